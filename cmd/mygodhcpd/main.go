@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/server"
+)
+
+type Flags struct {
+	ConfPath        string
+	ImportIscLeases string
+	ConvertIscConf  string
+	Pcap            string
+	PcapMacs        string
+	User            string
+	Group           string
+	Chroot          string
+	DryRun          bool
+}
+
+func parseFlags() Flags {
+	var flags Flags
+	flag.StringVar(&flags.ConfPath, "conf", "", "Path to configuration yaml file")
+	flag.StringVar(&flags.ImportIscLeases, "import-isc-leases", "", "Import active leases from an ISC dhcpd leases file, then exit")
+	flag.StringVar(&flags.ConvertIscConf, "convert-isc-conf", "", "Convert an ISC dhcpd.conf file to our yaml configuration on stdout, then exit")
+	flag.StringVar(&flags.Pcap, "pcap", "", "Debug: write all DHCP packets received and sent to this pcap file")
+	flag.StringVar(&flags.PcapMacs, "pcap-macs", "", "Debug: only capture packets for these comma separated mac addresses")
+	flag.StringVar(&flags.User, "user", "", "User to run as once port 67 is bound")
+	flag.StringVar(&flags.Group, "group", "", "Group to run as once port 67 is bound, if not the user's")
+	flag.StringVar(&flags.Chroot, "chroot", "", "Directory to chroot to once port 67 is bound. Paths in the configuration must be inside it")
+	flag.BoolVar(&flags.DryRun, "dry-run", false, "Handle requests and log what we'd answer, but never send anything")
+	flag.Parse()
+	return flags
+}
+
+func main() {
+	var err error
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(server.RunBench(os.Args[2:]))
+		case "client":
+			os.Exit(server.RunClient(os.Args[2:]))
+		case "replay":
+			os.Exit(server.RunReplay(os.Args[2:]))
+		case "snoop":
+			os.Exit(server.RunSnoop(os.Args[2:]))
+		}
+	}
+
+	flags := parseFlags()
+
+	if flags.ConvertIscConf != "" {
+		if err := server.ConvertIscConfFile(flags.ConvertIscConf, os.Stdout); err != nil {
+			log.Fatalf("Failed converting conf: %v", err)
+		}
+		return
+	}
+
+	confPath := flags.ConfPath
+
+	if confPath == "" {
+		log.Fatalf("Configuration file path not given")
+	}
+
+	conf, err := server.ParseConf(confPath)
+	if err != nil {
+		log.Fatalf("Failed parsing conf: %v", err)
+	}
+
+	app := server.NewApp()
+	if flags.DryRun {
+		app.SetDryRun(true)
+	}
+
+	err = app.InitConf(conf)
+
+	if err != nil {
+		log.Fatalf("Failed initializing: %v", err)
+	}
+
+	if flags.ImportIscLeases != "" {
+		count, err := app.ImportIscLeases(flags.ImportIscLeases)
+		if err != nil {
+			log.Fatalf("Failed importing leases: %v", err)
+		}
+		log.Printf("Imported %v leases from %v", count, flags.ImportIscLeases)
+		return
+	}
+
+	if flags.Pcap != "" {
+		var macs []dhcp4.HardwareAddr
+		if flags.PcapMacs != "" {
+			for _, s := range strings.Split(flags.PcapMacs, ",") {
+				mac, err := dhcp4.ParseHardwareAddr(s)
+				if err != nil {
+					log.Fatalf("Bad -pcap-macs: %v", err)
+				}
+				macs = append(macs, mac)
+			}
+		}
+		capture, err := server.NewCapture(flags.Pcap, macs)
+		if err != nil {
+			log.Fatalf("Failed opening capture file: %v", err)
+		}
+		app.SetCapture(capture)
+	}
+
+	// Socket activated, or bind port 67 ourselves
+	ln, err := server.SystemdListener()
+	if err != nil {
+		log.Fatalf("Failed listening: %v", err)
+	}
+	sockets := []*net.UDPConn{ln}
+	if ln == nil {
+		sockets, err = server.ListenDhcp(conf.Sockets)
+		if err != nil {
+			log.Fatalf("Failed listening: %v", err)
+		}
+	}
+	for _, socket := range sockets {
+		if err := server.SetupDhcpSocket(socket); err != nil {
+			log.Fatalf("Failed setting up socket: %v", err)
+		}
+	}
+
+	// Privileged ports and paths outside a chroot only work from here
+	var admin net.Listener
+	if conf.Admin != "" {
+		admin, err = app.ListenAdmin(conf.Admin)
+		if err != nil {
+			log.Fatalf("Failed listening for admin API: %v", err)
+		}
+	}
+	if err := server.OpenSdNotify(); err != nil {
+		log.Printf("Failed connecting to systemd: %v", err)
+	}
+
+	// Everything needing root is done
+	if err := server.DropPrivileges(flags.User, flags.Group, flags.Chroot); err != nil {
+		log.Fatalf("Failed dropping privileges: %v", err)
+	}
+
+	app.SetSockets(sockets...)
+	app.Start()
+
+	if admin != nil {
+		go func() {
+			log.Fatalf("Admin API failed: %v", app.ServeAdmin(admin))
+		}()
+	}
+
+	if err := server.SdNotify("READY=1"); err != nil {
+		log.Printf("Failed notifying systemd: %v", err)
+	}
+
+	// Finish what we're doing and write everything out before exiting. A
+	// second signal kills us straight away
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("Got %v; shutting down", sig)
+		server.SdNotify("STOPPING=1")
+		app.Stop()
+	}()
+
+	clean := true
+	if err := app.Serve(); err != nil {
+		log.Printf("Failed shutting down: %v", err)
+		clean = false
+	}
+	if err := app.Flush(); err != nil {
+		log.Printf("Failed writing out state: %v", err)
+		clean = false
+	}
+	if !clean {
+		os.Exit(1)
+	}
+	log.Printf("Shut down")
+}
@@ -0,0 +1,292 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+//
+// CableLabs client configuration (option 122, RFC 3495), which PacketCable
+// eMTAs, the telephony side of cable modems, need to find their
+// provisioning server and Kerberos realm. Its sub-options are encoded like
+// option 82's, with security ticket control from RFC 3594 and KDC servers
+// from RFC 3634
+//
+
+const (
+	CCC_PRIMARY_DHCP       = 1
+	CCC_SECONDARY_DHCP     = 2
+	CCC_PROVISIONING       = 3
+	CCC_AS_BACKOFF         = 4
+	CCC_AP_BACKOFF         = 5
+	CCC_KERBEROS_REALM     = 6
+	CCC_TGT                = 7
+	CCC_PROVISIONING_TIMER = 8
+	CCC_TICKET_CONTROL     = 9
+	CCC_KDC_SERVERS        = 10
+)
+
+// How the provisioning server is given, by the first byte of its sub-option
+const (
+	CCC_ENC_FQDN = 0
+	CCC_ENC_IP   = 1
+)
+
+// Kerberos exchange timeouts in milliseconds, and how often to retry
+type KerberosBackoff struct {
+	NominalTimeout uint32
+	MaximumTimeout uint32
+	MaxRetries     uint32
+}
+
+// Sub-options left empty or zero aren't sent, leaving the eMTA its defaults
+type CableLabsConfig struct {
+	PrimaryDhcp   net.IP
+	SecondaryDhcp net.IP
+
+	// Provisioning server, as an IP or a domain name
+	Provisioning string
+
+	AsBackoff *KerberosBackoff
+	ApBackoff *KerberosBackoff
+
+	KerberosRealm string
+
+	// Whether to get a ticket granting ticket
+	Tgt bool
+
+	// Minutes provisioning may take, with 0 for no limit
+	ProvisioningTimer *byte
+
+	// Bits of ticket control, each invalidating a kind of ticket
+	TicketControl uint16
+
+	KdcServers []net.IP
+}
+
+func (c *CableLabsConfig) Empty() bool {
+	return c == nil || len(c.Bytes()) == 0
+}
+
+func appendSubOption(b []byte, code byte, data []byte) []byte {
+	b = append(b, code, byte(len(data)))
+	return append(b, data...)
+}
+
+func (k *KerberosBackoff) Bytes() []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b, k.NominalTimeout)
+	binary.BigEndian.PutUint32(b[4:], k.MaximumTimeout)
+	binary.BigEndian.PutUint32(b[8:], k.MaxRetries)
+	return b
+}
+
+func (c *CableLabsConfig) Bytes() []byte {
+	var b []byte
+	if c.PrimaryDhcp != nil {
+		b = appendSubOption(b, CCC_PRIMARY_DHCP, c.PrimaryDhcp.To4())
+	}
+	if c.SecondaryDhcp != nil {
+		b = appendSubOption(b, CCC_SECONDARY_DHCP, c.SecondaryDhcp.To4())
+	}
+	if c.Provisioning != "" {
+		if ip := net.ParseIP(c.Provisioning).To4(); ip != nil {
+			b = appendSubOption(b, CCC_PROVISIONING, append([]byte{CCC_ENC_IP}, ip...))
+		} else {
+			b = appendSubOption(b, CCC_PROVISIONING, append([]byte{CCC_ENC_FQDN}, EncodeDomainNames([]string{c.Provisioning})...))
+		}
+	}
+	if c.AsBackoff != nil {
+		b = appendSubOption(b, CCC_AS_BACKOFF, c.AsBackoff.Bytes())
+	}
+	if c.ApBackoff != nil {
+		b = appendSubOption(b, CCC_AP_BACKOFF, c.ApBackoff.Bytes())
+	}
+	if c.KerberosRealm != "" {
+		b = appendSubOption(b, CCC_KERBEROS_REALM, EncodeDomainNames([]string{c.KerberosRealm}))
+	}
+	if c.Tgt {
+		b = appendSubOption(b, CCC_TGT, []byte{1})
+	}
+	if c.ProvisioningTimer != nil {
+		b = appendSubOption(b, CCC_PROVISIONING_TIMER, []byte{*c.ProvisioningTimer})
+	}
+	if c.TicketControl != 0 {
+		b = appendSubOption(b, CCC_TICKET_CONTROL, []byte{byte(c.TicketControl >> 8), byte(c.TicketControl)})
+	}
+	if len(c.KdcServers) != 0 {
+		var ips []byte
+		for _, ip := range c.KdcServers {
+			ips = append(ips, ip.To4()...)
+		}
+		b = appendSubOption(b, CCC_KDC_SERVERS, ips)
+	}
+	return b
+}
+
+// Check what configuration gave makes sense before it's encoded
+func (c *CableLabsConfig) Validate() error {
+	for _, ip := range append([]net.IP{c.PrimaryDhcp, c.SecondaryDhcp}, c.KdcServers...) {
+		if ip != nil && ip.To4() == nil {
+			return fmt.Errorf("CableLabs server %v is not v4", ip)
+		}
+	}
+	if c.Provisioning != "" {
+		if ip := net.ParseIP(c.Provisioning); ip != nil {
+			if ip.To4() == nil {
+				return fmt.Errorf("CableLabs provisioning server %v is not v4", c.Provisioning)
+			}
+		} else if err := validDomainName(c.Provisioning); err != nil {
+			return err
+		}
+	}
+	if c.KerberosRealm != "" {
+		if err := validDomainName(c.KerberosRealm); err != nil {
+			return err
+		}
+	}
+	if len(c.KdcServers) > 63 {
+		return errors.New("Too many KDC servers")
+	}
+	return nil
+}
+
+func decodeKerberosBackoff(data []byte) (*KerberosBackoff, error) {
+	if len(data) != 12 {
+		return nil, errors.New("Kerberos backoff sub-option is not 12 bytes")
+	}
+	return &KerberosBackoff{
+		NominalTimeout: binary.BigEndian.Uint32(data),
+		MaximumTimeout: binary.BigEndian.Uint32(data[4:]),
+		MaxRetries:     binary.BigEndian.Uint32(data[8:]),
+	}, nil
+}
+
+func decodeSingleDomainName(data []byte) (string, error) {
+	names, err := DecodeDomainNames(data)
+	if err != nil {
+		return "", err
+	}
+	if len(names) != 1 {
+		return "", errors.New("Expected a single domain name")
+	}
+	return names[0], nil
+}
+
+// Parse option 122, as it would come from another server. Sub-options we
+// don't know are skipped
+func DecodeCableLabsConfig(data []byte) (*CableLabsConfig, error) {
+	c := &CableLabsConfig{}
+	for len(data) > 0 {
+		if len(data) < 2 || 2+int(data[1]) > len(data) {
+			return nil, errors.New("Truncated CableLabs sub-option")
+		}
+		code, value := data[0], data[2:2+int(data[1])]
+		data = data[2+len(value):]
+
+		var err error
+		switch code {
+		case CCC_PRIMARY_DHCP, CCC_SECONDARY_DHCP:
+			if len(value) != 4 {
+				return nil, fmt.Errorf("CableLabs sub-option %v is not an IP", code)
+			}
+			if code == CCC_PRIMARY_DHCP {
+				c.PrimaryDhcp = net.IP(append([]byte(nil), value...))
+			} else {
+				c.SecondaryDhcp = net.IP(append([]byte(nil), value...))
+			}
+		case CCC_PROVISIONING:
+			if len(value) < 2 {
+				return nil, errors.New("CableLabs provisioning server too short")
+			}
+			switch value[0] {
+			case CCC_ENC_FQDN:
+				c.Provisioning, err = decodeSingleDomainName(value[1:])
+			case CCC_ENC_IP:
+				if len(value) != 5 {
+					return nil, errors.New("CableLabs provisioning server is not an IP")
+				}
+				c.Provisioning = net.IP(value[1:]).String()
+			default:
+				return nil, fmt.Errorf("Unknown CableLabs provisioning server encoding %v", value[0])
+			}
+		case CCC_AS_BACKOFF:
+			c.AsBackoff, err = decodeKerberosBackoff(value)
+		case CCC_AP_BACKOFF:
+			c.ApBackoff, err = decodeKerberosBackoff(value)
+		case CCC_KERBEROS_REALM:
+			c.KerberosRealm, err = decodeSingleDomainName(value)
+		case CCC_TGT, CCC_PROVISIONING_TIMER:
+			if len(value) != 1 {
+				return nil, fmt.Errorf("CableLabs sub-option %v is not 1 byte", code)
+			}
+			if code == CCC_TGT {
+				c.Tgt = value[0] == 1
+			} else {
+				timer := value[0]
+				c.ProvisioningTimer = &timer
+			}
+		case CCC_TICKET_CONTROL:
+			if len(value) != 2 {
+				return nil, errors.New("CableLabs ticket control is not 2 bytes")
+			}
+			c.TicketControl = binary.BigEndian.Uint16(value)
+		case CCC_KDC_SERVERS:
+			if len(value) == 0 || len(value)%4 != 0 {
+				return nil, errors.New("KDC server IPs are not a multiple of 4 bytes")
+			}
+			for i := 0; i < len(value); i += 4 {
+				c.KdcServers = append(c.KdcServers, net.IP(append([]byte(nil), value[i:i+4]...)))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Sub-options as name=value pairs for logs
+func (c *CableLabsConfig) String() string {
+	var parts []string
+	if c.PrimaryDhcp != nil {
+		parts = append(parts, "primary dhcp="+c.PrimaryDhcp.String())
+	}
+	if c.SecondaryDhcp != nil {
+		parts = append(parts, "secondary dhcp="+c.SecondaryDhcp.String())
+	}
+	if c.Provisioning != "" {
+		parts = append(parts, "provisioning="+c.Provisioning)
+	}
+	for _, backoff := range []struct {
+		name    string
+		backoff *KerberosBackoff
+	}{{"as backoff", c.AsBackoff}, {"ap backoff", c.ApBackoff}} {
+		if b := backoff.backoff; b != nil {
+			parts = append(parts, fmt.Sprintf("%v=%v/%v/%v", backoff.name, b.NominalTimeout, b.MaximumTimeout, b.MaxRetries))
+		}
+	}
+	if c.KerberosRealm != "" {
+		parts = append(parts, "realm="+c.KerberosRealm)
+	}
+	if c.Tgt {
+		parts = append(parts, "tgt")
+	}
+	if c.ProvisioningTimer != nil {
+		parts = append(parts, fmt.Sprintf("provisioning timer=%v", *c.ProvisioningTimer))
+	}
+	if c.TicketControl != 0 {
+		parts = append(parts, fmt.Sprintf("ticket control=%#04x", c.TicketControl))
+	}
+	if len(c.KdcServers) != 0 {
+		var ips []string
+		for _, ip := range c.KdcServers {
+			ips = append(ips, ip.String())
+		}
+		parts = append(parts, "kdc="+strings.Join(ips, " "))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,74 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+)
+
+func TestCableLabsConfig(t *testing.T) {
+	timer := byte(10)
+	ccc := &CableLabsConfig{
+		PrimaryDhcp:       net.IP{10, 0, 0, 1},
+		Provisioning:      "prov.example",
+		AsBackoff:         &KerberosBackoff{100, 1000, 3},
+		KerberosRealm:     "BASIC.1",
+		Tgt:               true,
+		ProvisioningTimer: &timer,
+		TicketControl:     0x0001,
+		KdcServers:        []net.IP{{10, 0, 0, 2}, {10, 0, 0, 3}},
+	}
+	require.Nil(t, ccc.Validate())
+	data := ccc.Bytes()
+	require.Equal(t, []byte{
+		CCC_PRIMARY_DHCP, 4, 10, 0, 0, 1,
+		CCC_PROVISIONING, 15, CCC_ENC_FQDN, 4, 'p', 'r', 'o', 'v', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0,
+		CCC_AS_BACKOFF, 12, 0, 0, 0, 100, 0, 0, 3, 232, 0, 0, 0, 3,
+		CCC_KERBEROS_REALM, 9, 5, 'B', 'A', 'S', 'I', 'C', 1, '1', 0,
+		CCC_TGT, 1, 1,
+		CCC_PROVISIONING_TIMER, 1, 10,
+		CCC_TICKET_CONTROL, 2, 0, 1,
+		CCC_KDC_SERVERS, 8, 10, 0, 0, 2, 10, 0, 0, 3,
+	}, data)
+
+	decoded, err := DecodeCableLabsConfig(data)
+	require.Nil(t, err)
+	require.Equal(t, ccc, decoded)
+	require.Equal(t, "primary dhcp=10.0.0.1, provisioning=prov.example, as backoff=100/1000/3, realm=BASIC.1, tgt, "+
+		"provisioning timer=10, ticket control=0x0001, kdc=10.0.0.2 10.0.0.3", FormatOption(OPTION_CCC, data))
+
+	// A provisioning server given as an IP, and a timer of 0 for no limit,
+	// which is sent unlike other zero values
+	timer = 0
+	ccc = &CableLabsConfig{Provisioning: "10.0.0.9", ProvisioningTimer: &timer}
+	require.Equal(t, []byte{CCC_PROVISIONING, 5, CCC_ENC_IP, 10, 0, 0, 9, CCC_PROVISIONING_TIMER, 1, 0}, ccc.Bytes())
+	require.True(t, (&CableLabsConfig{}).Empty())
+	require.True(t, (*CableLabsConfig)(nil).Empty())
+
+	// Unknown sub-options are skipped
+	decoded, err = DecodeCableLabsConfig([]byte{99, 1, 0, CCC_SECONDARY_DHCP, 4, 10, 0, 0, 4})
+	require.Nil(t, err)
+	require.Equal(t, net.IP{10, 0, 0, 4}, decoded.SecondaryDhcp)
+
+	for _, bad := range [][]byte{
+		{CCC_PRIMARY_DHCP, 4, 10, 0},
+		{CCC_PRIMARY_DHCP, 3, 10, 0, 0},
+		{CCC_PROVISIONING, 5, 2, 10, 0, 0, 9},
+		{CCC_AS_BACKOFF, 4, 0, 0, 0, 1},
+		{CCC_TGT, 0},
+		{CCC_KDC_SERVERS, 3, 10, 0, 0},
+	} {
+		_, err = DecodeCableLabsConfig(bad)
+		require.NotNil(t, err)
+	}
+
+	for _, bad := range []*CableLabsConfig{
+		{PrimaryDhcp: net.ParseIP("fe80::1")},
+		{Provisioning: "fe80::1"},
+		{Provisioning: "bad..example"},
+		{KerberosRealm: "."},
+	} {
+		require.NotNil(t, bad.Validate())
+	}
+}
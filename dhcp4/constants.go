@@ -0,0 +1,141 @@
+package dhcp4
+
+//
+// DHCP Op types
+//
+const (
+	BOOT_REQUEST byte = 1
+	BOOT_REPLY   byte = 2
+)
+
+// Flag a client sets when it can't receive unicast replies yet
+const FLAG_BROADCAST uint16 = 0x8000
+
+// Shortest wait the IPv6-only preferred option (108, RFC 8925) may give, in
+// seconds
+const MIN_V6ONLY_WAIT = 300
+
+//
+// Hardware types (htype), from the IANA ARP parameters registry
+//
+const (
+	HTYPE_ETHERNET   byte = 1
+	HTYPE_IEEE802    byte = 6 // Token ring
+	HTYPE_FDDI       byte = 8
+	HTYPE_IEEE1394   byte = 24
+	HTYPE_INFINIBAND byte = 32
+)
+
+//
+// DHCP Message types
+//
+const (
+	DHCPDISCOVER   byte = 1 // Implemented
+	DHCPOFFER      byte = 2 // Implemented
+	DHCPREQUEST    byte = 3 // Implemented
+	DHCPDECLINE    byte = 4
+	DHCPACK        byte = 5 // Implemented
+	DHCPNAK        byte = 6 // Implemented
+	DHCPRELEASE    byte = 7 // Implemented
+	DHCPINFORM     byte = 8
+	DHCPFORCERENEW byte = 9 // Implemented
+
+	// RFC 4388 leasequery
+	DHCPLEASEQUERY      byte = 10 // Implemented
+	DHCPLEASEUNASSIGNED byte = 11 // Implemented
+	DHCPLEASEUNKNOWN    byte = 12 // Implemented
+	DHCPLEASEACTIVE     byte = 13 // Implemented
+
+	// RFC 6926 bulk and RFC 7724 active leasequery, over TCP
+	DHCPBULKLEASEQUERY   byte = 14 // Implemented
+	DHCPLEASEQUERYDONE   byte = 15 // Implemented
+	DHCPACTIVELEASEQUERY byte = 16 // Implemented
+	DHCPLEASEQUERYSTATUS byte = 17 // Implemented
+)
+
+var OpNames = map[byte]string{
+	DHCPDISCOVER:        "DHCPDISCOVER",
+	DHCPOFFER:           "DHCPOFFER",
+	DHCPREQUEST:         "DHCPREQUEST",
+	DHCPDECLINE:         "DHCPDECLINE",
+	DHCPACK:             "DHCPACK",
+	DHCPNAK:             "DHCPNAK",
+	DHCPRELEASE:         "DHCPRELEASE",
+	DHCPINFORM:          "DHCPINFORM",
+	DHCPFORCERENEW:      "DHCPFORCERENEW",
+	DHCPLEASEQUERY:      "DHCPLEASEQUERY",
+	DHCPLEASEUNASSIGNED: "DHCPLEASEUNASSIGNED",
+	DHCPLEASEUNKNOWN:    "DHCPLEASEUNKNOWN",
+	DHCPLEASEACTIVE:     "DHCPLEASEACTIVE",
+
+	DHCPBULKLEASEQUERY:   "DHCPBULKLEASEQUERY",
+	DHCPLEASEQUERYDONE:   "DHCPLEASEQUERYDONE",
+	DHCPACTIVELEASEQUERY: "DHCPACTIVELEASEQUERY",
+	DHCPLEASEQUERYSTATUS: "DHCPLEASEQUERYSTATUS",
+}
+
+//
+// DHCP Option numbers. List partly taken from udhcpc
+//
+
+const (
+	OPTION_PADDING       = 0
+	OPTION_SUBNET        = 1
+	OPTION_TIME_OFFSET   = 2
+	OPTION_ROUTER        = 3
+	OPTION_TIME_SERVER   = 4
+	OPTION_NAME_SERVER   = 5
+	OPTION_DNS_SERVER    = 6
+	OPTION_LOG_SERVER    = 7
+	OPTION_COOKIE_SERVER = 8
+	OPTION_LPR_SERVER    = 9
+	OPTION_HOST_NAME     = 12
+	OPTION_BOOT_SIZE     = 13
+	OPTION_DOMAIN_NAME   = 15
+	OPTION_SWAP_SERVER   = 16
+	OPTION_ROOT_PATH     = 17
+	OPTION_IP_TTL        = 23
+	OPTION_MTU           = 26
+	OPTION_BROADCAST     = 28
+	OPTION_NTP_SERVER    = 42
+	OPTION_VENDOR_INFO   = 43
+	OPTION_WINS_SERVER   = 44
+	OPTION_REQUESTED_IP  = 50
+	OPTION_LEASE_TIME    = 51
+	OPTION_OPTION_OVER   = 52
+	OPTION_MESSAGE_TYPE  = 53
+	OPTION_SERVER_ID     = 54
+	OPTION_PARAM_REQ     = 55
+	OPTION_MESSAGE       = 56
+	OPTION_MAX_SIZE      = 57
+	OPTION_T1            = 58
+	OPTION_T2            = 59
+	OPTION_VENDOR        = 60
+	OPTION_CLIENT_ID     = 61
+	OPTION_TFTP_NAME     = 66
+	OPTION_BOOT_FILE     = 67
+	OPTION_USER_CLASS    = 77
+	OPTION_RAPID_COMMIT  = 80
+	OPTION_RELAY_AGENT   = 82
+	OPTION_AUTH          = 90
+	OPTION_LAST_TXN_TIME = 91
+	OPTION_ASSOCIATED_IP = 92
+	OPTION_V6_ONLY       = 108
+	OPTION_SIP_SERVERS   = 120
+	OPTION_CLASSLESS_RT  = 121
+	OPTION_CCC           = 122
+	OPTION_VI_CLASS      = 124
+	OPTION_VI_INFO       = 125
+	OPTION_TFTP_SERVERS  = 150
+	OPTION_STATUS_CODE   = 151
+	OPTION_BASE_TIME     = 152
+	OPTION_STATE_START   = 153
+	OPTION_QUERY_START   = 154
+	OPTION_QUERY_END     = 155
+	OPTION_DHCP_STATE    = 156
+	OPTION_MUD_URL       = 161
+	OPTION_AVAYA         = 242
+	OPTION_MS_CLASSLESS  = 249
+	OPTION_WPAD          = 252
+	OPTION_SENTINEL      = 255
+)
@@ -1,4 +1,4 @@
-package main
+package dhcp4
 
 import (
 	"encoding/binary"
@@ -21,7 +21,13 @@ func long2bytes(data uint32) []byte {
 	return b
 }
 
-func calcBroadcast(network, netmask net.IP) net.IP {
+func short2bytes(data uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, data)
+	return b
+}
+
+func CalcBroadcast(network, netmask net.IP) net.IP {
 	broadcast := ip2long(network) | ^ip2long(netmask)
 	return long2ip(broadcast)
 }
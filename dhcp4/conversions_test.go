@@ -1,4 +1,4 @@
-package main
+package dhcp4
 
 import (
 	"github.com/stretchr/testify/require"
@@ -19,7 +19,12 @@ func TestIpIntConversions(t *testing.T) {
 	require.Equal(t, uint32(167772162), IpToFixedV4(net.ParseIP("10.0.0.2")).Long())
 }
 
+func TestShortToBytes(t *testing.T) {
+	require.Equal(t, []byte{0x05, 0xdc}, short2bytes(1500))
+	require.Equal(t, []byte{0x23, 0x28}, short2bytes(9000))
+}
+
 func TestCalcBroadcast(t *testing.T) {
-	require.Equal(t, net.ParseIP("10.0.0.255").To4(), calcBroadcast(net.ParseIP("10.0.0.0"), net.ParseIP("255.255.255.0")).To4())
-	require.Equal(t, net.ParseIP("172.17.0.255").To4(), calcBroadcast(net.ParseIP("172.17.0.0"), net.ParseIP("255.255.255.0")).To4())
+	require.Equal(t, net.ParseIP("10.0.0.255").To4(), CalcBroadcast(net.ParseIP("10.0.0.0"), net.ParseIP("255.255.255.0")).To4())
+	require.Equal(t, net.ParseIP("172.17.0.255").To4(), CalcBroadcast(net.ParseIP("172.17.0.0"), net.ParseIP("255.255.255.0")).To4())
 }
@@ -0,0 +1,179 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//
+// Human readable names and values of options, for tools showing what a
+// server sent
+//
+
+var OptionNames = map[byte]string{
+	OPTION_SUBNET:        "subnet mask",
+	OPTION_TIME_OFFSET:   "time offset",
+	OPTION_ROUTER:        "router",
+	OPTION_TIME_SERVER:   "time server",
+	OPTION_NAME_SERVER:   "name server",
+	OPTION_DNS_SERVER:    "dns server",
+	OPTION_LOG_SERVER:    "log server",
+	OPTION_COOKIE_SERVER: "cookie server",
+	OPTION_LPR_SERVER:    "lpr server",
+	OPTION_HOST_NAME:     "host name",
+	OPTION_BOOT_SIZE:     "boot file size",
+	OPTION_DOMAIN_NAME:   "domain name",
+	OPTION_SWAP_SERVER:   "swap server",
+	OPTION_ROOT_PATH:     "root path",
+	OPTION_IP_TTL:        "ip ttl",
+	OPTION_MTU:           "mtu",
+	OPTION_BROADCAST:     "broadcast address",
+	OPTION_NTP_SERVER:    "ntp server",
+	OPTION_VENDOR_INFO:   "vendor specific information",
+	OPTION_WINS_SERVER:   "wins server",
+	OPTION_REQUESTED_IP:  "requested ip",
+	OPTION_LEASE_TIME:    "lease time",
+	OPTION_OPTION_OVER:   "option overload",
+	OPTION_MESSAGE_TYPE:  "message type",
+	OPTION_SERVER_ID:     "server id",
+	OPTION_PARAM_REQ:     "parameter request list",
+	OPTION_MESSAGE:       "message",
+	OPTION_MAX_SIZE:      "max message size",
+	OPTION_T1:            "renewal time",
+	OPTION_T2:            "rebinding time",
+	OPTION_VENDOR:        "vendor class",
+	OPTION_CLIENT_ID:     "client id",
+	OPTION_TFTP_NAME:     "tftp server name",
+	OPTION_BOOT_FILE:     "boot file name",
+	OPTION_USER_CLASS:    "user class",
+	OPTION_RAPID_COMMIT:  "rapid commit",
+	OPTION_RELAY_AGENT:   "relay agent information",
+	OPTION_AUTH:          "authentication",
+	OPTION_V6_ONLY:       "ipv6-only preferred",
+	OPTION_SIP_SERVERS:   "sip servers",
+	OPTION_CLASSLESS_RT:  "classless static routes",
+	OPTION_CCC:           "cablelabs client configuration",
+	OPTION_VI_CLASS:      "vendor-identifying vendor class",
+	OPTION_VI_INFO:       "vendor-identifying vendor specific information",
+	OPTION_TFTP_SERVERS:  "tftp servers",
+	OPTION_MUD_URL:       "mud url",
+	OPTION_AVAYA:         "avaya ip phone",
+	OPTION_MS_CLASSLESS:  "microsoft classless static routes",
+	OPTION_WPAD:          "wpad",
+}
+
+// Name of an option, or its code if we don't know it
+func OptionName(code byte) string {
+	if name, ok := OptionNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("option %v", code)
+}
+
+// Value of an option formatted according to its type, or as hex if we don't
+// know it or it's malformed
+func FormatOption(code byte, data []byte) string {
+	switch code {
+	case OPTION_SUBNET, OPTION_ROUTER, OPTION_TIME_SERVER, OPTION_NAME_SERVER, OPTION_DNS_SERVER,
+		OPTION_LOG_SERVER, OPTION_COOKIE_SERVER, OPTION_LPR_SERVER, OPTION_SWAP_SERVER, OPTION_BROADCAST,
+		OPTION_NTP_SERVER, OPTION_WINS_SERVER, OPTION_REQUESTED_IP, OPTION_SERVER_ID, OPTION_TFTP_SERVERS:
+		if len(data) > 0 && len(data)%4 == 0 {
+			var ips []string
+			for i := 0; i < len(data); i += 4 {
+				ip, _ := BytesToFixedV4(data[i : i+4])
+				ips = append(ips, ip.String())
+			}
+			return strings.Join(ips, ", ")
+		}
+
+	case OPTION_HOST_NAME, OPTION_DOMAIN_NAME, OPTION_ROOT_PATH, OPTION_MESSAGE, OPTION_VENDOR, OPTION_TFTP_NAME, OPTION_BOOT_FILE,
+		OPTION_WPAD, OPTION_AVAYA, OPTION_MUD_URL:
+		return string(data)
+
+	case OPTION_LEASE_TIME, OPTION_T1, OPTION_T2, OPTION_V6_ONLY:
+		if len(data) == 4 {
+			return (time.Duration(binary.BigEndian.Uint32(data)) * time.Second).String()
+		}
+
+	case OPTION_TIME_OFFSET:
+		if len(data) == 4 {
+			return (time.Duration(int32(binary.BigEndian.Uint32(data))) * time.Second).String()
+		}
+
+	case OPTION_BOOT_SIZE, OPTION_MTU, OPTION_MAX_SIZE:
+		if len(data) == 2 {
+			return fmt.Sprint(binary.BigEndian.Uint16(data))
+		}
+
+	case OPTION_IP_TTL, OPTION_OPTION_OVER:
+		if len(data) == 1 {
+			return fmt.Sprint(data[0])
+		}
+
+	case OPTION_MESSAGE_TYPE:
+		if len(data) == 1 {
+			if name, ok := OpNames[data[0]]; ok {
+				return name
+			}
+		}
+
+	case OPTION_SIP_SERVERS:
+		if sip, err := DecodeSipServers(data); err == nil {
+			servers := sip.Domains
+			for _, ip := range sip.IPs {
+				servers = append(servers, ip.String())
+			}
+			return strings.Join(servers, ", ")
+		}
+
+	case OPTION_CLIENT_ID:
+		if id, ok := ParseNodeClientId(data); ok {
+			return id.String()
+		}
+
+	case OPTION_USER_CLASS:
+		return strings.Join(ParseUserClasses(data), ", ")
+
+	case OPTION_CCC:
+		if ccc, err := DecodeCableLabsConfig(data); err == nil {
+			return ccc.String()
+		}
+
+	case OPTION_VI_CLASS:
+		if classes, err := ParseVendorClasses(data); err == nil && len(classes) > 0 {
+			var parts []string
+			for _, class := range classes {
+				var items []string
+				for _, item := range class.Data {
+					items = append(items, string(item))
+				}
+				parts = append(parts, fmt.Sprintf("%v: %v", class.Enterprise, strings.Join(items, ", ")))
+			}
+			return strings.Join(parts, "; ")
+		}
+
+	case OPTION_VI_INFO:
+		if vendors, err := ParseVendorOptions(data); err == nil && len(vendors) > 0 {
+			var parts []string
+			for _, vendor := range vendors {
+				var items []string
+				for _, option := range vendor.Options {
+					items = append(items, fmt.Sprintf("%v=%v", option.Code, hex.EncodeToString(option.Data)))
+				}
+				parts = append(parts, fmt.Sprintf("%v: %v", vendor.Enterprise, strings.Join(items, ", ")))
+			}
+			return strings.Join(parts, "; ")
+		}
+
+	case OPTION_PARAM_REQ:
+		var codes []string
+		for _, c := range data {
+			codes = append(codes, fmt.Sprint(c))
+		}
+		return strings.Join(codes, ", ")
+	}
+	return hex.EncodeToString(data)
+}
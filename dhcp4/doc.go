@@ -0,0 +1,5 @@
+// Package dhcp4 parses and encodes DHCPv4 messages: the fixed header,
+// options, and helpers for the values commonly found in them. It has no
+// dependencies on the rest of the server, so can be used on its own, e.g. by
+// clients or tools inspecting captured packets.
+package dhcp4
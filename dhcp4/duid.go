@@ -0,0 +1,104 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+//
+// Node-specific client identifiers (RFC 4361), which dual-stack clients
+// send in option 61 so that they're known by the same DUID over DHCPv4 as
+// over DHCPv6: type 255, then the IAID of the interface, then the DUID
+// (RFC 8415)
+//
+
+// Client identifier type for RFC 4361 identifiers
+const CLIENT_ID_NODE = 255
+
+// Kinds of DUID
+const (
+	DUID_LLT  = 1 // link-layer address plus time
+	DUID_EN   = 2 // enterprise number and identifier
+	DUID_LL   = 3 // link-layer address
+	DUID_UUID = 4
+)
+
+type DUID []byte
+
+type NodeClientId struct {
+	IAID uint32
+	DUID DUID
+}
+
+// The IAID and DUID from a client identifier, if it's an RFC 4361 one
+func ParseNodeClientId(id []byte) (NodeClientId, bool) {
+	// At least a DUID type and something after it
+	if len(id) < 1+4+3 || id[0] != CLIENT_ID_NODE {
+		return NodeClientId{}, false
+	}
+	return NodeClientId{binary.BigEndian.Uint32(id[1:5]), DUID(append([]byte(nil), id[5:]...))}, true
+}
+
+func (n NodeClientId) Bytes() []byte {
+	b := []byte{CLIENT_ID_NODE, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], n.IAID)
+	return append(b, n.DUID...)
+}
+
+func (n NodeClientId) String() string {
+	return fmt.Sprintf("iaid %v duid %v", n.IAID, n.DUID.String())
+}
+
+// A DUID as DHCPv6 servers write them, colon separated hex
+func ParseDUID(s string) (DUID, error) {
+	duid, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(duid) < 3 || len(duid) > 130 {
+		return nil, fmt.Errorf("Invalid DUID '%v'", s)
+	}
+	return duid, nil
+}
+
+func (d DUID) Type() uint16 {
+	if len(d) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(d)
+}
+
+// The link-layer address in a DUID-LLT or DUID-LL, which is the mac of one
+// of the client's interfaces
+func (d DUID) HardwareAddr() (HardwareAddr, bool) {
+	var addr []byte
+	switch d.Type() {
+	case DUID_LLT:
+		if len(d) < 8 {
+			return HardwareAddr{}, false
+		}
+		addr = d[8:]
+	case DUID_LL:
+		if len(d) < 4 {
+			return HardwareAddr{}, false
+		}
+		addr = d[4:]
+	default:
+		return HardwareAddr{}, false
+	}
+	htype := binary.BigEndian.Uint16(d[2:4])
+	if htype == 0 || htype > 255 || len(addr) == 0 || len(addr) > MAX_HLEN {
+		return HardwareAddr{}, false
+	}
+	if byte(htype) == HTYPE_ETHERNET && len(addr) != 6 {
+		return HardwareAddr{}, false
+	}
+	return NewHardwareAddr(byte(htype), addr), true
+}
+
+func (d DUID) String() string {
+	parts := make([]string, len(d))
+	for i, b := range d {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
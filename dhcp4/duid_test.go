@@ -0,0 +1,49 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestNodeClientId(t *testing.T) {
+	// DUID-LLT of an ethernet interface
+	duid, err := ParseDUID("00:01:00:01:28:d6:d3:14:00:1c:42:b4:6e:1d")
+	require.Nil(t, err)
+	require.Equal(t, uint16(DUID_LLT), duid.Type())
+	hw, ok := duid.HardwareAddr()
+	require.True(t, ok)
+	require.Equal(t, MacAddress{0x00, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware(), hw)
+	require.Equal(t, "00:01:00:01:28:d6:d3:14:00:1c:42:b4:6e:1d", duid.String())
+
+	id := NodeClientId{IAID: 7, DUID: duid}
+	data := id.Bytes()
+	require.Equal(t, []byte{CLIENT_ID_NODE, 0, 0, 0, 7, 0, 1, 0, 1}, data[:9])
+	parsed, ok := ParseNodeClientId(data)
+	require.True(t, ok)
+	require.Equal(t, id, parsed)
+	require.Equal(t, "iaid 7 duid 00:01:00:01:28:d6:d3:14:00:1c:42:b4:6e:1d", FormatOption(OPTION_CLIENT_ID, data))
+
+	// DUID-LL, and a DUID-EN with no link-layer address
+	duid, err = ParseDUID("000300010a0b0c0d0e0f")
+	require.Nil(t, err)
+	hw, ok = duid.HardwareAddr()
+	require.True(t, ok)
+	require.Equal(t, MacAddress{0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}.Hardware(), hw)
+	duid, err = ParseDUID("00:02:00:00:00:09:01:02")
+	require.Nil(t, err)
+	_, ok = duid.HardwareAddr()
+	require.False(t, ok)
+
+	// Ordinary client identifiers aren't
+	_, ok = ParseNodeClientId([]byte{HTYPE_ETHERNET, 0, 0, 0, 0, 0, 1})
+	require.False(t, ok)
+	_, ok = ParseNodeClientId([]byte{CLIENT_ID_NODE, 0, 0, 0, 7, 0})
+	require.False(t, ok)
+	for _, bad := range []string{"", "00:01", "zz:01:02"} {
+		_, err = ParseDUID(bad)
+		require.NotNil(t, err)
+	}
+	_, ok = DUID{0, 3, 0, 1, 1, 2}.HardwareAddr()
+	require.False(t, ok)
+}
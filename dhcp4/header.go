@@ -0,0 +1,167 @@
+//
+// Helpers for parsing the DHCP header payload
+//
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//
+// Header of a DHCP payload
+//
+
+var Magic uint32 = 0x63825363
+
+type MessageHeader struct {
+	Op          byte
+	HType       byte
+	HLen        byte
+	Hops        byte
+	Identifier  uint32
+	Secs        uint16
+	Flags       uint16
+	ClientAddr  FixedV4
+	YourAddr    FixedV4
+	ServerAddr  FixedV4
+	GatewayAddr FixedV4
+	Mac         MacAddress // First 6 bytes of chaddr, the whole of it for ethernet
+	MacPadding  [10]byte   // and the rest, for longer hardware addresses
+	Hostname    [64]byte
+	Filename    [128]byte
+	Magic       uint32
+}
+
+// Encoded size, including the magic cookie
+const HEADER_SIZE = 240
+
+func (h *MessageHeader) Encode(buf *bytes.Buffer) error {
+	// Set constant boilerplate. Messages are for ethernet unless said
+	// otherwise
+	h.Magic = Magic
+	if h.HType == 0 {
+		h.HType = HTYPE_ETHERNET
+		h.HLen = 6
+	}
+
+	// By hand rather than with binary.Write, which allocates
+	var b [HEADER_SIZE]byte
+	h.marshal(b[:])
+	_, err := buf.Write(b[:])
+	return err
+}
+
+func (h *MessageHeader) marshal(b []byte) {
+	b[0] = h.Op
+	b[1] = h.HType
+	b[2] = h.HLen
+	b[3] = h.Hops
+	binary.BigEndian.PutUint32(b[4:], h.Identifier)
+	binary.BigEndian.PutUint16(b[8:], h.Secs)
+	binary.BigEndian.PutUint16(b[10:], h.Flags)
+	binary.BigEndian.PutUint32(b[12:], uint32(h.ClientAddr))
+	binary.BigEndian.PutUint32(b[16:], uint32(h.YourAddr))
+	binary.BigEndian.PutUint32(b[20:], uint32(h.ServerAddr))
+	binary.BigEndian.PutUint32(b[24:], uint32(h.GatewayAddr))
+	copy(b[28:34], h.Mac[:])
+	copy(b[34:44], h.MacPadding[:])
+	copy(b[44:108], h.Hostname[:])
+	copy(b[108:236], h.Filename[:])
+	binary.BigEndian.PutUint32(b[236:], h.Magic)
+}
+
+func (h *MessageHeader) unmarshal(b []byte) {
+	h.Op = b[0]
+	h.HType = b[1]
+	h.HLen = b[2]
+	h.Hops = b[3]
+	h.Identifier = binary.BigEndian.Uint32(b[4:])
+	h.Secs = binary.BigEndian.Uint16(b[8:])
+	h.Flags = binary.BigEndian.Uint16(b[10:])
+	h.ClientAddr = FixedV4(binary.BigEndian.Uint32(b[12:]))
+	h.YourAddr = FixedV4(binary.BigEndian.Uint32(b[16:]))
+	h.ServerAddr = FixedV4(binary.BigEndian.Uint32(b[20:]))
+	h.GatewayAddr = FixedV4(binary.BigEndian.Uint32(b[24:]))
+	copy(h.Mac[:], b[28:34])
+	copy(h.MacPadding[:], b[34:44])
+	copy(h.Hostname[:], b[44:108])
+	copy(h.Filename[:], b[108:236])
+	h.Magic = binary.BigEndian.Uint32(b[236:])
+}
+
+func ParseMessageHeader(reader *bytes.Reader) (*MessageHeader, error) {
+	var b [HEADER_SIZE]byte
+	if _, err := io.ReadFull(reader, b[:]); err != nil {
+		return nil, fmt.Errorf("Failed unpacking header into struct: %v", err)
+	}
+
+	header := &MessageHeader{}
+	if err := decodeMessageHeader(b[:], header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Fill in header from the start of buf
+func decodeMessageHeader(buf []byte, header *MessageHeader) error {
+	if len(buf) < HEADER_SIZE {
+		return fmt.Errorf("Failed unpacking header into struct: only %v bytes", len(buf))
+	}
+	header.unmarshal(buf)
+
+	// Verify sanity. Any hardware type is fine, as long as its address
+	// fits in chaddr
+	if header.HType == 0 {
+		return fmt.Errorf("Hardware type 0 is reserved")
+	}
+	if header.HLen > MAX_HLEN {
+		return fmt.Errorf("Hardware address length %v is over %v", header.HLen, MAX_HLEN)
+	}
+	// Plain BOOTP clients may leave the vendor area empty
+	if header.Magic != Magic && header.Magic != 0 {
+		return fmt.Errorf("Incorrect option magic")
+	}
+
+	return nil
+}
+
+// Size of chaddr, the most any hardware address can be
+const MAX_HLEN = 16
+
+// Client hardware address, as long as hlen says it is
+func (h *MessageHeader) Chaddr() []byte {
+	var chaddr [MAX_HLEN]byte
+	copy(chaddr[:], h.Mac[:])
+	copy(chaddr[6:], h.MacPadding[:])
+	if h.HLen > MAX_HLEN {
+		return chaddr[:]
+	}
+	return chaddr[:h.HLen]
+}
+
+// Client hardware address along with its type
+func (h *MessageHeader) Hardware() HardwareAddr {
+	if h.HType == 0 {
+		return h.Mac.Hardware()
+	}
+	return NewHardwareAddr(h.HType, h.Chaddr())
+}
+
+// Set the client hardware type and address
+func (h *MessageHeader) SetHardware(a HardwareAddr) {
+	h.HType = a.htype
+	h.HLen = a.hlen
+	copy(h.Mac[:], a.addr[:6])
+	copy(h.MacPadding[:], a.addr[6:])
+}
+
+// Reply to a client with the same hardware type and address it sent
+func (h *MessageHeader) CopyHardwareAddr(from *MessageHeader) {
+	h.HType = from.HType
+	h.HLen = from.HLen
+	h.Mac = from.Mac
+	h.MacPadding = from.MacPadding
+}
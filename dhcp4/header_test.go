@@ -0,0 +1,142 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"testing"
+)
+
+func TestMessageParseEncode(t *testing.T) {
+	b := []byte{
+		1, 1, 6, 0, 110, 255, 201, 48, 0, 3, 0, 0, 172, 17, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 3, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	reader := bytes.NewReader(b)
+
+	header, err := ParseMessageHeader(reader)
+	require.Nil(t, err)
+
+	// Break things slightly to ensure .Encode fixes them, and that we don't
+	// need to set them manually when creating messages
+	header.Magic = 0
+	header.HLen = 0
+	header.HType = 0
+
+	buf := new(bytes.Buffer)
+	err = header.Encode(buf)
+	require.Nil(t, err)
+	encoded := buf.Bytes()
+
+	require.Equal(t, b[:240], encoded)
+}
+
+func TestHardwareTypes(t *testing.T) {
+	parse := func(htype, hlen byte) (*MessageHeader, error) {
+		b := make([]byte, HEADER_SIZE)
+		b[0] = BOOT_REQUEST
+		b[1] = htype
+		b[2] = hlen
+		for i := 0; i < MAX_HLEN; i++ {
+			b[28+i] = byte(i + 1)
+		}
+		return ParseMessageHeader(bytes.NewReader(b))
+	}
+
+	// Token ring has 6 byte macs like ethernet
+	header, err := parse(HTYPE_IEEE802, 6)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, header.Chaddr())
+	require.Equal(t, "6-1:2:3:4:5:6", header.Hardware().String())
+
+	// Longer ones run into the padding
+	header, err = parse(HTYPE_IEEE1394, 8)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, header.Chaddr())
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, header.Hardware().Bytes())
+
+	// InfiniBand clients send none at all
+	header, err = parse(HTYPE_INFINIBAND, 0)
+	require.Nil(t, err)
+	require.Empty(t, header.Chaddr())
+	require.True(t, header.Hardware().Empty())
+
+	// Replies keep the client's type and address
+	reply := &MessageHeader{Op: BOOT_REPLY}
+	reply.CopyHardwareAddr(header)
+	buf := new(bytes.Buffer)
+	require.Nil(t, reply.Encode(buf))
+	require.Equal(t, []byte{BOOT_REPLY, HTYPE_INFINIBAND, 0}, buf.Bytes()[:3])
+
+	_, err = parse(0, 6)
+	require.NotNil(t, err)
+	_, err = parse(HTYPE_ETHERNET, MAX_HLEN+1)
+	require.NotNil(t, err)
+}
+
+func TestMacEncoding(t *testing.T) {
+	encoded := StrToMac("0:1c:42:b4:6e:1d")
+	require.Equal(t, "0:1c:42:b4:6e:1d", encoded.String())
+	require.Equal(t, MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}, encoded)
+}
+
+func TestHardwareAddr(t *testing.T) {
+	mac := MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}
+
+	// Ethernet addresses are written just like macs
+	ethernet := mac.Hardware()
+	require.Equal(t, mac.String(), ethernet.String())
+	parsed, err := ParseHardwareAddr("0:1c:42:b4:6e:1d")
+	require.Nil(t, err)
+	require.Equal(t, ethernet, parsed)
+	got, ok := parsed.Mac()
+	require.True(t, ok)
+	require.Equal(t, mac, got)
+
+	// The same address on another type of hardware is another client
+	tokenRing := NewHardwareAddr(HTYPE_IEEE802, mac[:])
+	require.NotEqual(t, ethernet, tokenRing)
+	require.Equal(t, "6-0:1c:42:b4:6e:1d", tokenRing.String())
+
+	long := NewHardwareAddr(HTYPE_IEEE1394, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	for _, a := range []HardwareAddr{tokenRing, long, NewHardwareAddr(HTYPE_INFINIBAND, nil)} {
+		parsed, err := ParseHardwareAddr(a.String())
+		require.Nil(t, err)
+		require.Equal(t, a, parsed)
+	}
+	_, ok = long.Mac()
+	require.False(t, ok)
+
+	// Leading zeroes are fine, which macs aren't always written without
+	parsed, err = ParseHardwareAddr("00:1C:42:B4:6E:1D")
+	require.Nil(t, err)
+	require.Equal(t, ethernet, parsed)
+
+	for _, bad := range []string{"", "0:1c:42", "0:1c:42:b4:6e:1d:0", "0-1:2", "x-1:2", "6-1:2:3:4:5:6:7:8:9:a:b:c:d:e:f:10:11", "zz:1c:42:b4:6e:1d"} {
+		_, err := ParseHardwareAddr(bad)
+		require.NotNil(t, err, bad)
+	}
+
+	require.True(t, HardwareAddr{}.Empty())
+	require.True(t, MacAddress{}.Hardware().Empty())
+	require.False(t, ethernet.Empty())
+}
+
+func FuzzParseMessageHeader(f *testing.F) {
+	f.Add(benchmarkRequest())
+	f.Add(make([]byte, HEADER_SIZE))
+	f.Add([]byte{1, 1, 6})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		header, err := ParseMessageHeader(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+
+		// Anything we accept encodes back to the same bytes, bar a
+		// missing magic cookie, which we always add
+		buf := new(bytes.Buffer)
+		require.Nil(t, header.Encode(buf))
+		require.Equal(t, b[:HEADER_SIZE-4], buf.Bytes()[:HEADER_SIZE-4])
+	})
+}
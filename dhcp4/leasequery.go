@@ -0,0 +1,74 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//
+// Bulk (RFC 6926) and active (RFC 7724) leasequery, which run over TCP with
+// each message preceded by its length, and report how a query went in a
+// status code option (151) and what state each binding is in in a dhcp
+// state option (156)
+//
+
+// Status codes
+const (
+	LQ_SUCCESS           = 0
+	LQ_UNSPEC_FAIL       = 1
+	LQ_QUERY_TERMINATED  = 2
+	LQ_MALFORMED_QUERY   = 3
+	LQ_NOT_ALLOWED       = 4
+	LQ_DATA_MISSING      = 5
+	LQ_CONNECTION_ACTIVE = 6
+	LQ_CATCH_UP_COMPLETE = 7
+)
+
+// Binding states
+const (
+	LQ_STATE_AVAILABLE     = 1
+	LQ_STATE_ACTIVE        = 2
+	LQ_STATE_EXPIRED       = 3
+	LQ_STATE_RELEASED      = 4
+	LQ_STATE_ABANDONED     = 5
+	LQ_STATE_RESET         = 6
+	LQ_STATE_REMOTE        = 7
+	LQ_STATE_TRANSITIONING = 8
+)
+
+// Largest message that fits the 2 byte length
+const MAX_TCP_MESSAGE = 0xffff
+
+// Read one length prefixed message from a leasequery connection
+func ReadTcpMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func WriteTcpMessage(w io.Writer, message []byte) error {
+	if len(message) > MAX_TCP_MESSAGE {
+		return errors.New("Message too long for TCP")
+	}
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(message))), message...))
+	return err
+}
+
+// A status code option's value: the code, then a message for people
+func EncodeStatusCode(code byte, message string) []byte {
+	return append([]byte{code}, message...)
+}
+
+func DecodeStatusCode(data []byte) (byte, string, bool) {
+	if len(data) < 1 {
+		return 0, "", false
+	}
+	return data[0], string(data[1:]), true
+}
@@ -0,0 +1,28 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+//
+// Manufacturer Usage Description URLs (option 161, RFC 8520), which IoT
+// devices send so the network can fetch a profile of what they need to talk
+// to
+//
+
+// The URL from option 161, which must be https
+func ParseMudUrl(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", errors.New("Empty MUD URL")
+	}
+	u, err := url.Parse(string(data))
+	if err != nil {
+		return "", fmt.Errorf("Invalid MUD URL: %v", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("MUD URL '%v' is not https", string(data))
+	}
+	return u.String(), nil
+}
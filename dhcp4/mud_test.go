@@ -0,0 +1,19 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestParseMudUrl(t *testing.T) {
+	mud, err := ParseMudUrl([]byte("https://things.example.com/lightbulb2000"))
+	require.Nil(t, err)
+	require.Equal(t, "https://things.example.com/lightbulb2000", mud)
+	require.Equal(t, "https://things.example.com/lightbulb2000", FormatOption(OPTION_MUD_URL, []byte(mud)))
+
+	for _, bad := range []string{"", "http://things.example.com/lightbulb2000", "https:///lightbulb2000", "https://%zz"} {
+		_, err = ParseMudUrl([]byte(bad))
+		require.NotNil(t, err)
+	}
+}
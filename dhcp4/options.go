@@ -0,0 +1,410 @@
+//
+// Helpers for parsing the DHCP option payloads
+//
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+)
+
+// Represent a single DHCP option
+type Option struct {
+	Header struct {
+		Code   byte
+		Length byte
+	}
+	Data []byte
+}
+
+func (o *Option) CalculateLength() error {
+	length := len(o.Data)
+	if length > 255 {
+		return fmt.Errorf("Length of option %v value '%v' is too long", o.Header.Code, o.Data)
+	}
+	o.Header.Length = byte(length)
+	return nil
+}
+
+//
+// Easily handle lists of options. Each can only appear once.
+//
+
+type Options struct {
+	order []byte
+	data  map[byte]Option
+
+	// Backing store for option values we set or parse, so each doesn't
+	// need its own allocation
+	arena []byte
+}
+
+func NewOptions() *Options {
+	return &Options{
+		data:  map[byte]Option{},
+		order: []byte{},
+	}
+}
+
+// Space for an option value of n bytes
+func (o *Options) alloc(n int) []byte {
+	if cap(o.arena)-len(o.arena) < n {
+		size := 1024
+		if n > size {
+			size = n
+		}
+		o.arena = make([]byte, 0, size)
+	}
+	start := len(o.arena)
+	o.arena = o.arena[:start+n]
+
+	// Capped so appending to one value can't overwrite the next
+	return o.arena[start : start+n : start+n]
+}
+
+// Empty, keeping allocated space for reuse. Values previously got from
+// these options will be overwritten
+func (o *Options) reset() {
+	clear(o.data)
+	o.order = o.order[:0]
+	o.arena = o.arena[:0]
+}
+
+func (o *Options) GetAll() map[byte]Option {
+	return o.data
+}
+
+// Codes of the options in the order they're encoded
+func (o *Options) Codes() []byte {
+	return o.order
+}
+
+func (o *Options) Dump() {
+	for _, key := range o.order {
+		option := o.data[key]
+		log.Printf("%v = %v (%+v)", key, string(""), option.Data)
+	}
+}
+
+//
+// Abstract away boilerplate for common getting operations
+//
+func (o *Options) Get(code byte) (Option, bool) {
+	option, ok := o.data[code]
+	return option, ok
+}
+
+func (o *Options) GetByte(code byte) byte {
+	if option, ok := o.data[code]; ok {
+		if len(option.Data) == 1 {
+			return option.Data[0]
+		}
+	}
+	return 0
+}
+
+func (o *Options) GetUint16(code byte) (uint16, bool) {
+	if option, ok := o.data[code]; ok && len(option.Data) == 2 {
+		return binary.BigEndian.Uint16(option.Data), true
+	}
+	return 0, false
+}
+
+func (o *Options) GetUint32(code byte) (uint32, bool) {
+	if option, ok := o.data[code]; ok && len(option.Data) == 4 {
+		return binary.BigEndian.Uint32(option.Data), true
+	}
+	return 0, false
+}
+
+func (o *Options) GetString(code byte) (string, bool) {
+	if option, ok := o.data[code]; ok {
+		return string(option.Data), true
+	}
+	return "", false
+}
+
+// Single IP option, eg requested IP or server identifier
+func (o *Options) GetIP(code byte) (FixedV4, bool) {
+	if option, ok := o.data[code]; ok {
+		if ip, err := BytesToFixedV4(option.Data); err == nil {
+			return ip, true
+		}
+	}
+	return 0, false
+}
+
+// Non-empty list of IPs, eg routers or dns servers
+func (o *Options) GetIPList(code byte) ([]FixedV4, bool) {
+	if option, ok := o.data[code]; ok && len(option.Data) > 0 && len(option.Data)%4 == 0 {
+		return o.GetFixedV4s(code), true
+	}
+	return nil, false
+}
+
+// Primarily used in testing
+func (o *Options) GetFixedV4s(code byte) []FixedV4 {
+	if option, ok := o.data[code]; ok {
+		if len(option.Data)%4 == 0 {
+			ips := make([]FixedV4, len(option.Data)/4)
+			for i := 0; i < len(ips); i++ {
+				if ip, err := BytesToFixedV4(option.Data[i*4 : i*4+4]); err == nil {
+					ips[i] = ip
+				}
+			}
+			return ips
+		}
+	}
+	return nil
+}
+
+//
+// Abstract away boilerplate for common IP setting operations
+//
+func (o *Options) SetIPs(code byte, ips ...net.IP) {
+	if len(ips) == 0 {
+		return
+	}
+	data := o.alloc(4 * len(ips))
+	for i, ip := range ips {
+		binary.BigEndian.PutUint32(data[i*4:], uint32(IpToFixedV4(ip)))
+	}
+	o.Set(code, data)
+}
+
+func (o *Options) SetFixedV4s(code byte, ips ...FixedV4) {
+	if len(ips) == 0 {
+		return
+	}
+	data := o.alloc(4 * len(ips))
+	for i, ip := range ips {
+		binary.BigEndian.PutUint32(data[i*4:], uint32(ip))
+	}
+	o.Set(code, data)
+}
+
+//
+// Abstract away boilerplate for common scalar setting operations
+//
+func (o *Options) SetByte(code byte, value byte) {
+	data := o.alloc(1)
+	data[0] = value
+	o.Set(code, data)
+}
+
+func (o *Options) SetUint16(code byte, value uint16) {
+	data := o.alloc(2)
+	binary.BigEndian.PutUint16(data, value)
+	o.Set(code, data)
+}
+
+func (o *Options) SetUint32(code byte, value uint32) {
+	data := o.alloc(4)
+	binary.BigEndian.PutUint32(data, value)
+	o.Set(code, data)
+}
+
+func (o *Options) SetString(code byte, value string) {
+	if value == "" {
+		return
+	}
+	data := o.alloc(len(value))
+	copy(data, value)
+	o.Set(code, data)
+}
+
+// Set a single option
+func (o *Options) Set(code byte, data []byte) {
+	option := Option{
+		Data: data,
+	}
+	option.Header.Code = code
+	if err := option.CalculateLength(); err != nil {
+		log.Printf("Can't set option %v to %v: %v", code, data, err)
+		return
+	}
+	if _, ok := o.data[code]; ok {
+		log.Printf("Not setting option %v more than once", code)
+		return
+	}
+	o.order = append(o.order, code)
+	o.data[code] = option
+}
+
+// Set an option, replacing the value of an existing one while keeping its
+// position
+func (o *Options) Override(code byte, data []byte) {
+	if _, ok := o.data[code]; !ok {
+		o.Set(code, data)
+		return
+	}
+	option := Option{
+		Data: data,
+	}
+	option.Header.Code = code
+	if err := option.CalculateLength(); err != nil {
+		log.Printf("Can't set option %v to %v: %v", code, data, err)
+		return
+	}
+	o.data[code] = option
+}
+
+func (o *Options) Delete(code byte) {
+	if _, ok := o.data[code]; !ok {
+		return
+	}
+	delete(o.data, code)
+	for i, c := range o.order {
+		if c == code {
+			o.order = append(o.order[:i], o.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Move the given codes to the front, in that order, keeping the relative
+// order of everything else
+func (o *Options) Prioritize(codes ...byte) {
+	var scratch [256]byte
+	var seen [256]bool
+	order := scratch[:0]
+	for _, code := range codes {
+		if seen[code] {
+			continue
+		}
+		if _, ok := o.data[code]; ok {
+			order = append(order, code)
+			seen[code] = true
+		}
+	}
+	for _, code := range o.order {
+		if !seen[code] {
+			order = append(order, code)
+		}
+	}
+	o.order = append(o.order[:0], order...)
+}
+
+// Add options we don't already have from another set, such as one parsed out
+// of an overloaded header field
+func (o *Options) Merge(other *Options) {
+	for _, code := range other.order {
+		if _, ok := o.data[code]; ok {
+			continue
+		}
+		o.Set(code, other.data[code].Data)
+	}
+}
+
+// Size of all options once encoded, including the sentinel
+func (o *Options) EncodedLen() int {
+	length := 0
+	for _, code := range o.order {
+		if code == OPTION_SENTINEL {
+			continue
+		}
+		// Long values are split over several instances
+		data := o.data[code].Data
+		length += 2*max(1, (len(data)+254)/255) + len(data)
+	}
+	return length + 1
+}
+
+// Encode all options, including sentinel, to buf
+func (o *Options) Encode(buf *bytes.Buffer) error {
+	// Need the sentinel value at the end
+	if len(o.order) > 0 && o.order[len(o.order)-1] != OPTION_SENTINEL {
+		o.Set(OPTION_SENTINEL, nil)
+	}
+
+	for _, code := range o.order {
+		option, ok := o.data[code]
+		if !ok {
+			log.Printf("Missing option %v ?", code)
+			continue
+		}
+
+		// FIXME: why does the following fail to serialize?
+		// binary.Write(buf, binary.LittleEndian, option)
+
+		// Values parsed from repeated options can be too long for one,
+		// so are split again as RFC 3396 describes
+		data := option.Data
+		for first := true; first || len(data) > 0; first = false {
+			chunk := data[:min(len(data), 255)]
+			data = data[len(chunk):]
+
+			if err := buf.WriteByte(option.Header.Code); err != nil {
+				return fmt.Errorf("Failed writing option code to buf: %v", err)
+			}
+
+			// If any of the following fail, we may generate badly corrupted data
+			if err := buf.WriteByte(byte(len(chunk))); err != nil {
+				return fmt.Errorf("Failed writing option length to buf: %v", err)
+			}
+			if len(chunk) > 0 {
+				if _, err := buf.Write(chunk); err != nil {
+					return fmt.Errorf("Failed writing option data to buf: %v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Parse options into a list
+func ParseOptions(reader *bytes.Reader) *Options {
+	buf := make([]byte, reader.Len())
+	reader.Read(buf)
+
+	options := NewOptions()
+	options.parse(buf)
+	return options
+}
+
+// Add options encoded in buf. Options we already have are extended with
+// the data of repeated ones, whether within buf or continued in the header
+// fields, which is how RFC 3396 carries values over 255 bytes
+func (o *Options) parse(buf []byte) {
+	for pos := 0; pos < len(buf); {
+		code := buf[pos]
+
+		// Used for padding to word boundaries, without a length
+		if code == OPTION_PADDING {
+			pos++
+			continue
+		} else if code == OPTION_SENTINEL {
+			// The end
+			break
+		}
+
+		// Truncated options end parsing, keeping those before them
+		if pos+1 >= len(buf) {
+			log.Printf("Failed reading message option?")
+			break
+		}
+		length := int(buf[pos+1])
+		pos += 2
+		if pos+length > len(buf) {
+			log.Printf("Did not read as much as expected. %v != %v", len(buf)-pos, length)
+			break
+		}
+
+		// Copied so the packet can be reused
+		option, ok := o.data[code]
+		data := o.alloc(len(option.Data) + length)
+		copy(data[copy(data, option.Data):], buf[pos:pos+length])
+		if !ok {
+			o.Set(code, data)
+		} else {
+			// Concatenated, so may be longer than the header can say
+			option.Data = data
+			option.Header.Length = byte(min(len(data), 255))
+			o.data[code] = option
+		}
+		pos += length
+	}
+}
@@ -0,0 +1,167 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestTypedOptions(t *testing.T) {
+	options := NewOptions()
+	options.SetByte(OPTION_MESSAGE_TYPE, DHCPOFFER)
+	options.SetUint16(OPTION_MTU, 1500)
+	options.SetUint32(OPTION_LEASE_TIME, 3600)
+	options.SetString(OPTION_HOST_NAME, "ubuntu2")
+	options.SetString(OPTION_DOMAIN_NAME, "")
+	options.SetIPs(OPTION_SERVER_ID, net.ParseIP("10.0.0.1"))
+	options.SetIPs(OPTION_DNS_SERVER, net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8"))
+
+	require.Equal(t, DHCPOFFER, options.GetByte(OPTION_MESSAGE_TYPE))
+
+	mtu, ok := options.GetUint16(OPTION_MTU)
+	require.True(t, ok)
+	require.Equal(t, uint16(1500), mtu)
+
+	leaseTime, ok := options.GetUint32(OPTION_LEASE_TIME)
+	require.True(t, ok)
+	require.Equal(t, uint32(3600), leaseTime)
+
+	hostname, ok := options.GetString(OPTION_HOST_NAME)
+	require.True(t, ok)
+	require.Equal(t, "ubuntu2", hostname)
+
+	// Empty strings are never set
+	_, ok = options.GetString(OPTION_DOMAIN_NAME)
+	require.False(t, ok)
+
+	ip, ok := options.GetIP(OPTION_SERVER_ID)
+	require.True(t, ok)
+	require.Equal(t, IpToFixedV4(net.ParseIP("10.0.0.1")), ip)
+
+	ips, ok := options.GetIPList(OPTION_DNS_SERVER)
+	require.True(t, ok)
+	require.Equal(t, []FixedV4{IpToFixedV4(net.ParseIP("1.1.1.1")), IpToFixedV4(net.ParseIP("8.8.8.8"))}, ips)
+
+	// Wrong sizes are rejected rather than misread
+	_, ok = options.GetUint32(OPTION_MTU)
+	require.False(t, ok)
+	_, ok = options.GetUint16(OPTION_LEASE_TIME)
+	require.False(t, ok)
+	_, ok = options.GetIP(OPTION_DNS_SERVER)
+	require.False(t, ok)
+	_, ok = options.GetIP(OPTION_HOST_NAME)
+	require.False(t, ok)
+	_, ok = options.GetIPList(OPTION_MTU)
+	require.False(t, ok)
+
+	// Missing options
+	_, ok = options.GetUint32(OPTION_T1)
+	require.False(t, ok)
+
+	// Overriding keeps position
+	options.Override(OPTION_MTU, short2bytes(9000))
+	mtu, _ = options.GetUint16(OPTION_MTU)
+	require.Equal(t, uint16(9000), mtu)
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, options.Encode(buf))
+	require.Equal(t, []byte{OPTION_MESSAGE_TYPE, 1, DHCPOFFER, OPTION_MTU, 2, 0x23, 0x28}, buf.Bytes()[:7])
+}
+
+func TestParseMalformedOptions(t *testing.T) {
+	// Length running past the end keeps what came before
+	options := ParseOptions(bytes.NewReader([]byte{OPTION_MESSAGE_TYPE, 1, DHCPDISCOVER, OPTION_HOST_NAME, 10, 'a'}))
+	require.Equal(t, DHCPDISCOVER, options.GetByte(OPTION_MESSAGE_TYPE))
+	_, ok := options.Get(OPTION_HOST_NAME)
+	require.False(t, ok)
+
+	// As does a code without a length
+	options = ParseOptions(bytes.NewReader([]byte{OPTION_MESSAGE_TYPE, 1, DHCPDISCOVER, OPTION_HOST_NAME}))
+	require.Equal(t, DHCPDISCOVER, options.GetByte(OPTION_MESSAGE_TYPE))
+	require.Len(t, options.GetAll(), 1)
+
+	// Empty values are kept, but don't read as anything
+	options = ParseOptions(bytes.NewReader([]byte{OPTION_MESSAGE_TYPE, 0, OPTION_REQUESTED_IP, 0, OPTION_SENTINEL}))
+	require.Zero(t, options.GetByte(OPTION_MESSAGE_TYPE))
+	_, ok = options.GetIP(OPTION_REQUESTED_IP)
+	require.False(t, ok)
+
+	// Repeated options are concatenated, so one meant to be a byte isn't
+	options = ParseOptions(bytes.NewReader([]byte{OPTION_MESSAGE_TYPE, 1, DHCPDISCOVER, OPTION_MESSAGE_TYPE, 1, DHCPREQUEST}))
+	require.Equal(t, byte(0), options.GetByte(OPTION_MESSAGE_TYPE))
+}
+
+func TestParseSplitOptions(t *testing.T) {
+	// A classless route split over two instances, with another between
+	options := ParseOptions(bytes.NewReader([]byte{
+		OPTION_CLASSLESS_RT, 3, 24, 10, 0,
+		OPTION_MESSAGE_TYPE, 1, DHCPDISCOVER,
+		OPTION_CLASSLESS_RT, 5, 1, 10, 0, 0, 1,
+	}))
+	option, ok := options.Get(OPTION_CLASSLESS_RT)
+	require.True(t, ok)
+	require.Equal(t, []byte{24, 10, 0, 1, 10, 0, 0, 1}, option.Data)
+	require.Equal(t, byte(8), option.Header.Length)
+	require.Equal(t, DHCPDISCOVER, options.GetByte(OPTION_MESSAGE_TYPE))
+
+	// Values too long for one option are split again when encoded
+	long := bytes.Repeat([]byte{'a'}, 300)
+	buf := []byte{OPTION_VENDOR_INFO, 200}
+	buf = append(buf, long[:200]...)
+	buf = append(buf, OPTION_VENDOR_INFO, 100)
+	buf = append(buf, long[200:]...)
+	options = ParseOptions(bytes.NewReader(buf))
+	option, _ = options.Get(OPTION_VENDOR_INFO)
+	require.Equal(t, long, option.Data)
+
+	encoded := new(bytes.Buffer)
+	require.Nil(t, options.Encode(encoded))
+	require.Equal(t, []byte{OPTION_VENDOR_INFO, 255}, encoded.Bytes()[:2])
+	require.Equal(t, []byte{OPTION_VENDOR_INFO, 45}, encoded.Bytes()[257:259])
+	option, _ = ParseOptions(bytes.NewReader(encoded.Bytes())).Get(OPTION_VENDOR_INFO)
+	require.Equal(t, long, option.Data)
+}
+
+func FuzzParseOptions(f *testing.F) {
+	f.Add(benchmarkRequest()[HEADER_SIZE:])
+	f.Add([]byte{OPTION_MESSAGE_TYPE, 1, DHCPDISCOVER, OPTION_MESSAGE_TYPE, 1, DHCPREQUEST})
+	f.Add([]byte{OPTION_HOST_NAME, 255, 'a'})
+	f.Add([]byte{OPTION_PADDING, OPTION_HOST_NAME, 0, OPTION_SENTINEL})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		options := ParseOptions(bytes.NewReader(b))
+
+		// Whatever we make of it encodes and parses back the same
+		buf := new(bytes.Buffer)
+		require.Nil(t, options.Encode(buf))
+		reparsed := ParseOptions(bytes.NewReader(buf.Bytes()))
+		count := len(options.GetAll())
+		if _, ok := options.Get(OPTION_SENTINEL); ok {
+			count--
+		}
+		require.Equal(t, count, len(reparsed.GetAll()))
+		for code, option := range reparsed.GetAll() {
+			require.Equal(t, options.GetAll()[code].Data, option.Data)
+		}
+	})
+}
+
+func TestFormatOption(t *testing.T) {
+	require.Equal(t, "subnet mask", OptionName(OPTION_SUBNET))
+	require.Equal(t, "option 200", OptionName(200))
+
+	require.Equal(t, "1.1.1.1, 8.8.8.8", FormatOption(OPTION_DNS_SERVER, []byte{1, 1, 1, 1, 8, 8, 8, 8}))
+	require.Equal(t, "example.com", FormatOption(OPTION_DOMAIN_NAME, []byte("example.com")))
+	require.Equal(t, "1h0m0s", FormatOption(OPTION_LEASE_TIME, []byte{0, 0, 0x0e, 0x10}))
+	require.Equal(t, "-1h0m0s", FormatOption(OPTION_TIME_OFFSET, []byte{0xff, 0xff, 0xf1, 0xf0}))
+	require.Equal(t, "1500", FormatOption(OPTION_MTU, []byte{0x05, 0xdc}))
+	require.Equal(t, "DHCPACK", FormatOption(OPTION_MESSAGE_TYPE, []byte{DHCPACK}))
+	require.Equal(t, "1, 3, 6", FormatOption(OPTION_PARAM_REQ, []byte{1, 3, 6}))
+	require.Equal(t, "10.0.0.2, 10.0.0.3", FormatOption(OPTION_TFTP_SERVERS, []byte{10, 0, 0, 2, 10, 0, 0, 3}))
+
+	// Unknown or malformed values are shown as hex
+	require.Equal(t, "0102", FormatOption(200, []byte{1, 2}))
+	require.Equal(t, "010203", FormatOption(OPTION_ROUTER, []byte{1, 2, 3}))
+}
@@ -0,0 +1,165 @@
+package dhcp4
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//
+// Arbitrary options sourced from configuration, with a typed value
+//
+
+type CustomOption struct {
+	Code byte
+	Data []byte
+}
+
+// Options we generate ourselves which must not be overridden by configuration
+var reservedOptionCodes = map[byte]struct{}{
+	OPTION_PADDING:      {},
+	OPTION_OPTION_OVER:  {},
+	OPTION_MESSAGE_TYPE: {},
+	OPTION_SERVER_ID:    {},
+	OPTION_SENTINEL:     {},
+}
+
+func NewCustomOption(code int, kind string, value interface{}) (CustomOption, error) {
+	if code < 1 || code > 254 {
+		return CustomOption{}, fmt.Errorf("Option code %v out of range", code)
+	}
+	if _, ok := reservedOptionCodes[byte(code)]; ok {
+		return CustomOption{}, fmt.Errorf("Option %v cannot be set from configuration", code)
+	}
+	return newOption("Option", code, kind, value)
+}
+
+// Sub-option of an encapsulating option, such as vendor specific
+// information (43). Any code but pad and end will do
+func NewSubOption(code int, kind string, value interface{}) (CustomOption, error) {
+	if code < 1 || code > 254 {
+		return CustomOption{}, fmt.Errorf("Sub-option code %v out of range", code)
+	}
+	return newOption("Sub-option", code, kind, value)
+}
+
+func newOption(what string, code int, kind string, value interface{}) (CustomOption, error) {
+	data, err := EncodeOptionValue(kind, value)
+	if err != nil {
+		return CustomOption{}, fmt.Errorf("%v %v: %v", what, code, err)
+	}
+	if len(data) > 255 {
+		return CustomOption{}, fmt.Errorf("%v %v: value is too long", what, code)
+	}
+	return CustomOption{byte(code), data}, nil
+}
+
+// Turn a value from yaml into its wire format. Lists may be given either as
+// yaml lists or as comma separated strings
+func EncodeOptionValue(kind string, value interface{}) ([]byte, error) {
+	switch kind {
+	case "ip":
+		return encodeIPs(optionValueStrings(value), 1)
+	case "ip-list":
+		return encodeIPs(optionValueStrings(value), 0)
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("Expected a string, not %v", value)
+		}
+		return []byte(s), nil
+	case "uint8":
+		n, err := optionValueUint(value, 8)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{byte(n)}, nil
+	case "uint16":
+		n, err := optionValueUint(value, 16)
+		if err != nil {
+			return nil, err
+		}
+		return short2bytes(uint16(n)), nil
+	case "uint32":
+		n, err := optionValueUint(value, 32)
+		if err != nil {
+			return nil, err
+		}
+		return long2bytes(uint32(n)), nil
+	case "hex":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("Expected a hex string, not %v", value)
+		}
+		s = strings.NewReplacer(":", "", " ", "").Replace(s)
+		return hex.DecodeString(s)
+	case "encapsulated":
+		subOptions, ok := value.([]CustomOption)
+		if !ok || len(subOptions) == 0 {
+			return nil, fmt.Errorf("Expected sub-options, not %v", value)
+		}
+		return EncapsulateOptions(subOptions), nil
+	default:
+		return nil, fmt.Errorf("Unknown option type %q", kind)
+	}
+}
+
+// Sub-options one after the other as code, length and data, as RFC 2132
+// describes for option 43
+func EncapsulateOptions(subOptions []CustomOption) []byte {
+	var data []byte
+	for _, option := range subOptions {
+		data = append(data, option.Code, byte(len(option.Data)))
+		data = append(data, option.Data...)
+	}
+	return data
+}
+
+// If count is non-zero, exactly that many IPs are required
+func encodeIPs(values []string, count int) ([]byte, error) {
+	if len(values) == 0 || (count != 0 && len(values) != count) {
+		return nil, fmt.Errorf("Wrong number of IPs in %v", values)
+	}
+	data := make([]byte, 0, 4*len(values))
+	for _, value := range values {
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("Invalid IP %v", value)
+		}
+		data = append(data, ip.To4()...)
+	}
+	return data, nil
+}
+
+func optionValueStrings(value interface{}) []string {
+	var result []string
+	switch v := value.(type) {
+	case string:
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			result = append(result, fmt.Sprint(item))
+		}
+	}
+	return result
+}
+
+func optionValueUint(value interface{}, bits int) (uint64, error) {
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("Negative value %v", v)
+		}
+		return strconv.ParseUint(strconv.Itoa(v), 10, bits)
+	case string:
+		return strconv.ParseUint(v, 0, bits)
+	default:
+		return 0, fmt.Errorf("Expected a number, not %v", value)
+	}
+}
@@ -0,0 +1,79 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestEncodeOptionValue(t *testing.T) {
+	b, err := EncodeOptionValue("ip", "10.0.0.1")
+	require.Nil(t, err)
+	require.Equal(t, []byte{10, 0, 0, 1}, b)
+
+	_, err = EncodeOptionValue("ip", "10.0.0.1, 10.0.0.2")
+	require.NotNil(t, err)
+
+	b, err = EncodeOptionValue("ip-list", []interface{}{"10.0.0.1", "10.0.0.2"})
+	require.Nil(t, err)
+	require.Equal(t, []byte{10, 0, 0, 1, 10, 0, 0, 2}, b)
+
+	b, err = EncodeOptionValue("ip-list", "10.0.0.1, 10.0.0.2")
+	require.Nil(t, err)
+	require.Equal(t, []byte{10, 0, 0, 1, 10, 0, 0, 2}, b)
+
+	b, err = EncodeOptionValue("string", "tftp.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []byte("tftp.example.com"), b)
+
+	b, err = EncodeOptionValue("uint8", 64)
+	require.Nil(t, err)
+	require.Equal(t, []byte{64}, b)
+
+	_, err = EncodeOptionValue("uint8", 256)
+	require.NotNil(t, err)
+
+	b, err = EncodeOptionValue("uint16", 1500)
+	require.Nil(t, err)
+	require.Equal(t, []byte{0x05, 0xdc}, b)
+
+	b, err = EncodeOptionValue("uint32", "0x10")
+	require.Nil(t, err)
+	require.Equal(t, []byte{0, 0, 0, 0x10}, b)
+
+	b, err = EncodeOptionValue("hex", "01:02:ff")
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 0xff}, b)
+
+	_, err = EncodeOptionValue("bogus", "1")
+	require.NotNil(t, err)
+
+	_, err = NewCustomOption(OPTION_MESSAGE_TYPE, "uint8", 1)
+	require.NotNil(t, err)
+
+	_, err = NewCustomOption(300, "uint8", 1)
+	require.NotNil(t, err)
+}
+
+func TestEncapsulatedOptions(t *testing.T) {
+	controller, err := NewSubOption(1, "ip", "10.0.0.5")
+	require.Nil(t, err)
+	name, err := NewSubOption(2, "string", "unifi")
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 4, 10, 0, 0, 5, 2, 5, 'u', 'n', 'i', 'f', 'i'}, EncapsulateOptions([]CustomOption{controller, name}))
+
+	// Sub-options may be encapsulated themselves
+	inner, err := NewSubOption(3, "encapsulated", []CustomOption{controller})
+	require.Nil(t, err)
+	option, err := NewCustomOption(OPTION_VENDOR_INFO, "encapsulated", []CustomOption{inner})
+	require.Nil(t, err)
+	require.Equal(t, []byte{3, 6, 1, 4, 10, 0, 0, 5}, option.Data)
+
+	// Codes reserved at the top level are fine within
+	_, err = NewSubOption(OPTION_MESSAGE_TYPE, "uint8", 1)
+	require.Nil(t, err)
+	_, err = NewSubOption(255, "uint8", 1)
+	require.NotNil(t, err)
+	_, err = NewCustomOption(OPTION_VENDOR_INFO, "encapsulated", []CustomOption{})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,289 @@
+//
+// Helpers for parsing and encoding a unified DHCP message,
+// including the header and the options
+//
+package dhcp4
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// Values for the option overload option (52)
+const (
+	OVERLOAD_FILE  byte = 1
+	OVERLOAD_SNAME byte = 2
+	OVERLOAD_BOTH  byte = 3
+)
+
+// Smallest message every client must accept: a 576 byte datagram, less IP
+// and UDP headers
+const MIN_MESSAGE_SIZE = 576 - 28
+
+// BOOTP messages have a fixed 64 byte vendor area
+const BOOTP_MESSAGE_SIZE = 300
+
+type DHCPMessage struct {
+	Header  *MessageHeader
+	Options *Options
+
+	// If non-zero, options which don't fit within this total message size
+	// spill over into the file and sname header fields
+	MaxSize int
+
+	// If non-zero, pad the message to at least this size
+	MinSize int
+}
+
+func NewDhcpMessage() *DHCPMessage {
+	return &DHCPMessage{
+		Options: NewOptions(),
+		Header:  &MessageHeader{},
+	}
+}
+
+// Messages are reused rather than allocated for every packet, along with
+// the space for their options
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return NewDhcpMessage()
+	},
+}
+
+// An empty message, which should be given back with Release once done with
+func GetDhcpMessage() *DHCPMessage {
+	return messagePool.Get().(*DHCPMessage)
+}
+
+// Give a message back for reuse. Neither it nor any option data got from
+// it may be used afterwards
+func (m *DHCPMessage) Release() {
+	*m.Header = MessageHeader{}
+	m.Options.reset()
+	m.MaxSize = 0
+	m.MinSize = 0
+	messagePool.Put(m)
+}
+
+// Copy of a message sharing nothing with it, which should be given back with
+// Release once done with
+func (m *DHCPMessage) Clone() *DHCPMessage {
+	c := GetDhcpMessage()
+	*c.Header = *m.Header
+	for _, code := range m.Options.Codes() {
+		option, _ := m.Options.Get(code)
+		data := c.Options.alloc(len(option.Data))
+		copy(data, option.Data)
+		c.Options.Set(code, data)
+	}
+	c.MaxSize = m.MaxSize
+	c.MinSize = m.MinSize
+	return c
+}
+
+// Buffers for encoding messages into, so sending doesn't allocate
+var encodePool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func GetEncodeBuffer() *bytes.Buffer {
+	return encodePool.Get().(*bytes.Buffer)
+}
+
+func PutEncodeBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	encodePool.Put(buf)
+}
+
+func (m *DHCPMessage) Encode(buf *bytes.Buffer) error {
+	header, options := m.Header, m.Options
+
+	if m.MaxSize > 0 {
+		var err error
+		header, options, err = m.overload(m.MaxSize - HEADER_SIZE)
+		if err != nil {
+			return fmt.Errorf("Fitting dhcp options into %v bytes: %v", m.MaxSize, err)
+		}
+	}
+
+	err := header.Encode(buf)
+	if err != nil {
+		return fmt.Errorf("Writing dhcp header to our payload: %v", err)
+	}
+
+	err = options.Encode(buf)
+	if err != nil {
+		return fmt.Errorf("Writing dhcp options to our payload: %v", err)
+	}
+
+	if buf.Len() < m.MinSize {
+		buf.Write(make([]byte, m.MinSize-buf.Len()))
+	}
+
+	return nil
+}
+
+// Drop options from the end of the list until the message fits in MaxSize,
+// never dropping the first keep options. Returns the dropped option codes
+func (m *DHCPMessage) Trim(keep int) []byte {
+	var dropped []byte
+	if m.MaxSize == 0 {
+		return dropped
+	}
+	for {
+		if m.fits(m.MaxSize - HEADER_SIZE) {
+			return dropped
+		}
+		order := m.Options.order
+		if len(order) > 0 && order[len(order)-1] == OPTION_SENTINEL {
+			order = order[:len(order)-1]
+		}
+		if len(order) <= keep {
+			return dropped
+		}
+		code := order[len(order)-1]
+		m.Options.Delete(code)
+		dropped = append(dropped, code)
+	}
+}
+
+// A region of the message options can be encoded into
+type optionArea struct {
+	options  *Options
+	capacity int
+	field    []byte
+	flag     byte
+}
+
+// Whether overload would manage to fit our options in space bytes, without
+// writing anything to the header
+func (m *DHCPMessage) fits(space int) bool {
+	if m.Options.EncodedLen() <= space {
+		return true
+	}
+
+	// Same areas, in the same order, as overload
+	capacities := [3]int{space - 4}
+	areas := 1
+	if isZero(m.Header.Filename[:]) {
+		capacities[areas] = len(m.Header.Filename) - 1
+		areas++
+	}
+	if isZero(m.Header.Hostname[:]) {
+		capacities[areas] = len(m.Header.Hostname) - 1
+		areas++
+	}
+
+	i := 0
+	for _, code := range m.Options.order {
+		if code == OPTION_SENTINEL {
+			continue
+		}
+		size := 2 + len(m.Options.data[code].Data)
+		for i < areas && capacities[i] < size {
+			i++
+		}
+		if i == areas {
+			return false
+		}
+		capacities[i] -= size
+	}
+	return true
+}
+
+// If our options don't fit in space bytes, move as many as needed into the
+// unused file and sname header fields, returning the header to encode and
+// what's left for the options area along with the option overload option.
+// The message itself is left as it was, so it encodes the same every time
+func (m *DHCPMessage) overload(space int) (*MessageHeader, *Options, error) {
+	if m.Options.EncodedLen() <= space {
+		return m.Header, m.Options, nil
+	}
+	header := *m.Header
+
+	// Areas in the order RFC 2131 says they're read: options, file, sname.
+	// Leave room in the options area for option 52 and the sentinel, and in
+	// the header fields for the sentinel
+	areas := []*optionArea{{options: NewOptions(), capacity: space - 4}}
+	if isZero(header.Filename[:]) {
+		areas = append(areas, &optionArea{NewOptions(), len(header.Filename) - 1, header.Filename[:], OVERLOAD_FILE})
+	}
+	if isZero(header.Hostname[:]) {
+		areas = append(areas, &optionArea{NewOptions(), len(header.Hostname) - 1, header.Hostname[:], OVERLOAD_SNAME})
+	}
+
+	i := 0
+	for _, code := range m.Options.order {
+		if code == OPTION_SENTINEL {
+			continue
+		}
+		option := m.Options.data[code]
+		for i < len(areas) && areas[i].capacity < 2+len(option.Data) {
+			i++
+		}
+		if i == len(areas) {
+			return nil, nil, fmt.Errorf("No room left for option %v", code)
+		}
+		areas[i].options.Set(code, option.Data)
+		areas[i].capacity -= 2 + len(option.Data)
+	}
+
+	var overload byte
+	for _, area := range areas[1:] {
+		if len(area.options.order) == 0 {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := area.options.Encode(buf); err != nil {
+			return nil, nil, err
+		}
+		copy(area.field, buf.Bytes())
+		overload |= area.flag
+	}
+
+	// Everything fit in the options area after all
+	if overload == 0 {
+		return m.Header, m.Options, nil
+	}
+
+	areas[0].options.SetByte(OPTION_OPTION_OVER, overload)
+	return &header, areas[0].options, nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func ParseDhcpMessage(buf []byte) (*DHCPMessage, error) {
+	message := GetDhcpMessage()
+	header, options := message.Header, message.Options
+
+	if err := decodeMessageHeader(buf, header); err != nil {
+		message.Release()
+		return nil, err
+	}
+
+	// Parse arbitrary options, if there are any
+	if header.Magic == Magic {
+		options.parse(buf[HEADER_SIZE:])
+	}
+
+	// Options may continue in the file and sname header fields, in that order
+	overload := options.GetByte(OPTION_OPTION_OVER)
+	if overload&OVERLOAD_FILE != 0 {
+		options.parse(header.Filename[:])
+	}
+	if overload&OVERLOAD_SNAME != 0 {
+		options.parse(header.Hostname[:])
+	}
+
+	return message, nil
+}
@@ -0,0 +1,149 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseDhcpMessage(t *testing.T) {
+	b := []byte{
+		1, 1, 6, 0, 110, 255, 201, 48, 0, 3, 0, 0, 172, 17, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 3, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err := ParseDhcpMessage(b)
+	require.Nil(t, err)
+
+	require.Equal(t, "0:1c:42:b4:6e:1d", message.Header.Mac.String())
+	require.Equal(t, byte(DHCPREQUEST), message.Options.GetByte(OPTION_MESSAGE_TYPE))
+	require.Equal(t, uint32(0x6effc930), message.Header.Identifier)
+	require.Equal(t, IpToFixedV4(net.ParseIP("172.17.0.100")), message.Header.ClientAddr)
+
+	opt, ok := message.Options.Get(OPTION_MESSAGE_TYPE)
+	require.True(t, ok)
+	require.Equal(t, []byte{3}, opt.Data)
+
+	opt, ok = message.Options.Get(OPTION_HOST_NAME)
+	require.True(t, ok)
+	require.Equal(t, []byte("ubuntu2"), opt.Data)
+
+	// Verify added padding around options doesn't break
+	b = []byte{
+		1, 1, 6, 0, 110, 255, 201, 48, 0, 3, 0, 0, 172, 17, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 3, 0, 0, 0, 0, 0, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err = ParseDhcpMessage(b)
+	require.Nil(t, err)
+
+	opt, ok = message.Options.Get(OPTION_HOST_NAME)
+	require.True(t, ok)
+	require.Equal(t, []byte("ubuntu2"), opt.Data)
+
+	// Trying to decode something broken fails
+	b = []byte{}
+	_, err = ParseDhcpMessage(b)
+	require.NotNil(t, err)
+}
+
+func TestOptionOverload(t *testing.T) {
+	message := NewDhcpMessage()
+	message.Header.Op = BOOT_REPLY
+	message.Options.SetByte(OPTION_MESSAGE_TYPE, DHCPOFFER)
+	message.Options.SetString(OPTION_HOST_NAME, string(bytes.Repeat([]byte("h"), 200)))
+	message.Options.SetString(OPTION_DOMAIN_NAME, string(bytes.Repeat([]byte("d"), 100)))
+	message.Options.SetString(OPTION_ROOT_PATH, string(bytes.Repeat([]byte("r"), 50)))
+	message.MaxSize = MIN_MESSAGE_SIZE
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, message.Encode(buf))
+	require.LessOrEqual(t, buf.Len(), MIN_MESSAGE_SIZE)
+
+	parsed, err := ParseDhcpMessage(buf.Bytes())
+	require.Nil(t, err)
+	require.Equal(t, OVERLOAD_BOTH, parsed.Options.GetByte(OPTION_OPTION_OVER))
+
+	// The message is left alone, so encoding it again gives the same bytes
+	require.True(t, isZero(message.Header.Filename[:]))
+	require.True(t, isZero(message.Header.Hostname[:]))
+	again := new(bytes.Buffer)
+	require.Nil(t, message.Encode(again))
+	require.Equal(t, buf.Bytes(), again.Bytes())
+
+	for _, code := range []byte{OPTION_MESSAGE_TYPE, OPTION_HOST_NAME, OPTION_DOMAIN_NAME, OPTION_ROOT_PATH} {
+		expected, _ := message.Options.GetString(code)
+		actual, ok := parsed.Options.GetString(code)
+		require.True(t, ok)
+		require.Equal(t, expected, actual)
+	}
+
+	// Small enough messages are left alone
+	message = NewDhcpMessage()
+	message.Options.SetByte(OPTION_MESSAGE_TYPE, DHCPOFFER)
+	message.MaxSize = MIN_MESSAGE_SIZE
+
+	buf = new(bytes.Buffer)
+	require.Nil(t, message.Encode(buf))
+	parsed, err = ParseDhcpMessage(buf.Bytes())
+	require.Nil(t, err)
+	_, ok := parsed.Options.Get(OPTION_OPTION_OVER)
+	require.False(t, ok)
+
+	// Too much to fit anywhere fails
+	message = NewDhcpMessage()
+	message.Options.SetString(OPTION_HOST_NAME, string(bytes.Repeat([]byte("h"), 250)))
+	message.Options.SetString(OPTION_DOMAIN_NAME, string(bytes.Repeat([]byte("d"), 250)))
+	message.MaxSize = MIN_MESSAGE_SIZE
+	require.NotNil(t, message.Encode(new(bytes.Buffer)))
+}
+
+// A typical REQUEST, with a parameter request list and a few other options
+func benchmarkRequest() []byte {
+	message := NewDhcpMessage()
+	message.Header.Op = BOOT_REQUEST
+	message.Header.Identifier = 0x1234
+	message.Header.Mac = MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}
+	message.Options.SetByte(OPTION_MESSAGE_TYPE, DHCPREQUEST)
+	message.Options.SetFixedV4s(OPTION_REQUESTED_IP, IpToFixedV4(net.ParseIP("172.17.0.100")))
+	message.Options.SetString(OPTION_HOST_NAME, "ubuntu2")
+	message.Options.Set(OPTION_PARAM_REQ, []byte{1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42})
+	message.Options.Set(OPTION_CLIENT_ID, []byte{1, 0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d})
+	buf := new(bytes.Buffer)
+	message.Encode(buf)
+	return buf.Bytes()
+}
+
+func BenchmarkParseDhcpMessage(b *testing.B) {
+	packet := benchmarkRequest()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message, err := ParseDhcpMessage(packet)
+		if err != nil {
+			b.Fatal(err)
+		}
+		message.Release()
+	}
+}
+
+func TestCloneMessage(t *testing.T) {
+	message := NewDhcpMessage()
+	message.Header.Identifier = 0x1234
+	message.Options.SetByte(OPTION_MESSAGE_TYPE, DHCPOFFER)
+	message.Options.SetString(OPTION_HOST_NAME, "host")
+	message.MaxSize = MIN_MESSAGE_SIZE
+
+	clone := message.Clone()
+	require.Equal(t, message.Header, clone.Header)
+	require.Equal(t, message.Options.Codes(), clone.Options.Codes())
+	require.Equal(t, MIN_MESSAGE_SIZE, clone.MaxSize)
+
+	// Nothing is shared
+	message.Header.Identifier = 0
+	option, _ := message.Options.Get(OPTION_HOST_NAME)
+	option.Data[0] = 'g'
+	message.Release()
+	require.Equal(t, uint32(0x1234), clone.Header.Identifier)
+	hostname, _ := clone.Options.GetString(OPTION_HOST_NAME)
+	require.Equal(t, "host", hostname)
+}
@@ -0,0 +1,28 @@
+package dhcp4
+
+//
+// Sub-options of the relay agent information option (82, RFC 3046), which
+// relays add to say which switch port or subscriber a request came from
+//
+
+const (
+	RELAY_AGENT_CIRCUIT_ID = 1
+	RELAY_AGENT_REMOTE_ID  = 2
+	RELAY_AGENT_RELAY_ID   = 12 // RFC 6925
+)
+
+// A sub-option from relay agent information, if it's there and the
+// information is well formed
+func RelayAgentSubOption(info []byte, code byte) ([]byte, bool) {
+	for len(info) >= 2 {
+		length := int(info[1])
+		if len(info) < 2+length {
+			return nil, false
+		}
+		if info[0] == code {
+			return info[2 : 2+length], true
+		}
+		info = info[2+length:]
+	}
+	return nil, false
+}
@@ -0,0 +1,23 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestRelayAgentSubOption(t *testing.T) {
+	info := []byte{RELAY_AGENT_CIRCUIT_ID, 3, 'p', '1', '2', RELAY_AGENT_REMOTE_ID, 2, 0xaa, 0xbb}
+
+	circuit, ok := RelayAgentSubOption(info, RELAY_AGENT_CIRCUIT_ID)
+	require.True(t, ok)
+	require.Equal(t, []byte("p12"), circuit)
+	remote, ok := RelayAgentSubOption(info, RELAY_AGENT_REMOTE_ID)
+	require.True(t, ok)
+	require.Equal(t, []byte{0xaa, 0xbb}, remote)
+
+	_, ok = RelayAgentSubOption(info, 9)
+	require.False(t, ok)
+	_, ok = RelayAgentSubOption(info[:7], RELAY_AGENT_REMOTE_ID)
+	require.False(t, ok)
+}
@@ -0,0 +1,51 @@
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+)
+
+//
+// Static route pushed to clients via the classless static route option (121)
+//
+
+type StaticRoute struct {
+	Destination *net.IPNet
+	Router      net.IP
+}
+
+func ParseStaticRoute(destination, router string) (StaticRoute, error) {
+	_, ipnet, err := net.ParseCIDR(destination)
+	if err != nil {
+		return StaticRoute{}, err
+	}
+	if ipnet.IP.To4() == nil {
+		return StaticRoute{}, fmt.Errorf("Route destination %v is not v4", destination)
+	}
+	ip := net.ParseIP(router)
+	if ip == nil || ip.To4() == nil {
+		return StaticRoute{}, fmt.Errorf("Invalid route gateway %v", router)
+	}
+	return StaticRoute{ipnet, ip}, nil
+}
+
+// RFC 3442 encoding: prefix length, then only the significant octets of the
+// destination, then the router
+func (r StaticRoute) Bytes() []byte {
+	ones, _ := r.Destination.Mask.Size()
+	significant := (ones + 7) / 8
+
+	b := make([]byte, 0, 1+significant+4)
+	b = append(b, byte(ones))
+	b = append(b, r.Destination.IP.To4()[:significant]...)
+	b = append(b, r.Router.To4()...)
+	return b
+}
+
+func EncodeStaticRoutes(routes []StaticRoute) []byte {
+	var b []byte
+	for _, route := range routes {
+		b = append(b, route.Bytes()...)
+	}
+	return b
+}
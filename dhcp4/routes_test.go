@@ -0,0 +1,29 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestStaticRouteEncoding(t *testing.T) {
+	route, err := ParseStaticRoute("10.0.0.0/8", "172.17.0.1")
+	require.Nil(t, err)
+	require.Equal(t, []byte{8, 10, 172, 17, 0, 1}, route.Bytes())
+
+	route, err = ParseStaticRoute("192.168.128.0/17", "172.17.0.1")
+	require.Nil(t, err)
+	require.Equal(t, []byte{17, 192, 168, 128, 172, 17, 0, 1}, route.Bytes())
+
+	defaultRoute, err := ParseStaticRoute("0.0.0.0/0", "172.17.0.254")
+	require.Nil(t, err)
+	require.Equal(t, []byte{0, 172, 17, 0, 254}, defaultRoute.Bytes())
+
+	require.Equal(t, []byte{17, 192, 168, 128, 172, 17, 0, 1, 0, 172, 17, 0, 254}, EncodeStaticRoutes([]StaticRoute{route, defaultRoute}))
+
+	_, err = ParseStaticRoute("10.0.0.0", "172.17.0.1")
+	require.NotNil(t, err)
+
+	_, err = ParseStaticRoute("10.0.0.0/8", "bogus")
+	require.NotNil(t, err)
+}
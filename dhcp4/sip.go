@@ -0,0 +1,168 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+//
+// SIP servers pushed to VoIP clients via option 120 (RFC 3361), either as a
+// list of domain names or a list of IPs. The first byte of the option says
+// which, so the two can't be mixed
+//
+
+const (
+	SIP_ENC_DOMAINS = 0
+	SIP_ENC_IPS     = 1
+)
+
+type SipServers struct {
+	Domains []string
+	IPs     []net.IP
+}
+
+// Servers all given as IPs or all as domain names
+func ParseSipServers(servers []string) (SipServers, error) {
+	var sip SipServers
+	for _, server := range servers {
+		if ip := net.ParseIP(server); ip != nil {
+			if ip.To4() == nil {
+				return SipServers{}, fmt.Errorf("SIP server %v is not v4", server)
+			}
+			sip.IPs = append(sip.IPs, ip.To4())
+		} else {
+			if err := validDomainName(server); err != nil {
+				return SipServers{}, err
+			}
+			sip.Domains = append(sip.Domains, server)
+		}
+	}
+	if len(sip.IPs) != 0 && len(sip.Domains) != 0 {
+		return SipServers{}, errors.New("SIP servers must be either all IPs or all domain names")
+	}
+	return sip, nil
+}
+
+func (s SipServers) Empty() bool {
+	return len(s.Domains) == 0 && len(s.IPs) == 0
+}
+
+func (s SipServers) Bytes() []byte {
+	if len(s.IPs) != 0 {
+		b := []byte{SIP_ENC_IPS}
+		for _, ip := range s.IPs {
+			b = append(b, ip.To4()...)
+		}
+		return b
+	}
+	return append([]byte{SIP_ENC_DOMAINS}, EncodeDomainNames(s.Domains)...)
+}
+
+// Parse option 120, as it would come from another server
+func DecodeSipServers(data []byte) (SipServers, error) {
+	if len(data) < 2 {
+		return SipServers{}, errors.New("SIP servers option too short")
+	}
+	var sip SipServers
+	switch data[0] {
+	case SIP_ENC_DOMAINS:
+		domains, err := DecodeDomainNames(data[1:])
+		if err != nil {
+			return SipServers{}, err
+		}
+		sip.Domains = domains
+	case SIP_ENC_IPS:
+		if (len(data)-1)%4 != 0 {
+			return SipServers{}, errors.New("SIP server IPs are not a multiple of 4 bytes")
+		}
+		for i := 1; i < len(data); i += 4 {
+			sip.IPs = append(sip.IPs, net.IP(append([]byte(nil), data[i:i+4]...)))
+		}
+	default:
+		return SipServers{}, fmt.Errorf("Unknown SIP server encoding %v", data[0])
+	}
+	return sip, nil
+}
+
+// Names in RFC 1035 wire format, as length prefixed labels ending with an
+// empty one. We never compress them
+func EncodeDomainNames(names []string) []byte {
+	var b []byte
+	for _, name := range names {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Names in RFC 1035 wire format, following compression pointers to
+// earlier names in the same data
+func DecodeDomainNames(data []byte) ([]string, error) {
+	var names []string
+	for i := 0; i < len(data); {
+		name, next, err := decodeDomainName(data, i)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		i = next
+	}
+	return names, nil
+}
+
+// The name at offset i, and the offset just past it
+func decodeDomainName(data []byte, i int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if i >= len(data) {
+			return "", 0, errors.New("Truncated domain name")
+		}
+		n := int(data[i])
+		switch {
+		case n == 0:
+			if next == -1 {
+				next = i + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case n&0xc0 == 0xc0:
+			if i+1 >= len(data) {
+				return "", 0, errors.New("Truncated domain name pointer")
+			}
+			if next == -1 {
+				next = i + 2
+			}
+			// Pointers only go backwards, but a chain of them could loop
+			if jumps++; jumps > len(data) {
+				return "", 0, errors.New("Domain name pointer loop")
+			}
+			i = (n&0x3f)<<8 | int(data[i+1])
+		case n > 63:
+			return "", 0, fmt.Errorf("Invalid domain name label length %v", n)
+		default:
+			if i+1+n > len(data) {
+				return "", 0, errors.New("Truncated domain name label")
+			}
+			labels = append(labels, string(data[i+1:i+1+n]))
+			i += 1 + n
+		}
+	}
+}
+
+func validDomainName(name string) error {
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == "" || len(trimmed) > 253 {
+		return fmt.Errorf("Invalid domain name '%v'", name)
+	}
+	for _, label := range strings.Split(trimmed, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("Invalid domain name '%v'", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+)
+
+func TestSipServers(t *testing.T) {
+	sip, err := ParseSipServers([]string{"sip.example.com", "sip2.example.com."})
+	require.Nil(t, err)
+	data := sip.Bytes()
+	require.Equal(t, append([]byte{SIP_ENC_DOMAINS, 3, 's', 'i', 'p', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		4, 's', 'i', 'p', '2'}, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0), data)
+	require.Equal(t, "sip.example.com, sip2.example.com", FormatOption(OPTION_SIP_SERVERS, data))
+
+	sip, err = ParseSipServers([]string{"10.0.0.5", "10.0.0.6"})
+	require.Nil(t, err)
+	data = sip.Bytes()
+	require.Equal(t, []byte{SIP_ENC_IPS, 10, 0, 0, 5, 10, 0, 0, 6}, data)
+	decoded, err := DecodeSipServers(data)
+	require.Nil(t, err)
+	require.Equal(t, []net.IP{net.IP{10, 0, 0, 5}, net.IP{10, 0, 0, 6}}, decoded.IPs)
+
+	// As other servers may send them, with the second name compressed to
+	// point at the first's domain
+	decoded, err = DecodeSipServers([]byte{SIP_ENC_DOMAINS, 1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, 1, 'b', 0xc0, 2})
+	require.Nil(t, err)
+	require.Equal(t, []string{"a.example", "b.example"}, decoded.Domains)
+
+	for _, bad := range [][]byte{
+		{SIP_ENC_IPS, 10, 0, 0},
+		{2, 10, 0, 0, 5},
+		{SIP_ENC_DOMAINS, 3, 'a'},
+		{SIP_ENC_DOMAINS, 0xc0, 0},
+	} {
+		_, err = DecodeSipServers(bad)
+		require.NotNil(t, err)
+	}
+
+	for _, bad := range [][]string{{"10.0.0.5", "sip.example.com"}, {"::1"}, {"sip..example.com"}} {
+		_, err = ParseSipServers(bad)
+		require.NotNil(t, err)
+	}
+}
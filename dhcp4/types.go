@@ -0,0 +1,199 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//
+// net.IPNet suitable for a map key
+//
+type HashableIpNet struct {
+	IP   FixedV4
+	Mask FixedV4
+}
+
+func IpNet2HashableIpNet(inet *net.IPNet) (HashableIpNet, error) {
+	var result HashableIpNet
+	if len(inet.Mask) != net.IPv4len {
+		return result, errors.New("Not v4")
+	}
+	result.IP = IpToFixedV4(inet.IP)
+	result.Mask = FixedV4(binary.BigEndian.Uint32(inet.Mask))
+	return result, nil
+}
+
+//
+// Fixed-width big-endian integer to keep track of IPv4 IPs, as they appear over the wire
+//
+type FixedV4 uint32
+
+func (v4 FixedV4) String() string {
+	ip := long2ip(uint32(v4))
+	return fmt.Sprintf("%d.%d.%d.%d", ip[0], ip[1], ip[2], ip[3])
+}
+
+func (v4 FixedV4) Bytes() []byte {
+	return long2bytes(uint32(v4))
+}
+
+func (v4 FixedV4) Long() uint32 {
+	return uint32(v4)
+}
+
+func (v4 FixedV4) NetIp() net.IP {
+	return long2ip(uint32(v4))
+}
+
+func (v4 FixedV4) Empty() bool {
+	return uint32(v4) == 0
+}
+
+func IpToFixedV4(ip net.IP) FixedV4 {
+	b := ip.To4()
+	return FixedV4(binary.BigEndian.Uint32(b[0:4]))
+}
+
+func BytesToFixedV4(b []byte) (FixedV4, error) {
+	if len(b) != 4 {
+		return 0, errors.New("Incorrect length")
+	}
+	return FixedV4(binary.BigEndian.Uint32(b[0:4])), nil
+}
+
+//
+// Fixed-width byte array for mac addresses, as they appear over the wire
+//
+type MacAddress [6]byte
+
+func (m MacAddress) String() string {
+	return fmt.Sprintf("%x:%x:%x:%x:%x:%x", m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+func StrToMac(str string) MacAddress {
+	var m MacAddress
+
+	parts := strings.Split(str, ":")
+	if len(parts) != 6 {
+		return m
+	}
+
+	parsePart := func(s string) byte {
+		if n, err := strconv.ParseUint(s, 16, 8); err == nil {
+			return byte(n)
+		}
+		return 0
+	}
+
+	m = MacAddress{
+		parsePart(parts[0]),
+		parsePart(parts[1]),
+		parsePart(parts[2]),
+		parsePart(parts[3]),
+		parsePart(parts[4]),
+		parsePart(parts[5]),
+	}
+
+	return m
+}
+
+//
+// Hardware address of any type, up to the 16 bytes chaddr holds, which is
+// how clients are told apart. Comparable, so it can key maps. Ethernet
+// addresses are written like MacAddress, and others with their hardware
+// type first, as in 6-0:1c:42:b4:6e:1d or 24-0:1:2:3:4:5:6:7
+//
+type HardwareAddr struct {
+	htype byte
+	hlen  byte
+	addr  [MAX_HLEN]byte
+}
+
+func NewHardwareAddr(htype byte, addr []byte) HardwareAddr {
+	a := HardwareAddr{htype: htype}
+	a.hlen = byte(copy(a.addr[:], addr))
+	return a
+}
+
+func (m MacAddress) Hardware() HardwareAddr {
+	return NewHardwareAddr(HTYPE_ETHERNET, m[:])
+}
+
+func (a HardwareAddr) Type() byte {
+	return a.htype
+}
+
+func (a HardwareAddr) Len() int {
+	return int(a.hlen)
+}
+
+func (a HardwareAddr) Bytes() []byte {
+	return a.addr[:a.hlen]
+}
+
+// No address, or one of all zeroes as sent by clients without one
+func (a HardwareAddr) Empty() bool {
+	return a.addr == [MAX_HLEN]byte{}
+}
+
+// The address as a mac, if it's 6 bytes long like ethernet's are
+func (a HardwareAddr) Mac() (MacAddress, bool) {
+	var m MacAddress
+	if a.hlen != 6 {
+		return m, false
+	}
+	copy(m[:], a.addr[:])
+	return m, true
+}
+
+func (a HardwareAddr) String() string {
+	if m, ok := a.Mac(); ok && a.htype == HTYPE_ETHERNET {
+		return m.String()
+	}
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(a.htype)))
+	b.WriteByte('-')
+	for i, octet := range a.addr[:a.hlen] {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(strconv.FormatUint(uint64(octet), 16))
+	}
+	return b.String()
+}
+
+func ParseHardwareAddr(str string) (HardwareAddr, error) {
+	htype := HTYPE_ETHERNET
+	if before, after, ok := strings.Cut(str, "-"); ok {
+		n, err := strconv.ParseUint(before, 10, 8)
+		if err != nil || n == 0 {
+			return HardwareAddr{}, fmt.Errorf("Invalid hardware type in '%v'", str)
+		}
+		htype, str = byte(n), after
+	}
+
+	var addr []byte
+	if str != "" {
+		for _, part := range strings.Split(str, ":") {
+			n, err := strconv.ParseUint(part, 16, 8)
+			if err != nil {
+				return HardwareAddr{}, fmt.Errorf("Invalid hardware address '%v'", str)
+			}
+			addr = append(addr, byte(n))
+		}
+	}
+	if len(addr) > MAX_HLEN || (htype == HTYPE_ETHERNET && len(addr) != 6) {
+		return HardwareAddr{}, fmt.Errorf("Invalid hardware address '%v'", str)
+	}
+	return NewHardwareAddr(htype, addr), nil
+}
+
+// Like StrToMac, the zero address if str isn't one
+func StrToHardwareAddr(str string) HardwareAddr {
+	a, _ := ParseHardwareAddr(str)
+	return a
+}
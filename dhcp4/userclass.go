@@ -0,0 +1,30 @@
+package dhcp4
+
+//
+// User classes (option 77, RFC 3004), which clients can be configured with
+// to say what they're for, such as a site or a role. Each is length
+// prefixed, though some clients, like Windows and iPXE, send one unprefixed
+// string instead, which is taken as is
+//
+
+func ParseUserClasses(data []byte) []string {
+	var classes []string
+	for rest := data; len(rest) > 0; {
+		n := int(rest[0])
+		if n == 0 || 1+n > len(rest) {
+			return []string{string(data)}
+		}
+		classes = append(classes, string(rest[1:1+n]))
+		rest = rest[1+n:]
+	}
+	return classes
+}
+
+func EncodeUserClasses(classes []string) []byte {
+	var b []byte
+	for _, class := range classes {
+		b = append(b, byte(len(class)))
+		b = append(b, class...)
+	}
+	return b
+}
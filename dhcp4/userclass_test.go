@@ -0,0 +1,19 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestUserClasses(t *testing.T) {
+	data := EncodeUserClasses([]string{"iot", "building-a"})
+	require.Equal(t, []byte{3, 'i', 'o', 't', 10, 'b', 'u', 'i', 'l', 'd', 'i', 'n', 'g', '-', 'a'}, data)
+	require.Equal(t, []string{"iot", "building-a"}, ParseUserClasses(data))
+	require.Equal(t, "iot, building-a", FormatOption(OPTION_USER_CLASS, data))
+
+	// Unprefixed, as Windows and iPXE send it
+	require.Equal(t, []string{"iPXE"}, ParseUserClasses([]byte("iPXE")))
+	require.Equal(t, []string{"\x00ab"}, ParseUserClasses([]byte("\x00ab")))
+	require.Nil(t, ParseUserClasses(nil))
+}
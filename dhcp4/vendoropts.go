@@ -0,0 +1,104 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//
+// Vendor-identifying options from RFC 3925, which scope vendor classes (124)
+// and vendor specific information (125) by IANA enterprise number, so that
+// one client may identify as, and be provisioned for, several vendors at
+// once
+//
+
+// Vendor class data from option 124 for a single enterprise
+type VendorClass struct {
+	Enterprise uint32
+	Data       [][]byte
+}
+
+// Sub-options from option 125 for a single enterprise
+type VendorOptions struct {
+	Enterprise uint32
+	Options    []CustomOption
+}
+
+// Enterprise number, length, then a length prefixed item per vendor class
+func ParseVendorClasses(data []byte) ([]VendorClass, error) {
+	var classes []VendorClass
+	err := parseEnterpriseBlocks(data, func(enterprise uint32, block []byte) error {
+		class := VendorClass{Enterprise: enterprise}
+		for len(block) > 0 {
+			n := int(block[0])
+			if 1+n > len(block) {
+				return errors.New("Truncated vendor class data")
+			}
+			class.Data = append(class.Data, block[1:1+n])
+			block = block[1+n:]
+		}
+		classes = append(classes, class)
+		return nil
+	})
+	return classes, err
+}
+
+func EncodeVendorClasses(classes []VendorClass) []byte {
+	var b []byte
+	for _, class := range classes {
+		var block []byte
+		for _, data := range class.Data {
+			block = append(block, byte(len(data)))
+			block = append(block, data...)
+		}
+		b = appendEnterpriseBlock(b, class.Enterprise, block)
+	}
+	return b
+}
+
+// Enterprise number, length, then sub-options encoded like option 43's
+func ParseVendorOptions(data []byte) ([]VendorOptions, error) {
+	var vendors []VendorOptions
+	err := parseEnterpriseBlocks(data, func(enterprise uint32, block []byte) error {
+		vendor := VendorOptions{Enterprise: enterprise}
+		for len(block) > 0 {
+			if len(block) < 2 || 2+int(block[1]) > len(block) {
+				return errors.New("Truncated vendor sub-option")
+			}
+			n := int(block[1])
+			vendor.Options = append(vendor.Options, CustomOption{block[0], block[2 : 2+n]})
+			block = block[2+n:]
+		}
+		vendors = append(vendors, vendor)
+		return nil
+	})
+	return vendors, err
+}
+
+func EncodeVendorOptions(vendors []VendorOptions) []byte {
+	var b []byte
+	for _, vendor := range vendors {
+		b = appendEnterpriseBlock(b, vendor.Enterprise, EncapsulateOptions(vendor.Options))
+	}
+	return b
+}
+
+func parseEnterpriseBlocks(data []byte, f func(enterprise uint32, block []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 5 || 5+int(data[4]) > len(data) {
+			return errors.New("Truncated enterprise data")
+		}
+		n := int(data[4])
+		if err := f(binary.BigEndian.Uint32(data), data[5:5+n]); err != nil {
+			return err
+		}
+		data = data[5+n:]
+	}
+	return nil
+}
+
+func appendEnterpriseBlock(b []byte, enterprise uint32, block []byte) []byte {
+	b = append(b, long2bytes(enterprise)...)
+	b = append(b, byte(len(block)))
+	return append(b, block...)
+}
@@ -0,0 +1,47 @@
+package dhcp4
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestVendorOptions(t *testing.T) {
+	// A cable modem identifying as both CableLabs and another vendor
+	classes := []VendorClass{
+		{Enterprise: 4491, Data: [][]byte{[]byte("docsis3.0")}},
+		{Enterprise: 3561, Data: [][]byte{[]byte("a"), []byte("bc")}},
+	}
+	data := EncodeVendorClasses(classes)
+	require.Equal(t, []byte{
+		0, 0, 0x11, 0x8b, 10, 9, 'd', 'o', 'c', 's', 'i', 's', '3', '.', '0',
+		0, 0, 0x0d, 0xe9, 5, 1, 'a', 2, 'b', 'c',
+	}, data)
+	parsed, err := ParseVendorClasses(data)
+	require.Nil(t, err)
+	require.Equal(t, classes, parsed)
+	require.Equal(t, "4491: docsis3.0; 3561: a, bc", FormatOption(OPTION_VI_CLASS, data))
+
+	vendors := []VendorOptions{
+		{Enterprise: 3561, Options: []CustomOption{{1, []byte{10, 0, 0, 5}}, {2, []byte("acs")}}},
+	}
+	data = EncodeVendorOptions(vendors)
+	require.Equal(t, []byte{0, 0, 0x0d, 0xe9, 11, 1, 4, 10, 0, 0, 5, 2, 3, 'a', 'c', 's'}, data)
+	parsedOptions, err := ParseVendorOptions(data)
+	require.Nil(t, err)
+	require.Equal(t, vendors, parsedOptions)
+	require.Equal(t, "3561: 1=0a000005, 2=616373", FormatOption(OPTION_VI_INFO, data))
+
+	// Lengths running past the end
+	for _, bad := range [][]byte{
+		{0, 0, 0x0d},
+		{0, 0, 0x0d, 0xe9, 3, 1},
+		{0, 0, 0x0d, 0xe9, 2, 5, 'a'},
+	} {
+		_, err = ParseVendorClasses(bad)
+		require.NotNil(t, err)
+	}
+	_, err = ParseVendorOptions([]byte{0, 0, 0x0d, 0xe9, 3, 1, 4, 10})
+	require.NotNil(t, err)
+	require.Equal(t, "00000de9030104", FormatOption(OPTION_VI_INFO, []byte{0, 0, 0x0d, 0xe9, 3, 1, 4}))
+}
@@ -0,0 +1,6 @@
+// Package dhcp6 parses and encodes the parts of DHCPv6 (RFC 8415) messages
+// the server will need to serve IPv6 clients, starting with the relay
+// messages clients behind relays arrive in, and building and checking
+// authenticated Reconfigure messages.
+// Like dhcp4 it has no dependencies on the rest of the server.
+package dhcp6
@@ -0,0 +1,52 @@
+package dhcp6
+
+import (
+	"errors"
+)
+
+//
+// Client and server messages (RFC 8415 section 8): a message type, a 3 byte
+// transaction id, then options. Relay messages have their own header, see
+// relay.go
+//
+
+const (
+	MSG_SOLICIT             = 1
+	MSG_ADVERTISE           = 2
+	MSG_REQUEST             = 3
+	MSG_CONFIRM             = 4
+	MSG_RENEW               = 5
+	MSG_REBIND              = 6
+	MSG_REPLY               = 7
+	MSG_RELEASE             = 8
+	MSG_DECLINE             = 9
+	MSG_RECONFIGURE         = 10
+	MSG_INFORMATION_REQUEST = 11
+)
+
+type Message struct {
+	Type          byte
+	TransactionId [3]byte
+	Options       Options
+}
+
+func DecodeMessage(data []byte) (*Message, error) {
+	if len(data) < 4 {
+		return nil, errors.New("Message too short")
+	}
+	if data[0] == MSG_RELAY_FORW || data[0] == MSG_RELAY_REPL {
+		return nil, errors.New("Message is a relay message")
+	}
+	options, err := ParseOptions(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Type: data[0], Options: options}
+	copy(m.TransactionId[:], data[1:4])
+	return m, nil
+}
+
+func (m *Message) Bytes() []byte {
+	b := append([]byte{m.Type}, m.TransactionId[:]...)
+	return append(b, m.Options.Bytes()...)
+}
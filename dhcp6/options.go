@@ -0,0 +1,64 @@
+package dhcp6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//
+// DHCPv6 options, each a 16 bit code and length followed by its data. Unlike
+// DHCPv4's some may appear more than once, so they're kept in order as a
+// list
+//
+
+const (
+	OPTION_CLIENT_ID    = 1
+	OPTION_SERVER_ID    = 2
+	OPTION_RELAY_MSG    = 9
+	OPTION_INTERFACE_ID = 18
+	OPTION_REMOTE_ID    = 37
+)
+
+type Option struct {
+	Code uint16
+	Data []byte
+}
+
+type Options []Option
+
+func ParseOptions(data []byte) (Options, error) {
+	var options Options
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("Truncated option header")
+		}
+		code, length := binary.BigEndian.Uint16(data), int(binary.BigEndian.Uint16(data[2:]))
+		if 4+length > len(data) {
+			return nil, fmt.Errorf("Truncated option %v", code)
+		}
+		options = append(options, Option{code, data[4 : 4+length]})
+		data = data[4+length:]
+	}
+	return options, nil
+}
+
+// The first option with this code
+func (o Options) Get(code uint16) ([]byte, bool) {
+	for _, option := range o {
+		if option.Code == code {
+			return option.Data, true
+		}
+	}
+	return nil, false
+}
+
+func (o Options) Bytes() []byte {
+	var b []byte
+	for _, option := range o {
+		b = binary.BigEndian.AppendUint16(b, option.Code)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(option.Data)))
+		b = append(b, option.Data...)
+	}
+	return b
+}
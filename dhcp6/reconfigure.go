@@ -0,0 +1,187 @@
+package dhcp6
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//
+// Reconfigure (RFC 8415 section 18.3.11), the server telling a client to
+// renew, rebind or ask for information straight away, so changed options
+// reach it without waiting for T1. Only clients that said they accept
+// Reconfigure (option 20) may be sent one, and it must be authenticated
+// with the reconfigure key protocol (section 20.4): the server gives the
+// client a random key in its first Reply, then signs each Reconfigure
+// with an HMAC-MD5 of it keyed by that key.
+//
+// Only the messages and options are here. Nothing sends a Reconfigure yet:
+// that needs a DHCPv6 server to give clients their keys in its Replies, and
+// to keep them and the replay counter with its leases.
+//
+
+const (
+	OPTION_AUTH          = 11
+	OPTION_RECONF_MSG    = 19
+	OPTION_RECONF_ACCEPT = 20
+)
+
+// The authentication option's protocol, algorithm and replay detection
+// method for reconfigure keys
+const (
+	AUTH_PROTOCOL_RECONFIGURE_KEY = 3
+	AUTH_ALGORITHM_HMAC_MD5       = 1
+	AUTH_RDM_MONOTONIC            = 0
+)
+
+// What the authentication information of a reconfigure key option holds
+const (
+	RECONFIGURE_KEY_VALUE = 1
+	RECONFIGURE_KEY_HMAC  = 2
+)
+
+type ReconfigureKey [16]byte
+
+// Protocol, algorithm, RDM, replay detection and the information type
+const authHeaderLen = 1 + 1 + 1 + 8 + 1
+
+type Authentication struct {
+	Protocol  byte
+	Algorithm byte
+	RDM       byte
+
+	// A counter the server increases with every message it authenticates,
+	// so clients can tell a replayed one
+	ReplayDetection uint64
+
+	Info []byte
+}
+
+func NewReconfigureKey() (ReconfigureKey, error) {
+	var key ReconfigureKey
+	_, err := rand.Read(key[:])
+	return key, err
+}
+
+func DecodeAuthentication(data []byte) (*Authentication, error) {
+	if len(data) < 11 {
+		return nil, errors.New("Authentication option too short")
+	}
+	return &Authentication{
+		Protocol:        data[0],
+		Algorithm:       data[1],
+		RDM:             data[2],
+		ReplayDetection: binary.BigEndian.Uint64(data[3:11]),
+		Info:            append([]byte(nil), data[11:]...),
+	}, nil
+}
+
+func (a *Authentication) Bytes() []byte {
+	b := []byte{a.Protocol, a.Algorithm, a.RDM}
+	b = binary.BigEndian.AppendUint64(b, a.ReplayDetection)
+	return append(b, a.Info...)
+}
+
+func reconfigureKeyAuth(infoType byte, value []byte, replay uint64) Option {
+	auth := &Authentication{
+		Protocol:        AUTH_PROTOCOL_RECONFIGURE_KEY,
+		Algorithm:       AUTH_ALGORITHM_HMAC_MD5,
+		RDM:             AUTH_RDM_MONOTONIC,
+		ReplayDetection: replay,
+		Info:            append([]byte{infoType}, value...),
+	}
+	return Option{OPTION_AUTH, auth.Bytes()}
+}
+
+// The option giving a client its key, for the Reply to its Request, or to
+// its Solicit with rapid commit
+func ReconfigureKeyOption(key ReconfigureKey, replay uint64) Option {
+	return reconfigureKeyAuth(RECONFIGURE_KEY_VALUE, key[:], replay)
+}
+
+// Whether the client sent Reconfigure Accept, and may be sent Reconfigure
+func (m *Message) AcceptsReconfigure() bool {
+	_, ok := m.Options.Get(OPTION_RECONF_ACCEPT)
+	return ok
+}
+
+// A signed Reconfigure for the client with this DUID, telling it to send
+// msgType: Renew, Rebind or Information-request. Replay must be higher
+// than any the client has seen from us
+func NewReconfigure(serverId, clientId []byte, msgType byte, key ReconfigureKey, replay uint64) ([]byte, error) {
+	switch msgType {
+	case MSG_RENEW, MSG_REBIND, MSG_INFORMATION_REQUEST:
+	default:
+		return nil, fmt.Errorf("Clients can't be reconfigured to send message type %v", msgType)
+	}
+	// Reconfigure has no transaction, so its id is zero
+	m := &Message{
+		Type: MSG_RECONFIGURE,
+		Options: Options{
+			{OPTION_SERVER_ID, serverId},
+			{OPTION_CLIENT_ID, clientId},
+			{OPTION_RECONF_MSG, []byte{msgType}},
+			reconfigureKeyAuth(RECONFIGURE_KEY_HMAC, make([]byte, md5.Size), replay),
+		},
+	}
+	data := m.Bytes()
+	// The HMAC is of the whole message with its own field zeroed, and ends
+	// it, as the authentication option is last
+	copy(data[len(data)-md5.Size:], reconfigureHmac(data, key))
+	return data, nil
+}
+
+func reconfigureHmac(data []byte, key ReconfigureKey) []byte {
+	mac := hmac.New(md5.New, key[:])
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Check a Reconfigure was signed with the key, as clients do, returning the
+// message type it asks for and its replay detection counter
+func VerifyReconfigure(data []byte, key ReconfigureKey) (byte, uint64, error) {
+	m, err := DecodeMessage(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	if m.Type != MSG_RECONFIGURE {
+		return 0, 0, fmt.Errorf("Message type %v is not Reconfigure", m.Type)
+	}
+	msgType, ok := m.Options.Get(OPTION_RECONF_MSG)
+	if !ok || len(msgType) != 1 {
+		return 0, 0, errors.New("Reconfigure without a reconfigure message option")
+	}
+	authData, ok := m.Options.Get(OPTION_AUTH)
+	if !ok {
+		return 0, 0, errors.New("Reconfigure not authenticated")
+	}
+	auth, err := DecodeAuthentication(authData)
+	if err != nil {
+		return 0, 0, err
+	}
+	if auth.Protocol != AUTH_PROTOCOL_RECONFIGURE_KEY || auth.Algorithm != AUTH_ALGORITHM_HMAC_MD5 ||
+		len(auth.Info) != 1+md5.Size || auth.Info[0] != RECONFIGURE_KEY_HMAC {
+		return 0, 0, errors.New("Reconfigure not authenticated with a reconfigure key")
+	}
+	// Zero the HMAC where it sits in the message to compute it again
+	offset := optionOffset(data[4:], OPTION_AUTH) + 4 + authHeaderLen
+	zeroed := append([]byte(nil), data...)
+	copy(zeroed[offset:offset+md5.Size], make([]byte, md5.Size))
+	if !hmac.Equal(auth.Info[1:], reconfigureHmac(zeroed, key)) {
+		return 0, 0, errors.New("Reconfigure HMAC doesn't match")
+	}
+	return msgType[0], auth.ReplayDetection, nil
+}
+
+// Where the data of the first option with this code starts, of options
+// already parsed
+func optionOffset(data []byte, code uint16) int {
+	offset := 0
+	for binary.BigEndian.Uint16(data[offset:]) != code {
+		offset += 4 + int(binary.BigEndian.Uint16(data[offset+2:]))
+	}
+	return offset + 4
+}
@@ -0,0 +1,58 @@
+package dhcp6
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestReconfigure(t *testing.T) {
+	serverId := []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 1}
+	clientId := []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 2}
+
+	request := &Message{
+		Type:          MSG_REQUEST,
+		TransactionId: [3]byte{1, 2, 3},
+		Options:       Options{{OPTION_CLIENT_ID, clientId}, {OPTION_RECONF_ACCEPT, nil}},
+	}
+	decoded, err := DecodeMessage(request.Bytes())
+	require.Nil(t, err)
+	require.Equal(t, request.TransactionId, decoded.TransactionId)
+	require.True(t, decoded.AcceptsReconfigure())
+
+	// The client learns its key from our Reply
+	key, err := NewReconfigureKey()
+	require.Nil(t, err)
+	auth, err := DecodeAuthentication(ReconfigureKeyOption(key, 1).Data)
+	require.Nil(t, err)
+	require.Equal(t, byte(AUTH_PROTOCOL_RECONFIGURE_KEY), auth.Protocol)
+	require.Equal(t, uint64(1), auth.ReplayDetection)
+	require.Equal(t, append([]byte{RECONFIGURE_KEY_VALUE}, key[:]...), auth.Info)
+
+	data, err := NewReconfigure(serverId, clientId, MSG_RENEW, key, 2)
+	require.Nil(t, err)
+	m, err := DecodeMessage(data)
+	require.Nil(t, err)
+	require.Equal(t, byte(MSG_RECONFIGURE), m.Type)
+	id, _ := m.Options.Get(OPTION_CLIENT_ID)
+	require.Equal(t, clientId, id)
+	msgType, replay, err := VerifyReconfigure(data, key)
+	require.Nil(t, err)
+	require.Equal(t, byte(MSG_RENEW), msgType)
+	require.Equal(t, uint64(2), replay)
+
+	// Signed with another key, or tampered with
+	other, _ := NewReconfigureKey()
+	_, _, err = VerifyReconfigure(data, other)
+	require.NotNil(t, err)
+	data[8] ^= 1
+	_, _, err = VerifyReconfigure(data, key)
+	require.NotNil(t, err)
+
+	_, err = NewReconfigure(serverId, clientId, MSG_SOLICIT, key, 3)
+	require.NotNil(t, err)
+	_, _, err = VerifyReconfigure(request.Bytes(), key)
+	require.NotNil(t, err)
+	_, err = DecodeMessage([]byte{MSG_RELAY_FORW, 0, 0, 0})
+	require.NotNil(t, err)
+}
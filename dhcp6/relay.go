@@ -0,0 +1,148 @@
+package dhcp6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+//
+// Relay messages (RFC 8415 section 9), which relays wrap client messages in
+// on their way to the server, each relay along the path adding a layer, and
+// which the server wraps its reply in to send back the same way. Relays say
+// which of their interfaces (18) and which subscriber (37, RFC 4649) a
+// client is on, and need the interface id echoed back to forward the reply.
+//
+
+const (
+	MSG_RELAY_FORW = 12
+	MSG_RELAY_REPL = 13
+)
+
+// Most relays a message may come through, as relays refuse to forward
+// messages with a higher hop count
+const HOP_COUNT_LIMIT = 8
+
+// Message type, hop count, link address and peer address
+const relayHeaderLen = 1 + 1 + 16 + 16
+
+type RelayMessage struct {
+	Type     byte
+	HopCount byte
+
+	// Address identifying the link the client is on, if the relay has one
+	// there, and the address of the client or relay it came from
+	LinkAddr net.IP
+	PeerAddr net.IP
+
+	Options Options
+}
+
+func DecodeRelayMessage(data []byte) (*RelayMessage, error) {
+	if len(data) < relayHeaderLen {
+		return nil, errors.New("Relay message too short")
+	}
+	if data[0] != MSG_RELAY_FORW && data[0] != MSG_RELAY_REPL {
+		return nil, fmt.Errorf("Message type %v is not a relay message", data[0])
+	}
+	options, err := ParseOptions(data[relayHeaderLen:])
+	if err != nil {
+		return nil, err
+	}
+	return &RelayMessage{
+		Type:     data[0],
+		HopCount: data[1],
+		LinkAddr: net.IP(append([]byte(nil), data[2:18]...)),
+		PeerAddr: net.IP(append([]byte(nil), data[18:34]...)),
+		Options:  options,
+	}, nil
+}
+
+func (m *RelayMessage) Bytes() []byte {
+	b := make([]byte, relayHeaderLen, relayHeaderLen+64)
+	b[0], b[1] = m.Type, m.HopCount
+	copy(b[2:18], m.LinkAddr.To16())
+	copy(b[18:34], m.PeerAddr.To16())
+	return append(b, m.Options.Bytes()...)
+}
+
+// The message the relay carries, a client's or another relay's
+func (m *RelayMessage) Inner() ([]byte, bool) {
+	return m.Options.Get(OPTION_RELAY_MSG)
+}
+
+// The relay's id for the interface the message came in on, if it gave one
+func (m *RelayMessage) InterfaceId() ([]byte, bool) {
+	return m.Options.Get(OPTION_INTERFACE_ID)
+}
+
+// The enterprise number of the relay's vendor and its id for the client's
+// subscriber line, if it gave one
+func (m *RelayMessage) RemoteId() (uint32, []byte, bool) {
+	data, ok := m.Options.Get(OPTION_REMOTE_ID)
+	if !ok || len(data) < 5 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint32(data), data[4:], true
+}
+
+// The relays a client message came through, outermost, the one which sent it
+// to us, first
+type RelayChain []*RelayMessage
+
+// Peel the relay layers off a message, giving the relays and the client's
+// message inside them. Messages which didn't come through a relay have no
+// relays
+func Unwrap(data []byte) (RelayChain, []byte, error) {
+	var chain RelayChain
+	for len(data) > 0 && data[0] == MSG_RELAY_FORW {
+		if len(chain) >= HOP_COUNT_LIMIT {
+			return nil, nil, errors.New("Too many relays")
+		}
+		relay, err := DecodeRelayMessage(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		inner, ok := relay.Inner()
+		if !ok {
+			return nil, nil, errors.New("Relay message without a relayed message")
+		}
+		chain = append(chain, relay)
+		data = inner
+	}
+	if len(data) == 0 {
+		return nil, nil, errors.New("Empty message")
+	}
+	return chain, data, nil
+}
+
+// Wrap a reply to the client in a relay reply for each relay it came
+// through, echoing their link and peer addresses and interface ids so each
+// knows where to send it on to
+func (c RelayChain) Wrap(reply []byte) []byte {
+	for i := len(c) - 1; i >= 0; i-- {
+		relay := c[i]
+		wrapped := &RelayMessage{
+			Type:     MSG_RELAY_REPL,
+			HopCount: relay.HopCount,
+			LinkAddr: relay.LinkAddr,
+			PeerAddr: relay.PeerAddr,
+		}
+		if id, ok := relay.InterfaceId(); ok {
+			wrapped.Options = append(wrapped.Options, Option{OPTION_INTERFACE_ID, id})
+		}
+		wrapped.Options = append(wrapped.Options, Option{OPTION_RELAY_MSG, reply})
+		reply = wrapped.Bytes()
+	}
+	return reply
+}
+
+// The relay nearest the client, whose interface and remote ids say where the
+// client is
+func (c RelayChain) Nearest() (*RelayMessage, bool) {
+	if len(c) == 0 {
+		return nil, false
+	}
+	return c[len(c)-1], true
+}
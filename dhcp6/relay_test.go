@@ -0,0 +1,92 @@
+package dhcp6
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+)
+
+func TestRelayMessages(t *testing.T) {
+	solicit := []byte{1, 0xaa, 0xbb, 0xcc, 0, OPTION_CLIENT_ID, 0, 2, 0xde, 0xad}
+
+	// Through a switch, then an aggregation router
+	access := &RelayMessage{
+		Type:     MSG_RELAY_FORW,
+		LinkAddr: net.ParseIP("2001:db8:1::1"),
+		PeerAddr: net.ParseIP("fe80::1"),
+		Options: Options{
+			{OPTION_INTERFACE_ID, []byte("Gi1/0/7")},
+			{OPTION_REMOTE_ID, []byte{0, 0, 0, 9, 's', 'u', 'b', '1'}},
+			{OPTION_RELAY_MSG, solicit},
+		},
+	}
+	aggregation := &RelayMessage{
+		Type:     MSG_RELAY_FORW,
+		HopCount: 1,
+		LinkAddr: net.IPv6zero,
+		PeerAddr: net.ParseIP("2001:db8:1::1"),
+		Options:  Options{{OPTION_INTERFACE_ID, []byte("eth3")}, {OPTION_RELAY_MSG, access.Bytes()}},
+	}
+
+	chain, inner, err := Unwrap(aggregation.Bytes())
+	require.Nil(t, err)
+	require.Equal(t, solicit, inner)
+	require.Len(t, chain, 2)
+	nearest, ok := chain.Nearest()
+	require.True(t, ok)
+	id, _ := nearest.InterfaceId()
+	require.Equal(t, []byte("Gi1/0/7"), id)
+	enterprise, remote, ok := nearest.RemoteId()
+	require.True(t, ok)
+	require.Equal(t, uint32(9), enterprise)
+	require.Equal(t, []byte("sub1"), remote)
+	require.True(t, net.ParseIP("fe80::1").Equal(nearest.PeerAddr))
+
+	// The reply goes back through both, with their interface ids but not
+	// the remote id
+	advertise := []byte{2, 0xaa, 0xbb, 0xcc}
+	outer, err := DecodeRelayMessage(chain.Wrap(advertise))
+	require.Nil(t, err)
+	require.Equal(t, byte(MSG_RELAY_REPL), outer.Type)
+	require.Equal(t, byte(1), outer.HopCount)
+	id, _ = outer.InterfaceId()
+	require.Equal(t, []byte("eth3"), id)
+	data, _ := outer.Inner()
+	replyChain, reply, err := Unwrap(data)
+	require.Nil(t, err)
+	require.Empty(t, replyChain)
+	inside, err := DecodeRelayMessage(reply)
+	require.Nil(t, err)
+	_, _, ok = inside.RemoteId()
+	require.False(t, ok)
+	data, _ = inside.Inner()
+	require.Equal(t, advertise, data)
+	require.True(t, net.ParseIP("fe80::1").Equal(inside.PeerAddr))
+
+	// Straight from the client, with no relays
+	chain, inner, err = Unwrap(solicit)
+	require.Nil(t, err)
+	require.Empty(t, chain)
+	require.Equal(t, solicit, chain.Wrap(inner))
+	_, ok = chain.Nearest()
+	require.False(t, ok)
+
+	message := solicit
+	for i := 0; i <= HOP_COUNT_LIMIT; i++ {
+		message = (&RelayMessage{Type: MSG_RELAY_FORW, Options: Options{{OPTION_RELAY_MSG, message}}}).Bytes()
+	}
+	_, _, err = Unwrap(message)
+	require.NotNil(t, err)
+	for _, bad := range [][]byte{
+		{MSG_RELAY_FORW, 0, 1},
+		(&RelayMessage{Type: MSG_RELAY_FORW}).Bytes(),
+		append((&RelayMessage{Type: MSG_RELAY_FORW}).Bytes(), 0, OPTION_RELAY_MSG, 0, 9, 1),
+		(&RelayMessage{Type: MSG_RELAY_FORW, Options: Options{{OPTION_RELAY_MSG, nil}}}).Bytes(),
+	} {
+		_, _, err = Unwrap(bad)
+		require.NotNil(t, err)
+	}
+	_, err = DecodeRelayMessage(solicit)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,58 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// How a pool picks a free IP for a new client. The range is searched for a
+// free IP from the point an allocator picks, wrapping around at the end, so
+// an allocator only decides where the search starts.
+//
+
+// Offset into a range of size IPs to start looking for a free one from
+type Allocator func(mac dhcp4.HardwareAddr, size uint32) uint32
+
+// The first free IP in the range, as we always used to
+func SequentialAllocator(mac dhcp4.HardwareAddr, size uint32) uint32 {
+	return 0
+}
+
+// Anywhere in the range, so clients' IPs can't be guessed from the order
+// they turned up in
+func RandomAllocator(mac dhcp4.HardwareAddr, size uint32) uint32 {
+	return rand.Uint32() % size
+}
+
+// An IP picked by the client's hardware address, so it gets the same one
+// each time it's free, even after its lease is gone, without a reservation
+func HashAllocator(mac dhcp4.HardwareAddr, size uint32) uint32 {
+	return hashMac(mac) % size
+}
+
+var allocators = map[string]Allocator{
+	"sequential": SequentialAllocator,
+	"random":     RandomAllocator,
+	"hash":       HashAllocator,
+}
+
+// Allocator by its name in configuration
+func AllocatorByName(name string) (Allocator, error) {
+	if allocator, ok := allocators[name]; ok {
+		return allocator, nil
+	}
+	return nil, fmt.Errorf("Unknown allocation strategy '%v'", name)
+}
+
+// FNV-1a
+func hashMac(mac dhcp4.HardwareAddr) uint32 {
+	h := uint32(2166136261)
+	for _, b := range mac.Bytes() {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
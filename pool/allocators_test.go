@@ -0,0 +1,60 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestAllocators(t *testing.T) {
+	mac := dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware()
+	start := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10"))
+
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.Allocator = HashAllocator
+	lease, err := pool.GetNextLease(mac, "")
+	require.Nil(t, err)
+	hashed := lease.IP
+	require.Equal(t, start+dhcp4.FixedV4(HashAllocator(mac, 11)), hashed)
+
+	// The same IP again once the lease is gone, even from another pool
+	_, ok := pool.ReleaseLeaseByMac(mac)
+	require.True(t, ok)
+	lease, err = pool.GetNextLease(mac, "")
+	require.Nil(t, err)
+	require.Equal(t, hashed, lease.IP)
+
+	other := newTestPool()
+	other.Allocator = HashAllocator
+	lease, err = other.GetNextLease(mac, "")
+	require.Nil(t, err)
+	require.Equal(t, hashed, lease.IP)
+
+	// Searching wraps around from where the allocator starts
+	full := newTestPool()
+	full.LeaseTime = time.Hour
+	full.Allocator = func(mac dhcp4.HardwareAddr, size uint32) uint32 { return size - 1 }
+	var ips []dhcp4.FixedV4
+	for i := byte(1); i <= 3; i++ {
+		lease, err := full.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware(), "")
+		require.Nil(t, err)
+		ips = append(ips, lease.IP)
+	}
+	require.Equal(t, []dhcp4.FixedV4{start + 10, start, start + 1}, ips)
+
+	random := newTestPool()
+	random.Allocator = RandomAllocator
+	lease, err = random.GetNextLease(mac, "")
+	require.Nil(t, err)
+	require.True(t, random.Contains(lease.IP))
+
+	_, err = AllocatorByName("hash")
+	require.Nil(t, err)
+	_, err = AllocatorByName("bogus")
+	require.NotNil(t, err)
+}
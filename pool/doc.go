@@ -0,0 +1,3 @@
+// Package pool keeps track of the IPs in a range and the leases handed out
+// from it, along with the backends leases are persisted to.
+package pool
@@ -0,0 +1,422 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Lease backend in etcd, for clusters of servers behind anycast or several
+// relays. Every claim is a compare-and-swap transaction on the IP's key, so
+// only one server can ever win it, and leases are attached to etcd leases
+// so they disappear when they expire. We talk to etcd's JSON gateway rather
+// than gRPC.
+//
+
+// Retries when someone else changes a key between us reading and writing it
+const etcdClaimAttempts = 5
+
+type EtcdClient struct {
+	endpoints []string
+	username  string
+	password  string
+	http      *http.Client
+
+	m     sync.Mutex
+	token string
+}
+
+func NewEtcdClient(endpoints []string, username, password string) *EtcdClient {
+	return &EtcdClient{
+		endpoints: endpoints,
+		username:  username,
+		password:  password,
+		http:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type etcdKeyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdCompare struct {
+	Key         []byte `json:"key"`
+	Result      string `json:"result"`
+	Target      string `json:"target"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+type etcdDeleteRequest struct {
+	Key []byte `json:"key"`
+}
+
+type etcdRequestOp struct {
+	Put    *etcdPutRequest    `json:"request_put,omitempty"`
+	Delete *etcdDeleteRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+type etcdGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type etcdGrantResponse struct {
+	ID int64 `json:"ID,string"`
+}
+
+type etcdAuthRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type etcdAuthResponse struct {
+	Token string `json:"token"`
+}
+
+// POST to the first endpoint which answers
+func (c *EtcdClient) call(path string, request, response interface{}) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, endpoint := range c.endpoints {
+		lastErr = c.post(strings.TrimSuffix(endpoint, "/")+path, payload, response)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (c *EtcdClient) post(url string, payload []byte, response interface{}) error {
+	token, err := c.authToken(url)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+
+		// Token may have expired, so get a new one next time
+		if resp.StatusCode == http.StatusUnauthorized {
+			c.m.Lock()
+			c.token = ""
+			c.m.Unlock()
+		}
+		return fmt.Errorf("etcd returned %v: %v", resp.Status, failure.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func (c *EtcdClient) authToken(url string) (string, error) {
+	if c.username == "" {
+		return "", nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.token != "" {
+		return c.token, nil
+	}
+
+	payload, err := json.Marshal(etcdAuthRequest{c.username, c.password})
+	if err != nil {
+		return "", err
+	}
+	authUrl := url[:strings.Index(url, "/v3/")] + "/v3/auth/authenticate"
+	resp, err := c.http.Post(authUrl, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("etcd authentication failed: %v", resp.Status)
+	}
+
+	auth := &etcdAuthResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(auth); err != nil {
+		return "", err
+	}
+	c.token = auth.Token
+	return c.token, nil
+}
+
+func (c *EtcdClient) Get(key string) (*etcdKeyValue, error) {
+	response := &etcdRangeResponse{}
+	if err := c.call("/v3/kv/range", etcdRangeRequest{Key: []byte(key)}, response); err != nil {
+		return nil, err
+	}
+	if len(response.Kvs) == 0 {
+		return nil, nil
+	}
+	return &response.Kvs[0], nil
+}
+
+// Everything under a prefix
+func (c *EtcdClient) GetPrefix(prefix string) ([]etcdKeyValue, error) {
+	end := []byte(prefix)
+	end[len(end)-1]++
+
+	response := &etcdRangeResponse{}
+	if err := c.call("/v3/kv/range", etcdRangeRequest{Key: []byte(prefix), RangeEnd: end}, response); err != nil {
+		return nil, err
+	}
+	return response.Kvs, nil
+}
+
+// Run the operations if none of the keys have changed since the given
+// revisions, with 0 meaning the key doesn't exist
+func (c *EtcdClient) Txn(revisions map[string]int64, ops []etcdRequestOp) (bool, error) {
+	request := etcdTxnRequest{Success: ops}
+	for key, revision := range revisions {
+		request.Compare = append(request.Compare, etcdCompare{
+			Key:         []byte(key),
+			Result:      "EQUAL",
+			Target:      "MOD",
+			ModRevision: revision,
+		})
+	}
+
+	response := &etcdTxnResponse{}
+	if err := c.call("/v3/kv/txn", request, response); err != nil {
+		return false, err
+	}
+	return response.Succeeded, nil
+}
+
+func (c *EtcdClient) Grant(ttl time.Duration) (int64, error) {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	response := &etcdGrantResponse{}
+	if err := c.call("/v3/lease/grant", etcdGrantRequest{TTL: seconds}, response); err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
+
+type EtcdPersistence struct {
+	client *EtcdClient
+	prefix string
+}
+
+func NewEtcdPersistence(client *EtcdClient, prefix string) *EtcdPersistence {
+	return &EtcdPersistence{client, prefix}
+}
+
+func (p *EtcdPersistence) ipKey(ip string) string {
+	return p.prefix + "ip/" + ip
+}
+
+func (p *EtcdPersistence) macKey(mac dhcp4.HardwareAddr) string {
+	return p.prefix + "mac/" + mac.String()
+}
+
+func decodeEtcdLease(kv *etcdKeyValue) (*Lease, error) {
+	stored := &FilePersistenceLease{}
+	if err := json.Unmarshal(kv.Value, stored); err != nil {
+		return nil, err
+	}
+	return stored.ToLease(), nil
+}
+
+func etcdRevision(kv *etcdKeyValue) int64 {
+	if kv == nil {
+		return 0
+	}
+	return kv.ModRevision
+}
+
+func (p *EtcdPersistence) Check() error {
+	_, err := p.client.Get(p.prefix)
+	return err
+}
+
+func (p *EtcdPersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	kvs, err := p.client.GetPrefix(p.prefix + "ip/")
+	if err != nil {
+		return nil, err
+	}
+
+	leases := map[dhcp4.FixedV4]*Lease{}
+	for i := range kvs {
+		lease, err := decodeEtcdLease(&kvs[i])
+		if err != nil {
+			return nil, err
+		}
+		leases[lease.IP] = lease
+	}
+	return leases, nil
+}
+
+// Bulk writes are only used for imports, so don't need to be atomic
+func (p *EtcdPersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	for _, lease := range leases {
+		if lease.Expired() {
+			continue
+		}
+		if _, err := p.ClaimLease(lease); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *EtcdPersistence) ClaimLease(lease *Lease) (*Lease, error) {
+	ipKey := p.ipKey(lease.IP.String())
+	macKey := p.macKey(lease.Mac)
+
+	payload, err := json.Marshal(NewFilePersistenceLease(lease))
+	if err != nil {
+		return nil, err
+	}
+
+	var etcdLease int64
+	if !lease.Permanent() {
+		if etcdLease, err = p.client.Grant(time.Until(lease.Expiration)); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; attempt < etcdClaimAttempts; attempt++ {
+		current, err := p.client.Get(ipKey)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			holder, err := decodeEtcdLease(current)
+			if err != nil {
+				return nil, err
+			}
+			if holder.Mac != lease.Mac {
+				return holder, nil
+			}
+		}
+
+		previous, err := p.client.Get(macKey)
+		if err != nil {
+			return nil, err
+		}
+
+		ops := []etcdRequestOp{
+			{Put: &etcdPutRequest{Key: []byte(ipKey), Value: payload, Lease: etcdLease}},
+			{Put: &etcdPutRequest{Key: []byte(macKey), Value: []byte(lease.IP.String()), Lease: etcdLease}},
+		}
+		// The client is moving to a new IP
+		if previous != nil && string(previous.Value) != lease.IP.String() {
+			ops = append(ops, etcdRequestOp{Delete: &etcdDeleteRequest{Key: []byte(p.ipKey(string(previous.Value)))}})
+		}
+
+		ok, err := p.client.Txn(map[string]int64{ipKey: etcdRevision(current), macKey: etcdRevision(previous)}, ops)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+	}
+	return nil, errors.New("Gave up claiming lease after repeated conflicts")
+}
+
+func (p *EtcdPersistence) LookupLease(mac dhcp4.HardwareAddr) (*Lease, error) {
+	ip, err := p.client.Get(p.macKey(mac))
+	if err != nil || ip == nil {
+		return nil, err
+	}
+	current, err := p.client.Get(p.ipKey(string(ip.Value)))
+	if err != nil || current == nil {
+		return nil, err
+	}
+	lease, err := decodeEtcdLease(current)
+	if err != nil {
+		return nil, err
+	}
+	// Index was stale
+	if lease.Mac != mac {
+		return nil, nil
+	}
+	return lease, nil
+}
+
+func (p *EtcdPersistence) ReleaseLease(lease *Lease) error {
+	ipKey := p.ipKey(lease.IP.String())
+	macKey := p.macKey(lease.Mac)
+
+	for attempt := 0; attempt < etcdClaimAttempts; attempt++ {
+		current, err := p.client.Get(ipKey)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return nil
+		}
+		holder, err := decodeEtcdLease(current)
+		if err != nil {
+			return err
+		}
+		if holder.Mac != lease.Mac {
+			return nil
+		}
+
+		ok, err := p.client.Txn(map[string]int64{ipKey: current.ModRevision}, []etcdRequestOp{
+			{Delete: &etcdDeleteRequest{Key: []byte(ipKey)}},
+			{Delete: &etcdDeleteRequest{Key: []byte(macKey)}},
+		})
+		if err != nil || ok {
+			return err
+		}
+	}
+	return errors.New("Gave up releasing lease after repeated conflicts")
+}
@@ -0,0 +1,138 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+// Just enough of etcd's JSON gateway to exercise our client
+type fakeEtcd struct {
+	m        sync.Mutex
+	revision int64
+	kvs      map[string]etcdKeyValue
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		request := &etcdRangeRequest{}
+		json.NewDecoder(r.Body).Decode(request)
+		response := &etcdRangeResponse{}
+		for key, kv := range f.kvs {
+			if key == string(request.Key) || (request.RangeEnd != nil && key >= string(request.Key) && key < string(request.RangeEnd)) {
+				response.Kvs = append(response.Kvs, kv)
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "/v3/kv/txn":
+		request := &etcdTxnRequest{}
+		json.NewDecoder(r.Body).Decode(request)
+		for _, compare := range request.Compare {
+			if f.kvs[string(compare.Key)].ModRevision != compare.ModRevision {
+				json.NewEncoder(w).Encode(&etcdTxnResponse{})
+				return
+			}
+		}
+		f.revision++
+		for _, op := range request.Success {
+			if op.Put != nil {
+				f.kvs[string(op.Put.Key)] = etcdKeyValue{Key: op.Put.Key, Value: op.Put.Value, ModRevision: f.revision}
+			}
+			if op.Delete != nil {
+				delete(f.kvs, string(op.Delete.Key))
+			}
+		}
+		json.NewEncoder(w).Encode(&etcdTxnResponse{Succeeded: true})
+
+	case "/v3/lease/grant":
+		json.NewEncoder(w).Encode(&etcdGrantResponse{ID: 1})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdPersistence(t *testing.T) {
+	etcd := &fakeEtcd{kvs: map[string]etcdKeyValue{}}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	// Falls through to the endpoint which works
+	client := NewEtcdClient([]string{"http://127.0.0.1:1", server.URL}, "", "")
+	persistence := NewEtcdPersistence(client, "/dhcpd/test/")
+
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	lease := &Lease{
+		Mac:        mac1,
+		IP:         dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")),
+		Expiration: time.Now().Add(time.Hour).Truncate(time.Second).UTC(),
+	}
+
+	holder, err := persistence.ClaimLease(lease)
+	require.Nil(t, err)
+	require.Nil(t, holder)
+
+	other := *lease
+	other.Mac = mac2
+	holder, err = persistence.ClaimLease(&other)
+	require.Nil(t, err)
+	require.Equal(t, lease, holder)
+
+	found, err := persistence.LookupLease(mac1)
+	require.Nil(t, err)
+	require.Equal(t, lease, found)
+
+	// Moving to another IP drops the old one
+	moved := *lease
+	moved.IP = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.11"))
+	holder, err = persistence.ClaimLease(&moved)
+	require.Nil(t, err)
+	require.Nil(t, holder)
+
+	leases, err := persistence.LoadLeases()
+	require.Nil(t, err)
+	require.Equal(t, map[dhcp4.FixedV4]*Lease{moved.IP: &moved}, leases)
+
+	require.Nil(t, persistence.ReleaseLease(&moved))
+	require.Empty(t, etcd.kvs)
+
+	// Keys are where we expect, so other tools can find them
+	_, err = persistence.ClaimLease(lease)
+	require.Nil(t, err)
+	_, ok := etcd.kvs["/dhcpd/test/ip/10.0.0.10"]
+	require.True(t, ok)
+	require.Equal(t, "10.0.0.10", string(etcd.kvs["/dhcpd/test/mac/0:0:0:0:0:1"].Value))
+}
+
+func TestEtcdAuth(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/auth/authenticate" {
+			json.NewEncoder(w).Encode(&etcdAuthResponse{Token: "abc"})
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(&etcdRangeResponse{})
+	}))
+	defer server.Close()
+
+	// Trailing slashes are fine
+	client := NewEtcdClient([]string{server.URL + "/"}, "root", "secret")
+	_, err := client.Get("key")
+	require.Nil(t, err)
+	require.Equal(t, "abc", authorization)
+}
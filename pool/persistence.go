@@ -0,0 +1,189 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+type Persistence interface {
+	LoadLeases() (map[dhcp4.FixedV4]*Lease, error)
+	PersistLeases(map[dhcp4.FixedV4]*Lease) error
+}
+
+// Backends shared by several servers, which need to agree on who holds each
+// IP rather than trusting their own view of the pool
+type SharedPersistence interface {
+	Persistence
+
+	// Take the lease's IP, or refresh our hold on it. If another client
+	// already holds it, returns their lease instead
+	ClaimLease(lease *Lease) (*Lease, error)
+
+	// Lease currently held by this mac, if any
+	LookupLease(mac dhcp4.HardwareAddr) (*Lease, error)
+
+	ReleaseLease(lease *Lease) error
+}
+
+// Backends which can tell whether they're usable right now, for health
+// checks, without waiting for a lease to fail to be written
+type CheckedPersistence interface {
+	Check() error
+}
+
+// Leases loaded from another backend, but from then on only kept in memory,
+// for dry runs which mustn't change what other servers see. Shared backends
+// are treated like any other, so nothing is claimed in them
+type ReadOnlyPersistence struct {
+	backend Persistence
+}
+
+func NewReadOnlyPersistence(backend Persistence) *ReadOnlyPersistence {
+	return &ReadOnlyPersistence{backend}
+}
+
+func (r *ReadOnlyPersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	return r.backend.LoadLeases()
+}
+
+func (r *ReadOnlyPersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	return nil
+}
+
+type FilePersistenceLease struct {
+	Hostname        string
+	IP              string
+	Mac             string
+	Expiration      time.Time
+	LastTransaction time.Time
+	RelayAgentInfo  []byte
+	MudUrl          string
+	Fingerprint     string
+	VendorClass     string
+	Duid            string
+	ClientId        []byte
+}
+
+type FilePersistence struct {
+	path string
+}
+
+func NewFilePersistence(path string) *FilePersistence {
+	return &FilePersistence{path}
+}
+
+// Convert between our in-memory and json leases
+func (l *FilePersistenceLease) ToLease() *Lease {
+	return &Lease{
+		Mac:        dhcp4.StrToHardwareAddr(l.Mac),
+		Hostname:   l.Hostname,
+		IP:         dhcp4.IpToFixedV4(net.ParseIP(l.IP)),
+		Expiration: l.Expiration,
+
+		LastTransaction: l.LastTransaction,
+		RelayAgentInfo:  l.RelayAgentInfo,
+		MudUrl:          l.MudUrl,
+		Fingerprint:     l.Fingerprint,
+		VendorClass:     l.VendorClass,
+		Duid:            l.Duid,
+		ClientId:        l.ClientId,
+	}
+}
+
+func NewFilePersistenceLease(lease *Lease) *FilePersistenceLease {
+	return &FilePersistenceLease{
+		Mac:        lease.Mac.String(),
+		Hostname:   lease.Hostname,
+		IP:         lease.IP.String(),
+		Expiration: lease.Expiration,
+
+		LastTransaction: lease.LastTransaction,
+		RelayAgentInfo:  lease.RelayAgentInfo,
+		MudUrl:          lease.MudUrl,
+		Fingerprint:     lease.Fingerprint,
+		VendorClass:     lease.VendorClass,
+		Duid:            lease.Duid,
+		ClientId:        lease.ClientId,
+	}
+}
+
+// Load on-disk json leases into our in-memory format
+func (p *FilePersistence) decode(orig map[string]*FilePersistenceLease) map[dhcp4.FixedV4]*Lease {
+	result := map[dhcp4.FixedV4]*Lease{}
+	for _, lease := range orig {
+		decoded := lease.ToLease()
+		result[decoded.IP] = decoded
+	}
+	return result
+}
+
+// Encoded in-memory leases into our on-disk json format
+func (p *FilePersistence) encode(leases map[dhcp4.FixedV4]*Lease) map[string]*FilePersistenceLease {
+	result := map[string]*FilePersistenceLease{}
+	for _, lease := range leases {
+		result[lease.IP.String()] = NewFilePersistenceLease(lease)
+	}
+	return result
+}
+
+func (p *FilePersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	leases := map[dhcp4.FixedV4]*Lease{}
+
+	contents, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		// If file doesn't exist, don't surface it as an error,
+		// and instead just return an empty list
+		if errors.Is(err, os.ErrNotExist) {
+			return leases, nil
+		}
+		return nil, err
+	}
+
+	fromFile := map[string]*FilePersistenceLease{}
+
+	err = json.Unmarshal(contents, &fromFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.decode(fromFile), nil
+}
+
+// Whether we can write to the lease file's directory
+func (p *FilePersistence) Check() error {
+	file, err := os.CreateTemp(filepath.Dir(p.path), ".check")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (p *FilePersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	encoded := p.encode(leases)
+	payload, err := json.MarshalIndent(encoded, "", "   ")
+	if err != nil {
+		return err
+	}
+
+	// Write alongside and rename over, so dying mid-write can't leave a
+	// truncated file
+	tmp := p.path + ".tmp"
+	err = ioutil.WriteFile(tmp, payload, 0644)
+	if err == nil {
+		err = os.Rename(tmp, p.path)
+	}
+	if err != nil {
+		log.Printf("Failed persisting leases to %v: %v", p.path, err)
+	}
+	return err
+}
@@ -0,0 +1,1330 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+var ErrNoIps = errors.New("No free IPs")
+
+var ErrDraining = errors.New("Pool is draining")
+
+// BOOTP clients never renew, so their leases last forever
+var NeverExpires = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+type Lease struct {
+	// Client's hardware address, a mac for ethernet clients
+	Mac        dhcp4.HardwareAddr
+	Hostname   string
+	IP         dhcp4.FixedV4
+	Expiration time.Time
+
+	// Last time we heard from the client, and the relay agent information
+	// (option 82) it came with, for answering leasequeries
+	LastTransaction time.Time
+	RelayAgentInfo  []byte
+
+	// Manufacturer Usage Description URL (option 161) the client last sent
+	MudUrl string
+
+	// Fingerprint of the client, as the option codes it last asked for in
+	// order, and its vendor class, for telling what kind of device it is
+	Fingerprint string
+	VendorClass string
+
+	// DUID from an RFC 4361 client identifier, by which a dual-stack client
+	// is known over DHCPv6 too
+	Duid string
+
+	// Client identifier (option 61) the client last sent, which its DNS
+	// names are registered by
+	ClientId []byte
+
+	// Offered but not yet requested, so only held until it expires, and
+	// neither written out nor announced to observers until it is
+	Offered bool
+}
+
+func (l *Lease) BumpExpiry(d time.Duration) {
+	if l.Permanent() {
+		return
+	}
+	l.Expiration = time.Now().Add(d)
+}
+
+func (l *Lease) Permanent() bool {
+	return l.Expiration.Equal(NeverExpires)
+}
+
+func (l *Lease) Expired() bool {
+	return time.Now().After(l.Expiration)
+}
+
+// Kinds of change to a lease
+const (
+	LEASE_CREATED  = "created"
+	LEASE_RENEWED  = "renewed"
+	LEASE_RELEASED = "released"
+	LEASE_EXPIRED  = "expired"
+)
+
+type LeaseEvent struct {
+	Kind  string
+	Pool  *Pool
+	Lease Lease
+}
+
+// Told about every lease change, once it's been made and written out. May be
+// called concurrently for different clients
+type LeaseObserver func(event LeaseEvent)
+
+// Leases are split by mac into this many shards, each with its own lock, so
+// renewals by different clients don't wait on each other, or on allocations
+// searching for a free IP
+const leaseShards = 32
+
+type leaseShard struct {
+	m      sync.Mutex
+	leases map[dhcp4.HardwareAddr]*Lease
+}
+
+type ReservedHost struct {
+	Mac      dhcp4.HardwareAddr
+	Hostname string
+	IP       dhcp4.FixedV4
+	Options  []dhcp4.CustomOption
+
+	// If set, overrides the lease time of the pool and of any class
+	LeaseTime time.Duration
+}
+
+type Pool struct {
+	Name        string
+	Network     net.IP
+	Netmask     net.IP
+	Broadcast   net.IP
+	Start       net.IP
+	End         net.IP
+	MyIp        dhcp4.FixedV4
+	Router      []net.IP
+	Dns         []net.IP
+	Ntp         []net.IP
+	Sip         dhcp4.SipServers
+	Routes      []dhcp4.StaticRoute
+	Options     []dhcp4.CustomOption
+	BootpStart  net.IP
+	BootpEnd    net.IP
+	Mtu         uint16
+	RapidCommit bool
+	Wpad        string
+	Domain      string
+	LeaseTime   time.Duration
+	Persistence Persistence
+
+	// How long clients preferring IPv6-only should go without IPv4, if the
+	// pool's network is IPv6-mostly
+	V6OnlyWait time.Duration
+
+	// Options from the global configuration, which anything set for the
+	// pool overrides
+	GlobalOptions []dhcp4.CustomOption
+
+	// Vendor-identifying options by enterprise, for clients identifying as
+	// each
+	VendorOptions []dhcp4.VendorOptions
+
+	// If set, the only interface, or VLAN ID of sub-interfaces, this pool
+	// answers requests received on
+	Interface string
+	Vlan      int
+
+	// Name of the shared network this pool is one of the subnets of, if any
+	SharedNetwork string
+
+	// Stay quiet rather than NAKing requests for IPs we don't know of, as
+	// another server on the network might
+	NotAuthoritative bool
+
+	// Leave clients with neither a reservation nor a class unanswered
+	IgnoreUnknown bool
+
+	// If set, how many leases clients behind one relay circuit can hold
+	MaxLeasesPerCircuit int
+
+	// Whether the server keeps leases by client identifier where clients
+	// send one, so the hardware addresses of leases may be identifiers
+	LeaseByClientId bool
+
+	// Where to start looking for a free IP for a new client, the start of
+	// the range if unset
+	Allocator Allocator
+
+	// If set, how long an offered IP is held for the client to request it,
+	// rather than the whole lease time
+	OfferTime time.Duration
+
+	// If set, how long to wait for an answer to an ARP probe for an IP
+	// before offering it to a new client, and how many more to send
+	ProbeTimeout time.Duration
+	ProbeRetries int
+
+	// If set, how long an IP a client declined as already in use is kept
+	// out of use, rather than the lease time
+	AbandonTime time.Duration
+
+	// Lease times and options for classes of clients, overriding the
+	// pool's own
+	ClassLeaseTimes map[string]time.Duration
+	ClassOptions    map[string][]dhcp4.CustomOption
+
+	// Internal lease database. Leases by mac are sharded, with each shard's
+	// lock also covering the fields of the leases in it. Leases by IP, which
+	// are searched for free IPs, are under alloc. When taking both, alloc
+	// comes first, and only one shard lock is held at a time
+	shards    [leaseShards]leaseShard
+	leaseByIp map[dhcp4.FixedV4]*Lease
+	alloc     sync.Mutex
+
+	// Who last held each IP whose lease is gone, and the other way round,
+	// so returning clients can get their IP back. Kept to one entry per IP,
+	// and under alloc
+	lastMacByIp map[dhcp4.FixedV4]dhcp4.HardwareAddr
+	lastIpByMac map[dhcp4.HardwareAddr]dhcp4.FixedV4
+
+	// IPs clients declined as in use by someone else, until when we stop
+	// avoiding them. Under alloc
+	abandoned map[dhcp4.FixedV4]time.Time
+
+	// Leases are written out in full after changes, in the background so
+	// the change needn't wait on copying and writing every lease, with
+	// changes made while a write is under way coalesced into the next one.
+	// One snapshot is written at a time so an older one never overwrites a
+	// newer one
+	persist      sync.Mutex
+	persistOnce  sync.Once
+	persistDirty chan struct{}
+
+	// Internal database of fixed mac addresses to IPs for hosts,
+	// sourced from configuration
+	reservedByMac map[dhcp4.HardwareAddr]*ReservedHost
+	reservedByIp  map[dhcp4.FixedV4]*ReservedHost
+
+	// If set, the part of our range we hand out new IPs from, when sharing
+	// it with another server
+	shareStart dhcp4.FixedV4
+	shareEnd   dhcp4.FixedV4
+
+	// No new leases while set, only existing ones renewed
+	draining bool
+
+	observers []LeaseObserver
+
+	// Covers reservations, share, draining and observers. Taken before alloc
+	m sync.RWMutex
+}
+
+func NewPool() *Pool {
+	p := &Pool{}
+	p.clearLeases()
+	p.clearReservedHosts()
+	return p
+}
+
+func (p *Pool) getFreeIp(mac dhcp4.HardwareAddr) (dhcp4.FixedV4, error) {
+	if p.shareStart != 0 {
+		return p.getFreeIpInRange(mac, p.shareStart.NetIp(), p.shareEnd.NetIp())
+	}
+	return p.getFreeIpInRange(mac, p.Start, p.End)
+}
+
+// Stop handing out new leases, while renewing the ones clients have as
+// usual, so the pool empties out as they leave for a subnet being migrated
+// or renumbered to
+func (p *Pool) SetDraining(draining bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.draining = draining
+}
+
+func (p *Pool) Draining() bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	return p.draining
+}
+
+// Only hand out new IPs from part of our range. Existing leases outside of it
+// are still honoured
+func (p *Pool) SetShare(start, end dhcp4.FixedV4) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.shareStart = start
+	p.shareEnd = end
+}
+
+// Whether this IP is in our range but another server's share of it
+func (p *Pool) inPeerShare(ip dhcp4.FixedV4) bool {
+	if p.shareStart == 0 {
+		return false
+	}
+	if ip < dhcp4.IpToFixedV4(p.Start) || ip > dhcp4.IpToFixedV4(p.End) {
+		return false
+	}
+	return ip < p.shareStart || ip > p.shareEnd
+}
+
+// Take over a lease handed out by the server we share our range with, when
+// its client comes to us to renew it
+func (p *Pool) AdoptLease(mac dhcp4.HardwareAddr, ip dhcp4.FixedV4) (*Lease, bool) {
+	lease, ok := p.adoptLease(mac, ip)
+	if ok {
+		p.changed(LEASE_CREATED, lease)
+	}
+	return lease, ok
+}
+
+func (p *Pool) adoptLease(mac dhcp4.HardwareAddr, ip dhcp4.FixedV4) (*Lease, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if !p.inPeerShare(ip) {
+		return nil, false
+	}
+	if _, ok := p.leaseByIp[ip]; ok {
+		return nil, false
+	}
+	if _, ok := p.lookupLease(mac); ok {
+		return nil, false
+	}
+	if host, ok := p.reservedByIp[ip]; ok && host.Mac != mac {
+		return nil, false
+	}
+
+	lease := &Lease{
+		IP:  ip,
+		Mac: mac,
+	}
+	lease.BumpExpiry(p.leaseTimeFor(mac, ""))
+	p.insertLease(lease)
+	return lease, true
+}
+
+func (p *Pool) AddObserver(observer LeaseObserver) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.observers = append(p.observers, observer)
+}
+
+// Must be called without any of our locks held
+func (p *Pool) notify(kind string, lease Lease) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	for _, observer := range p.observers {
+		observer(LeaseEvent{kind, p, lease})
+	}
+}
+
+// Write out and tell observers about a change to a lease. Must be called
+// without any of our locks held
+func (p *Pool) changed(kind string, lease *Lease) {
+	event := p.copyLease(lease)
+	p.persistLater()
+	p.notify(kind, event)
+}
+
+// Hacky, terrible, naive impl. I want an ordered int set!
+func (p *Pool) getFreeIpInRange(mac dhcp4.HardwareAddr, startIp, endIp net.IP) (dhcp4.FixedV4, error) {
+
+	// If there is a reserved IP for this mac address, use that
+	if host, ok := p.reservedByMac[mac]; ok {
+		return host.IP, nil
+	}
+
+	if startIp == nil || endIp == nil {
+		return 0, ErrNoIps
+	}
+
+	// Try to find the next free IP within our range from where our
+	// allocator says to start, while keeping track of the first expired
+	// lease we found, in case we have no otherwise free IPs
+	start := dhcp4.IpToFixedV4(startIp)
+	end := dhcp4.IpToFixedV4(endIp)
+	if end < start {
+		return 0, ErrNoIps
+	}
+
+	// A returning client gets the IP it last had back, if it's still free
+	if ip, ok := p.lastIpByMac[mac]; ok && ip >= start && ip <= end && !p.isAbandoned(ip) {
+		if _, ok := p.reservedByIp[ip]; !ok {
+			if lease, ok := p.leaseByIp[ip]; !ok || p.reclaimLease(lease) {
+				return ip, nil
+			}
+		}
+	}
+
+	size := uint32(end-start) + 1
+	allocator := p.Allocator
+	if allocator == nil {
+		allocator = SequentialAllocator
+	}
+	offset := allocator(mac, size) % size
+
+	for {
+		var foundExpired *Lease = nil
+
+		// Free IPs someone else last had are kept for them to come back to
+		// while there are others
+		var foundRemembered dhcp4.FixedV4
+		remembered := false
+
+		for i := uint32(0); i < size; i++ {
+			ipLong := start + dhcp4.FixedV4((offset+i)%size)
+			// Skip over any IPs in our range which are reserved
+			if _, ok := p.reservedByIp[ipLong]; ok {
+				continue
+			}
+			if p.isAbandoned(ipLong) {
+				continue
+			}
+			if lease, ok := p.leaseByIp[ipLong]; !ok {
+				if _, ok := p.lastMacByIp[ipLong]; !ok {
+					return ipLong, nil
+				}
+				if !remembered {
+					foundRemembered, remembered = ipLong, true
+				}
+			} else if foundExpired == nil {
+				if current := p.copyLease(lease); current.Expired() {
+					foundExpired = lease
+				}
+			}
+		}
+
+		if remembered {
+			return foundRemembered, nil
+		}
+		if foundExpired == nil {
+			return 0, ErrNoIps
+		}
+
+		// We have a recovered expired lease. Delete it and return its
+		// free IP, unless it was renewed since we looked
+		if p.reclaimLease(foundExpired) {
+			return foundExpired.IP, nil
+		}
+	}
+}
+
+func (p *Pool) shard(mac dhcp4.HardwareAddr) *leaseShard {
+	return &p.shards[hashMac(mac)%leaseShards]
+}
+
+func (p *Pool) clearLeases() {
+	for i := range p.shards {
+		p.shards[i].m.Lock()
+		p.shards[i].leases = map[dhcp4.HardwareAddr]*Lease{}
+		p.shards[i].m.Unlock()
+	}
+	p.leaseByIp = map[dhcp4.FixedV4]*Lease{}
+	p.lastMacByIp = map[dhcp4.FixedV4]dhcp4.HardwareAddr{}
+	p.lastIpByMac = map[dhcp4.HardwareAddr]dhcp4.FixedV4{}
+	p.abandoned = map[dhcp4.FixedV4]time.Time{}
+}
+
+// Whether an IP was declined recently enough that we're still avoiding it.
+// Must be called with alloc held
+func (p *Pool) isAbandoned(ip dhcp4.FixedV4) bool {
+	until, ok := p.abandoned[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(p.abandoned, ip)
+		return false
+	}
+	return true
+}
+
+func (p *Pool) lookupLease(mac dhcp4.HardwareAddr) (*Lease, bool) {
+	s := p.shard(mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	lease, ok := s.leases[mac]
+	return lease, ok
+}
+
+// Consistent copy of a lease, which may be being renewed
+func (p *Pool) copyLease(lease *Lease) Lease {
+	s := p.shard(lease.Mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return *lease
+}
+
+// Must be called with alloc held
+func (p *Pool) insertLease(lease *Lease) {
+	s := p.shard(lease.Mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.leases[lease.Mac] = lease
+	p.leaseByIp[lease.IP] = lease
+}
+
+// Must be called with alloc held
+func (p *Pool) deleteLease(lease *Lease) {
+	s := p.shard(lease.Mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.leases, lease.Mac)
+	delete(p.leaseByIp, lease.IP)
+	p.rememberHolder(lease)
+}
+
+// Delete a lease if it's still expired. Must be called with alloc held
+func (p *Pool) reclaimLease(lease *Lease) bool {
+	s := p.shard(lease.Mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !lease.Expired() {
+		return false
+	}
+	delete(s.leases, lease.Mac)
+	delete(p.leaseByIp, lease.IP)
+	p.rememberHolder(lease)
+	return true
+}
+
+// Note who held a lease which is going. Must be called with alloc held
+func (p *Pool) rememberHolder(lease *Lease) {
+	if mac, ok := p.lastMacByIp[lease.IP]; ok {
+		delete(p.lastIpByMac, mac)
+	}
+	if ip, ok := p.lastIpByMac[lease.Mac]; ok {
+		delete(p.lastMacByIp, ip)
+	}
+	p.lastMacByIp[lease.IP] = lease.Mac
+	p.lastIpByMac[lease.Mac] = lease.IP
+}
+
+func (p *Pool) clearReservedHosts() {
+	p.reservedByMac = map[dhcp4.HardwareAddr]*ReservedHost{}
+	p.reservedByIp = map[dhcp4.FixedV4]*ReservedHost{}
+}
+
+func (p *Pool) insertReservedHost(host *ReservedHost) {
+	p.reservedByMac[host.Mac] = host
+	p.reservedByIp[host.IP] = host
+}
+
+func (p *Pool) AddReservedHost(host *ReservedHost) error {
+	if _, ok := p.reservedByIp[host.IP]; ok {
+		return errors.New("Reserved hosts with duplicate IPs")
+	}
+	if _, ok := p.reservedByMac[host.Mac]; ok {
+		return errors.New("Reserved hosts with duplicate mac addresses")
+	}
+	p.insertReservedHost(host)
+	return nil
+}
+
+// Replace all reservations, leaving leases be
+func (p *Pool) SetReservedHosts(hosts []*ReservedHost) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	byMac, byIp := p.reservedByMac, p.reservedByIp
+	p.clearReservedHosts()
+	for _, host := range hosts {
+		if err := p.AddReservedHost(host); err != nil {
+			p.reservedByMac, p.reservedByIp = byMac, byIp
+			return err
+		}
+	}
+	return nil
+}
+
+// Add a reservation, or change the one for the same client. Fails if the
+// IP is reserved for or leased to another client. Leases are left be, so
+// a client whose reserved IP changed keeps its old one until it's released
+func (p *Pool) SetReservedHost(host *ReservedHost) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if other, ok := p.reservedByIp[host.IP]; ok && other.Mac != host.Mac {
+		return fmt.Errorf("IP is reserved for %v", other.Mac.String())
+	}
+	if lease, ok := p.leaseByIp[host.IP]; ok {
+		if current := p.copyLease(lease); current.Mac != host.Mac && !current.Expired() {
+			return fmt.Errorf("IP is leased to %v", current.Mac.String())
+		}
+	}
+	if old, ok := p.reservedByMac[host.Mac]; ok {
+		delete(p.reservedByIp, old.IP)
+	}
+	p.insertReservedHost(host)
+	return nil
+}
+
+func (p *Pool) RemoveReservedHost(mac dhcp4.HardwareAddr) (*ReservedHost, bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	host, ok := p.reservedByMac[mac]
+	if ok {
+		delete(p.reservedByMac, mac)
+		delete(p.reservedByIp, host.IP)
+	}
+	return host, ok
+}
+
+// Snapshot of all current leases, leaving out offers not yet requested
+func (p *Pool) GetLeases() []Lease {
+	return p.getLeases(false)
+}
+
+// Snapshot of offers not yet requested
+func (p *Pool) GetOffers() []Lease {
+	return p.getLeases(true)
+}
+
+func (p *Pool) getLeases(offered bool) []Lease {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	leases := make([]Lease, 0, len(p.leaseByIp))
+	for _, lease := range p.leaseByIp {
+		if copied := p.copyLease(lease); copied.Offered == offered {
+			leases = append(leases, copied)
+		}
+	}
+	return leases
+}
+
+// Free the IPs of offers whose clients never requested them in time,
+// returning how many there were
+func (p *Pool) ExpireOffers() int {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	count := 0
+	for _, lease := range p.leaseByIp {
+		if current := p.copyLease(lease); current.Offered && current.Expired() {
+			p.deleteLease(lease)
+			p.releaseSharedLease(lease)
+			count++
+		}
+	}
+	return count
+}
+
+// Free the IP offered to a client which took another server's offer
+// instead, returning whether there was one
+func (p *Pool) WithdrawOffer(mac dhcp4.HardwareAddr) bool {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	lease, ok := p.lookupLease(mac)
+	if !ok || !p.copyLease(lease).Offered {
+		return false
+	}
+	p.deleteLease(lease)
+	p.releaseSharedLease(lease)
+	return true
+}
+
+func (p *Pool) GetLeaseByMac(mac dhcp4.HardwareAddr) (Lease, bool) {
+	s := p.shard(mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if lease, ok := s.leases[mac]; ok {
+		return *lease, true
+	}
+	return Lease{}, false
+}
+
+func (p *Pool) GetLeaseByIp(ip dhcp4.FixedV4) (Lease, bool) {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if lease, ok := p.leaseByIp[ip]; ok {
+		return p.copyLease(lease), true
+	}
+	return Lease{}, false
+}
+
+// Whether we could ever hand out this IP, either dynamically or as a
+// reservation
+func (p *Pool) Contains(ip dhcp4.FixedV4) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if _, ok := p.reservedByIp[ip]; ok {
+		return true
+	}
+	if ip >= dhcp4.IpToFixedV4(p.Start) && ip <= dhcp4.IpToFixedV4(p.End) {
+		return true
+	}
+	if p.BootpStart != nil && ip >= dhcp4.IpToFixedV4(p.BootpStart) && ip <= dhcp4.IpToFixedV4(p.BootpEnd) {
+		return true
+	}
+	return false
+}
+
+// Whether an IP is on the network the pool serves, whether or not it's
+// one we could hand out
+func (p *Pool) OnNetwork(ip dhcp4.FixedV4) bool {
+	if p.Netmask == nil {
+		return p.Contains(ip)
+	}
+	mask := dhcp4.IpToFixedV4(p.Netmask)
+	network := p.MyIp
+	if p.Network != nil {
+		network = dhcp4.IpToFixedV4(p.Network)
+	}
+	return ip&mask == network&mask
+}
+
+// Dynamic and BOOTP ranges, as first and last IPs
+func (p *Pool) ranges() [][2]dhcp4.FixedV4 {
+	var ranges [][2]dhcp4.FixedV4
+	if p.Start != nil && p.End != nil {
+		ranges = append(ranges, [2]dhcp4.FixedV4{dhcp4.IpToFixedV4(p.Start), dhcp4.IpToFixedV4(p.End)})
+	}
+	if p.BootpStart != nil && p.BootpEnd != nil {
+		ranges = append(ranges, [2]dhcp4.FixedV4{dhcp4.IpToFixedV4(p.BootpStart), dhcp4.IpToFixedV4(p.BootpEnd)})
+	}
+	return ranges
+}
+
+// Why this pool and another could both hand out the same IP, if they
+// could: their ranges overlap, or one has a reservation the other could
+// hand out
+func (p *Pool) Overlap(other *Pool) error {
+	for _, a := range p.ranges() {
+		for _, b := range other.ranges() {
+			if a[0] <= b[1] && b[0] <= a[1] {
+				return fmt.Errorf("Range %v-%v of pool %v overlaps range %v-%v of pool %v",
+					a[0].String(), a[1].String(), p.Name, b[0].String(), b[1].String(), other.Name)
+			}
+		}
+	}
+	for _, pools := range [][2]*Pool{{p, other}, {other, p}} {
+		if ip, ok := pools[0].reservedIn(pools[1]); ok {
+			return fmt.Errorf("IP %v reserved in pool %v could be handed out by pool %v", ip.String(), pools[0].Name, pools[1].Name)
+		}
+	}
+	return nil
+}
+
+// A reserved IP the other pool could hand out, if there is one
+func (p *Pool) reservedIn(other *Pool) (dhcp4.FixedV4, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	for ip := range p.reservedByIp {
+		if other.Contains(ip) {
+			return ip, true
+		}
+	}
+	return 0, false
+}
+
+// What a client's last transaction came with
+type Transaction struct {
+	RelayAgentInfo []byte
+	MudUrl         string
+	Fingerprint    string
+	VendorClass    string
+	Duid           string
+	ClientId       []byte
+}
+
+// Record that we've just heard from the holder of this lease, and what it
+// came with
+func (p *Pool) NoteTransaction(mac dhcp4.HardwareAddr, txn Transaction) {
+	s := p.shard(mac)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if lease, ok := s.leases[mac]; ok {
+		lease.LastTransaction = time.Now()
+		lease.RelayAgentInfo = txn.RelayAgentInfo
+		lease.MudUrl = txn.MudUrl
+		lease.Fingerprint = txn.Fingerprint
+		lease.VendorClass = txn.VendorClass
+		lease.Duid = txn.Duid
+		lease.ClientId = txn.ClientId
+	}
+}
+
+// Tell observers about leases which expired after since, up to until. Expired
+// leases are kept around so their clients can get the same IP back, so this
+// is the only notice of them going
+func (p *Pool) NotifyExpired(since, until time.Time) {
+	var expired []Lease
+	for _, lease := range p.GetLeases() {
+		if lease.Expiration.After(since) && !lease.Expiration.After(until) {
+			expired = append(expired, lease)
+		}
+	}
+	for _, lease := range expired {
+		p.notify(LEASE_EXPIRED, lease)
+	}
+}
+
+func (p *Pool) GetReservedHost(mac dhcp4.HardwareAddr) (*ReservedHost, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	host, ok := p.reservedByMac[mac]
+	return host, ok
+}
+
+func (p *Pool) TouchLeaseByMac(mac dhcp4.HardwareAddr) (*Lease, bool) {
+	return p.TouchLease(mac, p.LeaseTimeFor(mac, ""))
+}
+
+// Renew a client's lease for d, taking up an offer it was made if that's
+// what it has
+func (p *Pool) TouchLease(mac dhcp4.HardwareAddr, d time.Duration) (*Lease, bool) {
+	return p.touchLease(mac, d, true)
+}
+
+// Renew a client's lease for d, but not an offer it hasn't requested yet
+func (p *Pool) RenewLease(mac dhcp4.HardwareAddr, d time.Duration) (*Lease, bool) {
+	return p.touchLease(mac, d, false)
+}
+
+func (p *Pool) touchLease(mac dhcp4.HardwareAddr, d time.Duration, takeOffer bool) (*Lease, bool) {
+	// Leases in a shared backend may have changed hands since we last
+	// looked, so renewing one means checking the IP is still free
+	if _, ok := p.sharedPersistence(); ok {
+		lease, kind, ok := p.touchSharedLease(mac, d, takeOffer)
+		if ok {
+			p.changed(kind, lease)
+		}
+		return lease, ok
+	}
+
+	// Otherwise only this client's shard is locked, leaving allocations
+	// and other renewals be
+	s := p.shard(mac)
+	s.m.Lock()
+	lease, ok := s.leases[mac]
+	kind := LEASE_RENEWED
+	if ok {
+		kind, ok = bumpLease(lease, d, takeOffer)
+	}
+	s.m.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	p.changed(kind, lease)
+	return lease, true
+}
+
+func (p *Pool) touchSharedLease(mac dhcp4.HardwareAddr, d time.Duration, takeOffer bool) (*Lease, string, bool) {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	lease, ok := p.lookupLease(mac)
+	if !ok {
+		// Another server sharing our backend may have handed it out
+		if lease, ok = p.lookupSharedLease(mac); !ok {
+			return nil, "", false
+		}
+	}
+
+	s := p.shard(mac)
+	s.m.Lock()
+	kind, ok := bumpLease(lease, d, takeOffer)
+	s.m.Unlock()
+
+	if !ok || !p.claimSharedLease(lease) {
+		return nil, "", false
+	}
+	return lease, kind, true
+}
+
+// Extend a lease, which only counts as created once an offer is taken up.
+// Must be called with its shard's lock held
+func bumpLease(lease *Lease, d time.Duration, takeOffer bool) (string, bool) {
+	kind := LEASE_RENEWED
+	if lease.Offered {
+		if !takeOffer {
+			return "", false
+		}
+		lease.Offered = false
+		kind = LEASE_CREATED
+	}
+	lease.BumpExpiry(d)
+	return kind, true
+}
+
+func (p *Pool) GetNextLease(mac dhcp4.HardwareAddr, hostname string) (*Lease, error) {
+	return p.getNextLease(mac, hostname, p.LeaseTimeFor(mac, ""))
+}
+
+// New lease for d
+func (p *Pool) GetNextLeaseFor(mac dhcp4.HardwareAddr, hostname string, d time.Duration) (*Lease, error) {
+	return p.getNextLease(mac, hostname, d)
+}
+
+// New lease to offer, held only for OfferTime if set, so IPs offered to
+// clients which never request them are soon free again
+func (p *Pool) OfferLease(mac dhcp4.HardwareAddr, hostname string) (*Lease, error) {
+	return p.OfferLeaseFor(mac, hostname, p.LeaseTimeFor(mac, ""))
+}
+
+// New lease to offer for d, held for OfferTime if that's shorter. It's only
+// written out and announced once the client requests it with TouchLease, and
+// an offer already made to the client is made again
+func (p *Pool) OfferLeaseFor(mac dhcp4.HardwareAddr, hostname string, d time.Duration) (*Lease, error) {
+	if p.OfferTime != 0 && p.OfferTime < d {
+		d = p.OfferTime
+	}
+	return p.allocateLease(mac, hostname, d, true)
+}
+
+// How long to lease IPs to a client for, going by its reservation, then
+// the class it's in if any, then the pool
+func (p *Pool) LeaseTimeFor(mac dhcp4.HardwareAddr, class string) time.Duration {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	return p.leaseTimeFor(mac, class)
+}
+
+// Must be called with p.m held
+func (p *Pool) leaseTimeFor(mac dhcp4.HardwareAddr, class string) time.Duration {
+	if host, ok := p.reservedByMac[mac]; ok && host.LeaseTime != 0 {
+		return host.LeaseTime
+	}
+	if d, ok := p.ClassLeaseTimes[class]; ok && class != "" {
+		return d
+	}
+	return p.LeaseTime
+}
+
+func (p *Pool) getNextLease(mac dhcp4.HardwareAddr, hostname string, d time.Duration) (*Lease, error) {
+	lease, err := p.allocateLease(mac, hostname, d, false)
+	if err != nil {
+		return nil, err
+	}
+	p.changed(LEASE_CREATED, lease)
+	return lease, nil
+}
+
+func (p *Pool) allocateLease(mac dhcp4.HardwareAddr, hostname string, d time.Duration, offered bool) (*Lease, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if existing, ok := p.lookupLease(mac); ok && p.copyLease(existing).Offered {
+		s := p.shard(mac)
+		s.m.Lock()
+		existing.Offered = offered
+		existing.Hostname = hostname
+		existing.BumpExpiry(d)
+		s.m.Unlock()
+		if p.claimSharedLease(existing) {
+			return existing, nil
+		}
+	}
+	if p.draining {
+		return nil, ErrDraining
+	}
+
+	tried := map[dhcp4.FixedV4]bool{}
+	for {
+		ip, err := p.getFreeIp(mac)
+		if err != nil {
+			return nil, err
+		}
+		if tried[ip] {
+			return nil, fmt.Errorf("Could not claim %v from shared backend", ip.String())
+		}
+		tried[ip] = true
+		lease := &Lease{
+			IP:       ip,
+			Hostname: hostname,
+			Mac:      mac,
+			Offered:  offered,
+		}
+		lease.BumpExpiry(d)
+		p.insertLease(lease)
+
+		// Lost the race for this IP to another server, which we now know
+		// about, so try the next. A reserved IP is the only one the client
+		// can have, so there's no next to try
+		if p.claimSharedLease(lease) {
+			return lease, nil
+		}
+		if _, ok := p.leaseByIp[ip]; !ok {
+			return nil, errors.New("Could not claim lease from shared backend")
+		}
+		if host, ok := p.reservedByMac[mac]; ok && host.IP == ip {
+			return nil, fmt.Errorf("Reserved IP %v is held by another client in the shared backend", ip.String())
+		}
+	}
+}
+
+// Permanent lease for a BOOTP client, either one it already has, or a
+// reserved or free IP from the BOOTP range. A DHCP lease the client holds
+// is released rather than kept forever
+func (p *Pool) GetBootpLease(mac dhcp4.HardwareAddr) (*Lease, error) {
+	lease, released, created, err := p.allocateBootpLease(mac)
+	if err != nil {
+		return nil, err
+	}
+	if released != nil {
+		p.changed(LEASE_RELEASED, released)
+	}
+	if created {
+		p.changed(LEASE_CREATED, lease)
+	}
+	return lease, nil
+}
+
+func (p *Pool) allocateBootpLease(mac dhcp4.HardwareAddr) (*Lease, *Lease, bool, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	existing, ok := p.lookupLease(mac)
+	if ok {
+		if current := p.copyLease(existing); current.Permanent() {
+			return existing, nil, false, nil
+		}
+	}
+	if p.draining {
+		return nil, nil, false, ErrDraining
+	}
+
+	// Dropped first, so its IP can be given out again if it's reserved
+	if ok {
+		p.deleteLease(existing)
+	}
+	ip, err := p.getFreeIpInRange(mac, p.BootpStart, p.BootpEnd)
+	if err != nil {
+		if ok {
+			p.insertLease(existing)
+		}
+		return nil, nil, false, err
+	}
+	lease := &Lease{
+		IP:         ip,
+		Mac:        mac,
+		Expiration: NeverExpires,
+	}
+	p.insertLease(lease)
+	if !p.claimSharedLease(lease) {
+		return nil, nil, false, errors.New("Could not claim lease from shared backend")
+	}
+	if !ok {
+		existing = nil
+	}
+	return lease, existing, true, nil
+}
+
+// Add a lease from elsewhere, as long as it doesn't clash with what we have.
+// Callers importing in bulk need to PersistLeases afterwards
+func (p *Pool) ImportLease(lease *Lease) error {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if existing, ok := p.leaseByIp[lease.IP]; ok && existing.Mac != lease.Mac {
+		return fmt.Errorf("IP already leased to %v", existing.Mac.String())
+	}
+	if existing, ok := p.lookupLease(lease.Mac); ok && existing.IP != lease.IP {
+		return fmt.Errorf("%v already has a lease for %v", lease.Mac.String(), existing.IP.String())
+	}
+	if host, ok := p.reservedByIp[lease.IP]; ok && host.Mac != lease.Mac {
+		return fmt.Errorf("IP is reserved for %v", host.Mac.String())
+	}
+
+	p.insertLease(lease)
+	return nil
+}
+
+func (p *Pool) PersistLeases() error {
+	if p.Persistence == nil {
+		return nil
+	}
+
+	p.persist.Lock()
+	defer p.persist.Unlock()
+
+	return p.Persistence.PersistLeases(p.snapshot())
+}
+
+func (p *Pool) ReleaseLeaseByMac(mac dhcp4.HardwareAddr) (*Lease, bool) {
+	lease, ok := p.releaseLease(mac)
+	if ok {
+		p.changed(LEASE_RELEASED, lease)
+	}
+	return lease, ok
+}
+
+// Drop the lease of a client which found its IP already in use, and keep
+// the IP out of use for AbandonTime
+func (p *Pool) DeclineLease(mac dhcp4.HardwareAddr, ip dhcp4.FixedV4) (*Lease, bool) {
+	lease, ok := p.declineLease(mac, ip)
+	if ok {
+		p.changed(LEASE_RELEASED, lease)
+	}
+	return lease, ok
+}
+
+func (p *Pool) declineLease(mac dhcp4.HardwareAddr, ip dhcp4.FixedV4) (*Lease, bool) {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	lease, ok := p.lookupLease(mac)
+	if !ok || lease.IP != ip {
+		return nil, false
+	}
+	p.deleteLease(lease)
+	p.releaseSharedLease(lease)
+
+	d := p.AbandonTime
+	if d == 0 {
+		d = p.LeaseTime
+	}
+	p.abandoned[ip] = time.Now().Add(d)
+	return lease, true
+}
+
+func (p *Pool) releaseLease(mac dhcp4.HardwareAddr) (*Lease, bool) {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if lease, ok := p.lookupLease(mac); ok {
+		p.deleteLease(lease)
+		p.releaseSharedLease(lease)
+		return lease, true
+	}
+
+	return nil, false
+}
+
+// Take a lease from a peer server, replacing whatever we have for the same IP
+// or mac unless ours is more recent. Doesn't notify observers, to avoid
+// bouncing it back to the peer
+func (p *Pool) ApplyLease(lease *Lease) {
+	if p.applyLease(lease) {
+		p.persistLater()
+	}
+}
+
+func (p *Pool) applyLease(lease *Lease) bool {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	byMac, _ := p.lookupLease(lease.Mac)
+	existing := []*Lease{p.leaseByIp[lease.IP], byMac}
+	for _, existing := range existing {
+		if existing != nil && p.copyLease(existing).Expiration.After(lease.Expiration) {
+			return false
+		}
+	}
+	for _, existing := range existing {
+		if existing != nil {
+			p.deleteLease(existing)
+		}
+	}
+
+	p.insertLease(lease)
+	return true
+}
+
+// Drop a lease a peer server has seen released
+func (p *Pool) RemoveLease(ip dhcp4.FixedV4, mac dhcp4.HardwareAddr) {
+	if p.removeLease(ip, mac) {
+		p.persistLater()
+	}
+}
+
+func (p *Pool) removeLease(ip dhcp4.FixedV4, mac dhcp4.HardwareAddr) bool {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	if lease, ok := p.leaseByIp[ip]; ok && lease.Mac == mac {
+		p.deleteLease(lease)
+		return true
+	}
+	return false
+}
+
+func (p *Pool) LoadLeases() (int, error) {
+	if p.Persistence == nil {
+		return 0, nil
+	}
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	leases, err := p.Persistence.LoadLeases()
+	if err != nil {
+		return 0, err
+	}
+
+	p.clearLeases()
+
+	for _, lease := range leases {
+		p.insertLease(lease)
+	}
+	return len(leases), nil
+}
+
+// Write out leases, such as on shutdown, unless they always are already
+func (p *Pool) Flush() error {
+	if !p.persistsInBulk() {
+		return nil
+	}
+	return p.PersistLeases()
+}
+
+// Shared backends are kept up to date a lease at a time as we claim and
+// release them, so only need writing in bulk by PersistLeases
+func (p *Pool) persistsInBulk() bool {
+	if p.Persistence == nil {
+		return false
+	}
+	_, ok := p.sharedPersistence()
+	return !ok
+}
+
+// Have the leases written out soon by a writer in the background, started
+// the first time there's anything to write
+func (p *Pool) persistLater() {
+	if !p.persistsInBulk() {
+		return
+	}
+	p.persistOnce.Do(func() {
+		p.persistDirty = make(chan struct{}, 1)
+		go p.persistInBackground()
+	})
+
+	// A write already pending picks this change up too
+	select {
+	case p.persistDirty <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pool) persistInBackground() {
+	for range p.persistDirty {
+		if err := p.PersistLeases(); err != nil {
+			log.Printf("Failed writing leases of pool %v: %v", p.Name, err)
+		}
+	}
+}
+
+// Copy of every lease, by IP, for writing out
+func (p *Pool) snapshot() map[dhcp4.FixedV4]*Lease {
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	leases := make(map[dhcp4.FixedV4]*Lease, len(p.leaseByIp))
+	for ip, lease := range p.leaseByIp {
+		if copied := p.copyLease(lease); !copied.Offered {
+			leases[ip] = &copied
+		}
+	}
+	return leases
+}
+
+// Whether our lease backend is usable, if it can tell
+func (p *Pool) CheckPersistence() error {
+	if checked, ok := p.Persistence.(CheckedPersistence); ok {
+		return checked.Check()
+	}
+	return nil
+}
+
+func (p *Pool) sharedPersistence() (SharedPersistence, bool) {
+	shared, ok := p.Persistence.(SharedPersistence)
+	return shared, ok
+}
+
+// Must be called with alloc held
+func (p *Pool) lookupSharedLease(mac dhcp4.HardwareAddr) (*Lease, bool) {
+	shared, ok := p.sharedPersistence()
+	if !ok {
+		return nil, false
+	}
+	lease, err := shared.LookupLease(mac)
+	if err != nil {
+		log.Printf("Failed looking up lease for %v in pool %v: %v", mac.String(), p.Name, err)
+		return nil, false
+	}
+	if lease == nil {
+		return nil, false
+	}
+	if existing, ok := p.leaseByIp[lease.IP]; ok {
+		p.deleteLease(existing)
+	}
+	p.insertLease(lease)
+	return lease, true
+}
+
+// Make sure nobody sharing our backend holds this lease's IP. If someone
+// does, their lease replaces ours. Must be called with alloc held
+func (p *Pool) claimSharedLease(lease *Lease) bool {
+	shared, ok := p.sharedPersistence()
+	if !ok {
+		return true
+	}
+	claim := p.copyLease(lease)
+	holder, err := shared.ClaimLease(&claim)
+	if err != nil {
+		log.Printf("Failed claiming %v in pool %v: %v", lease.IP.String(), p.Name, err)
+		p.deleteLease(lease)
+		return false
+	}
+	if holder != nil {
+		p.deleteLease(lease)
+		if existing, ok := p.lookupLease(holder.Mac); ok {
+			p.deleteLease(existing)
+		}
+		p.insertLease(holder)
+		return false
+	}
+	return true
+}
+
+func (p *Pool) releaseSharedLease(lease *Lease) {
+	shared, ok := p.sharedPersistence()
+	if !ok {
+		return
+	}
+	claim := p.copyLease(lease)
+	if err := shared.ReleaseLease(&claim); err != nil {
+		log.Printf("Failed releasing %v in pool %v: %v", lease.IP.String(), p.Name, err)
+	}
+}
@@ -0,0 +1,593 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func newTestPool() *Pool {
+	pool := NewPool()
+	pool.Start = net.ParseIP("10.0.0.10")
+	pool.End = net.ParseIP("10.0.0.20")
+	pool.Netmask = net.ParseIP("255.255.255.0")
+	pool.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.254"))
+	return pool
+}
+
+func TestIpAllocation(t *testing.T) {
+	// Pool with deliberately only 2 available IPs
+	pool := NewPool()
+	pool.Start = net.ParseIP("172.0.0.10")
+	pool.End = net.ParseIP("172.0.0.11")
+	pool.Netmask = net.ParseIP("255.255.255.0")
+	pool.LeaseTime = time.Duration(1) * time.Hour
+
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	mac3 := dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware()
+
+	// Verify initial IP lease acquisition works
+	lease1, err := pool.GetNextLease(mac1, "host1")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.0.0.10")), lease1.IP)
+	require.Equal(t, mac1, lease1.Mac)
+	require.Equal(t, "host1", lease1.Hostname)
+	require.False(t, lease1.Expired())
+	orig_time := lease1.Expiration
+
+	// And that when we bump it, its expiration gets bumped accordingly
+	lease1Fetched, ok := pool.TouchLeaseByMac(mac1)
+	require.True(t, ok)
+	require.True(t, lease1Fetched.Expiration.After(orig_time))
+
+	// And that another host is able to get the next free IP
+	lease2, err := pool.GetNextLease(mac2, "host2")
+	require.Nil(t, err)
+	require.Equal(t, mac2, lease2.Mac)
+	require.Equal(t, "host2", lease2.Hostname)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.0.0.11")), lease2.IP)
+	require.False(t, lease2.Expired())
+
+	// No free Ips for lease3 so it will fail
+	lease3, err := pool.GetNextLease(mac3, "host3")
+	require.Equal(t, ErrNoIps, err)
+	require.Nil(t, lease3)
+
+	// However, if we expire lease1, host3 will get its IP
+	lease1.Expiration = time.Now().Add(time.Duration(-1) * time.Hour)
+	require.True(t, lease1.Expired())
+
+	lease3, err = pool.GetNextLease(mac3, "host3")
+	require.Nil(t, err)
+	require.Equal(t, mac3, lease3.Mac)
+	require.Equal(t, "host3", lease3.Hostname)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.0.0.10")), lease3.IP)
+}
+
+// Test IP allocation with reserved mac addresses
+func TestIpReservedAllocation(t *testing.T) {
+	pool := NewPool()
+	pool.Start = net.ParseIP("172.0.0.10")
+	pool.End = net.ParseIP("172.0.0.12")
+	pool.Netmask = net.ParseIP("255.255.255.0")
+	pool.LeaseTime = time.Duration(1) * time.Hour
+
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+
+	// Bind mac2 to 172.0.0.10. Deliberately choose an IP in our range to
+	// verify that overlaps are ignored
+	err := pool.AddReservedHost(&ReservedHost{
+		Mac: mac2,
+		IP:  dhcp4.IpToFixedV4(net.ParseIP("172.0.0.10")),
+	})
+	require.Nil(t, err)
+
+	// Verify initial IP lease acquisition chooses the IP after the reserved
+	lease1, err := pool.GetNextLease(mac1, "host1")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.0.0.11")), lease1.IP)
+	require.Equal(t, mac1, lease1.Mac)
+	require.Equal(t, "host1", lease1.Hostname)
+	require.False(t, lease1.Expired())
+
+	// Verify custom allocation works
+	lease2, err := pool.GetNextLease(mac2, "host2")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.0.0.10")), lease2.IP)
+	require.Equal(t, mac2, lease2.Mac)
+	require.Equal(t, "host2", lease2.Hostname)
+	require.False(t, lease2.Expired())
+}
+
+func TestLeaseTimeFor(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.ClassLeaseTimes = map[string]time.Duration{"guest": 10 * time.Minute}
+
+	server := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	err := pool.AddReservedHost(&ReservedHost{
+		Mac:       server,
+		IP:        dhcp4.IpToFixedV4(net.ParseIP("10.0.0.5")),
+		LeaseTime: 7 * 24 * time.Hour,
+	})
+	require.Nil(t, err)
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+
+	// Reservations win over classes, which win over the pool
+	require.Equal(t, 7*24*time.Hour, pool.LeaseTimeFor(server, "guest"))
+	require.Equal(t, 10*time.Minute, pool.LeaseTimeFor(other, "guest"))
+	require.Equal(t, time.Hour, pool.LeaseTimeFor(other, "staff"))
+	require.Equal(t, time.Hour, pool.LeaseTimeFor(other, ""))
+
+	// Offers are only held for the offer time when that's shorter
+	pool.OfferTime = 30 * time.Minute
+	lease, err := pool.OfferLeaseFor(other, "", 10*time.Minute)
+	require.Nil(t, err)
+	require.True(t, lease.Expiration.Before(time.Now().Add(11*time.Minute)))
+
+	lease, ok := pool.TouchLease(other, 10*time.Minute)
+	require.True(t, ok)
+	require.True(t, lease.Expiration.Before(time.Now().Add(11*time.Minute)))
+
+	lease, err = pool.GetNextLease(server, "")
+	require.Nil(t, err)
+	require.True(t, lease.Expiration.After(time.Now().Add(6*24*time.Hour)))
+}
+
+// Stand-in for a backend shared with other servers
+type memorySharedPersistence struct {
+	leases map[dhcp4.FixedV4]*Lease
+}
+
+func (m *memorySharedPersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	return map[dhcp4.FixedV4]*Lease{}, nil
+}
+
+func (m *memorySharedPersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	return nil
+}
+
+func (m *memorySharedPersistence) ClaimLease(lease *Lease) (*Lease, error) {
+	if holder, ok := m.leases[lease.IP]; ok && holder.Mac != lease.Mac {
+		copied := *holder
+		return &copied, nil
+	}
+	copied := *lease
+	m.leases[lease.IP] = &copied
+	return nil, nil
+}
+
+func (m *memorySharedPersistence) LookupLease(mac dhcp4.HardwareAddr) (*Lease, error) {
+	for _, lease := range m.leases {
+		if lease.Mac == mac {
+			copied := *lease
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memorySharedPersistence) ReleaseLease(lease *Lease) error {
+	delete(m.leases, lease.IP)
+	return nil
+}
+
+func TestReadOnlyPersistence(t *testing.T) {
+	shared := &memorySharedPersistence{leases: map[dhcp4.FixedV4]*Lease{}}
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.Persistence = NewReadOnlyPersistence(shared)
+
+	// Allocated in memory, without claiming anything in the backend
+	_, err := pool.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	require.Nil(t, err)
+	require.Empty(t, shared.leases)
+}
+
+func TestSharedPersistence(t *testing.T) {
+	shared := &memorySharedPersistence{leases: map[dhcp4.FixedV4]*Lease{}}
+
+	pool1 := newTestPool()
+	pool1.LeaseTime = time.Hour
+	pool1.Persistence = shared
+	pool2 := newTestPool()
+	pool2.LeaseTime = time.Hour
+	pool2.Persistence = shared
+
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+
+	lease1, err := pool1.GetNextLease(mac1, "")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), lease1.IP)
+
+	// The second server loses the race for the first IP, and moves on
+	lease2, err := pool2.GetNextLease(mac2, "")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.11")), lease2.IP)
+
+	// Either server can renew a lease handed out by the other
+	lease, ok := pool2.TouchLeaseByMac(mac1)
+	require.True(t, ok)
+	require.Equal(t, lease1.IP, lease.IP)
+
+	// Released leases are free for anyone
+	_, ok = pool1.ReleaseLeaseByMac(mac1)
+	require.True(t, ok)
+	require.Len(t, shared.leases, 1)
+}
+
+func TestSharedReservedIpHeld(t *testing.T) {
+	shared := &memorySharedPersistence{leases: map[dhcp4.FixedV4]*Lease{}}
+	ip := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	shared.leases[ip] = &Lease{IP: ip, Mac: other, Expiration: time.Now().Add(time.Hour)}
+
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.Persistence = shared
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	require.Nil(t, pool.AddReservedHost(&ReservedHost{Mac: mac, IP: ip}))
+
+	// Gives up rather than asking for the reserved IP again and again
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.GetNextLease(mac, "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Allocation didn't give up on a reserved IP held elsewhere")
+	}
+
+	// And the pool is still usable
+	lease, err := pool.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware(), "")
+	require.Nil(t, err)
+	require.NotEqual(t, ip, lease.IP)
+}
+
+func TestConcurrentLeases(t *testing.T) {
+	pool := NewPool()
+	pool.Start = net.ParseIP("10.0.0.1")
+	pool.End = net.ParseIP("10.0.0.254")
+	pool.LeaseTime = time.Hour
+
+	// Clients getting leases and renewing them all at once
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(mac dhcp4.HardwareAddr) {
+			defer wg.Done()
+			_, err := pool.GetNextLease(mac, "")
+			require.Nil(t, err)
+			_, ok := pool.TouchLeaseByMac(mac)
+			require.True(t, ok)
+			pool.NoteTransaction(mac, Transaction{})
+		}(dhcp4.MacAddress{0, 0, 0, 0, byte(i >> 8), byte(i)}.Hardware())
+	}
+	wg.Wait()
+
+	// Each with its own IP
+	leases := pool.GetLeases()
+	require.Len(t, leases, 200)
+	seen := map[dhcp4.FixedV4]dhcp4.HardwareAddr{}
+	for _, lease := range leases {
+		_, ok := seen[lease.IP]
+		require.False(t, ok)
+		seen[lease.IP] = lease.Mac
+
+		byMac, ok := pool.GetLeaseByMac(lease.Mac)
+		require.True(t, ok)
+		require.Equal(t, lease.IP, byMac.IP)
+	}
+}
+
+func BenchmarkGetNextLease(b *testing.B) {
+	pool := NewPool()
+	pool.Start = net.ParseIP("10.0.0.0")
+	pool.End = net.ParseIP("10.0.255.255")
+	pool.LeaseTime = time.Hour
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		mac := dhcp4.MacAddress{0, 0, byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)}.Hardware()
+		if _, err := pool.GetNextLease(mac, ""); err != nil {
+			// Out of IPs; start again
+			b.StopTimer()
+			pool.clearLeases()
+			b.StartTimer()
+		}
+	}
+}
+
+func BenchmarkTouchLease(b *testing.B) {
+	pool := NewPool()
+	pool.Start = net.ParseIP("10.0.0.0")
+	pool.End = net.ParseIP("10.0.3.255")
+	pool.LeaseTime = time.Hour
+
+	var macs []dhcp4.HardwareAddr
+	for i := 0; i < 1024; i++ {
+		mac := dhcp4.MacAddress{0, 0, 0, 0, byte(i >> 8), byte(i)}.Hardware()
+		_, err := pool.GetNextLease(mac, "")
+		require.Nil(b, err)
+		macs = append(macs, mac)
+	}
+
+	// Renewals by many clients at once
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			pool.TouchLeaseByMac(macs[i%len(macs)])
+			i++
+		}
+	})
+}
+
+func TestHardwareAddrPersistence(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.Persistence = NewFilePersistence(filepath.Join(t.TempDir(), "leases.json"))
+
+	macs := []dhcp4.HardwareAddr{
+		dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(),
+		dhcp4.NewHardwareAddr(dhcp4.HTYPE_IEEE802, []byte{0, 0, 0, 0, 0, 1}),
+		dhcp4.NewHardwareAddr(dhcp4.HTYPE_IEEE1394, []byte{0, 0, 0, 0, 0, 1, 2, 3}),
+	}
+	for _, mac := range macs {
+		_, err := pool.GetNextLease(mac, "")
+		require.Nil(t, err)
+	}
+	require.Nil(t, pool.Flush())
+
+	loaded := newTestPool()
+	loaded.Persistence = pool.Persistence
+	count, err := loaded.LoadLeases()
+	require.Nil(t, err)
+	require.Equal(t, 3, count)
+	for _, mac := range macs {
+		expected, ok := pool.GetLeaseByMac(mac)
+		require.True(t, ok)
+		lease, ok := loaded.GetLeaseByMac(mac)
+		require.True(t, ok)
+		require.Equal(t, expected.IP, lease.IP)
+	}
+}
+
+// Backend whose writes wait until let go of
+type blockingPersistence struct {
+	proceed chan struct{}
+	writes  atomic.Int32
+}
+
+func (b *blockingPersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	return map[dhcp4.FixedV4]*Lease{}, nil
+}
+
+func (b *blockingPersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	<-b.proceed
+	b.writes.Add(1)
+	return nil
+}
+
+func TestPersistInBackground(t *testing.T) {
+	backend := &blockingPersistence{proceed: make(chan struct{})}
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.Persistence = backend
+
+	// Changes don't wait on writes, and those made while one is under way
+	// are written together
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	_, err := pool.GetNextLease(mac, "")
+	require.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, ok := pool.RenewLease(mac, time.Hour)
+		require.True(t, ok)
+	}
+	close(backend.proceed)
+	require.Eventually(t, func() bool {
+		return backend.writes.Load() >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.LessOrEqual(t, backend.writes.Load(), int32(2))
+}
+
+func TestOfferHold(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.OfferTime = time.Minute
+	pool.Persistence = NewFilePersistence(filepath.Join(t.TempDir(), "leases.json"))
+	var events []string
+	pool.AddObserver(func(event LeaseEvent) {
+		events = append(events, event.Kind)
+	})
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	offer, err := pool.OfferLeaseFor(mac, "", time.Hour)
+	require.Nil(t, err)
+	require.True(t, offer.Offered)
+
+	// Offers are kept apart from leases, and aren't written out or announced
+	require.Empty(t, pool.GetLeases())
+	require.Len(t, pool.GetOffers(), 1)
+	require.Empty(t, events)
+	loaded := newTestPool()
+	loaded.Persistence = pool.Persistence
+	count, err := loaded.LoadLeases()
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+
+	// Offering again holds the same IP, and renewing doesn't take it up
+	again, err := pool.OfferLeaseFor(mac, "", time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, offer.IP, again.IP)
+	_, ok := pool.RenewLease(mac, time.Hour)
+	require.False(t, ok)
+
+	// Requesting it does
+	lease, ok := pool.TouchLease(mac, time.Hour)
+	require.True(t, ok)
+	require.False(t, lease.Offered)
+	require.Equal(t, []string{LEASE_CREATED}, events)
+	require.Len(t, pool.GetLeases(), 1)
+	require.Empty(t, pool.GetOffers())
+	_, ok = pool.RenewLease(mac, time.Hour)
+	require.True(t, ok)
+	require.Equal(t, []string{LEASE_CREATED, LEASE_RENEWED}, events)
+
+	// Offers which are never requested are freed once their hold is up
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	offer, err = pool.OfferLeaseFor(other, "", time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, 0, pool.ExpireOffers())
+	pool.shard(other).m.Lock()
+	offer.Expiration = time.Now().Add(-time.Second)
+	pool.shard(other).m.Unlock()
+	require.Equal(t, 1, pool.ExpireOffers())
+	_, ok = pool.GetLeaseByIp(offer.IP)
+	require.False(t, ok)
+	require.Len(t, pool.GetLeases(), 1)
+}
+
+func TestDraining(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.BootpStart = net.ParseIP("10.0.0.30")
+	pool.BootpEnd = net.ParseIP("10.0.0.31")
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	offered := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	bootp := dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware()
+	lease, err := pool.GetNextLease(mac, "")
+	require.Nil(t, err)
+	offer, err := pool.OfferLeaseFor(offered, "", time.Hour)
+	require.Nil(t, err)
+	_, err = pool.GetBootpLease(bootp)
+	require.Nil(t, err)
+
+	// Existing leases and offers carry on, but there are no new ones
+	pool.SetDraining(true)
+	require.True(t, pool.Draining())
+	renewed, ok := pool.RenewLease(mac, time.Hour)
+	require.True(t, ok)
+	require.Equal(t, lease.IP, renewed.IP)
+	again, err := pool.OfferLeaseFor(offered, "", time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, offer.IP, again.IP)
+	_, err = pool.GetBootpLease(bootp)
+	require.Nil(t, err)
+
+	_, err = pool.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware(), "")
+	require.Equal(t, ErrDraining, err)
+	_, err = pool.OfferLeaseFor(dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware(), "", time.Hour)
+	require.Equal(t, ErrDraining, err)
+	_, err = pool.GetBootpLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware())
+	require.Equal(t, ErrDraining, err)
+
+	pool.SetDraining(false)
+	_, err = pool.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware(), "")
+	require.Nil(t, err)
+}
+
+func TestStickyLeases(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	mac := func(i byte) dhcp4.HardwareAddr {
+		return dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware()
+	}
+
+	first, err := pool.GetNextLease(mac(1), "")
+	require.Nil(t, err)
+	_, err = pool.GetNextLease(mac(2), "")
+	require.Nil(t, err)
+	_, ok := pool.ReleaseLeaseByMac(mac(1))
+	require.True(t, ok)
+
+	// New clients get IPs nobody's had, leaving the released one be
+	lease, err := pool.GetNextLease(mac(3), "")
+	require.Nil(t, err)
+	require.NotEqual(t, first.IP, lease.IP)
+
+	// For its last holder to come back to
+	lease, err = pool.GetNextLease(mac(1), "")
+	require.Nil(t, err)
+	require.Equal(t, first.IP, lease.IP)
+
+	// Even once another client's expired lease is on it
+	_, ok = pool.ReleaseLeaseByMac(mac(1))
+	require.True(t, ok)
+	for i := byte(4); i <= 11; i++ {
+		_, err = pool.GetNextLease(mac(i), "")
+		require.Nil(t, err)
+	}
+	taken, err := pool.GetNextLease(mac(12), "")
+	require.Nil(t, err)
+	require.Equal(t, first.IP, taken.IP)
+	_, err = pool.GetNextLease(mac(13), "")
+	require.Equal(t, ErrNoIps, err)
+
+	s := pool.shard(mac(12))
+	s.m.Lock()
+	taken.Expiration = time.Now().Add(-time.Second)
+	s.m.Unlock()
+	_, ok = pool.ReleaseLeaseByMac(mac(2))
+	require.True(t, ok)
+	lease, err = pool.GetNextLease(mac(1), "")
+	require.Nil(t, err)
+	require.Equal(t, first.IP, lease.IP)
+}
+
+func TestCheckPersistence(t *testing.T) {
+	pool := newTestPool()
+	require.Nil(t, pool.CheckPersistence())
+
+	dir := t.TempDir()
+	pool.Persistence = NewFilePersistence(filepath.Join(dir, "leases.json"))
+	require.Nil(t, pool.CheckPersistence())
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	pool.Persistence = NewFilePersistence(filepath.Join(dir, "missing", "leases.json"))
+	require.NotNil(t, pool.CheckPersistence())
+}
+
+func TestOverlap(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "first"
+	other := newTestPool()
+	other.Name = "second"
+	other.Start = net.ParseIP("10.0.0.20")
+	other.End = net.ParseIP("10.0.0.30")
+
+	// Sharing even one IP is too many
+	require.EqualError(t, pool.Overlap(other), "Range 10.0.0.10-10.0.0.20 of pool first overlaps range 10.0.0.20-10.0.0.30 of pool second")
+
+	other.Start = net.ParseIP("10.0.0.21")
+	require.Nil(t, pool.Overlap(other))
+
+	other.BootpStart = net.ParseIP("10.0.0.5")
+	other.BootpEnd = net.ParseIP("10.0.0.10")
+	require.NotNil(t, pool.Overlap(other))
+	other.BootpEnd = net.ParseIP("10.0.0.9")
+	require.Nil(t, pool.Overlap(other))
+
+	// Nor can either reserve an IP the other hands out
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	require.Nil(t, other.AddReservedHost(&ReservedHost{Mac: mac, IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))}))
+	require.EqualError(t, pool.Overlap(other), "IP 10.0.0.15 reserved in pool second could be handed out by pool first")
+	require.NotNil(t, other.Overlap(pool))
+}
@@ -0,0 +1,305 @@
+package pool
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Lease backend in PostgreSQL, for when lease data needs to be durable and
+// queryable. Like the Redis backend it can be shared by several servers,
+// with claims done in transactions so two can't take the same IP. Every
+// change is also recorded in lease_history for auditing.
+//
+
+// Applied in order, each in its own transaction. Only ever append to this
+var postgresMigrations = []string{
+	`CREATE TABLE leases (
+		pool             text        NOT NULL,
+		ip               inet        NOT NULL,
+		mac              text        NOT NULL,
+		hostname         text        NOT NULL DEFAULT '',
+		expiration       timestamptz NOT NULL,
+		last_transaction timestamptz,
+		relay_agent_info bytea,
+		PRIMARY KEY (pool, ip)
+	);
+	CREATE UNIQUE INDEX leases_pool_mac ON leases (pool, mac);
+
+	CREATE TABLE lease_history (
+		id         bigserial   PRIMARY KEY,
+		at         timestamptz NOT NULL DEFAULT now(),
+		event      text        NOT NULL,
+		pool       text        NOT NULL,
+		ip         inet        NOT NULL,
+		mac        text        NOT NULL,
+		hostname   text        NOT NULL DEFAULT '',
+		expiration timestamptz
+	);
+	CREATE INDEX lease_history_mac ON lease_history (mac, at);
+	CREATE INDEX lease_history_ip ON lease_history (ip, at);
+
+	CREATE VIEW active_leases AS
+		SELECT * FROM leases WHERE expiration > now();`,
+
+	`ALTER TABLE leases ADD COLUMN mud_url text NOT NULL DEFAULT '';
+	CREATE OR REPLACE VIEW active_leases AS
+		SELECT * FROM leases WHERE expiration > now();`,
+
+	`ALTER TABLE leases ADD COLUMN fingerprint text NOT NULL DEFAULT '';
+	ALTER TABLE leases ADD COLUMN vendor_class text NOT NULL DEFAULT '';
+	CREATE INDEX leases_fingerprint ON leases (fingerprint);
+	CREATE OR REPLACE VIEW active_leases AS
+		SELECT * FROM leases WHERE expiration > now();`,
+
+	`ALTER TABLE leases ADD COLUMN duid text NOT NULL DEFAULT '';
+	CREATE INDEX leases_duid ON leases (duid);
+	CREATE OR REPLACE VIEW active_leases AS
+		SELECT * FROM leases WHERE expiration > now();`,
+
+	`ALTER TABLE leases ADD COLUMN client_id bytea NOT NULL DEFAULT '';
+	CREATE OR REPLACE VIEW active_leases AS
+		SELECT * FROM leases WHERE expiration > now();`,
+}
+
+func OpenPostgres(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migratePostgres(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(postgresMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(postgresMigrations[version-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed applying schema migration %v: %v", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied schema migration %v", version)
+	}
+	return nil
+}
+
+type PostgresPersistence struct {
+	db   *sql.DB
+	pool string
+}
+
+func NewPostgresPersistence(db *sql.DB, pool string) *PostgresPersistence {
+	return &PostgresPersistence{db, pool}
+}
+
+// Implemented by both *sql.DB and *sql.Tx
+type postgresQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+const postgresLeaseColumns = `host(ip), mac, hostname, expiration, last_transaction, relay_agent_info, mud_url, fingerprint, vendor_class, duid, client_id`
+
+// Implemented by both *sql.Row and *sql.Rows
+type postgresScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPostgresLease(row postgresScanner) (*Lease, error) {
+	var ip, mac string
+	var lastTransaction sql.NullTime
+	lease := &Lease{}
+	err := row.Scan(&ip, &mac, &lease.Hostname, &lease.Expiration, &lastTransaction, &lease.RelayAgentInfo, &lease.MudUrl, &lease.Fingerprint, &lease.VendorClass, &lease.Duid, &lease.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	lease.IP = dhcp4.IpToFixedV4(net.ParseIP(ip))
+	lease.Mac = dhcp4.StrToHardwareAddr(mac)
+	lease.Expiration = lease.Expiration.UTC()
+	lease.LastTransaction = lastTransaction.Time
+	return lease, nil
+}
+
+func (p *PostgresPersistence) Check() error {
+	return p.db.Ping()
+}
+
+func (p *PostgresPersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	rows, err := p.db.Query(`SELECT `+postgresLeaseColumns+` FROM leases WHERE pool = $1 AND expiration > now()`, p.pool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leases := map[dhcp4.FixedV4]*Lease{}
+	for rows.Next() {
+		lease, err := scanPostgresLease(rows)
+		if err != nil {
+			return nil, err
+		}
+		leases[lease.IP] = lease
+	}
+	return leases, rows.Err()
+}
+
+// Store the lease unless another mac holds its IP, in which case their lease
+// is returned. Otherwise returns whether the lease was created or renewed.
+// Must be run in a transaction, which the caller rolls back if the IP was
+// taken
+func (p *PostgresPersistence) claim(tx *sql.Tx, lease *Lease) (*Lease, string, error) {
+	// A client only has one lease per pool
+	_, err := tx.Exec(`DELETE FROM leases WHERE pool = $1 AND mac = $2 AND ip <> $3`,
+		p.pool, lease.Mac.String(), lease.IP.String())
+	if err != nil {
+		return nil, "", err
+	}
+
+	var lastTransaction sql.NullTime
+	if !lease.LastTransaction.IsZero() {
+		lastTransaction = sql.NullTime{Time: lease.LastTransaction, Valid: true}
+	}
+
+	var event string
+	err = tx.QueryRow(`
+		INSERT INTO leases AS l (pool, ip, mac, hostname, expiration, last_transaction, relay_agent_info, mud_url, fingerprint, vendor_class, duid, client_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (pool, ip) DO UPDATE SET
+			mac = EXCLUDED.mac,
+			hostname = EXCLUDED.hostname,
+			expiration = EXCLUDED.expiration,
+			last_transaction = EXCLUDED.last_transaction,
+			relay_agent_info = EXCLUDED.relay_agent_info,
+			mud_url = EXCLUDED.mud_url,
+			fingerprint = EXCLUDED.fingerprint,
+			vendor_class = EXCLUDED.vendor_class,
+			duid = EXCLUDED.duid,
+			client_id = EXCLUDED.client_id
+		WHERE l.mac = EXCLUDED.mac OR l.expiration <= now()
+		RETURNING CASE WHEN xmax = 0 THEN 'created' ELSE 'renewed' END`,
+		p.pool, lease.IP.String(), lease.Mac.String(), lease.Hostname, lease.Expiration,
+		lastTransaction, lease.RelayAgentInfo, lease.MudUrl, lease.Fingerprint, lease.VendorClass, lease.Duid, lease.ClientId).Scan(&event)
+
+	if err == sql.ErrNoRows {
+		holder, err := p.lookup(tx, `ip = $2`, lease.IP.String())
+		return holder, "", err
+	}
+	return nil, event, err
+}
+
+func (p *PostgresPersistence) record(tx *sql.Tx, event string, lease *Lease) error {
+	_, err := tx.Exec(`
+		INSERT INTO lease_history (event, pool, ip, mac, hostname, expiration)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		event, p.pool, lease.IP.String(), lease.Mac.String(), lease.Hostname, lease.Expiration)
+	return err
+}
+
+func (p *PostgresPersistence) lookup(q postgresQuerier, where string, arg interface{}) (*Lease, error) {
+	lease, err := scanPostgresLease(q.QueryRow(
+		`SELECT `+postgresLeaseColumns+` FROM leases WHERE pool = $1 AND `+where+` AND expiration > now()`,
+		p.pool, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return lease, err
+}
+
+// Write out all our unexpired leases, without clobbering any IP someone
+// else has claimed since
+func (p *PostgresPersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, lease := range leases {
+		if lease.Expired() {
+			continue
+		}
+		if _, err := tx.Exec(`SAVEPOINT lease`); err != nil {
+			return err
+		}
+		holder, _, err := p.claim(tx, lease)
+		if err != nil {
+			return err
+		}
+		if holder != nil {
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT lease`); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *PostgresPersistence) ClaimLease(lease *Lease) (*Lease, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	holder, event, err := p.claim(tx, lease)
+	if err != nil || holder != nil {
+		return holder, err
+	}
+	if err := p.record(tx, event, lease); err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+func (p *PostgresPersistence) LookupLease(mac dhcp4.HardwareAddr) (*Lease, error) {
+	return p.lookup(p.db, `mac = $2`, mac.String())
+}
+
+func (p *PostgresPersistence) ReleaseLease(lease *Lease) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM leases WHERE pool = $1 AND ip = $2 AND mac = $3`,
+		p.pool, lease.IP.String(), lease.Mac.String())
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		released := *lease
+		released.Expiration = time.Now()
+		if err := p.record(tx, "released", &released); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
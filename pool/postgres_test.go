@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+// Needs a scratch database, e.g.
+// POSTGRES_TEST_DSN=postgres://postgres@localhost/dhcpd_test?sslmode=disable
+func TestPostgresPersistence(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+
+	db, err := OpenPostgres(dsn)
+	require.Nil(t, err)
+	defer db.Close()
+
+	// Migrating again is a no-op
+	require.Nil(t, migratePostgres(db))
+
+	_, err = db.Exec(`DELETE FROM leases WHERE pool = 'test'`)
+	require.Nil(t, err)
+
+	persistence := NewPostgresPersistence(db, "test")
+
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	lease := &Lease{
+		Mac:        mac1,
+		IP:         dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")),
+		Hostname:   "host1",
+		Expiration: time.Now().Add(time.Hour).Truncate(time.Microsecond).UTC(),
+	}
+
+	holder, err := persistence.ClaimLease(lease)
+	require.Nil(t, err)
+	require.Nil(t, holder)
+
+	// Someone else can't take it
+	other := *lease
+	other.Mac = mac2
+	holder, err = persistence.ClaimLease(&other)
+	require.Nil(t, err)
+	require.Equal(t, lease, holder)
+
+	found, err := persistence.LookupLease(mac1)
+	require.Nil(t, err)
+	require.Equal(t, lease, found)
+
+	leases, err := persistence.LoadLeases()
+	require.Nil(t, err)
+	require.Equal(t, map[dhcp4.FixedV4]*Lease{lease.IP: lease}, leases)
+
+	require.Nil(t, persistence.ReleaseLease(lease))
+	found, err = persistence.LookupLease(mac1)
+	require.Nil(t, err)
+	require.Nil(t, found)
+
+	// Now it's free
+	holder, err = persistence.ClaimLease(&other)
+	require.Nil(t, err)
+	require.Nil(t, holder)
+
+	var events int
+	require.Nil(t, db.QueryRow(`SELECT count(*) FROM lease_history WHERE pool = 'test' AND ip = '10.0.0.10'`).Scan(&events))
+	require.GreaterOrEqual(t, events, 3)
+}
@@ -0,0 +1,418 @@
+package pool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Lease backend in Redis, so several servers can share one view of which
+// IPs are taken. Each lease is stored under its IP with a TTL matching its
+// expiry, along with an index from mac to IP. Claims are done in a Lua
+// script so two servers can't take the same IP.
+//
+
+const redisTimeout = 5 * time.Second
+
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// Minimal RESP client, enough for the handful of commands we need
+type RedisClient struct {
+	address  string
+	password string
+	database int
+
+	m      sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewRedisClient(address, password string, database int) *RedisClient {
+	return &RedisClient{
+		address:  address,
+		password: password,
+		database: database,
+	}
+}
+
+func (c *RedisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.address, redisTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.database != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.database)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	replies, err := c.pipeline(setup)
+	if err == nil {
+		for i, reply := range replies {
+			if redisErr, ok := reply.(RedisError); ok {
+				err = fmt.Errorf("%v failed: %v", setup[i][0], redisErr)
+				break
+			}
+		}
+	}
+	if err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+func (c *RedisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Run a single command
+func (c *RedisClient) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(RedisError); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Send several commands at once and read all of their replies. Errors
+// returned by Redis for individual commands are returned as RedisError
+// values among the replies
+func (c *RedisClient) Pipeline(commands [][]string) ([]interface{}, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	replies, err := c.pipeline(commands)
+	if err != nil {
+		// Start afresh rather than risk reading stale replies
+		c.close()
+	}
+	return replies, err
+}
+
+func (c *RedisClient) pipeline(commands [][]string) ([]interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var buf strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := io.WriteString(c.conn, buf.String()); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		reply, err := readRedisReply(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// Decode a reply into a string, int64, nil, RedisError or a slice of those
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Malformed redis reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return RedisError(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRedisReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("Unknown redis reply type %q", kind)
+}
+
+// Returns the current holder if it's someone else, otherwise stores the
+// lease and points its mac at it, dropping any other IP the mac had
+const redisClaimScript = `
+local current = redis.call('GET', KEYS[1])
+if current then
+  local holder = cjson.decode(current)
+  if holder.Mac ~= ARGV[2] then
+    return current
+  end
+end
+local previous = redis.call('GET', KEYS[2])
+if previous and previous ~= ARGV[4] then
+  redis.call('DEL', ARGV[5] .. previous)
+end
+if ARGV[3] == '0' then
+  redis.call('SET', KEYS[1], ARGV[1])
+  redis.call('SET', KEYS[2], ARGV[4])
+else
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+  redis.call('SET', KEYS[2], ARGV[4], 'PX', ARGV[3])
+end
+return false
+`
+
+const redisReleaseScript = `
+local current = redis.call('GET', KEYS[1])
+if current and cjson.decode(current).Mac == ARGV[1] then
+  redis.call('DEL', KEYS[1])
+end
+if redis.call('GET', KEYS[2]) == ARGV[2] then
+  redis.call('DEL', KEYS[2])
+end
+return 1
+`
+
+type RedisPersistence struct {
+	client *RedisClient
+	prefix string
+}
+
+func NewRedisPersistence(client *RedisClient, prefix string) *RedisPersistence {
+	return &RedisPersistence{client, prefix}
+}
+
+func (p *RedisPersistence) ipPrefix() string {
+	return p.prefix + "ip:"
+}
+
+func (p *RedisPersistence) ipKey(ip dhcp4.FixedV4) string {
+	return p.ipPrefix() + ip.String()
+}
+
+func (p *RedisPersistence) macKey(mac dhcp4.HardwareAddr) string {
+	return p.prefix + "mac:" + mac.String()
+}
+
+// Milliseconds until the lease expires, with 0 meaning never. Negative if
+// it already has
+func redisTTL(lease *Lease) int64 {
+	if lease.Permanent() {
+		return 0
+	}
+	ttl := time.Until(lease.Expiration).Milliseconds()
+	if ttl == 0 {
+		return -1
+	}
+	return ttl
+}
+
+func (p *RedisPersistence) claimCommand(lease *Lease) ([]string, error) {
+	payload, err := json.Marshal(NewFilePersistenceLease(lease))
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"EVAL", redisClaimScript, "2", p.ipKey(lease.IP), p.macKey(lease.Mac),
+		string(payload), lease.Mac.String(), strconv.FormatInt(redisTTL(lease), 10),
+		lease.IP.String(), p.ipPrefix(),
+	}, nil
+}
+
+func decodeRedisLease(reply interface{}) (*Lease, error) {
+	payload, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected redis reply %v", reply)
+	}
+	stored := &FilePersistenceLease{}
+	if err := json.Unmarshal([]byte(payload), stored); err != nil {
+		return nil, err
+	}
+	return stored.ToLease(), nil
+}
+
+func (p *RedisPersistence) Check() error {
+	_, err := p.client.Do("PING")
+	return err
+}
+
+func (p *RedisPersistence) LoadLeases() (map[dhcp4.FixedV4]*Lease, error) {
+	leases := map[dhcp4.FixedV4]*Lease{}
+
+	cursor := "0"
+	for {
+		reply, err := p.client.Do("SCAN", cursor, "MATCH", p.ipPrefix()+"*", "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return nil, fmt.Errorf("Unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = items[0].(string)
+		keys, _ := items[1].([]interface{})
+
+		if len(keys) > 0 {
+			args := []string{"MGET"}
+			for _, key := range keys {
+				args = append(args, fmt.Sprint(key))
+			}
+			values, err := p.client.Do(args...)
+			if err != nil {
+				return nil, err
+			}
+			list, _ := values.([]interface{})
+			for _, value := range list {
+				// Expired between the SCAN and MGET
+				if value == nil {
+					continue
+				}
+				lease, err := decodeRedisLease(value)
+				if err != nil {
+					return nil, err
+				}
+				leases[lease.IP] = lease
+			}
+		}
+
+		if cursor == "0" || cursor == "" {
+			return leases, nil
+		}
+	}
+}
+
+// Write out all our unexpired leases, without clobbering any IP someone
+// else has claimed since
+func (p *RedisPersistence) PersistLeases(leases map[dhcp4.FixedV4]*Lease) error {
+	var commands [][]string
+	for _, lease := range leases {
+		if redisTTL(lease) < 0 {
+			continue
+		}
+		command, err := p.claimCommand(lease)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, command)
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	replies, err := p.client.Pipeline(commands)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(RedisError); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *RedisPersistence) ClaimLease(lease *Lease) (*Lease, error) {
+	if redisTTL(lease) < 0 {
+		return nil, errors.New("Lease has already expired")
+	}
+	command, err := p.claimCommand(lease)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := p.client.Do(command...)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, nil
+	}
+	return decodeRedisLease(reply)
+}
+
+func (p *RedisPersistence) LookupLease(mac dhcp4.HardwareAddr) (*Lease, error) {
+	reply, err := p.client.Do("GET", p.macKey(mac))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	ip, _ := reply.(string)
+
+	reply, err = p.client.Do("GET", p.ipPrefix()+ip)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	lease, err := decodeRedisLease(reply)
+	if err != nil {
+		return nil, err
+	}
+	// Index was stale
+	if lease.Mac != mac {
+		return nil, nil
+	}
+	return lease, nil
+}
+
+func (p *RedisPersistence) ReleaseLease(lease *Lease) error {
+	_, err := p.client.Do("EVAL", redisReleaseScript, "2", p.ipKey(lease.IP), p.macKey(lease.Mac),
+		lease.Mac.String(), lease.IP.String())
+	return err
+}
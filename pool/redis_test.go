@@ -0,0 +1,84 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestRedisClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	received := make(chan []interface{}, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		replies := []string{
+			"+OK\r\n",
+			"$5\r\nhello\r\n",
+			"$-1\r\n",
+			":42\r\n",
+			"*2\r\n$1\r\n0\r\n*1\r\n$3\r\nkey\r\n",
+			"-ERR nope\r\n",
+		}
+		for _, reply := range replies {
+			command, err := readRedisReply(reader)
+			if err != nil {
+				return
+			}
+			received <- command.([]interface{})
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	client := NewRedisClient(ln.Addr().String(), "secret", 0)
+
+	// Authenticates on connecting
+	replies, err := client.Pipeline([][]string{{"GET", "a"}, {"GET", "b"}, {"INCR", "c"}})
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{"hello", nil, int64(42)}, replies)
+	require.Equal(t, []interface{}{"AUTH", "secret"}, <-received)
+	require.Equal(t, []interface{}{"GET", "a"}, <-received)
+
+	reply, err := client.Do("SCAN", "0")
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{"0", []interface{}{"key"}}, reply)
+
+	_, err = client.Do("BOGUS")
+	require.Equal(t, RedisError("ERR nope"), err)
+}
+
+func TestRedisClientAuthFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for _, reply := range []string{"-WRONGPASS invalid username-password pair\r\n", "+OK\r\n"} {
+			if _, err := readRedisReply(reader); err != nil {
+				return
+			}
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	// A wrong password fails connecting, rather than the commands after
+	client := NewRedisClient(ln.Addr().String(), "wrong", 1)
+	_, err = client.Do("GET", "a")
+	require.EqualError(t, err, "AUTH failed: WRONGPASS invalid username-password pair")
+}
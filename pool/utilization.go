@@ -0,0 +1,85 @@
+package pool
+
+import (
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// How much of a pool's dynamic range is in use. Every IP from Start to End
+// is counted as exactly one of reserved, leased, offered, abandoned or free,
+// so they add up to the total
+//
+
+type Utilization struct {
+	Total     int `json:"total"`
+	Leased    int `json:"leased"`
+	Offered   int `json:"offered"`
+	Reserved  int `json:"reserved"`
+	Abandoned int `json:"abandoned"`
+	Free      int `json:"free"`
+}
+
+// Percentage of the range which isn't free
+func (u Utilization) Used() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+	return 100 * float64(u.Total-u.Free) / float64(u.Total)
+}
+
+func (p *Pool) Utilization() Utilization {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	p.alloc.Lock()
+	defer p.alloc.Unlock()
+
+	var u Utilization
+	if p.Start == nil || p.End == nil {
+		return u
+	}
+	start := dhcp4.IpToFixedV4(p.Start)
+	end := dhcp4.IpToFixedV4(p.End)
+	if end < start {
+		return u
+	}
+	u.Total = int(end-start) + 1
+
+	for ip := range p.reservedByIp {
+		if ip >= start && ip <= end {
+			u.Reserved++
+		}
+	}
+	for ip, lease := range p.leaseByIp {
+		if ip < start || ip > end {
+			continue
+		}
+		if _, ok := p.reservedByIp[ip]; ok {
+			continue
+		}
+		current := p.copyLease(lease)
+		switch {
+		case current.Expired():
+		case current.Offered:
+			u.Offered++
+		default:
+			u.Leased++
+		}
+	}
+	now := time.Now()
+	for ip, until := range p.abandoned {
+		if ip < start || ip > end || now.After(until) {
+			continue
+		}
+		if _, ok := p.reservedByIp[ip]; ok {
+			continue
+		}
+		if _, ok := p.leaseByIp[ip]; ok {
+			continue
+		}
+		u.Abandoned++
+	}
+	u.Free = u.Total - u.Reserved - u.Leased - u.Offered - u.Abandoned
+	return u
+}
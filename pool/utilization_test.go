@@ -0,0 +1,49 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestUtilization(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	mac := func(i byte) dhcp4.HardwareAddr {
+		return dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware()
+	}
+
+	require.Equal(t, Utilization{Total: 11, Free: 11}, pool.Utilization())
+
+	require.Nil(t, pool.AddReservedHost(&ReservedHost{Mac: mac(9), IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))}))
+	_, err := pool.GetNextLease(mac(1), "")
+	require.Nil(t, err)
+	_, err = pool.OfferLease(mac(2), "")
+	require.Nil(t, err)
+	declined, err := pool.GetNextLease(mac(3), "")
+	require.Nil(t, err)
+
+	// A declined IP is abandoned rather than leased, and not handed out
+	// again, even to the client which had it
+	_, ok := pool.DeclineLease(mac(3), declined.IP+1)
+	require.False(t, ok)
+	_, ok = pool.DeclineLease(mac(3), declined.IP)
+	require.True(t, ok)
+	lease, err := pool.GetNextLease(mac(3), "")
+	require.Nil(t, err)
+	require.NotEqual(t, declined.IP, lease.IP)
+
+	u := pool.Utilization()
+	require.Equal(t, Utilization{Total: 11, Leased: 2, Offered: 1, Reserved: 1, Abandoned: 1, Free: 6}, u)
+	require.InDelta(t, 100*5.0/11, u.Used(), 0.01)
+
+	// Until it's been abandoned for long enough
+	pool.alloc.Lock()
+	pool.abandoned[declined.IP] = time.Now().Add(-time.Second)
+	pool.alloc.Unlock()
+	require.Equal(t, 0, pool.Utilization().Abandoned)
+}
@@ -0,0 +1,679 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Small HTTP API for operators to poke at a running server. Anything but a
+// GET changes something, so over TCP it needs the configured token as a
+// bearer token, which also keeps web pages from making changes cross-site.
+// On a unix socket, file permissions decide who can connect, so no token
+// is needed. Listening beyond loopback needs a token, which every request
+// other than health checks must then carry.
+//
+
+// Admin addresses starting with this are unix socket paths
+const ADMIN_UNIX_PREFIX = "unix:"
+
+func (a *App) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/forcerenew", a.adminForceRenew)
+	mux.HandleFunc("/revoke", a.adminRevoke)
+	mux.HandleFunc("/blacklist", a.adminBlacklist)
+	mux.HandleFunc("/drain", a.adminDrain)
+	mux.HandleFunc("/reservations", a.adminReservations)
+	mux.HandleFunc("/trace", a.adminTrace)
+	mux.HandleFunc("/starvation", a.adminStarvation)
+	mux.HandleFunc("/relays", a.adminRelays)
+	mux.HandleFunc("/workers", a.adminWorkers)
+	mux.HandleFunc("/offers", a.adminOffers)
+	mux.HandleFunc("/mud", a.adminMud)
+	mux.HandleFunc("/fingerprints", a.adminFingerprints)
+	mux.HandleFunc("/duid", a.adminDuid)
+	mux.HandleFunc("/hosts", a.adminHosts)
+	mux.HandleFunc("/resolve", a.adminResolve)
+	mux.HandleFunc("/rogue", a.adminRogue)
+	mux.HandleFunc("/utilization", a.adminUtilization)
+	mux.HandleFunc("/metrics", a.adminMetrics)
+	mux.HandleFunc("/healthz", a.adminHealthz)
+	mux.HandleFunc("/readyz", a.adminReadyz)
+	return mux
+}
+
+// Whether an admin address only accepts connections from this host
+func adminLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Bind the admin API, before dropping privileges so privileged ports and
+// socket paths outside a chroot work
+func (a *App) ListenAdmin(addr string) (net.Listener, error) {
+	if path := strings.TrimPrefix(addr, ADMIN_UNIX_PREFIX); path != addr {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0660); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+
+	if !adminLoopback(addr) && a.adminToken == "" {
+		return nil, fmt.Errorf("Admin API on %v, which isn't loopback, needs admintoken", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func (a *App) ServeAdmin(ln net.Listener) error {
+	log.Printf("Admin API listening on %v", ln.Addr().String())
+	handler := a.AdminHandler()
+	if ln.Addr().Network() != "unix" {
+		handler = adminAuth(handler, a.adminToken, !adminLoopback(ln.Addr().String()))
+	}
+	return http.Serve(ln, handler)
+}
+
+// Require the token for changes, and with public for reads too, other than
+// health checks
+func adminAuth(next http.Handler, token string, public bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		read := req.Method == http.MethodGet || req.Method == http.MethodHead
+		probe := req.URL.Path == "/healthz" || req.URL.Path == "/readyz"
+		if read && (!public || probe) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if token == "" {
+			http.Error(w, "Changes need admintoken configured, or the unix socket", http.StatusForbidden)
+			return
+		}
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed writing admin response: %v", err)
+	}
+}
+
+// POST /forcerenew?pool=name[&mac=aa:bb:cc:dd:ee:ff]
+func (a *App) adminForceRenew(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mac *dhcp4.HardwareAddr
+	if s := req.URL.Query().Get("mac"); s != "" {
+		m, err := dhcp4.ParseHardwareAddr(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mac = &m
+	}
+
+	sent, err := a.ForceRenew(req.URL.Query().Get("pool"), mac)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJson(w, map[string]int{"sent": sent})
+}
+
+// Duration from the query, zero if not given
+func durationFromQuery(req *http.Request, name string) (time.Duration, error) {
+	if s := req.URL.Query().Get(name); s != "" {
+		return time.ParseDuration(s)
+	}
+	return 0, nil
+}
+
+// POST /revoke?pool=name&mac=aa:bb:cc:dd:ee:ff[&forcerenew=true][&blacklist=1h]
+func (a *App) adminRevoke(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mac, err := dhcp4.ParseHardwareAddr(req.URL.Query().Get("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	blacklist, err := durationFromQuery(req, "blacklist")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	forceRenew := req.URL.Query().Get("forcerenew") == "true"
+
+	if err := a.Revoke(req.URL.Query().Get("pool"), mac, forceRenew, blacklist); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, map[string]string{"revoked": mac.String()})
+}
+
+// GET /blacklist lists blacklisted clients
+// POST /blacklist?mac=aa:bb:cc:dd:ee:ff[&duration=1h]
+// DELETE /blacklist?mac=aa:bb:cc:dd:ee:ff
+func (a *App) adminBlacklist(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		writeJson(w, a.blacklist.List())
+		return
+	}
+
+	mac, err := dhcp4.ParseHardwareAddr(req.URL.Query().Get("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		duration, err := durationFromQuery(req, "duration")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.blacklist.Add(mac, duration)
+		log.Printf("Blacklisted %v", mac.String())
+		writeJson(w, map[string]string{"blacklisted": mac.String()})
+
+	case http.MethodDelete:
+		if !a.blacklist.Remove(mac) {
+			http.Error(w, mac.String()+" isn't blacklisted", http.StatusNotFound)
+			return
+		}
+		log.Printf("Took %v off the blacklist", mac.String())
+		writeJson(w, map[string]string{"removed": mac.String()})
+
+	default:
+		http.Error(w, "GET, POST or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /drain, or POST or DELETE /drain?pool=name
+func (a *App) adminDrain(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		draining := []string{}
+		for _, p := range a.pools() {
+			if p.Draining() {
+				draining = append(draining, p.Name)
+			}
+		}
+		writeJson(w, draining)
+		return
+	}
+
+	p, err := a.findPoolByName(req.URL.Query().Get("pool"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		p.SetDraining(true)
+		log.Printf("Draining pool %v", p.Name)
+		writeJson(w, map[string]string{"draining": p.Name})
+
+	case http.MethodDelete:
+		if !p.Draining() {
+			http.Error(w, "Pool "+p.Name+" isn't draining", http.StatusNotFound)
+			return
+		}
+		p.SetDraining(false)
+		log.Printf("Stopped draining pool %v", p.Name)
+		writeJson(w, map[string]string{"undrained": p.Name})
+
+	default:
+		http.Error(w, "GET, POST or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+type adminReservation struct {
+	Mac       string `json:"hw,omitempty"`
+	CircuitId string `json:"circuitid,omitempty"`
+	RemoteId  string `json:"remoteid,omitempty"`
+	Duid      string `json:"duid,omitempty"`
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname,omitempty"`
+}
+
+func newAdminReservation(hc HostConf) adminReservation {
+	return adminReservation{hc.Mac, hc.CircuitId, hc.RemoteId, hc.Duid, hc.IP, hc.Hostname}
+}
+
+// GET /reservations?pool=name lists a pool's reservations
+// PUT /reservations?pool=name with a host as in the configuration, as YAML
+// or JSON, adds or changes one
+// DELETE /reservations?pool=name&mac=aa:bb:cc:dd:ee:ff|circuitid=id|remoteid=id|duid=00:01:...
+func (a *App) adminReservations(w http.ResponseWriter, req *http.Request) {
+	poolName := req.URL.Query().Get("pool")
+	if _, err := a.findPoolByName(poolName); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		reservations := []adminReservation{}
+		for _, hc := range a.reservations.List(poolName) {
+			reservations = append(reservations, newAdminReservation(hc))
+		}
+		writeJson(w, reservations)
+
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(req.Body, 1<<16))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var hc HostConf
+		if err := yaml.Unmarshal(body, &hc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.SetReservation(poolName, hc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJson(w, newAdminReservation(hc))
+
+	case http.MethodDelete:
+		query := req.URL.Query()
+		host := HostConf{Mac: query.Get("mac"), CircuitId: query.Get("circuitid"), RemoteId: query.Get("remoteid"), Duid: query.Get("duid")}
+		mac, err := host.Key()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.RemoveReservation(poolName, mac); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJson(w, map[string]string{"removed": mac.String()})
+
+	default:
+		http.Error(w, "GET, PUT or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+func traceKeyFromQuery(req *http.Request) (string, error) {
+	if s := req.URL.Query().Get("mac"); s != "" {
+		mac, err := dhcp4.ParseHardwareAddr(s)
+		if err != nil {
+			return "", err
+		}
+		return macTraceKey(mac), nil
+	}
+	if s := req.URL.Query().Get("client-id"); s != "" {
+		return ParseClientIdTraceKey(s)
+	}
+	return "", errors.New("mac or client-id required")
+}
+
+// GET /trace lists traced clients
+// POST /trace?mac=aa:bb:cc:dd:ee:ff|client-id=01aabbccddeeff[&duration=10m]
+// DELETE /trace?mac=aa:bb:cc:dd:ee:ff|client-id=01aabbccddeeff
+func (a *App) adminTrace(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		writeJson(w, a.tracer.List())
+		return
+	}
+
+	key, err := traceKeyFromQuery(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		var duration time.Duration
+		if s := req.URL.Query().Get("duration"); s != "" {
+			if duration, err = time.ParseDuration(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		a.tracer.Enable(key, duration)
+		log.Printf("Tracing %v", key)
+		writeJson(w, map[string]string{"tracing": key})
+
+	case http.MethodDelete:
+		if !a.tracer.Disable(key) {
+			http.Error(w, "Not tracing "+key, http.StatusNotFound)
+			return
+		}
+		log.Printf("Stopped tracing %v", key)
+		writeJson(w, map[string]string{"stopped": key})
+
+	default:
+		http.Error(w, "GET, POST or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /starvation
+func (a *App) adminStarvation(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.starvation == nil {
+		http.Error(w, "Starvation protection not configured", http.StatusNotFound)
+		return
+	}
+	writeJson(w, a.starvation.Stats())
+}
+
+// GET /rogue
+func (a *App) adminRogue(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.rogue == nil {
+		http.Error(w, "Rogue detection not configured", http.StatusNotFound)
+		return
+	}
+	writeJson(w, a.rogue.Servers())
+}
+
+// GET /relays
+func (a *App) adminRelays(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.relays == nil {
+		http.Error(w, "Relay allowlist not configured", http.StatusNotFound)
+		return
+	}
+	writeJson(w, map[string]interface{}{"dropped": a.relays.Dropped()})
+}
+
+// GET /workers
+func (a *App) adminWorkers(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, a.workers.Stats())
+}
+
+type adminOffer struct {
+	Mac     string    `json:"mac"`
+	IP      string    `json:"ip"`
+	Expires time.Time `json:"expires"`
+}
+
+// GET /offers lists IPs offered but not yet requested, by pool
+func (a *App) adminOffers(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	offers := map[string][]adminOffer{}
+	for _, p := range a.pools() {
+		for _, lease := range p.GetOffers() {
+			offers[p.Name] = append(offers[p.Name], adminOffer{lease.Mac.String(), lease.IP.String(), lease.Expiration})
+		}
+	}
+	writeJson(w, offers)
+}
+
+type adminMudLease struct {
+	Mac    string `json:"mac"`
+	IP     string `json:"ip"`
+	MudUrl string `json:"mudurl"`
+}
+
+// GET /mud lists leases of clients which sent a MUD URL, by pool
+func (a *App) adminMud(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	leases := map[string][]adminMudLease{}
+	for _, p := range a.pools() {
+		for _, lease := range p.GetLeases() {
+			if lease.MudUrl != "" && !lease.Expired() {
+				leases[p.Name] = append(leases[p.Name], adminMudLease{lease.Mac.String(), lease.IP.String(), lease.MudUrl})
+			}
+		}
+	}
+	writeJson(w, leases)
+}
+
+type adminFingerprint struct {
+	Mac         string `json:"mac"`
+	IP          string `json:"ip"`
+	Hostname    string `json:"hostname,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	VendorClass string `json:"vendorclass,omitempty"`
+}
+
+// GET /fingerprints[?fingerprint=1,3,6] lists leases with the fingerprint and
+// vendor class of their clients, by pool, optionally only those with one
+// fingerprint
+func (a *App) adminFingerprints(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	only := req.URL.Query().Get("fingerprint")
+	leases := map[string][]adminFingerprint{}
+	for _, p := range a.pools() {
+		for _, lease := range p.GetLeases() {
+			if lease.Fingerprint == "" || lease.Expired() || (only != "" && lease.Fingerprint != only) {
+				continue
+			}
+			leases[p.Name] = append(leases[p.Name], adminFingerprint{
+				lease.Mac.String(), lease.IP.String(), lease.Hostname, lease.Fingerprint, lease.VendorClass,
+			})
+		}
+	}
+	writeJson(w, leases)
+}
+
+type adminDuidLease struct {
+	Pool       string    `json:"pool"`
+	Mac        string    `json:"mac"`
+	IP         string    `json:"ip"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// GET /duid?duid=00:01:... lists the leases a dual-stack client holds by the
+// DUID it also uses over DHCPv6, across pools and its interfaces
+func (a *App) adminDuid(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	duid, err := dhcp4.ParseDUID(req.URL.Query().Get("duid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	leases := []adminDuidLease{}
+	for _, p := range a.pools() {
+		for _, lease := range p.GetLeases() {
+			if lease.Duid == duid.String() && !lease.Expired() {
+				leases = append(leases, adminDuidLease{p.Name, lease.Mac.String(), lease.IP.String(), lease.Hostname, lease.Expiration})
+			}
+		}
+	}
+	writeJson(w, leases)
+}
+
+type adminUtilization struct {
+	pool.Utilization
+	History []UtilizationSample `json:"history"`
+}
+
+// GET /utilization gives current counts for each pool, and recent samples
+func (a *App) adminUtilization(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	history := a.utilization.History()
+	utilization := map[string]adminUtilization{}
+	for _, p := range a.pools() {
+		utilization[p.Name] = adminUtilization{p.Utilization(), history[p.Name]}
+	}
+	writeJson(w, utilization)
+}
+
+// GET /hosts[?format=dnsmasq] shows active leases' hostnames as a hosts file
+// or dnsmasq host-records
+// POST /hosts writes the configured hosts file now
+func (a *App) adminHosts(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = HOSTS_FORMAT_HOSTS
+		}
+		if !validHostsFormat(format) {
+			http.Error(w, "Unknown format", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		WriteHosts(w, a.pools(), format)
+	case http.MethodPost:
+		if a.hostsFile == nil {
+			http.Error(w, "No hosts file configured", http.StatusNotFound)
+			return
+		}
+		written, err := a.hostsFile.Write()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, map[string]bool{"written": written})
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+type adminResolved struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// GET /resolve?name=laptop.example.com or ?ip=10.0.0.10 looks up an active
+// lease's hostname, as the resolver would
+func (a *App) adminResolve(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	resolver := a.resolver
+	if resolver == nil {
+		resolver = newLeaseResolver(a.pools, 0)
+	}
+	if name := req.URL.Query().Get("name"); name != "" {
+		if ip, ok := resolver.Lookup(name); ok {
+			writeJson(w, adminResolved{name, ip.String()})
+			return
+		}
+	} else if ip := net.ParseIP(req.URL.Query().Get("ip")).To4(); ip != nil {
+		if name, ok := resolver.Reverse(dhcp4.IpToFixedV4(ip)); ok {
+			writeJson(w, adminResolved{name, ip.String()})
+			return
+		}
+	} else {
+		http.Error(w, "name or ip required", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Not found", http.StatusNotFound)
+}
+
+// GET /metrics in the Prometheus text format
+func (a *App) adminMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	a.utilization.WriteMetrics(w)
+	a.counters.WriteMetrics(w)
+	if a.unknown != nil {
+		a.unknown.WriteMetrics(w)
+	}
+	if a.quota != nil {
+		a.quota.WriteMetrics(w)
+	}
+	if a.rogue != nil {
+		a.rogue.WriteMetrics(w)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != HEALTH_OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJson(w, report)
+}
+
+// GET /healthz
+func (a *App) adminHealthz(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, a.Health(false))
+}
+
+// GET /readyz
+func (a *App) adminReadyz(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, a.Health(true))
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func newTestApp(t *testing.T, pools ...*pool.Pool) *App {
+	app := NewApp()
+	for _, pool := range pools {
+		require.Nil(t, app.insertPool(pool))
+	}
+
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	t.Cleanup(func() { socket.Close() })
+	app.SetSocket(socket)
+
+	return app
+}
+
+func TestAdminForceRenew(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.Network = net.ParseIP("127.0.0.0")
+	pool.Start = net.ParseIP("127.0.0.1")
+	pool.End = net.ParseIP("127.0.0.2")
+	pool.LeaseTime = time.Hour
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	_, err := pool.GetNextLease(mac, "host1")
+	require.Nil(t, err)
+	_, err = pool.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "host2")
+	require.Nil(t, err)
+
+	handler := newTestApp(t, pool).AdminHandler()
+
+	// Whole pool
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/forcerenew?pool=test", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"sent": 2}`, rec.Body.String())
+
+	// Single client
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/forcerenew?pool=test&mac=0:0:0:0:0:1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"sent": 1}`, rec.Body.String())
+
+	// Unknown client, unknown pool, wrong method
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/forcerenew?pool=test&mac=0:0:0:0:0:9", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/forcerenew?pool=bogus", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/forcerenew?pool=test", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestForceRenewMessage(t *testing.T) {
+	p := newTestPool()
+	lease := &pool.Lease{
+		IP:  dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")),
+		Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(),
+	}
+
+	message := NewForceRenew(p, lease)
+	require.Equal(t, dhcp4.DHCPFORCERENEW, message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, lease.IP, message.Header.ClientAddr)
+	require.Equal(t, lease.Mac, message.Header.Hardware())
+
+	serverId, ok := message.Options.GetIP(dhcp4.OPTION_SERVER_ID)
+	require.True(t, ok)
+	require.Equal(t, p.MyIp, serverId)
+}
+
+func TestAdminTrace(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.AdminHandler()
+
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware())
+	other := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	other.Options.Set(dhcp4.OPTION_CLIENT_ID, []byte{1, 0xaa, 0xbb})
+	require.Equal(t, "", app.tracer.Traced(message))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trace?mac=00:1c:42:b4:6e:1d", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "mac 0:1c:42:b4:6e:1d", app.tracer.Traced(message))
+	require.Equal(t, "", app.tracer.Traced(other))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trace?client-id=01:aa:bb&duration=1h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "client-id 01aabb", app.tracer.Traced(other))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trace", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"mac 0:1c:42:b4:6e:1d":"0001-01-01T00:00:00Z"`)
+	require.Contains(t, rec.Body.String(), `"client-id 01aabb"`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/trace?mac=0:1c:42:b4:6e:1d", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "", app.tracer.Traced(message))
+
+	// Not traced any more, bad client id, bad duration
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/trace?mac=0:1c:42:b4:6e:1d", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trace?client-id=xyz", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trace?mac=0:0:0:0:0:1&duration=soon", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Expired traces stop
+	app.tracer.Enable("mac 0:0:0:0:0:1", -time.Second)
+	require.Equal(t, "client-id 01aabb", app.tracer.Traced(other))
+}
+
+func TestAdminOffers(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.Network = net.ParseIP("127.0.0.0")
+	pool.LeaseTime = time.Hour
+	pool.OfferTime = time.Minute
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	offer, err := pool.OfferLeaseFor(mac, "", time.Hour)
+	require.Nil(t, err)
+	_, err = pool.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "")
+	require.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	newTestApp(t, pool).AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/offers", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var offers map[string][]adminOffer
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &offers))
+	require.Len(t, offers["test"], 1)
+	require.Equal(t, "0:0:0:0:0:1", offers["test"][0].Mac)
+	require.Equal(t, offer.IP.String(), offers["test"][0].IP)
+}
+
+func TestAdminAuth(t *testing.T) {
+	app := newTestApp(t)
+	call := func(handler http.Handler, method, url, token string) int {
+		req := httptest.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// On loopback without a token reads work, but changes are refused
+	local := adminAuth(app.AdminHandler(), "", false)
+	require.Equal(t, http.StatusOK, call(local, http.MethodGet, "/blacklist", ""))
+	require.Equal(t, http.StatusForbidden, call(local, http.MethodPost, "/blacklist?mac=0:0:0:0:0:1", ""))
+
+	// With one, changes need it
+	local = adminAuth(app.AdminHandler(), "secret", false)
+	require.Equal(t, http.StatusUnauthorized, call(local, http.MethodPost, "/blacklist?mac=0:0:0:0:0:1", ""))
+	require.Equal(t, http.StatusUnauthorized, call(local, http.MethodPost, "/blacklist?mac=0:0:0:0:0:1", "wrong"))
+	require.Equal(t, http.StatusOK, call(local, http.MethodPost, "/blacklist?mac=0:0:0:0:0:1", "secret"))
+
+	// Beyond loopback so do reads, other than health checks
+	public := adminAuth(app.AdminHandler(), "secret", true)
+	require.Equal(t, http.StatusUnauthorized, call(public, http.MethodGet, "/blacklist", ""))
+	require.Equal(t, http.StatusOK, call(public, http.MethodGet, "/blacklist", "secret"))
+	require.Equal(t, http.StatusOK, call(public, http.MethodGet, "/healthz", ""))
+
+	// Which needs a token to listen on at all
+	_, err := app.ListenAdmin("0.0.0.0:0")
+	require.NotNil(t, err)
+	ln, err := app.ListenAdmin("127.0.0.1:0")
+	require.Nil(t, err)
+	ln.Close()
+}
+
+func TestAdminUnixSocket(t *testing.T) {
+	app := newTestApp(t)
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	ln, err := app.ListenAdmin(ADMIN_UNIX_PREFIX + path)
+	require.Nil(t, err)
+	go app.ServeAdmin(ln)
+	defer ln.Close()
+
+	// No token needed for changes
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Post("http://admin/blacklist?mac=0:0:0:0:0:1", "", nil)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
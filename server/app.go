@@ -0,0 +1,1043 @@
+package server
+
+import (
+	"golang.org/x/net/ipv4"
+
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+type App struct {
+	ipnet2pool map[dhcp4.HashableIpNet]*pool.Pool
+	shared     map[string][]*pool.Pool
+	interfaces map[string]struct{}
+	hooks      []RequestHook
+	handler    Handler
+	middleware []Middleware
+	serve      Handler
+	dryRun     bool
+	socket     PacketConn
+	sockets    []PacketConn
+	failover   *Failover
+	balancer   *LoadBalancer
+	execHooks  []*ExecHook
+	webhooks   []*Webhook
+	eventBuses []*EventBus
+	capture    *Capture
+	tracer     *Tracer
+	blacklist  *Blacklist
+	otel       *OtelExporter
+	starvation *StarvationGuard
+	auth       *Authenticator
+	relays     *RelayAllowlist
+	audit      *AuditLog
+	workers    *WorkerPool
+	neighbors  *NeighborTable
+	prober     *ArpProber
+	unknown    *UnknownClients
+	quota      *CircuitQuota
+
+	reservations *Reservations
+	utilization  *UtilizationMonitor
+	counters     MessageCounters
+	snmp         *SnmpAgent
+	leaseQuery   *LeaseQueryServer
+	ddns         *DdnsUpdater
+	hostsFile    *HostsExporter
+	resolver     *LeaseResolver
+	ethers       *EthersWatcher
+	rogue        *RogueDetector
+	adminToken   string
+	dedup        *dedupCache
+	stopped      atomic.Bool
+}
+
+func NewApp() *App {
+	workers, _ := NewWorkerPool(&WorkerConf{})
+	a := &App{
+		ipnet2pool: map[dhcp4.HashableIpNet]*pool.Pool{},
+		shared:     map[string][]*pool.Pool{},
+		interfaces: map[string]struct{}{},
+		tracer:     NewTracer(),
+		blacklist:  NewBlacklist(),
+		workers:    workers,
+		handler:    DefaultHandler,
+		serve:      DefaultHandler,
+
+		reservations: NewReservations(""),
+	}
+	a.utilization, _ = NewUtilizationMonitor(&UtilizationConf{}, a.pools)
+	return a
+}
+
+func (a *App) InitConf(conf *Conf) error {
+
+	for _, iface := range conf.Interfaces {
+		a.interfaces[iface] = struct{}{}
+	}
+
+	if len(a.interfaces) == 0 {
+		return errors.New("No interfaces configured")
+	}
+
+	// Dry runs mustn't change anything other servers or clients see,
+	// leaving out whatever acts on leases changing elsewhere
+	a.dryRun = a.dryRun || conf.DryRun
+	if a.dryRun {
+		conf = dryRunConf(conf)
+	}
+
+	newPersistence, err := persistenceFactory(conf)
+	if err != nil {
+		return err
+	}
+	if a.dryRun {
+		backend := newPersistence
+		newPersistence = func(name string) pool.Persistence {
+			return pool.NewReadOnlyPersistence(backend(name))
+		}
+	}
+
+	globalOptions, err := conf.globalOptions()
+	if err != nil {
+		return err
+	}
+
+	a.reservations = NewReservations(conf.Leasedir)
+	for _, pc := range conf.Pools {
+		pool, err := pc.ToPool()
+		if err != nil {
+			return err
+		}
+		hosts, err := pc.Hosts()
+		if err != nil {
+			return err
+		}
+		if err := a.reservations.Load(pool, hosts); err != nil {
+			return err
+		}
+		if pc.Ethers != "" {
+			if a.ethers == nil {
+				a.ethers = NewEthersWatcher(a.reservations)
+			}
+			a.ethers.Add(pool, pc)
+		}
+
+		if pool.Interface != "" && !a.servesInterface(pool.Interface) {
+			return fmt.Errorf("Pool %v is bound to %v, which isn't one of our interfaces", pool.Name, pool.Interface)
+		}
+
+		pool.GlobalOptions = globalOptions
+		pool.Persistence = newPersistence(pool.Name)
+
+		count, err := pool.LoadLeases()
+		if err != nil {
+			return err
+		}
+
+		if count == 1 {
+			log.Printf("Loaded pool %v with %v lease", pool.Name, count)
+		} else {
+			log.Printf("Loaded pool %v with %v leases", pool.Name, count)
+		}
+
+		err = a.insertPool(pool)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Failover != nil {
+		if conf.Backend != nil {
+			return errors.New("Failover replicates leases itself, so can't be used with a shared backend")
+		}
+		for _, pc := range conf.Pools {
+			if pc.Split != nil {
+				return fmt.Errorf("Pool %v can't be split when using failover, which splits every pool", pc.Name)
+			}
+		}
+		a.failover, err = NewFailover(conf.Failover, a.pools())
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.OneLeasePerClient {
+		for _, pool := range a.pools() {
+			pool.AddObserver(a.releaseOtherLeases)
+		}
+	}
+
+	for i := range conf.Exec {
+		hook, err := NewExecHook(&conf.Exec[i])
+		if err != nil {
+			return err
+		}
+		for _, pool := range a.pools() {
+			pool.AddObserver(hook.Observe)
+		}
+		a.execHooks = append(a.execHooks, hook)
+	}
+
+	for i := range conf.Webhooks {
+		webhook, err := NewWebhook(&conf.Webhooks[i])
+		if err != nil {
+			return err
+		}
+		for _, pool := range a.pools() {
+			pool.AddObserver(webhook.Observe)
+		}
+		a.AddHook(webhook.Hook)
+		a.webhooks = append(a.webhooks, webhook)
+	}
+
+	for i := range conf.EventBus {
+		bus, err := NewEventBus(&conf.EventBus[i])
+		if err != nil {
+			return err
+		}
+		for _, pool := range a.pools() {
+			pool.AddObserver(bus.Observe)
+		}
+		a.AddHook(bus.Hook)
+		a.eventBuses = append(a.eventBuses, bus)
+	}
+
+	a.adminToken = conf.AdminToken
+
+	if conf.UnicastReplies {
+		if a.neighbors, err = NewNeighborTable(); err != nil {
+			return err
+		}
+	}
+
+	// Retransmissions skip everything else, other than the blacklist so a
+	// client turned away doesn't keep getting the reply it had
+	a.Use(a.blacklist.Middleware)
+	if conf.Dedup != 0 {
+		a.dedup = newDedupCache(time.Second * time.Duration(conf.Dedup))
+		a.Use(a.dedup.Middleware)
+	}
+
+	if err := a.initClasses(conf.Classes); err != nil {
+		return err
+	}
+
+	a.initUnknownClients()
+	a.initCircuitQuota()
+
+	for _, p := range a.pools() {
+		if p.ProbeTimeout != 0 {
+			if a.prober, err = NewArpProber(); err != nil {
+				return err
+			}
+			a.Use(ProbeMiddleware(a.prober.InUse))
+			break
+		}
+	}
+
+	if len(a.ipnet2pool) > 1 {
+		a.Use(DuplicateGuardMiddleware(a.claimedElsewhere))
+	}
+
+	if len(conf.Relays) != 0 {
+		a.relays, err = NewRelayAllowlist(conf.Relays)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Auth != nil {
+		a.auth, err = NewAuthenticator(conf.Auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Starvation != nil {
+		a.starvation, err = NewStarvationGuard(conf.Starvation)
+		if err != nil {
+			return err
+		}
+		for _, webhook := range a.webhooks {
+			a.starvation.AddAlertHook(webhook.enqueue)
+		}
+		for _, bus := range a.eventBuses {
+			a.starvation.AddAlertHook(bus.enqueue)
+		}
+	}
+
+	if conf.Audit != nil {
+		a.audit, err = NewAuditLog(conf.Audit)
+		if err != nil {
+			return err
+		}
+		a.AddHook(a.audit.Hook)
+	}
+
+	if conf.Otel != nil {
+		a.otel, err = NewOtelExporter(conf.Otel)
+		if err != nil {
+			return err
+		}
+		a.AddHook(a.otel.Hook)
+	}
+
+	if conf.LoadBalance != nil {
+		a.balancer, err = NewLoadBalancer(conf.LoadBalance)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Workers != nil {
+		a.workers, err = NewWorkerPool(conf.Workers)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Snmp != nil {
+		a.snmp, err = NewSnmpAgent(conf.Snmp, &a.counters, a.pools)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.LeaseQuery != nil {
+		a.leaseQuery, err = NewLeaseQueryServer(conf.LeaseQuery, a.pools)
+		if err != nil {
+			return err
+		}
+		for _, pool := range a.pools() {
+			pool.AddObserver(a.leaseQuery.Observe)
+		}
+	}
+
+	if conf.Ddns != nil {
+		a.ddns, err = NewDdnsUpdater(conf.Ddns)
+		if err != nil {
+			return err
+		}
+		for _, pool := range a.pools() {
+			pool.AddObserver(a.ddns.Observe)
+		}
+	}
+
+	if conf.HostsFile != nil {
+		a.hostsFile, err = NewHostsExporter(conf.HostsFile, a.pools)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Resolver != nil {
+		a.resolver, err = NewLeaseResolver(conf.Resolver, a.pools)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Rogue != nil {
+		a.rogue, err = NewRogueDetector(conf.Rogue, a.pools)
+		if err != nil {
+			return err
+		}
+		for _, webhook := range a.webhooks {
+			a.rogue.AddAlertHook(webhook.enqueue)
+		}
+		for _, bus := range a.eventBuses {
+			a.rogue.AddAlertHook(bus.enqueue)
+		}
+	}
+
+	if conf.Utilization != nil {
+		a.utilization, err = NewUtilizationMonitor(conf.Utilization, a.pools)
+		if err != nil {
+			return err
+		}
+		for _, webhook := range a.webhooks {
+			a.utilization.AddAlertHook(webhook.enqueue)
+		}
+		for _, bus := range a.eventBuses {
+			a.utilization.AddAlertHook(bus.enqueue)
+		}
+	}
+
+	return nil
+}
+
+// Returns a function creating the persistence for a pool
+// A copy of conf without failover, DDNS or hooks, for dry runs
+func dryRunConf(conf *Conf) *Conf {
+	dry := *conf
+	if dry.Failover != nil {
+		log.Printf("Dry run: not replicating leases to failover peer")
+		dry.Failover = nil
+	}
+	if dry.Ddns != nil {
+		log.Printf("Dry run: not sending DNS updates")
+		dry.Ddns = nil
+	}
+	if len(dry.Exec)+len(dry.Webhooks)+len(dry.EventBus) != 0 {
+		log.Printf("Dry run: not running exec hooks, webhooks or event buses")
+		dry.Exec, dry.Webhooks, dry.EventBus = nil, nil, nil
+	}
+	return &dry
+}
+
+func persistenceFactory(conf *Conf) (func(string) pool.Persistence, error) {
+	if conf.Backend == nil {
+		return func(name string) pool.Persistence {
+			return pool.NewFilePersistence(filepath.Join(conf.Leasedir, name+".json"))
+		}, nil
+	}
+
+	switch conf.Backend.Type {
+	case "redis":
+		client := pool.NewRedisClient(conf.Backend.Address, conf.Backend.Password, conf.Backend.Database)
+		prefix := conf.Backend.Prefix
+		if prefix == "" {
+			prefix = "golang-dhcpd:"
+		}
+		return func(name string) pool.Persistence {
+			return pool.NewRedisPersistence(client, prefix+name+":")
+		}, nil
+
+	case "postgres":
+		db, err := pool.OpenPostgres(conf.Backend.Address)
+		if err != nil {
+			return nil, err
+		}
+		return func(name string) pool.Persistence {
+			return pool.NewPostgresPersistence(db, name)
+		}, nil
+
+	case "etcd":
+		client := pool.NewEtcdClient(strings.Split(conf.Backend.Address, ","), conf.Backend.Username, conf.Backend.Password)
+		prefix := conf.Backend.Prefix
+		if prefix == "" {
+			prefix = "/golang-dhcpd/"
+		}
+		return func(name string) pool.Persistence {
+			return pool.NewEtcdPersistence(client, prefix+name+"/")
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown lease backend '%v'", conf.Backend.Type)
+}
+
+func (a *App) pools() []*pool.Pool {
+	pools := make([]*pool.Pool, 0, len(a.ipnet2pool))
+	for _, pool := range a.ipnet2pool {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// Start anything which runs in the background
+func (a *App) Start() {
+	if a.failover != nil {
+		go func() {
+			log.Fatalf("Failover failed: %v", a.failover.Run())
+		}()
+	}
+	for _, hook := range a.execHooks {
+		go hook.Run()
+	}
+	for _, webhook := range a.webhooks {
+		go webhook.Run()
+	}
+	for _, bus := range a.eventBuses {
+		go bus.Run()
+	}
+	if a.otel != nil {
+		go a.otel.Run()
+	}
+	if a.snmp != nil {
+		go func() {
+			log.Fatalf("SNMP agent failed: %v", a.snmp.Run())
+		}()
+	}
+	if a.leaseQuery != nil {
+		go func() {
+			log.Fatalf("Leasequery failed: %v", a.leaseQuery.Run())
+		}()
+	}
+	if a.ddns != nil {
+		go a.ddns.Run()
+	}
+	if a.hostsFile != nil {
+		go a.hostsFile.Run()
+	}
+	if a.resolver != nil {
+		go func() {
+			log.Fatalf("Resolver failed: %v", a.resolver.Run())
+		}()
+	}
+	if a.ethers != nil {
+		go a.ethers.Run()
+	}
+	if a.rogue != nil {
+		go func() {
+			log.Fatalf("Rogue detection failed: %v", a.rogue.Run())
+		}()
+	}
+	if a.prober != nil {
+		go a.prober.Run()
+	}
+	go a.utilization.Run()
+	go a.watchExpiry(expiryInterval)
+}
+
+// How long Serve waits for requests being handled once stopped
+const shutdownTimeout = 10 * time.Second
+
+// Handle packets from our sockets until Stop is called, then wait for those
+// being handled to finish
+func (a *App) Serve() error {
+	a.workers.Start(func(p packet) {
+		a.DispatchMessage(p.buffers.data[:p.n], p.buffers.oob[:p.nn], p.remote, p.socket)
+	})
+
+	var readers sync.WaitGroup
+	for i, socket := range a.sockets {
+		readers.Add(1)
+		go func(socket PacketConn, broadcasts bool) {
+			defer readers.Done()
+			a.receive(socket, broadcasts)
+		}(socket, i == 0)
+	}
+	readers.Wait()
+
+	return a.workers.Close(shutdownTimeout)
+}
+
+// Most packets read with one syscall
+const receiveBatch = 32
+
+// Space to receive a packet into, reused once it's been handled
+type packetBuffers struct {
+	data    [1024]byte
+	oob     [1024]byte
+	buffers [1][]byte
+}
+
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		p := &packetBuffers{}
+		p.buffers[0] = p.data[:]
+		return p
+	},
+}
+
+// Read from one socket until it's closed, replying through it. Packets are
+// read in batches with recvmmsg where it's available, to cut syscalls during
+// floods of requests such as when everything boots after a power cut.
+// Sockets sharing port 67 each get a copy of every broadcast, so only the
+// one told to handle broadcasts does, the others only unicast and relayed
+// requests, which the kernel hands to just one of them
+func (a *App) receive(conn PacketConn, broadcasts bool) {
+	messages := make([]ipv4.Message, receiveBatch)
+	held := make([]*packetBuffers, receiveBatch)
+
+	for {
+		// Each is queued for a worker so needs its own buffers
+		for i := range messages {
+			if held[i] == nil {
+				held[i] = packetPool.Get().(*packetBuffers)
+				messages[i].Buffers = held[i].buffers[:]
+				messages[i].OOB = held[i].oob[:]
+			}
+		}
+
+		count, err := conn.ReadBatch(messages, 0)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed accepting: %v", err)
+			continue
+		}
+
+		for i := 0; i < count; i++ {
+			message, buffers := messages[i], held[i]
+			held[i] = nil
+
+			remote, ok := message.Addr.(*net.UDPAddr)
+			if !ok || (!broadcasts && a.broadcastDst(buffers.oob[:message.NN])) {
+				packetPool.Put(buffers)
+				continue
+			}
+			a.workers.Submit(packet{buffers, message.N, message.NN, remote, conn})
+		}
+	}
+}
+
+// Stop receiving packets, making Serve return
+func (a *App) Stop() error {
+	a.stopped.Store(true)
+	var errs []error
+	for _, socket := range a.sockets {
+		if err := socket.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if a.prober != nil {
+		if err := a.prober.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if a.neighbors != nil {
+		if err := a.neighbors.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Write out everything we're holding on to before exiting
+func (a *App) Flush() error {
+	var errs []error
+	for _, pool := range a.pools() {
+		if err := pool.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("Pool %v: %v", pool.Name, err))
+		}
+	}
+	if a.otel != nil {
+		a.otel.Close()
+	}
+	if a.audit != nil {
+		if err := a.audit.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if a.capture != nil {
+		if err := a.capture.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// How often we look for leases which have expired
+const expiryInterval = 10 * time.Second
+
+func (a *App) watchExpiry(interval time.Duration) {
+	last := time.Now()
+	for now := range time.Tick(interval) {
+		for _, pool := range a.pools() {
+			pool.NotifyExpired(last, now)
+			if count := pool.ExpireOffers(); count > 0 {
+				log.Printf("Freed %v IPs offered in pool %v which were never requested", count, pool.Name)
+			}
+		}
+		last = now
+	}
+}
+
+// Write all packets we handle to a pcap file
+func (a *App) SetCapture(capture *Capture) {
+	a.capture = capture
+}
+
+// Socket used for server initiated messages
+func (a *App) SetSocket(socket *net.UDPConn) {
+	a.SetSockets(socket)
+}
+
+// Sockets to receive on, sharing port 67. The first is also used for
+// server initiated messages
+func (a *App) SetSockets(sockets ...*net.UDPConn) {
+	conns := make([]PacketConn, len(sockets))
+	for i, socket := range sockets {
+		conns[i] = NewUDPPacketConn(socket)
+	}
+	a.SetConns(conns...)
+}
+
+// Like SetSockets, for connections other than plain UDP sockets, such as
+// MemoryConn
+func (a *App) SetConns(conns ...PacketConn) {
+	a.socket = conns[0]
+	a.sockets = conns
+}
+
+// Register a hook to be called after each handled request
+func (a *App) AddHook(hook RequestHook) {
+	a.hooks = append(a.hooks, hook)
+}
+
+// Handle requests but only log what we'd answer, e.g. to check a new
+// server's answers against those of the one it's replacing. Must be called
+// before InitConf for leases to only be kept in memory
+func (a *App) SetDryRun(dryRun bool) {
+	a.dryRun = dryRun
+}
+
+// Replace our own allocation logic. Must be called before Start
+func (a *App) SetHandler(handler Handler) {
+	a.handler = handler
+	a.serve = Chain(a.handler, a.middleware...)
+}
+
+// Wrap the handler in middleware, outermost first. Must be called before
+// Start
+func (a *App) Use(middleware ...Middleware) {
+	a.middleware = append(a.middleware, middleware...)
+	a.serve = Chain(a.handler, a.middleware...)
+}
+
+func (a *App) runHooks(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+	for _, hook := range a.hooks {
+		hook(ctx, request, response)
+	}
+}
+
+func (a *App) insertPool(p *pool.Pool) error {
+	ipnet := dhcp4.HashableIpNet{
+		IP:   dhcp4.IpToFixedV4(p.Network),
+		Mask: dhcp4.IpToFixedV4(p.Netmask),
+	}
+
+	if _, ok := a.ipnet2pool[ipnet]; ok {
+		return errors.New("Duplicate IP network between pools")
+	}
+	for _, existing := range a.ipnet2pool {
+		if err := p.Overlap(existing); err != nil {
+			return err
+		}
+	}
+
+	if p.SharedNetwork != "" {
+		if members := a.shared[p.SharedNetwork]; len(members) != 0 &&
+			(members[0].Interface != p.Interface || members[0].Vlan != p.Vlan) {
+			return fmt.Errorf("Pool %v is bound differently to the rest of shared network %v", p.Name, p.SharedNetwork)
+		}
+		a.shared[p.SharedNetwork] = append(a.shared[p.SharedNetwork], p)
+	}
+
+	a.ipnet2pool[ipnet] = p
+
+	return nil
+}
+
+func (a *App) findPoolByName(name string) (*pool.Pool, error) {
+	for _, pool := range a.ipnet2pool {
+		if pool.Name == name {
+			return pool, nil
+		}
+	}
+	return nil, fmt.Errorf("No pool named %v", name)
+}
+
+func (a *App) oObToInterface(oob []byte) (*net.Interface, error) {
+	cm := &ipv4.ControlMessage{}
+
+	if err := cm.Parse(oob); err != nil {
+		return nil, err
+	}
+
+	iface, err := net.InterfaceByIndex(cm.IfIndex)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return iface, nil
+}
+
+// Address the packet was sent to, if we can tell
+func (a *App) outOfBandDestination(oob []byte) net.IP {
+	cm := &ipv4.ControlMessage{}
+	if err := cm.Parse(oob); err != nil {
+		return nil
+	}
+	return cm.Dst
+}
+
+// Whether a packet was sent to the limited broadcast address, or one of our
+// pools' networks' broadcast address
+func (a *App) broadcastDst(oob []byte) bool {
+	dst := a.outOfBandDestination(oob)
+	if dst == nil {
+		return false
+	}
+	if dst.Equal(net.IPv4bcast) {
+		return true
+	}
+	for _, pool := range a.ipnet2pool {
+		if dst.Equal(pool.Broadcast) {
+			return true
+		}
+	}
+	return false
+}
+
+// For non-relayed requests: find a pool by comparing nets to local nic
+// IPs, or failing that the one pool bound to the interface
+func (a *App) findPoolByInterface(iface *net.Interface) (*pool.Pool, error) {
+	addrs, err := iface.Addrs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bound []*pool.Pool
+	for _, pool := range a.ipnet2pool {
+		if (pool.Interface != "" || pool.Vlan != 0) && poolServesInterface(pool, iface.Name) {
+			bound = append(bound, pool)
+		}
+	}
+
+	for _, addr := range addrs {
+		// FIXME: should we verify addr.Network() is first "ip+net" ?
+		_, ipnet, err := net.ParseCIDR(addr.String())
+		if err != nil {
+			continue
+		}
+
+		hipnet, err := dhcp4.IpNet2HashableIpNet(ipnet)
+		if err != nil {
+			continue
+		}
+
+		if pool, ok := a.ipnet2pool[hipnet]; ok && poolServesInterface(pool, iface.Name) {
+			return pool, nil
+		}
+	}
+
+	if len(bound) == 1 {
+		return bound[0], nil
+	}
+	return nil, errors.New("Not found")
+}
+
+// Whether a pool answers requests received on an interface, going by the
+// interface or VLAN it's bound to, if any
+func poolServesInterface(p *pool.Pool, iface string) bool {
+	if p.Interface != "" && p.Interface != iface {
+		return false
+	}
+	if p.Vlan != 0 && p.Vlan != vlanFromInterface(iface) {
+		return false
+	}
+	return true
+}
+
+func interfaceHasIp(iface *net.Interface, ip dhcp4.FixedV4) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip.NetIp()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether we're configured to answer on an interface, either by name or as
+// a VLAN sub-interface of one configured as eth0.*
+func (a *App) servesInterface(name string) bool {
+	if _, ok := a.interfaces[name]; ok {
+		return true
+	}
+	if idx := strings.LastIndex(name, "."); idx != -1 && vlanFromInterface(name) != 0 {
+		_, ok := a.interfaces[name[:idx]+".*"]
+		return ok
+	}
+	return false
+}
+
+// For relayed requests: find a pool by comparing giaddr to configured
+// pool nets
+func (a *App) findPoolbyGiaddr(giaddr dhcp4.FixedV4) (*pool.Pool, error) {
+	for _, pool := range a.ipnet2pool {
+		ipnet := &net.IPNet{
+			IP:   pool.Network,
+			Mask: net.IPMask([]byte(pool.Netmask)),
+		}
+		if ipnet.Contains(giaddr.NetIp()) {
+			return pool, nil
+		}
+	}
+
+	return nil, errors.New("Not found")
+}
+
+func (a *App) DispatchMessage(myBuf, myOob []byte, remote *net.UDPAddr, localSocket PacketConn) {
+	// Sanity remote port check
+	if remote.Port != 67 && remote.Port != 68 {
+		log.Printf("Ignoring DHCP packet with source port %d rather than 67 or 68", remote.Port)
+		return
+	}
+
+	var err error
+
+	// Grab iface and verify we're configured to work on it
+	iface, err := a.oObToInterface(myOob)
+	if err != nil {
+		log.Printf("Failed parsing interface out of OOB: %v", err)
+		return
+	}
+
+	if !a.servesInterface(iface.Name) {
+		log.Printf("Ignoring DHCP traffic on unconfigured interface %v", iface.Name)
+		return
+	}
+
+	// Standing by while our failover peer answers
+	if a.failover != nil && !a.failover.Active() {
+		return
+	}
+
+	ctx := NewRequestContext(iface.Name, remote)
+	ctx.Dst = a.outOfBandDestination(myOob)
+
+	if a.capture != nil {
+		a.capture.Received(remote, ctx.Dst, myBuf)
+	}
+	ctx.Capture = a.capture
+
+	// Parse entire dhcp message
+	message, err := dhcp4.ParseDhcpMessage(myBuf)
+	if err != nil {
+		log.Printf("Failed parsing dhcp packet: %v", err)
+		return
+	}
+	defer message.Release()
+	a.counters.Received(message)
+
+	ctx.Populate(message)
+	ctx.Mark("parsed")
+
+	ctx.Trace = a.tracer.Traced(message)
+	ctx.TraceMessage("received on "+iface.Name+" from "+remote.String(), message)
+
+	// Relayed by someone we don't trust
+	if a.relays != nil && ctx.Relayed() && !a.relays.Allowed(ctx.RelayAddr, remote.IP) {
+		ctx.Tracef("Ignoring as relay %v is not trusted", ctx.RelayAddr.String())
+		return
+	}
+
+	if a.auth != nil {
+		ctx.Auth, err = a.auth.Verify(myBuf, message)
+		if err != nil {
+			log.Printf("Ignoring unauthenticated message from %v: %v", message.Header.Hardware().String(), err)
+			return
+		}
+	}
+
+	// Client belongs to our load balancing peer
+	if a.balancer != nil && !a.balancer.ShouldAnswer(message) {
+		ctx.Tracef("Ignoring as the client belongs to our load balancing peer")
+		return
+	}
+
+	switch {
+	// Leasequeries by IP are answered by whichever pool holds that IP, or
+	// if none do by the relay's, which tells it the IP is unknown
+	case message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPLEASEQUERY && !message.Header.ClientAddr.Empty():
+		ctx.Pool, err = a.findPoolbyGiaddr(message.Header.ClientAddr)
+		if err != nil {
+			ctx.Pool, err = a.findPoolbyGiaddr(ctx.RelayAddr)
+		}
+		if err != nil {
+			log.Printf("Can't find pool for leasequery of %v", message.Header.ClientAddr.String())
+			return
+		}
+
+	// Relayed request. Find pool based on giaddr
+	case ctx.Relayed():
+		ctx.Pool, err = a.findPoolbyGiaddr(ctx.RelayAddr)
+		if err != nil {
+			log.Printf("Can't find pool based on IPs bound to %v", iface.Name)
+			return
+		}
+
+	default:
+		// Renewing clients unicast straight to us from the IP they're
+		// renewing, which may well be behind a relay
+		if unicastRenewal(ctx, message) {
+			ctx.Pool, _ = a.findPoolbyGiaddr(message.Header.ClientAddr)
+		}
+		if ctx.Pool == nil {
+			ctx.Pool, err = a.findPoolByInterface(iface)
+			if err != nil {
+				log.Printf("Can't find pool based on IPs bound to %v", iface.Name)
+				return
+			}
+		}
+
+		// Answer from the (sub-)interface it came in on
+		ctx.ReplyIfIndex = iface.Index
+		if interfaceHasIp(iface, ctx.Pool.MyIp) {
+			ctx.ReplySrc = ctx.Pool.MyIp
+		}
+	}
+
+	ctx.Pool = a.sharedPool(ctx.Pool, message)
+	ctx.Shared = a.shared[ctx.Pool.SharedNetwork]
+
+	// Never answer for a pool from a segment it isn't bound to
+	if !poolServesInterface(ctx.Pool, iface.Name) {
+		log.Printf("Ignoring request for pool %v, which isn't bound to %v", ctx.Pool.Name, iface.Name)
+		return
+	}
+
+	ctx.Mark("pool")
+	ctx.Tracef("Using pool %v", ctx.Pool.Name)
+
+	// Too many new clients at once
+	if a.starvation != nil && message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPDISCOVER &&
+		!a.starvation.Allow(ctx.Pool, leaseKey(ctx.Pool, message)) {
+		ctx.Tracef("Ignoring DISCOVER as pool %v has had too many new clients", ctx.Pool.Name)
+		return
+	}
+
+	response := a.serve.ServeDHCP(ctx, message)
+
+	ctx.Mark("handled")
+
+	switch {
+	case response == nil:
+		ctx.Tracef("Not responding")
+	case a.dryRun:
+		log.Printf("Dry run: not sending %v of %v to %v", dhcp4.OpNames[response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)],
+			response.Header.YourAddr.String(), response.Header.Hardware().String())
+	default:
+		ctx.TraceMessage("sending", response)
+
+		// In the case of a relayed request, send the response unicast to the relaying server
+		handler := NewRequestHandler(message, ctx)
+		switch {
+		case ctx.Relayed():
+			handler.sendMessageRelayed(response, ctx.RelayAddr, localSocket)
+		case handler.replyUnicast(response):
+			handler.sendMessageUnicast(response, message.Header.ClientAddr, 68, localSocket)
+		case a.neighbors != nil && handler.replyToYourAddr(response):
+			handler.sendMessageNeighbor(response, a.neighbors, localSocket)
+		default:
+			handler.sendMessageBroadcast(response, localSocket)
+		}
+		a.counters.Sent(response)
+		ctx.Mark("sent")
+	}
+
+	a.runHooks(ctx, message, response)
+
+	if response != nil {
+		response.Release()
+	}
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestShutdown(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	_, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "host1")
+	require.Nil(t, err)
+
+	// Not yet written anywhere
+	path := filepath.Join(t.TempDir(), "test.json")
+	p.Persistence = pool.NewFilePersistence(path)
+
+	app := newTestApp(t, p)
+	served := make(chan error)
+	go func() {
+		served <- app.Serve()
+	}()
+
+	// Handled, if only to be ignored
+	client, err := net.DialUDP("udp4", nil, app.socket.LocalAddr().(*net.UDPAddr))
+	require.Nil(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	require.Nil(t, err)
+
+	require.Nil(t, app.Stop())
+	select {
+	case err := <-served:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return once stopped")
+	}
+
+	require.Nil(t, app.Flush())
+	leases, err := pool.NewFilePersistence(path).LoadLeases()
+	require.Nil(t, err)
+	require.Len(t, leases, 1)
+}
+
+func TestReceiveBatch(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.Network = net.ParseIP("127.0.0.0")
+	pool.Netmask = net.ParseIP("255.0.0.0")
+	pool.LeaseTime = time.Hour
+
+	app := newTestApp(t, pool)
+	app.interfaces["lo"] = struct{}{}
+	require.Nil(t, SetupDhcpSocket(app.socket.(*UDPPacketConn).UDPConn))
+
+	handled := make(chan dhcp4.HardwareAddr, 10)
+	app.AddHook(func(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+		handled <- request.Header.Hardware()
+	})
+
+	// Requests come from port 68
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 68})
+	if err != nil {
+		t.Skipf("Can't bind port 68: %v", err)
+	}
+	defer client.Close()
+
+	// Several queued up before we start reading
+	for i := byte(1); i <= 5; i++ {
+		buf := new(bytes.Buffer)
+		require.Nil(t, newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware()).Encode(buf))
+		_, err := client.WriteTo(buf.Bytes(), app.socket.LocalAddr())
+		require.Nil(t, err)
+	}
+
+	served := make(chan error)
+	go func() {
+		served <- app.Serve()
+	}()
+
+	seen := map[dhcp4.HardwareAddr]bool{}
+	for len(seen) < 5 {
+		select {
+		case mac := <-handled:
+			seen[mac] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Only handled %v requests", len(seen))
+		}
+	}
+
+	require.Nil(t, app.Stop())
+	require.Nil(t, <-served)
+}
+
+func TestDryRun(t *testing.T) {
+	app, conn, p := newMemoryApp(t)
+	app.SetDryRun(true)
+	handled := make(chan byte, 1)
+	app.AddHook(func(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+		handled <- response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+	})
+	go app.Serve()
+	defer app.Stop()
+
+	// Allocated as usual, but not sent
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	buf := new(bytes.Buffer)
+	require.Nil(t, newTestMessage(dhcp4.DHCPDISCOVER, mac).Encode(buf))
+	require.Nil(t, conn.Send(buf.Bytes(), &net.UDPAddr{IP: net.IPv4zero, Port: 68}))
+
+	select {
+	case op := <-handled:
+		require.Equal(t, dhcp4.DHCPOFFER, op)
+	case <-time.After(time.Second):
+		t.Fatal("Request wasn't handled")
+	}
+	_, ok := p.GetLeaseByMac(mac)
+	require.True(t, ok)
+
+	_, _, err := conn.Receive(100 * time.Millisecond)
+	require.NotNil(t, err)
+
+	// Nor are server initiated messages
+	_, err = app.ForceRenew("test", nil)
+	require.NotNil(t, err)
+}
+
+func TestDryRunConf(t *testing.T) {
+	leasedir := t.TempDir()
+	conf := &Conf{
+		Interfaces: []string{"lo"},
+		Pools:      []PoolConf{{Name: "test", MyIp: "10.0.0.1", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20"}},
+		Leasedir:   leasedir,
+		DryRun:     true,
+		Ddns:       &DdnsConf{Server: "127.0.0.1:53"},
+		Failover:   &FailoverConf{Role: "primary", Listen: "127.0.0.1:0", Peer: "127.0.0.1:1"},
+		Exec:       []ExecHookConf{{Command: []string{"/bin/true"}}},
+	}
+	app := NewApp()
+	require.Nil(t, app.InitConf(conf))
+	require.True(t, app.dryRun)
+
+	// Nothing acting on leases outside of us is set up
+	require.Nil(t, app.ddns)
+	require.Nil(t, app.failover)
+	require.Empty(t, app.execHooks)
+	require.NotNil(t, conf.Ddns)
+
+	// Leases are only kept in memory
+	p, err := app.findPoolByName("test")
+	require.Nil(t, err)
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	require.Nil(t, err)
+	require.Nil(t, p.Flush())
+	entries, err := os.ReadDir(leasedir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestPoolInterfaceBinding(t *testing.T) {
+	app, conn, p := newMemoryApp(t)
+	lo, err := net.InterfaceByName("lo")
+	require.Nil(t, err)
+	oob := (&ipv4.ControlMessage{IfIndex: lo.Index}).Marshal()
+	client := &net.UDPAddr{IP: net.IPv4zero, Port: 68}
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+
+	// Answer of a request received on lo, if any
+	dispatch := func(message *dhcp4.DHCPMessage, remote *net.UDPAddr) *dhcp4.DHCPMessage {
+		buf := new(bytes.Buffer)
+		require.Nil(t, message.Encode(buf))
+		app.DispatchMessage(buf.Bytes(), oob, remote, conn)
+		data, _, err := conn.Receive(10 * time.Millisecond)
+		if err != nil {
+			return nil
+		}
+		reply, err := dhcp4.ParseDhcpMessage(data)
+		require.Nil(t, err)
+		return reply
+	}
+
+	require.NotNil(t, dispatch(newTestMessage(dhcp4.DHCPDISCOVER, mac), client))
+
+	// Bound elsewhere, so lo's pool isn't answered for, relayed or not
+	p.Interface = "eth9"
+	require.Nil(t, dispatch(newTestMessage(dhcp4.DHCPDISCOVER, mac), client))
+	relayed := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	relayed.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("127.0.0.2"))
+	require.Nil(t, dispatch(relayed, &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 67}))
+
+	// A pool bound to lo is used for it, even with no address in its
+	// network
+	bound := newTestPool()
+	bound.Name = "bound"
+	bound.Network = net.ParseIP("10.0.0.0")
+	bound.Broadcast = net.ParseIP("10.0.0.255")
+	bound.LeaseTime = time.Hour
+	bound.Interface = "lo"
+	require.Nil(t, app.insertPool(bound))
+	offer := dispatch(newTestMessage(dhcp4.DHCPDISCOVER, mac), client)
+	require.NotNil(t, offer)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), offer.Header.YourAddr)
+
+	// And has to be one of ours
+	conf := &Conf{Interfaces: []string{"lo"}, Pools: []PoolConf{{Name: "test", MyIp: "10.0.0.1", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", Interface: "eth9"}}, Leasedir: t.TempDir()}
+	require.NotNil(t, NewApp().InitConf(conf))
+}
+
+func TestVlans(t *testing.T) {
+	app := NewApp()
+	app.interfaces["eth0.*"] = struct{}{}
+	app.interfaces["eth1"] = struct{}{}
+	require.True(t, app.servesInterface("eth0.10"))
+	require.True(t, app.servesInterface("eth0.20"))
+	require.False(t, app.servesInterface("eth0"))
+	require.False(t, app.servesInterface("eth1.10"))
+	require.True(t, app.servesInterface("eth1"))
+
+	p := newTestPool()
+	require.True(t, poolServesInterface(p, "eth0.10"))
+	p.Vlan = 10
+	require.True(t, poolServesInterface(p, "eth0.10"))
+	require.True(t, poolServesInterface(p, "eth1.10"))
+	require.False(t, poolServesInterface(p, "eth0.20"))
+	require.False(t, poolServesInterface(p, "eth0"))
+	p.Interface = "eth1.10"
+	require.False(t, poolServesInterface(p, "eth0.10"))
+
+	_, err := PoolConf{Name: "test", MyIp: "10.0.0.1", Vlan: 4095}.ToPool()
+	require.NotNil(t, err)
+}
+
+func TestBootpRangeConf(t *testing.T) {
+	pc := PoolConf{Name: "test", MyIp: "10.0.0.1", Network: "10.0.0.0", Netmask: "255.255.255.0",
+		Start: "10.0.0.10", End: "10.0.0.99", BootpStart: "10.0.0.100", BootpEnd: "10.0.0.110"}
+	_, err := pc.ToPool()
+	require.Nil(t, err)
+
+	for _, r := range [][2]string{{"10.0.0.100", ""}, {"10.0.0.100", "bogus"}, {"10.0.0.110", "10.0.0.100"}, {"10.0.1.100", "10.0.1.110"}, {"10.0.0.250", "10.0.1.10"}} {
+		pc.BootpStart, pc.BootpEnd = r[0], r[1]
+		_, err = pc.ToPool()
+		require.NotNil(t, err, r)
+	}
+}
+
+func TestReplySource(t *testing.T) {
+	app, conn, p := newMemoryApp(t)
+	lo, err := net.InterfaceByName("lo")
+	require.Nil(t, err)
+	oob := (&ipv4.ControlMessage{IfIndex: lo.Index}).Marshal()
+
+	// Replies to local clients go out of the interface the request came
+	// in on, from our address on it
+	buf := new(bytes.Buffer)
+	require.Nil(t, newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()).Encode(buf))
+	app.DispatchMessage(buf.Bytes(), oob, &net.UDPAddr{IP: net.IPv4zero, Port: 68}, conn)
+	_, _, info, err := conn.ReceiveWithInfo(time.Second)
+	require.Nil(t, err)
+	require.NotNil(t, info)
+	require.Equal(t, lo.Index, info.IfIndex)
+	require.True(t, info.Src.Equal(p.MyIp.NetIp()))
+
+	// Without our address if it isn't on the interface
+	p.MyIp = dhcp4.IpToFixedV4(net.ParseIP("192.0.2.1"))
+	buf.Reset()
+	require.Nil(t, newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()).Encode(buf))
+	app.DispatchMessage(buf.Bytes(), oob, &net.UDPAddr{IP: net.IPv4zero, Port: 68}, conn)
+	_, _, info, err = conn.ReceiveWithInfo(time.Second)
+	require.Nil(t, err)
+	require.Equal(t, lo.Index, info.IfIndex)
+	require.Nil(t, info.Src)
+}
+
+// Control message for a packet received on an interface and addressed to
+// dst, which Marshal leaves out
+func receivedOob(ifIndex int, dst net.IP) []byte {
+	oob := (&ipv4.ControlMessage{IfIndex: ifIndex}).Marshal()
+	copy(oob[syscall.CmsgLen(0)+8:], dst.To4())
+	return oob
+}
+
+func TestUnicastRenewal(t *testing.T) {
+	app, conn, local := newMemoryApp(t)
+	lo, err := net.InterfaceByName("lo")
+	require.Nil(t, err)
+
+	// A pool for clients behind a relay, who renew straight with us
+	remote := newTestPool()
+	remote.Name = "remote"
+	remote.Network = net.ParseIP("10.0.0.0")
+	remote.Broadcast = net.ParseIP("10.0.0.255")
+	remote.LeaseTime = time.Hour
+	require.Nil(t, app.insertPool(remote))
+
+	exchange := func(message *dhcp4.DHCPMessage, dst net.IP) (*dhcp4.DHCPMessage, net.Addr) {
+		buf := new(bytes.Buffer)
+		require.Nil(t, message.Encode(buf))
+		app.DispatchMessage(buf.Bytes(), receivedOob(lo.Index, dst), &net.UDPAddr{IP: message.Header.ClientAddr.NetIp(), Port: 68}, conn)
+		data, addr, err := conn.Receive(time.Second)
+		require.Nil(t, err)
+		reply, err := dhcp4.ParseDhcpMessage(data)
+		require.Nil(t, err)
+		return reply, addr
+	}
+	renewal := func(mac dhcp4.HardwareAddr, ip dhcp4.FixedV4) *dhcp4.DHCPMessage {
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Header.ClientAddr = ip
+		return request
+	}
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease, err := remote.GetNextLease(mac, "")
+	require.Nil(t, err)
+	reply, addr := exchange(renewal(mac, lease.IP), local.MyIp.NetIp())
+	require.Equal(t, dhcp4.DHCPACK, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, lease.IP.String()+":68", addr.String())
+
+	// Rebinding clients broadcast, but are still answered at their IP
+	lease, err = local.GetNextLease(mac, "")
+	require.Nil(t, err)
+	reply, addr = exchange(renewal(mac, lease.IP), net.IPv4bcast)
+	require.Equal(t, dhcp4.DHCPACK, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, lease.IP.String()+":68", addr.String())
+
+	// Except for NAKs, which are broadcast
+	reply, addr = exchange(renewal(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), lease.IP+1), local.MyIp.NetIp())
+	require.Equal(t, dhcp4.DHCPNAK, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, "127.255.255.255:68", addr.String())
+}
+
+func TestLeaseQueryUnknownNetwork(t *testing.T) {
+	app, conn, _ := newMemoryApp(t)
+	lo, err := net.InterfaceByName("lo")
+	require.Nil(t, err)
+
+	remote := newTestPool()
+	remote.Name = "remote"
+	remote.Network = net.ParseIP("10.0.0.0")
+	remote.Broadcast = net.ParseIP("10.0.0.255")
+	require.Nil(t, app.insertPool(remote))
+
+	// An IP none of our pools hold is unknown to us, rather than ignored
+	query := newTestMessage(dhcp4.DHCPLEASEQUERY, dhcp4.MacAddress{}.Hardware())
+	query.Header.ClientAddr = dhcp4.IpToFixedV4(net.ParseIP("192.168.5.5"))
+	query.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))
+	buf := new(bytes.Buffer)
+	require.Nil(t, query.Encode(buf))
+	app.DispatchMessage(buf.Bytes(), receivedOob(lo.Index, remote.MyIp.NetIp()), &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67}, conn)
+	data, _, err := conn.Receive(time.Second)
+	require.Nil(t, err)
+	reply, err := dhcp4.ParseDhcpMessage(data)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.DHCPLEASEUNKNOWN, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Append-only log of every DHCP transaction, one json record per line, for
+// answering questions like who had an IP at 3am. Rotated by size, keeping
+// a number of old files as path.1, path.2 and so on.
+//
+
+// What we did with a request
+const (
+	AUDIT_IGNORED = "ignored"
+)
+
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Xid       string    `json:"xid"`
+	Mac       string    `json:"mac"`
+	Request   string    `json:"request"`
+	Response  string    `json:"response"`
+	IP        string    `json:"ip,omitempty"`
+	Requested string    `json:"requested,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Pool      string    `json:"pool,omitempty"`
+	Interface string    `json:"interface"`
+	Relay     string    `json:"relay,omitempty"`
+	RelayInfo string    `json:"relay_info,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+}
+
+type AuditLog struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	m      sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+func NewAuditLog(conf *AuditConf) (*AuditLog, error) {
+	if conf.Path == "" {
+		return nil, errors.New("Audit log needs a path")
+	}
+
+	a := &AuditLog{
+		path:    conf.Path,
+		maxSize: 100 << 20,
+		keep:    10,
+	}
+	if conf.MaxSize != 0 {
+		a.maxSize = int64(conf.MaxSize) << 20
+	}
+	if conf.Keep != 0 {
+		a.keep = conf.Keep
+	}
+
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// Shift path.N to path.N+1, dropping the oldest, and start a new file
+func (a *AuditLog) rotate() error {
+	a.file.Close()
+
+	os.Remove(fmt.Sprintf("%v.%d", a.path, a.keep))
+	for i := a.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%v.%d", a.path, i), fmt.Sprintf("%v.%d", a.path, i+1))
+	}
+	err := os.Rename(a.path, a.path+".1")
+
+	// Carry on writing somewhere even if we couldn't move the old file
+	if openErr := a.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func NewAuditRecord(ctx *RequestContext, request, response *dhcp4.DHCPMessage) *AuditRecord {
+	record := &AuditRecord{
+		Time:      time.Now(),
+		Xid:       fmt.Sprintf("%08x", request.Header.Identifier),
+		Mac:       request.Header.Hardware().String(),
+		Request:   dhcp4.OpNames[request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)],
+		Response:  AUDIT_IGNORED,
+		Interface: ctx.Interface,
+		Duration:  float64(ctx.Elapsed().Microseconds()) / 1000,
+	}
+	if request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == 0 {
+		record.Request = "BOOTREQUEST"
+	}
+
+	if requested, ok := request.Options.GetIP(dhcp4.OPTION_REQUESTED_IP); ok {
+		record.Requested = requested.String()
+	} else if !request.Header.ClientAddr.Empty() {
+		record.Requested = request.Header.ClientAddr.String()
+	}
+	record.Hostname, _ = request.Options.GetString(dhcp4.OPTION_HOST_NAME)
+
+	if ctx.Pool != nil {
+		record.Pool = ctx.Pool.Name
+	}
+	if ctx.Relayed() {
+		record.Relay = ctx.RelayAddr.String()
+	}
+	if option, ok := request.Options.Get(dhcp4.OPTION_RELAY_AGENT); ok {
+		record.RelayInfo = hex.EncodeToString(option.Data)
+	}
+
+	if response != nil {
+		if op := response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE); op != 0 {
+			record.Response = dhcp4.OpNames[op]
+		} else {
+			record.Response = "BOOTREPLY"
+		}
+		if !response.Header.YourAddr.Empty() {
+			record.IP = response.Header.YourAddr.String()
+		}
+	}
+
+	return record
+}
+
+// Request hook writing a record of the transaction
+func (a *AuditLog) Hook(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+	line, err := json.Marshal(NewAuditRecord(ctx, request, response))
+	if err != nil {
+		log.Printf("Failed encoding audit record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	// Handlers may still be running if shutting down timed out
+	if a.closed {
+		return
+	}
+	if a.size+int64(len(line)) > a.maxSize && a.size > 0 {
+		if err := a.rotate(); err != nil {
+			log.Printf("Failed rotating audit log %v: %v", a.path, err)
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Printf("Failed writing audit log %v: %v", a.path, err)
+	}
+}
+
+func (a *AuditLog) Close() error {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	return a.file.Close()
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	audit, err := NewAuditLog(&AuditConf{Path: path, Keep: 2})
+	require.Nil(t, err)
+
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.LeaseTime = time.Hour
+
+	ctx := NewRequestContext("eth0", nil)
+	ctx.Pool = pool
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))
+	message.Options.Set(dhcp4.OPTION_RELAY_AGENT, []byte{1, 2, 0xab, 0xcd})
+	ctx.Populate(message)
+	response := NewRequestHandler(message, ctx).Handle()
+	audit.Hook(ctx, message, response)
+
+	// Nothing sent back
+	message = newTestMessage(dhcp4.DHCPRELEASE, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	audit.Hook(ctx, message, nil)
+
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := AuditRecord{}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+
+	require.Equal(t, "00001234", records[0].Xid)
+	require.Equal(t, "0:0:0:0:0:1", records[0].Mac)
+	require.Equal(t, "DHCPDISCOVER", records[0].Request)
+	require.Equal(t, "DHCPOFFER", records[0].Response)
+	require.Equal(t, "10.0.0.10", records[0].IP)
+	require.Equal(t, "test", records[0].Pool)
+	require.Equal(t, "eth0", records[0].Interface)
+	require.Equal(t, "10.0.0.1", records[0].Relay)
+	require.Equal(t, "0102abcd", records[0].RelayInfo)
+
+	require.Equal(t, "DHCPRELEASE", records[1].Request)
+	require.Equal(t, AUDIT_IGNORED, records[1].Response)
+
+	// Handlers still running once closed are ignored
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Nil(t, audit.Close())
+	audit.Hook(ctx, message, nil)
+	require.Nil(t, audit.Close())
+	after, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Equal(t, info.Size(), after.Size())
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	audit, err := NewAuditLog(&AuditConf{Path: path, Keep: 2})
+	require.Nil(t, err)
+	audit.maxSize = 1
+
+	// Every record goes in its own file
+	ctx := NewRequestContext("eth0", nil)
+	for i := byte(1); i <= 4; i++ {
+		audit.Hook(ctx, newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware()), nil)
+	}
+
+	mac := func(path string) string {
+		contents, err := os.ReadFile(path)
+		require.Nil(t, err)
+		record := AuditRecord{}
+		require.Nil(t, json.Unmarshal(contents, &record))
+		return record.Mac
+	}
+	require.Equal(t, "0:0:0:0:0:4", mac(path))
+	require.Equal(t, "0:0:0:0:0:3", mac(path+".1"))
+	require.Equal(t, "0:0:0:0:0:2", mac(path+".2"))
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
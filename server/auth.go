@@ -0,0 +1,283 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// RFC 3118 authentication (option 90). Clients using the delayed protocol
+// ask for it in their DISCOVER, and from then on each message carries an
+// HMAC-MD5 of the whole message using a secret shared with us, with a
+// counter against replays. The simpler configuration token protocol just
+// sends a shared token in the clear. We check what clients send, and sign
+// our replies to those which asked.
+//
+
+const (
+	AUTH_PROTOCOL_TOKEN   = 0
+	AUTH_PROTOCOL_DELAYED = 1
+
+	AUTH_ALGORITHM_HMAC_MD5 = 1
+
+	// Replay detection is a monotonically increasing counter
+	AUTH_RDM_MONOTONIC = 0
+)
+
+// Protocol, algorithm, RDM and replay detection, then the authentication
+// information. For the delayed protocol that's a secret id and the HMAC
+const (
+	authHeaderLen  = 11
+	authDelayedLen = authHeaderLen + 4 + md5.Size
+)
+
+type Authenticator struct {
+	keys    map[uint32][]byte
+	signKey uint32
+	token   []byte
+	require bool
+	sign    bool
+
+	m sync.Mutex
+
+	// Highest replay counter seen from each client, and the last we sent
+	lastRd map[dhcp4.HardwareAddr]uint64
+	rd     uint64
+}
+
+func NewAuthenticator(conf *AuthConf) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:    map[uint32][]byte{},
+		token:   []byte(conf.Token),
+		require: conf.Require,
+		sign:    conf.Sign,
+		lastRd:  map[dhcp4.HardwareAddr]uint64{},
+	}
+	for i, key := range conf.Keys {
+		if key.Secret == "" {
+			return nil, fmt.Errorf("Authentication key %v has no secret", key.Id)
+		}
+		if _, ok := a.keys[key.Id]; ok {
+			return nil, fmt.Errorf("Authentication key %v given more than once", key.Id)
+		}
+		a.keys[key.Id] = []byte(key.Secret)
+
+		// New clients are given the first key
+		if i == 0 {
+			a.signKey = key.Id
+		}
+	}
+	if len(a.keys) == 0 && len(a.token) == 0 {
+		return nil, errors.New("Authentication needs keys or a token")
+	}
+	return a, nil
+}
+
+// How we're to sign the reply to a request
+type AuthReply struct {
+	auth     *Authenticator
+	protocol byte
+	key      uint32
+}
+
+// Check a request's authentication. Returns how to sign the reply, or nil
+// if we shouldn't
+func (a *Authenticator) Verify(packet []byte, message *dhcp4.DHCPMessage) (*AuthReply, error) {
+	option, ok := message.Options.Get(dhcp4.OPTION_AUTH)
+	if !ok {
+		if a.require {
+			return nil, errors.New("No authentication option")
+		}
+		return nil, nil
+	}
+
+	data := option.Data
+	if len(data) < 3 {
+		return nil, fmt.Errorf("Authentication option too short (%v bytes)", len(data))
+	}
+
+	switch data[0] {
+	case AUTH_PROTOCOL_TOKEN:
+		if len(a.token) == 0 {
+			break
+		}
+		if len(data) < authHeaderLen || subtle.ConstantTimeCompare(data[authHeaderLen:], a.token) != 1 {
+			return nil, errors.New("Wrong authentication token")
+		}
+		return a.reply(AUTH_PROTOCOL_TOKEN, 0), nil
+
+	case AUTH_PROTOCOL_DELAYED:
+		if len(a.keys) == 0 {
+			break
+		}
+		if data[1] != AUTH_ALGORITHM_HMAC_MD5 || data[2] != AUTH_RDM_MONOTONIC {
+			return nil, fmt.Errorf("Unsupported authentication algorithm %v or replay detection %v", data[1], data[2])
+		}
+
+		// Asking to authenticate from now on
+		if message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPDISCOVER && len(data) <= authHeaderLen {
+			return a.reply(AUTH_PROTOCOL_DELAYED, a.signKey), nil
+		}
+
+		if len(data) != authDelayedLen {
+			return nil, fmt.Errorf("Authentication option is %v bytes, not %v", len(data), authDelayedLen)
+		}
+		id := binary.BigEndian.Uint32(data[authHeaderLen:])
+		key, ok := a.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("Unknown authentication key %v", id)
+		}
+		if !hmac.Equal(data[authHeaderLen+4:], authHmac(key, packet)) {
+			return nil, errors.New("Authentication HMAC doesn't match")
+		}
+
+		// Only count it once we know it's genuine
+		rd := binary.BigEndian.Uint64(data[3:])
+		mac := message.Header.Hardware()
+		a.m.Lock()
+		defer a.m.Unlock()
+		if last, ok := a.lastRd[mac]; ok && rd <= last {
+			return nil, fmt.Errorf("Replayed message; counter %v not after %v", rd, last)
+		}
+		a.lastRd[mac] = rd
+		return a.reply(AUTH_PROTOCOL_DELAYED, id), nil
+	}
+
+	// A protocol we haven't been set up for
+	if a.require {
+		return nil, fmt.Errorf("Unsupported authentication protocol %v", data[0])
+	}
+	return nil, nil
+}
+
+func (a *Authenticator) reply(protocol byte, key uint32) *AuthReply {
+	if !a.sign {
+		return nil
+	}
+	return &AuthReply{auth: a, protocol: protocol, key: key}
+}
+
+// Our replay detection counter, which must always go up
+func (a *Authenticator) nextRd() uint64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	rd := uint64(time.Now().UnixNano())
+	if rd <= a.rd {
+		rd = a.rd + 1
+	}
+	a.rd = rd
+	return rd
+}
+
+// Add the authentication option to a reply, with room for the HMAC which
+// Sign fills in once the reply is encoded
+func (r *AuthReply) Prepare(message *dhcp4.DHCPMessage) {
+	if r == nil {
+		return
+	}
+
+	data := make([]byte, authHeaderLen, authDelayedLen)
+	data[0] = r.protocol
+	binary.BigEndian.PutUint64(data[3:], r.auth.nextRd())
+	if r.protocol == AUTH_PROTOCOL_TOKEN {
+		data = append(data, r.auth.token...)
+	} else {
+		data[1] = AUTH_ALGORITHM_HMAC_MD5
+		data[2] = AUTH_RDM_MONOTONIC
+		data = data[:authDelayedLen]
+		binary.BigEndian.PutUint32(data[authHeaderLen:], r.key)
+	}
+	message.Options.Override(dhcp4.OPTION_AUTH, data)
+}
+
+// Fill in the HMAC of an encoded reply
+func (r *AuthReply) Sign(packet []byte) error {
+	if r == nil || r.protocol != AUTH_PROTOCOL_DELAYED {
+		return nil
+	}
+	start, end, ok := findRawOption(packet, dhcp4.OPTION_AUTH)
+	if !ok || end-start != 2+authDelayedLen {
+		return errors.New("Authentication option missing from encoded reply")
+	}
+	copy(packet[end-md5.Size:end], authHmac(r.auth.keys[r.key], packet))
+	return nil
+}
+
+// HMAC-MD5 of the message with hops, giaddr and the HMAC itself zeroed, as
+// relays may change them. Relay agent information is left out too, as per
+// RFC 4030, as relays add it on the way to us and remove it from replies
+func authHmac(key, packet []byte) []byte {
+	buf := make([]byte, len(packet))
+	copy(buf, packet)
+
+	if len(buf) > 28 {
+		buf[3] = 0
+		copy(buf[24:28], []byte{0, 0, 0, 0})
+	}
+	if start, end, ok := findRawOption(buf, dhcp4.OPTION_AUTH); ok && end-start == 2+authDelayedLen {
+		copy(buf[end-md5.Size:end], make([]byte, md5.Size))
+	}
+	if start, end, ok := findRawOption(buf, dhcp4.OPTION_RELAY_AGENT); ok {
+		buf = append(buf[:start], buf[end:]...)
+	}
+
+	mac := hmac.New(md5.New, key)
+	mac.Write(buf)
+	return mac.Sum(nil)
+}
+
+// Position of an option in an encoded message, from its code to the end of
+// its data, looking in the header fields too if options overflowed into them
+func findRawOption(packet []byte, code byte) (int, int, bool) {
+	const (
+		snameStart   = 44
+		fileStart    = 108
+		optionsStart = 240
+	)
+
+	areas := [][2]int{{optionsStart, len(packet)}}
+	overload := byte(0)
+	for i := 0; i < len(areas); i++ {
+		for pos := areas[i][0]; pos < areas[i][1]; {
+			switch packet[pos] {
+			case dhcp4.OPTION_PADDING:
+				pos++
+				continue
+			case dhcp4.OPTION_SENTINEL:
+				pos = areas[i][1]
+				continue
+			}
+			if pos+1 >= areas[i][1] || pos+2+int(packet[pos+1]) > areas[i][1] {
+				break
+			}
+			end := pos + 2 + int(packet[pos+1])
+			if packet[pos] == code {
+				return pos, end, true
+			}
+			if packet[pos] == dhcp4.OPTION_OPTION_OVER && end-pos == 3 {
+				overload = packet[pos+2]
+			}
+			pos = end
+		}
+
+		// Overflowed options are in file, then sname
+		if i == 0 {
+			if overload&dhcp4.OVERLOAD_FILE != 0 && len(packet) >= optionsStart {
+				areas = append(areas, [2]int{fileStart, fileStart + 128})
+			}
+			if overload&dhcp4.OVERLOAD_SNAME != 0 && len(packet) >= optionsStart {
+				areas = append(areas, [2]int{snameStart, snameStart + 64})
+			}
+		}
+	}
+	return 0, 0, false
+}
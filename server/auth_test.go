@@ -0,0 +1,131 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+// Encode a message, filling in the HMAC of its delayed authentication option
+func signedTestPacket(t *testing.T, message *dhcp4.DHCPMessage, key []byte) []byte {
+	buf := new(bytes.Buffer)
+	require.Nil(t, message.Encode(buf))
+	packet := buf.Bytes()
+
+	_, end, ok := findRawOption(packet, dhcp4.OPTION_AUTH)
+	require.True(t, ok)
+	copy(packet[end-16:end], authHmac(key, packet))
+	return packet
+}
+
+func delayedAuthOption(rd uint64, id uint32) []byte {
+	data := make([]byte, authDelayedLen)
+	data[0] = AUTH_PROTOCOL_DELAYED
+	data[1] = AUTH_ALGORITHM_HMAC_MD5
+	binary.BigEndian.PutUint64(data[3:], rd)
+	binary.BigEndian.PutUint32(data[authHeaderLen:], id)
+	return data
+}
+
+func TestAuthDelayed(t *testing.T) {
+	_, err := NewAuthenticator(&AuthConf{})
+	require.NotNil(t, err)
+
+	auth, err := NewAuthenticator(&AuthConf{
+		Keys:    []AuthKeyConf{{Id: 7, Secret: "seven"}, {Id: 8, Secret: "eight"}},
+		Require: true,
+		Sign:    true,
+	})
+	require.Nil(t, err)
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+
+	// Unauthenticated
+	message := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	_, err = auth.Verify(nil, message)
+	require.NotNil(t, err)
+
+	// Client asking to use authentication is given the first key
+	message = newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	message.Options.Set(dhcp4.OPTION_AUTH, []byte{AUTH_PROTOCOL_DELAYED, AUTH_ALGORITHM_HMAC_MD5, AUTH_RDM_MONOTONIC})
+	reply, err := auth.Verify(nil, message)
+	require.Nil(t, err)
+	require.Equal(t, uint32(7), reply.key)
+
+	// Then authenticates with the second. Relays may change giaddr and
+	// add relay agent information without breaking it
+	message = newTestMessage(dhcp4.DHCPREQUEST, mac)
+	message.Options.Set(dhcp4.OPTION_AUTH, delayedAuthOption(100, 8))
+	packet := signedTestPacket(t, message, []byte("eight"))
+	relayed, err := dhcp4.ParseDhcpMessage(packet)
+	require.Nil(t, err)
+	relayed.Header.GatewayAddr = 0x0a000001
+	relayed.Header.Hops = 1
+	relayed.Options.Set(dhcp4.OPTION_RELAY_AGENT, []byte{1, 1, 1})
+	buf := new(bytes.Buffer)
+	require.Nil(t, relayed.Encode(buf))
+	reply, err = auth.Verify(buf.Bytes(), relayed)
+	require.Nil(t, err)
+	require.Equal(t, uint32(8), reply.key)
+
+	// Replayed
+	_, err = auth.Verify(buf.Bytes(), relayed)
+	require.NotNil(t, err)
+
+	// Wrong secret, and unknown key
+	message = newTestMessage(dhcp4.DHCPREQUEST, mac)
+	message.Options.Set(dhcp4.OPTION_AUTH, delayedAuthOption(101, 8))
+	packet = signedTestPacket(t, message, []byte("seven"))
+	message, _ = dhcp4.ParseDhcpMessage(packet)
+	_, err = auth.Verify(packet, message)
+	require.NotNil(t, err)
+
+	message = newTestMessage(dhcp4.DHCPREQUEST, mac)
+	message.Options.Set(dhcp4.OPTION_AUTH, delayedAuthOption(102, 9))
+	packet = signedTestPacket(t, message, []byte("nine"))
+	message, _ = dhcp4.ParseDhcpMessage(packet)
+	_, err = auth.Verify(packet, message)
+	require.NotNil(t, err)
+
+	// Our signed reply verifies with the client's key
+	response := newTestMessage(dhcp4.DHCPACK, mac)
+	response.Header.Op = dhcp4.BOOT_REPLY
+	reply.Prepare(response)
+	buf = new(bytes.Buffer)
+	require.Nil(t, response.Encode(buf))
+	require.Nil(t, reply.Sign(buf.Bytes()))
+
+	signed, err := dhcp4.ParseDhcpMessage(buf.Bytes())
+	require.Nil(t, err)
+	option, ok := signed.Options.Get(dhcp4.OPTION_AUTH)
+	require.True(t, ok)
+	require.Equal(t, uint32(8), binary.BigEndian.Uint32(option.Data[authHeaderLen:]))
+	require.Equal(t, authHmac([]byte("eight"), buf.Bytes()), option.Data[authHeaderLen+4:])
+}
+
+func TestAuthToken(t *testing.T) {
+	auth, err := NewAuthenticator(&AuthConf{Token: "secret"})
+	require.Nil(t, err)
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+
+	// Optional, and we don't sign
+	reply, err := auth.Verify(nil, newTestMessage(dhcp4.DHCPDISCOVER, mac))
+	require.Nil(t, err)
+	require.Nil(t, reply)
+
+	token := append(make([]byte, authHeaderLen), "secret"...)
+	message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	message.Options.Set(dhcp4.OPTION_AUTH, token)
+	_, err = auth.Verify(nil, message)
+	require.Nil(t, err)
+
+	message = newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	message.Options.Set(dhcp4.OPTION_AUTH, append(make([]byte, authHeaderLen), "wrong"...))
+	_, err = auth.Verify(nil, message)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Load testing a running server. `mygodhcpd bench` plays thousands of
+// clients going through DISCOVER, OFFER, REQUEST and ACK at once, then
+// reports how many offers a second the server managed, how long acks took,
+// and how many clients didn't get a lease. The clients pose as relayed, so
+// replies come back to us unicast rather than broadcast. That means binding
+// port 67, and the server needing a pool whose network contains the relay
+// address we claim.
+//
+
+type BenchConf struct {
+	// Where the server is listening, and where it's to send replies
+	Server string
+	Relay  net.IP
+	Listen string
+
+	Clients     int
+	Concurrency int
+	Timeout     time.Duration
+
+	// Release leases once acked, so runs don't use up the pool
+	Release bool
+}
+
+type BenchResult struct {
+	Clients int
+	Offers  int
+	Acks    int
+	Naks    int
+
+	// Clients which timed out waiting for an offer, usually because the
+	// pool ran out of IPs, or for an ack
+	NoOffer int
+	NoAck   int
+
+	Elapsed time.Duration
+
+	// From sending each REQUEST to its ACK
+	AckLatencies []time.Duration
+}
+
+func (r *BenchResult) OffersPerSec() float64 {
+	if r.Elapsed == 0 {
+		return 0
+	}
+	return float64(r.Offers) / r.Elapsed.Seconds()
+}
+
+// Ack latency which p (0 to 1) of acks were at least as quick as
+func (r *BenchResult) Percentile(p float64) time.Duration {
+	if len(r.AckLatencies) == 0 {
+		return 0
+	}
+	latencies := append([]time.Duration(nil), r.AckLatencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[int(p*float64(len(latencies)-1))]
+}
+
+func (r *BenchResult) Report(w io.Writer) {
+	fmt.Fprintf(w, "Clients:        %v in %v\n", r.Clients, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Offers:         %v (%.1f/sec)\n", r.Offers, r.OffersPerSec())
+	fmt.Fprintf(w, "Acks:           %v\n", r.Acks)
+	fmt.Fprintf(w, "Ack latency:    p50 %v, p90 %v, p99 %v, max %v\n",
+		r.Percentile(0.5), r.Percentile(0.9), r.Percentile(0.99), r.Percentile(1))
+	fmt.Fprintf(w, "Failures:       %v without an offer, %v without an ack, %v naks\n", r.NoOffer, r.NoAck, r.Naks)
+}
+
+type Bench struct {
+	conf   *BenchConf
+	conn   *net.UDPConn
+	server *net.UDPAddr
+
+	// Clients waiting on a reply, by transaction id
+	m       sync.Mutex
+	waiting map[uint32]chan *dhcp4.DHCPMessage
+}
+
+func NewBench(conf *BenchConf) (*Bench, error) {
+	server, err := net.ResolveUDPAddr("udp4", conf.Server)
+	if err != nil {
+		return nil, fmt.Errorf("Bad server address '%v': %v", conf.Server, err)
+	}
+	if conf.Relay.To4() == nil {
+		return nil, errors.New("Bench needs an IPv4 relay address")
+	}
+	if conf.Clients < 1 || conf.Concurrency < 1 {
+		return nil, errors.New("Bench needs at least one client, and to run at least one at a time")
+	}
+	listen, err := net.ResolveUDPAddr("udp4", conf.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("Bad listen address '%v': %v", conf.Listen, err)
+	}
+	conn, err := net.ListenUDP("udp4", listen)
+	if err != nil {
+		return nil, err
+	}
+	return &Bench{
+		conf:    conf,
+		conn:    conn,
+		server:  server,
+		waiting: map[uint32]chan *dhcp4.DHCPMessage{},
+	}, nil
+}
+
+func (b *Bench) Close() error {
+	return b.conn.Close()
+}
+
+// Run every client, a number at a time
+func (b *Bench) Run() *BenchResult {
+	go b.receive()
+
+	result := &BenchResult{Clients: b.conf.Clients}
+	var m sync.Mutex
+
+	clients := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < b.conf.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range clients {
+				b.client(i, result, &m)
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := 0; i < b.conf.Clients; i++ {
+		clients <- i
+	}
+	close(clients)
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	return result
+}
+
+// Hand replies to whichever client is waiting for them
+func (b *Bench) receive() {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Failed receiving replies: %v", err)
+			}
+			return
+		}
+		message, err := dhcp4.ParseDhcpMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		b.m.Lock()
+		replies, ok := b.waiting[message.Header.Identifier]
+		b.m.Unlock()
+		if !ok {
+			message.Release()
+			continue
+		}
+		select {
+		case replies <- message:
+		default:
+			message.Release()
+		}
+	}
+}
+
+// One client getting a lease and maybe releasing it
+func (b *Bench) client(i int, result *BenchResult, m *sync.Mutex) {
+	// Locally administered, so as not to clash with real clients
+	mac := dhcp4.MacAddress{0x02, 0xbe, byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)}
+
+	offer, err := b.exchange(b.request(dhcp4.DHCPDISCOVER, mac))
+	if err != nil || offer.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) != dhcp4.DHCPOFFER {
+		m.Lock()
+		result.NoOffer++
+		m.Unlock()
+		return
+	}
+	ip := offer.Header.YourAddr
+	serverId, _ := offer.Options.GetIP(dhcp4.OPTION_SERVER_ID)
+	offer.Release()
+
+	m.Lock()
+	result.Offers++
+	m.Unlock()
+
+	request := b.request(dhcp4.DHCPREQUEST, mac)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, ip)
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, serverId)
+	sent := time.Now()
+	ack, err := b.exchange(request)
+	latency := time.Since(sent)
+
+	acked := err == nil && ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPACK
+	if ack != nil {
+		ack.Release()
+	}
+
+	m.Lock()
+	switch {
+	case err != nil:
+		result.NoAck++
+	case acked:
+		result.Acks++
+		result.AckLatencies = append(result.AckLatencies, latency)
+	default:
+		result.Naks++
+	}
+	m.Unlock()
+
+	if acked && b.conf.Release {
+		release := b.request(dhcp4.DHCPRELEASE, mac)
+		release.Header.ClientAddr = ip
+		release.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, serverId)
+		b.send(release)
+	}
+}
+
+func (b *Bench) request(op byte, mac dhcp4.MacAddress) *dhcp4.DHCPMessage {
+	message := newClientRequest(op, mac, rand.Uint32())
+	message.Header.Hops = 1
+	message.Header.GatewayAddr = dhcp4.IpToFixedV4(b.conf.Relay)
+	return message
+}
+
+func (b *Bench) send(message *dhcp4.DHCPMessage) error {
+	buf := new(bytes.Buffer)
+	if err := message.Encode(buf); err != nil {
+		return err
+	}
+	_, err := b.conn.WriteToUDP(buf.Bytes(), b.server)
+	return err
+}
+
+// Send a request and wait for the reply to it
+func (b *Bench) exchange(message *dhcp4.DHCPMessage) (*dhcp4.DHCPMessage, error) {
+	xid := message.Header.Identifier
+	replies := make(chan *dhcp4.DHCPMessage, 1)
+
+	b.m.Lock()
+	b.waiting[xid] = replies
+	b.m.Unlock()
+	defer func() {
+		b.m.Lock()
+		delete(b.waiting, xid)
+		b.m.Unlock()
+	}()
+
+	if err := b.send(message); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-time.After(b.conf.Timeout):
+		return nil, errors.New("Timed out")
+	}
+}
+
+// Address we'd send to server from, to claim as relay by default
+func localAddrFor(server string) (net.IP, error) {
+	conn, err := net.Dial("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// `mygodhcpd bench [flags]`
+func RunBench(args []string) int {
+	conf := &BenchConf{}
+	var relay string
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags.StringVar(&conf.Server, "server", "127.0.0.1:67", "Address of the server to test")
+	flags.StringVar(&relay, "relay", "", "Relay address to claim, which a pool on the server must contain. Defaults to our address towards the server")
+	flags.StringVar(&conf.Listen, "listen", ":67", "Address to receive replies on, which must be port 67 of the relay address")
+	flags.IntVar(&conf.Clients, "clients", 1000, "Number of clients to simulate")
+	flags.IntVar(&conf.Concurrency, "concurrency", 100, "Number of clients at a time")
+	flags.DurationVar(&conf.Timeout, "timeout", 2*time.Second, "How long to wait for each reply")
+	flags.BoolVar(&conf.Release, "release", true, "Release each lease once acked")
+	flags.Parse(args)
+
+	if relay == "" {
+		ip, err := localAddrFor(conf.Server)
+		if err != nil {
+			log.Printf("Failed finding relay address: %v", err)
+			return 1
+		}
+		conf.Relay = ip
+	} else {
+		conf.Relay = net.ParseIP(relay)
+	}
+
+	bench, err := NewBench(conf)
+	if err != nil {
+		log.Printf("Failed starting bench: %v", err)
+		return 1
+	}
+	defer bench.Close()
+
+	bench.Run().Report(os.Stdout)
+	return 0
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestBench(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.Network = net.ParseIP("127.0.0.0")
+	pool.Netmask = net.ParseIP("255.0.0.0")
+	pool.LeaseTime = time.Hour
+
+	app := newTestApp(t, pool)
+	app.interfaces["lo"] = struct{}{}
+	require.Nil(t, SetupDhcpSocket(app.socket.(*UDPPacketConn).UDPConn))
+
+	// Replies to relayed requests go to port 67 of the relay
+	bench, err := NewBench(&BenchConf{
+		Server:      app.socket.LocalAddr().String(),
+		Relay:       net.ParseIP("127.0.0.2"),
+		Listen:      "127.0.0.2:67",
+		Clients:     20,
+		Concurrency: 5,
+		Timeout:     time.Second,
+		Release:     true,
+	})
+	if err != nil {
+		t.Skipf("Can't bind port 67: %v", err)
+	}
+	defer bench.Close()
+
+	served := make(chan error)
+	go func() {
+		served <- app.Serve()
+	}()
+
+	// More clients than IPs, but each releases its lease
+	result := bench.Run()
+	require.Equal(t, 20, result.Offers)
+	require.Equal(t, 20, result.Acks)
+	require.Len(t, result.AckLatencies, 20)
+	require.Zero(t, result.NoOffer+result.NoAck+result.Naks)
+	require.True(t, result.Percentile(0.5) <= result.Percentile(1))
+
+	require.Nil(t, app.Stop())
+	require.Nil(t, <-served)
+}
+
+func TestBenchPercentile(t *testing.T) {
+	result := &BenchResult{}
+	require.Zero(t, result.Percentile(0.5))
+
+	for i := 10; i >= 1; i-- {
+		result.AckLatencies = append(result.AckLatencies, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, time.Millisecond, result.Percentile(0))
+	require.Equal(t, 5*time.Millisecond, result.Percentile(0.5))
+	require.Equal(t, 10*time.Millisecond, result.Percentile(1))
+}
+
+func quietLog(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func BenchmarkHandleDiscover(b *testing.B) {
+	quietLog(b)
+
+	pool := newTestPool()
+	pool.Start = net.ParseIP("10.0.0.0")
+	pool.End = net.ParseIP("10.0.255.255")
+	pool.Netmask = net.ParseIP("255.255.0.0")
+	pool.LeaseTime = time.Hour
+	ctx := &RequestContext{Pool: pool}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, byte(i >> 8), byte(i)}.Hardware())
+		if response := NewRequestHandler(message, ctx).Handle(); response != nil {
+			response.Release()
+		}
+	}
+}
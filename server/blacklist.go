@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Revoking leases and turning clients away, for incident response. A
+// revoked lease is released straight away, optionally after sending the
+// client a DHCPFORCERENEW so it asks again rather than holding on until
+// its lease runs out. Blacklisted clients are offered nothing, and their
+// requests NAKed, until they're taken off the list or their time is up.
+//
+
+type Blacklist struct {
+	m sync.Mutex
+
+	// Keyed by hardware address, to when the client is let back in. Zero
+	// means never
+	listed map[dhcp4.HardwareAddr]time.Time
+}
+
+func NewBlacklist() *Blacklist {
+	return &Blacklist{listed: map[dhcp4.HardwareAddr]time.Time{}}
+}
+
+// Turn the client away for the given time, or until removed if zero
+func (b *Blacklist) Add(mac dhcp4.HardwareAddr, duration time.Duration) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var until time.Time
+	if duration != 0 {
+		until = time.Now().Add(duration)
+	}
+	b.listed[mac] = until
+}
+
+func (b *Blacklist) Remove(mac dhcp4.HardwareAddr) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	_, ok := b.listed[mac]
+	delete(b.listed, mac)
+	return ok
+}
+
+// Whether the client is currently turned away
+func (b *Blacklist) Listed(mac dhcp4.HardwareAddr) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	until, ok := b.listed[mac]
+	if ok && !until.IsZero() && time.Now().After(until) {
+		delete(b.listed, mac)
+		return false
+	}
+	return ok
+}
+
+// Clients turned away, and until when
+func (b *Blacklist) List() map[string]time.Time {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	list := map[string]time.Time{}
+	for mac, until := range b.listed {
+		if !until.IsZero() && time.Now().After(until) {
+			delete(b.listed, mac)
+			continue
+		}
+		list[mac.String()] = until
+	}
+	return list
+}
+
+// Drop DISCOVERs and BOOTP requests from blacklisted clients, and NAK
+// their REQUESTs so they give up any IP they think they still have
+func (b *Blacklist) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		if !allocates(request) {
+			return next.ServeDHCP(ctx, request)
+		}
+		mac := request.Header.Hardware()
+		if !b.Listed(mac) && !b.Listed(leaseKey(ctx.Pool, request)) {
+			return next.ServeDHCP(ctx, request)
+		}
+		log.Printf("Turning away blacklisted client %v", mac.String())
+		if request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPREQUEST {
+			return NewRequestHandler(request, ctx).SendNAK()
+		}
+		return nil
+	})
+}
+
+// Release a client's lease in a pool straight away, first telling it to
+// renew if forceRenew is set, and blacklisting it for the given time if
+// not zero
+func (a *App) Revoke(poolName string, mac dhcp4.HardwareAddr, forceRenew bool, blacklist time.Duration) error {
+	p, err := a.findPoolByName(poolName)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.GetLeaseByMac(mac); !ok {
+		return fmt.Errorf("No lease for %v", mac.String())
+	}
+
+	// Listed first, so the renewal it's pushed into is NAKed
+	if blacklist != 0 {
+		a.blacklist.Add(mac, blacklist)
+		log.Printf("Blacklisted %v for %v", mac.String(), blacklist)
+	}
+	if forceRenew {
+		if _, err = a.ForceRenew(poolName, &mac); err != nil {
+			err = fmt.Errorf("Revoked, but failed sending DHCPFORCERENEW: %v", err)
+		}
+	}
+	if lease, ok := p.ReleaseLeaseByMac(mac); ok {
+		log.Printf("Revoked lease of %v for %v in pool %v", mac.String(), lease.IP.String(), p.Name)
+	}
+
+	// Retransmissions aren't to get the ACK for the lease it no longer has
+	if a.dedup != nil {
+		a.dedup.forget(mac)
+	}
+	return err
+}
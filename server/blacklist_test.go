@@ -0,0 +1,111 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestRevokeAndBlacklist(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("127.0.0.0")
+	p.Start = net.ParseIP("127.0.0.1")
+	p.End = net.ParseIP("127.0.0.2")
+	p.LeaseTime = time.Hour
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease, err := p.GetNextLease(mac, "")
+	require.Nil(t, err)
+
+	app := newTestApp(t, p)
+	admin := app.AdminHandler()
+	post := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, url, nil))
+		return rec
+	}
+
+	rec := post("/revoke?pool=test&mac=0:0:0:0:0:1&forcerenew=true&blacklist=1h")
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, ok := p.GetLeaseByMac(mac)
+	require.False(t, ok)
+	require.Contains(t, app.blacklist.List(), mac.String())
+
+	// Nothing left to revoke
+	require.Equal(t, http.StatusBadRequest, post("/revoke?pool=test&mac=0:0:0:0:0:1").Code)
+	require.Equal(t, http.StatusBadRequest, post("/revoke?pool=test&mac=0:0:0:0:0:1&blacklist=soon").Code)
+
+	// The client's renewal is NAKed, and it's offered nothing
+	handler := Chain(DefaultHandler, app.blacklist.Middleware)
+	renew := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	renew.Header.ClientAddr = lease.IP
+	response := handler.ServeDHCP(&RequestContext{Pool: p}, renew)
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Nil(t, handler.ServeDHCP(&RequestContext{Pool: p}, newTestMessage(dhcp4.DHCPDISCOVER, mac)))
+
+	// Until it's taken off the list
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/blacklist?mac=0:0:0:0:0:1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, handler.ServeDHCP(&RequestContext{Pool: p}, newTestMessage(dhcp4.DHCPDISCOVER, mac)))
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/blacklist?mac=0:0:0:0:0:1", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Or its time is up
+	app.blacklist.Add(mac, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	require.False(t, app.blacklist.Listed(mac))
+	require.Equal(t, http.StatusOK, post("/blacklist?mac=0:0:0:0:0:1").Code)
+	require.True(t, app.blacklist.Listed(mac))
+}
+
+func TestRevokeForgetsCachedReplies(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("127.0.0.0")
+	p.Start = net.ParseIP("127.0.0.1")
+	p.End = net.ParseIP("127.0.0.2")
+	p.LeaseTime = time.Hour
+
+	app := newTestApp(t, p)
+	app.dedup = newDedupCache(time.Minute)
+	admin := app.AdminHandler()
+	post := func(url string) int {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, url, nil))
+		return rec.Code
+	}
+
+	// In the order InitConf puts them
+	handler := Chain(DefaultHandler, app.blacklist.Middleware, app.dedup.Middleware)
+	renew := func(mac dhcp4.HardwareAddr, ip dhcp4.FixedV4) byte {
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Header.ClientAddr = ip
+		return handler.ServeDHCP(&RequestContext{Pool: p}, request).Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+	}
+
+	// A blacklisted client's retransmissions aren't answered from the cache
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease, err := p.GetNextLease(mac, "")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.DHCPACK, renew(mac, lease.IP))
+	require.Equal(t, http.StatusOK, post("/blacklist?mac=0:0:0:0:0:1"))
+	require.Equal(t, dhcp4.DHCPNAK, renew(mac, lease.IP))
+
+	// Nor are those of a client whose lease was revoked
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	lease, err = p.GetNextLease(other, "")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.DHCPACK, renew(other, lease.IP))
+	require.Equal(t, http.StatusOK, post("/revoke?pool=test&mac=0:0:0:0:0:2"))
+	require.Equal(t, dhcp4.DHCPNAK, renew(other, lease.IP))
+}
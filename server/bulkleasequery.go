@@ -0,0 +1,386 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Bulk (RFC 6926) and active (RFC 7724) leasequery over TCP, for access
+// concentrators which need every binding we hold, such as after they
+// reboot, rather than asking about one IP at a time. A bulk query gets the
+// bindings matching it, by IP, mac, client identifier, relay id or remote
+// id, optionally only those changed within a time window, then
+// DHCPLEASEQUERYDONE. An active query gets each change as it happens for as
+// long as the connection stays open. We keep no history of changes, so
+// active queries asking to catch up from a point in time are told data is
+// missing, and should make a bulk query to catch up. Only requestors on the
+// allow list may connect; connections aren't secured with TLS.
+//
+
+const (
+	// How long a connection may sit idle between bulk queries, and how long
+	// a requestor may take to read what we send
+	leaseQueryIdleTimeout  = 5 * time.Minute
+	leaseQueryWriteTimeout = 30 * time.Second
+
+	// Changes queued for an active requestor before we give up on it
+	activeLeaseQueryQueue = 1024
+)
+
+type LeaseQueryServer struct {
+	ln    net.Listener
+	allow *RelayAllowlist
+	pools func() []*pool.Pool
+
+	m      sync.Mutex
+	active map[*activeLeaseQuery]bool
+}
+
+type activeLeaseQuery struct {
+	query   *dhcp4.MessageHeader
+	myIp    dhcp4.FixedV4
+	updates chan *dhcp4.DHCPMessage
+}
+
+// Listens straight away, so that it can be done before dropping privileges
+func NewLeaseQueryServer(conf *LeaseQueryConf, pools func() []*pool.Pool) (*LeaseQueryServer, error) {
+	if conf.Listen == "" {
+		return nil, errors.New("Leasequery needs an address to listen on")
+	}
+	if len(conf.Allow) == 0 {
+		return nil, errors.New("Leasequery needs requestors to allow")
+	}
+	allow, err := NewRelayAllowlist(conf.Allow)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", conf.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("Failed listening for leasequery on %v: %v", conf.Listen, err)
+	}
+	return &LeaseQueryServer{
+		ln:     ln,
+		allow:  allow,
+		pools:  pools,
+		active: map[*activeLeaseQuery]bool{},
+	}, nil
+}
+
+func (s *LeaseQueryServer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *LeaseQueryServer) Run() error {
+	log.Printf("Leasequery listening on %v", s.ln.Addr())
+	return s.Serve(s.ln)
+}
+
+func (s *LeaseQueryServer) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func addrIp(addr net.Addr) net.IP {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// Answer queries from a requestor until it goes away, or makes an active
+// query which lasts until then
+func (s *LeaseQueryServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	remote := addrIp(conn.RemoteAddr())
+	if remote == nil || !s.allow.contains(remote) {
+		log.Printf("Refusing leasequery connection from %v", conn.RemoteAddr().String())
+		return
+	}
+	// Replies come from the address the requestor connected to
+	myIp := dhcp4.IpToFixedV4(addrIp(conn.LocalAddr()))
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(leaseQueryIdleTimeout))
+		data, err := dhcp4.ReadTcpMessage(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Leasequery connection from %v failed: %v", remote, err)
+			}
+			return
+		}
+		query, err := dhcp4.ParseDhcpMessage(data)
+		if err != nil {
+			log.Printf("Invalid leasequery from %v: %v", remote, err)
+			return
+		}
+
+		switch op := query.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE); op {
+		case dhcp4.DHCPBULKLEASEQUERY:
+			replies := s.Bulk(query, myIp)
+			log.Printf("DHCPBULKLEASEQUERY from %v: sending %v replies", remote, len(replies)-1)
+			for _, reply := range replies {
+				if err := writeLeaseQueryReply(conn, reply); err != nil {
+					log.Printf("Leasequery connection from %v failed: %v", remote, err)
+					return
+				}
+			}
+		case dhcp4.DHCPACTIVELEASEQUERY:
+			log.Printf("DHCPACTIVELEASEQUERY from %v", remote)
+			s.serveActive(conn, query, myIp)
+			return
+		default:
+			log.Printf("Unexpected message type %v on leasequery connection from %v", op, remote)
+			writeLeaseQueryReply(conn, leaseQueryStatus(query.Header, myIp, dhcp4.DHCPLEASEQUERYSTATUS,
+				dhcp4.LQ_MALFORMED_QUERY, "Expected a bulk or active leasequery"))
+			return
+		}
+	}
+}
+
+func writeLeaseQueryReply(conn net.Conn, reply *dhcp4.DHCPMessage) error {
+	buf := dhcp4.GetEncodeBuffer()
+	defer dhcp4.PutEncodeBuffer(buf)
+	if err := reply.Encode(buf); err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(leaseQueryWriteTimeout))
+	return dhcp4.WriteTcpMessage(conn, buf.Bytes())
+}
+
+// A reply carrying only a status: DHCPLEASEQUERYDONE once a bulk query is
+// answered, or DHCPLEASEQUERYSTATUS
+func leaseQueryStatus(query *dhcp4.MessageHeader, myIp dhcp4.FixedV4, op, code byte, message string) *dhcp4.DHCPMessage {
+	reply := leaseQueryReply(query, myIp, op, nil)
+	reply.Options.Set(dhcp4.OPTION_STATUS_CODE, dhcp4.EncodeStatusCode(code, message))
+	return reply
+}
+
+// A binding's reply, with its state and when it began
+func bindingReply(query *dhcp4.MessageHeader, myIp dhcp4.FixedV4, state byte, lease *pool.Lease) *dhcp4.DHCPMessage {
+	op := dhcp4.DHCPLEASEACTIVE
+	if state != dhcp4.LQ_STATE_ACTIVE {
+		op = dhcp4.DHCPLEASEUNASSIGNED
+	}
+	reply := leaseQueryReply(query, myIp, op, lease)
+	if !lease.Mac.Empty() {
+		reply.Header.SetHardware(lease.Mac)
+	}
+	now := time.Now()
+	reply.Options.SetUint32(dhcp4.OPTION_BASE_TIME, uint32(now.Unix()))
+	if !lease.LastTransaction.IsZero() && state == dhcp4.LQ_STATE_ACTIVE {
+		reply.Options.SetUint32(dhcp4.OPTION_STATE_START, uint32(now.Sub(lease.LastTransaction).Seconds()))
+	}
+	reply.Options.SetByte(dhcp4.OPTION_DHCP_STATE, state)
+	return reply
+}
+
+// The time window of a query, from its query start and end times
+func queryWindow(options *dhcp4.Options) (time.Time, time.Time, error) {
+	var start, end time.Time
+	if _, ok := options.Get(dhcp4.OPTION_QUERY_START); ok {
+		seconds, ok := options.GetUint32(dhcp4.OPTION_QUERY_START)
+		if !ok {
+			return start, end, errors.New("Invalid query start time")
+		}
+		start = time.Unix(int64(seconds), 0)
+	}
+	if _, ok := options.Get(dhcp4.OPTION_QUERY_END); ok {
+		seconds, ok := options.GetUint32(dhcp4.OPTION_QUERY_END)
+		if !ok {
+			return start, end, errors.New("Invalid query end time")
+		}
+		end = time.Unix(int64(seconds), 0)
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return start, end, errors.New("Query ends before it starts")
+	}
+	return start, end, nil
+}
+
+// Whether the lease matches the relay id and remote id asked for, if any
+func matchesRelay(lease *pool.Lease, relayId, remoteId []byte) bool {
+	if relayId != nil {
+		if id, ok := dhcp4.RelayAgentSubOption(lease.RelayAgentInfo, dhcp4.RELAY_AGENT_RELAY_ID); !ok || string(id) != string(relayId) {
+			return false
+		}
+	}
+	if remoteId != nil {
+		if id, ok := dhcp4.RelayAgentSubOption(lease.RelayAgentInfo, dhcp4.RELAY_AGENT_REMOTE_ID); !ok || string(id) != string(remoteId) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replies to a bulk query, ending with DHCPLEASEQUERYDONE or, if the query
+// makes no sense, DHCPLEASEQUERYSTATUS
+func (s *LeaseQueryServer) Bulk(query *dhcp4.DHCPMessage, myIp dhcp4.FixedV4) []*dhcp4.DHCPMessage {
+	header := query.Header
+	start, end, err := queryWindow(query.Options)
+	if err != nil {
+		return []*dhcp4.DHCPMessage{leaseQueryStatus(header, myIp, dhcp4.DHCPLEASEQUERYSTATUS, dhcp4.LQ_MALFORMED_QUERY, err.Error())}
+	}
+	inWindow := func(lease *pool.Lease) bool {
+		if start.IsZero() && end.IsZero() {
+			return true
+		}
+		return !lease.LastTransaction.IsZero() && !lease.LastTransaction.Before(start) &&
+			(end.IsZero() || !lease.LastTransaction.After(end))
+	}
+
+	var replies []*dhcp4.DHCPMessage
+	active := func(lease *pool.Lease) {
+		if !lease.Expired() && inWindow(lease) {
+			replies = append(replies, bindingReply(header, myIp, dhcp4.LQ_STATE_ACTIVE, lease))
+		}
+	}
+
+	relayInfo, byRelay := query.Options.Get(dhcp4.OPTION_RELAY_AGENT)
+	clientId, byClientId := query.Options.Get(dhcp4.OPTION_CLIENT_ID)
+	switch {
+	case !header.ClientAddr.Empty():
+		ip := header.ClientAddr
+		for _, p := range s.pools() {
+			if !p.Contains(ip) {
+				continue
+			}
+			if lease, ok := p.GetLeaseByIp(ip); ok && !lease.Expired() {
+				active(&lease)
+			} else if start.IsZero() && end.IsZero() {
+				replies = append(replies, bindingReply(header, myIp, dhcp4.LQ_STATE_AVAILABLE, &pool.Lease{IP: ip}))
+			}
+		}
+
+	case !header.Hardware().Empty() || byClientId:
+		key := header.Hardware()
+		if byClientId {
+			var ok bool
+			if key, ok = clientIdAddr(clientId.Data); !ok {
+				return []*dhcp4.DHCPMessage{leaseQueryStatus(header, myIp, dhcp4.DHCPLEASEQUERYSTATUS,
+					dhcp4.LQ_MALFORMED_QUERY, "Client identifier too short")}
+			}
+		}
+		for _, p := range s.pools() {
+			if lease, ok := p.GetLeaseByMac(key); ok {
+				active(&lease)
+			}
+		}
+
+	case byRelay:
+		relayId, _ := dhcp4.RelayAgentSubOption(relayInfo.Data, dhcp4.RELAY_AGENT_RELAY_ID)
+		remoteId, _ := dhcp4.RelayAgentSubOption(relayInfo.Data, dhcp4.RELAY_AGENT_REMOTE_ID)
+		if relayId == nil && remoteId == nil {
+			return []*dhcp4.DHCPMessage{leaseQueryStatus(header, myIp, dhcp4.DHCPLEASEQUERYSTATUS,
+				dhcp4.LQ_MALFORMED_QUERY, "Relay agent information without a relay id or remote id")}
+		}
+		for _, p := range s.pools() {
+			for _, lease := range p.GetLeases() {
+				if matchesRelay(&lease, relayId, remoteId) {
+					active(&lease)
+				}
+			}
+		}
+
+	// Everything
+	default:
+		for _, p := range s.pools() {
+			for _, lease := range p.GetLeases() {
+				active(&lease)
+			}
+		}
+	}
+
+	return append(replies, leaseQueryStatus(header, myIp, dhcp4.DHCPLEASEQUERYDONE, dhcp4.LQ_SUCCESS, ""))
+}
+
+// Stream changes to the requestor until it closes the connection, or falls
+// so far behind that changes are lost
+func (s *LeaseQueryServer) serveActive(conn net.Conn, query *dhcp4.DHCPMessage, myIp dhcp4.FixedV4) {
+	aq := &activeLeaseQuery{
+		query:   query.Header,
+		myIp:    myIp,
+		updates: make(chan *dhcp4.DHCPMessage, activeLeaseQueryQueue),
+	}
+	s.m.Lock()
+	s.active[aq] = true
+	s.m.Unlock()
+	defer func() {
+		s.m.Lock()
+		delete(s.active, aq)
+		s.m.Unlock()
+	}()
+
+	// The requestor has nothing more to say, so reading only tells us when
+	// it's gone
+	gone := make(chan struct{})
+	go func() {
+		conn.SetReadDeadline(time.Time{})
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+
+	if _, ok := query.Options.Get(dhcp4.OPTION_QUERY_START); ok {
+		err := writeLeaseQueryReply(conn, leaseQueryStatus(aq.query, myIp, dhcp4.DHCPLEASEQUERYSTATUS,
+			dhcp4.LQ_DATA_MISSING, "No history to catch up from; make a bulk query"))
+		if err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case update, ok := <-aq.updates:
+			if !ok {
+				log.Printf("Active leasequery from %v fell behind; closing", conn.RemoteAddr().String())
+				writeLeaseQueryReply(conn, leaseQueryStatus(aq.query, myIp, dhcp4.DHCPLEASEQUERYSTATUS,
+					dhcp4.LQ_DATA_MISSING, "Fell behind"))
+				return
+			}
+			if err := writeLeaseQueryReply(conn, update); err != nil {
+				log.Printf("Active leasequery from %v failed: %v", conn.RemoteAddr().String(), err)
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// Lease observer, queueing changes for active requestors
+func (s *LeaseQueryServer) Observe(event pool.LeaseEvent) {
+	state := byte(dhcp4.LQ_STATE_ACTIVE)
+	switch event.Kind {
+	case pool.LEASE_RELEASED:
+		state = dhcp4.LQ_STATE_RELEASED
+	case pool.LEASE_EXPIRED:
+		state = dhcp4.LQ_STATE_EXPIRED
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for aq := range s.active {
+		select {
+		case aq.updates <- bindingReply(aq.query, aq.myIp, state, &event.Lease):
+		default:
+			// Its connection sees the channel closed and gives up
+			close(aq.updates)
+			delete(s.active, aq)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestBulkLeaseQuery(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	p.RapidCommit = true
+
+	// Two clients behind one relay, on different subscribers' lines
+	lease := func(mac dhcp4.HardwareAddr, remoteId string) dhcp4.FixedV4 {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		message.Options.Set(dhcp4.OPTION_RAPID_COMMIT, nil)
+		message.Options.Set(dhcp4.OPTION_RELAY_AGENT, append([]byte{2, byte(len(remoteId))}, remoteId...))
+		response := NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+		require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+		return response.Header.YourAddr
+	}
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	ip1 := lease(mac1, "sub1")
+	lease(mac2, "sub2")
+
+	s, err := NewLeaseQueryServer(&LeaseQueryConf{Listen: "127.0.0.1:0", Allow: []string{"127.0.0.0/8"}},
+		func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+	defer s.Close()
+	p.AddObserver(s.Observe)
+	go s.Run()
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+
+	send := func(conn net.Conn, query *dhcp4.DHCPMessage) {
+		var buf bytes.Buffer
+		require.Nil(t, query.Encode(&buf))
+		require.Nil(t, dhcp4.WriteTcpMessage(conn, buf.Bytes()))
+	}
+	receive := func(conn net.Conn) *dhcp4.DHCPMessage {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, err := dhcp4.ReadTcpMessage(conn)
+		require.Nil(t, err)
+		reply, err := dhcp4.ParseDhcpMessage(data)
+		require.Nil(t, err)
+		require.Equal(t, uint32(0x1234), reply.Header.Identifier)
+		return reply
+	}
+	// Replies up to and including the last, by their IP, with its type
+	bulk := func(query *dhcp4.DHCPMessage) ([]*dhcp4.DHCPMessage, *dhcp4.DHCPMessage) {
+		send(conn, query)
+		var replies []*dhcp4.DHCPMessage
+		for {
+			reply := receive(conn)
+			switch reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) {
+			case dhcp4.DHCPLEASEQUERYDONE, dhcp4.DHCPLEASEQUERYSTATUS:
+				return replies, reply
+			}
+			replies = append(replies, reply)
+		}
+	}
+	newQuery := func() *dhcp4.DHCPMessage {
+		return newTestMessage(dhcp4.DHCPBULKLEASEQUERY, dhcp4.HardwareAddr{})
+	}
+
+	// Everything
+	replies, done := bulk(newQuery())
+	require.Len(t, replies, 2)
+	require.Equal(t, dhcp4.DHCPLEASEQUERYDONE, done.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	status, _ := done.Options.Get(dhcp4.OPTION_STATUS_CODE)
+	require.Equal(t, []byte{dhcp4.LQ_SUCCESS}, status.Data)
+	for _, reply := range replies {
+		require.Equal(t, dhcp4.DHCPLEASEACTIVE, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+		require.Equal(t, byte(dhcp4.LQ_STATE_ACTIVE), reply.Options.GetByte(dhcp4.OPTION_DHCP_STATE))
+		_, ok := reply.Options.GetUint32(dhcp4.OPTION_BASE_TIME)
+		require.True(t, ok)
+		require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("127.0.0.1")), reply.Options.GetFixedV4s(dhcp4.OPTION_SERVER_ID)[0])
+	}
+
+	// By remote id, on the same connection
+	query := newQuery()
+	query.Options.Set(dhcp4.OPTION_RELAY_AGENT, []byte{2, 4, 's', 'u', 'b', '1'})
+	replies, _ = bulk(query)
+	require.Len(t, replies, 1)
+	require.Equal(t, ip1, replies[0].Header.ClientAddr)
+	require.Equal(t, mac1, replies[0].Header.Hardware())
+
+	// By mac, and by IP, including one that's free
+	replies, _ = bulk(newTestMessage(dhcp4.DHCPBULKLEASEQUERY, mac2))
+	require.Len(t, replies, 1)
+	require.Equal(t, mac2, replies[0].Header.Hardware())
+	query = newQuery()
+	query.Header.ClientAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))
+	replies, _ = bulk(query)
+	require.Len(t, replies, 1)
+	require.Equal(t, dhcp4.DHCPLEASEUNASSIGNED, replies[0].Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, byte(dhcp4.LQ_STATE_AVAILABLE), replies[0].Options.GetByte(dhcp4.OPTION_DHCP_STATE))
+
+	// Only changes in a window ending before the leases were made
+	query = newQuery()
+	query.Options.SetUint32(dhcp4.OPTION_QUERY_END, uint32(time.Now().Add(-time.Hour).Unix()))
+	replies, _ = bulk(query)
+	require.Empty(t, replies)
+
+	// Malformed: relay information saying nothing we can query by
+	query = newQuery()
+	query.Options.Set(dhcp4.OPTION_RELAY_AGENT, []byte{1, 1, 'x'})
+	replies, done = bulk(query)
+	require.Empty(t, replies)
+	require.Equal(t, dhcp4.DHCPLEASEQUERYSTATUS, done.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	status, _ = done.Options.Get(dhcp4.OPTION_STATUS_CODE)
+	require.Equal(t, byte(dhcp4.LQ_MALFORMED_QUERY), status.Data[0])
+
+	// Malformed: an empty client identifier
+	query = newQuery()
+	query.Options.Set(dhcp4.OPTION_CLIENT_ID, []byte{})
+	replies, done = bulk(query)
+	require.Empty(t, replies)
+	require.Equal(t, dhcp4.DHCPLEASEQUERYSTATUS, done.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	status, _ = done.Options.Get(dhcp4.OPTION_STATUS_CODE)
+	require.Equal(t, byte(dhcp4.LQ_MALFORMED_QUERY), status.Data[0])
+
+	// An active query streams changes as they happen
+	active, err := net.Dial("tcp", s.ln.Addr().String())
+	require.Nil(t, err)
+	defer active.Close()
+	send(active, newTestMessage(dhcp4.DHCPACTIVELEASEQUERY, dhcp4.HardwareAddr{}))
+	require.Eventually(t, func() bool {
+		s.m.Lock()
+		defer s.m.Unlock()
+		return len(s.active) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	p.ReleaseLeaseByMac(mac1)
+	reply := receive(active)
+	require.Equal(t, dhcp4.DHCPLEASEUNASSIGNED, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, byte(dhcp4.LQ_STATE_RELEASED), reply.Options.GetByte(dhcp4.OPTION_DHCP_STATE))
+	require.Equal(t, ip1, reply.Header.ClientAddr)
+	require.Equal(t, mac1, reply.Header.Hardware())
+
+	lease(mac1, "sub1")
+	reply = receive(active)
+	require.Equal(t, dhcp4.DHCPLEASEACTIVE, reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, mac1, reply.Header.Hardware())
+
+	// Closing the connection ends it
+	active.Close()
+	require.Eventually(t, func() bool {
+		s.m.Lock()
+		defer s.m.Unlock()
+		return len(s.active) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Requestors not on the allow list are refused
+	s, err = NewLeaseQueryServer(&LeaseQueryConf{Listen: "127.0.0.1:0", Allow: []string{"10.0.0.1"}},
+		func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+	defer s.Close()
+	go s.Run()
+	refused, err := net.Dial("tcp", s.ln.Addr().String())
+	require.Nil(t, err)
+	defer refused.Close()
+	refused.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = dhcp4.ReadTcpMessage(refused)
+	require.NotNil(t, err)
+
+	_, err = NewLeaseQueryServer(&LeaseQueryConf{Listen: "127.0.0.1:0"}, nil)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Classes of clients, matched by what they send and where from, so some can
+// be treated differently to the rest of their pool, such as being given
+// shorter leases on a guest network or longer ones for servers. Clients are
+// put in the first class they match by classifying middleware.
+//
+
+type ClassConf struct {
+	Name string `yaml:"name"`
+
+	// What to match. A client must match each of these which are given,
+	// by any of the values listed
+	VendorClasses []string `yaml:"vendorclasses,omitempty"` // prefixes of the vendor class identifier
+	Macs          []string `yaml:"macs,omitempty"`          // mac prefixes, such as an OUI
+	Relays        []string `yaml:"relays,omitempty"`        // giaddrs, as IPs or networks
+	Pools         []string `yaml:"pools,omitempty"`
+	HardwareTypes []int    `yaml:"hardwaretypes,omitempty"` // htypes, e.g. 32 for InfiniBand
+	Enterprises   []uint32 `yaml:"enterprises,omitempty"`   // from the vendor-identifying vendor class
+	UserClasses   []string `yaml:"userclasses,omitempty"`   // from the user class (77)
+
+	// Fingerprints, the option codes a client asks for in order such as
+	// 1,3,6,15, which it must have one of, or with notfingerprints none of
+	Fingerprints    []string `yaml:"fingerprints,omitempty"`
+	NotFingerprints []string `yaml:"notfingerprints,omitempty"`
+
+	// Pool to serve the class from instead, such as a quarantine pool. It
+	// must be in the same shared network as the pools clients come in for
+	Pool string `yaml:"pool,omitempty"`
+
+	// Seconds to lease IPs for, overriding the pool's
+	LeaseTime uint32 `yaml:"leasetime,omitempty"`
+
+	// TFTP servers for the class, as for pools
+	Tftp []string `yaml:"tftp,omitempty"`
+
+	// Preset options for a vendor's IP phones, which also match them by
+	// vendor class unless vendorclasses is given
+	Voice *VoiceConf `yaml:"voice,omitempty"`
+
+	// CableLabs client configuration, as for pools, such as for eMTAs
+	// matched by a pktc vendor class
+	CableLabs *CableLabsConf `yaml:"cablelabs,omitempty"`
+
+	// Options for clients in the class, overriding the pool's, such as
+	// vendor specific information (43) for a vendor class
+	Options []OptionConf `yaml:"options,omitempty"`
+}
+
+type Class struct {
+	Name string
+
+	vendorClasses []string
+	macs          [][]byte
+	relays        *RelayAllowlist
+	pools         map[string]bool
+	htypes        map[byte]bool
+	enterprises   map[uint32]bool
+	userClasses   map[string]bool
+
+	fingerprints    map[string]bool
+	notFingerprints map[string]bool
+
+	Pool      string
+	LeaseTime time.Duration
+	Options   []dhcp4.CustomOption
+}
+
+func (cc *ClassConf) ToClass() (*Class, error) {
+	if cc.Name == "" {
+		return nil, fmt.Errorf("Classes need a name")
+	}
+
+	class := &Class{
+		Name:          cc.Name,
+		vendorClasses: cc.VendorClasses,
+		Pool:          cc.Pool,
+		LeaseTime:     time.Second * time.Duration(cc.LeaseTime),
+	}
+	for _, prefix := range cc.Macs {
+		mac, err := parseMacPrefix(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Class %v: %v", cc.Name, err)
+		}
+		class.macs = append(class.macs, mac)
+	}
+	if len(cc.Relays) != 0 {
+		relays, err := NewRelayAllowlist(cc.Relays)
+		if err != nil {
+			return nil, fmt.Errorf("Class %v: %v", cc.Name, err)
+		}
+		class.relays = relays
+	}
+	if len(cc.Pools) != 0 {
+		class.pools = map[string]bool{}
+		for _, name := range cc.Pools {
+			class.pools[name] = true
+		}
+	}
+	// Before anything else, so the class can override the preset
+	if cc.Voice != nil {
+		vendorClasses, options, err := cc.Voice.toPreset()
+		if err != nil {
+			return nil, fmt.Errorf("Class %v: %v", cc.Name, err)
+		}
+		if len(class.vendorClasses) == 0 {
+			class.vendorClasses = vendorClasses
+		}
+		class.Options = append(class.Options, options...)
+	}
+	if len(cc.Tftp) != 0 {
+		options, err := tftpOptions(cc.Tftp)
+		if err != nil {
+			return nil, fmt.Errorf("Class %v: %v", cc.Name, err)
+		}
+		class.Options = append(class.Options, options...)
+	}
+	if cc.CableLabs != nil {
+		option, err := cc.CableLabs.ToOption()
+		if err != nil {
+			return nil, fmt.Errorf("Class %v: %v", cc.Name, err)
+		}
+		class.Options = append(class.Options, option)
+	}
+	for _, oc := range cc.Options {
+		option, err := oc.ToOption()
+		if err != nil {
+			return nil, fmt.Errorf("Class %v: %v", cc.Name, err)
+		}
+		class.Options = append(class.Options, option)
+	}
+	if len(cc.HardwareTypes) != 0 {
+		class.htypes = map[byte]bool{}
+		for _, htype := range cc.HardwareTypes {
+			if htype < 1 || htype > 255 {
+				return nil, fmt.Errorf("Class %v: invalid hardware type %v", cc.Name, htype)
+			}
+			class.htypes[byte(htype)] = true
+		}
+	}
+	if len(cc.Enterprises) != 0 {
+		class.enterprises = map[uint32]bool{}
+		for _, enterprise := range cc.Enterprises {
+			class.enterprises[enterprise] = true
+		}
+	}
+	if len(cc.UserClasses) != 0 {
+		class.userClasses = stringSet(cc.UserClasses)
+	}
+	if len(cc.Fingerprints) != 0 {
+		class.fingerprints = stringSet(cc.Fingerprints)
+	}
+	if len(cc.NotFingerprints) != 0 {
+		class.notFingerprints = stringSet(cc.NotFingerprints)
+	}
+	return class, nil
+}
+
+func stringSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// Mac prefixes are written like macs, with anywhere from one to six octets
+func parseMacPrefix(prefix string) ([]byte, error) {
+	var mac []byte
+	for _, octet := range strings.Split(prefix, ":") {
+		var b byte
+		if _, err := fmt.Sscanf(octet, "%x", &b); err != nil || len(octet) > 2 {
+			return nil, fmt.Errorf("Invalid mac prefix '%v'", prefix)
+		}
+		mac = append(mac, b)
+	}
+	if len(mac) > 6 {
+		return nil, fmt.Errorf("Invalid mac prefix '%v'", prefix)
+	}
+	return mac, nil
+}
+
+func (c *Class) Matches(ctx *RequestContext, request *dhcp4.DHCPMessage) bool {
+	if len(c.vendorClasses) != 0 && !c.matchesVendorClass(ctx.VendorClass) {
+		return false
+	}
+	if c.enterprises != nil && !c.matchesEnterprise(ctx.VendorEnterprises) {
+		return false
+	}
+	if c.htypes != nil && !c.htypes[htypeOf(request.Header)] {
+		return false
+	}
+	if c.userClasses != nil && !c.matchesUserClass(ctx.UserClasses) {
+		return false
+	}
+	if c.fingerprints != nil || c.notFingerprints != nil {
+		fingerprint := storedFingerprint(ctx, request)
+		if c.fingerprints != nil && !c.fingerprints[fingerprint] {
+			return false
+		}
+		if c.notFingerprints[fingerprint] {
+			return false
+		}
+	}
+	if len(c.macs) != 0 && !c.matchesMac(request.Header.Hardware()) {
+		return false
+	}
+	if c.relays != nil && (!ctx.Relayed() || !c.relays.contains(ctx.RelayAddr.NetIp())) {
+		return false
+	}
+	if c.pools != nil && (ctx.Pool == nil || !c.pools[ctx.Pool.Name]) {
+		return false
+	}
+	return true
+}
+
+func (c *Class) matchesVendorClass(vendorClass string) bool {
+	for _, prefix := range c.vendorClasses {
+		if strings.HasPrefix(vendorClass, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Class) matchesEnterprise(enterprises []uint32) bool {
+	for _, enterprise := range enterprises {
+		if c.enterprises[enterprise] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Class) matchesUserClass(userClasses []string) bool {
+	for _, userClass := range userClasses {
+		if c.userClasses[userClass] {
+			return true
+		}
+	}
+	return false
+}
+
+// The client's fingerprint, or for messages without one such as releases,
+// the one stored on its lease
+func storedFingerprint(ctx *RequestContext, request *dhcp4.DHCPMessage) string {
+	if fingerprint := fingerprint(request.Options); fingerprint != "" || ctx.Pool == nil {
+		return fingerprint
+	}
+	if lease, ok := ctx.Pool.GetLeaseByMac(leaseKey(ctx.Pool, request)); ok {
+		return lease.Fingerprint
+	}
+	return ""
+}
+
+func (c *Class) matchesMac(hw dhcp4.HardwareAddr) bool {
+	for _, prefix := range c.macs {
+		if bytes.HasPrefix(hw.Bytes(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hardware type, taking our own unset ones to be ethernet like Encode does
+func htypeOf(header *dhcp4.MessageHeader) byte {
+	if header.HType == 0 {
+		return dhcp4.HTYPE_ETHERNET
+	}
+	return header.HType
+}
+
+// Classifier putting clients in the first of the classes they match
+func ClassMatcher(classes []*Class) Classifier {
+	return func(ctx *RequestContext, request *dhcp4.DHCPMessage) string {
+		for _, class := range classes {
+			if class.Matches(ctx, request) {
+				return class.Name
+			}
+		}
+		return ""
+	}
+}
+
+// Set up pools and middleware for the configured classes
+func (a *App) initClasses(confs []ClassConf) error {
+	if len(confs) == 0 {
+		return nil
+	}
+
+	var classes []*Class
+	leaseTimes := map[string]time.Duration{}
+	options := map[string][]dhcp4.CustomOption{}
+	classPools := map[string]*pool.Pool{}
+	for i := range confs {
+		class, err := confs[i].ToClass()
+		if err != nil {
+			return err
+		}
+		classes = append(classes, class)
+		if class.Pool != "" {
+			p, err := a.findPoolByName(class.Pool)
+			if err != nil {
+				return fmt.Errorf("Class %v: %v", class.Name, err)
+			}
+			if p.SharedNetwork == "" {
+				return fmt.Errorf("Class %v: pool %v isn't in a shared network", class.Name, p.Name)
+			}
+			classPools[class.Name] = p
+		}
+		if class.LeaseTime != 0 {
+			leaseTimes[class.Name] = class.LeaseTime
+		}
+		if len(class.Options) != 0 {
+			options[class.Name] = class.Options
+		}
+	}
+
+	for _, p := range a.pools() {
+		if len(leaseTimes) != 0 {
+			p.ClassLeaseTimes = leaseTimes
+		}
+		if len(options) != 0 {
+			p.ClassOptions = options
+		}
+	}
+	a.Use(ClassifyMiddleware(ClassMatcher(classes)))
+	if len(classPools) != 0 {
+		a.Use(ClassPoolMiddleware(classPools))
+	}
+	return nil
+}
+
+// Serve clients of classes with a pool of their own from it, so long as
+// it's in the shared network of the pool they came in for and so on their
+// link. They don't carry on to other pools when it's full. Goes after
+// classifying middleware
+func ClassPoolMiddleware(classPools map[string]*pool.Pool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+			p, ok := classPools[ctx.Class]
+			if !ok || ctx.Pool == nil || p == ctx.Pool {
+				return next.ServeDHCP(ctx, request)
+			}
+			if ctx.Pool.SharedNetwork != p.SharedNetwork {
+				ctx.Tracef("Not moving class %v to pool %v, which isn't in pool %v's shared network", ctx.Class, p.Name, ctx.Pool.Name)
+				return next.ServeDHCP(ctx, request)
+			}
+			ctx.Tracef("Serving class %v from pool %v", ctx.Class, p.Name)
+			ctx.Pool = p
+			ctx.Shared = []*pool.Pool{p}
+			return next.ServeDHCP(ctx, request)
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestClassMatcher(t *testing.T) {
+	confs := []ClassConf{
+		{Name: "phones", VendorClasses: []string{"Polycom"}, Macs: []string{"00:04:f2"}},
+		{Name: "guest", Relays: []string{"10.1.0.0/16"}},
+		{Name: "servers", Pools: []string{"dc"}},
+	}
+	var classes []*Class
+	for i := range confs {
+		class, err := confs[i].ToClass()
+		require.Nil(t, err)
+		classes = append(classes, class)
+	}
+	classify := ClassMatcher(classes)
+
+	p := newTestPool()
+	p.Name = "office"
+	classOf := func(mac dhcp4.HardwareAddr, vendorClass, relay string) string {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		if vendorClass != "" {
+			message.Options.SetString(dhcp4.OPTION_VENDOR, vendorClass)
+		}
+		if relay != "" {
+			message.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP(relay))
+		}
+		ctx := NewRequestContext("eth0", nil)
+		ctx.Populate(message)
+		ctx.Pool = p
+		return classify(ctx, message)
+	}
+
+	polycom := dhcp4.MacAddress{0, 0x04, 0xf2, 1, 2, 3}.Hardware()
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+
+	// Everything given has to match
+	require.Equal(t, "phones", classOf(polycom, "PolycomVVX-VVX_411", ""))
+	require.Equal(t, "", classOf(other, "PolycomVVX-VVX_411", ""))
+	require.Equal(t, "", classOf(polycom, "", ""))
+
+	// First match wins
+	require.Equal(t, "phones", classOf(polycom, "Polycom", "10.1.2.1"))
+	require.Equal(t, "guest", classOf(other, "", "10.1.2.1"))
+	require.Equal(t, "", classOf(other, "", "10.2.0.1"))
+
+	p.Name = "dc"
+	require.Equal(t, "servers", classOf(other, "", ""))
+
+	// Clients we can't lease to can still be classified
+	infiniband, err := (&ClassConf{Name: "infiniband", HardwareTypes: []int{32}}).ToClass()
+	require.Nil(t, err)
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{}.Hardware())
+	message.Header.HType = dhcp4.HTYPE_INFINIBAND
+	require.Equal(t, "infiniband", ClassMatcher([]*Class{infiniband})(NewRequestContext("ib0", nil), message))
+	message.Header.HType = 0
+	require.Equal(t, "", ClassMatcher([]*Class{infiniband})(NewRequestContext("ib0", nil), message))
+
+	// Enterprises come from the vendor-identifying vendor class
+	cpe, err := (&ClassConf{Name: "cpe", Enterprises: []uint32{3561}}).ToClass()
+	require.Nil(t, err)
+	message = newTestMessage(dhcp4.DHCPDISCOVER, other)
+	message.Options.Set(dhcp4.OPTION_VI_CLASS, dhcp4.EncodeVendorClasses([]dhcp4.VendorClass{{Enterprise: 4491}, {Enterprise: 3561}}))
+	ctx := NewRequestContext("eth0", nil)
+	ctx.Populate(message)
+	require.Equal(t, []uint32{4491, 3561}, ctx.VendorEnterprises)
+	require.Equal(t, "cpe", ClassMatcher([]*Class{cpe})(ctx, message))
+	ctx.Populate(newTestMessage(dhcp4.DHCPDISCOVER, other))
+	require.Equal(t, "", ClassMatcher([]*Class{cpe})(ctx, message))
+
+	_, err = (&ClassConf{Name: "bad", Macs: []string{"00:zz"}}).ToClass()
+	require.NotNil(t, err)
+	_, err = (&ClassConf{Macs: []string{"00"}}).ToClass()
+	require.NotNil(t, err)
+}
+
+func TestClassLeaseTime(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	p.ClassLeaseTimes = map[string]time.Duration{"guest": 10 * time.Minute}
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	leaseTime := func(class string) uint32 {
+		response := NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: p, Class: class}).Handle()
+		require.NotNil(t, response)
+		seconds, ok := response.Options.GetUint32(dhcp4.OPTION_LEASE_TIME)
+		require.True(t, ok)
+		return seconds
+	}
+	require.Equal(t, uint32(600), leaseTime("guest"))
+
+	// Renewing in another class gets that class's time
+	require.Equal(t, uint32(3600), leaseTime(""))
+	lease, ok := p.GetLeaseByMac(mac)
+	require.True(t, ok)
+	require.True(t, lease.Expiration.After(time.Now().Add(50*time.Minute)))
+}
+
+func TestClassOptions(t *testing.T) {
+	var confs []ClassConf
+	require.Nil(t, yaml.Unmarshal([]byte(`
+- name: unifi
+  vendorclasses: [ubnt]
+  options:
+    - code: 43
+      suboptions:
+        - code: 1
+          type: ip
+          value: 10.0.0.5
+        - code: 2
+          suboptions:
+            - code: 1
+              type: string
+              value: site
+`), &confs))
+
+	app := NewApp()
+	p := newTestPool()
+	p.Name = "office"
+	p.Network = net.ParseIP("10.0.0.0")
+	require.Nil(t, app.insertPool(p))
+	require.Nil(t, app.initClasses(confs))
+
+	vendorInfo := func(mac dhcp4.HardwareAddr, vendorClass string) ([]byte, bool) {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		message.Options.SetString(dhcp4.OPTION_VENDOR, vendorClass)
+		ctx := NewRequestContext("eth0", nil)
+		ctx.Populate(message)
+		ctx.Pool = p
+		response := app.serve.ServeDHCP(ctx, message)
+		require.NotNil(t, response)
+		option, ok := response.Options.Get(dhcp4.OPTION_VENDOR_INFO)
+		return option.Data, ok
+	}
+	data, ok := vendorInfo(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "ubnt")
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 4, 10, 0, 0, 5, 2, 6, 1, 4, 's', 'i', 't', 'e'}, data)
+
+	_, ok = vendorInfo(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "MSFT 5.0")
+	require.False(t, ok)
+
+	// Hosts still override classes
+	hostInfo, err := dhcp4.NewCustomOption(dhcp4.OPTION_VENDOR_INFO, "hex", "01:04:0a:00:00:06")
+	require.Nil(t, err)
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware()
+	require.Nil(t, p.AddReservedHost(&pool.ReservedHost{
+		Mac:     mac,
+		IP:      dhcp4.IpToFixedV4(net.ParseIP("10.0.0.50")),
+		Options: []dhcp4.CustomOption{hostInfo},
+	}))
+	data, ok = vendorInfo(mac, "ubnt")
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 4, 10, 0, 0, 6}, data)
+
+	_, err = (&ClassConf{Name: "bad", Options: []OptionConf{{Code: 43, Type: "ip", Value: "10.0.0.5", SubOptions: []OptionConf{{Code: 1, Type: "ip", Value: "10.0.0.5"}}}}}).ToClass()
+	require.NotNil(t, err)
+	_, err = (&ClassConf{Name: "bad", Options: []OptionConf{{Code: 43, SubOptions: []OptionConf{{Code: 255, Type: "uint8", Value: 1}}}}}).ToClass()
+	require.NotNil(t, err)
+}
+
+func TestClassTftp(t *testing.T) {
+	p, err := (&PoolConf{
+		Name: "office", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", MyIp: "10.0.0.254",
+		Options: []OptionConf{{Code: dhcp4.OPTION_TFTP_NAME, Type: "string", Value: "tftp.example.com"}},
+	}).ToPool()
+	require.Nil(t, err)
+	app := NewApp()
+	require.Nil(t, app.insertPool(p))
+	require.Nil(t, app.initClasses([]ClassConf{{Name: "phones", VendorClasses: []string{"Cisco"}, Tftp: []string{"10.0.0.2", "10.0.0.3"}}}))
+
+	tftp := func(mac dhcp4.HardwareAddr, vendorClass string) (string, []dhcp4.FixedV4) {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		message.Options.SetString(dhcp4.OPTION_VENDOR, vendorClass)
+		ctx := NewRequestContext("eth0", nil)
+		ctx.Populate(message)
+		ctx.Pool = p
+		response := app.serve.ServeDHCP(ctx, message)
+		require.NotNil(t, response)
+		name, _ := response.Options.GetString(dhcp4.OPTION_TFTP_NAME)
+		return name, response.Options.GetFixedV4s(dhcp4.OPTION_TFTP_SERVERS)
+	}
+	name, servers := tftp(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "Cisco Systems, Inc. IP Phone CP-7841")
+	require.Equal(t, "10.0.0.2", name)
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.2")), dhcp4.IpToFixedV4(net.ParseIP("10.0.0.3"))}, servers)
+
+	name, servers = tftp(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "MSFT 5.0")
+	require.Equal(t, "tftp.example.com", name)
+	require.Empty(t, servers)
+
+	_, err = (&ClassConf{Name: "bad", Tftp: []string{"tftp.example.com"}}).ToClass()
+	require.NotNil(t, err)
+}
+
+func TestClassCableLabs(t *testing.T) {
+	p, err := (&PoolConf{
+		Name: "cable", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", MyIp: "10.0.0.254",
+	}).ToPool()
+	require.Nil(t, err)
+	app := NewApp()
+	require.Nil(t, app.insertPool(p))
+	require.Nil(t, app.initClasses([]ClassConf{{Name: "emtas", VendorClasses: []string{"pktc"}, CableLabs: &CableLabsConf{
+		PrimaryDhcp: "10.0.0.254", Provisioning: "prov.example", Realm: "BASIC.1", Kdc: []string{"10.0.0.2"},
+	}}}))
+
+	ccc := func(mac dhcp4.HardwareAddr, vendorClass string) *dhcp4.CableLabsConfig {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		message.Options.SetString(dhcp4.OPTION_VENDOR, vendorClass)
+		message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_CCC})
+		ctx := NewRequestContext("eth0", nil)
+		ctx.Populate(message)
+		ctx.Pool = p
+		response := app.serve.ServeDHCP(ctx, message)
+		require.NotNil(t, response)
+		data, ok := response.Options.Get(dhcp4.OPTION_CCC)
+		if !ok {
+			return nil
+		}
+		decoded, err := dhcp4.DecodeCableLabsConfig(data.Data)
+		require.Nil(t, err)
+		return decoded
+	}
+	decoded := ccc(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "pktc1.5:051f0101")
+	require.NotNil(t, decoded)
+	require.Equal(t, net.IP{10, 0, 0, 254}, decoded.PrimaryDhcp)
+	require.Equal(t, "prov.example", decoded.Provisioning)
+	require.Equal(t, "BASIC.1", decoded.KerberosRealm)
+	require.Equal(t, []net.IP{{10, 0, 0, 2}}, decoded.KdcServers)
+	require.Nil(t, ccc(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "docsis3.0"))
+
+	for _, bad := range []*CableLabsConf{
+		{},
+		{PrimaryDhcp: "dhcp.example"},
+		{Provisioning: "fe80::1"},
+		{Kdc: []string{"kdc.example"}},
+	} {
+		_, err = (&ClassConf{Name: "bad", CableLabs: bad}).ToClass()
+		require.NotNil(t, err)
+	}
+}
+
+func TestClassPolicies(t *testing.T) {
+	office := newTestPool()
+	office.Name = "office"
+	office.Network = net.ParseIP("10.0.0.0")
+	office.LeaseTime = time.Hour
+	office.SharedNetwork = "link"
+
+	quarantine := newTestPool()
+	quarantine.Name = "quarantine"
+	quarantine.Network = net.ParseIP("10.0.1.0")
+	quarantine.Start = net.ParseIP("10.0.1.10")
+	quarantine.End = net.ParseIP("10.0.1.20")
+	quarantine.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.1.254"))
+	quarantine.LeaseTime = time.Hour
+	quarantine.SharedNetwork = "link"
+
+	app := NewApp()
+	require.Nil(t, app.insertPool(office))
+	require.Nil(t, app.insertPool(quarantine))
+	require.Nil(t, app.initClasses([]ClassConf{
+		{Name: "lab", UserClasses: []string{"lab"}, LeaseTime: 600},
+		{Name: "cameras", Fingerprints: []string{"1,3,6,12,15,28,42"}, LeaseTime: 1200},
+		// Devices with an IoT vendor's OUI we don't recognise the fingerprint of
+		{Name: "unknown-iot", Macs: []string{"00:17:88"}, NotFingerprints: []string{"1,3,6,15,28,42"}, Pool: "quarantine"},
+	}))
+
+	serve := func(mac dhcp4.HardwareAddr, prl []byte, userClass []byte) (*dhcp4.DHCPMessage, *RequestContext) {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		if prl != nil {
+			message.Options.Set(dhcp4.OPTION_PARAM_REQ, prl)
+		}
+		if userClass != nil {
+			message.Options.Set(dhcp4.OPTION_USER_CLASS, userClass)
+		}
+		ctx := NewRequestContext("eth0", nil)
+		ctx.Populate(message)
+		ctx.Pool = office
+		ctx.Shared = app.shared["link"]
+		response := app.serve.ServeDHCP(ctx, message)
+		require.NotNil(t, response)
+		return response, ctx
+	}
+	leaseTime := func(response *dhcp4.DHCPMessage) uint32 {
+		leaseTime, _ := response.Options.GetUint32(dhcp4.OPTION_LEASE_TIME)
+		return leaseTime
+	}
+
+	response, ctx := serve(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), nil, dhcp4.EncodeUserClasses([]string{"x", "lab"}))
+	require.Equal(t, "lab", ctx.Class)
+	require.Equal(t, uint32(600), leaseTime(response))
+
+	response, ctx = serve(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), []byte{1, 3, 6, 12, 15, 28, 42}, nil)
+	require.Equal(t, "cameras", ctx.Class)
+	require.Equal(t, uint32(1200), leaseTime(response))
+
+	// A known IoT fingerprint stays in the office pool, an unknown one is
+	// moved to quarantine
+	bulb := dhcp4.MacAddress{0, 0x17, 0x88, 0, 0, 1}.Hardware()
+	response, ctx = serve(bulb, []byte{1, 3, 6, 15, 28, 42}, nil)
+	require.Equal(t, "", ctx.Class)
+	require.True(t, office.Contains(response.Header.YourAddr))
+	response, ctx = serve(dhcp4.MacAddress{0, 0x17, 0x88, 0, 0, 2}.Hardware(), []byte{1, 3, 6, 121}, nil)
+	require.Equal(t, "unknown-iot", ctx.Class)
+	require.True(t, quarantine.Contains(response.Header.YourAddr))
+	require.Equal(t, []dhcp4.FixedV4{quarantine.MyIp}, response.Options.GetFixedV4s(dhcp4.OPTION_SERVER_ID))
+
+	// Without a fingerprint, the one stored on the client's lease is used
+	office.NoteTransaction(bulb, pool.Transaction{Fingerprint: "1,3,6,15,28,42"})
+	ctx = NewRequestContext("eth0", nil)
+	ctx.Pool = office
+	release := newTestMessage(dhcp4.DHCPRELEASE, bulb)
+	require.Equal(t, "1,3,6,15,28,42", storedFingerprint(ctx, release))
+
+	require.NotNil(t, app.initClasses([]ClassConf{{Name: "bad", Pool: "missing"}}))
+	lonely := newTestPool()
+	lonely.Name = "lonely"
+	lonely.Network = net.ParseIP("10.0.2.0")
+	lonely.Start = net.ParseIP("10.0.2.10")
+	lonely.End = net.ParseIP("10.0.2.20")
+	require.Nil(t, app.insertPool(lonely))
+	require.NotNil(t, app.initClasses([]ClassConf{{Name: "bad", Pool: "lonely"}}))
+}
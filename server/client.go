@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Smoke testing a deployment. `mygodhcpd client` goes through DISCOVER,
+// OFFER, REQUEST and ACK like a real client would, from port 68 and out of
+// a given interface, then prints the options it was given. The exit code is
+// non-zero if we didn't get a lease. It needs root, or at least the
+// capabilities to bind port 68 and to a device.
+//
+
+type ClientConf struct {
+	// Where to send requests, broadcast unless given a server, and where to
+	// receive replies
+	Server    string
+	Listen    string
+	Interface string
+
+	// Mac to ask for a lease for, the interface's unless given
+	Mac      dhcp4.MacAddress
+	Hostname string
+
+	// How long to keep trying for each reply
+	Timeout time.Duration
+
+	// Release the lease once we have it
+	Release bool
+}
+
+type Client struct {
+	conf   *ClientConf
+	conn   *net.UDPConn
+	server *net.UDPAddr
+}
+
+// Resend requests this often until we get a reply
+const clientRetryInterval = time.Second
+
+// Options we ask for, like most clients do
+var clientParamReq = []byte{
+	dhcp4.OPTION_SUBNET, dhcp4.OPTION_BROADCAST, dhcp4.OPTION_TIME_OFFSET, dhcp4.OPTION_ROUTER,
+	dhcp4.OPTION_DOMAIN_NAME, dhcp4.OPTION_DNS_SERVER, dhcp4.OPTION_HOST_NAME, dhcp4.OPTION_NTP_SERVER,
+	dhcp4.OPTION_MTU, dhcp4.OPTION_CLASSLESS_RT,
+}
+
+func NewClient(conf *ClientConf) (*Client, error) {
+	server, err := net.ResolveUDPAddr("udp4", conf.Server)
+	if err != nil {
+		return nil, fmt.Errorf("Bad server address '%v': %v", conf.Server, err)
+	}
+
+	config := net.ListenConfig{}
+	if conf.Interface != "" {
+		config.Control = func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			err := conn.Control(func(fd uintptr) {
+				sockErr = syscall.BindToDevice(int(fd), conf.Interface)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	conn, err := config.ListenPacket(context.Background(), "udp4", conf.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("Failed listening on %v: %v", conf.Listen, err)
+	}
+
+	return &Client{conf: conf, conn: conn.(*net.UDPConn), server: server}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get a lease, describing what happens to w. Returns the ACK
+func (c *Client) Run(w io.Writer) (*dhcp4.DHCPMessage, error) {
+	xid := rand.Uint32()
+
+	discover := newClientRequest(dhcp4.DHCPDISCOVER, c.conf.Mac, xid)
+	c.prepare(discover)
+	offer, err := c.exchange(discover, dhcp4.DHCPOFFER)
+	if err != nil {
+		return nil, fmt.Errorf("No offer: %v", err)
+	}
+	serverId, _ := offer.Options.GetIP(dhcp4.OPTION_SERVER_ID)
+	fmt.Fprintf(w, "DHCPOFFER of %v from %v\n", offer.Header.YourAddr.String(), serverId.String())
+
+	request := newClientRequest(dhcp4.DHCPREQUEST, c.conf.Mac, xid)
+	c.prepare(request)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, serverId)
+	ack, err := c.exchange(request, dhcp4.DHCPACK)
+	if err != nil {
+		return nil, fmt.Errorf("No ack: %v", err)
+	}
+	fmt.Fprintf(w, "DHCPACK of %v from %v\n", ack.Header.YourAddr.String(), serverId.String())
+	describeOptions(w, ack.Options)
+
+	if c.conf.Release {
+		release := newClientRequest(dhcp4.DHCPRELEASE, c.conf.Mac, rand.Uint32())
+		release.Header.ClientAddr = ack.Header.YourAddr
+		release.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, serverId)
+
+		// Straight to the server, rather than whoever's listening
+		to := c.server
+		if to.IP.Equal(net.IPv4bcast) {
+			to = &net.UDPAddr{IP: serverId.NetIp(), Port: 67}
+		}
+		if err := c.send(release, to); err != nil {
+			return ack, fmt.Errorf("Failed releasing: %v", err)
+		}
+		fmt.Fprintf(w, "DHCPRELEASE of %v\n", ack.Header.YourAddr.String())
+	}
+	return ack, nil
+}
+
+func (c *Client) prepare(message *dhcp4.DHCPMessage) {
+	// We have no address to receive unicast replies on yet
+	message.Header.Flags = dhcp4.FLAG_BROADCAST
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, clientParamReq)
+	if c.conf.Hostname != "" {
+		message.Options.SetString(dhcp4.OPTION_HOST_NAME, c.conf.Hostname)
+	}
+}
+
+func (c *Client) send(message *dhcp4.DHCPMessage, to *net.UDPAddr) error {
+	buf := new(bytes.Buffer)
+	if err := message.Encode(buf); err != nil {
+		return err
+	}
+	_, err := c.conn.WriteToUDP(buf.Bytes(), to)
+	return err
+}
+
+// Send a request until we get a reply of the type we want, or a NAK
+func (c *Client) exchange(message *dhcp4.DHCPMessage, want byte) (*dhcp4.DHCPMessage, error) {
+	deadline := time.Now().Add(c.conf.Timeout)
+	buf := make([]byte, 1500)
+
+	for time.Now().Before(deadline) {
+		if err := c.send(message, c.server); err != nil {
+			return nil, err
+		}
+
+		retry := time.Now().Add(clientRetryInterval)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+		c.conn.SetReadDeadline(retry)
+		for {
+			n, _, err := c.conn.ReadFromUDP(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			reply, err := dhcp4.ParseDhcpMessage(buf[:n])
+			if err != nil || reply.Header.Op != dhcp4.BOOT_REPLY || reply.Header.Identifier != message.Header.Identifier ||
+				reply.Header.Mac != message.Header.Mac {
+				continue
+			}
+			switch reply.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) {
+			case want:
+				return reply, nil
+			case dhcp4.DHCPNAK:
+				text, _ := reply.Options.GetString(dhcp4.OPTION_MESSAGE)
+				return nil, fmt.Errorf("Got DHCPNAK: %v", text)
+			}
+		}
+	}
+	return nil, errors.New("Timed out")
+}
+
+func describeOptions(w io.Writer, options *dhcp4.Options) {
+	codes := append([]byte(nil), options.Codes()...)
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		option, _ := options.Get(code)
+		fmt.Fprintf(w, "  %v (%v): %v\n", dhcp4.OptionName(code), code, dhcp4.FormatOption(code, option.Data))
+	}
+}
+
+// A request from mac, as a client would send it
+func newClientRequest(op byte, mac dhcp4.MacAddress, xid uint32) *dhcp4.DHCPMessage {
+	message := dhcp4.NewDhcpMessage()
+	message.Header.Op = dhcp4.BOOT_REQUEST
+	message.Header.HType = 1
+	message.Header.HLen = 6
+	message.Header.Identifier = xid
+	message.Header.Mac = mac
+	message.Header.Magic = dhcp4.Magic
+	message.Options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, op)
+	return message
+}
+
+// `mygodhcpd client [flags]`
+func RunClient(args []string) int {
+	conf := &ClientConf{}
+	var mac string
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	flags.StringVar(&conf.Interface, "interface", "", "Interface to send requests out of")
+	flags.StringVar(&conf.Server, "server", "255.255.255.255:67", "Address to send requests to")
+	flags.StringVar(&conf.Listen, "listen", ":68", "Address to receive replies on")
+	flags.StringVar(&mac, "mac", "", "Mac address to ask for a lease for. Defaults to the interface's")
+	flags.StringVar(&conf.Hostname, "hostname", "", "Host name to send")
+	flags.DurationVar(&conf.Timeout, "timeout", 10*time.Second, "How long to keep trying for each reply")
+	flags.BoolVar(&conf.Release, "release", false, "Release the lease once acked")
+	flags.Parse(args)
+
+	switch {
+	case mac != "":
+		hw, err := net.ParseMAC(mac)
+		if err != nil || len(hw) != 6 {
+			log.Printf("Bad mac address '%v'", mac)
+			return 1
+		}
+		copy(conf.Mac[:], hw)
+	case conf.Interface != "":
+		iface, err := net.InterfaceByName(conf.Interface)
+		if err != nil {
+			log.Printf("Failed finding interface: %v", err)
+			return 1
+		}
+		if len(iface.HardwareAddr) != 6 {
+			log.Printf("Interface %v has no ethernet address; give one with -mac", conf.Interface)
+			return 1
+		}
+		copy(conf.Mac[:], iface.HardwareAddr)
+	default:
+		log.Printf("Need an interface or mac address")
+		return 1
+	}
+
+	client, err := NewClient(conf)
+	if err != nil {
+		log.Printf("Failed starting client: %v", err)
+		return 1
+	}
+	defer client.Close()
+
+	if _, err := client.Run(os.Stdout); err != nil {
+		log.Printf("Failed getting a lease: %v", err)
+		return 1
+	}
+	return 0
+}
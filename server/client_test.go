@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestClient(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("127.0.0.0")
+	p.Netmask = net.ParseIP("255.0.0.0")
+	p.Start = net.ParseIP("127.0.0.10")
+	p.End = net.ParseIP("127.0.0.20")
+	p.MyIp = dhcp4.IpToFixedV4(net.ParseIP("127.0.0.1"))
+	p.Dns = []net.IP{net.ParseIP("1.1.1.1")}
+	p.LeaseTime = time.Hour
+
+	// Replies to the client's port, as there's nowhere to broadcast to
+	p.Broadcast = net.ParseIP("127.0.0.1")
+
+	app := newTestApp(t, p)
+	app.interfaces["lo"] = struct{}{}
+	require.Nil(t, SetupDhcpSocket(app.socket.(*UDPPacketConn).UDPConn))
+	go app.Serve()
+	defer app.Stop()
+
+	mac := dhcp4.MacAddress{0x02, 0, 0, 0, 0, 1}
+	client, err := NewClient(&ClientConf{
+		Server:   app.socket.LocalAddr().String(),
+		Listen:   "127.0.0.1:68",
+		Mac:      mac,
+		Hostname: "smoke",
+		Timeout:  2 * time.Second,
+		Release:  true,
+	})
+	if err != nil {
+		t.Skipf("Can't bind port 68: %v", err)
+	}
+	defer client.Close()
+
+	out := new(bytes.Buffer)
+	ack, err := client.Run(out)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("127.0.0.10")), ack.Header.YourAddr)
+	require.Contains(t, out.String(), "DHCPACK of 127.0.0.10 from 127.0.0.1")
+	require.Contains(t, out.String(), "dns server (6): 1.1.1.1")
+	require.Contains(t, out.String(), "lease time (51): 1h0m0s")
+
+	// Released
+	require.Eventually(t, func() bool {
+		_, ok := p.GetLeaseByMac(mac.Hardware())
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
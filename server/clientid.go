@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/sha256"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Leases kept by client identifier (option 61) rather than hardware
+// address, for pools set to, so one NIC can hold several: VMs or
+// containers bridged behind it, or RFC 4361 clients with an IAID per
+// interface. The identifier is used as a hardware address of its own type
+// where it fits in one, as ethernet ones sent by most clients do, so their
+// leases stay the same as by chaddr. Longer ones, such as RFC 4361's IAID
+// and DUID, are hashed into one of type 255. Clients with a reservation
+// keep being served by the address it's for.
+//
+
+// Hardware type for hashed client identifiers, as RFC 4361 uses for its
+const HTYPE_CLIENT_ID byte = 255
+
+// The hardware address the sender of a message has its leases in p kept by
+func leaseKey(p *pool.Pool, message *dhcp4.DHCPMessage) dhcp4.HardwareAddr {
+	hw := message.Header.Hardware()
+	if p == nil {
+		return hw
+	}
+	if key, ok := relayHostKey(p, message); ok {
+		return key
+	}
+	if key, ok := duidHostKey(p, message); ok {
+		return key
+	}
+	if !p.LeaseByClientId {
+		return hw
+	}
+	option, ok := message.Options.Get(dhcp4.OPTION_CLIENT_ID)
+	if !ok {
+		return hw
+	}
+	if _, ok := p.GetReservedHost(hw); ok {
+		return hw
+	}
+	if key, ok := clientIdAddr(option.Data); ok {
+		return key
+	}
+	return hw
+}
+
+// A client identifier as a hardware address, unless it's too short to be
+// one, being at least a type and a byte of identifier
+func clientIdAddr(id []byte) (dhcp4.HardwareAddr, bool) {
+	if len(id) < 2 {
+		return dhcp4.HardwareAddr{}, false
+	}
+	htype, addr := id[0], id[1:]
+	fits := htype != 0 && htype < HTYPE_DUID && len(addr) <= dhcp4.MAX_HLEN
+	if htype == dhcp4.HTYPE_ETHERNET && len(addr) != 6 {
+		fits = false
+	}
+	if fits {
+		return dhcp4.NewHardwareAddr(htype, addr), true
+	}
+	sum := sha256.Sum256(id)
+	return dhcp4.NewHardwareAddr(HTYPE_CLIENT_ID, sum[:dhcp4.MAX_HLEN]), true
+}
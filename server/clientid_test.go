@@ -0,0 +1,83 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestLeaseByClientId(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	p.LeaseByClientId = true
+
+	// Two VMs bridged behind the one NIC, with RFC 4361 identifiers
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	vm := func(iaid byte) []byte {
+		return []byte{HTYPE_CLIENT_ID, 0, 0, 0, iaid, 0, 2, 0, 0, 0x89, 0x0c, 1, 2, 3, 4, 5, 6, 7, 8}
+	}
+	lease := func(id []byte) dhcp4.FixedV4 {
+		discover := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		discover.Options.Set(dhcp4.OPTION_CLIENT_ID, id)
+		offer := NewRequestHandler(discover, &RequestContext{Pool: p}).Handle()
+		require.NotNil(t, offer)
+
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Options.Set(dhcp4.OPTION_CLIENT_ID, id)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+		request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+		ack := NewRequestHandler(request, &RequestContext{Pool: p}).Handle()
+		require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+		return ack.Header.YourAddr
+	}
+	first := lease(vm(1))
+	second := lease(vm(2))
+	require.NotEqual(t, first, second)
+	require.Equal(t, first, lease(vm(1)))
+
+	// Releasing one leaves the other be
+	release := newTestMessage(dhcp4.DHCPRELEASE, mac)
+	release.Options.Set(dhcp4.OPTION_CLIENT_ID, vm(1))
+	release.Header.ClientAddr = first
+	NewRequestHandler(release, &RequestContext{Pool: p}).Handle()
+	key, _ := clientIdAddr(vm(1))
+	_, ok := p.GetLeaseByMac(key)
+	require.False(t, ok)
+	key, _ = clientIdAddr(vm(2))
+	held, ok := p.GetLeaseByMac(key)
+	require.True(t, ok)
+	require.Equal(t, second, held.IP)
+
+	// Ethernet identifiers are kept by the mac in them, as without, and
+	// malformed ones hashed like long ones
+	ethernet := append([]byte{dhcp4.HTYPE_ETHERNET}, mac.Bytes()...)
+	key, ok = clientIdAddr(ethernet)
+	require.True(t, ok)
+	require.Equal(t, mac, key)
+	key, _ = clientIdAddr(append(ethernet, 0))
+	require.Equal(t, HTYPE_CLIENT_ID, key.Type())
+
+	// Ones too short to hold an identifier aren't used
+	_, ok = clientIdAddr([]byte{dhcp4.HTYPE_ETHERNET})
+	require.False(t, ok)
+	_, ok = clientIdAddr(nil)
+	require.False(t, ok)
+
+	// Reserved clients are served by their hardware address
+	reserved := dhcp4.MacAddress{0, 0, 0, 0, 0, 9}.Hardware()
+	require.Nil(t, p.AddReservedHost(&pool.ReservedHost{Mac: reserved, IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.19"))}))
+	discover := newTestMessage(dhcp4.DHCPDISCOVER, reserved)
+	discover.Options.Set(dhcp4.OPTION_CLIENT_ID, vm(3))
+	require.Equal(t, reserved, leaseKey(p, discover))
+
+	// And without the pool set to, everyone is
+	p.LeaseByClientId = false
+	discover = newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	discover.Options.Set(dhcp4.OPTION_CLIENT_ID, vm(3))
+	require.Equal(t, mac, leaseKey(p, discover))
+}
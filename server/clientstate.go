@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Which RFC 2131 client state a DHCPREQUEST was sent from, told apart by
+// which of the server identifier, requested IP option and ciaddr it has
+// (section 4.3.2). A renewing client unicasts straight to the server it got
+// its lease from, while a rebinding one broadcasts, possibly through a
+// relay, for any server to answer.
+//
+
+const (
+	STATE_SELECTING   = "SELECTING"
+	STATE_INIT_REBOOT = "INIT-REBOOT"
+	STATE_RENEWING    = "RENEWING"
+	STATE_REBINDING   = "REBINDING"
+)
+
+// Empty if the request fits none of them, having neither a requested IP
+// nor ciaddr
+func (r *RequestHandler) requestState() string {
+	if _, ok := r.options.Get(dhcp4.OPTION_SERVER_ID); ok {
+		return STATE_SELECTING
+	}
+	if _, ok := r.options.Get(dhcp4.OPTION_REQUESTED_IP); ok && r.header.ClientAddr.Empty() {
+		return STATE_INIT_REBOOT
+	}
+	if r.header.ClientAddr.Empty() {
+		return ""
+	}
+	if r.unicast() {
+		return STATE_RENEWING
+	}
+	return STATE_REBINDING
+}
+
+// The IP the client wants: the requested IP option when selecting or
+// rebooting, and the one it's using otherwise
+func (r *RequestHandler) requestedIp() dhcp4.FixedV4 {
+	if ip, ok := r.options.GetIP(dhcp4.OPTION_REQUESTED_IP); ok {
+		return ip
+	}
+	return r.header.ClientAddr
+}
+
+// Whether the request was sent straight to us rather than broadcast
+func (r *RequestHandler) unicast() bool {
+	dst := r.ctx.Dst
+	if dst == nil || r.ctx.Relayed() {
+		return false
+	}
+	return !dst.Equal(net.IPv4bcast) && !dst.Equal(r.ctx.Pool.Broadcast)
+}
+
+// Whether a message is a renewing client's REQUEST, sent straight to us,
+// before we know which pool it's for
+func unicastRenewal(ctx *RequestContext, message *dhcp4.DHCPMessage) bool {
+	if message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) != dhcp4.DHCPREQUEST || message.Header.ClientAddr.Empty() {
+		return false
+	}
+	return !ctx.Relayed() && ctx.Dst != nil && !ctx.Dst.Equal(net.IPv4bcast)
+}
@@ -0,0 +1,1013 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+// Pool conf object
+type PoolConf struct {
+	Name string `yaml:"name"`
+	MyIp string `yaml:"myip"`
+
+	Network string `yaml:"network"`
+	Subnet  string `yaml:"subnet,omitempty"`
+	Netmask string `yaml:"mask"`
+
+	// Broadcast address, if not the one implied by the network and mask
+	Broadcast string `yaml:"broadcast,omitempty"`
+
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	// If set, only answer requests for this pool received on this
+	// interface, relayed or not
+	Interface string `yaml:"interface,omitempty"`
+
+	// If set, only answer requests for this pool received on an 802.1Q
+	// sub-interface for this VLAN ID, such as eth0.10 for 10
+	Vlan int `yaml:"vlan,omitempty"`
+
+	// Pools with the same shared network are subnets on one link, handing
+	// out IPs from the next once one runs out, in the order configured
+	SharedNetwork string `yaml:"sharednetwork,omitempty"`
+
+	// Optional range for legacy BOOTP clients
+	BootpStart string `yaml:"bootpstart,omitempty"`
+	BootpEnd   string `yaml:"bootpend,omitempty"`
+
+	Router []string `yaml:"routers,omitempty"`
+	Dns    []string `yaml:"dns,omitempty"`
+	Ntp    []string `yaml:"ntp,omitempty"`
+
+	// SIP servers for VoIP phones, either all IPs or all domain names
+	Sip []string `yaml:"sip,omitempty"`
+
+	Routes []RouteConf `yaml:"routes,omitempty"`
+
+	LeaseTime uint32 `yaml:"leasetime"`
+
+	// Seconds an offered IP is held for the client to request it, if
+	// less than the lease time
+	OfferTime uint32 `yaml:"offertime,omitempty"`
+
+	// Seconds an IP a client declines as in use is kept out of use, if
+	// not the lease time
+	AbandonTime uint32 `yaml:"abandontime,omitempty"`
+
+	// Optional ARP probing of IPs before offering them to new clients
+	Probe *ProbeConf `yaml:"probe,omitempty"`
+
+	Mtu uint16 `yaml:"mtu,omitempty"`
+
+	// Allow the two message DISCOVER -> ACK exchange for clients asking for it
+	RapidCommit bool `yaml:"rapidcommit,omitempty"`
+
+	// Seconds clients which prefer IPv6-only (option 108) should go without
+	// IPv4, rather than being given an IP, on IPv6-mostly networks. At
+	// least 300
+	V6OnlyWait uint32 `yaml:"v6onlywait,omitempty"`
+
+	// Whether we're the only server for the network, so NAK requests for
+	// IPs we don't know of rather than leaving them for another server.
+	// True by default
+	Authoritative *bool `yaml:"authoritative,omitempty"`
+
+	// Only offer and lease to clients with a reservation or a class
+	IgnoreUnknown bool `yaml:"ignoreunknown,omitempty"`
+
+	// Renew existing leases but make no new ones, to empty the pool out
+	Drain bool `yaml:"drain,omitempty"`
+
+	// Most leases clients behind one relay circuit (option 82) can hold
+	MaxLeasesPerCircuit int `yaml:"maxleasespercircuit,omitempty"`
+
+	// Keep leases by client identifier (option 61) where clients send one,
+	// rather than hardware address, so one NIC can hold several
+	ClientId bool `yaml:"clientid,omitempty"`
+
+	// Proxy auto-config URL
+	Wpad string `yaml:"wpad,omitempty"`
+
+	// DNS domain name for clients to qualify their hostname with
+	Domain string `yaml:"domain,omitempty"`
+
+	// TFTP servers for phones to provision from, sent as both the TFTP
+	// server list (150) and, with only the first, the TFTP server name (66)
+	Tftp []string `yaml:"tftp,omitempty"`
+
+	// CableLabs client configuration (122) for PacketCable eMTAs
+	CableLabs *CableLabsConf `yaml:"cablelabs,omitempty"`
+
+	// Arbitrary options aside from the ones above
+	Options []OptionConf `yaml:"options,omitempty"`
+
+	// Vendor-identifying sub-options (125), sent to clients which identify
+	// as the enterprise in their vendor-identifying vendor class (124)
+	VendorOptions []VendorOptionsConf `yaml:"vendoroptions,omitempty"`
+
+	// How to pick IPs for new clients: sequential (the default), random, or
+	// hash, which gives each client the same IP whenever it's free
+	Allocation string `yaml:"allocation,omitempty"`
+
+	// Share the range with another server, each allocating from its part
+	Split *SplitConf `yaml:"split,omitempty"`
+
+	ReservedHosts []HostConf `yaml:"hosts,omitempty"`
+
+	// Additional reservations from a dnsmasq dhcp-hostsfile
+	DnsmasqHosts string `yaml:"dnsmasqhosts,omitempty"`
+
+	// Additional reservations from an ethers file, watched for changes
+	Ethers string `yaml:"ethers,omitempty"`
+}
+
+func (pc PoolConf) ToPool() (*pool.Pool, error) {
+	var allocator pool.Allocator
+	if pc.Allocation != "" {
+		var err error
+		if allocator, err = pool.AllocatorByName(pc.Allocation); err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+	}
+
+	pool := pool.NewPool()
+
+	pool.Name = pc.Name
+
+	if strings.Contains(pool.Name, "/") {
+		return nil, errors.New("Pool names cannot contain slashes as they are used in file names")
+	}
+
+	// FIXME: input validation for all of these
+	pool.Network = net.ParseIP(pc.Network)
+	pool.Netmask = net.ParseIP(pc.Netmask)
+	pool.Start = net.ParseIP(pc.Start)
+	pool.End = net.ParseIP(pc.End)
+	pool.MyIp = dhcp4.IpToFixedV4(net.ParseIP(pc.MyIp))
+	pool.Interface = pc.Interface
+	if pc.Vlan < 0 || pc.Vlan > 4094 {
+		return nil, fmt.Errorf("Pool %v: invalid VLAN ID %v", pc.Name, pc.Vlan)
+	}
+	pool.Vlan = pc.Vlan
+	pool.SharedNetwork = pc.SharedNetwork
+
+	if (pc.BootpStart == "") != (pc.BootpEnd == "") {
+		return nil, fmt.Errorf("Pool %v needs both bootpstart and bootpend", pc.Name)
+	}
+	if pc.BootpStart != "" {
+		pool.BootpStart = net.ParseIP(pc.BootpStart).To4()
+		pool.BootpEnd = net.ParseIP(pc.BootpEnd).To4()
+		if pool.BootpStart == nil || pool.BootpEnd == nil {
+			return nil, fmt.Errorf("Invalid BOOTP range %v-%v for pool %v", pc.BootpStart, pc.BootpEnd, pc.Name)
+		}
+		start, end := dhcp4.IpToFixedV4(pool.BootpStart), dhcp4.IpToFixedV4(pool.BootpEnd)
+		if end < start || !pool.OnNetwork(start) || !pool.OnNetwork(end) {
+			return nil, fmt.Errorf("BOOTP range %v-%v of pool %v isn't within its network", pc.BootpStart, pc.BootpEnd, pc.Name)
+		}
+	}
+	pool.LeaseTime = time.Second * time.Duration(pc.LeaseTime)
+	pool.OfferTime = time.Second * time.Duration(pc.OfferTime)
+	pool.AbandonTime = time.Second * time.Duration(pc.AbandonTime)
+	if pc.Probe != nil {
+		if pc.Probe.Retries < 0 {
+			return nil, fmt.Errorf("Pool %v: invalid probe retries %v", pc.Name, pc.Probe.Retries)
+		}
+		pool.ProbeTimeout = defaultProbeTimeout
+		if pc.Probe.Timeout != 0 {
+			pool.ProbeTimeout = time.Millisecond * time.Duration(pc.Probe.Timeout)
+		}
+		pool.ProbeRetries = pc.Probe.Retries
+	}
+	pool.Allocator = allocator
+
+	if pc.Split != nil {
+		start, end, err := pc.Split.Share(dhcp4.IpToFixedV4(pool.Start), dhcp4.IpToFixedV4(pool.End))
+		if err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+		pool.SetShare(start, end)
+	}
+
+	pool.Broadcast = dhcp4.CalcBroadcast(pool.Network, pool.Netmask)
+	if pc.Broadcast != "" {
+		pool.Broadcast = net.ParseIP(pc.Broadcast).To4()
+		if pool.Broadcast == nil {
+			return nil, fmt.Errorf("Invalid broadcast address %v for pool %v", pc.Broadcast, pc.Name)
+		}
+	}
+
+	// RFC 2132 specifies 68 as the minimum legal value
+	if pc.Mtu != 0 && pc.Mtu < 68 {
+		return nil, fmt.Errorf("MTU %v for pool %v is below the minimum of 68", pc.Mtu, pc.Name)
+	}
+	pool.Mtu = pc.Mtu
+	pool.RapidCommit = pc.RapidCommit
+	if pc.V6OnlyWait != 0 && pc.V6OnlyWait < dhcp4.MIN_V6ONLY_WAIT {
+		return nil, fmt.Errorf("IPv6-only wait %v for pool %v is below the minimum of %v", pc.V6OnlyWait, pc.Name, dhcp4.MIN_V6ONLY_WAIT)
+	}
+	pool.V6OnlyWait = time.Second * time.Duration(pc.V6OnlyWait)
+	pool.NotAuthoritative = pc.Authoritative != nil && !*pc.Authoritative
+	pool.IgnoreUnknown = pc.IgnoreUnknown
+	pool.SetDraining(pc.Drain)
+	if pc.MaxLeasesPerCircuit < 0 {
+		return nil, fmt.Errorf("Pool %v: invalid maximum leases per circuit %v", pc.Name, pc.MaxLeasesPerCircuit)
+	}
+	pool.MaxLeasesPerCircuit = pc.MaxLeasesPerCircuit
+	pool.LeaseByClientId = pc.ClientId
+
+	if pc.Wpad != "" {
+		u, err := url.ParseRequestURI(pc.Wpad)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid WPAD URL for pool %v: %v", pc.Name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid WPAD URL for pool %v: expected an http or https URL with a host", pc.Name)
+		}
+		if len(pc.Wpad) > 255 {
+			return nil, fmt.Errorf("WPAD URL for pool %v is too long", pc.Name)
+		}
+	}
+	pool.Wpad = pc.Wpad
+
+	if len(pc.Domain) > 255 {
+		return nil, fmt.Errorf("Domain name for pool %v is too long", pc.Name)
+	}
+	pool.Domain = pc.Domain
+
+	for _, ip := range pc.Router {
+		pool.Router = append(pool.Router, net.ParseIP(ip))
+	}
+
+	for _, ip := range pc.Dns {
+		pool.Dns = append(pool.Dns, net.ParseIP(ip))
+	}
+
+	for _, ip := range pc.Ntp {
+		pool.Ntp = append(pool.Ntp, net.ParseIP(ip))
+	}
+
+	if len(pc.Sip) != 0 {
+		sip, err := dhcp4.ParseSipServers(pc.Sip)
+		if err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+		pool.Sip = sip
+	}
+
+	for _, rc := range pc.Routes {
+		route, err := dhcp4.ParseStaticRoute(rc.Destination, rc.Router)
+		if err != nil {
+			return nil, err
+		}
+		pool.Routes = append(pool.Routes, route)
+	}
+
+	// Before arbitrary options, so those can override them
+	if len(pc.Tftp) != 0 {
+		options, err := tftpOptions(pc.Tftp)
+		if err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+		pool.Options = append(pool.Options, options...)
+	}
+	if pc.CableLabs != nil {
+		option, err := pc.CableLabs.ToOption()
+		if err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+		pool.Options = append(pool.Options, option)
+	}
+
+	for _, oc := range pc.Options {
+		option, err := oc.ToOption()
+		if err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+		pool.Options = append(pool.Options, option)
+	}
+
+	for _, vc := range pc.VendorOptions {
+		vendor, err := vc.ToVendorOptions()
+		if err != nil {
+			return nil, fmt.Errorf("Pool %v: %v", pc.Name, err)
+		}
+		pool.VendorOptions = append(pool.VendorOptions, vendor)
+	}
+
+	hostConfs, err := pc.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, hc := range hostConfs {
+		host, err := hc.ToHost()
+		if err != nil {
+			return nil, err
+		}
+		if err := pool.AddReservedHost(host); err != nil {
+			return nil, err
+		}
+	}
+
+	return pool, nil
+}
+
+// Reserved hosts from the configuration, any dnsmasq hosts file and any
+// ethers file
+func (pc *PoolConf) Hosts() ([]HostConf, error) {
+	hosts := pc.ReservedHosts
+	if pc.DnsmasqHosts != "" {
+		dnsmasqHosts, err := LoadDnsmasqHosts(pc.DnsmasqHosts)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(append([]HostConf{}, hosts...), dnsmasqHosts...)
+	}
+	if pc.Ethers != "" {
+		ethersHosts, err := pc.ethersHosts()
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(append([]HostConf{}, hosts...), ethersHosts...)
+	}
+	return hosts, nil
+}
+
+type SplitConf struct {
+	Role string `yaml:"role"`
+
+	// Percentage of the range belonging to the primary
+	Percent int `yaml:"percent"`
+}
+
+// The part of the range from start to end which is ours. The primary gets
+// the bottom of it and the secondary the rest
+func (sc *SplitConf) Share(start, end dhcp4.FixedV4) (dhcp4.FixedV4, dhcp4.FixedV4, error) {
+	if sc.Percent < 1 || sc.Percent > 99 {
+		return 0, 0, fmt.Errorf("Split percentage %v is outside 1-99", sc.Percent)
+	}
+	if end < start {
+		return 0, 0, errors.New("Can't split an empty range")
+	}
+
+	size := uint64(end-start) + 1
+	primarySize := dhcp4.FixedV4(size * uint64(sc.Percent) / 100)
+	if primarySize == 0 || uint64(primarySize) == size {
+		return 0, 0, fmt.Errorf("Range is too small to split %v%%", sc.Percent)
+	}
+
+	switch sc.Role {
+	case FAILOVER_PRIMARY:
+		return start, start + primarySize - 1, nil
+	case FAILOVER_SECONDARY:
+		return start + primarySize, end, nil
+	}
+	return 0, 0, fmt.Errorf("Unknown split role '%v'", sc.Role)
+}
+
+type RouteConf struct {
+	Destination string `yaml:"destination"`
+	Router      string `yaml:"router"`
+}
+
+type OptionConf struct {
+	Code  int         `yaml:"code"`
+	Type  string      `yaml:"type,omitempty"`
+	Value interface{} `yaml:"value,omitempty"`
+
+	// Instead of a value, sub-options to encapsulate, as for vendor
+	// specific information (43). These may have sub-options in turn
+	SubOptions []OptionConf `yaml:"suboptions,omitempty"`
+}
+
+func (oc OptionConf) ToOption() (dhcp4.CustomOption, error) {
+	kind, value, err := oc.typedValue()
+	if err != nil {
+		return dhcp4.CustomOption{}, fmt.Errorf("Option %v: %v", oc.Code, err)
+	}
+	return dhcp4.NewCustomOption(oc.Code, kind, value)
+}
+
+func (oc OptionConf) typedValue() (string, interface{}, error) {
+	if len(oc.SubOptions) == 0 {
+		return oc.Type, oc.Value, nil
+	}
+	if oc.Type != "" || oc.Value != nil {
+		return "", nil, errors.New("Give either a value or sub-options, not both")
+	}
+
+	var subOptions []dhcp4.CustomOption
+	for _, sc := range oc.SubOptions {
+		subOption, err := sc.toSubOption()
+		if err != nil {
+			return "", nil, err
+		}
+		subOptions = append(subOptions, subOption)
+	}
+	return "encapsulated", subOptions, nil
+}
+
+func (oc OptionConf) toSubOption() (dhcp4.CustomOption, error) {
+	kind, value, err := oc.typedValue()
+	if err != nil {
+		return dhcp4.CustomOption{}, fmt.Errorf("Sub-option %v: %v", oc.Code, err)
+	}
+	return dhcp4.NewSubOption(oc.Code, kind, value)
+}
+
+// Options 150 and 66 for TFTP servers given as IPs. Cisco and Avaya phones
+// take the list, most others only the name
+func tftpOptions(servers []string) ([]dhcp4.CustomOption, error) {
+	list, err := dhcp4.NewCustomOption(dhcp4.OPTION_TFTP_SERVERS, "ip-list", strings.Join(servers, ","))
+	if err != nil {
+		return nil, err
+	}
+	name, err := dhcp4.NewCustomOption(dhcp4.OPTION_TFTP_NAME, "string", servers[0])
+	if err != nil {
+		return nil, err
+	}
+	return []dhcp4.CustomOption{list, name}, nil
+}
+
+// Kerberos timeouts in milliseconds, and how many times to retry
+type KerberosBackoffConf struct {
+	Nominal uint32 `yaml:"nominal"`
+	Maximum uint32 `yaml:"maximum"`
+	Retries uint32 `yaml:"retries"`
+}
+
+func (kc *KerberosBackoffConf) toBackoff() *dhcp4.KerberosBackoff {
+	if kc == nil {
+		return nil
+	}
+	return &dhcp4.KerberosBackoff{NominalTimeout: kc.Nominal, MaximumTimeout: kc.Maximum, MaxRetries: kc.Retries}
+}
+
+// CableLabs client configuration sub-options, any left out aren't sent
+type CableLabsConf struct {
+	PrimaryDhcp   string `yaml:"primarydhcp,omitempty"`
+	SecondaryDhcp string `yaml:"secondarydhcp,omitempty"`
+
+	// Provisioning server, as an IP or a domain name
+	Provisioning string `yaml:"provisioning,omitempty"`
+
+	AsBackoff *KerberosBackoffConf `yaml:"asbackoff,omitempty"`
+	ApBackoff *KerberosBackoffConf `yaml:"apbackoff,omitempty"`
+
+	Realm string `yaml:"realm,omitempty"`
+	Tgt   bool   `yaml:"tgt,omitempty"`
+
+	// Minutes provisioning may take, 0 for no limit
+	ProvisioningTimer *uint8 `yaml:"provisioningtimer,omitempty"`
+
+	// RFC 3594 bits invalidating tickets the eMTA holds
+	TicketControl uint16 `yaml:"ticketcontrol,omitempty"`
+
+	Kdc []string `yaml:"kdc,omitempty"`
+}
+
+func (cc *CableLabsConf) ToOption() (dhcp4.CustomOption, error) {
+	ccc := &dhcp4.CableLabsConfig{
+		Provisioning:      cc.Provisioning,
+		AsBackoff:         cc.AsBackoff.toBackoff(),
+		ApBackoff:         cc.ApBackoff.toBackoff(),
+		KerberosRealm:     cc.Realm,
+		Tgt:               cc.Tgt,
+		ProvisioningTimer: cc.ProvisioningTimer,
+		TicketControl:     cc.TicketControl,
+	}
+	for _, server := range []struct {
+		ip  string
+		dst *net.IP
+	}{{cc.PrimaryDhcp, &ccc.PrimaryDhcp}, {cc.SecondaryDhcp, &ccc.SecondaryDhcp}} {
+		if server.ip == "" {
+			continue
+		}
+		if *server.dst = net.ParseIP(server.ip); *server.dst == nil {
+			return dhcp4.CustomOption{}, fmt.Errorf("Invalid CableLabs DHCP server '%v'", server.ip)
+		}
+	}
+	for _, s := range cc.Kdc {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return dhcp4.CustomOption{}, fmt.Errorf("Invalid KDC server '%v'", s)
+		}
+		ccc.KdcServers = append(ccc.KdcServers, ip)
+	}
+	if err := ccc.Validate(); err != nil {
+		return dhcp4.CustomOption{}, err
+	}
+	data := ccc.Bytes()
+	if len(data) == 0 {
+		return dhcp4.CustomOption{}, errors.New("No CableLabs sub-options")
+	}
+	if len(data) > 255 {
+		return dhcp4.CustomOption{}, errors.New("CableLabs sub-options are too long")
+	}
+	return dhcp4.CustomOption{Code: dhcp4.OPTION_CCC, Data: data}, nil
+}
+
+// Sub-options for one IANA enterprise number, eg 3561 for the Broadband
+// Forum's TR-069 ACS details
+type VendorOptionsConf struct {
+	Enterprise uint32       `yaml:"enterprise"`
+	Options    []OptionConf `yaml:"options"`
+}
+
+func (vc VendorOptionsConf) ToVendorOptions() (dhcp4.VendorOptions, error) {
+	vendor := dhcp4.VendorOptions{Enterprise: vc.Enterprise}
+	for _, oc := range vc.Options {
+		option, err := oc.toSubOption()
+		if err != nil {
+			return vendor, fmt.Errorf("Enterprise %v: %v", vc.Enterprise, err)
+		}
+		vendor.Options = append(vendor.Options, option)
+	}
+	if len(vendor.Options) == 0 {
+		return vendor, fmt.Errorf("Enterprise %v: no options", vc.Enterprise)
+	}
+	if len(dhcp4.EncapsulateOptions(vendor.Options)) > 255 {
+		return vendor, fmt.Errorf("Enterprise %v: options are too long", vc.Enterprise)
+	}
+	return vendor, nil
+}
+
+type HostConf struct {
+	IP       string `yaml:"ip"`
+	Mac      string `yaml:"hw,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+
+	// Instead of hw, the circuit id or remote id relays put in option 82,
+	// as text or hex after 0x, so whatever is behind that switch port or
+	// subscriber line gets the IP
+	CircuitId string `yaml:"circuitid,omitempty"`
+	RemoteId  string `yaml:"remoteid,omitempty"`
+
+	// Or the DUID dual-stack clients send in RFC 4361 client identifiers,
+	// as colon separated hex as DHCPv6 servers write it
+	Duid string `yaml:"duid,omitempty"`
+
+	// Options scoped to this host, overriding the pool's and class's. DNS
+	// servers, routes and the boot file may be given like a pool's, with
+	// anything in options overriding those
+	Dns      []string     `yaml:"dns,omitempty"`
+	Routes   []RouteConf  `yaml:"routes,omitempty"`
+	Bootfile string       `yaml:"bootfile,omitempty"`
+	Options  []OptionConf `yaml:"options,omitempty"`
+
+	// Seconds to lease the IP for, overriding the pool's and class's
+	LeaseTime uint32 `yaml:"leasetime,omitempty"`
+}
+
+// Hardware address the host is reserved under, its own or one made from
+// its circuit id, remote id or DUID
+func (hc *HostConf) Key() (dhcp4.HardwareAddr, error) {
+	given := 0
+	for _, s := range []string{hc.Mac, hc.CircuitId, hc.RemoteId, hc.Duid} {
+		if s != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return dhcp4.HardwareAddr{}, errors.New("Only one of hw, circuitid, remoteid and duid can be given")
+	}
+
+	switch {
+	case hc.CircuitId != "":
+		id, err := parseRelayId(hc.CircuitId)
+		return idAddr(HTYPE_CIRCUIT_ID, id), err
+	case hc.RemoteId != "":
+		id, err := parseRelayId(hc.RemoteId)
+		return idAddr(HTYPE_REMOTE_ID, id), err
+	case hc.Duid != "":
+		duid, err := dhcp4.ParseDUID(hc.Duid)
+		return duidAddr(duid), err
+	}
+	return dhcp4.ParseHardwareAddr(hc.Mac)
+}
+
+func (hc *HostConf) ToHost() (*pool.ReservedHost, error) {
+	mac, err := hc.Key()
+	if err != nil {
+		return nil, fmt.Errorf("Host %v: %v", hc.Hostname, err)
+	}
+	host := &pool.ReservedHost{
+		Mac:       mac,
+		Hostname:  hc.Hostname,
+		IP:        dhcp4.IpToFixedV4(net.ParseIP(hc.IP)),
+		LeaseTime: time.Second * time.Duration(hc.LeaseTime),
+	}
+	options, err := hc.fieldOptions()
+	if err != nil {
+		return nil, fmt.Errorf("Host %v: %v", hc.Mac, err)
+	}
+	host.Options = options
+	for _, oc := range hc.Options {
+		option, err := oc.ToOption()
+		if err != nil {
+			return nil, fmt.Errorf("Host %v: %v", hc.Mac, err)
+		}
+		host.Options = append(host.Options, option)
+	}
+	return host, nil
+}
+
+// Options for the host's fields which mirror the pool's
+func (hc *HostConf) fieldOptions() ([]dhcp4.CustomOption, error) {
+	var options []dhcp4.CustomOption
+	if len(hc.Dns) != 0 {
+		dns, err := dhcp4.NewCustomOption(dhcp4.OPTION_DNS_SERVER, "ip-list", strings.Join(hc.Dns, ","))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, dns)
+	}
+	if len(hc.Routes) != 0 {
+		var routes []dhcp4.StaticRoute
+		for _, rc := range hc.Routes {
+			route, err := dhcp4.ParseStaticRoute(rc.Destination, rc.Router)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, route)
+		}
+		data := dhcp4.EncodeStaticRoutes(routes)
+		if len(data) > 255 {
+			return nil, errors.New("Too many routes")
+		}
+		options = append(options,
+			dhcp4.CustomOption{Code: dhcp4.OPTION_CLASSLESS_RT, Data: data},
+			dhcp4.CustomOption{Code: dhcp4.OPTION_MS_CLASSLESS, Data: data})
+	}
+	if hc.Bootfile != "" {
+		bootfile, err := dhcp4.NewCustomOption(dhcp4.OPTION_BOOT_FILE, "string", hc.Bootfile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, bootfile)
+	}
+	return options, nil
+}
+
+// Options given for every pool, which anything given for a pool, class or
+// host overrides
+func (c *Conf) globalOptions() ([]dhcp4.CustomOption, error) {
+	var options []dhcp4.CustomOption
+	for _, oc := range c.Options {
+		option, err := oc.ToOption()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+type ProbeConf struct {
+	// Milliseconds to wait for an answer to each probe, 200 by default
+	Timeout uint32 `yaml:"timeout,omitempty"`
+
+	// Probes to send after the first before deciding the IP is free
+	Retries int `yaml:"retries,omitempty"`
+}
+
+// Root yaml conf
+type Conf struct {
+	Pools      []PoolConf `yaml:"pools"`
+	Leasedir   string     `yaml:"leasedir"`
+	Interfaces []string   `yaml:"interfaces"`
+
+	// Number of sockets to receive on, sharing the load with SO_REUSEPORT
+	Sockets int `yaml:"sockets,omitempty"`
+
+	// How many requests are handled at once, and queued beyond that
+	Workers *WorkerConf `yaml:"workers,omitempty"`
+
+	// Where to keep leases, if not in json files in leasedir
+	Backend *BackendConf `yaml:"backend,omitempty"`
+
+	// Commands to run when leases change
+	Exec []ExecHookConf `yaml:"exec,omitempty"`
+
+	// URLs to POST lease events to
+	Webhooks []WebhookConf `yaml:"webhooks,omitempty"`
+
+	// Message brokers to publish lease events to
+	EventBus []EventBusConf `yaml:"eventbus,omitempty"`
+
+	// Optional log of every transaction
+	Audit *AuditConf `yaml:"audit,omitempty"`
+
+	// If set, the only relay agents we accept relayed requests from, as
+	// IPs or networks
+	Relays []string `yaml:"relays,omitempty"`
+
+	// Optional RFC 3118 authentication of clients
+	Auth *AuthConf `yaml:"auth,omitempty"`
+
+	// Optional limit on offers to new clients, against starvation attacks
+	Starvation *StarvationConf `yaml:"starvation,omitempty"`
+
+	// Optional export of request traces to an OpenTelemetry collector
+	Otel *OtelConf `yaml:"otel,omitempty"`
+
+	// Optional address for the admin HTTP API to listen on, or
+	// unix:/path/to/socket
+	Admin string `yaml:"admin,omitempty"`
+
+	// Bearer token needed for changes through the admin API over TCP, and
+	// for everything if it isn't on loopback
+	AdminToken string `yaml:"admintoken,omitempty"`
+
+	// Optional active/standby pairing with another server
+	Failover *FailoverConf `yaml:"failover,omitempty"`
+
+	// Optional RFC 3074 split of clients with another active server
+	LoadBalance *LoadBalanceConf `yaml:"loadbalance,omitempty"`
+
+	// Handle requests and log what we'd answer, but never send anything
+	DryRun bool `yaml:"dryrun,omitempty"`
+
+	// Unicast replies to local clients which didn't ask for a broadcast,
+	// adding a neighbor entry for the IP they're getting, as ISC dhcpd does
+	UnicastReplies bool `yaml:"unicastreplies,omitempty"`
+
+	// Release a client's leases in other pools once it takes up a new one
+	OneLeasePerClient bool `yaml:"oneleaseperclient,omitempty"`
+
+	// Classes of clients to treat differently, the first matching winning
+	Classes []ClassConf `yaml:"classes,omitempty"`
+
+	// Seconds to answer retransmitted DISCOVERs and REQUESTs with the same
+	// reply as the first, rather than handling them again
+	Dedup uint32 `yaml:"dedup,omitempty"`
+
+	// Options for every pool, with the lowest precedence of all
+	Options []OptionConf `yaml:"options,omitempty"`
+
+	// How often pool utilization is sampled and how much is kept
+	Utilization *UtilizationConf `yaml:"utilization,omitempty"`
+
+	// Optional SNMP agent serving counts of messages and pool utilization
+	Snmp *SnmpConf `yaml:"snmp,omitempty"`
+
+	// Optional bulk and active leasequery service over TCP
+	LeaseQuery *LeaseQueryConf `yaml:"leasequery,omitempty"`
+
+	// Optional dynamic DNS updates of clients' hostnames
+	Ddns *DdnsConf `yaml:"ddns,omitempty"`
+
+	// Optional hosts file of active leases' hostnames, for a local resolver
+	HostsFile *HostsFileConf `yaml:"hostsfile,omitempty"`
+
+	// Optional DNS server answering for active leases' hostnames
+	Resolver *ResolverConf `yaml:"resolver,omitempty"`
+
+	// Optional watch for other DHCP servers answering clients
+	Rogue *RogueConf `yaml:"rogue,omitempty"`
+}
+
+type BackendConf struct {
+	Type string `yaml:"type"`
+
+	// Comma separated for backends which take several
+	Address string `yaml:"address"`
+
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Database int    `yaml:"database,omitempty"`
+
+	// Prepended to all keys, to allow sharing a database
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+type ExecHookConf struct {
+	// Lease events to run for, or all of them if empty
+	Events  []string `yaml:"events,omitempty"`
+	Command []string `yaml:"command"`
+
+	// In seconds
+	Timeout uint32 `yaml:"timeout,omitempty"`
+}
+
+type WebhookConf struct {
+	Url string `yaml:"url"`
+
+	// Events to send, or all of them if empty
+	Events []string `yaml:"events,omitempty"`
+
+	// Extra headers, e.g. for authentication
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	Retries *int `yaml:"retries,omitempty"`
+}
+
+type EventBusConf struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// Prefix of the topic each event is published to
+	Topic string `yaml:"topic,omitempty"`
+
+	// Events to send, or all of them if empty
+	Events []string `yaml:"events,omitempty"`
+}
+
+type AuditConf struct {
+	Path string `yaml:"path"`
+
+	// Size in MB to rotate at, and how many old files to keep
+	MaxSize uint32 `yaml:"maxsize,omitempty"`
+	Keep    int    `yaml:"keep,omitempty"`
+}
+
+type AuthConf struct {
+	// Secrets for the delayed authentication protocol, by id. New clients
+	// are given the first
+	Keys []AuthKeyConf `yaml:"keys,omitempty"`
+
+	// For the configuration token protocol
+	Token string `yaml:"token,omitempty"`
+
+	// Drop messages which aren't authenticated, and sign our replies
+	Require bool `yaml:"require,omitempty"`
+	Sign    bool `yaml:"sign,omitempty"`
+}
+
+type AuthKeyConf struct {
+	Id     uint32 `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+type WorkerConf struct {
+	// Defaults to 4 per CPU
+	Count int `yaml:"count,omitempty"`
+
+	// Packets waiting for a worker, 1024 by default
+	Queue int `yaml:"queue,omitempty"`
+
+	// Which to drop when the queue is full: the "newest" packet, just
+	// received, or the "oldest" one queued
+	Drop string `yaml:"drop,omitempty"`
+}
+
+type StarvationConf struct {
+	// At most MaxNew clients we hadn't seen are offered IPs in each pool
+	// every Window seconds
+	Window uint32 `yaml:"window,omitempty"`
+	MaxNew int    `yaml:"maxnew"`
+}
+
+type UtilizationConf struct {
+	// Seconds between samples, and how many of them to keep
+	Interval uint32 `yaml:"interval,omitempty"`
+	History  int    `yaml:"history,omitempty"`
+
+	// Warn when more than this percentage of a pool is in use
+	HighWater float64 `yaml:"highwater,omitempty"`
+
+	// Alert when fewer than this many IPs, or this percentage of them, are
+	// free in a pool
+	MinFree        int     `yaml:"minfree,omitempty"`
+	MinFreePercent float64 `yaml:"minfreepercent,omitempty"`
+}
+
+type SnmpConf struct {
+	// Address to listen on, such as 127.0.0.1:161
+	Listen string `yaml:"listen"`
+
+	// Community requests must give, public if not set
+	Community string `yaml:"community,omitempty"`
+
+	// OID to put our tree under, if not net-snmp's playpen
+	Oid string `yaml:"oid,omitempty"`
+}
+
+type LeaseQueryConf struct {
+	// Address to listen on, such as 10.0.0.1:67
+	Listen string `yaml:"listen"`
+
+	// Requestors allowed to connect, as IPs or CIDR networks
+	Allow []string `yaml:"allow"`
+}
+
+type DdnsConf struct {
+	// DNS server to send updates to, such as 10.0.0.1:53
+	Server string `yaml:"server"`
+
+	// Zone to update, if not each pool's domain. Also the domain of pools
+	// without one
+	Zone string `yaml:"zone,omitempty"`
+
+	// TTL of records in seconds, 300 if not set
+	Ttl uint32 `yaml:"ttl,omitempty"`
+
+	// TSIG keys to sign updates with, by zone
+	Keys []TsigKeyConf `yaml:"keys,omitempty"`
+}
+
+type TsigKeyConf struct {
+	// Zone whose updates the key signs
+	Zone string `yaml:"zone"`
+
+	// Name of the key, as the DNS server knows it
+	Name string `yaml:"name"`
+
+	// hmac-sha256 if not set, hmac-sha384 or hmac-sha512
+	Algorithm string `yaml:"algorithm,omitempty"`
+
+	// Base64, as in BIND's key files
+	Secret string `yaml:"secret"`
+}
+
+type HostsFileConf struct {
+	Path string `yaml:"path"`
+
+	// hosts if not set, or dnsmasq for host-record lines
+	Format string `yaml:"format,omitempty"`
+
+	// Seconds between writes, 60 if not set
+	Interval uint32 `yaml:"interval,omitempty"`
+}
+
+type ResolverConf struct {
+	// Address to answer DNS queries on, such as 127.0.0.1:5353
+	Listen string `yaml:"listen"`
+
+	// TTL of answers in seconds, 60 if not set
+	Ttl uint32 `yaml:"ttl,omitempty"`
+}
+
+type RogueConf struct {
+	// Address to listen for other servers' replies on, 0.0.0.0:68 if not set
+	Listen string `yaml:"listen,omitempty"`
+
+	// Other servers allowed to answer clients, as IPs or networks, besides
+	// our own pools' server identifiers
+	Allow []string `yaml:"allow,omitempty"`
+
+	// Seconds between alerts about the same server, an hour if not set
+	Realert uint32 `yaml:"realert,omitempty"`
+}
+
+type OtelConf struct {
+	// OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces
+	Endpoint string `yaml:"endpoint"`
+
+	// Reported as service.name
+	Service string `yaml:"service,omitempty"`
+
+	// Extra headers, e.g. for authentication
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type FailoverConf struct {
+	Role   string `yaml:"role"`
+	Listen string `yaml:"listen,omitempty"`
+	Peer   string `yaml:"peer,omitempty"`
+
+	// Shared by both peers, to sign what they send each other with
+	Secret string `yaml:"secret"`
+
+	// Addresses or networks the primary accepts the secondary from
+	Allow []string `yaml:"allow,omitempty"`
+
+	// In seconds
+	Heartbeat uint32 `yaml:"heartbeat,omitempty"`
+	Timeout   uint32 `yaml:"timeout,omitempty"`
+}
+
+type LoadBalanceConf struct {
+	Role string `yaml:"role"`
+
+	// Number of hash buckets (out of 256) owned by the primary
+	Split *int `yaml:"split,omitempty"`
+
+	// Answer any client whose secs field has reached this
+	MaxSecs uint16 `yaml:"maxsecs,omitempty"`
+}
+
+func ParseConf(path string) (*Conf, error) {
+	conf := &Conf{}
+	var err error
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(content, &conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Metadata about a single request, gathered as it moves through parsing,
+// pool lookup, allocation and response. Handed to hooks once the request
+// has been dealt with.
+//
+
+type TimingMark struct {
+	Stage string
+	Time  time.Time
+}
+
+type RequestContext struct {
+	// Receiving interface, and the VLAN ID if it's an 802.1Q sub-interface
+	Interface string
+	Vlan      int
+
+	Remote *net.UDPAddr
+
+	// Address the request was sent to, if we can tell
+	Dst net.IP
+
+	// Interface to send replies to clients on the local segment out of,
+	// and our address on it to send them from, if known
+	ReplyIfIndex int
+	ReplySrc     dhcp4.FixedV4
+
+	// Relay agent which forwarded this request, if any
+	RelayAddr dhcp4.FixedV4
+	Hops      byte
+
+	// Classification data supplied by the client, including the enterprises
+	// in its vendor-identifying vendor class
+	VendorClass       string
+	VendorEnterprises []uint32
+	UserClasses       []string
+
+	// Class the client was put in by classifying middleware, if any
+	Class string
+
+	Pool *pool.Pool
+
+	// Every pool in the shared network the pool is in, if it's in one
+	Shared []*pool.Pool
+
+	Marks []TimingMark
+
+	// Debug capture our responses are written to, if any
+	Capture *Capture
+
+	// How to authenticate our response, if the client asked us to
+	Auth *AuthReply
+
+	// What the client is being traced by, if it is
+	Trace string
+}
+
+func NewRequestContext(iface string, remote *net.UDPAddr) *RequestContext {
+	ctx := &RequestContext{
+		Interface: iface,
+		Vlan:      vlanFromInterface(iface),
+		Remote:    remote,
+	}
+	ctx.Mark("received")
+	return ctx
+}
+
+// Pull the relay and classification metadata out of a parsed message
+func (c *RequestContext) Populate(message *dhcp4.DHCPMessage) {
+	c.RelayAddr = message.Header.GatewayAddr
+	c.Hops = message.Header.Hops
+	c.VendorClass, _ = message.Options.GetString(dhcp4.OPTION_VENDOR)
+	c.VendorEnterprises = nil
+	if option, ok := message.Options.Get(dhcp4.OPTION_VI_CLASS); ok {
+		classes, _ := dhcp4.ParseVendorClasses(option.Data)
+		for _, class := range classes {
+			c.VendorEnterprises = append(c.VendorEnterprises, class.Enterprise)
+		}
+	}
+	c.UserClasses = nil
+	if option, ok := message.Options.Get(dhcp4.OPTION_USER_CLASS); ok {
+		c.UserClasses = dhcp4.ParseUserClasses(option.Data)
+	}
+}
+
+func (c *RequestContext) Relayed() bool {
+	return !c.RelayAddr.Empty()
+}
+
+// Record the time at which we hit a stage of the pipeline
+func (c *RequestContext) Mark(stage string) {
+	c.Marks = append(c.Marks, TimingMark{stage, time.Now()})
+}
+
+// Time between the first and last recorded marks
+func (c *RequestContext) Elapsed() time.Duration {
+	if len(c.Marks) < 2 {
+		return 0
+	}
+	return c.Marks[len(c.Marks)-1].Time.Sub(c.Marks[0].Time)
+}
+
+// Called once a request has been handled, with the response we decided on
+// (nil if we're not replying). Both messages are reused afterwards, so
+// anything wanted from them must be copied
+type RequestHook func(ctx *RequestContext, request, response *dhcp4.DHCPMessage)
+
+// eth0.10 -> 10
+func vlanFromInterface(iface string) int {
+	idx := strings.LastIndex(iface, ".")
+	if idx == -1 {
+		return 0
+	}
+	vlan, err := strconv.Atoi(iface[idx+1:])
+	if err != nil {
+		return 0
+	}
+	return vlan
+}
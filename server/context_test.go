@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestRequestContext(t *testing.T) {
+	require.Equal(t, 0, vlanFromInterface("eth0"))
+	require.Equal(t, 10, vlanFromInterface("eth0.10"))
+	require.Equal(t, 0, vlanFromInterface("eth0.foo"))
+
+	ctx := NewRequestContext("eth1.20", nil)
+	require.Equal(t, "eth1.20", ctx.Interface)
+	require.Equal(t, 20, ctx.Vlan)
+	require.Len(t, ctx.Marks, 1)
+
+	message := dhcp4.NewDhcpMessage()
+	message.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))
+	message.Header.Hops = 1
+	message.Options.Set(dhcp4.OPTION_VENDOR, []byte("MSFT 5.0"))
+
+	ctx.Populate(message)
+	require.True(t, ctx.Relayed())
+	require.Equal(t, byte(1), ctx.Hops)
+	require.Equal(t, "MSFT 5.0", ctx.VendorClass)
+
+	ctx.Mark("handled")
+	require.Len(t, ctx.Marks, 2)
+	require.True(t, ctx.Elapsed() >= 0)
+}
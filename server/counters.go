@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Counts of the messages we've received and sent, by DHCP message type,
+// with 0 for BOOTP messages which have none
+//
+
+type MessageCounters struct {
+	received [256]atomic.Uint64
+	sent     [256]atomic.Uint64
+}
+
+func (c *MessageCounters) Received(message *dhcp4.DHCPMessage) {
+	c.received[message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)].Add(1)
+}
+
+func (c *MessageCounters) Sent(message *dhcp4.DHCPMessage) {
+	c.sent[message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)].Add(1)
+}
+
+// Counts of each type seen at least once, by type
+func (c *MessageCounters) Snapshot() (received, sent map[byte]uint64) {
+	received = map[byte]uint64{}
+	sent = map[byte]uint64{}
+	for i := range c.received {
+		if n := c.received[i].Load(); n != 0 {
+			received[byte(i)] = n
+		}
+		if n := c.sent[i].Load(); n != 0 {
+			sent[byte(i)] = n
+		}
+	}
+	return received, sent
+}
+
+// Name of a message type for metrics
+func messageTypeName(op byte) string {
+	if op == 0 {
+		return "BOOTP"
+	}
+	if name, ok := dhcp4.OpNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("%v", op)
+}
+
+// Counts in the Prometheus text format
+func (c *MessageCounters) WriteMetrics(w io.Writer) {
+	received, sent := c.Snapshot()
+	for _, metric := range []struct {
+		name   string
+		help   string
+		counts map[byte]uint64
+	}{
+		{"dhcp_messages_received_total", "Messages received, by type", received},
+		{"dhcp_messages_sent_total", "Messages sent, by type", sent},
+	} {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", metric.name, metric.help, metric.name)
+		ops := make([]byte, 0, len(metric.counts))
+		for op := range metric.counts {
+			ops = append(ops, op)
+		}
+		sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+		for _, op := range ops {
+			fmt.Fprintf(w, "%v{type=%q} %v\n", metric.name, messageTypeName(op), metric.counts[op])
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Dynamic DNS (RFC 2136), putting the hostnames clients send in DNS as A
+// records under their pool's domain. Each name gets a DHCID record too
+// (RFC 4701), a hash of the client's identity and the name, so that two
+// clients claiming the same hostname don't overwrite each other's records
+// (RFC 4703): a name is only taken over if its DHCID is ours, and only
+// removed when the lease ends if it still is. Clients known by an RFC 4361
+// DUID get the same DHCID from us as from a DHCPv6 server, so both can
+// share a name. Updates to zones we have a TSIG key for are signed, see
+// tsig.go. Updates are sent from a queue, and dropped if the DNS server
+// can't be reached.
+//
+
+const ddnsTimeout = 5 * time.Second
+
+// TTL of records, if not configured
+const defaultDdnsTtl = 300
+
+// DNS types, classes and response codes we use
+const (
+	dnsTypeA     = 1
+	dnsTypeSOA   = 6
+	dnsTypeAAAA  = 28
+	dnsTypeDHCID = 49
+	dnsTypeANY   = 255
+
+	dnsClassIN   = 1
+	dnsClassNONE = 254
+	dnsClassANY  = 255
+
+	dnsOpcodeUpdate = 5
+
+	dnsNoError  = 0
+	dnsYXDomain = 6
+	dnsYXRRSet  = 7
+	dnsNXRRSet  = 8
+)
+
+var dnsRcodeNames = map[byte]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+func dnsRcodeName(rcode byte) string {
+	if name, ok := dnsRcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("rcode %v", rcode)
+}
+
+// Identifier types of DHCID records
+const (
+	DHCID_CHADDR    = 0
+	DHCID_CLIENT_ID = 1
+	DHCID_DUID      = 2
+)
+
+const dhcidDigestSha256 = 1
+
+// A DHCID record's data: the identifier type, digest type, and a SHA-256 of
+// the identifier then the name in wire format
+func dhcid(idType uint16, id []byte, fqdn string) []byte {
+	digest := sha256.New()
+	digest.Write(id)
+	digest.Write(dhcp4.EncodeDomainNames([]string{strings.ToLower(fqdn)}))
+	data := binary.BigEndian.AppendUint16(nil, idType)
+	data = append(data, dhcidDigestSha256)
+	return digest.Sum(data)
+}
+
+// The DHCID of a lease's client: by its DUID if it has one, else by the
+// client identifier it sent, else its hardware type and address, as RFC
+// 4701 section 3.3 says
+func leaseDhcid(lease *pool.Lease, fqdn string) []byte {
+	if lease.Duid != "" {
+		if duid, err := dhcp4.ParseDUID(lease.Duid); err == nil {
+			return dhcid(DHCID_DUID, duid, fqdn)
+		}
+	}
+	if len(lease.ClientId) > 0 {
+		return dhcid(DHCID_CLIENT_ID, lease.ClientId, fqdn)
+	}
+	return dhcid(DHCID_CHADDR, append([]byte{lease.Mac.Type()}, lease.Mac.Bytes()...), fqdn)
+}
+
+// A resource record in an update, which depending on its class and TTL is
+// a prerequisite on or a change to the zone
+type dnsRR struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+func (rr *dnsRR) appendTo(b []byte) []byte {
+	b = append(b, dhcp4.EncodeDomainNames([]string{rr.name})...)
+	b = binary.BigEndian.AppendUint16(b, rr.rtype)
+	b = binary.BigEndian.AppendUint16(b, rr.class)
+	b = binary.BigEndian.AppendUint32(b, rr.ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rr.data)))
+	return append(b, rr.data...)
+}
+
+type dnsUpdate struct {
+	zone    string
+	prereqs []dnsRR
+	updates []dnsRR
+}
+
+func (u *dnsUpdate) Bytes(id uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, dnsOpcodeUpdate<<11)
+	// One zone, then the prerequisites and updates, with no additional
+	// records
+	for _, count := range []int{1, len(u.prereqs), len(u.updates), 0} {
+		b = binary.BigEndian.AppendUint16(b, uint16(count))
+	}
+	b = append(b, dhcp4.EncodeDomainNames([]string{u.zone})...)
+	b = binary.BigEndian.AppendUint16(b, dnsTypeSOA)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+	for _, rr := range u.prereqs {
+		b = rr.appendTo(b)
+	}
+	for _, rr := range u.updates {
+		b = rr.appendTo(b)
+	}
+	return b
+}
+
+// Prerequisites
+func nameNotInUse(name string) dnsRR {
+	return dnsRR{name: name, rtype: dnsTypeANY, class: dnsClassNONE}
+}
+
+func rrsetNotExists(name string, rtype uint16) dnsRR {
+	return dnsRR{name: name, rtype: rtype, class: dnsClassNONE}
+}
+
+func rrExists(name string, rtype uint16, data []byte) dnsRR {
+	return dnsRR{name: name, rtype: rtype, class: dnsClassIN, data: data}
+}
+
+// Changes
+func addRR(name string, rtype uint16, ttl uint32, data []byte) dnsRR {
+	return dnsRR{name: name, rtype: rtype, class: dnsClassIN, ttl: ttl, data: data}
+}
+
+func deleteRRset(name string, rtype uint16) dnsRR {
+	return dnsRR{name: name, rtype: rtype, class: dnsClassANY}
+}
+
+func deleteRR(name string, rtype uint16, data []byte) dnsRR {
+	return dnsRR{name: name, rtype: rtype, class: dnsClassNONE, data: data}
+}
+
+// Whether a hostname can go in DNS as is, as a single label of letters,
+// digits and hyphens
+func validHostLabel(name string) bool {
+	if name == "" || len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+type ddnsName struct {
+	fqdn  string
+	zone  string
+	ip    dhcp4.FixedV4
+	dhcid []byte
+}
+
+type ddnsJob struct {
+	add  bool
+	mac  dhcp4.HardwareAddr
+	name ddnsName
+}
+
+var errDdnsConflict = errors.New("name belongs to another client")
+
+type DdnsUpdater struct {
+	server string
+	zone   string
+	ttl    uint32
+	keys   map[string]*TsigKey
+	queue  chan ddnsJob
+
+	// Sends an update, returning the server's response code
+	exchange func(update *dnsUpdate) (byte, error)
+
+	m sync.Mutex
+
+	// Names we've put in DNS, by client, so renewals needn't be sent and we
+	// know what to remove
+	names map[dhcp4.HardwareAddr]ddnsName
+}
+
+func NewDdnsUpdater(conf *DdnsConf) (*DdnsUpdater, error) {
+	if conf.Server == "" {
+		return nil, errors.New("Dynamic DNS needs a server to send updates to")
+	}
+	if _, _, err := net.SplitHostPort(conf.Server); err != nil {
+		return nil, fmt.Errorf("Invalid dynamic DNS server '%v': %v", conf.Server, err)
+	}
+	u := &DdnsUpdater{
+		server: conf.Server,
+		zone:   strings.TrimSuffix(conf.Zone, "."),
+		ttl:    conf.Ttl,
+		keys:   map[string]*TsigKey{},
+		queue:  make(chan ddnsJob, 1024),
+		names:  map[dhcp4.HardwareAddr]ddnsName{},
+	}
+	if u.ttl == 0 {
+		u.ttl = defaultDdnsTtl
+	}
+	for i := range conf.Keys {
+		zone := strings.ToLower(strings.TrimSuffix(conf.Keys[i].Zone, "."))
+		if zone == "" {
+			return nil, fmt.Errorf("TSIG key %v needs a zone", conf.Keys[i].Name)
+		}
+		if _, ok := u.keys[zone]; ok {
+			return nil, fmt.Errorf("More than one TSIG key for zone %v", zone)
+		}
+		key, err := NewTsigKey(&conf.Keys[i])
+		if err != nil {
+			return nil, err
+		}
+		u.keys[zone] = key
+	}
+	u.exchange = u.send
+	return u, nil
+}
+
+// The name a lease's client should have, if it can have one
+func (u *DdnsUpdater) name(p *pool.Pool, lease *pool.Lease) (ddnsName, bool) {
+	domain := strings.TrimSuffix(p.Domain, ".")
+	if domain == "" {
+		domain = u.zone
+	}
+	if domain == "" || !validHostLabel(lease.Hostname) {
+		return ddnsName{}, false
+	}
+	zone := u.zone
+	if zone == "" {
+		zone = domain
+	}
+	fqdn := strings.ToLower(lease.Hostname) + "." + domain
+	return ddnsName{fqdn, zone, lease.IP, leaseDhcid(lease, fqdn)}, true
+}
+
+// Lease observer
+func (u *DdnsUpdater) Observe(event pool.LeaseEvent) {
+	job := ddnsJob{mac: event.Lease.Mac}
+	switch event.Kind {
+	case pool.LEASE_CREATED, pool.LEASE_RENEWED:
+		name, ok := u.name(event.Pool, &event.Lease)
+		if !ok {
+			return
+		}
+		job.add, job.name = true, name
+	default:
+		// Whatever name we gave the client is looked up when removing
+	}
+	select {
+	case u.queue <- job:
+	default:
+		log.Printf("Dynamic DNS queue full; dropping update for %v", event.Lease.IP.String())
+	}
+}
+
+func (u *DdnsUpdater) Run() {
+	for job := range u.queue {
+		u.handle(job)
+	}
+}
+
+func (u *DdnsUpdater) handle(job ddnsJob) {
+	u.m.Lock()
+	current, ok := u.names[job.mac]
+	u.m.Unlock()
+
+	if job.add {
+		if ok && current.fqdn == job.name.fqdn && current.ip == job.name.ip {
+			return
+		}
+		// The client's changed name, so give up the old one
+		if ok && current.fqdn != job.name.fqdn {
+			u.removeName(job.mac, current)
+		}
+		err := u.add(&job.name)
+		if err != nil {
+			log.Printf("Dynamic DNS update of %v to %v failed: %v", job.name.fqdn, job.name.ip.String(), err)
+			return
+		}
+		log.Printf("Dynamic DNS: %v is %v", job.name.fqdn, job.name.ip.String())
+		u.m.Lock()
+		u.names[job.mac] = job.name
+		u.m.Unlock()
+		return
+	}
+
+	if ok {
+		u.removeName(job.mac, current)
+	}
+}
+
+func (u *DdnsUpdater) removeName(mac dhcp4.HardwareAddr, name ddnsName) {
+	if err := u.remove(&name); err != nil {
+		log.Printf("Dynamic DNS removal of %v failed: %v", name.fqdn, err)
+	} else {
+		log.Printf("Dynamic DNS: removed %v", name.fqdn)
+	}
+	u.m.Lock()
+	delete(u.names, mac)
+	u.m.Unlock()
+}
+
+// Add the name's A record, taking the name over only if it's ours
+func (u *DdnsUpdater) add(name *ddnsName) error {
+	ip := name.ip.NetIp().To4()
+	rcode, err := u.exchange(&dnsUpdate{
+		zone:    name.zone,
+		prereqs: []dnsRR{nameNotInUse(name.fqdn)},
+		updates: []dnsRR{
+			addRR(name.fqdn, dnsTypeA, u.ttl, ip),
+			addRR(name.fqdn, dnsTypeDHCID, u.ttl, name.dhcid),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dnsNoError:
+		return nil
+	case dnsYXDomain:
+	default:
+		return errors.New(dnsRcodeName(rcode))
+	}
+
+	// Someone has the name, which is fine if it's the client itself
+	rcode, err = u.exchange(&dnsUpdate{
+		zone:    name.zone,
+		prereqs: []dnsRR{rrExists(name.fqdn, dnsTypeDHCID, name.dhcid)},
+		updates: []dnsRR{
+			deleteRRset(name.fqdn, dnsTypeA),
+			addRR(name.fqdn, dnsTypeA, u.ttl, ip),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dnsNoError:
+		return nil
+	case dnsNXRRSet:
+		return errDdnsConflict
+	}
+	return errors.New(dnsRcodeName(rcode))
+}
+
+// Remove the name's A record if it's still ours, and its DHCID once
+// nothing else has the name, such as a DHCPv6 server's AAAA record
+func (u *DdnsUpdater) remove(name *ddnsName) error {
+	rcode, err := u.exchange(&dnsUpdate{
+		zone:    name.zone,
+		prereqs: []dnsRR{rrExists(name.fqdn, dnsTypeDHCID, name.dhcid)},
+		updates: []dnsRR{deleteRR(name.fqdn, dnsTypeA, name.ip.NetIp().To4())},
+	})
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dnsNoError:
+	case dnsNXRRSet:
+		return errDdnsConflict
+	default:
+		return errors.New(dnsRcodeName(rcode))
+	}
+
+	rcode, err = u.exchange(&dnsUpdate{
+		zone: name.zone,
+		prereqs: []dnsRR{
+			rrExists(name.fqdn, dnsTypeDHCID, name.dhcid),
+			rrsetNotExists(name.fqdn, dnsTypeA),
+			rrsetNotExists(name.fqdn, dnsTypeAAAA),
+		},
+		updates: []dnsRR{deleteRRset(name.fqdn, dnsTypeDHCID)},
+	})
+	if err != nil {
+		return err
+	}
+	// Prerequisites failing means the name's still in use
+	if rcode != dnsNoError && rcode != dnsYXRRSet && rcode != dnsNXRRSet {
+		return errors.New(dnsRcodeName(rcode))
+	}
+	return nil
+}
+
+// Send an update over UDP, trying twice
+func (u *DdnsUpdater) send(update *dnsUpdate) (byte, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var rcode byte
+		if rcode, err = u.sendOnce(update); err == nil {
+			return rcode, nil
+		}
+	}
+	return 0, err
+}
+
+func (u *DdnsUpdater) sendOnce(update *dnsUpdate) (byte, error) {
+	conn, err := net.DialTimeout("udp", u.server, ddnsTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ddnsTimeout))
+
+	id := uint16(rand.Intn(0x10000))
+	message := update.Bytes(id)
+	key := u.keys[strings.ToLower(update.zone)]
+	var mac []byte
+	if key != nil {
+		message, mac = key.sign(message, nil, time.Now())
+	}
+	if _, err := conn.Write(message); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		// Ignore anything but the response to this update
+		if n < 12 || binary.BigEndian.Uint16(buf) != id || buf[2]&0x80 == 0 {
+			continue
+		}
+		if key != nil {
+			if err := key.verify(buf[:n], mac, time.Now()); err != nil {
+				return 0, err
+			}
+		}
+		return buf[3] & 0x0f, nil
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestDhcid(t *testing.T) {
+	// Examples from RFC 4701
+	duid, _ := dhcp4.ParseDUID("00:01:00:06:41:2d:f1:66:01:02:03:04:05:06")
+	require.Equal(t, "AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=",
+		base64.StdEncoding.EncodeToString(leaseDhcid(&pool.Lease{Duid: duid.String()}, "chi6.example.com")))
+	lease := &pool.Lease{Mac: dhcp4.MacAddress{1, 2, 3, 4, 5, 6}.Hardware()}
+	require.Equal(t, "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY=",
+		base64.StdEncoding.EncodeToString(leaseDhcid(lease, "client.example.com")))
+	require.Equal(t, "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No=",
+		base64.StdEncoding.EncodeToString(dhcid(DHCID_CLIENT_ID, []byte{1, 7, 8, 9, 10, 11, 12}, "chi.example.com")))
+
+	// Clients which sent a client identifier are known by it
+	lease = &pool.Lease{Mac: dhcp4.MacAddress{1, 2, 3, 4, 5, 6}.Hardware(), ClientId: []byte{1, 7, 8, 9, 10, 11, 12}}
+	require.Equal(t, "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No=",
+		base64.StdEncoding.EncodeToString(leaseDhcid(lease, "chi.example.com")))
+}
+
+func TestDhcidByClientId(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	var created []pool.Lease
+	p.AddObserver(func(event pool.LeaseEvent) {
+		if event.Kind == pool.LEASE_CREATED {
+			created = append(created, event.Lease)
+		}
+	})
+
+	// The client identifier is on the lease by the time it's committed
+	mac := dhcp4.MacAddress{1, 2, 3, 4, 5, 6}.Hardware()
+	clientId := []byte{1, 7, 8, 9, 10, 11, 12}
+	discover := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	discover.Options.Set(dhcp4.OPTION_CLIENT_ID, clientId)
+	offer := NewRequestHandler(discover, &RequestContext{Pool: p}).Handle()
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Options.Set(dhcp4.OPTION_CLIENT_ID, clientId)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+	ack := NewRequestHandler(request, &RequestContext{Pool: p}).Handle()
+	require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	require.Len(t, created, 1)
+	require.Equal(t, dhcid(DHCID_CLIENT_ID, clientId, "chi.example.com"), leaseDhcid(&created[0], "chi.example.com"))
+}
+
+// A zone applying updates as RFC 2136 says, for the prerequisites and
+// changes we send
+type testZone map[string]map[uint16][][]byte
+
+func (z testZone) has(name string, rtype uint16, data []byte) bool {
+	for _, rr := range z[name][rtype] {
+		if string(rr) == string(data) {
+			return true
+		}
+	}
+	return false
+}
+
+func (z testZone) exchange(update *dnsUpdate) (byte, error) {
+	for _, rr := range update.prereqs {
+		switch {
+		case rr.class == dnsClassNONE && rr.rtype == dnsTypeANY:
+			if len(z[rr.name]) != 0 {
+				return dnsYXDomain, nil
+			}
+		case rr.class == dnsClassNONE:
+			if len(z[rr.name][rr.rtype]) != 0 {
+				return dnsYXRRSet, nil
+			}
+		case !z.has(rr.name, rr.rtype, rr.data):
+			return dnsNXRRSet, nil
+		}
+	}
+	for _, rr := range update.updates {
+		if z[rr.name] == nil {
+			z[rr.name] = map[uint16][][]byte{}
+		}
+		switch rr.class {
+		case dnsClassIN:
+			if !z.has(rr.name, rr.rtype, rr.data) {
+				z[rr.name][rr.rtype] = append(z[rr.name][rr.rtype], rr.data)
+			}
+		case dnsClassANY:
+			delete(z[rr.name], rr.rtype)
+		case dnsClassNONE:
+			var kept [][]byte
+			for _, data := range z[rr.name][rr.rtype] {
+				if string(data) != string(rr.data) {
+					kept = append(kept, data)
+				}
+			}
+			z[rr.name][rr.rtype] = kept
+			if len(kept) == 0 {
+				delete(z[rr.name], rr.rtype)
+			}
+		}
+		if len(z[rr.name]) == 0 {
+			delete(z, rr.name)
+		}
+	}
+	return dnsNoError, nil
+}
+
+func TestDdnsConflicts(t *testing.T) {
+	p := newTestPool()
+	p.Domain = "example.com"
+
+	u, err := NewDdnsUpdater(&DdnsConf{Server: "127.0.0.1:53"})
+	require.Nil(t, err)
+	zone := testZone{}
+	exchanges := 0
+	u.exchange = func(update *dnsUpdate) (byte, error) {
+		exchanges++
+		require.Equal(t, "example.com", update.zone)
+		return zone.exchange(update)
+	}
+	observe := func(kind string, lease pool.Lease) {
+		u.Observe(pool.LeaseEvent{Kind: kind, Pool: p, Lease: lease})
+		select {
+		case job := <-u.queue:
+			u.handle(job)
+		default:
+		}
+	}
+	aRecords := func() [][]byte {
+		return zone["laptop.example.com"][dnsTypeA]
+	}
+
+	first := pool.Lease{Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), Hostname: "Laptop", IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10"))}
+	second := pool.Lease{Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), Hostname: "laptop", IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.11"))}
+
+	observe(pool.LEASE_CREATED, first)
+	require.Equal(t, [][]byte{{10, 0, 0, 10}}, aRecords())
+	require.True(t, zone.has("laptop.example.com", dnsTypeDHCID, leaseDhcid(&first, "laptop.example.com")))
+
+	// Another client wanting the same name doesn't get it
+	observe(pool.LEASE_CREATED, second)
+	require.Equal(t, [][]byte{{10, 0, 0, 10}}, aRecords())
+
+	// Renewals needn't be sent again
+	exchanges = 0
+	observe(pool.LEASE_RENEWED, first)
+	require.Equal(t, 0, exchanges)
+
+	// The name's given up once the lease ends, and the other client can
+	// have it
+	observe(pool.LEASE_RELEASED, first)
+	require.Empty(t, zone)
+	observe(pool.LEASE_RENEWED, second)
+	require.Equal(t, [][]byte{{10, 0, 0, 11}}, aRecords())
+
+	// If the first client's records were left behind, say by a restart, it
+	// takes its own name back
+	zone = testZone{}
+	u.names = map[dhcp4.HardwareAddr]ddnsName{}
+	zone.exchange(&dnsUpdate{updates: []dnsRR{
+		addRR("laptop.example.com", dnsTypeA, 300, []byte{10, 0, 0, 9}),
+		addRR("laptop.example.com", dnsTypeDHCID, 300, leaseDhcid(&first, "laptop.example.com")),
+	}})
+	observe(pool.LEASE_CREATED, first)
+	require.Equal(t, [][]byte{{10, 0, 0, 10}}, aRecords())
+
+	// Hostnames that aren't a DNS label, and pools without a domain, are
+	// left out
+	exchanges = 0
+	observe(pool.LEASE_CREATED, pool.Lease{Mac: second.Mac, Hostname: "bad name", IP: second.IP})
+	p.Domain = ""
+	observe(pool.LEASE_CREATED, second)
+	require.Equal(t, 0, exchanges)
+
+	_, err = NewDdnsUpdater(&DdnsConf{Server: "10.0.0.1"})
+	require.NotNil(t, err)
+}
+
+func TestDdnsSend(t *testing.T) {
+	update := &dnsUpdate{
+		zone:    "example.com",
+		prereqs: []dnsRR{nameNotInUse("a.example.com")},
+		updates: []dnsRR{addRR("a.example.com", dnsTypeA, 300, []byte{10, 0, 0, 1})},
+	}
+	require.Equal(t, []byte{
+		0x12, 0x34, 0x28, 0, 0, 1, 0, 1, 0, 1, 0, 0,
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, dnsTypeSOA, 0, dnsClassIN,
+		1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, dnsTypeANY, 0, dnsClassNONE, 0, 0, 0, 0, 0, 0,
+		1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, dnsTypeA, 0, dnsClassIN, 0, 0, 1, 44, 0, 4, 10, 0, 0, 1,
+	}, update.Bytes(0x1234))
+
+	// A server refusing everything
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+			response := binary.BigEndian.AppendUint16(nil, binary.BigEndian.Uint16(buf))
+			response = append(response, 0xa8, 5, 0, 0, 0, 0, 0, 0, 0, 0)
+			server.WriteToUDP(response, addr)
+		}
+	}()
+
+	u, err := NewDdnsUpdater(&DdnsConf{Server: server.LocalAddr().String()})
+	require.Nil(t, err)
+	start := time.Now()
+	rcode, err := u.send(update)
+	require.Nil(t, err)
+	require.Equal(t, byte(5), rcode)
+	require.Less(t, time.Since(start), ddnsTimeout)
+}
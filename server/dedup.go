@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Answering retransmissions. Clients resend a DISCOVER or REQUEST with the
+// same transaction ID when our reply is slow or lost, and running each copy
+// through allocation again risks offering two IPs or logging and announcing
+// the same ACK twice. Instead, for a short while, we send back a copy of
+// the reply we gave the first time.
+//
+
+// Forget cached replies this often, at most
+const dedupSweepInterval = time.Second
+
+type dedupKey struct {
+	xid uint32
+	hw  dhcp4.HardwareAddr
+	op  byte
+}
+
+type dedupEntry struct {
+	response *dhcp4.DHCPMessage
+	expires  time.Time
+}
+
+type dedupCache struct {
+	ttl time.Duration
+
+	m         sync.Mutex
+	entries   map[dedupKey]*dedupEntry
+	lastSweep time.Time
+}
+
+func newDedupCache(ttl time.Duration) *dedupCache {
+	return &dedupCache{ttl: ttl, entries: map[dedupKey]*dedupEntry{}}
+}
+
+// Copy of the reply to an earlier copy of this request, if still cached
+func (c *dedupCache) get(key dedupKey, now time.Time) (*dhcp4.DHCPMessage, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry.response.Clone(), true
+}
+
+func (c *dedupCache) put(key dedupKey, response *dhcp4.DHCPMessage, now time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if now.Sub(c.lastSweep) >= dedupSweepInterval {
+		c.sweep(now)
+	}
+	if entry, ok := c.entries[key]; ok {
+		entry.response.Release()
+	}
+	c.entries[key] = &dedupEntry{response.Clone(), now.Add(c.ttl)}
+}
+
+func (c *dedupCache) sweep(now time.Time) {
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			entry.response.Release()
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Drop the replies cached for a client, such as once its lease is revoked
+func (c *dedupCache) forget(hw dhcp4.HardwareAddr) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for key, entry := range c.entries {
+		if key.hw == hw {
+			entry.response.Release()
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Answer DISCOVERs and REQUESTs repeating the transaction ID, hardware
+// address and message type of one answered within ttl with the same reply,
+// without calling the next handler
+func DedupMiddleware(ttl time.Duration) Middleware {
+	return newDedupCache(ttl).Middleware
+}
+
+func (c *dedupCache) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		op := request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+		if op != dhcp4.DHCPDISCOVER && op != dhcp4.DHCPREQUEST {
+			return next.ServeDHCP(ctx, request)
+		}
+
+		key := dedupKey{request.Header.Identifier, request.Header.Hardware(), op}
+		if response, ok := c.get(key, time.Now()); ok {
+			ctx.Tracef("Retransmission of xid %08x, sending the same reply again", key.xid)
+			return response
+		}
+		response := next.ServeDHCP(ctx, request)
+		if response != nil {
+			c.put(key, response, time.Now())
+		}
+		return response
+	})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestDedupMiddleware(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	calls := 0
+	handler := Chain(HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		calls++
+		return DefaultHandler.ServeDHCP(ctx, request)
+	}), DedupMiddleware(time.Minute))
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	serve := func(op byte, xid uint32) *dhcp4.DHCPMessage {
+		message := newTestMessage(op, mac)
+		message.Header.Identifier = xid
+		return handler.ServeDHCP(&RequestContext{Pool: p}, message)
+	}
+
+	offer := serve(dhcp4.DHCPDISCOVER, 1)
+	require.NotNil(t, offer)
+	require.Equal(t, 1, calls)
+
+	// The same reply, as a copy of its own
+	again := serve(dhcp4.DHCPDISCOVER, 1)
+	require.Equal(t, 1, calls)
+	require.Equal(t, offer.Header, again.Header)
+	require.Equal(t, offer.Options.Codes(), again.Options.Codes())
+	again.Release()
+	leaseTime, ok := offer.Options.GetUint32(dhcp4.OPTION_LEASE_TIME)
+	require.True(t, ok)
+	require.NotZero(t, leaseTime)
+
+	// A new transaction, or the next message of this one, is handled
+	serve(dhcp4.DHCPDISCOVER, 2)
+	require.Equal(t, 2, calls)
+	serve(dhcp4.DHCPREQUEST, 1)
+	require.Equal(t, 3, calls)
+
+	// Until the reply expires
+	cache := newDedupCache(time.Second)
+	key := dedupKey{1, mac, dhcp4.DHCPDISCOVER}
+	now := time.Now()
+	cache.put(key, offer, now)
+	_, ok = cache.get(key, now.Add(time.Second))
+	require.True(t, ok)
+	_, ok = cache.get(key, now.Add(2*time.Second))
+	require.False(t, ok)
+	cache.put(dedupKey{2, mac, dhcp4.DHCPDISCOVER}, offer, now.Add(3*time.Second))
+	require.Len(t, cache.entries, 1)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+//
+// Reservations from dnsmasq style dhcp-host entries, either a dhcp-hostsfile
+// or lines from dnsmasq.conf itself, eg:
+//
+//   dhcp-host=00:1c:42:b4:6e:1d,172.17.0.5,ubuntu2,infinite
+//
+// Only entries with a mac address and IP are used. Other fields such as
+// lease times, client ids and tags are ignored.
+//
+
+func ParseDnsmasqHosts(reader io.Reader) ([]HostConf, error) {
+	var hosts []HostConf
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// dnsmasq.conf lines carry a prefix; hostsfile lines don't. Skip any
+		// other dnsmasq.conf directives
+		if idx := strings.Index(line, "="); idx != -1 {
+			if strings.TrimSpace(line[:idx]) != "dhcp-host" {
+				continue
+			}
+			line = line[idx+1:]
+		}
+
+		host := HostConf{}
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			switch {
+			case field == "":
+			case strings.Contains(field, ":") && !strings.HasPrefix(field, "id:") && !strings.HasPrefix(field, "set:") && !strings.HasPrefix(field, "tag:"):
+				if _, err := net.ParseMAC(field); err != nil {
+					return nil, fmt.Errorf("Line %v: invalid mac address %v", lineNo, field)
+				}
+				if host.Mac != "" {
+					log.Printf("Line %v: only the first mac address is used", lineNo)
+					continue
+				}
+				host.Mac = field
+			case net.ParseIP(field) != nil:
+				host.IP = field
+			case isDnsmasqLeaseTime(field), strings.Contains(field, ":"), field == "ignore":
+			default:
+				host.Hostname = field
+			}
+		}
+
+		if host.Mac == "" || host.IP == "" {
+			log.Printf("Line %v: skipping dhcp-host without both a mac address and IP", lineNo)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, scanner.Err()
+}
+
+// eg 45m, 12h, 3600, infinite
+func isDnsmasqLeaseTime(field string) bool {
+	if field == "infinite" {
+		return true
+	}
+	field = strings.TrimRight(field, "smhdw")
+	if field == "" {
+		return false
+	}
+	for _, c := range field {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func LoadDnsmasqHosts(path string) ([]HostConf, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hosts, err := ParseDnsmasqHosts(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed parsing %v: %v", path, err)
+	}
+	return hosts, nil
+}
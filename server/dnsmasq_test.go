@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"strings"
+	"testing"
+)
+
+func TestParseDnsmasqHosts(t *testing.T) {
+	content := `# Reservations
+dhcp-host=00:1c:42:b4:6e:1d,172.17.0.5,ubuntu2
+00:1c:42:b4:6e:1e,printer,172.17.0.6,infinite
+dhcp-host=00:1c:42:b4:6e:1f,id:01:02:03,172.17.0.7,12h
+dhcp-range=172.17.0.100,172.17.0.200,12h
+dhcp-host=laptop,172.17.0.8
+`
+	hosts, err := ParseDnsmasqHosts(strings.NewReader(content))
+	require.Nil(t, err)
+	require.Equal(t, []HostConf{
+		{Mac: "00:1c:42:b4:6e:1d", IP: "172.17.0.5", Hostname: "ubuntu2"},
+		{Mac: "00:1c:42:b4:6e:1e", IP: "172.17.0.6", Hostname: "printer"},
+		{Mac: "00:1c:42:b4:6e:1f", IP: "172.17.0.7"},
+	}, hosts)
+
+	_, err = ParseDnsmasqHosts(strings.NewReader("zz:1c:42:b4:6e:1d,172.17.0.5\n"))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,4 @@
+// Package server is the DHCP server: configuration, receiving requests and
+// answering them from pools, and everything around that such as failover,
+// hooks and the admin API. cmd/mygodhcpd is a thin wrapper around it.
+package server
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Dual-stack clients sending RFC 4361 client identifiers are known by the
+// same DUID as over DHCPv6. It's kept on their leases, and hosts can be
+// reserved by it, so a host has one reservation whichever interface, and so
+// IAID, it asks from, and can be matched up with its DHCPv6 leases. Hosts
+// reserved by DUID are given a hardware address of their own type made from
+// it, which their leases are kept by.
+//
+
+// Hardware type for DUIDs, hashed if they're longer than a hardware address
+// can be
+const HTYPE_DUID byte = 252
+
+func duidAddr(duid dhcp4.DUID) dhcp4.HardwareAddr {
+	return idAddr(HTYPE_DUID, duid)
+}
+
+// The DUID in the client identifier, if it's an RFC 4361 one
+func clientDuid(options *dhcp4.Options) (dhcp4.DUID, bool) {
+	option, ok := options.Get(dhcp4.OPTION_CLIENT_ID)
+	if !ok {
+		return nil, false
+	}
+	id, ok := dhcp4.ParseNodeClientId(option.Data)
+	return id.DUID, ok
+}
+
+// The hardware address a message's client is reserved under in p by its
+// DUID, if it is
+func duidHostKey(p *pool.Pool, message *dhcp4.DHCPMessage) (dhcp4.HardwareAddr, bool) {
+	duid, ok := clientDuid(message.Options)
+	if !ok {
+		return dhcp4.HardwareAddr{}, false
+	}
+	key := duidAddr(duid)
+	if _, ok := p.GetReservedHost(key); ok {
+		return key, true
+	}
+	return dhcp4.HardwareAddr{}, false
+}
+
+// The client's DUID as DHCPv6 servers write them, if it sent one
+func duidString(options *dhcp4.Options) string {
+	if duid, ok := clientDuid(options); ok {
+		return duid.String()
+	}
+	return ""
+}
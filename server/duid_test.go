@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestDuid(t *testing.T) {
+	p := newTestPool()
+	p.Name = "office"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	app := newTestApp(t, p)
+
+	duid, err := dhcp4.ParseDUID("00:01:00:01:28:d6:d3:14:00:1c:42:b4:6e:1d")
+	require.Nil(t, err)
+	lease := func(mac dhcp4.HardwareAddr, iaid uint32) *dhcp4.DHCPMessage {
+		clientId := dhcp4.NodeClientId{IAID: iaid, DUID: duid}.Bytes()
+		discover := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		discover.Options.Set(dhcp4.OPTION_CLIENT_ID, clientId)
+		offer := NewRequestHandler(discover, &RequestContext{Pool: p}).Handle()
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Options.Set(dhcp4.OPTION_CLIENT_ID, clientId)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+		request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+		ack := NewRequestHandler(request, &RequestContext{Pool: p}).Handle()
+		require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+		record, ok := NewResponseEventRecord(&RequestContext{Pool: p}, request, ack)
+		require.True(t, ok)
+		require.Equal(t, duid.String(), record.Duid)
+		return ack
+	}
+
+	// Kept on the lease, and found by it
+	wired := dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware()
+	ack := lease(wired, 1)
+	held, ok := p.GetLeaseByMac(wired)
+	require.True(t, ok)
+	require.Equal(t, duid.String(), held.Duid)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+	rec := get("/duid?duid=" + duid.String())
+	require.Equal(t, http.StatusOK, rec.Code)
+	var leases []adminDuidLease
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &leases))
+	require.Len(t, leases, 1)
+	require.Equal(t, "office", leases[0].Pool)
+	require.Equal(t, wired.String(), leases[0].Mac)
+	require.Equal(t, ack.Header.YourAddr.String(), leases[0].IP)
+	require.Equal(t, http.StatusBadRequest, get("/duid?duid=nope").Code)
+
+	// Reserved by DUID, the host gets its IP from whichever interface it
+	// asks from
+	require.Nil(t, app.SetReservation("office", HostConf{Duid: duid.String(), IP: "10.0.0.50"}))
+	_, ok = p.GetLeaseByMac(wired)
+	require.True(t, ok)
+	ack = lease(wired, 1)
+	require.Equal(t, "10.0.0.50", ack.Header.YourAddr.String())
+	wireless := dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1e}.Hardware()
+	ack = lease(wireless, 2)
+	require.Equal(t, "10.0.0.50", ack.Header.YourAddr.String())
+	_, ok = p.GetLeaseByMac(duidAddr(duid))
+	require.True(t, ok)
+
+	_, err = (&HostConf{Duid: duid.String(), Mac: wired.String(), IP: "10.0.0.50"}).Key()
+	require.NotNil(t, err)
+	_, err = (&HostConf{Duid: "00:01", IP: "10.0.0.50"}).Key()
+	require.NotNil(t, err)
+
+	// Ordinary client identifiers still fit in a hardware address, short of
+	// the types we use for our own keys
+	key, _ := clientIdAddr([]byte{HTYPE_DUID, 1, 2, 3})
+	require.Equal(t, HTYPE_CLIENT_ID, key.Type())
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Making sure no IP is handed out by two pools. Pools whose ranges overlap,
+// or with a reservation another could hand out, are refused when they're
+// configured. As a last line of defence against leases left over from an
+// older configuration, an IP about to be offered which another pool has
+// leased is abandoned, and the next one tried.
+//
+
+// Abandon IPs offered to clients which another pool has a claim on
+func DuplicateGuardMiddleware(claimed ConflictCheck) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+			if request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) != dhcp4.DHCPDISCOVER {
+				return next.ServeDHCP(ctx, request)
+			}
+			return offerUnclaimed(next, ctx, request, leaseKey(ctx.Pool, request), claimed)
+		})
+	}
+}
+
+// Whether a pool other than the one serving the client could hand out an
+// IP, or has it leased
+func (a *App) claimedElsewhere(ctx *RequestContext, request *dhcp4.DHCPMessage, ip dhcp4.FixedV4) bool {
+	for _, p := range a.pools() {
+		if p == ctx.Pool {
+			continue
+		}
+		if p.Contains(ip) {
+			return true
+		}
+		if lease, ok := p.GetLeaseByIp(ip); ok && !lease.Expired() {
+			return true
+		}
+	}
+	return false
+}
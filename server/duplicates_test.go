@@ -0,0 +1,45 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestDuplicateGuard(t *testing.T) {
+	app := NewApp()
+	first := newTestPool()
+	first.Name = "first"
+	first.Network = net.ParseIP("10.0.0.0")
+	first.LeaseTime = time.Hour
+	require.Nil(t, app.insertPool(first))
+
+	// Overlapping pools are refused
+	second := newTestPool()
+	second.Name = "second"
+	second.Network = net.ParseIP("10.0.1.0")
+	second.Start = net.ParseIP("10.0.0.20")
+	require.NotNil(t, app.insertPool(second))
+	second.Start = net.ParseIP("10.0.1.10")
+	second.End = net.ParseIP("10.0.1.20")
+	require.Nil(t, app.insertPool(second))
+
+	// A lease left over from an older configuration isn't handed out twice
+	stale := &pool.Lease{
+		IP:  dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")),
+		Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(),
+	}
+	stale.BumpExpiry(time.Hour)
+	require.Nil(t, second.ImportLease(stale))
+
+	handler := Chain(DefaultHandler, DuplicateGuardMiddleware(app.claimedElsewhere))
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	offer := handler.ServeDHCP(&RequestContext{Pool: first}, newTestMessage(dhcp4.DHCPDISCOVER, mac))
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.11")), offer.Header.YourAddr)
+	require.Equal(t, 1, first.Utilization().Abandoned)
+}
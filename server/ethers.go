@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Reservations from an ethers file, as in /etc/ethers, of a mac address and
+// an IP or hostname per line, eg:
+//
+//   00:1c:42:b4:6e:1d 172.17.0.5
+//   00:1c:42:b4:6e:1e printer
+//
+// Hostnames are resolved to an IPv4 address through the system resolver,
+// which includes /etc/hosts, and are kept as the reservation's hostname.
+// Entries which aren't on the pool's network are left out, so one file can
+// serve several pools. The file is checked for changes every few seconds
+// and the pool's reservations replaced when it does, unless they've been
+// changed at runtime through the admin API.
+//
+
+const ETHERS_INTERVAL = 5 * time.Second
+
+// So tests don't need a resolver
+var lookupEthersHost = net.LookupIP
+
+func ParseEthers(reader io.Reader) ([]HostConf, error) {
+	var hosts []HostConf
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Line %v: expected a mac address and an IP or hostname", lineNo)
+		}
+
+		if _, err := dhcp4.ParseHardwareAddr(fields[0]); err != nil {
+			return nil, fmt.Errorf("Line %v: invalid mac address %v", lineNo, fields[0])
+		}
+		host := HostConf{Mac: fields[0]}
+		if ip := net.ParseIP(fields[1]); ip != nil {
+			if ip.To4() == nil {
+				log.Printf("Line %v: skipping %v, which isn't an IPv4 address", lineNo, fields[1])
+				continue
+			}
+			host.IP = fields[1]
+		} else {
+			ip, err := ethersHostIp(fields[1])
+			if err != nil {
+				log.Printf("Line %v: skipping %v: %v", lineNo, fields[1], err)
+				continue
+			}
+			host.IP = ip.String()
+			host.Hostname = fields[1]
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, scanner.Err()
+}
+
+func ethersHostIp(name string) (net.IP, error) {
+	ips, err := lookupEthersHost(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+	return nil, fmt.Errorf("%v has no IPv4 address", name)
+}
+
+func LoadEthers(path string) ([]HostConf, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hosts, err := ParseEthers(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed parsing %v: %v", path, err)
+	}
+	return hosts, nil
+}
+
+// Entries of an ethers file which are on the pool's network
+func (pc *PoolConf) ethersHosts() ([]HostConf, error) {
+	hosts, err := LoadEthers(pc.Ethers)
+	if err != nil {
+		return nil, err
+	}
+	network, mask := net.ParseIP(pc.Network).To4(), net.ParseIP(pc.Netmask).To4()
+	if network == nil || mask == nil {
+		return hosts, nil
+	}
+	ipnet := net.IPNet{IP: network.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+
+	var onNetwork []HostConf
+	for _, hc := range hosts {
+		if ipnet.Contains(net.ParseIP(hc.IP)) {
+			onNetwork = append(onNetwork, hc)
+		}
+	}
+	return onNetwork, nil
+}
+
+type ethersSource struct {
+	pool    *pool.Pool
+	conf    PoolConf
+	content []byte
+}
+
+type EthersWatcher struct {
+	reservations *Reservations
+	sources      []*ethersSource
+}
+
+func NewEthersWatcher(reservations *Reservations) *EthersWatcher {
+	return &EthersWatcher{reservations: reservations}
+}
+
+// Watch the pool's ethers file, as it was when the pool was loaded
+func (w *EthersWatcher) Add(p *pool.Pool, pc PoolConf) {
+	content, _ := ioutil.ReadFile(pc.Ethers)
+	w.sources = append(w.sources, &ethersSource{pool: p, conf: pc, content: content})
+}
+
+func (w *EthersWatcher) Run() {
+	for range time.Tick(ETHERS_INTERVAL) {
+		w.Check()
+	}
+}
+
+// Reload the reservations of pools whose ethers file changed
+func (w *EthersWatcher) Check() {
+	for _, source := range w.sources {
+		content, err := ioutil.ReadFile(source.conf.Ethers)
+		if err != nil {
+			log.Printf("Failed reading %v: %v", source.conf.Ethers, err)
+			continue
+		}
+		if bytes.Equal(content, source.content) {
+			continue
+		}
+		source.content = content
+
+		hosts, err := source.conf.Hosts()
+		if err != nil {
+			log.Printf("Pool %v: %v", source.pool.Name, err)
+			continue
+		}
+		reloaded, err := w.reservations.Reload(source.pool, hosts)
+		if err != nil {
+			log.Printf("Pool %v: keeping its reservations as %v: %v", source.pool.Name, source.conf.Ethers, err)
+			continue
+		}
+		if !reloaded {
+			log.Printf("Pool %v: ignoring changes to %v as its reservations were changed at runtime", source.pool.Name, source.conf.Ethers)
+			continue
+		}
+		log.Printf("Pool %v: reloaded %v reservations after %v changed", source.pool.Name, len(hosts), source.conf.Ethers)
+	}
+}
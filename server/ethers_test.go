@@ -0,0 +1,104 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestParseEthers(t *testing.T) {
+	defer func(lookup func(string) ([]net.IP, error)) { lookupEthersHost = lookup }(lookupEthersHost)
+	lookupEthersHost = func(name string) ([]net.IP, error) {
+		if name == "printer" {
+			return []net.IP{net.ParseIP("fe80::1"), net.ParseIP("10.0.0.6")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	content := `# Reservations
+00:1c:42:b4:6e:1d 10.0.0.5
+00:1c:42:b4:6e:1e	printer  # by name
+00:1c:42:b4:6e:1f unknown
+00:1c:42:b4:6e:20 fe80::2
+
+`
+	hosts, err := ParseEthers(strings.NewReader(content))
+	require.Nil(t, err)
+	require.Equal(t, []HostConf{
+		{Mac: "00:1c:42:b4:6e:1d", IP: "10.0.0.5"},
+		{Mac: "00:1c:42:b4:6e:1e", IP: "10.0.0.6", Hostname: "printer"},
+	}, hosts)
+
+	_, err = ParseEthers(strings.NewReader("zz:1c:42:b4:6e:1d 10.0.0.5\n"))
+	require.NotNil(t, err)
+	_, err = ParseEthers(strings.NewReader("00:1c:42:b4:6e:1d\n"))
+	require.NotNil(t, err)
+}
+
+func TestEthersWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ethers")
+	require.Nil(t, ioutil.WriteFile(path, []byte("0:0:0:0:0:1 10.0.0.15\n0:0:0:0:0:9 192.168.1.5\n"), 0644))
+	pc := PoolConf{
+		Name:    "test",
+		Network: "10.0.0.0",
+		Netmask: "255.255.255.0",
+		Start:   "10.0.0.10",
+		End:     "10.0.0.20",
+		MyIp:    "10.0.0.254",
+		Ethers:  path,
+	}
+
+	// Entries off the pool's network are left out
+	p, err := pc.ToPool()
+	require.Nil(t, err)
+	p.LeaseTime = time.Hour
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	host, ok := p.GetReservedHost(mac)
+	require.True(t, ok)
+	require.Equal(t, "10.0.0.15", host.IP.String())
+	_, ok = p.GetReservedHost(dhcp4.MacAddress{0, 0, 0, 0, 0, 9}.Hardware())
+	require.False(t, ok)
+
+	dir := t.TempDir()
+	reservations := NewReservations(dir)
+	hosts, err := pc.Hosts()
+	require.Nil(t, err)
+	require.Nil(t, reservations.Load(p, hosts))
+	watcher := NewEthersWatcher(reservations)
+	watcher.Add(p, pc)
+	_, err = p.GetNextLease(mac, "")
+	require.Nil(t, err)
+
+	// Nothing happens until the file changes
+	watcher.Check()
+	_, ok = p.GetLeaseByMac(mac)
+	require.True(t, ok)
+
+	// A client whose IP changed has its lease released
+	require.Nil(t, ioutil.WriteFile(path, []byte("0:0:0:0:0:1 10.0.0.16\n0:0:0:0:0:2 10.0.0.17\n"), 0644))
+	watcher.Check()
+	host, ok = p.GetReservedHost(mac)
+	require.True(t, ok)
+	require.Equal(t, "10.0.0.16", host.IP.String())
+	_, ok = p.GetReservedHost(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	require.True(t, ok)
+	_, ok = p.GetLeaseByMac(mac)
+	require.False(t, ok)
+	require.Len(t, reservations.List("test"), 2)
+
+	// Reservations changed at runtime take precedence
+	require.Nil(t, ioutil.WriteFile(reservations.path("test"), []byte("[]\n"), 0644))
+	require.Nil(t, ioutil.WriteFile(path, []byte("0:0:0:0:0:1 10.0.0.18\n"), 0644))
+	watcher.Check()
+	host, ok = p.GetReservedHost(mac)
+	require.True(t, ok)
+	require.Equal(t, "10.0.0.16", host.IP.String())
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Stream lease events to a message broker, so home automation and network
+// monitoring can react to devices coming and going. Each event is published
+// as json to a topic named after the pool and event, e.g.
+// golang-dhcpd/office/acked over MQTT, or golang-dhcpd.office.acked over
+// NATS. Delivery is best effort: we reconnect and retry once if the broker
+// goes away, then drop the event.
+//
+
+const (
+	EVENTBUS_NATS = "nats"
+	EVENTBUS_MQTT = "mqtt"
+)
+
+const eventBusTimeout = 5 * time.Second
+
+// Connection to a broker
+type BrokerConn interface {
+	Publish(topic string, payload []byte) error
+	Close() error
+}
+
+type EventBus struct {
+	kind      string
+	address   string
+	username  string
+	password  string
+	prefix    string
+	separator string
+	events    map[string]bool
+	queue     chan EventRecord
+
+	conn BrokerConn
+}
+
+func NewEventBus(conf *EventBusConf) (*EventBus, error) {
+	b := &EventBus{
+		kind:     conf.Type,
+		address:  conf.Address,
+		username: conf.Username,
+		password: conf.Password,
+		prefix:   conf.Topic,
+		queue:    make(chan EventRecord, 1024),
+	}
+
+	switch b.kind {
+	case EVENTBUS_NATS:
+		b.separator = "."
+	case EVENTBUS_MQTT:
+		b.separator = "/"
+	default:
+		return nil, fmt.Errorf("Unknown event bus type '%v'. Supported are nats and mqtt", b.kind)
+	}
+
+	if b.address == "" {
+		return nil, errors.New("Event bus needs an address")
+	}
+	if b.prefix == "" {
+		b.prefix = "golang-dhcpd"
+	}
+
+	if len(conf.Events) != 0 {
+		b.events = map[string]bool{}
+		for _, event := range conf.Events {
+			if !validEventName(event) {
+				return nil, fmt.Errorf("Unknown event '%v' for event bus", event)
+			}
+			b.events[event] = true
+		}
+	}
+
+	return b, nil
+}
+
+func (b *EventBus) enqueue(record EventRecord) {
+	if b.events != nil && !b.events[record.Event] {
+		return
+	}
+	select {
+	case b.queue <- record:
+	default:
+		log.Printf("Event bus queue for %v full; dropping %v event for %v", b.address, record.Event, record.IP)
+	}
+}
+
+// Lease observer
+func (b *EventBus) Observe(event pool.LeaseEvent) {
+	b.enqueue(NewLeaseEventRecord(event))
+}
+
+// Request hook, for offers and acks
+func (b *EventBus) Hook(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+	if record, ok := NewResponseEventRecord(ctx, request, response); ok {
+		b.enqueue(record)
+	}
+}
+
+func (b *EventBus) Topic(record EventRecord) string {
+	return strings.Join([]string{b.prefix, topicLevel(record.Pool), record.Event}, b.separator)
+}
+
+// A pool name as a single topic level, with what NATS and MQTT would take
+// as separators or wildcards, or don't allow, replaced
+func topicLevel(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r <= ' ', r == 0x7f, r == '.', r == '/', r == '+', r == '#', r == '*', r == '>':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func (b *EventBus) Run() {
+	for record := range b.queue {
+		payload, err := json.Marshal(record)
+		if err != nil {
+			log.Printf("Failed encoding event: %v", err)
+			continue
+		}
+		topic := b.Topic(record)
+
+		for attempt := 0; attempt < 2; attempt++ {
+			if err = b.publish(topic, payload); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Failed publishing %v event for %v to %v: %v", record.Event, record.IP, b.address, err)
+		}
+	}
+}
+
+func (b *EventBus) publish(topic string, payload []byte) error {
+	if b.conn == nil {
+		var err error
+		switch b.kind {
+		case EVENTBUS_NATS:
+			b.conn, err = DialNats(b.address, b.username, b.password)
+		case EVENTBUS_MQTT:
+			b.conn, err = DialMqtt(b.address, b.username, b.password)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := b.conn.Publish(topic, payload); err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return err
+	}
+	return nil
+}
+
+//
+// NATS client protocol, just enough to publish
+//
+
+type NatsConn struct {
+	conn net.Conn
+	m    sync.Mutex
+
+	// Set once the server has closed on us or sent an error
+	err error
+}
+
+func DialNats(address, username, password string) (*NatsConn, error) {
+	conn, err := net.DialTimeout("tcp", address, eventBusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(eventBusTimeout))
+	reader := bufio.NewReader(conn)
+
+	// Server greets us with its INFO
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("Expected INFO from NATS server, got %q", line)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "golang-dhcpd",
+	}
+	if username != "" {
+		options["user"] = username
+		options["pass"] = password
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The PONG tells us we were accepted
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if strings.TrimSpace(line) != "PONG" {
+		conn.Close()
+		return nil, fmt.Errorf("NATS server refused connection: %v", strings.TrimSpace(line))
+	}
+	conn.SetDeadline(time.Time{})
+
+	n := &NatsConn{conn: conn}
+	go n.read(reader)
+	return n, nil
+}
+
+// Answer the server's keepalive pings, and notice it going away
+func (n *NatsConn) read(reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			n.fail(err)
+			return
+		}
+		switch {
+		case strings.TrimSpace(line) == "PING":
+			n.m.Lock()
+			n.conn.SetWriteDeadline(time.Now().Add(eventBusTimeout))
+			_, err = io.WriteString(n.conn, "PONG\r\n")
+			n.m.Unlock()
+			if err != nil {
+				n.fail(err)
+				return
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			n.fail(errors.New(strings.TrimSpace(line)))
+		}
+	}
+}
+
+func (n *NatsConn) fail(err error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.err == nil {
+		n.err = err
+	}
+}
+
+func (n *NatsConn) Publish(subject string, payload []byte) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.err != nil {
+		return n.err
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(eventBusTimeout))
+	_, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", subject, len(payload), payload)
+	return err
+}
+
+func (n *NatsConn) Close() error {
+	return n.conn.Close()
+}
+
+//
+// MQTT 3.1.1, publishing at QoS 0
+//
+
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttDisconnect = 0xe0
+)
+
+type MqttConn struct {
+	conn net.Conn
+}
+
+func mqttString(value string) []byte {
+	encoded := make([]byte, 2, 2+len(value))
+	binary.BigEndian.PutUint16(encoded, uint16(len(value)))
+	return append(encoded, value...)
+}
+
+// Fixed header followed by the rest of the packet
+func mqttPacket(kind byte, body []byte) []byte {
+	packet := []byte{kind}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func DialMqtt(address, username, password string) (*MqttConn, error) {
+	conn, err := net.DialTimeout("tcp", address, eventBusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(eventBusTimeout))
+
+	// Clean session, and no keepalive as we only ever write
+	flags := byte(0x02)
+	payload := mqttString(fmt.Sprintf("golang-dhcpd-%d", time.Now().UnixNano()))
+	if username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(username)...)
+		if password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags, 0, 0)
+	body = append(body, payload...)
+
+	if _, err := conn.Write(mqttPacket(mqttConnect, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if connack[0] != mqttConnack {
+		conn.Close()
+		return nil, fmt.Errorf("Expected CONNACK from MQTT broker, got packet type %#x", connack[0])
+	}
+	if connack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker refused connection with code %v", connack[3])
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &MqttConn{conn}, nil
+}
+
+func (m *MqttConn) Publish(topic string, payload []byte) error {
+	m.conn.SetWriteDeadline(time.Now().Add(eventBusTimeout))
+	_, err := m.conn.Write(mqttPacket(mqttPublish, append(mqttString(topic), payload...)))
+	return err
+}
+
+func (m *MqttConn) Close() error {
+	m.conn.Write(mqttPacket(mqttDisconnect, nil))
+	return m.conn.Close()
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/pool"
+)
+
+func TestEventBusTopic(t *testing.T) {
+	_, err := NewEventBus(&EventBusConf{Type: "kafka", Address: "127.0.0.1:9092"})
+	require.NotNil(t, err)
+
+	record := EventRecord{Event: EVENT_ACKED, Pool: "office"}
+
+	bus, err := NewEventBus(&EventBusConf{Type: EVENTBUS_MQTT, Address: "127.0.0.1:1883"})
+	require.Nil(t, err)
+	require.Equal(t, "golang-dhcpd/office/acked", bus.Topic(record))
+
+	bus, err = NewEventBus(&EventBusConf{Type: EVENTBUS_NATS, Address: "127.0.0.1:4222", Topic: "dhcp"})
+	require.Nil(t, err)
+	require.Equal(t, "dhcp.office.acked", bus.Topic(record))
+
+	// Pool names can't add levels or wildcards
+	record.Pool = "floor 2.east/+#*>"
+	require.Equal(t, "dhcp.floor_2_east_____.acked", bus.Topic(record))
+	record.Pool = ""
+	require.Equal(t, "dhcp._.acked", bus.Topic(record))
+}
+
+func TestNatsPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	published := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		connect, _ := reader.ReadString('\n')
+		if !strings.Contains(connect, `"user":"dhcpd"`) {
+			io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+			return
+		}
+		reader.ReadString('\n')
+		io.WriteString(conn, "PONG\r\n")
+
+		// We expect them to answer our ping, before or after publishing
+		io.WriteString(conn, "PING\r\n")
+		var ponged bool
+		var message string
+		for !ponged || message == "" {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "PONG\r\n" {
+				ponged = true
+			} else {
+				payload, _ := reader.ReadString('\n')
+				message = line + payload
+			}
+		}
+		published <- message
+	}()
+
+	bus, err := NewEventBus(&EventBusConf{Type: EVENTBUS_NATS, Address: ln.Addr().String(), Username: "dhcpd", Password: "secret"})
+	require.Nil(t, err)
+	go bus.Run()
+
+	bus.enqueue(EventRecord{Event: pool.LEASE_RELEASED, Pool: "office", IP: "10.0.0.10"})
+
+	select {
+	case message := <-published:
+		lines := strings.Split(message, "\r\n")
+		require.True(t, strings.HasPrefix(lines[0], "PUB golang-dhcpd.office.released "))
+		record := EventRecord{}
+		require.Nil(t, json.Unmarshal([]byte(lines[1]), &record))
+		require.Equal(t, "10.0.0.10", record.IP)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing published")
+	}
+}
+
+func TestMqttPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	readPacket := func(reader *bufio.Reader) (byte, []byte) {
+		kind, _ := reader.ReadByte()
+		length, multiplier := 0, 1
+		for {
+			digit, _ := reader.ReadByte()
+			length += int(digit&0x7f) * multiplier
+			multiplier *= 128
+			if digit&0x80 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		io.ReadFull(reader, body)
+		return kind, body
+	}
+
+	published := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		kind, body := readPacket(reader)
+		if kind != mqttConnect || string(body[2:6]) != "MQTT" || body[7]&0xc0 != 0xc0 {
+			conn.Write([]byte{mqttConnack, 2, 0, 5})
+			return
+		}
+		conn.Write([]byte{mqttConnack, 2, 0, 0})
+
+		kind, body = readPacket(reader)
+		if kind == mqttPublish {
+			published <- body
+		}
+	}()
+
+	conn, err := DialMqtt(ln.Addr().String(), "dhcpd", "secret")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// Long enough to need a multi-byte length
+	payload := []byte(strings.Repeat("x", 200))
+	require.Nil(t, conn.Publish("golang-dhcpd/office/acked", payload))
+
+	select {
+	case body := <-published:
+		require.Equal(t, append(mqttString("golang-dhcpd/office/acked"), payload...), body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing published")
+	}
+}
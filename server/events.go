@@ -0,0 +1,113 @@
+package server
+
+import (
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Lease events as sent to external systems by webhooks and the event bus.
+// As well as the lease changes seen by pool observers, these include every
+// offer and ack we send, and alerts about pools.
+//
+
+// Sent in response to requests, as opposed to lease events
+const (
+	EVENT_OFFERED = "offered"
+	EVENT_ACKED   = "acked"
+)
+
+// Alerts about the state of a pool rather than a single lease
+const (
+	EVENT_STARVATION = "starvation"
+	EVENT_EXHAUSTION = "exhaustion"
+)
+
+// Alerts about another DHCP server on the network
+const EVENT_ROGUE_SERVER = "rogueserver"
+
+type EventRecord struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Pool       string    `json:"pool"`
+	Mac        string    `json:"mac"`
+	IP         string    `json:"ip,omitempty"`
+	Hostname   string    `json:"hostname,omitempty"`
+	MudUrl     string    `json:"mudurl,omitempty"`
+	Expiration time.Time `json:"expiration"`
+
+	// What kind of device the client is, going by what it asked for
+	Fingerprint string `json:"fingerprint,omitempty"`
+	VendorClass string `json:"vendorclass,omitempty"`
+
+	// DUID of a dual-stack client, for matching it up with its DHCPv6 leases
+	Duid string `json:"duid,omitempty"`
+
+	// What an alert is about
+	Detail string `json:"detail,omitempty"`
+}
+
+// Whether this is the name of an event we can send
+func validEventName(name string) bool {
+	switch name {
+	case EVENT_OFFERED, EVENT_ACKED, EVENT_STARVATION, EVENT_EXHAUSTION, EVENT_ROGUE_SERVER, pool.LEASE_CREATED, pool.LEASE_RENEWED, pool.LEASE_RELEASED, pool.LEASE_EXPIRED:
+		return true
+	}
+	return false
+}
+
+func NewLeaseEventRecord(event pool.LeaseEvent) EventRecord {
+	return EventRecord{
+		Event:       event.Kind,
+		Time:        time.Now(),
+		Pool:        event.Pool.Name,
+		Mac:         event.Lease.Mac.String(),
+		IP:          event.Lease.IP.String(),
+		Hostname:    event.Lease.Hostname,
+		MudUrl:      event.Lease.MudUrl,
+		Expiration:  event.Lease.Expiration,
+		Fingerprint: event.Lease.Fingerprint,
+		VendorClass: event.Lease.VendorClass,
+		Duid:        event.Lease.Duid,
+	}
+}
+
+// Record of an offer or ack we've sent, if the response was one
+func NewResponseEventRecord(ctx *RequestContext, request, response *dhcp4.DHCPMessage) (EventRecord, bool) {
+	// Those with no IP, such as telling a client to go IPv6-only, aren't
+	// leases
+	if response == nil || ctx.Pool == nil || response.Header.YourAddr.Empty() {
+		return EventRecord{}, false
+	}
+
+	var event string
+	switch response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) {
+	case dhcp4.DHCPOFFER:
+		event = EVENT_OFFERED
+	case dhcp4.DHCPACK:
+		event = EVENT_ACKED
+	default:
+		return EventRecord{}, false
+	}
+
+	hostname, _ := request.Options.GetString(dhcp4.OPTION_HOST_NAME)
+	vendorClass, _ := request.Options.GetString(dhcp4.OPTION_VENDOR)
+	record := EventRecord{
+		Event:       event,
+		Time:        time.Now(),
+		Pool:        ctx.Pool.Name,
+		Mac:         request.Header.Hardware().String(),
+		IP:          response.Header.YourAddr.String(),
+		Hostname:    hostname,
+		MudUrl:      mudUrl(request.Options),
+		Fingerprint: fingerprint(request.Options),
+		VendorClass: vendorClass,
+		Duid:        duidString(request.Options),
+	}
+	if leaseTime, ok := response.Options.GetUint32(dhcp4.OPTION_LEASE_TIME); ok {
+		record.Expiration = record.Time.Add(time.Duration(leaseTime) * time.Second)
+	}
+	return record, true
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"mygodhcpd/pool"
+)
+
+//
+// Run external commands when leases change, like ISC dhcpd's "on commit",
+// so admins can script firewall or DNS updates. Details of the lease are
+// passed in the environment. Commands for each hook run one at a time, in
+// the order the changes happened.
+//
+
+type ExecHook struct {
+	events  map[string]bool
+	command []string
+	timeout time.Duration
+	queue   chan pool.LeaseEvent
+}
+
+func NewExecHook(conf *ExecHookConf) (*ExecHook, error) {
+	if len(conf.Command) == 0 {
+		return nil, errors.New("Exec hook needs a command")
+	}
+
+	h := &ExecHook{
+		command: conf.Command,
+		timeout: 30 * time.Second,
+		queue:   make(chan pool.LeaseEvent, 1024),
+	}
+	if conf.Timeout != 0 {
+		h.timeout = time.Duration(conf.Timeout) * time.Second
+	}
+
+	if len(conf.Events) != 0 {
+		h.events = map[string]bool{}
+		for _, event := range conf.Events {
+			switch event {
+			case pool.LEASE_CREATED, pool.LEASE_RENEWED, pool.LEASE_RELEASED, pool.LEASE_EXPIRED:
+				h.events[event] = true
+			default:
+				return nil, fmt.Errorf("Unknown lease event '%v' for exec hook", event)
+			}
+		}
+	}
+
+	return h, nil
+}
+
+// Lease observer queueing the command to run
+func (h *ExecHook) Observe(event pool.LeaseEvent) {
+	if h.events != nil && !h.events[event.Kind] {
+		return
+	}
+	select {
+	case h.queue <- event:
+	default:
+		log.Printf("Exec hook queue full; dropping %v event for %v", event.Kind, event.Lease.IP.String())
+	}
+}
+
+func (h *ExecHook) Run() {
+	for event := range h.queue {
+		h.run(event)
+	}
+}
+
+func execHookEnv(event pool.LeaseEvent) []string {
+	return []string{
+		"DHCPD_EVENT=" + event.Kind,
+		"DHCPD_MAC=" + event.Lease.Mac.String(),
+		"DHCPD_IP=" + event.Lease.IP.String(),
+		"DHCPD_HOSTNAME=" + event.Lease.Hostname,
+		"DHCPD_POOL=" + event.Pool.Name,
+		"DHCPD_EXPIRATION=" + event.Lease.Expiration.Format(time.RFC3339),
+	}
+}
+
+func (h *ExecHook) run(event pool.LeaseEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Env = append(os.Environ(), execHookEnv(event)...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Exec hook %v failed for %v event on %v: %v: %s", h.command[0], event.Kind, event.Lease.IP.String(), err, output)
+	}
+}
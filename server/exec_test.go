@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestExecHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+
+	_, err := NewExecHook(&ExecHookConf{Command: []string{"true"}, Events: []string{"committed"}})
+	require.NotNil(t, err)
+
+	hook, err := NewExecHook(&ExecHookConf{
+		Events:  []string{pool.LEASE_CREATED, pool.LEASE_EXPIRED},
+		Command: []string{"sh", "-c", `echo "$DHCPD_EVENT $DHCPD_MAC $DHCPD_IP $DHCPD_HOSTNAME $DHCPD_POOL" >> ` + out},
+	})
+	require.Nil(t, err)
+	go hook.Run()
+
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.LeaseTime = time.Hour
+	pool.AddObserver(hook.Observe)
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	_, err = pool.GetNextLease(mac, "host1")
+	require.Nil(t, err)
+
+	// Not one we run for
+	_, ok := pool.TouchLeaseByMac(mac)
+	require.True(t, ok)
+
+	// Sweeping past the expiry
+	pool.NotifyExpired(time.Now(), time.Now().Add(2*time.Hour))
+
+	require.Eventually(t, func() bool {
+		contents, _ := os.ReadFile(out)
+		return strings.Count(string(contents), "\n") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	contents, err := os.ReadFile(out)
+	require.Nil(t, err)
+	require.Equal(t, "created 0:0:0:0:0:1 10.0.0.10 host1 test\nexpired 0:0:0:0:0:1 10.0.0.10 host1 test\n", string(contents))
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Active/standby failover between two servers. The secondary connects to the
+// primary over TCP and each replicates its lease changes to the other. The
+// primary answers clients; the secondary only does so once it hasn't heard
+// from the primary for a while.
+//
+// To avoid handing out the same IP twice if both end up answering during a
+// partition, each server only allocates new IPs from its own half of every
+// pool's range, while honouring existing leases from either. On reconnecting
+// both sides send every lease they have, and the one expiring later wins.
+//
+// The primary only accepts connections from allowed addresses. Each side
+// then sends a random nonce, and every message after is signed with an
+// HMAC of the shared secret, the receiver's nonce and the message's
+// sequence number, so that messages can't be forged, replayed or
+// reordered. They aren't encrypted.
+//
+
+const (
+	FAILOVER_PRIMARY   = "primary"
+	FAILOVER_SECONDARY = "secondary"
+)
+
+// Message types exchanged between peers
+const (
+	FAILOVER_HEARTBEAT = "heartbeat"
+	FAILOVER_UPDATE    = "update"
+	FAILOVER_RELEASE   = "release"
+	FAILOVER_SYNC      = "sync"
+)
+
+type failoverMessage struct {
+	Type   string
+	Pool   string                       `json:",omitempty"`
+	Leases []*pool.FilePersistenceLease `json:",omitempty"`
+}
+
+// Sent first by each side
+type failoverHello struct {
+	Nonce []byte
+}
+
+// A message as sent, with its signature
+type failoverEnvelope struct {
+	Seq     uint64
+	Message json.RawMessage
+	Mac     []byte
+}
+
+const failoverNonceSize = 32
+
+type Failover struct {
+	role      string
+	listen    string
+	peer      string
+	secret    []byte
+	allow     *RelayAllowlist
+	heartbeat time.Duration
+	timeout   time.Duration
+	pools     map[string]*pool.Pool
+
+	out chan failoverMessage
+
+	// Held while talking to our peer, so only one connection is used at a
+	// time
+	session sync.Mutex
+
+	m         sync.Mutex
+	connected bool
+	lastHeard time.Time
+}
+
+func NewFailover(conf *FailoverConf, pools []*pool.Pool) (*Failover, error) {
+	f := &Failover{
+		role:      conf.Role,
+		listen:    conf.Listen,
+		peer:      conf.Peer,
+		secret:    []byte(conf.Secret),
+		heartbeat: time.Second,
+		timeout:   5 * time.Second,
+		pools:     map[string]*pool.Pool{},
+		out:       make(chan failoverMessage, 1024),
+		lastHeard: time.Now(),
+	}
+
+	switch f.role {
+	case FAILOVER_PRIMARY:
+		if f.listen == "" {
+			return nil, errors.New("Failover primary needs an address to listen on")
+		}
+		if len(conf.Allow) == 0 {
+			return nil, errors.New("Failover primary needs the secondary's address to allow")
+		}
+		var err error
+		if f.allow, err = NewRelayAllowlist(conf.Allow); err != nil {
+			return nil, err
+		}
+	case FAILOVER_SECONDARY:
+		if f.peer == "" {
+			return nil, errors.New("Failover secondary needs a peer to connect to")
+		}
+	default:
+		return nil, fmt.Errorf("Unknown failover role %q", f.role)
+	}
+
+	if len(f.secret) < 16 {
+		return nil, errors.New("Failover needs a secret of at least 16 characters")
+	}
+
+	if conf.Heartbeat != 0 {
+		f.heartbeat = time.Duration(conf.Heartbeat) * time.Second
+	}
+	if conf.Timeout != 0 {
+		f.timeout = time.Duration(conf.Timeout) * time.Second
+	}
+	if f.timeout <= f.heartbeat {
+		return nil, errors.New("Failover timeout must be longer than the heartbeat")
+	}
+
+	for _, pool := range pools {
+		f.pools[pool.Name] = pool
+
+		start, end := failoverShare(pool, f.role)
+		pool.SetShare(start, end)
+		pool.AddObserver(f.observe)
+	}
+
+	return f, nil
+}
+
+// Primary gets the first half of the range, secondary the rest
+func failoverShare(pool *pool.Pool, role string) (dhcp4.FixedV4, dhcp4.FixedV4) {
+	start := dhcp4.IpToFixedV4(pool.Start)
+	end := dhcp4.IpToFixedV4(pool.End)
+	middle := start + (end-start)/2
+
+	if role == FAILOVER_PRIMARY {
+		return start, middle
+	}
+	return middle + 1, end
+}
+
+// Whether we should be answering clients
+func (f *Failover) Active() bool {
+	if f.role == FAILOVER_PRIMARY {
+		return true
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	return time.Since(f.lastHeard) > f.timeout
+}
+
+func (f *Failover) markHeard() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.lastHeard = time.Now()
+}
+
+func (f *Failover) setConnected(connected bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.connected = connected
+}
+
+// Queue lease changes for the peer. While disconnected there's no point, as
+// everything gets sent on reconnecting
+func (f *Failover) observe(event pool.LeaseEvent) {
+	// Our peer notices expiries itself
+	if event.Kind == pool.LEASE_EXPIRED {
+		return
+	}
+
+	f.m.Lock()
+	connected := f.connected
+	f.m.Unlock()
+	if !connected {
+		return
+	}
+
+	message := failoverMessage{
+		Type:   FAILOVER_UPDATE,
+		Pool:   event.Pool.Name,
+		Leases: []*pool.FilePersistenceLease{pool.NewFilePersistenceLease(&event.Lease)},
+	}
+	if event.Kind == pool.LEASE_RELEASED {
+		message.Type = FAILOVER_RELEASE
+	}
+
+	select {
+	case f.out <- message:
+	default:
+		log.Printf("Failover queue full; dropping update for %v", event.Lease.IP.String())
+	}
+}
+
+// Connect to or accept connections from our peer forever
+func (f *Failover) Run() error {
+	go f.watch()
+
+	if f.role == FAILOVER_PRIMARY {
+		ln, err := net.Listen("tcp", f.listen)
+		if err != nil {
+			return err
+		}
+		log.Printf("Failover primary listening on %v", f.listen)
+		return f.Serve(ln)
+	}
+
+	for {
+		conn, err := net.DialTimeout("tcp", f.peer, f.timeout)
+		if err != nil {
+			time.Sleep(f.heartbeat)
+			continue
+		}
+		f.serve(conn)
+		time.Sleep(f.heartbeat)
+	}
+}
+
+// Accept connections from the secondary. They're set up concurrently, so
+// one which goes nowhere doesn't hold up the real one, but only one at a
+// time is used
+func (f *Failover) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go f.serve(conn)
+	}
+}
+
+// Log whenever we take over or hand back
+func (f *Failover) watch() {
+	active := f.Active()
+	for range time.Tick(f.heartbeat) {
+		if now := f.Active(); now != active {
+			if now {
+				log.Printf("Failover peer is down; answering clients")
+			} else {
+				log.Printf("Failover peer is back; standing by")
+			}
+			active = now
+		}
+	}
+}
+
+// A connection to our peer, once we've swapped nonces
+type failoverConn struct {
+	conn      net.Conn
+	secret    []byte
+	encoder   *json.Encoder
+	decoder   *json.Decoder
+	nonce     []byte
+	peerNonce []byte
+
+	// Each only used by one goroutine, the writer and the reader
+	sent     uint64
+	received uint64
+}
+
+func failoverMac(secret, nonce []byte, seq uint64, message []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(nonce)
+	binary.Write(mac, binary.BigEndian, seq)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (c *failoverConn) send(message failoverMessage, timeout time.Duration) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	envelope := failoverEnvelope{Seq: c.sent, Message: payload, Mac: failoverMac(c.secret, c.peerNonce, c.sent, payload)}
+	c.sent++
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return c.encoder.Encode(envelope)
+}
+
+func (c *failoverConn) receive(timeout time.Duration) (failoverMessage, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	envelope := failoverEnvelope{}
+	if err := c.decoder.Decode(&envelope); err != nil {
+		return failoverMessage{}, err
+	}
+	if envelope.Seq != c.received || !hmac.Equal(envelope.Mac, failoverMac(c.secret, c.nonce, envelope.Seq, envelope.Message)) {
+		return failoverMessage{}, errors.New("Message isn't signed by our peer")
+	}
+	c.received++
+	message := failoverMessage{}
+	return message, json.Unmarshal(envelope.Message, &message)
+}
+
+// Swap nonces with the other end, and check it knows the secret by it
+// signing a heartbeat
+func (f *Failover) handshake(conn net.Conn) (*failoverConn, error) {
+	c := &failoverConn{
+		conn:    conn,
+		secret:  f.secret,
+		encoder: json.NewEncoder(conn),
+		decoder: json.NewDecoder(bufio.NewReader(conn)),
+		nonce:   make([]byte, failoverNonceSize),
+	}
+	if _, err := rand.Read(c.nonce); err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(f.timeout))
+	if err := c.encoder.Encode(failoverHello{c.nonce}); err != nil {
+		return nil, err
+	}
+	hello := failoverHello{}
+	if err := c.decoder.Decode(&hello); err != nil {
+		return nil, err
+	}
+	if len(hello.Nonce) != failoverNonceSize {
+		return nil, errors.New("Invalid nonce")
+	}
+	c.peerNonce = hello.Nonce
+
+	if err := c.send(failoverMessage{Type: FAILOVER_HEARTBEAT}, f.timeout); err != nil {
+		return nil, err
+	}
+	if _, err := c.receive(f.timeout); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (f *Failover) serve(conn net.Conn) {
+	defer conn.Close()
+
+	if f.allow != nil {
+		if remote := addrIp(conn.RemoteAddr()); remote == nil || !f.allow.contains(remote) {
+			log.Printf("Refusing failover connection from %v", conn.RemoteAddr())
+			return
+		}
+	}
+	c, err := f.handshake(conn)
+	if err != nil {
+		log.Printf("Failover connection from %v failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	// Waiting for an older connection to time out, if there is one
+	f.session.Lock()
+	defer f.session.Unlock()
+	log.Printf("Failover connected to %v", conn.RemoteAddr())
+
+	f.markHeard()
+	f.setConnected(true)
+	defer f.setConnected(false)
+
+	done := make(chan struct{})
+	defer close(done)
+	go f.write(c, done)
+
+	for {
+		message, err := c.receive(f.timeout)
+		if err != nil {
+			log.Printf("Failover connection to %v lost: %v", conn.RemoteAddr(), err)
+			return
+		}
+		f.markHeard()
+		f.apply(message)
+	}
+}
+
+func (f *Failover) write(c *failoverConn, done chan struct{}) {
+	send := func(message failoverMessage) bool {
+		if err := c.send(message, f.timeout); err != nil {
+			c.conn.Close()
+			return false
+		}
+		return true
+	}
+
+	for _, message := range f.syncMessages() {
+		if !send(message) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(f.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case message := <-f.out:
+			if !send(message) {
+				return
+			}
+		case <-ticker.C:
+			if !send(failoverMessage{Type: FAILOVER_HEARTBEAT}) {
+				return
+			}
+		}
+	}
+}
+
+// Every lease we have, per pool
+func (f *Failover) syncMessages() []failoverMessage {
+	var messages []failoverMessage
+	for name, p := range f.pools {
+		message := failoverMessage{Type: FAILOVER_SYNC, Pool: name}
+		for _, lease := range p.GetLeases() {
+			message.Leases = append(message.Leases, pool.NewFilePersistenceLease(&lease))
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func (f *Failover) apply(message failoverMessage) {
+	if message.Type == FAILOVER_HEARTBEAT {
+		return
+	}
+
+	pool, ok := f.pools[message.Pool]
+	if !ok {
+		log.Printf("Failover peer sent leases for unknown pool %v", message.Pool)
+		return
+	}
+
+	for _, lease := range message.Leases {
+		switch message.Type {
+		case FAILOVER_UPDATE, FAILOVER_SYNC:
+			pool.ApplyLease(lease.ToLease())
+		case FAILOVER_RELEASE:
+			decoded := lease.ToLease()
+			pool.RemoveLease(decoded.IP, decoded.Mac)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+const testFailoverSecret = "0123456789abcdef"
+
+func TestFailover(t *testing.T) {
+	primaryPool := newTestPool()
+	primaryPool.Name = "test"
+	primaryPool.LeaseTime = time.Hour
+
+	secondaryPool := newTestPool()
+	secondaryPool.Name = "test"
+	secondaryPool.LeaseTime = time.Hour
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	primary, err := NewFailover(&FailoverConf{Role: FAILOVER_PRIMARY, Listen: ln.Addr().String(), Secret: testFailoverSecret, Allow: []string{"127.0.0.1"}, Heartbeat: 1, Timeout: 3}, []*pool.Pool{primaryPool})
+	require.Nil(t, err)
+	secondary, err := NewFailover(&FailoverConf{Role: FAILOVER_SECONDARY, Peer: ln.Addr().String(), Secret: testFailoverSecret, Heartbeat: 1, Timeout: 3}, []*pool.Pool{secondaryPool})
+	require.Nil(t, err)
+
+	// Something connecting first and saying nothing doesn't hold up the
+	// secondary
+	stuck, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer stuck.Close()
+
+	// Existing lease on the primary gets synced on connecting
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease1, err := primaryPool.GetNextLease(mac1, "host1")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), lease1.IP)
+
+	go primary.Serve(ln)
+	go secondary.Run()
+
+	require.Eventually(t, func() bool {
+		lease, ok := secondaryPool.GetLeaseByMac(mac1)
+		return ok && lease.IP == lease1.IP
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.True(t, primary.Active())
+	require.False(t, secondary.Active())
+
+	// Each side allocates from its own half, and replicates to the other
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	lease2, err := secondaryPool.GetNextLease(mac2, "host2")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.16")), lease2.IP)
+
+	require.Eventually(t, func() bool {
+		lease, ok := primaryPool.GetLeaseByMac(mac2)
+		return ok && lease.IP == lease2.IP
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Releases replicate too
+	_, ok := primaryPool.ReleaseLeaseByMac(mac1)
+	require.True(t, ok)
+
+	require.Eventually(t, func() bool {
+		_, ok := secondaryPool.GetLeaseByMac(mac1)
+		return !ok
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Secondary takes over once the primary goes quiet
+	secondary.m.Lock()
+	secondary.lastHeard = time.Now().Add(-time.Minute)
+	secondary.m.Unlock()
+	require.True(t, secondary.Active())
+}
+
+func TestFailoverAuth(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.LeaseTime = time.Hour
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	primary, err := NewFailover(&FailoverConf{Role: FAILOVER_PRIMARY, Listen: ln.Addr().String(), Secret: testFailoverSecret, Allow: []string{"127.0.0.1"}, Heartbeat: 1, Timeout: 3}, []*pool.Pool{p})
+	require.Nil(t, err)
+	go primary.Serve(ln)
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	update := failoverMessage{Type: FAILOVER_UPDATE, Pool: "test", Leases: []*pool.FilePersistenceLease{
+		pool.NewFilePersistenceLease(&pool.Lease{IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), Mac: mac, Expiration: time.Now().Add(time.Hour)}),
+	}}
+
+	// Updates from a peer which doesn't know the secret are refused
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	impostor := &Failover{secret: []byte("fedcba9876543210"), timeout: 3 * time.Second}
+	_, err = impostor.handshake(conn)
+	require.NotNil(t, err)
+
+	// As are ones replayed
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	peer := &Failover{secret: []byte(testFailoverSecret), timeout: 3 * time.Second}
+	c, err := peer.handshake(conn)
+	require.Nil(t, err)
+	c.sent = 0
+	require.Nil(t, c.send(update, time.Second))
+	for err == nil {
+		_, err = c.receive(3 * time.Second)
+	}
+	_, ok := p.GetLeaseByMac(mac)
+	require.False(t, ok)
+
+	// The secret gets them through
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	c, err = peer.handshake(conn)
+	require.Nil(t, err)
+	require.Nil(t, c.send(update, time.Second))
+	require.Eventually(t, func() bool {
+		_, ok := p.GetLeaseByMac(mac)
+		return ok
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestFailoverConf(t *testing.T) {
+	_, err := NewFailover(&FailoverConf{Role: FAILOVER_PRIMARY, Secret: testFailoverSecret, Allow: []string{"10.0.0.2"}}, nil)
+	require.NotNil(t, err)
+
+	_, err = NewFailover(&FailoverConf{Role: FAILOVER_SECONDARY, Secret: testFailoverSecret}, nil)
+	require.NotNil(t, err)
+
+	_, err = NewFailover(&FailoverConf{Role: "bogus"}, nil)
+	require.NotNil(t, err)
+
+	_, err = NewFailover(&FailoverConf{Role: FAILOVER_PRIMARY, Listen: ":8647", Secret: testFailoverSecret, Allow: []string{"10.0.0.2"}, Heartbeat: 5, Timeout: 5}, nil)
+	require.NotNil(t, err)
+
+	// Needs a secret, and the primary who to allow
+	_, err = NewFailover(&FailoverConf{Role: FAILOVER_SECONDARY, Peer: "10.0.0.1:8647"}, nil)
+	require.NotNil(t, err)
+	_, err = NewFailover(&FailoverConf{Role: FAILOVER_SECONDARY, Peer: "10.0.0.1:8647", Secret: "short"}, nil)
+	require.NotNil(t, err)
+	_, err = NewFailover(&FailoverConf{Role: FAILOVER_PRIMARY, Listen: ":8647", Secret: testFailoverSecret}, nil)
+	require.NotNil(t, err)
+	_, err = NewFailover(&FailoverConf{Role: FAILOVER_PRIMARY, Listen: ":8647", Secret: testFailoverSecret, Allow: []string{"10.0.0.2"}}, nil)
+	require.Nil(t, err)
+}
+
+func TestSplitScope(t *testing.T) {
+	start := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10"))
+	end := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.19"))
+
+	shareStart, shareEnd, err := (&SplitConf{Role: FAILOVER_PRIMARY, Percent: 70}).Share(start, end)
+	require.Nil(t, err)
+	require.Equal(t, start, shareStart)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.16")), shareEnd)
+
+	shareStart, shareEnd, err = (&SplitConf{Role: FAILOVER_SECONDARY, Percent: 70}).Share(start, end)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.17")), shareStart)
+	require.Equal(t, end, shareEnd)
+
+	_, _, err = (&SplitConf{Role: FAILOVER_PRIMARY, Percent: 100}).Share(start, end)
+	require.NotNil(t, err)
+	_, _, err = (&SplitConf{Role: FAILOVER_PRIMARY, Percent: 5}).Share(start, end)
+	require.NotNil(t, err)
+
+	// Secondary only allocates from its 30%
+	p := newTestPool()
+	p.End = end.NetIp()
+	p.LeaseTime = time.Hour
+	p.SetShare(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.17")), end)
+
+	for i := byte(1); i <= 3; i++ {
+		lease, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware(), "")
+		require.Nil(t, err)
+		require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.17"))+dhcp4.FixedV4(i-1), lease.IP)
+	}
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware(), "")
+	require.Equal(t, pool.ErrNoIps, err)
+
+	// But renews leases from the primary's share
+	message := newTestMessage(dhcp4.DHCPREQUEST, dhcp4.MacAddress{0, 0, 0, 0, 0, 5}.Hardware())
+	message.Header.ClientAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.12"))
+	response := NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, message.Header.ClientAddr, response.Header.YourAddr)
+
+	// Unless someone else already holds it
+	message.Header.Mac = dhcp4.MacAddress{0, 0, 0, 0, 0, 6}
+	response = NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// Or it's outside the pool
+	message.Header.ClientAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.100"))
+	response = NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+}
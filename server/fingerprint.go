@@ -0,0 +1,29 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Fingerprints of clients, for telling what kind of device each is. The
+// options a client asks for (55) and the order it asks for them in differ
+// between operating systems and firmwares, so written as a comma separated
+// list of codes they can be looked up in databases such as Fingerbank's.
+// Along with the vendor class they're kept on leases and given in events.
+//
+
+// The codes of the client's parameter request list, eg "1,3,6,15"
+func fingerprint(options *dhcp4.Options) string {
+	option, ok := options.Get(dhcp4.OPTION_PARAM_REQ)
+	if !ok {
+		return ""
+	}
+	codes := make([]string, len(option.Data))
+	for i, code := range option.Data {
+		codes[i] = strconv.Itoa(int(code))
+	}
+	return strings.Join(codes, ",")
+}
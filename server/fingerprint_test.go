@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestFingerprint(t *testing.T) {
+	p := newTestPool()
+	p.Name = "office"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	path := filepath.Join(t.TempDir(), "office.json")
+	p.Persistence = pool.NewFilePersistence(path)
+	app := newTestApp(t, p)
+
+	lease := func(mac dhcp4.HardwareAddr, prl []byte, vendorClass string) EventRecord {
+		discover := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		offer := NewRequestHandler(discover, &RequestContext{Pool: p}).Handle()
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+		request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+		request.Options.Set(dhcp4.OPTION_PARAM_REQ, prl)
+		if vendorClass != "" {
+			request.Options.SetString(dhcp4.OPTION_VENDOR, vendorClass)
+		}
+		ack := NewRequestHandler(request, &RequestContext{Pool: p}).Handle()
+		record, ok := NewResponseEventRecord(&RequestContext{Pool: p}, request, ack)
+		require.True(t, ok)
+		return record
+	}
+
+	printer := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	record := lease(printer, []byte{1, 3, 6, 15, 44, 47}, "Hewlett-Packard JetDirect")
+	require.Equal(t, "1,3,6,15,44,47", record.Fingerprint)
+	require.Equal(t, "Hewlett-Packard JetDirect", record.VendorClass)
+	phone := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	lease(phone, []byte{1, 3, 6, 42, 66, 150}, "")
+
+	held, ok := p.GetLeaseByMac(printer)
+	require.True(t, ok)
+	require.Equal(t, "1,3,6,15,44,47", held.Fingerprint)
+	require.Equal(t, "Hewlett-Packard JetDirect", held.VendorClass)
+
+	// Kept across restarts once the lease is next written
+	_, ok = p.TouchLease(printer, time.Hour)
+	require.True(t, ok)
+	require.Nil(t, p.Flush())
+	leases, err := pool.NewFilePersistence(path).LoadLeases()
+	require.Nil(t, err)
+	require.Equal(t, "1,3,6,15,44,47", leases[held.IP].Fingerprint)
+
+	get := func(url string) map[string][]adminFingerprint {
+		rec := httptest.NewRecorder()
+		app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var fingerprints map[string][]adminFingerprint
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &fingerprints))
+		return fingerprints
+	}
+	require.Len(t, get("/fingerprints")["office"], 2)
+	only := get("/fingerprints?fingerprint=1,3,6,42,66,150")["office"]
+	require.Len(t, only, 1)
+	require.Equal(t, phone.String(), only[0].Mac)
+	require.Empty(t, only[0].VendorClass)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Server initiated DHCPFORCERENEW (RFC 3203), to push clients into renewing
+// straight away after eg DNS or router changes. Note that many clients only
+// honour these when authenticated.
+//
+
+func NewForceRenew(pool *pool.Pool, lease *pool.Lease) *dhcp4.DHCPMessage {
+	header := &dhcp4.MessageHeader{
+		Op:         dhcp4.BOOT_REPLY,
+		Identifier: rand.Uint32(),
+		ClientAddr: lease.IP,
+		ServerAddr: pool.MyIp,
+	}
+	header.SetHardware(lease.Mac)
+
+	options := dhcp4.NewOptions()
+	options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, dhcp4.DHCPFORCERENEW)
+	options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, pool.MyIp)
+
+	return &dhcp4.DHCPMessage{Header: header, Options: options}
+}
+
+// Send a DHCPFORCERENEW to the holder of each active lease in a pool, or
+// just the one for mac if given. Returns how many were sent
+func (a *App) ForceRenew(poolName string, mac *dhcp4.HardwareAddr) (int, error) {
+	if a.socket == nil {
+		return 0, errors.New("No socket to send from")
+	}
+	if a.dryRun {
+		return 0, errors.New("Not sending anything in dry run mode")
+	}
+
+	pool, err := a.findPoolByName(poolName)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, lease := range pool.GetLeases() {
+		if lease.Expired() || (mac != nil && lease.Mac != *mac) {
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		if err := NewForceRenew(pool, &lease).Encode(buf); err != nil {
+			return sent, err
+		}
+
+		addr := &net.UDPAddr{IP: lease.IP.NetIp(), Port: 68}
+		if _, err := a.socket.WriteTo(buf.Bytes(), addr); err != nil {
+			return sent, fmt.Errorf("Failed sending to %v: %v", addr, err)
+		}
+		a.capture.Sent(pool.MyIp, addr, buf.Bytes())
+
+		log.Printf("Sending DHCPFORCERENEW to %v at %v", lease.Mac.String(), lease.IP.String())
+		sent++
+	}
+
+	if mac != nil && sent == 0 {
+		return 0, fmt.Errorf("No active lease for %v", mac.String())
+	}
+
+	return sent, nil
+}
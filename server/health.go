@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Health checks for process supervisors and container orchestrators. We're
+// live as long as we have sockets open and haven't been stopped, and ready
+// once as well as that every pool's configuration makes sense and its lease
+// backend is usable.
+//
+
+const (
+	HEALTH_OK      = "ok"
+	HEALTH_FAILING = "failing"
+)
+
+type HealthReport struct {
+	Status string `json:"status"`
+
+	// Result of each check by name, ok or what's wrong
+	Checks map[string]string `json:"checks"`
+}
+
+func (r *HealthReport) add(name string, err error) {
+	if err != nil {
+		r.Checks[name] = err.Error()
+		r.Status = HEALTH_FAILING
+		return
+	}
+	r.Checks[name] = HEALTH_OK
+}
+
+// Whether we're up and answering, and with ready whether everything we
+// depend on is too
+func (a *App) Health(ready bool) HealthReport {
+	report := HealthReport{Status: HEALTH_OK, Checks: map[string]string{}}
+	report.add("sockets", a.checkSockets())
+	if !ready {
+		return report
+	}
+
+	if len(a.ipnet2pool) == 0 {
+		report.add("pools", errors.New("No pools configured"))
+	}
+	for _, p := range a.pools() {
+		report.add("pool "+p.Name, checkPool(p))
+		report.add("leases "+p.Name, p.CheckPersistence())
+	}
+	return report
+}
+
+func (a *App) checkSockets() error {
+	if a.stopped.Load() {
+		return errors.New("Stopped")
+	}
+	if len(a.sockets) == 0 {
+		return errors.New("No sockets")
+	}
+	return nil
+}
+
+// Whether a pool's range and settings make sense
+func checkPool(p *pool.Pool) error {
+	if p.Start == nil || p.End == nil {
+		return errors.New("No range")
+	}
+	if dhcp4.IpToFixedV4(p.End) < dhcp4.IpToFixedV4(p.Start) {
+		return fmt.Errorf("Range ends at %v before starting at %v", p.End, p.Start)
+	}
+	if p.Network != nil && p.Netmask != nil {
+		ipnet := &net.IPNet{IP: p.Network, Mask: net.IPMask(p.Netmask.To4())}
+		if !ipnet.Contains(p.Start) || !ipnet.Contains(p.End) {
+			return fmt.Errorf("Range %v-%v is outside of %v", p.Start, p.End, ipnet)
+		}
+	}
+	if p.MyIp.Empty() {
+		return errors.New("No server IP")
+	}
+	if p.LeaseTime == 0 {
+		return errors.New("No lease time")
+	}
+	return nil
+}
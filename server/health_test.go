@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mygodhcpd/pool"
+)
+
+func TestHealth(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	dir := t.TempDir()
+	p.Persistence = pool.NewFilePersistence(filepath.Join(dir, "test.json"))
+
+	// Not yet serving
+	app := NewApp()
+	require.Nil(t, app.insertPool(p))
+	report := app.Health(false)
+	require.Equal(t, HEALTH_FAILING, report.Status)
+	require.Equal(t, "No sockets", report.Checks["sockets"])
+
+	app = newTestApp(t, p)
+	handler := app.AdminHandler()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report HealthReport
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Equal(t, HEALTH_OK, report.Status)
+	}
+
+	// Lease backend gone, and a pool that makes no sense, aren't ready
+	// but are still live
+	p.Persistence = pool.NewFilePersistence(filepath.Join(dir, "missing", "test.json"))
+	p.LeaseTime = 0
+	report = app.Health(true)
+	require.Equal(t, HEALTH_FAILING, report.Status)
+	require.Equal(t, "No lease time", report.Checks["pool test"])
+	require.NotEqual(t, HEALTH_OK, report.Checks["leases test"])
+	require.Equal(t, HEALTH_OK, report.Checks["sockets"])
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Nor once stopped
+	require.Nil(t, app.Stop())
+	require.Equal(t, "Stopped", app.Health(false).Checks["sockets"])
+}
+
+func TestCheckPool(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	require.Nil(t, checkPool(p))
+
+	p.Network = net.ParseIP("10.1.0.0")
+	require.NotNil(t, checkPool(p))
+	p.Network = net.ParseIP("10.0.0.0")
+	p.Start, p.End = p.End, p.Start
+	require.NotNil(t, checkPool(p))
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Export active leases' hostnames for a local resolver to serve, without
+// dynamic DNS. Either as a hosts file, for dnsmasq's addn-hosts, unbound
+// or systemd-resolved:
+//
+//   10.0.0.10	laptop.example.com laptop
+//
+// or as dnsmasq host-record lines:
+//
+//   host-record=laptop.example.com,laptop,10.0.0.10
+//
+// The file is rewritten every interval if anything changed, and on demand
+// through the admin API. Hostnames which aren't a single DNS label are left
+// out, as are all but the latest lease of a hostname several clients sent.
+//
+
+const (
+	HOSTS_FORMAT_HOSTS   = "hosts"
+	HOSTS_FORMAT_DNSMASQ = "dnsmasq"
+)
+
+const defaultHostsInterval = 60 * time.Second
+
+type hostsEntry struct {
+	ip   dhcp4.FixedV4
+	name string
+	fqdn string
+}
+
+type HostsExporter struct {
+	path     string
+	format   string
+	interval time.Duration
+	pools    func() []*pool.Pool
+
+	m    sync.Mutex
+	last []byte
+}
+
+func validHostsFormat(format string) bool {
+	return format == HOSTS_FORMAT_HOSTS || format == HOSTS_FORMAT_DNSMASQ
+}
+
+func NewHostsExporter(conf *HostsFileConf, pools func() []*pool.Pool) (*HostsExporter, error) {
+	if conf.Path == "" {
+		return nil, errors.New("Hosts file export needs a path")
+	}
+	e := &HostsExporter{
+		path:     conf.Path,
+		format:   conf.Format,
+		interval: time.Duration(conf.Interval) * time.Second,
+		pools:    pools,
+	}
+	if e.format == "" {
+		e.format = HOSTS_FORMAT_HOSTS
+	}
+	if !validHostsFormat(e.format) {
+		return nil, fmt.Errorf("Unknown hosts file format '%v'. Supported are hosts and dnsmasq", conf.Format)
+	}
+	if e.interval == 0 {
+		e.interval = defaultHostsInterval
+	}
+	return e, nil
+}
+
+// Active leases with a usable hostname, in IP order, keeping the latest
+// lease of each name
+func hostsEntries(pools []*pool.Pool) []hostsEntry {
+	latest := map[string]pool.Lease{}
+	domains := map[string]string{}
+	for _, p := range pools {
+		for _, lease := range p.GetLeases() {
+			if lease.Expired() || !validHostLabel(lease.Hostname) {
+				continue
+			}
+			name := strings.ToLower(lease.Hostname)
+			if other, ok := latest[name]; ok {
+				// Ties go to the lower IP, to write the same file each time
+				if other.LastTransaction.After(lease.LastTransaction) ||
+					other.LastTransaction.Equal(lease.LastTransaction) && other.IP < lease.IP {
+					continue
+				}
+			}
+			latest[name] = lease
+			domains[name] = strings.TrimSuffix(p.Domain, ".")
+		}
+	}
+
+	entries := make([]hostsEntry, 0, len(latest))
+	for name, lease := range latest {
+		entry := hostsEntry{ip: lease.IP, name: name}
+		if domain := domains[name]; domain != "" {
+			entry.fqdn = name + "." + domain
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ip != entries[j].ip {
+			return entries[i].ip < entries[j].ip
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries
+}
+
+// The pools' hostnames in a format
+func WriteHosts(w io.Writer, pools []*pool.Pool, format string) error {
+	if !validHostsFormat(format) {
+		return fmt.Errorf("Unknown hosts file format '%v'", format)
+	}
+	for _, entry := range hostsEntries(pools) {
+		var err error
+		switch {
+		case format == HOSTS_FORMAT_DNSMASQ && entry.fqdn != "":
+			_, err = fmt.Fprintf(w, "host-record=%v,%v,%v\n", entry.fqdn, entry.name, entry.ip.String())
+		case format == HOSTS_FORMAT_DNSMASQ:
+			_, err = fmt.Fprintf(w, "host-record=%v,%v\n", entry.name, entry.ip.String())
+		case entry.fqdn != "":
+			_, err = fmt.Fprintf(w, "%v\t%v %v\n", entry.ip.String(), entry.fqdn, entry.name)
+		default:
+			_, err = fmt.Fprintf(w, "%v\t%v\n", entry.ip.String(), entry.name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the file if it's changed since we last did, returning whether it
+// had
+func (e *HostsExporter) Write() (bool, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Active leases, written by golang-dhcpd\n")
+	if err := WriteHosts(&buf, e.pools(), e.format); err != nil {
+		return false, err
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.last != nil && bytes.Equal(buf.Bytes(), e.last) {
+		return false, nil
+	}
+	tmp := e.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		return false, err
+	}
+	e.last = buf.Bytes()
+	return true, nil
+}
+
+func (e *HostsExporter) Run() {
+	for {
+		if _, err := e.Write(); err != nil {
+			log.Printf("Failed writing hosts file %v: %v", e.path, err)
+		}
+		time.Sleep(e.interval)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestHostsFile(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	p.Domain = "example.com"
+
+	for i, hostname := range []string{"Laptop", "printer", "not valid", "", "laptop"} {
+		_, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, byte(i + 1)}.Hardware(), hostname)
+		require.Nil(t, err)
+	}
+	// The second laptop was heard from last, so has the name
+	p.NoteTransaction(dhcp4.MacAddress{0, 0, 0, 0, 0, 5}.Hardware(), pool.Transaction{})
+	laptop, _ := p.GetLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 5}.Hardware())
+	printer, _ := p.GetLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+
+	app := newTestApp(t, p)
+	path := filepath.Join(t.TempDir(), "hosts")
+	var err error
+	app.hostsFile, err = NewHostsExporter(&HostsFileConf{Path: path}, app.pools)
+	require.Nil(t, err)
+
+	written, err := app.hostsFile.Write()
+	require.Nil(t, err)
+	require.True(t, written)
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "# Active leases, written by golang-dhcpd\n"+
+		printer.IP.String()+"\tprinter.example.com printer\n"+
+		laptop.IP.String()+"\tlaptop.example.com laptop\n", string(data))
+
+	// Nothing changed, so nothing to write
+	written, err = app.hostsFile.Write()
+	require.Nil(t, err)
+	require.False(t, written)
+
+	handler := app.AdminHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hosts?format=dnsmasq", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "host-record=printer.example.com,printer,"+printer.IP.String()+"\n"+
+		"host-record=laptop.example.com,laptop,"+laptop.IP.String()+"\n", rec.Body.String())
+
+	p.ReleaseLeaseByMac(printer.Mac)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hosts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	data, _ = ioutil.ReadFile(path)
+	require.NotContains(t, string(data), "printer")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hosts?format=bogus", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	_, err = NewHostsExporter(&HostsFileConf{Path: path, Format: "bind"}, app.pools)
+	require.NotNil(t, err)
+}
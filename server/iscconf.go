@@ -0,0 +1,467 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Converter for a useful subset of ISC dhcpd.conf into our own configuration:
+// subnets, ranges, host reservations and common options. Anything else is
+// skipped with a warning, so the result needs reviewing before use.
+//
+
+// A parsed statement, along with its nested block if it has one
+type iscStatement struct {
+	Args  []string
+	Block []*iscStatement
+}
+
+// Options which map onto our own config fields or a typed custom option
+var iscOptionTypes = map[string]struct {
+	code int
+	kind string
+}{
+	"domain-name":          {dhcp4.OPTION_DOMAIN_NAME, "string"},
+	"tftp-server-name":     {dhcp4.OPTION_TFTP_NAME, "string"},
+	"bootfile-name":        {dhcp4.OPTION_BOOT_FILE, "string"},
+	"time-offset":          {dhcp4.OPTION_TIME_OFFSET, "uint32"},
+	"log-servers":          {dhcp4.OPTION_LOG_SERVER, "ip-list"},
+	"netbios-name-servers": {dhcp4.OPTION_WINS_SERVER, "ip-list"},
+}
+
+// Split into words, quoted strings and the ; { } punctuation, dropping comments
+func tokenizeIscConf(input string) ([]string, error) {
+	var tokens []string
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+		case c == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("Unterminated string")
+			}
+			// Keep the quotes so strings can be told apart from words
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(";{}#\"", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end - 1
+		}
+	}
+
+	return tokens, nil
+}
+
+func parseIscStatements(tokens []string, pos int, nested bool) ([]*iscStatement, int, error) {
+	var statements []*iscStatement
+	current := &iscStatement{}
+
+	for pos < len(tokens) {
+		token := tokens[pos]
+		pos++
+
+		switch token {
+		case ";":
+			if len(current.Args) > 0 {
+				statements = append(statements, current)
+			}
+			current = &iscStatement{}
+		case "{":
+			if len(current.Args) == 0 {
+				return nil, 0, fmt.Errorf("Unexpected {")
+			}
+			block, next, err := parseIscStatements(tokens, pos, true)
+			if err != nil {
+				return nil, 0, err
+			}
+			current.Block = block
+			statements = append(statements, current)
+			current = &iscStatement{}
+			pos = next
+		case "}":
+			if !nested {
+				return nil, 0, fmt.Errorf("Unexpected }")
+			}
+			if len(current.Args) > 0 {
+				return nil, 0, fmt.Errorf("Missing ; after %v", strings.Join(current.Args, " "))
+			}
+			return statements, pos, nil
+		default:
+			current.Args = append(current.Args, token)
+		}
+	}
+
+	if nested {
+		return nil, 0, fmt.Errorf("Missing }")
+	}
+	if len(current.Args) > 0 {
+		return nil, 0, fmt.Errorf("Missing ; after %v", strings.Join(current.Args, " "))
+	}
+	return statements, pos, nil
+}
+
+// Turn the comma separated remainder of a statement into a list
+func iscList(args []string) []string {
+	var result []string
+	for _, part := range strings.Split(strings.Join(args, " "), ",") {
+		if part = strings.Trim(strings.TrimSpace(part), `"`); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// Hardware types dhcpd knows by name
+var iscHardwareTypes = map[string]byte{
+	"ethernet":   dhcp4.HTYPE_ETHERNET,
+	"token-ring": dhcp4.HTYPE_IEEE802,
+	"fddi":       dhcp4.HTYPE_FDDI,
+}
+
+// Hardware address in our format from dhcpd's `hardware <type> <address>`
+func iscHardwareAddr(htype, address string) string {
+	if iscHardwareTypes[htype] == dhcp4.HTYPE_ETHERNET {
+		return address
+	}
+	return strconv.Itoa(int(iscHardwareTypes[htype])) + "-" + address
+}
+
+// Pool settings which can be given globally and then overridden per subnet
+type iscScope struct {
+	routers   []string
+	dns       []string
+	ntp       []string
+	mtu       uint16
+	leaseTime uint32
+	serverId  string
+	broadcast string
+	options   []OptionConf
+
+	// ISC dhcpd isn't authoritative unless told to be, unlike us
+	authoritative bool
+
+	// Whether clients without a host declaration are turned away
+	ignoreUnknown bool
+}
+
+type iscConverter struct {
+	warnings []string
+}
+
+func (c *iscConverter) warn(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// Apply a statement valid in any scope. Returns false if it isn't one
+func (c *iscConverter) applyScoped(scope *iscScope, args []string) bool {
+	switch {
+	case args[0] == "default-lease-time" && len(args) == 2:
+		seconds, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			c.warn("Invalid default-lease-time %v", args[1])
+		}
+		scope.leaseTime = uint32(seconds)
+	case args[0] == "authoritative" && len(args) == 1:
+		scope.authoritative = true
+	case args[0] == "not" && len(args) == 2 && args[1] == "authoritative":
+		scope.authoritative = false
+	case (args[0] == "deny" || args[0] == "ignore") && len(args) == 2 && args[1] == "unknown-clients":
+		scope.ignoreUnknown = true
+	case args[0] == "allow" && len(args) == 2 && args[1] == "unknown-clients":
+		scope.ignoreUnknown = false
+	case args[0] == "server-identifier" && len(args) == 2:
+		scope.serverId = args[1]
+	case args[0] == "filename" && len(args) == 2:
+		scope.options = append(scope.options, OptionConf{Code: 67, Type: "string", Value: strings.Trim(args[1], `"`)})
+	case args[0] == "option" && len(args) >= 3:
+		name := args[1]
+		values := iscList(args[2:])
+		if len(values) == 0 {
+			c.warn("Skipping option %v without a value", name)
+			break
+		}
+		switch name {
+		case "routers":
+			scope.routers = values
+		case "domain-name-servers":
+			scope.dns = values
+		case "ntp-servers":
+			scope.ntp = values
+		case "interface-mtu":
+			mtu, err := strconv.ParseUint(values[0], 10, 16)
+			if err != nil {
+				c.warn("Invalid interface-mtu %v", values[0])
+			}
+			scope.mtu = uint16(mtu)
+		case "broadcast-address":
+			scope.broadcast = values[0]
+		case "subnet-mask":
+			// Derived from the subnet declaration
+		default:
+			optionType, ok := iscOptionTypes[name]
+			if !ok {
+				c.warn("Skipping unsupported option %v", name)
+				break
+			}
+			var value interface{} = strings.Join(values, ",")
+			if optionType.kind == "ip-list" {
+				value = values
+			}
+			scope.options = append(scope.options, OptionConf{Code: optionType.code, Type: optionType.kind, Value: value})
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *iscConverter) convertHost(statement *iscStatement) (HostConf, string) {
+	host := HostConf{}
+	if len(statement.Args) > 1 {
+		host.Hostname = statement.Args[1]
+	}
+	scope := &iscScope{}
+	for _, s := range statement.Block {
+		switch {
+		case s.Args[0] == "hardware" && len(s.Args) == 3 && iscHardwareTypes[s.Args[1]] != 0:
+			host.Mac = iscHardwareAddr(s.Args[1], s.Args[2])
+		case s.Args[0] == "fixed-address" && len(s.Args) == 2:
+			host.IP = s.Args[1]
+		case c.applyScoped(scope, s.Args):
+		default:
+			c.warn("Skipping unsupported statement in host %v: %v", host.Hostname, strings.Join(s.Args, " "))
+		}
+	}
+	host.Options = scope.options
+	return host, host.IP
+}
+
+func (c *iscConverter) convertSubnet(statement *iscStatement, global iscScope) (PoolConf, *net.IPNet, error) {
+	args := statement.Args
+	if len(args) != 4 || args[2] != "netmask" {
+		return PoolConf{}, nil, fmt.Errorf("Malformed subnet declaration: %v", strings.Join(args, " "))
+	}
+
+	ipnet := &net.IPNet{IP: net.ParseIP(args[1]).To4(), Mask: net.IPMask(net.ParseIP(args[3]).To4())}
+	if ipnet.IP == nil || ipnet.Mask == nil {
+		return PoolConf{}, nil, fmt.Errorf("Invalid subnet %v netmask %v", args[1], args[3])
+	}
+
+	pc := PoolConf{
+		Name:    "subnet " + args[1],
+		Network: args[1],
+		Netmask: args[3],
+	}
+
+	scope := global
+	scope.options = append([]OptionConf{}, global.options...)
+
+	for _, s := range statement.Block {
+		switch {
+		case s.Args[0] == "range":
+			bounds := s.Args[1:]
+			bootp := len(bounds) > 0 && bounds[0] == "dynamic-bootp"
+			if bootp {
+				bounds = bounds[1:]
+			}
+			if len(bounds) == 1 {
+				bounds = append(bounds, bounds[0])
+			}
+			if len(bounds) != 2 {
+				c.warn("Skipping malformed range in %v", pc.Name)
+				continue
+			}
+			if pc.Start != "" {
+				c.warn("Only the first range of %v is used", pc.Name)
+				continue
+			}
+			pc.Start, pc.End = bounds[0], bounds[1]
+			if bootp {
+				pc.BootpStart, pc.BootpEnd = bounds[0], bounds[1]
+			}
+		case s.Args[0] == "host":
+			host, _ := c.convertHost(s)
+			pc.ReservedHosts = append(pc.ReservedHosts, host)
+		case c.applyScoped(&scope, s.Args):
+		default:
+			c.warn("Skipping unsupported statement in %v: %v", pc.Name, strings.Join(s.Args, " "))
+		}
+	}
+
+	pc.Router = scope.routers
+	pc.Dns = scope.dns
+	pc.Ntp = scope.ntp
+	pc.Mtu = scope.mtu
+	pc.Broadcast = scope.broadcast
+	authoritative := scope.authoritative
+	pc.Authoritative = &authoritative
+	pc.IgnoreUnknown = scope.ignoreUnknown
+	pc.LeaseTime = scope.leaseTime
+	pc.Options = scope.options
+
+	pc.MyIp = scope.serverId
+	if pc.MyIp == "" && len(pc.Router) > 0 {
+		pc.MyIp = pc.Router[0]
+		c.warn("No server-identifier for %v, so using the first router %v as myip", pc.Name, pc.MyIp)
+	}
+
+	if pc.Start == "" {
+		c.warn("%v has no range, so only reserved hosts will be served", pc.Name)
+	}
+
+	return pc, ipnet, nil
+}
+
+// Convert dhcpd.conf into our configuration, along with warnings about
+// anything which couldn't be
+func ConvertIscConf(reader io.Reader) (*Conf, []string, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := tokenizeIscConf(string(content))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statements, _, err := parseIscStatements(tokens, 0, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &iscConverter{}
+	conf := &Conf{Leasedir: "/var/lib/golang-dhcpd"}
+	global := iscScope{}
+
+	var globalHosts []*iscStatement
+	var nets []*net.IPNet
+
+	// Globals first, as they apply to every subnet regardless of ordering
+	for _, s := range statements {
+		switch {
+		case s.Args[0] == "subnet", s.Args[0] == "shared-network":
+		case s.Args[0] == "host":
+			globalHosts = append(globalHosts, s)
+		case c.applyScoped(&global, s.Args):
+		default:
+			c.warn("Skipping unsupported global statement: %v", strings.Join(s.Args, " "))
+		}
+	}
+
+	addSubnet := func(s *iscStatement, scope iscScope, shared string) error {
+		pc, ipnet, err := c.convertSubnet(s, scope)
+		if err != nil {
+			return err
+		}
+		pc.SharedNetwork = shared
+		conf.Pools = append(conf.Pools, pc)
+		nets = append(nets, ipnet)
+		return nil
+	}
+
+	for _, s := range statements {
+		switch s.Args[0] {
+		case "subnet":
+			if err := addSubnet(s, global, ""); err != nil {
+				return nil, nil, err
+			}
+		case "shared-network":
+			if len(s.Args) != 2 {
+				return nil, nil, fmt.Errorf("Malformed shared-network declaration: %v", strings.Join(s.Args, " "))
+			}
+			name := strings.Trim(s.Args[1], `"`)
+
+			// Options for the shared network apply to each of its subnets
+			scope := global
+			scope.options = append([]OptionConf{}, global.options...)
+			for _, child := range s.Block {
+				if child.Args[0] != "subnet" && !c.applyScoped(&scope, child.Args) {
+					c.warn("Skipping unsupported statement in shared-network %v: %v", name, strings.Join(child.Args, " "))
+				}
+			}
+			for _, child := range s.Block {
+				if child.Args[0] != "subnet" {
+					continue
+				}
+				if err := addSubnet(child, scope, name); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	// Hosts declared outside a subnet go into whichever one their IP is in
+	for _, s := range globalHosts {
+		host, ip := c.convertHost(s)
+		placed := false
+		for i, ipnet := range nets {
+			if ipnet.Contains(net.ParseIP(ip)) {
+				conf.Pools[i].ReservedHosts = append(conf.Pools[i].ReservedHosts, host)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			c.warn("Skipping host %v as no subnet contains %v", host.Hostname, ip)
+		}
+	}
+
+	c.warn("Interfaces are given on the ISC dhcpd command line, so need adding by hand")
+
+	return conf, c.warnings, nil
+}
+
+// Convert a dhcpd.conf file, writing the resulting yaml to out and logging
+// any warnings
+func ConvertIscConfFile(path string, out io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	conf, warnings, err := ConvertIscConf(file)
+	if err != nil {
+		return fmt.Errorf("Failed parsing %v: %v", path, err)
+	}
+
+	for _, warning := range warnings {
+		log.Printf("Warning: %v", warning)
+	}
+
+	content, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(content)
+	return err
+}
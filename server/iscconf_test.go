@@ -0,0 +1,139 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"strings"
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+const iscConf = `# Global settings
+default-lease-time 600;
+max-lease-time 7200;
+authoritative;
+option domain-name "example.com";
+option domain-name-servers 1.1.1.1, 8.8.8.8;
+
+subnet 172.17.0.0 netmask 255.255.255.0 {
+  range 172.17.0.100 172.17.0.200;
+  option routers 172.17.0.1;
+  option interface-mtu 9000;
+  default-lease-time 60;
+
+  host ubuntu2 {
+    hardware ethernet 0:1c:42:b4:6e:1d;
+    fixed-address 172.17.0.5;
+    filename "pxelinux.0";
+  }
+}
+
+subnet 192.168.0.0 netmask 255.255.255.0 {
+  range dynamic-bootp 192.168.0.200;
+  deny unknown-clients;
+  server-identifier 192.168.0.1;
+  option ntp-servers 192.168.0.1;
+}
+
+host printer {
+  hardware ethernet 0:1c:42:b4:6e:1e;
+  fixed-address 192.168.0.10;
+}
+`
+
+func TestConvertIscConf(t *testing.T) {
+	conf, warnings, err := ConvertIscConf(strings.NewReader(iscConf))
+	require.Nil(t, err)
+	require.Len(t, conf.Pools, 2)
+
+	pool := conf.Pools[0]
+	require.Equal(t, "subnet 172.17.0.0", pool.Name)
+	require.Equal(t, "172.17.0.0", pool.Network)
+	require.Equal(t, "255.255.255.0", pool.Netmask)
+	require.Equal(t, "172.17.0.100", pool.Start)
+	require.Equal(t, "172.17.0.200", pool.End)
+	require.Equal(t, []string{"172.17.0.1"}, pool.Router)
+	require.Equal(t, "172.17.0.1", pool.MyIp)
+	require.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, pool.Dns)
+	require.Equal(t, uint16(9000), pool.Mtu)
+	require.Equal(t, uint32(60), pool.LeaseTime)
+	require.Equal(t, []OptionConf{{Code: dhcp4.OPTION_DOMAIN_NAME, Type: "string", Value: "example.com"}}, pool.Options)
+	require.True(t, *pool.Authoritative)
+	require.False(t, pool.IgnoreUnknown)
+
+	require.Len(t, pool.ReservedHosts, 1)
+	require.Equal(t, "ubuntu2", pool.ReservedHosts[0].Hostname)
+	require.Equal(t, "0:1c:42:b4:6e:1d", pool.ReservedHosts[0].Mac)
+	require.Equal(t, "172.17.0.5", pool.ReservedHosts[0].IP)
+	require.Equal(t, []OptionConf{{Code: 67, Type: "string", Value: "pxelinux.0"}}, pool.ReservedHosts[0].Options)
+
+	pool = conf.Pools[1]
+	require.Equal(t, "192.168.0.1", pool.MyIp)
+	require.Equal(t, "192.168.0.200", pool.Start)
+	require.Equal(t, "192.168.0.200", pool.BootpEnd)
+	require.Equal(t, uint32(600), pool.LeaseTime)
+	require.Equal(t, []string{"192.168.0.1"}, pool.Ntp)
+	require.True(t, pool.IgnoreUnknown)
+
+	// Global host lands in the subnet containing it
+	require.Len(t, pool.ReservedHosts, 1)
+	require.Equal(t, "printer", pool.ReservedHosts[0].Hostname)
+
+	require.Contains(t, warnings, "Skipping unsupported global statement: max-lease-time 7200")
+
+	// The result is a valid configuration
+	for _, pc := range conf.Pools {
+		_, err := pc.ToPool()
+		require.Nil(t, err)
+	}
+
+	_, _, err = ConvertIscConf(strings.NewReader("subnet 10.0.0.0 netmask 255.0.0.0 {\n range 10.0.0.1 10.0.0.2\n}\n"))
+	require.NotNil(t, err)
+
+	_, _, err = ConvertIscConf(strings.NewReader("subnet 10.0.0.0 netmask 255.0.0.0 {\n"))
+	require.NotNil(t, err)
+}
+
+func TestConvertIscConfMalformed(t *testing.T) {
+	// Blocks without a declaration
+	for _, input := range []string{"{ }", "subnet 10.0.0.0 netmask 255.0.0.0 {\n { }\n}\n", "host printer { { } }"} {
+		_, _, err := ConvertIscConf(strings.NewReader(input))
+		require.NotNil(t, err, input)
+	}
+
+	// Options without a value are skipped
+	conf, warnings, err := ConvertIscConf(strings.NewReader(`option interface-mtu ,;
+subnet 10.0.0.0 netmask 255.255.255.0 {
+  range 10.0.0.10 10.0.0.20;
+  option broadcast-address "";
+}
+`))
+	require.Nil(t, err)
+	require.Len(t, conf.Pools, 1)
+	require.Zero(t, conf.Pools[0].Mtu)
+	require.Empty(t, conf.Pools[0].Broadcast)
+	require.Contains(t, warnings, "Skipping option interface-mtu without a value")
+	require.Contains(t, warnings, "Skipping option broadcast-address without a value")
+}
+
+func TestConvertIscSharedNetwork(t *testing.T) {
+	conf, _, err := ConvertIscConf(strings.NewReader(`shared-network "office" {
+  option routers 10.0.0.1;
+  subnet 10.0.0.0 netmask 255.255.255.0 {
+    range 10.0.0.10 10.0.0.200;
+  }
+  subnet 10.0.1.0 netmask 255.255.255.0 {
+    range 10.0.1.10 10.0.1.200;
+    option routers 10.0.1.1;
+  }
+}
+`))
+	require.Nil(t, err)
+	require.Len(t, conf.Pools, 2)
+	require.False(t, *conf.Pools[0].Authoritative)
+	require.Equal(t, "office", conf.Pools[0].SharedNetwork)
+	require.Equal(t, "office", conf.Pools[1].SharedNetwork)
+	require.Equal(t, []string{"10.0.0.1"}, conf.Pools[0].Router)
+	require.Equal(t, []string{"10.0.1.1"}, conf.Pools[1].Router)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// One-shot import of an ISC dhcpd leases file, eg /var/lib/dhcp/dhcpd.leases,
+// to migrate without handing out IPs clients already hold
+//
+
+// Parse a leases file. Entries for the same IP appear several times as
+// they're appended over time, so only the last one for each IP is kept
+func ParseIscLeases(reader io.Reader) ([]*pool.Lease, error) {
+	scanner := bufio.NewScanner(reader)
+
+	byIp := map[dhcp4.FixedV4]*pool.Lease{}
+	var order []dhcp4.FixedV4
+
+	var lease *pool.Lease
+	active := false
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripIscComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if lease == nil {
+			// Ignore everything outside lease blocks, eg server-duid
+			if !strings.HasPrefix(line, "lease ") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 3 || fields[2] != "{" {
+				return nil, fmt.Errorf("Line %v: malformed lease declaration", lineNo)
+			}
+			ip := net.ParseIP(fields[1])
+			if ip == nil || ip.To4() == nil {
+				return nil, fmt.Errorf("Line %v: invalid IP %v", lineNo, fields[1])
+			}
+			lease = &pool.Lease{IP: dhcp4.IpToFixedV4(ip)}
+			active = false
+			continue
+		}
+
+		if line == "}" {
+			if _, ok := byIp[lease.IP]; !ok {
+				order = append(order, lease.IP)
+			}
+			if active && !lease.Mac.Empty() {
+				byIp[lease.IP] = lease
+			} else {
+				byIp[lease.IP] = nil
+			}
+			lease = nil
+			continue
+		}
+
+		statement := strings.TrimSuffix(line, ";")
+		fields := strings.Fields(statement)
+
+		switch {
+		case strings.HasPrefix(statement, "binding state "):
+			active = len(fields) == 3 && fields[2] == "active"
+		case strings.HasPrefix(statement, "hardware "):
+			if len(fields) == 3 && iscHardwareTypes[fields[1]] != 0 {
+				lease.Mac = dhcp4.StrToHardwareAddr(iscHardwareAddr(fields[1], fields[2]))
+			}
+		case strings.HasPrefix(statement, "client-hostname "):
+			lease.Hostname = strings.Trim(strings.TrimPrefix(statement, "client-hostname "), `"`)
+		case strings.HasPrefix(statement, "ends "):
+			expiration, err := parseIscTime(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("Line %v: %v", lineNo, err)
+			}
+			lease.Expiration = expiration
+		case strings.HasPrefix(statement, "cltt "):
+			cltt, err := parseIscTime(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("Line %v: %v", lineNo, err)
+			}
+			lease.LastTransaction = cltt
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lease != nil {
+		return nil, fmt.Errorf("Unterminated lease for %v", lease.IP.String())
+	}
+
+	var leases []*pool.Lease
+	for _, ip := range order {
+		if lease := byIp[ip]; lease != nil {
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
+
+// Drop any # comment not inside a quoted string
+func stripIscComment(line string) string {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Either "never", "<weekday> yyyy/mm/dd hh:mm:ss" in UTC, or
+// "epoch <seconds>" when db-time-format local is used
+func parseIscTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return pool.NeverExpires, nil
+	case len(fields) >= 2 && fields[0] == "epoch":
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid epoch time %v", fields[1])
+		}
+		return time.Unix(seconds, 0), nil
+	case len(fields) == 3:
+		return time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	}
+	return time.Time{}, fmt.Errorf("Unrecognised time %v", strings.Join(fields, " "))
+}
+
+// Seed our pools with the active leases from an ISC leases file. Returns how
+// many were imported
+func (a *App) ImportIscLeases(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	leases, err := ParseIscLeases(file)
+	if err != nil {
+		return 0, fmt.Errorf("Failed parsing %v: %v", path, err)
+	}
+
+	count := 0
+	touched := map[*pool.Pool]struct{}{}
+	for _, lease := range leases {
+		if lease.Expired() {
+			continue
+		}
+		pool, err := a.findPoolbyGiaddr(lease.IP)
+		if err != nil {
+			log.Printf("Skipping lease for %v as no pool contains it", lease.IP.String())
+			continue
+		}
+		if err := pool.ImportLease(lease); err != nil {
+			log.Printf("Skipping lease for %v: %v", lease.IP.String(), err)
+			continue
+		}
+		touched[pool] = struct{}{}
+		count++
+	}
+
+	for pool := range touched {
+		if err := pool.PersistLeases(); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
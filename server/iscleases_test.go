@@ -0,0 +1,96 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+const iscLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.1
+
+# authoring-byte-order entry is generated, DO NOT DELETE
+authoring-byte-order little-endian;
+
+server-duid "\000\001\000\001(\326\323\024\000\034B\264n\035";
+
+lease 172.17.0.100 {
+  starts 4 2021/07/01 10:00:00;
+  ends 4 2021/07/01 22:00:00;
+  cltt 4 2021/07/01 10:00:00;
+  binding state active;
+  next binding state free;
+  hardware ethernet 00:1c:42:b4:6e:1d;
+  client-hostname "ubuntu2";
+}
+lease 172.17.0.101 {
+  starts 4 2021/07/01 10:00:00;
+  ends never;
+  binding state active;
+  hardware ethernet 00:1c:42:b4:6e:1e;
+}
+lease 172.17.0.100 {
+  starts 4 2021/07/01 11:00:00;
+  ends epoch 1625180400; # Thu Jul 01 23:00:00 2021
+  binding state active;
+  hardware ethernet 00:1c:42:b4:6e:1d;
+  client-hostname "ubuntu2";
+}
+lease 172.17.0.102 {
+  starts 4 2021/07/01 10:00:00;
+  ends 4 2021/07/01 11:00:00;
+  binding state free;
+  hardware ethernet 00:1c:42:b4:6e:1f;
+}
+`
+
+func TestParseIscLeases(t *testing.T) {
+	leases, err := ParseIscLeases(strings.NewReader(iscLeases))
+	require.Nil(t, err)
+	require.Len(t, leases, 2)
+
+	// Later entries win
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.17.0.100")), leases[0].IP)
+	require.Equal(t, dhcp4.StrToHardwareAddr("0:1c:42:b4:6e:1d"), leases[0].Mac)
+	require.Equal(t, "ubuntu2", leases[0].Hostname)
+	require.Equal(t, time.Unix(1625180400, 0), leases[0].Expiration)
+
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("172.17.0.101")), leases[1].IP)
+	require.True(t, leases[1].Permanent())
+
+	_, err = ParseIscLeases(strings.NewReader("lease 172.17.0.100 {\n  ends bogus;\n}\n"))
+	require.NotNil(t, err)
+
+	_, err = ParseIscLeases(strings.NewReader("lease 172.17.0.100 {\n"))
+	require.NotNil(t, err)
+}
+
+func TestImportLease(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+
+	lease1, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "host1")
+	require.Nil(t, err)
+
+	// Clashing IP
+	err = p.ImportLease(&pool.Lease{IP: lease1.IP, Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), Expiration: pool.NeverExpires})
+	require.NotNil(t, err)
+
+	// Clashing mac
+	err = p.ImportLease(&pool.Lease{IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15")), Mac: lease1.Mac, Expiration: pool.NeverExpires})
+	require.NotNil(t, err)
+
+	// Fine, and the IP isn't handed out again
+	err = p.ImportLease(&pool.Lease{IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.11")), Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), Expiration: pool.NeverExpires})
+	require.Nil(t, err)
+
+	lease3, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware(), "host3")
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.12")), lease3.IP)
+}
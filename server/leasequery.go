@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// DHCPLEASEQUERY (RFC 4388) responder, letting access concentrators ask who
+// holds an IP or mac address. Queries by client identifier aren't supported
+// and get DHCPLEASEUNKNOWN.
+//
+
+func (r *RequestHandler) HandleLeaseQuery() *dhcp4.DHCPMessage {
+	if r.header.GatewayAddr.Empty() {
+		log.Printf("Ignoring DHCPLEASEQUERY from %v without giaddr", r.hw.String())
+		return nil
+	}
+
+	// Query by IP
+	if !r.header.ClientAddr.Empty() {
+		ip := r.header.ClientAddr
+		log.Printf("DHCPLEASEQUERY from %v for %v", r.header.GatewayAddr.String(), ip.String())
+
+		if lease, ok := r.ctx.Pool.GetLeaseByIp(ip); ok && !lease.Expired() {
+			return r.sendLeaseQueryReply(dhcp4.DHCPLEASEACTIVE, &lease)
+		}
+		if r.ctx.Pool.Contains(ip) {
+			return r.sendLeaseQueryReply(dhcp4.DHCPLEASEUNASSIGNED, &pool.Lease{IP: ip})
+		}
+		return r.sendLeaseQueryReply(dhcp4.DHCPLEASEUNKNOWN, nil)
+	}
+
+	// Query by mac
+	if !r.hw.Empty() {
+		log.Printf("DHCPLEASEQUERY from %v for %v", r.header.GatewayAddr.String(), r.hw.String())
+
+		if lease, ok := r.ctx.Pool.GetLeaseByMac(r.hw); ok && !lease.Expired() {
+			return r.sendLeaseQueryReply(dhcp4.DHCPLEASEACTIVE, &lease)
+		}
+		return r.sendLeaseQueryReply(dhcp4.DHCPLEASEUNKNOWN, nil)
+	}
+
+	log.Printf("DHCPLEASEQUERY from %v by client identifier is unsupported", r.header.GatewayAddr.String())
+	return r.sendLeaseQueryReply(dhcp4.DHCPLEASEUNKNOWN, nil)
+}
+
+func (r *RequestHandler) sendLeaseQueryReply(op byte, lease *pool.Lease) *dhcp4.DHCPMessage {
+	reply := leaseQueryReply(r.header, r.ctx.Pool.MyIp, op, lease)
+	log.Printf("Sending %s for %v to %v", dhcp4.OpNames[op], reply.Header.ClientAddr.String(), r.header.GatewayAddr.String())
+	return reply
+}
+
+// A reply to the query with this header, with what we know of the lease if
+// it's active. Shared with bulk leasequery
+func leaseQueryReply(query *dhcp4.MessageHeader, myIp dhcp4.FixedV4, op byte, lease *pool.Lease) *dhcp4.DHCPMessage {
+	header := &dhcp4.MessageHeader{
+		Op:         dhcp4.BOOT_REPLY,
+		Identifier: query.Identifier,
+	}
+	header.CopyHardwareAddr(query)
+
+	options := dhcp4.NewOptions()
+	options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, op)
+	options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, myIp)
+
+	if lease != nil {
+		header.ClientAddr = lease.IP
+	}
+
+	if op == dhcp4.DHCPLEASEACTIVE {
+		header.SetHardware(lease.Mac)
+
+		if lease.Permanent() {
+			options.SetUint32(dhcp4.OPTION_LEASE_TIME, 0xffffffff)
+		} else {
+			options.SetUint32(dhcp4.OPTION_LEASE_TIME, uint32(time.Until(lease.Expiration).Seconds()))
+		}
+		if !lease.LastTransaction.IsZero() {
+			options.SetUint32(dhcp4.OPTION_LAST_TXN_TIME, uint32(time.Since(lease.LastTransaction).Seconds()))
+		}
+		if len(lease.RelayAgentInfo) > 0 {
+			options.Set(dhcp4.OPTION_RELAY_AGENT, lease.RelayAgentInfo)
+		}
+	}
+
+	return &dhcp4.DHCPMessage{Header: header, Options: options}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestLeaseQuery(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	giaddr := dhcp4.IpToFixedV4(net.ParseIP("10.0.1.1"))
+
+	// Lease a client's IP via a relay which adds option 82
+	message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	message.Options.Set(dhcp4.OPTION_RAPID_COMMIT, nil)
+	message.Options.Set(dhcp4.OPTION_RELAY_AGENT, []byte{1, 3, 'p', 'o', '1'})
+	pool.RapidCommit = true
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	leasedIp := response.Header.YourAddr
+
+	query := func(ip dhcp4.FixedV4, mac dhcp4.HardwareAddr) *dhcp4.DHCPMessage {
+		message := newTestMessage(dhcp4.DHCPLEASEQUERY, mac)
+		message.Header.ClientAddr = ip
+		message.Header.GatewayAddr = giaddr
+		return NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	}
+
+	// By IP
+	response = query(leasedIp, dhcp4.MacAddress{}.Hardware())
+	require.Equal(t, dhcp4.DHCPLEASEACTIVE, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, leasedIp, response.Header.ClientAddr)
+	require.Equal(t, mac, response.Header.Hardware())
+	leaseTime, ok := response.Options.GetUint32(dhcp4.OPTION_LEASE_TIME)
+	require.True(t, ok)
+	require.InDelta(t, 3600, leaseTime, 5)
+	_, ok = response.Options.GetUint32(dhcp4.OPTION_LAST_TXN_TIME)
+	require.True(t, ok)
+	option, ok := response.Options.Get(dhcp4.OPTION_RELAY_AGENT)
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 3, 'p', 'o', '1'}, option.Data)
+
+	// By mac
+	response = query(0, mac)
+	require.Equal(t, dhcp4.DHCPLEASEACTIVE, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, leasedIp, response.Header.ClientAddr)
+
+	// Free IP in our range
+	response = query(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15")), dhcp4.MacAddress{}.Hardware())
+	require.Equal(t, dhcp4.DHCPLEASEUNASSIGNED, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// IP we know nothing about, and unknown mac
+	response = query(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.100")), dhcp4.MacAddress{}.Hardware())
+	require.Equal(t, dhcp4.DHCPLEASEUNKNOWN, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	response = query(0, dhcp4.MacAddress{0, 0, 0, 0, 0, 9}.Hardware())
+	require.Equal(t, dhcp4.DHCPLEASEUNKNOWN, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// Queries must come via a relay
+	message = newTestMessage(dhcp4.DHCPLEASEQUERY, mac)
+	require.Nil(t, NewRequestHandler(message, &RequestContext{Pool: pool}).Handle())
+}
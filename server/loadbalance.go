@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// DHC load balancing (RFC 3074). Two servers answering on the same
+// segment each hash every client into one of 256 buckets and only answer
+// the clients whose bucket they own, so a broadcast DISCOVER gets one
+// OFFER instead of two.
+//
+
+const (
+	LOADBALANCE_PRIMARY   = "primary"
+	LOADBALANCE_SECONDARY = "secondary"
+)
+
+// Mixing table from RFC 3074 section 6
+var loadbalanceTable = [256]byte{
+	251, 175, 119, 215, 81, 14, 79, 191, 103, 49, 181, 143, 186, 157, 0,
+	232, 31, 32, 55, 60, 152, 58, 17, 237, 174, 70, 160, 144, 220, 90, 57,
+	223, 59, 3, 18, 140, 111, 166, 203, 196, 134, 243, 124, 95, 222, 179,
+	197, 65, 180, 48, 36, 15, 107, 46, 233, 130, 165, 30, 123, 161, 209, 23,
+	97, 16, 40, 91, 219, 61, 100, 10, 210, 109, 250, 127, 22, 138, 29, 108,
+	244, 67, 207, 9, 178, 204, 74, 98, 126, 249, 167, 116, 34, 77, 193,
+	200, 121, 5, 20, 113, 71, 35, 128, 13, 182, 94, 25, 226, 227, 199, 75,
+	27, 41, 245, 230, 224, 43, 225, 177, 26, 155, 150, 212, 142, 218, 115,
+	241, 73, 88, 105, 39, 114, 62, 255, 192, 201, 145, 214, 168, 158, 221,
+	148, 154, 122, 12, 84, 82, 163, 44, 139, 228, 236, 205, 242, 217, 11,
+	187, 146, 159, 64, 86, 239, 195, 42, 106, 198, 118, 112, 184, 172, 87,
+	2, 173, 117, 176, 229, 247, 253, 137, 185, 99, 164, 102, 147, 45, 66,
+	231, 52, 141, 211, 194, 206, 246, 238, 56, 110, 78, 248, 63, 240, 189,
+	93, 92, 51, 53, 183, 19, 171, 72, 50, 33, 104, 101, 69, 8, 252, 83, 120,
+	76, 135, 85, 54, 202, 125, 188, 213, 96, 235, 136, 208, 162, 129, 190,
+	132, 156, 38, 47, 1, 7, 254, 24, 4, 216, 131, 89, 21, 28, 133, 37, 153,
+	149, 80, 170, 68, 6, 169, 234, 151,
+}
+
+// Pearson hash of a client key as specified by the RFC
+func LoadBalanceHash(key []byte) byte {
+	hash := byte(len(key))
+	for i := len(key); i > 0; {
+		i--
+		hash = loadbalanceTable[hash^key[i]]
+	}
+	return hash
+}
+
+// The RFC hashes the client identifier if the client sent one, and
+// chaddr otherwise
+func LoadBalanceKey(message *dhcp4.DHCPMessage) []byte {
+	if option, ok := message.Options.Get(dhcp4.OPTION_CLIENT_ID); ok && len(option.Data) > 0 {
+		return option.Data
+	}
+	return message.Header.Hardware().Bytes()
+}
+
+type LoadBalancer struct {
+	// Buckets below split belong to the primary, the rest to the secondary
+	primary bool
+	split   int
+
+	// Answer regardless of bucket once the client has been trying this
+	// long, in case the other server is down
+	maxSecs uint16
+}
+
+func NewLoadBalancer(conf *LoadBalanceConf) (*LoadBalancer, error) {
+	lb := &LoadBalancer{
+		split:   128,
+		maxSecs: conf.MaxSecs,
+	}
+
+	switch conf.Role {
+	case LOADBALANCE_PRIMARY:
+		lb.primary = true
+	case LOADBALANCE_SECONDARY:
+	default:
+		return nil, fmt.Errorf("Unknown load balancing role '%v'", conf.Role)
+	}
+
+	if conf.Split != nil {
+		if *conf.Split < 0 || *conf.Split > 256 {
+			return nil, fmt.Errorf("Load balancing split %v is outside 0-256", *conf.Split)
+		}
+		lb.split = *conf.Split
+	}
+
+	return lb, nil
+}
+
+func (lb *LoadBalancer) Owns(bucket byte) bool {
+	return (int(bucket) < lb.split) == lb.primary
+}
+
+// Whether this server should answer the message. Only messages which
+// either server could answer are balanced; a REQUEST naming a server id
+// is for whichever server it names.
+func (lb *LoadBalancer) ShouldAnswer(message *dhcp4.DHCPMessage) bool {
+	switch message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) {
+	case dhcp4.DHCPDISCOVER:
+	case dhcp4.DHCPREQUEST:
+		if _, ok := message.Options.Get(dhcp4.OPTION_SERVER_ID); ok {
+			return true
+		}
+	default:
+		return true
+	}
+
+	if lb.maxSecs != 0 && message.Header.Secs >= lb.maxSecs {
+		return true
+	}
+
+	return lb.Owns(LoadBalanceHash(LoadBalanceKey(message)))
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestLoadBalanceHash(t *testing.T) {
+	seen := map[byte]struct{}{}
+	for _, value := range loadbalanceTable {
+		seen[value] = struct{}{}
+	}
+	require.Len(t, seen, 256)
+
+	require.Equal(t, byte(0), LoadBalanceHash(nil))
+	require.Equal(t, loadbalanceTable[1^0xaa], LoadBalanceHash([]byte{0xaa}))
+	require.Equal(t, loadbalanceTable[loadbalanceTable[2^0x02]^0x01], LoadBalanceHash([]byte{0x01, 0x02}))
+}
+
+func TestLoadBalancerShouldAnswer(t *testing.T) {
+	split := 128
+	primary, err := NewLoadBalancer(&LoadBalanceConf{Role: LOADBALANCE_PRIMARY, Split: &split, MaxSecs: 10})
+	require.Nil(t, err)
+	secondary, err := NewLoadBalancer(&LoadBalanceConf{Role: LOADBALANCE_SECONDARY})
+	require.Nil(t, err)
+
+	_, err = NewLoadBalancer(&LoadBalanceConf{Role: "tertiary"})
+	require.NotNil(t, err)
+
+	discover := func(mac dhcp4.HardwareAddr, secs uint16) *dhcp4.DHCPMessage {
+		message := &dhcp4.DHCPMessage{
+			Header:  &dhcp4.MessageHeader{Secs: secs},
+			Options: dhcp4.NewOptions(),
+		}
+		message.Header.SetHardware(mac)
+		message.Options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, dhcp4.DHCPDISCOVER)
+		return message
+	}
+
+	// Exactly one server answers each client, and they share the load
+	answered := map[bool]int{}
+	for i := 0; i < 256; i++ {
+		message := discover(dhcp4.MacAddress{0, 0x1c, 0x42, 0, 0, byte(i)}.Hardware(), 0)
+		require.NotEqual(t, primary.ShouldAnswer(message), secondary.ShouldAnswer(message))
+		answered[primary.ShouldAnswer(message)]++
+	}
+	require.InDelta(t, 128, answered[true], 40)
+
+	// Client ID takes precedence over chaddr
+	message := discover(dhcp4.MacAddress{}.Hardware(), 0)
+	message.Options.Set(dhcp4.OPTION_CLIENT_ID, []byte{1, 2, 3})
+	require.Equal(t, LoadBalanceHash([]byte{1, 2, 3}) < 128, primary.ShouldAnswer(message))
+
+	// Anyone can answer a client which has waited long enough
+	for i := 0; i < 256; i++ {
+		require.True(t, primary.ShouldAnswer(discover(dhcp4.MacAddress{0, 0, 0, 0, 0, byte(i)}.Hardware(), 10)))
+	}
+
+	// REQUESTs to a specific server aren't balanced
+	request := discover(dhcp4.MacAddress{}.Hardware(), 0)
+	request.Options.Override(dhcp4.OPTION_MESSAGE_TYPE, []byte{dhcp4.DHCPREQUEST})
+	request.Options.Set(dhcp4.OPTION_SERVER_ID, []byte{10, 0, 0, 1})
+	require.True(t, primary.ShouldAnswer(request))
+	require.True(t, secondary.ShouldAnswer(request))
+}
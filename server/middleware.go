@@ -0,0 +1,158 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Handling a request goes through a Handler, which by default is our own
+// allocation logic. Embedders can replace it, or wrap it in middleware for
+// logging, rate limiting, classifying clients and so on, without touching
+// RequestHandler. Middleware sees the request once it has a pool, after the
+// relay, auth, load balancing and starvation checks.
+//
+
+// Answers a request, returning the response to send, or nil to stay quiet.
+// Both messages are reused once the request is done with, like for hooks
+type Handler interface {
+	ServeDHCP(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage
+}
+
+type HandlerFunc func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage
+
+func (f HandlerFunc) ServeDHCP(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+	return f(ctx, request)
+}
+
+// Wraps a handler, e.g. to look at or change the request or response, or to
+// not call the next handler at all
+type Middleware func(next Handler) Handler
+
+// Allocating from the request's pool
+var DefaultHandler Handler = HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+	return NewRequestHandler(request, ctx).Handle()
+})
+
+// Wrap handler in middleware, the first given being outermost
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Log every request with what we answered and how long it took
+func LoggingMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		start := time.Now()
+		response := next.ServeDHCP(ctx, request)
+		took := time.Since(start)
+
+		op := "BOOTREQUEST"
+		if code := request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE); code != 0 {
+			op = dhcp4.OpNames[code]
+		}
+		switch {
+		case response == nil:
+			log.Printf("%v from %v on %v: no response (%v)", op, request.Header.Hardware().String(), ctx.Interface, took)
+		case response.Header.YourAddr.Empty():
+			log.Printf("%v from %v on %v: %v (%v)", op, request.Header.Hardware().String(), ctx.Interface,
+				dhcp4.OpNames[response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)], took)
+		default:
+			log.Printf("%v from %v on %v: %v of %v (%v)", op, request.Header.Hardware().String(), ctx.Interface,
+				dhcp4.OpNames[response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)], response.Header.YourAddr.String(), took)
+		}
+		return response
+	})
+}
+
+// Decides which class a client belongs in, or "" for none
+type Classifier func(ctx *RequestContext, request *dhcp4.DHCPMessage) string
+
+// Set the request context's class for handlers further in
+func ClassifyMiddleware(classify Classifier) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+			ctx.Class = classify(ctx, request)
+			return next.ServeDHCP(ctx, request)
+		})
+	}
+}
+
+// Forget clients whose buckets have been full this long
+const rateLimitIdle = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	m         sync.Mutex
+	buckets   map[dhcp4.HardwareAddr]*tokenBucket
+	lastSweep time.Time
+}
+
+// Whether mac still has a request to spend
+func (l *rateLimiter) allow(mac dhcp4.HardwareAddr, now time.Time) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitIdle {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[mac]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[mac] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Drop buckets which would have filled up again, so made up macs can't
+// grow the map forever
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for mac, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst && now.Sub(b.last) >= rateLimitIdle {
+			delete(l.buckets, mac)
+		}
+	}
+}
+
+// Ignore clients sending more than rate requests a second, after a burst of
+// up to burst
+func RateLimitMiddleware(rate float64, burst int) Middleware {
+	limiter := &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[dhcp4.HardwareAddr]*tokenBucket{},
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+			if !limiter.allow(request.Header.Hardware(), time.Now()) {
+				ctx.Tracef("Ignoring as %v is over its rate limit", request.Header.Hardware().String())
+				return nil
+			}
+			return next.ServeDHCP(ctx, request)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+				order = append(order, name)
+				return next.ServeDHCP(ctx, request)
+			})
+		}
+	}
+
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	handler := Chain(DefaultHandler, trace("outer"), LoggingMiddleware, trace("inner"))
+
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	response := handler.ServeDHCP(&RequestContext{Pool: p}, message)
+	require.Equal(t, []string{"outer", "inner"}, order)
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+}
+
+func TestCustomHandler(t *testing.T) {
+	// Allocation logic of our own, falling back to the pool for everyone else
+	special := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	handler := HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		if request.Header.Hardware() == special {
+			return nil
+		}
+		return DefaultHandler.ServeDHCP(ctx, request)
+	})
+
+	app := NewApp()
+	app.SetHandler(handler)
+	app.Use(ClassifyMiddleware(func(ctx *RequestContext, request *dhcp4.DHCPMessage) string {
+		return ctx.VendorClass
+	}))
+
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+
+	message := newTestMessage(dhcp4.DHCPDISCOVER, special)
+	require.Nil(t, app.serve.ServeDHCP(&RequestContext{Pool: p}, message))
+
+	ctx := &RequestContext{Pool: p, VendorClass: "MSFT 5.0"}
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	response := app.serve.ServeDHCP(ctx, message)
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, "MSFT 5.0", ctx.Class)
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := &rateLimiter{rate: 1, burst: 2, buckets: map[dhcp4.HardwareAddr]*tokenBucket{}}
+	mac1 := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	mac2 := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	now := time.Now()
+
+	// A burst, then nothing until the bucket refills
+	require.True(t, limiter.allow(mac1, now))
+	require.True(t, limiter.allow(mac1, now))
+	require.False(t, limiter.allow(mac1, now))
+	require.True(t, limiter.allow(mac2, now))
+	require.False(t, limiter.allow(mac1, now.Add(500*time.Millisecond)))
+	require.True(t, limiter.allow(mac1, now.Add(time.Second)))
+
+	// Idle clients are forgotten
+	require.True(t, limiter.allow(mac2, now.Add(2*rateLimitIdle)))
+	require.Len(t, limiter.buckets, 1)
+
+	// Requests over the limit go unanswered
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	handler := Chain(DefaultHandler, RateLimitMiddleware(1, 1))
+	message := newTestMessage(dhcp4.DHCPDISCOVER, mac1)
+	require.NotNil(t, handler.ServeDHCP(&RequestContext{Pool: p}, message))
+	require.Nil(t, handler.ServeDHCP(&RequestContext{Pool: p}, message))
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"log"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Manufacturer Usage Description URLs (option 161) IoT clients send, kept
+// on their leases and given in events, so security tooling can fetch the
+// profile of what each device needs to talk to and lock it down to that.
+// URLs that aren't https, which RFC 8520 requires, are logged and dropped.
+//
+
+// The client's MUD URL, if it sent a valid one
+func mudUrl(options *dhcp4.Options) string {
+	option, ok := options.Get(dhcp4.OPTION_MUD_URL)
+	if !ok {
+		return ""
+	}
+	mud, err := dhcp4.ParseMudUrl(option.Data)
+	if err != nil {
+		log.Printf("Ignoring MUD URL: %v", err)
+		return ""
+	}
+	return mud
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestMudUrl(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "iot"
+	pool.Network = net.ParseIP("10.0.0.0")
+	pool.LeaseTime = time.Hour
+	app := newTestApp(t, pool)
+
+	const mud = "https://things.example.com/lightbulb2000"
+	lease := func(mac dhcp4.HardwareAddr, url string) *dhcp4.DHCPMessage {
+		discover := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		offer := NewRequestHandler(discover, &RequestContext{Pool: pool}).Handle()
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+		request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, pool.MyIp)
+		if url != "" {
+			request.Options.SetString(dhcp4.OPTION_MUD_URL, url)
+		}
+		ack := NewRequestHandler(request, &RequestContext{Pool: pool}).Handle()
+		require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+		record, ok := NewResponseEventRecord(&RequestContext{Pool: pool}, request, ack)
+		require.True(t, ok)
+		if url == mud {
+			require.Equal(t, mud, record.MudUrl)
+		} else {
+			require.Empty(t, record.MudUrl)
+		}
+		return ack
+	}
+	bulb := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease(bulb, mud)
+	lease(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "")
+	lease(dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware(), "http://things.example.com/insecure")
+
+	held, ok := pool.GetLeaseByMac(bulb)
+	require.True(t, ok)
+	require.Equal(t, mud, held.MudUrl)
+
+	rec := httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mud", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var leases map[string][]adminMudLease
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &leases))
+	require.Equal(t, []adminMudLease{{bulb.String(), held.IP.String(), mud}}, leases["iot"])
+
+	// Renewing without it clears it
+	lease(bulb, "")
+	held, _ = pool.GetLeaseByMac(bulb)
+	require.Empty(t, held.MudUrl)
+}
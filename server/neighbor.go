@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Unicasting replies to clients which don't have their IP configured yet,
+// as ISC dhcpd does on Linux. They can't answer ARP for an IP they don't
+// have, so we add a neighbor entry mapping it to their hardware address
+// ourselves, like `ip neigh replace` would, over netlink. That takes
+// CAP_NET_ADMIN, which we no longer have once privileges are dropped, so if
+// it fails we broadcast as before.
+//
+
+type NeighborTable struct {
+	m   sync.Mutex
+	fd  int
+	seq uint32
+}
+
+func NewNeighborTable() (*NeighborTable, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("Failed opening netlink socket: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("Failed binding netlink socket: %v", err)
+	}
+
+	// Never hold up replies for long waiting on the kernel
+	timeout := unix.NsecToTimeval(time.Second.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &NeighborTable{fd: fd}, nil
+}
+
+// Add or replace the neighbor entry for ip on an interface
+func (n *NeighborTable) Replace(ifIndex int, ip dhcp4.FixedV4, hw dhcp4.HardwareAddr) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.seq++
+	if err := unix.Sendto(n.fd, neighborMessage(n.seq, ifIndex, ip, hw), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		size, _, err := unix.Recvfrom(n.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		done, err := parseNeighborAck(buf[:size], n.seq)
+		if done {
+			return err
+		}
+	}
+}
+
+func (n *NeighborTable) Close() error {
+	return unix.Close(n.fd)
+}
+
+// RTM_NEWNEIGH request for a reachable entry, asking for an ack
+func neighborMessage(seq uint32, ifIndex int, ip dhcp4.FixedV4, hw dhcp4.HardwareAddr) []byte {
+	attr := func(kind uint16, data []byte) []byte {
+		b := make([]byte, (unix.SizeofRtAttr+len(data)+3)&^3)
+		binary.NativeEndian.PutUint16(b[0:], uint16(unix.SizeofRtAttr+len(data)))
+		binary.NativeEndian.PutUint16(b[2:], kind)
+		copy(b[unix.SizeofRtAttr:], data)
+		return b
+	}
+	dst := make([]byte, 4)
+	binary.BigEndian.PutUint32(dst, uint32(ip))
+	attrs := append(attr(unix.NDA_DST, dst), attr(unix.NDA_LLADDR, hw.Bytes())...)
+
+	b := make([]byte, unix.SizeofNlMsghdr+unix.SizeofNdMsg, unix.SizeofNlMsghdr+unix.SizeofNdMsg+len(attrs))
+	binary.NativeEndian.PutUint32(b[0:], uint32(cap(b)))
+	binary.NativeEndian.PutUint16(b[4:], unix.RTM_NEWNEIGH)
+	binary.NativeEndian.PutUint16(b[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+	binary.NativeEndian.PutUint32(b[8:], seq)
+
+	nd := b[unix.SizeofNlMsghdr:]
+	nd[0] = unix.AF_INET
+	binary.NativeEndian.PutUint32(nd[4:], uint32(ifIndex))
+	binary.NativeEndian.PutUint16(nd[8:], unix.NUD_REACHABLE)
+	return append(b, attrs...)
+}
+
+// Whether a reply from the kernel is the ack for our request, and if so
+// the error it reports, if any
+func parseNeighborAck(b []byte, seq uint32) (bool, error) {
+	for len(b) >= unix.SizeofNlMsghdr {
+		size := int(binary.NativeEndian.Uint32(b[0:]))
+		if size < unix.SizeofNlMsghdr || size > len(b) {
+			return true, errors.New("Truncated netlink message")
+		}
+		kind := binary.NativeEndian.Uint16(b[4:])
+		if kind == unix.NLMSG_ERROR && binary.NativeEndian.Uint32(b[8:]) == seq {
+			if size < unix.SizeofNlMsghdr+4 {
+				return true, errors.New("Truncated netlink error")
+			}
+			if code := int32(binary.NativeEndian.Uint32(b[unix.SizeofNlMsghdr:])); code != 0 {
+				return true, syscall.Errno(-code)
+			}
+			return true, nil
+		}
+		b = b[(size+3)&^3:]
+	}
+	return false, nil
+}
+
+// Whether a reply can go unicast to the IP it's giving the client rather
+// than being broadcast: the client is on our segment, hasn't asked for a
+// broadcast, and has an ethernet address we can add a neighbor entry for
+func (r *RequestHandler) replyToYourAddr(response *dhcp4.DHCPMessage) bool {
+	if r.ctx.Relayed() || r.ctx.ReplyIfIndex == 0 || !r.header.ClientAddr.Empty() || r.header.Flags&dhcp4.FLAG_BROADCAST != 0 {
+		return false
+	}
+	if response.Header.YourAddr.Empty() || response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPNAK {
+		return false
+	}
+	_, ok := r.hw.Mac()
+	return ok && r.hw.Type() == dhcp4.HTYPE_ETHERNET
+}
+
+// Unicast a reply to the IP it gives the client, once the kernel knows
+// where to find it, or broadcast it if we can't tell the kernel
+func (r *RequestHandler) sendMessageNeighbor(message *dhcp4.DHCPMessage, neighbors *NeighborTable, localSocket PacketConn) {
+	if err := neighbors.Replace(r.ctx.ReplyIfIndex, message.Header.YourAddr, r.hw); err != nil {
+		log.Printf("Failed adding neighbor entry for %v at %v, so broadcasting: %v", r.hw.String(), message.Header.YourAddr.String(), err)
+		r.sendMessageBroadcast(message, localSocket)
+		return
+	}
+	r.sendMessageUnicast(message, message.Header.YourAddr, 68, localSocket)
+}
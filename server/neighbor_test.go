@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestNeighborMessage(t *testing.T) {
+	hw := dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware()
+	b := neighborMessage(7, 3, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), hw)
+
+	require.Len(t, b, unix.SizeofNlMsghdr+unix.SizeofNdMsg+8+12)
+	require.Equal(t, uint32(len(b)), binary.NativeEndian.Uint32(b[0:]))
+	require.Equal(t, uint16(unix.RTM_NEWNEIGH), binary.NativeEndian.Uint16(b[4:]))
+	require.Equal(t, uint32(7), binary.NativeEndian.Uint32(b[8:]))
+
+	nd := b[unix.SizeofNlMsghdr:]
+	require.Equal(t, byte(unix.AF_INET), nd[0])
+	require.Equal(t, uint32(3), binary.NativeEndian.Uint32(nd[4:]))
+	require.Equal(t, uint16(unix.NUD_REACHABLE), binary.NativeEndian.Uint16(nd[8:]))
+
+	attrs := nd[unix.SizeofNdMsg:]
+	require.Equal(t, uint16(unix.NDA_DST), binary.NativeEndian.Uint16(attrs[2:]))
+	require.Equal(t, []byte{10, 0, 0, 10}, attrs[4:8])
+	require.Equal(t, uint16(10), binary.NativeEndian.Uint16(attrs[8:]))
+	require.Equal(t, uint16(unix.NDA_LLADDR), binary.NativeEndian.Uint16(attrs[10:]))
+	require.Equal(t, hw.Bytes(), attrs[12:18])
+}
+
+func TestParseNeighborAck(t *testing.T) {
+	ack := func(seq uint32, code int32) []byte {
+		b := make([]byte, unix.SizeofNlMsghdr+unix.SizeofNlMsgerr)
+		binary.NativeEndian.PutUint32(b[0:], uint32(len(b)))
+		binary.NativeEndian.PutUint16(b[4:], unix.NLMSG_ERROR)
+		binary.NativeEndian.PutUint32(b[8:], seq)
+		binary.NativeEndian.PutUint32(b[unix.SizeofNlMsghdr:], uint32(code))
+		return b
+	}
+
+	done, err := parseNeighborAck(ack(1, 0), 2)
+	require.False(t, done)
+	done, err = parseNeighborAck(append(ack(1, 0), ack(2, 0)...), 2)
+	require.True(t, done)
+	require.Nil(t, err)
+	done, err = parseNeighborAck(ack(2, -int32(syscall.EPERM)), 2)
+	require.True(t, done)
+	require.Equal(t, syscall.EPERM, err)
+}
+
+func TestReplyToYourAddr(t *testing.T) {
+	p := newTestPool()
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	ctx := &RequestContext{Pool: p, ReplyIfIndex: 1}
+	request := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	offer := NewRequestHandler(request, ctx).Handle()
+	require.True(t, NewRequestHandler(request, ctx).replyToYourAddr(offer))
+
+	// Not for clients asking for a broadcast, or relayed ones
+	request.Header.Flags = dhcp4.FLAG_BROADCAST
+	require.False(t, NewRequestHandler(request, ctx).replyToYourAddr(offer))
+	request.Header.Flags = 0
+	request.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.1.1"))
+	require.False(t, NewRequestHandler(request, &RequestContext{Pool: p, RelayAddr: request.Header.GatewayAddr, ReplyIfIndex: 1}).replyToYourAddr(offer))
+
+	// Nor for hardware we can't add a neighbor entry for
+	request = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.NewHardwareAddr(dhcp4.HTYPE_INFINIBAND, make([]byte, 20)))
+	require.False(t, NewRequestHandler(request, ctx).replyToYourAddr(offer))
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"log"
+
+	"mygodhcpd/pool"
+)
+
+//
+// Keeping clients to a single lease across all our pools. A client which
+// moves subnets gets a new lease in the pool it's now on, and the one it
+// left behind would otherwise stay allocated until it expired, so as soon
+// as a new lease is taken up the client's leases in every other pool are
+// released.
+//
+
+// Pool observer releasing the other leases of a client given a new one
+func (a *App) releaseOtherLeases(event pool.LeaseEvent) {
+	if event.Kind != pool.LEASE_CREATED {
+		return
+	}
+	for _, p := range a.pools() {
+		if p == event.Pool {
+			continue
+		}
+		if old, ok := p.ReleaseLeaseByMac(event.Lease.Mac); ok {
+			log.Printf("Released %v in pool %v, as %v now has %v in pool %v", old.IP.String(), p.Name, event.Lease.Mac.String(), event.Lease.IP.String(), event.Pool.Name)
+		}
+	}
+}
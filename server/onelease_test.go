@@ -0,0 +1,54 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestOneLeasePerClient(t *testing.T) {
+	app := NewApp()
+	first := newTestPool()
+	first.Name = "first"
+	first.Network = net.ParseIP("10.0.0.0")
+	first.LeaseTime = time.Hour
+	second := newTestPool()
+	second.Name = "second"
+	second.Network = net.ParseIP("10.0.1.0")
+	second.Start = net.ParseIP("10.0.1.10")
+	second.End = net.ParseIP("10.0.1.20")
+	second.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.1.254"))
+	second.LeaseTime = time.Hour
+	for _, p := range []*pool.Pool{first, second} {
+		require.Nil(t, app.insertPool(p))
+		p.AddObserver(app.releaseOtherLeases)
+	}
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	_, err := first.GetNextLease(mac, "")
+	require.Nil(t, err)
+	_, err = first.GetNextLease(other, "")
+	require.Nil(t, err)
+
+	// Only an offer in the new pool, which the client may not take up
+	_, err = second.OfferLease(mac, "")
+	require.Nil(t, err)
+	_, ok := first.GetLeaseByMac(mac)
+	require.True(t, ok)
+
+	// Once it does, its old lease goes, and nobody else's
+	_, ok = second.TouchLease(mac, time.Hour)
+	require.True(t, ok)
+	_, ok = first.GetLeaseByMac(mac)
+	require.False(t, ok)
+	_, ok = first.GetLeaseByMac(other)
+	require.True(t, ok)
+	_, ok = second.GetLeaseByMac(mac)
+	require.True(t, ok)
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Export a trace of how each request was handled to an OpenTelemetry
+// collector, using OTLP over HTTP with json encoding. Every request becomes
+// a span with a child for each stage we marked along the way (parse, pool
+// lookup, allocation, encode, send), so tail latency and lock contention
+// can be looked at alongside everything else in the observability stack.
+//
+
+const (
+	otelSpanKindInternal = 1
+	otelSpanKindServer   = 2
+
+	otelBatchSize     = 512
+	otelFlushInterval = 5 * time.Second
+)
+
+// Child span names, by the mark ending the stage
+var otelStageNames = map[string]string{
+	"parsed":  "parse",
+	"pool":    "pool lookup",
+	"handled": "allocation",
+	"encoded": "encode",
+	"sent":    "send",
+}
+
+type OtelAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type OtelSpan struct {
+	TraceId      string          `json:"traceId"`
+	SpanId       string          `json:"spanId"`
+	ParentSpanId string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []OtelAttribute `json:"attributes,omitempty"`
+}
+
+func otelString(key, value string) OtelAttribute {
+	return OtelAttribute{key, map[string]interface{}{"stringValue": value}}
+}
+
+// OTLP json carries 64 bit ints as strings
+func otelInt(key string, value int64) OtelAttribute {
+	return OtelAttribute{key, map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+func otelTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otelId(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type OtelExporter struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	client   *http.Client
+	done     chan struct{}
+
+	// Closed once we've been closed, as handlers may still be running if
+	// shutting down timed out waiting for them
+	m      sync.Mutex
+	queue  chan []OtelSpan
+	closed bool
+}
+
+func NewOtelExporter(conf *OtelConf) (*OtelExporter, error) {
+	if conf.Endpoint == "" {
+		return nil, errors.New("OpenTelemetry export needs an endpoint")
+	}
+
+	o := &OtelExporter{
+		endpoint: conf.Endpoint,
+		service:  conf.Service,
+		headers:  conf.Headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan []OtelSpan, 1024),
+		done:     make(chan struct{}),
+	}
+	if o.service == "" {
+		o.service = "golang-dhcpd"
+	}
+	return o, nil
+}
+
+// The request's span, followed by one for each stage
+func NewOtelSpans(ctx *RequestContext, request, response *dhcp4.DHCPMessage) []OtelSpan {
+	if len(ctx.Marks) == 0 {
+		return nil
+	}
+
+	op := request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+	name := "BOOTREQUEST"
+	if op != 0 {
+		name = dhcp4.OpNames[op]
+	}
+
+	root := OtelSpan{
+		TraceId: otelId(16),
+		SpanId:  otelId(8),
+		Name:    name,
+		Kind:    otelSpanKindServer,
+		Start:   otelTime(ctx.Marks[0].Time),
+		End:     otelTime(ctx.Marks[len(ctx.Marks)-1].Time),
+		Attributes: []OtelAttribute{
+			otelString("dhcp.message_type", name),
+			otelString("dhcp.xid", fmt.Sprintf("%08x", request.Header.Identifier)),
+			otelString("dhcp.client.mac", request.Header.Hardware().String()),
+			otelString("network.interface.name", ctx.Interface),
+		},
+	}
+	if ctx.Pool != nil {
+		root.Attributes = append(root.Attributes, otelString("dhcp.pool", ctx.Pool.Name))
+	}
+	if ctx.Relayed() {
+		root.Attributes = append(root.Attributes,
+			otelString("dhcp.relay", ctx.RelayAddr.String()),
+			otelInt("dhcp.hops", int64(ctx.Hops)))
+	}
+	if response != nil {
+		if op := response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE); op != 0 {
+			root.Attributes = append(root.Attributes, otelString("dhcp.response_type", dhcp4.OpNames[op]))
+		}
+		if !response.Header.YourAddr.Empty() {
+			root.Attributes = append(root.Attributes, otelString("dhcp.yiaddr", response.Header.YourAddr.String()))
+		}
+	}
+
+	spans := []OtelSpan{root}
+	for i := 1; i < len(ctx.Marks); i++ {
+		stage := ctx.Marks[i].Stage
+		if name, ok := otelStageNames[stage]; ok {
+			stage = name
+		}
+		spans = append(spans, OtelSpan{
+			TraceId:      root.TraceId,
+			SpanId:       otelId(8),
+			ParentSpanId: root.SpanId,
+			Name:         stage,
+			Kind:         otelSpanKindInternal,
+			Start:        otelTime(ctx.Marks[i-1].Time),
+			End:          otelTime(ctx.Marks[i].Time),
+		})
+	}
+	return spans
+}
+
+// Request hook
+func (o *OtelExporter) Hook(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+	spans := NewOtelSpans(ctx, request, response)
+	if spans == nil {
+		return
+	}
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.closed {
+		return
+	}
+	select {
+	case o.queue <- spans:
+	default:
+		log.Printf("OpenTelemetry queue for %v full; dropping spans", o.endpoint)
+	}
+}
+
+// Send spans in batches, at least every few seconds
+func (o *OtelExporter) Run() {
+	ticker := time.NewTicker(otelFlushInterval)
+	defer ticker.Stop()
+	defer close(o.done)
+
+	var batch []OtelSpan
+	for {
+		select {
+		case spans, ok := <-o.queue:
+			if !ok {
+				o.flush(batch)
+				return
+			}
+			batch = append(batch, spans...)
+			if len(batch) < otelBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		o.flush(batch)
+		batch = nil
+	}
+}
+
+// Send anything queued. Spans from later calls to Hook are dropped
+func (o *OtelExporter) Close() {
+	o.m.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+	o.m.Unlock()
+	<-o.done
+}
+
+func (o *OtelExporter) flush(spans []OtelSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	if err := o.export(spans); err != nil {
+		log.Printf("Failed exporting %v spans to %v: %v", len(spans), o.endpoint, err)
+	}
+}
+
+func (o *OtelExporter) export(spans []OtelSpan) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []OtelAttribute{otelString("service.name", o.service)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "golang-dhcpd"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Got %v", resp.Status)
+	}
+	return nil
+}
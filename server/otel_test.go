@@ -0,0 +1,89 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestOtelSpans(t *testing.T) {
+	pool := newTestPool()
+	pool.Name = "test"
+	pool.LeaseTime = time.Hour
+
+	ctx := NewRequestContext("eth0", nil)
+	ctx.Pool = pool
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	ctx.Populate(message)
+	ctx.Mark("parsed")
+	ctx.Mark("pool")
+	response := NewRequestHandler(message, ctx).Handle()
+	ctx.Mark("handled")
+
+	spans := NewOtelSpans(ctx, message, response)
+	require.Len(t, spans, 4)
+
+	root := spans[0]
+	require.Equal(t, "DHCPDISCOVER", root.Name)
+	require.Len(t, root.TraceId, 32)
+	require.Len(t, root.SpanId, 16)
+	require.Equal(t, otelSpanKindServer, root.Kind)
+	require.Contains(t, root.Attributes, otelString("dhcp.pool", "test"))
+	require.Contains(t, root.Attributes, otelString("dhcp.response_type", "DHCPOFFER"))
+	require.Contains(t, root.Attributes, otelString("dhcp.yiaddr", "10.0.0.10"))
+
+	for i, name := range []string{"parse", "pool lookup", "allocation"} {
+		span := spans[i+1]
+		require.Equal(t, name, span.Name)
+		require.Equal(t, root.TraceId, span.TraceId)
+		require.Equal(t, root.SpanId, span.ParentSpanId)
+		require.Equal(t, otelTime(ctx.Marks[i].Time), span.Start)
+		require.Equal(t, otelTime(ctx.Marks[i+1].Time), span.End)
+	}
+	require.Equal(t, root.End, spans[3].End)
+}
+
+func TestOtelExport(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		payload := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+	}))
+	defer server.Close()
+
+	_, err := NewOtelExporter(&OtelConf{})
+	require.NotNil(t, err)
+
+	exporter, err := NewOtelExporter(&OtelConf{Endpoint: server.URL, Headers: map[string]string{"X-Api-Key": "secret"}})
+	require.Nil(t, err)
+
+	ctx := NewRequestContext("eth0", nil)
+	ctx.Mark("parsed")
+	exporter.Hook(ctx, newTestMessage(dhcp4.DHCPINFORM, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()), nil)
+	go exporter.Run()
+	exporter.Close()
+
+	// Handlers still running once closed are ignored
+	exporter.Hook(ctx, newTestMessage(dhcp4.DHCPINFORM, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()), nil)
+	exporter.Close()
+
+	payload := <-received
+	resource := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	attribute := resource["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "service.name", attribute["key"])
+	require.Equal(t, "golang-dhcpd", attribute["value"].(map[string]interface{})["stringValue"])
+
+	spans := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+	require.Equal(t, "DHCPINFORM", spans[0].(map[string]interface{})["name"])
+	require.Equal(t, "parse", spans[1].(map[string]interface{})["name"])
+}
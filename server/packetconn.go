@@ -0,0 +1,262 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+//
+// Requests are received on, and replies sent through, a PacketConn. In
+// production that's a UDP socket bound to port 67, but tests and embedders
+// can use a MemoryConn instead, to run whole DISCOVER, OFFER, REQUEST, ACK
+// exchanges without real sockets or root.
+//
+
+type PacketConn interface {
+	// Read up to len(ms) packets, blocking until there's at least one. Each
+	// message's OOB holds the IP_PKTINFO control message telling us which
+	// interface the packet arrived on
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+
+	WriteTo(b []byte, addr net.Addr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// Conns which can also send a packet out of a given interface, and from a
+// given source address, with IP_PKTINFO. Replies to clients on a VLAN
+// sub-interface need both to leave by the right VLAN with the right address
+type PacketInfoWriter interface {
+	WriteToWithInfo(b []byte, info *ipv4.ControlMessage, addr net.Addr) (int, error)
+}
+
+// Most replies queued at once on a UDPPacketConn, and sent in one go
+const sendBatch = 32
+
+// A UDP socket, read and written in batches with recvmmsg and sendmmsg
+// where they're available. Replies are queued for a writer of the socket's
+// own, which sends whatever has built up since it last did, so during a
+// flood of requests it takes a syscall per batch rather than per reply
+type UDPPacketConn struct {
+	*net.UDPConn
+	batch *ipv4.PacketConn
+
+	queue  chan ipv4.Message
+	once   sync.Once
+	closed chan struct{}
+}
+
+func NewUDPPacketConn(conn *net.UDPConn) *UDPPacketConn {
+	c := &UDPPacketConn{
+		UDPConn: conn,
+		batch:   ipv4.NewPacketConn(conn),
+		queue:   make(chan ipv4.Message, sendBatch),
+		closed:  make(chan struct{}),
+	}
+	go c.write()
+	return c
+}
+
+func (c *UDPPacketConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	return c.batch.ReadBatch(ms, flags)
+}
+
+func (c *UDPPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.WriteToWithInfo(b, nil, addr)
+}
+
+// Queue a packet to be sent. Errors sending it are logged rather than
+// returned, as it's sent after we return
+func (c *UDPPacketConn) WriteToWithInfo(b []byte, info *ipv4.ControlMessage, addr net.Addr) (int, error) {
+	// Copied, as callers reuse their buffers once we return
+	message := ipv4.Message{Buffers: [][]byte{append([]byte(nil), b...)}, Addr: addr}
+
+	// Always with a control message, if only one leaving the interface and
+	// source to the kernel, as x/net otherwise leaves in place that of
+	// whichever message last used its pooled headers
+	if info == nil {
+		info = &ipv4.ControlMessage{Src: net.IPv4zero}
+	}
+	message.OOB = info.Marshal()
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	select {
+	case c.queue <- message:
+		return len(b), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// Send queued packets until closed
+func (c *UDPPacketConn) write() {
+	messages := make([]ipv4.Message, 0, sendBatch)
+	for {
+		select {
+		case message := <-c.queue:
+			messages = append(messages[:0], message)
+		case <-c.closed:
+			return
+		}
+
+		// Whatever else is already waiting
+	drain:
+		for len(messages) < sendBatch {
+			select {
+			case message := <-c.queue:
+				messages = append(messages, message)
+			default:
+				break drain
+			}
+		}
+
+		for sent := 0; sent < len(messages); {
+			count, err := c.batch.WriteBatch(messages[sent:], 0)
+			if count > 0 {
+				sent += count
+			}
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				// Skipping the one which failed
+				if sent < len(messages) {
+					log.Printf("Failed sending to %v: %v", messages[sent].Addr, err)
+				}
+				sent++
+			}
+		}
+	}
+}
+
+func (c *UDPPacketConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.UDPConn.Close()
+}
+
+// How many packets a MemoryConn holds in each direction
+const memoryConnQueue = 64
+
+type memoryPacket struct {
+	data []byte
+	addr *net.UDPAddr
+
+	// Interface and source address a reply was sent with, if any
+	info *ipv4.ControlMessage
+}
+
+// Connection in memory, with packets sent to the server by Send appearing to
+// arrive on the interface with index ifIndex, and the server's replies
+// picked up by Receive
+type MemoryConn struct {
+	ifIndex int
+	in      chan memoryPacket
+	out     chan memoryPacket
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func NewMemoryConn(ifIndex int) *MemoryConn {
+	return &MemoryConn{
+		ifIndex: ifIndex,
+		in:      make(chan memoryPacket, memoryConnQueue),
+		out:     make(chan memoryPacket, memoryConnQueue),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Deliver a packet to the server, as if sent from from
+func (c *MemoryConn) Send(data []byte, from *net.UDPAddr) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	default:
+	}
+	select {
+	case c.in <- memoryPacket{append([]byte(nil), data...), from, nil}:
+		return nil
+	default:
+		return errors.New("Too many packets waiting to be read")
+	}
+}
+
+// Next packet the server sent, and where to, waiting up to timeout
+func (c *MemoryConn) Receive(timeout time.Duration) ([]byte, *net.UDPAddr, error) {
+	data, addr, _, err := c.ReceiveWithInfo(timeout)
+	return data, addr, err
+}
+
+// Like Receive, but also giving the interface and source address the packet
+// was sent with, if the server chose them
+func (c *MemoryConn) ReceiveWithInfo(timeout time.Duration) ([]byte, *net.UDPAddr, *ipv4.ControlMessage, error) {
+	select {
+	case p := <-c.out:
+		return p.data, p.addr, p.info, nil
+	case <-time.After(timeout):
+		return nil, nil, nil, errors.New("Timed out")
+	}
+}
+
+func (c *MemoryConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	var p memoryPacket
+	select {
+	case p = <-c.in:
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+
+	oob := (&ipv4.ControlMessage{IfIndex: c.ifIndex}).Marshal()
+	count := 0
+	for {
+		m := &ms[count]
+		m.N = copy(m.Buffers[0], p.data)
+		m.NN = copy(m.OOB, oob)
+		m.Addr = p.addr
+		count++
+
+		// Whatever else is already waiting
+		if count == len(ms) {
+			return count, nil
+		}
+		select {
+		case p = <-c.in:
+		default:
+			return count, nil
+		}
+	}
+}
+
+func (c *MemoryConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.WriteToWithInfo(b, nil, addr)
+}
+
+func (c *MemoryConn) WriteToWithInfo(b []byte, info *ipv4.ControlMessage, addr net.Addr) (int, error) {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("Not a UDP address")
+	}
+	select {
+	case c.out <- memoryPacket{append([]byte(nil), b...), udp, info}:
+		return len(b), nil
+	default:
+		return 0, errors.New("Too many packets waiting to be received")
+	}
+}
+
+func (c *MemoryConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 67}
+}
+
+func (c *MemoryConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+// App serving a pool on the loopback interface through a MemoryConn, until
+// the test ends
+func newMemoryApp(t *testing.T) (*App, *MemoryConn, *pool.Pool) {
+	// Pools are found by the receiving interface's addresses
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("127.0.0.0")
+	p.Netmask = net.ParseIP("255.0.0.0")
+	p.Broadcast = net.ParseIP("127.255.255.255")
+	p.Start = net.ParseIP("127.0.0.10")
+	p.End = net.ParseIP("127.0.0.20")
+	p.MyIp = dhcp4.IpToFixedV4(net.ParseIP("127.0.0.1"))
+	p.LeaseTime = time.Hour
+
+	app := NewApp()
+	require.Nil(t, app.insertPool(p))
+	app.interfaces["lo"] = struct{}{}
+	conn := NewMemoryConn(lo.Index)
+	app.SetConns(conn)
+	return app, conn, p
+}
+
+func TestMemoryConn(t *testing.T) {
+	app, conn, p := newMemoryApp(t)
+	served := make(chan error)
+	go func() {
+		served <- app.Serve()
+	}()
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	client := &net.UDPAddr{IP: net.IPv4zero, Port: 68}
+	exchange := func(message *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		buf := new(bytes.Buffer)
+		require.Nil(t, message.Encode(buf))
+		require.Nil(t, conn.Send(buf.Bytes(), client))
+
+		data, addr, err := conn.Receive(time.Second)
+		require.Nil(t, err)
+		require.Equal(t, "127.255.255.255:68", addr.String())
+		reply, err := dhcp4.ParseDhcpMessage(data)
+		require.Nil(t, err)
+		return reply
+	}
+
+	offer := exchange(newTestMessage(dhcp4.DHCPDISCOVER, mac))
+	require.Equal(t, dhcp4.DHCPOFFER, offer.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("127.0.0.10")), offer.Header.YourAddr)
+
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+	ack := exchange(request)
+	require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, offer.Header.YourAddr, ack.Header.YourAddr)
+
+	lease, ok := p.GetLeaseByMac(mac)
+	require.True(t, ok)
+	require.Equal(t, offer.Header.YourAddr, lease.IP)
+
+	require.Nil(t, app.Stop())
+	select {
+	case err := <-served:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return once stopped")
+	}
+}
+
+func TestUDPPacketConnBatch(t *testing.T) {
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	defer client.Close()
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	conn := NewUDPPacketConn(socket)
+
+	// Many more replies than fit in a batch all get sent, with their
+	// buffers free to reuse as soon as they're queued
+	buf := make([]byte, 1)
+	for i := 0; i < 100; i++ {
+		buf[0] = byte(i)
+		n, err := conn.WriteTo(buf, client.LocalAddr())
+		require.Nil(t, err)
+		require.Equal(t, 1, n)
+	}
+	received := make([]byte, 2)
+	require.Nil(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	for i := 0; i < 100; i++ {
+		n, _, err := client.ReadFrom(received)
+		require.Nil(t, err)
+		require.Equal(t, []byte{byte(i)}, received[:n])
+	}
+
+	require.Nil(t, conn.Close())
+	_, err = conn.WriteTo(buf, client.LocalAddr())
+	require.ErrorIs(t, err, net.ErrClosed)
+}
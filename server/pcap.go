@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Debug capture of the DHCP packets we receive and send, written to a pcap
+// file which wireshark or tcpdump -r can read. We only see UDP payloads, so
+// each is wrapped in made up IPv4 and UDP headers with the right addresses.
+// Captures are read back too, ours or tcpdump's, to replay them.
+//
+
+const (
+	pcapMagic     = 0xa1b2c3d4
+	pcapSnapLen   = 65535
+	pcapLinkIPv4  = 228
+	pcapChaddrOff = 28
+
+	// Timestamps in nanoseconds rather than microseconds
+	pcapMagicNanos = 0xa1b23c4d
+
+	// Link types we can read besides our own
+	pcapLinkEthernet = 1
+	pcapLinkRaw      = 101
+	pcapLinkLinuxSLL = 113
+)
+
+type Capture struct {
+	macs map[dhcp4.HardwareAddr]bool
+
+	m      sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	closed bool
+}
+
+// Capture to path, only packets for the given macs if any
+func NewCapture(path string, macs []dhcp4.HardwareAddr) (*Capture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Capture{
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}
+	if len(macs) != 0 {
+		c.macs = map[dhcp4.HardwareAddr]bool{}
+		for _, mac := range macs {
+			c.macs[mac] = true
+		}
+	}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkIPv4)
+	if _, err := c.writer.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Capture) wanted(payload []byte) bool {
+	if c.macs == nil {
+		return true
+	}
+	if len(payload) < pcapChaddrOff+dhcp4.MAX_HLEN || payload[2] > dhcp4.MAX_HLEN {
+		return false
+	}
+	return c.macs[dhcp4.NewHardwareAddr(payload[1], payload[pcapChaddrOff:pcapChaddrOff+int(payload[2])])]
+}
+
+func (c *Capture) Received(src *net.UDPAddr, dst net.IP, payload []byte) {
+	if dst == nil {
+		dst = net.IPv4bcast
+	}
+	c.write(src.IP, uint16(src.Port), dst, 67, payload)
+}
+
+func (c *Capture) Sent(src dhcp4.FixedV4, dst *net.UDPAddr, payload []byte) {
+	c.write(src.NetIp(), 67, dst.IP, uint16(dst.Port), payload)
+}
+
+func (c *Capture) write(src net.IP, srcPort uint16, dst net.IP, dstPort uint16, payload []byte) {
+	// Nil safe so callers don't need to check whether we're capturing
+	if c == nil || !c.wanted(payload) {
+		return
+	}
+
+	packet := make([]byte, 28, 28+len(payload))
+
+	// IPv4
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(28+len(payload)))
+	packet[8] = 64
+	packet[9] = 17
+	copy(packet[12:16], src.To4())
+	copy(packet[16:20], dst.To4())
+	binary.BigEndian.PutUint16(packet[10:], ipChecksum(packet[:20]))
+
+	// UDP, with the optional checksum left out
+	binary.BigEndian.PutUint16(packet[20:], srcPort)
+	binary.BigEndian.PutUint16(packet[22:], dstPort)
+	binary.BigEndian.PutUint16(packet[24:], uint16(8+len(payload)))
+	packet = append(packet, payload...)
+
+	now := time.Now()
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// Handlers may still be running if shutting down timed out
+	if c.closed {
+		return
+	}
+	c.writer.Write(record)
+	c.writer.Write(packet)
+
+	// Flush every packet so the file is usable while we're running
+	c.writer.Flush()
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func (c *Capture) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.writer.Flush()
+	return c.file.Close()
+}
+
+// UDP payload read from a capture, numbered from 1 like wireshark does
+type PcapPacket struct {
+	Frame    int
+	Src, Dst *net.UDPAddr
+	Payload  []byte
+}
+
+// Every IPv4 UDP packet in a pcap file, skipping anything else
+func ReadPcap(r io.Reader) ([]PcapPacket, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("Failed reading pcap header: %v", err)
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(header) == pcapMagic || binary.LittleEndian.Uint32(header) == pcapMagicNanos:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == pcapMagic || binary.BigEndian.Uint32(header) == pcapMagicNanos:
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("Not a pcap file; pcapng captures need converting with editcap -F pcap")
+	}
+	link := order.Uint32(header[20:])
+
+	var packets []PcapPacket
+	record := make([]byte, 16)
+	for frame := 1; ; frame++ {
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF {
+				return packets, nil
+			}
+			return nil, fmt.Errorf("Failed reading frame %v: %v", frame, err)
+		}
+		data := make([]byte, order.Uint32(record[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("Failed reading frame %v: %v", frame, err)
+		}
+
+		ip, err := pcapIPv4(link, data)
+		if err != nil {
+			return nil, err
+		}
+		if packet, ok := pcapUDP(ip); ok {
+			packet.Frame = frame
+			packets = append(packets, packet)
+		}
+	}
+}
+
+// The IPv4 packet in a frame of the given link type, or nil if it's not one
+func pcapIPv4(link uint32, frame []byte) ([]byte, error) {
+	switch link {
+	case pcapLinkIPv4, pcapLinkRaw:
+		return frame, nil
+
+	case pcapLinkEthernet:
+		if len(frame) < 14 {
+			return nil, nil
+		}
+		etherType, offset := binary.BigEndian.Uint16(frame[12:]), 14
+
+		// 802.1Q tagged
+		if etherType == 0x8100 && len(frame) >= 18 {
+			etherType, offset = binary.BigEndian.Uint16(frame[16:]), 18
+		}
+		if etherType != 0x0800 {
+			return nil, nil
+		}
+		return frame[offset:], nil
+
+	case pcapLinkLinuxSLL:
+		if len(frame) < 16 || binary.BigEndian.Uint16(frame[14:]) != 0x0800 {
+			return nil, nil
+		}
+		return frame[16:], nil
+	}
+	return nil, fmt.Errorf("Unsupported pcap link type %v", link)
+}
+
+func pcapUDP(ip []byte) (PcapPacket, bool) {
+	if len(ip) < 20 || ip[0]>>4 != 4 || ip[9] != 17 {
+		return PcapPacket{}, false
+	}
+
+	// Fragments are rare enough for DHCP not to bother reassembling them
+	if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+		return PcapPacket{}, false
+	}
+
+	headerLen := int(ip[0]&0x0f) * 4
+	if len(ip) < headerLen+8 {
+		return PcapPacket{}, false
+	}
+	udp := ip[headerLen:]
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		return PcapPacket{}, false
+	}
+
+	return PcapPacket{
+		Src:     &net.UDPAddr{IP: net.IP(append([]byte(nil), ip[12:16]...)), Port: int(binary.BigEndian.Uint16(udp[0:]))},
+		Dst:     &net.UDPAddr{IP: net.IP(append([]byte(nil), ip[16:20]...)), Port: int(binary.BigEndian.Uint16(udp[2:]))},
+		Payload: udp[8:length],
+	}, true
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dhcp.pcap")
+
+	wanted := dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware()
+	capture, err := NewCapture(path, []dhcp4.HardwareAddr{wanted})
+	require.Nil(t, err)
+
+	encode := func(mac dhcp4.HardwareAddr) []byte {
+		buf := new(bytes.Buffer)
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		require.Nil(t, message.Encode(buf))
+		return buf.Bytes()
+	}
+	payload := encode(wanted)
+
+	capture.Received(&net.UDPAddr{IP: net.IPv4zero, Port: 68}, nil, payload)
+	capture.Received(&net.UDPAddr{IP: net.IPv4zero, Port: 68}, nil, encode(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()))
+	capture.Sent(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.254")), &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 67}, payload)
+	require.Nil(t, capture.Close())
+
+	contents, err := os.ReadFile(path)
+	require.Nil(t, err)
+
+	require.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(contents))
+	require.Equal(t, uint32(pcapLinkIPv4), binary.LittleEndian.Uint32(contents[20:]))
+	contents = contents[24:]
+
+	readPacket := func() []byte {
+		length := binary.LittleEndian.Uint32(contents[8:])
+		packet := contents[16 : 16+length]
+		contents = contents[16+length:]
+		return packet
+	}
+
+	// The other mac was filtered out
+	received := readPacket()
+	sent := readPacket()
+	require.Empty(t, contents)
+
+	require.Equal(t, byte(0x45), received[0])
+	require.Equal(t, uint16(28+len(payload)), binary.BigEndian.Uint16(received[2:]))
+	require.Equal(t, uint16(0), ipChecksum(received[:20]))
+	require.Equal(t, net.IPv4zero.To4(), net.IP(received[12:16]))
+	require.Equal(t, net.IPv4bcast.To4(), net.IP(received[16:20]))
+	require.Equal(t, uint16(68), binary.BigEndian.Uint16(received[20:]))
+	require.Equal(t, uint16(67), binary.BigEndian.Uint16(received[22:]))
+	require.Equal(t, payload, received[28:])
+
+	require.Equal(t, net.ParseIP("10.0.0.254").To4(), net.IP(sent[12:16]))
+	require.Equal(t, net.ParseIP("10.0.0.1").To4(), net.IP(sent[16:20]))
+	require.Equal(t, uint16(67), binary.BigEndian.Uint16(sent[20:]))
+	require.Equal(t, uint16(67), binary.BigEndian.Uint16(sent[22:]))
+}
+
+func TestReadPcap(t *testing.T) {
+	// Big endian, as written on some routers, with ethernet framing
+	capture := new(bytes.Buffer)
+	header := make([]byte, 24)
+	binary.BigEndian.PutUint32(header[0:], pcapMagic)
+	binary.BigEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.BigEndian.PutUint32(header[20:], pcapLinkEthernet)
+	capture.Write(header)
+
+	frame := func(data []byte) {
+		record := make([]byte, 16)
+		binary.BigEndian.PutUint32(record[8:], uint32(len(data)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(data)))
+		capture.Write(record)
+		capture.Write(data)
+	}
+
+	// ARP, skipped
+	arp := make([]byte, 42)
+	binary.BigEndian.PutUint16(arp[12:], 0x0806)
+	frame(arp)
+
+	// Tagged for VLAN 10, from a relay
+	payload := []byte("payload")
+	tagged := make([]byte, 18+28+len(payload))
+	binary.BigEndian.PutUint16(tagged[12:], 0x8100)
+	binary.BigEndian.PutUint16(tagged[14:], 10)
+	binary.BigEndian.PutUint16(tagged[16:], 0x0800)
+	ip := tagged[18:]
+	ip[0] = 0x45
+	ip[9] = 17
+	copy(ip[12:], net.ParseIP("10.0.5.1").To4())
+	copy(ip[16:], net.ParseIP("10.0.0.254").To4())
+	binary.BigEndian.PutUint16(ip[20:], 67)
+	binary.BigEndian.PutUint16(ip[22:], 67)
+	binary.BigEndian.PutUint16(ip[24:], uint16(8+len(payload)))
+	copy(ip[28:], payload)
+	frame(tagged)
+
+	packets, err := ReadPcap(capture)
+	require.Nil(t, err)
+	require.Len(t, packets, 1)
+	require.Equal(t, 2, packets[0].Frame)
+	require.Equal(t, "10.0.5.1:67", packets[0].Src.String())
+	require.Equal(t, "10.0.0.254:67", packets[0].Dst.String())
+	require.Equal(t, payload, packets[0].Payload)
+
+	// pcapng's section header block
+	_, err = ReadPcap(bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a, 0x1c, 0, 0, 0, 0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}))
+	require.NotNil(t, err)
+}
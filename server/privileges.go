@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+//
+// Give up root once port 67 is bound, so a bug in parsing packets from the
+// network can't be used to take over the machine. Optionally chroot too,
+// after which every path we open is inside the new root.
+//
+
+func DropPrivileges(username, group, chroot string) error {
+	if username == "" && group == "" && chroot == "" {
+		return nil
+	}
+
+	// Look these up while we can still see /etc
+	uid, gid := -1, -1
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("User %v has non-numeric uid %v", username, u.Uid)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf("User %v has non-numeric gid %v", username, u.Gid)
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("Group %v has non-numeric gid %v", group, g.Gid)
+		}
+	}
+
+	if chroot != "" {
+		if err := syscall.Chroot(chroot); err != nil {
+			return fmt.Errorf("Failed chrooting to %v: %v", chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return err
+		}
+	}
+
+	// Group first, as we can't once we're no longer root
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("Failed setting groups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("Failed setting gid %v: %v", gid, err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("Failed setting uid %v: %v", uid, err)
+		}
+
+		// Make sure there's no way back
+		if uid != 0 && syscall.Setuid(0) == nil {
+			return errors.New("Still able to become root after dropping privileges")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestDropPrivilegesUnknown(t *testing.T) {
+	require.Nil(t, DropPrivileges("", "", ""))
+	require.NotNil(t, DropPrivileges("no-such-user-golang-dhcpd", "", ""))
+	require.NotNil(t, DropPrivileges("", "no-such-group-golang-dhcpd", ""))
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Making sure an IP really is free before offering it to a new client, by
+// ARP probing for it (RFC 5227) on the interface the client is on. Plenty
+// of hosts drop pings, but anything using an IP has to answer ARP for it.
+// An IP something other than the client answers for is abandoned, as if
+// declined, and another one offered instead. Clients behind relays aren't
+// on a segment we can probe, so aren't checked.
+//
+
+// Whether an IP is in use by something other than the client asking
+type ConflictCheck func(ctx *RequestContext, request *dhcp4.DHCPMessage, ip dhcp4.FixedV4) bool
+
+const (
+	defaultProbeTimeout = 200 * time.Millisecond
+
+	// How many IPs we try before giving up on a client
+	maxProbedOffers = 4
+)
+
+// Probe IPs offered to new clients of pools with a probe timeout
+func ProbeMiddleware(inUse ConflictCheck) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+			if request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) != dhcp4.DHCPDISCOVER || ctx.Relayed() || ctx.Pool.ProbeTimeout == 0 {
+				return next.ServeDHCP(ctx, request)
+			}
+
+			// A client we've leased or offered to before may well be
+			// using its IP already
+			mac := leaseKey(ctx.Pool, request)
+			if _, ok := ctx.Pool.GetLeaseByMac(mac); ok {
+				return next.ServeDHCP(ctx, request)
+			}
+
+			return offerUnclaimed(next, ctx, request, mac, inUse)
+		})
+	}
+}
+
+// Offer a client an IP inUse says is free, abandoning those it says aren't
+func offerUnclaimed(next Handler, ctx *RequestContext, request *dhcp4.DHCPMessage, mac dhcp4.HardwareAddr, inUse ConflictCheck) *dhcp4.DHCPMessage {
+	for i := 0; i < maxProbedOffers; i++ {
+		response := next.ServeDHCP(ctx, request)
+		if response == nil || response.Header.YourAddr.Empty() {
+			return response
+		}
+		ip := response.Header.YourAddr
+		if !inUse(ctx, request, ip) {
+			return response
+		}
+		log.Printf("%v in pool %v is already in use, so abandoning it rather than offering it to %v", ip.String(), ctx.Pool.Name, mac.String())
+		ctx.Pool.DeclineLease(mac, ip)
+		response.Release()
+	}
+	log.Printf("Every IP tried for %v was in use", mac.String())
+	return nil
+}
+
+type ArpProber struct {
+	fd     int
+	closed bool
+
+	// Probes awaiting an answer, by IP
+	m       sync.Mutex
+	waiting map[dhcp4.FixedV4]chan net.HardwareAddr
+}
+
+const arpPacketSize = 28
+
+func htons(v uint16) uint16 {
+	return binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v))
+}
+
+// Opens the packet socket to probe with straight away, as we can't once
+// privileges are dropped
+func NewArpProber() (*ArpProber, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed opening ARP socket: %v", err)
+	}
+
+	// So Run notices being closed
+	timeout := unix.NsecToTimeval(time.Second.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &ArpProber{fd: fd, waiting: map[dhcp4.FixedV4]chan net.HardwareAddr{}}, nil
+}
+
+// Pass on answers to the probes waiting for them, until closed
+func (p *ArpProber) Run() {
+	buf := make([]byte, 1500)
+	for {
+		size, _, err := unix.Recvfrom(p.fd, buf, 0)
+		if err != nil {
+			p.m.Lock()
+			closed := p.closed
+			p.m.Unlock()
+			if closed {
+				return
+			}
+			continue
+		}
+		hw, ip, ok := parseArp(buf[:size])
+		if !ok {
+			continue
+		}
+
+		p.m.Lock()
+		if answers, ok := p.waiting[ip]; ok {
+			select {
+			case answers <- hw:
+			default:
+			}
+		}
+		p.m.Unlock()
+	}
+}
+
+func (p *ArpProber) Close() error {
+	p.m.Lock()
+	p.closed = true
+	p.m.Unlock()
+	return unix.Close(p.fd)
+}
+
+// Probe for an IP on the interface the request came in on, as many times
+// as the pool says
+func (p *ArpProber) InUse(ctx *RequestContext, request *dhcp4.DHCPMessage, ip dhcp4.FixedV4) bool {
+	if ctx.ReplyIfIndex == 0 {
+		return false
+	}
+	iface, err := net.InterfaceByIndex(ctx.ReplyIfIndex)
+	if err != nil || len(iface.HardwareAddr) != 6 {
+		return false
+	}
+
+	answers := make(chan net.HardwareAddr, 1)
+	p.m.Lock()
+	if _, ok := p.waiting[ip]; ok {
+		p.m.Unlock()
+		return false
+	}
+	p.waiting[ip] = answers
+	p.m.Unlock()
+	defer func() {
+		p.m.Lock()
+		delete(p.waiting, ip)
+		p.m.Unlock()
+	}()
+
+	client := request.Header.Hardware().Bytes()
+	tries := ctx.Pool.ProbeRetries + 1
+	for i := 0; i < tries; i++ {
+		to := &unix.SockaddrLinklayer{Ifindex: iface.Index, Protocol: htons(unix.ETH_P_ARP), Halen: 6}
+		copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		if err := unix.Sendto(p.fd, arpProbe(iface.HardwareAddr, ip), 0, to); err != nil {
+			log.Printf("Failed sending ARP probe for %v on %v: %v", ip.String(), iface.Name, err)
+			return false
+		}
+
+		timeout := time.After(ctx.Pool.ProbeTimeout)
+		for waiting := true; waiting; {
+			select {
+			case hw := <-answers:
+				if !bytes.Equal(hw, client) {
+					ctx.Tracef("%v answered ARP for %v", hw.String(), ip.String())
+					return true
+				}
+			case <-timeout:
+				waiting = false
+			}
+		}
+	}
+	return false
+}
+
+// ARP request for ip with no sender IP, so as not to update anyone's cache
+func arpProbe(hw net.HardwareAddr, ip dhcp4.FixedV4) []byte {
+	b := make([]byte, arpPacketSize)
+	binary.BigEndian.PutUint16(b[0:], 1)
+	binary.BigEndian.PutUint16(b[2:], unix.ETH_P_IP)
+	b[4] = 6
+	b[5] = 4
+	binary.BigEndian.PutUint16(b[6:], 1)
+	copy(b[8:], hw)
+	binary.BigEndian.PutUint32(b[24:], uint32(ip))
+	return b
+}
+
+// Sender of an ethernet ARP packet for IPv4
+func parseArp(b []byte) (net.HardwareAddr, dhcp4.FixedV4, bool) {
+	if len(b) < arpPacketSize || binary.BigEndian.Uint16(b[0:]) != 1 || binary.BigEndian.Uint16(b[2:]) != unix.ETH_P_IP || b[4] != 6 || b[5] != 4 {
+		return nil, 0, false
+	}
+	ip := dhcp4.FixedV4(binary.BigEndian.Uint32(b[14:]))
+	if ip.Empty() {
+		return nil, 0, false
+	}
+	return net.HardwareAddr(append([]byte(nil), b[8:14]...)), ip, true
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestProbeMiddleware(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	p.ProbeTimeout = time.Millisecond
+
+	inUse := map[dhcp4.FixedV4]bool{
+		dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")): true,
+		dhcp4.IpToFixedV4(net.ParseIP("10.0.0.11")): true,
+	}
+	probed := 0
+	handler := Chain(DefaultHandler, ProbeMiddleware(func(ctx *RequestContext, request *dhcp4.DHCPMessage, ip dhcp4.FixedV4) bool {
+		probed++
+		return inUse[ip]
+	}))
+
+	// IPs something answers for are abandoned and the next one offered
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	offer := handler.ServeDHCP(&RequestContext{Pool: p}, newTestMessage(dhcp4.DHCPDISCOVER, mac))
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.12")), offer.Header.YourAddr)
+	require.Equal(t, 3, probed)
+	require.Equal(t, 2, p.Utilization().Abandoned)
+
+	// Not when the client was offered the IP already
+	handler.ServeDHCP(&RequestContext{Pool: p}, newTestMessage(dhcp4.DHCPDISCOVER, mac))
+	require.Equal(t, 3, probed)
+
+	// Nor for relayed clients, which we can't probe for
+	relayed := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	relayed.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.1.1"))
+	handler.ServeDHCP(&RequestContext{Pool: p, RelayAddr: relayed.Header.GatewayAddr}, relayed)
+	require.Equal(t, 3, probed)
+
+	// Giving up once enough IPs turn out to be in use
+	everything := Chain(DefaultHandler, ProbeMiddleware(func(ctx *RequestContext, request *dhcp4.DHCPMessage, ip dhcp4.FixedV4) bool {
+		return true
+	}))
+	require.Nil(t, everything.ServeDHCP(&RequestContext{Pool: p}, newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware())))
+	require.Equal(t, 2+maxProbedOffers, p.Utilization().Abandoned)
+}
+
+func TestArpPackets(t *testing.T) {
+	hw := net.HardwareAddr{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}
+	ip := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10"))
+	probe := arpProbe(hw, ip)
+	require.Len(t, probe, arpPacketSize)
+
+	// A probe has no sender IP, so isn't an answer
+	_, _, ok := parseArp(probe)
+	require.False(t, ok)
+
+	// Whereas a reply does
+	reply := append([]byte(nil), probe...)
+	reply[7] = 2
+	copy(reply[14:], []byte{10, 0, 0, 10})
+	sender, senderIp, ok := parseArp(reply)
+	require.True(t, ok)
+	require.Equal(t, hw, sender)
+	require.Equal(t, ip, senderIp)
+
+	_, _, ok = parseArp(reply[:20])
+	require.False(t, ok)
+}
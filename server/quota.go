@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Capping how many leases clients behind one relay circuit, ie one switch
+// port or subscriber line, can hold at once, going by the circuit id relays
+// put in option 82. New clients beyond the cap get no offer, and requests
+// for offers already made are ignored, while clients which already have a
+// lease carry on renewing it. Circuit ids only need to be unique within a
+// pool, as the relay's giaddr picks the pool.
+//
+
+type CircuitQuota struct {
+	m        sync.Mutex
+	exceeded map[string]uint64
+}
+
+func NewCircuitQuota() *CircuitQuota {
+	return &CircuitQuota{exceeded: map[string]uint64{}}
+}
+
+// Set up counting and middleware if any pool caps leases per circuit
+func (a *App) initCircuitQuota() {
+	for _, p := range a.pools() {
+		if p.MaxLeasesPerCircuit != 0 {
+			a.quota = NewCircuitQuota()
+			a.Use(a.quota.Middleware)
+			return
+		}
+	}
+}
+
+// Circuit id a relay put in a message, if any
+func circuitId(message *dhcp4.DHCPMessage) ([]byte, bool) {
+	option, ok := message.Options.Get(dhcp4.OPTION_RELAY_AGENT)
+	if !ok {
+		return nil, false
+	}
+	return dhcp4.RelayAgentSubOption(option.Data, dhcp4.RELAY_AGENT_CIRCUIT_ID)
+}
+
+// Active leases of clients other than mac on a circuit
+func circuitLeases(p *pool.Pool, circuit []byte, mac dhcp4.HardwareAddr) int {
+	count := 0
+	for _, lease := range p.GetLeases() {
+		if lease.Mac == mac || lease.Expired() {
+			continue
+		}
+		if id, ok := dhcp4.RelayAgentSubOption(lease.RelayAgentInfo, dhcp4.RELAY_AGENT_CIRCUIT_ID); ok && bytes.Equal(id, circuit) {
+			count++
+		}
+	}
+	return count
+}
+
+// Turn away new clients on circuits already at their pool's cap
+func (q *CircuitQuota) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		op := request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+		max := ctx.Pool.MaxLeasesPerCircuit
+		if max == 0 || (op != dhcp4.DHCPDISCOVER && op != dhcp4.DHCPREQUEST) {
+			return next.ServeDHCP(ctx, request)
+		}
+		circuit, ok := circuitId(request)
+		if !ok {
+			return next.ServeDHCP(ctx, request)
+		}
+		mac := leaseKey(ctx.Pool, request)
+		if lease, ok := ctx.Pool.GetLeaseByMac(mac); ok && !lease.Offered && !lease.Expired() {
+			return next.ServeDHCP(ctx, request)
+		}
+		if circuitLeases(ctx.Pool, circuit, mac) < max {
+			return next.ServeDHCP(ctx, request)
+		}
+
+		log.Printf("Circuit %q in pool %v already has %v leases, so turning away %v", circuit, ctx.Pool.Name, max, mac.String())
+		ctx.Pool.WithdrawOffer(mac)
+		q.m.Lock()
+		q.exceeded[ctx.Pool.Name]++
+		q.m.Unlock()
+		return nil
+	})
+}
+
+// Requests turned away so far, by pool
+func (q *CircuitQuota) Counts() map[string]uint64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	counts := make(map[string]uint64, len(q.exceeded))
+	for name, n := range q.exceeded {
+		counts[name] = n
+	}
+	return counts
+}
+
+// Counts in the Prometheus text format
+func (q *CircuitQuota) WriteMetrics(w io.Writer) {
+	counts := q.Counts()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# HELP dhcp_circuit_quota_exceeded_total Requests from new clients on circuits with as many leases as allowed\n# TYPE dhcp_circuit_quota_exceeded_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "dhcp_circuit_quota_exceeded_total{pool=%q} %v\n", name, counts[name])
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestCircuitQuota(t *testing.T) {
+	p := newTestPool()
+	p.Name = "isp"
+	p.LeaseTime = time.Hour
+	p.MaxLeasesPerCircuit = 2
+
+	quota := NewCircuitQuota()
+	handler := Chain(DefaultHandler, quota.Middleware)
+	serve := func(message *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		return handler.ServeDHCP(&RequestContext{Pool: p}, message)
+	}
+	onCircuit := func(op byte, i byte, circuit string) *dhcp4.DHCPMessage {
+		message := newTestMessage(op, dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware())
+		message.Options.Set(dhcp4.OPTION_RELAY_AGENT, append([]byte{dhcp4.RELAY_AGENT_CIRCUIT_ID, byte(len(circuit))}, circuit...))
+		return message
+	}
+	lease := func(i byte, circuit string) *dhcp4.DHCPMessage {
+		offer := serve(onCircuit(dhcp4.DHCPDISCOVER, i, circuit))
+		if offer == nil {
+			return nil
+		}
+		request := onCircuit(dhcp4.DHCPREQUEST, i, circuit)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+		request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+		return serve(request)
+	}
+
+	require.NotNil(t, lease(1, "port1"))
+	require.NotNil(t, lease(2, "port1"))
+
+	// A third client on the same port is offered nothing
+	require.Nil(t, lease(3, "port1"))
+	require.Equal(t, map[string]uint64{"isp": 1}, quota.Counts())
+
+	// While those already leased carry on, and other ports aren't affected
+	renew := onCircuit(dhcp4.DHCPREQUEST, 1, "port1")
+	lease1, ok := p.GetLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	require.True(t, ok)
+	renew.Header.ClientAddr = lease1.IP
+	require.Equal(t, dhcp4.DHCPACK, serve(renew).Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.NotNil(t, lease(4, "port2"))
+	require.NotNil(t, lease(5, ""))
+
+	// Until one of them goes
+	_, ok = p.ReleaseLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	require.True(t, ok)
+	require.NotNil(t, lease(3, "port1"))
+
+	var metrics bytes.Buffer
+	quota.WriteMetrics(&metrics)
+	require.Contains(t, metrics.String(), "dhcp_circuit_quota_exceeded_total{pool=\"isp\"} 1\n")
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Reservations for relay circuits and subscribers rather than devices, so
+// whatever is plugged into a switch port, or behind a subscriber line, gets
+// the same IP. Hosts reserved by the circuit id or remote id a relay puts in
+// option 82 are given a hardware address of their own type made from it.
+// A client's leases are kept by that rather than its own address when
+// there's such a reservation, so a new device on the port takes over the
+// lease of the one before.
+//
+
+// Hardware types for circuit and remote ids, hashed if they're longer
+// than a hardware address can be
+const (
+	HTYPE_CIRCUIT_ID byte = 253
+	HTYPE_REMOTE_ID  byte = 254
+)
+
+// An identifier, such as a relay agent sub-option, as a hardware address of
+// the given type
+func idAddr(htype byte, id []byte) dhcp4.HardwareAddr {
+	if len(id) > dhcp4.MAX_HLEN {
+		sum := sha256.Sum256(id)
+		id = sum[:dhcp4.MAX_HLEN]
+	}
+	return dhcp4.NewHardwareAddr(htype, id)
+}
+
+// A circuit or remote id as configured, either as text or as hex after 0x
+func parseRelayId(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("Empty relay agent id")
+	}
+	if digits, ok := strings.CutPrefix(s, "0x"); ok {
+		id, err := hex.DecodeString(strings.ReplaceAll(digits, ":", ""))
+		if err != nil || len(id) == 0 {
+			return nil, fmt.Errorf("Invalid relay agent id '%v'", s)
+		}
+		return id, nil
+	}
+	return []byte(s), nil
+}
+
+// The hardware address a message's client is reserved under in p by its
+// circuit or remote id, if it is
+func relayHostKey(p *pool.Pool, message *dhcp4.DHCPMessage) (dhcp4.HardwareAddr, bool) {
+	option, ok := message.Options.Get(dhcp4.OPTION_RELAY_AGENT)
+	if !ok {
+		return dhcp4.HardwareAddr{}, false
+	}
+	for _, sub := range []struct{ code, htype byte }{
+		{dhcp4.RELAY_AGENT_CIRCUIT_ID, HTYPE_CIRCUIT_ID},
+		{dhcp4.RELAY_AGENT_REMOTE_ID, HTYPE_REMOTE_ID},
+	} {
+		if id, ok := dhcp4.RelayAgentSubOption(option.Data, sub.code); ok && len(id) != 0 {
+			key := idAddr(sub.htype, id)
+			if _, ok := p.GetReservedHost(key); ok {
+				return key, true
+			}
+		}
+	}
+	return dhcp4.HardwareAddr{}, false
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestRelayAgentReservations(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	for _, hc := range []HostConf{
+		{CircuitId: "port7", IP: "10.0.0.15"},
+		{RemoteId: "0x00:11:22", IP: "10.0.0.16"},
+	} {
+		host, err := hc.ToHost()
+		require.Nil(t, err)
+		require.Nil(t, p.AddReservedHost(host))
+	}
+
+	relayed := func(op, device byte, subOption byte, id string) *dhcp4.DHCPMessage {
+		message := newTestMessage(op, dhcp4.MacAddress{0, 0, 0, 0, 0, device}.Hardware())
+		message.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))
+		message.Options.Set(dhcp4.OPTION_RELAY_AGENT, append([]byte{subOption, byte(len(id))}, id...))
+		return message
+	}
+	lease := func(device byte, subOption byte, id string) dhcp4.FixedV4 {
+		offer := NewRequestHandler(relayed(dhcp4.DHCPDISCOVER, device, subOption, id), &RequestContext{Pool: p}).Handle()
+		require.NotNil(t, offer)
+		request := relayed(dhcp4.DHCPREQUEST, device, subOption, id)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+		request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, p.MyIp)
+		ack := NewRequestHandler(request, &RequestContext{Pool: p}).Handle()
+		require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+		return ack.Header.YourAddr
+	}
+	reserved := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))
+
+	// Whatever is plugged into the port gets its IP, taking over the lease
+	// of the device before
+	require.Equal(t, reserved, lease(1, dhcp4.RELAY_AGENT_CIRCUIT_ID, "port7"))
+	require.Equal(t, reserved, lease(2, dhcp4.RELAY_AGENT_CIRCUIT_ID, "port7"))
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.16")), lease(3, dhcp4.RELAY_AGENT_REMOTE_ID, "\x00\x11\x22"))
+
+	// Other ports get IPs as usual
+	require.NotEqual(t, reserved, lease(4, dhcp4.RELAY_AGENT_CIRCUIT_ID, "port8"))
+
+	_, err := (&HostConf{Mac: "0:0:0:0:0:1", CircuitId: "port7"}).Key()
+	require.NotNil(t, err)
+	_, err = (&HostConf{RemoteId: "0xzz"}).Key()
+	require.NotNil(t, err)
+}
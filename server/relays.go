@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Only accept relayed requests from known relay agents. Anyone can send us
+// a request claiming to be relayed, and pick a giaddr to drain whichever
+// remote pool they like, so with an allowlist configured both the giaddr
+// and the address the packet came from must be on it.
+//
+
+// Spoofed giaddrs could be anything, so beyond this many we stop counting
+// drops for each separately
+const maxCountedRelays = 1024
+
+type RelayAllowlist struct {
+	networks []*net.IPNet
+
+	m       sync.Mutex
+	dropped map[string]int
+}
+
+// Entries are IPs or CIDR networks
+func NewRelayAllowlist(entries []string) (*RelayAllowlist, error) {
+	r := &RelayAllowlist{dropped: map[string]int{}}
+	for _, entry := range entries {
+		cidr := entry
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || network.IP.To4() == nil {
+			return nil, fmt.Errorf("Invalid relay address '%v'", entry)
+		}
+		r.networks = append(r.networks, network)
+	}
+	return r, nil
+}
+
+func (r *RelayAllowlist) contains(ip net.IP) bool {
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether to accept a request relayed by giaddr which came from src,
+// counting it against giaddr if not
+func (r *RelayAllowlist) Allowed(giaddr dhcp4.FixedV4, src net.IP) bool {
+	if r.contains(giaddr.NetIp()) && (src == nil || r.contains(src)) {
+		return true
+	}
+
+	relay := giaddr.String()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.dropped[relay]; !ok && len(r.dropped) >= maxCountedRelays {
+		relay = "other"
+	}
+	r.dropped[relay]++
+
+	// Only log the first, as there may be a flood of them
+	if r.dropped[relay] == 1 {
+		log.Printf("Dropping requests relayed by untrusted %v from %v", giaddr.String(), src)
+	}
+	return false
+}
+
+// Count of requests dropped, by the giaddr they claimed
+func (r *RelayAllowlist) Dropped() map[string]int {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	dropped := make(map[string]int, len(r.dropped))
+	for relay, count := range r.dropped {
+		dropped[relay] = count
+	}
+	return dropped
+}
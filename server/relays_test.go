@@ -0,0 +1,30 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestRelayAllowlist(t *testing.T) {
+	_, err := NewRelayAllowlist([]string{"10.0.0.300"})
+	require.NotNil(t, err)
+
+	relays, err := NewRelayAllowlist([]string{"10.0.0.1", "192.168.0.0/24"})
+	require.Nil(t, err)
+
+	relay := dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))
+	require.True(t, relays.Allowed(relay, net.ParseIP("10.0.0.1")))
+	require.True(t, relays.Allowed(relay, net.ParseIP("192.168.0.5")))
+	require.True(t, relays.Allowed(dhcp4.IpToFixedV4(net.ParseIP("192.168.0.1")), net.ParseIP("192.168.0.1")))
+
+	// Untrusted giaddr, or trusted one sent from elsewhere
+	require.False(t, relays.Allowed(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.2")), net.ParseIP("10.0.0.1")))
+	require.False(t, relays.Allowed(relay, net.ParseIP("10.0.0.2")))
+	require.False(t, relays.Allowed(relay, net.ParseIP("10.0.0.3")))
+
+	require.Equal(t, map[string]int{"10.0.0.2": 1, "10.0.0.1": 2}, relays.Dropped())
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Reproducing reported problems from a packet capture. `mygodhcpd replay`
+// feeds the DHCP requests in a pcap file, whether written with -pcap or by
+// tcpdump, through parsing and allocation against the pools in our
+// configuration, and prints what we'd answer to each. Nothing is sent and
+// leases are only kept in memory, so every run gives the same output.
+//
+
+type ReplayConf struct {
+	// Pool for requests which weren't relayed, if there's more than one
+	Pool string
+
+	// Directory to load leases from to start with, rather than starting
+	// with every pool empty. They're never written back
+	Leasedir string
+}
+
+type Replayer struct {
+	app *App
+
+	// Pool for requests which weren't relayed, if we know which
+	local *pool.Pool
+}
+
+func NewReplayer(conf *Conf, replayConf *ReplayConf) (*Replayer, error) {
+	app := NewApp()
+	globalOptions, err := conf.globalOptions()
+	if err != nil {
+		return nil, err
+	}
+	for _, pc := range conf.Pools {
+		p, err := pc.ToPool()
+		if err != nil {
+			return nil, err
+		}
+		p.GlobalOptions = globalOptions
+		if replayConf.Leasedir != "" {
+			p.Persistence = pool.NewFilePersistence(filepath.Join(replayConf.Leasedir, p.Name+".json"))
+			if _, err := p.LoadLeases(); err != nil {
+				return nil, fmt.Errorf("Failed loading leases for pool %v: %v", p.Name, err)
+			}
+			p.Persistence = nil
+		}
+		if err := app.insertPool(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := app.initClasses(conf.Classes); err != nil {
+		return nil, err
+	}
+	app.initUnknownClients()
+	app.initCircuitQuota()
+
+	r := &Replayer{app: app}
+	switch pools := app.pools(); {
+	case replayConf.Pool != "":
+		p, err := app.findPoolByName(replayConf.Pool)
+		if err != nil {
+			return nil, err
+		}
+		r.local = p
+	case len(pools) == 1:
+		r.local = pools[0]
+	}
+	return r, nil
+}
+
+// Replay every request in a capture, writing what we'd answer to w
+func (r *Replayer) ReplayFile(path string, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	packets, err := ReadPcap(file)
+	if err != nil {
+		return err
+	}
+	for _, packet := range packets {
+		r.Replay(packet, w)
+	}
+	return nil
+}
+
+// Handle one packet from a capture, if it's a request to a server
+func (r *Replayer) Replay(packet PcapPacket, w io.Writer) {
+	if packet.Dst.Port != 67 {
+		return
+	}
+	message, err := dhcp4.ParseDhcpMessage(packet.Payload)
+	if err != nil {
+		fmt.Fprintf(w, "%v: unparseable: %v\n", packet.Frame, err)
+		return
+	}
+	defer message.Release()
+	if message.Header.Op != dhcp4.BOOT_REQUEST {
+		return
+	}
+
+	ctx := NewRequestContext("", packet.Src)
+	ctx.Dst = packet.Dst.IP
+	ctx.Populate(message)
+
+	op := "BOOTREQUEST"
+	if code := message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE); code != 0 {
+		op = dhcp4.OpNames[code]
+	}
+	fmt.Fprintf(w, "%v: %v from %v xid %08x", packet.Frame, op, message.Header.Hardware().String(), message.Header.Identifier)
+	if ctx.Relayed() {
+		fmt.Fprintf(w, " via %v", ctx.RelayAddr.String())
+	}
+	fmt.Fprintln(w)
+
+	// Picked like DispatchMessage does, except that we can't look at the
+	// interface it arrived on
+	switch {
+	case message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPLEASEQUERY && !message.Header.ClientAddr.Empty():
+		ctx.Pool, err = r.app.findPoolbyGiaddr(message.Header.ClientAddr)
+	case ctx.Relayed():
+		ctx.Pool, err = r.app.findPoolbyGiaddr(ctx.RelayAddr)
+	case unicastRenewal(ctx, message):
+		if ctx.Pool, err = r.app.findPoolbyGiaddr(message.Header.ClientAddr); err != nil && r.local != nil {
+			ctx.Pool, err = r.local, nil
+		}
+	case r.local != nil:
+		ctx.Pool = r.local
+	default:
+		err = fmt.Errorf("Not relayed, and no pool given for requests which weren't")
+	}
+	if err != nil {
+		fmt.Fprintf(w, "   no pool: %v\n", err)
+		return
+	}
+	ctx.Pool = r.app.sharedPool(ctx.Pool, message)
+	ctx.Shared = r.app.shared[ctx.Pool.SharedNetwork]
+
+	response := r.app.serve.ServeDHCP(ctx, message)
+	if response == nil {
+		fmt.Fprintf(w, "   no response\n")
+		return
+	}
+	defer response.Release()
+
+	fmt.Fprintf(w, "   %v", dhcp4.OpNames[response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)])
+	if !response.Header.YourAddr.Empty() {
+		fmt.Fprintf(w, " of %v", response.Header.YourAddr.String())
+	}
+	fmt.Fprintln(w)
+	describeOptions(w, response.Options)
+}
+
+// `mygodhcpd replay [flags] capture.pcap`
+func RunReplay(args []string) int {
+	var confPath string
+	replayConf := &ReplayConf{}
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.StringVar(&confPath, "conf", "", "Path to configuration yaml file")
+	flags.StringVar(&replayConf.Pool, "pool", "", "Pool for requests which weren't relayed, if there's more than one")
+	flags.StringVar(&replayConf.Leasedir, "leasedir", "", "Directory of json lease files to start from, rather than empty pools")
+	flags.Parse(args)
+
+	if confPath == "" || flags.NArg() != 1 {
+		log.Printf("Usage: mygodhcpd replay -conf conf.yaml [flags] capture.pcap")
+		return 1
+	}
+
+	conf, err := ParseConf(confPath)
+	if err != nil {
+		log.Printf("Failed parsing conf: %v", err)
+		return 1
+	}
+
+	replayer, err := NewReplayer(conf, replayConf)
+	if err != nil {
+		log.Printf("Failed setting up pools: %v", err)
+		return 1
+	}
+
+	// Only what we'd answer, for diffing runs
+	log.SetOutput(io.Discard)
+	if err := replayer.ReplayFile(flags.Arg(0), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed replaying %v: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
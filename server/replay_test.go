@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dhcp.pcap")
+	capture, err := NewCapture(path, nil)
+	require.Nil(t, err)
+
+	client := &net.UDPAddr{IP: net.IPv4zero, Port: 68}
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	received := func(message *dhcp4.DHCPMessage) {
+		buf := new(bytes.Buffer)
+		require.Nil(t, message.Encode(buf))
+		capture.Received(client, nil, buf.Bytes())
+	}
+
+	received(newTestMessage(dhcp4.DHCPDISCOVER, mac))
+
+	// Our offer isn't replayed
+	capture.Sent(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1")), &net.UDPAddr{IP: net.IPv4bcast, Port: 68}, []byte{dhcp4.BOOT_REPLY})
+
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")))
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1")))
+	received(request)
+
+	// Relayed from a network we have no pool for
+	relayed := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	relayed.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("192.168.5.1"))
+	received(relayed)
+	require.Nil(t, capture.Close())
+
+	conf := &Conf{
+		Pools: []PoolConf{{
+			Name:      "test",
+			MyIp:      "10.0.0.1",
+			Network:   "10.0.0.0",
+			Netmask:   "255.255.255.0",
+			Start:     "10.0.0.10",
+			End:       "10.0.0.20",
+			LeaseTime: 3600,
+		}},
+	}
+	replay := func() string {
+		replayer, err := NewReplayer(conf, &ReplayConf{})
+		require.Nil(t, err)
+		out := new(bytes.Buffer)
+		require.Nil(t, replayer.ReplayFile(path, out))
+		return out.String()
+	}
+
+	out := replay()
+	lines := strings.Split(out, "\n")
+	require.True(t, strings.HasPrefix(lines[0], "1: DHCPDISCOVER from 0:0:0:0:0:1 xid "))
+	require.Equal(t, "   DHCPOFFER of 10.0.0.10", lines[1])
+	require.Contains(t, out, "3: DHCPREQUEST from 0:0:0:0:0:1 xid ")
+	require.Contains(t, out, "   DHCPACK of 10.0.0.10\n")
+	require.Contains(t, out, "4: DHCPDISCOVER from 0:0:0:0:0:2 xid ")
+	require.Contains(t, out, " via 192.168.5.1\n   no pool: ")
+	require.NotContains(t, out, "2: ")
+
+	// Nothing carries over between runs
+	require.Equal(t, out, replay())
+}
@@ -0,0 +1,646 @@
+package server
+
+import (
+	"golang.org/x/net/ipv4"
+
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+type RequestHandler struct {
+	header  *dhcp4.MessageHeader
+	options *dhcp4.Options
+	ctx     *RequestContext
+
+	// Client's hardware address, or client identifier as one for pools
+	// keeping leases by that, which its leases are kept by
+	hw dhcp4.HardwareAddr
+}
+
+func NewRequestHandler(message *dhcp4.DHCPMessage, ctx *RequestContext) *RequestHandler {
+	return &RequestHandler{
+		header:  message.Header,
+		options: message.Options,
+		ctx:     ctx,
+		hw:      leaseKey(ctx.Pool, message),
+	}
+}
+
+func (r *RequestHandler) Handle() *dhcp4.DHCPMessage {
+	op := r.options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+
+	// Leases are kept by hardware address, so clients without one, such
+	// as InfiniBand's, can be classified but not leased to
+	if r.hw.Empty() && op != dhcp4.DHCPLEASEQUERY {
+		log.Printf("Ignoring message from hardware type %v client without a hardware address", r.header.HType)
+		return nil
+	}
+
+	switch op {
+	case dhcp4.DHCPDISCOVER:
+		return r.HandleDiscover()
+	case dhcp4.DHCPREQUEST:
+		return r.HandleRequest()
+	case dhcp4.DHCPRELEASE:
+		return r.HandleRelease()
+	case dhcp4.DHCPDECLINE:
+		return r.HandleDecline()
+	case dhcp4.DHCPLEASEQUERY:
+		return r.HandleLeaseQuery()
+	case 0:
+		// No message type at all means a legacy BOOTP client
+		if r.header.Op == dhcp4.BOOT_REQUEST {
+			return r.HandleBootp()
+		}
+		log.Printf("Ignoring message with no type and op %v", r.header.Op)
+		return nil
+	default:
+		log.Printf("Unimplemented op %v", r.header.Op)
+		return nil
+	}
+}
+
+func (r *RequestHandler) HandleDiscover() *dhcp4.DHCPMessage {
+	hostname, _ := r.options.GetString(dhcp4.OPTION_HOST_NAME)
+
+	mac := r.hw
+	log.Printf("DHCPDISCOVER from %v (%s)", mac.String(), hostname)
+
+	if r.v6OnlyPreferred() {
+		return r.SendV6OnlyPreferred(dhcp4.DHCPOFFER)
+	}
+
+	// With rapid commit we go straight to committing the lease
+	op := dhcp4.DHCPOFFER
+	if _, ok := r.options.Get(dhcp4.OPTION_RAPID_COMMIT); ok && r.ctx.Pool.RapidCommit {
+		r.ctx.Tracef("Client asked for rapid commit, and the pool allows it")
+		op = dhcp4.DHCPACK
+	}
+
+	// Offers not yet requested are made again rather than taken up
+	leaseTime := r.leaseTime()
+	lease, ok := r.ctx.Pool.RenewLease(mac, leaseTime)
+	if op == dhcp4.DHCPACK && !ok {
+		lease, ok = r.ctx.Pool.TouchLease(mac, leaseTime)
+	}
+	if ok {
+		log.Printf("Have old lease for %v: %v", mac.String(), lease.IP.String())
+	} else {
+		// Carrying on to the next subnet of a shared network once one's full
+		var err error
+		for _, p := range r.sharedPools() {
+			r.ctx.Pool = p
+			leaseTime = r.leaseTime()
+			if op == dhcp4.DHCPOFFER {
+				lease, err = p.OfferLeaseFor(mac, hostname, leaseTime)
+			} else {
+				lease, err = p.GetNextLeaseFor(mac, hostname, leaseTime)
+			}
+			if err == nil {
+				break
+			}
+			r.ctx.Tracef("No lease for %v in pool %v: %v", mac.String(), p.Name, err)
+		}
+		if err != nil {
+			log.Printf("Could not get a new lease for %v: %v", mac.String(), err)
+			return nil
+		}
+		r.ctx.Tracef("Allocated new lease for %v in pool %v, expiring %v", lease.IP.String(), r.ctx.Pool.Name, lease.Expiration)
+	}
+
+	// Noted on offers too, so the client's identifiers are on its lease
+	// by the time a request commits it and DNS is told
+	r.noteTransaction()
+	response := r.SendLeaseInfo(lease, op)
+	if op == dhcp4.DHCPACK {
+		response.Options.Set(dhcp4.OPTION_RAPID_COMMIT, nil)
+	}
+	return response
+}
+
+func (r *RequestHandler) HandleRequest() *dhcp4.DHCPMessage {
+	mac := r.hw
+	state := r.requestState()
+	requested := r.requestedIp()
+	log.Printf("DHCPREQUEST from %v for %v (%v)", mac.String(), requested.String(), state)
+
+	switch state {
+	case STATE_SELECTING:
+		// A client selecting an offer names the server it chose, and the
+		// rest of us keep quiet and let our own offers go
+		if serverId, _ := r.options.GetIP(dhcp4.OPTION_SERVER_ID); serverId != r.ctx.Pool.MyIp {
+			log.Printf("Ignoring DHCPREQUEST from %v for server %v", mac.String(), serverId.String())
+			if r.ctx.Pool.WithdrawOffer(mac) {
+				r.ctx.Tracef("Withdrew our offer to %v", mac.String())
+			}
+			return nil
+		}
+	case STATE_INIT_REBOOT:
+		// Back from another network, so it has to start over
+		if !r.ctx.Pool.OnNetwork(requested) {
+			log.Printf("Client %v rebooted with %v, which isn't on pool %v's network", mac.String(), requested.String(), r.ctx.Pool.Name)
+			return r.sendAuthoritativeNAK()
+		}
+	case "":
+		log.Printf("Ignoring DHCPREQUEST from %v with neither a requested IP nor ciaddr", mac.String())
+		return nil
+	}
+
+	if r.v6OnlyPreferred() {
+		return r.SendV6OnlyPreferred(dhcp4.DHCPACK)
+	}
+
+	var lease *pool.Lease
+	var ok bool
+	if lease, ok = r.ctx.Pool.TouchLease(mac, r.leaseTime()); !ok {
+		// Renewing a lease from the server we split the pool with
+		r.ctx.Tracef("No lease in pool %v", r.ctx.Pool.Name)
+		if lease, ok = r.ctx.Pool.AdoptLease(mac, requested); ok {
+			log.Printf("Adopted lease for %v from our peer's share", mac.String())
+		} else if state == STATE_INIT_REBOOT {
+			// Some other server may know the client, so it's not for us
+			// to say its IP is wrong
+			log.Printf("No record of %v, so not answering it", mac.String())
+			return nil
+		} else {
+			log.Printf("Unrecognized lease for %v", mac.String())
+			return r.sendAuthoritativeNAK()
+		}
+	}
+
+	// Verify IP matches what is in our lease
+	if requested != lease.IP {
+		log.Printf("Client IP does not match! %v != %v (expected)", requested, lease.IP)
+		return r.SendNAK()
+	}
+
+	r.noteTransaction()
+
+	// Need to send DHCPACK
+	return r.SendLeaseInfo(lease, dhcp4.DHCPACK)
+}
+
+// NAK a request for an IP we don't know of, if we're the only server which
+// could, or otherwise leave it for whichever server does know it
+func (r *RequestHandler) sendAuthoritativeNAK() *dhcp4.DHCPMessage {
+	if r.ctx.Pool.NotAuthoritative {
+		r.ctx.Tracef("Not NAKing as pool %v isn't authoritative", r.ctx.Pool.Name)
+		return nil
+	}
+	return r.SendNAK()
+}
+
+func (r *RequestHandler) HandleRelease() *dhcp4.DHCPMessage {
+	mac := r.hw
+
+	log.Printf("DHCPRELEASE from %v for %v", mac.String(), r.header.ClientAddr.String())
+	var lease *pool.Lease
+	var ok bool
+
+	if lease, ok = r.ctx.Pool.ReleaseLeaseByMac(mac); !ok {
+		log.Printf("Unrecognized lease for %v to release", mac.String())
+		return nil
+	}
+
+	// Verify IP matches what is in our lease
+	if r.header.ClientAddr != lease.IP {
+		log.Printf("Client IP does not match! %v != %v (expected)", r.header.ClientAddr, lease.IP)
+	}
+
+	// No response to a DHCPRELEASE
+	return nil
+}
+
+// The client found the IP we gave it already in use, so it's abandoned for
+// a while rather than handed out again
+func (r *RequestHandler) HandleDecline() *dhcp4.DHCPMessage {
+	mac := r.hw
+	ip, _ := r.options.GetIP(dhcp4.OPTION_REQUESTED_IP)
+
+	log.Printf("DHCPDECLINE from %v for %v", mac.String(), ip.String())
+	if _, ok := r.ctx.Pool.DeclineLease(mac, ip); !ok {
+		log.Printf("Unrecognized lease for %v to decline", mac.String())
+		return nil
+	}
+	log.Printf("Abandoned %v in pool %v as it's already in use", ip.String(), r.ctx.Pool.Name)
+
+	// No response to a DHCPDECLINE
+	return nil
+}
+
+func (r *RequestHandler) HandleBootp() *dhcp4.DHCPMessage {
+	mac := r.hw
+	log.Printf("BOOTREQUEST from %v", mac.String())
+
+	var lease *pool.Lease
+	var err error
+	for _, p := range r.sharedPools() {
+		r.ctx.Pool = p
+		if lease, err = p.GetBootpLease(mac); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Could not get a BOOTP lease for %v: %v", mac.String(), err)
+		return nil
+	}
+
+	message := dhcp4.GetDhcpMessage()
+	*message.Header = dhcp4.MessageHeader{
+		Op:         dhcp4.BOOT_REPLY,
+		Identifier: r.header.Identifier,
+		YourAddr:   lease.IP,
+		ServerAddr: r.ctx.Pool.MyIp,
+	}
+	message.Header.CopyHardwareAddr(r.header)
+	message.MinSize = dhcp4.BOOTP_MESSAGE_SIZE
+
+	log.Printf("Sending BOOTREPLY with %v to %v", lease.IP.String(), mac.String())
+
+	// Only the RFC 1497 vendor extensions, none of the DHCP specific ones
+	options := message.Options
+	options.SetIPs(dhcp4.OPTION_SUBNET, r.ctx.Pool.Netmask)
+	options.SetIPs(dhcp4.OPTION_ROUTER, r.ctx.Pool.Router...)
+	options.SetIPs(dhcp4.OPTION_DNS_SERVER, r.ctx.Pool.Dns...)
+
+	return message
+}
+
+// How long this client gets its IP for, by its reservation or class
+func (r *RequestHandler) leaseTime() time.Duration {
+	return r.ctx.Pool.LeaseTimeFor(r.hw, r.ctx.Class)
+}
+
+func (r *RequestHandler) noteTransaction() {
+	var relayAgentInfo []byte
+	if option, ok := r.options.Get(dhcp4.OPTION_RELAY_AGENT); ok {
+		// Copied, as the request's options are reused once we're done
+		relayAgentInfo = append([]byte(nil), option.Data...)
+	}
+	var clientId []byte
+	if option, ok := r.options.Get(dhcp4.OPTION_CLIENT_ID); ok {
+		clientId = append([]byte(nil), option.Data...)
+	}
+	vendorClass, _ := r.options.GetString(dhcp4.OPTION_VENDOR)
+	r.ctx.Pool.NoteTransaction(r.hw, pool.Transaction{
+		RelayAgentInfo: relayAgentInfo,
+		MudUrl:         mudUrl(r.options),
+		Fingerprint:    fingerprint(r.options),
+		VendorClass:    vendorClass,
+		Duid:           duidString(r.options),
+		ClientId:       clientId,
+	})
+}
+
+// Whether the client listed this option in its parameter request list. Clients
+// which don't send a list at all get everything
+func (r *RequestHandler) requested(code byte) bool {
+	option, ok := r.options.Get(dhcp4.OPTION_PARAM_REQ)
+	if !ok {
+		return true
+	}
+	return bytes.IndexByte(option.Data, code) != -1
+}
+
+// Share code for DHCPOFFER and DHCPACK
+func (r *RequestHandler) SendLeaseInfo(lease *pool.Lease, op byte) *dhcp4.DHCPMessage {
+	message := dhcp4.GetDhcpMessage()
+	*message.Header = dhcp4.MessageHeader{
+		Op:         dhcp4.BOOT_REPLY,
+		Hops:       0,
+		Identifier: r.header.Identifier,
+		YourAddr:   lease.IP,
+		ServerAddr: r.ctx.Pool.MyIp,
+	}
+	message.Header.CopyHardwareAddr(r.header)
+
+	log.Printf("Sending %s with %v to %v", dhcp4.OpNames[op], lease.IP.String(), r.hw.String())
+
+	options := message.Options
+
+	// Message type
+	options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, op)
+
+	// Netmask option
+	options.SetIPs(dhcp4.OPTION_SUBNET, r.ctx.Pool.Netmask)
+
+	// Router (defgw)
+	if len(r.ctx.Pool.Router) > 0 {
+		options.SetIPs(dhcp4.OPTION_ROUTER, r.ctx.Pool.Router...)
+	}
+
+	// DNS servers
+	if len(r.ctx.Pool.Dns) > 0 {
+		options.SetIPs(dhcp4.OPTION_DNS_SERVER, r.ctx.Pool.Dns...)
+	}
+
+	// DNS domain
+	if r.ctx.Pool.Domain != "" {
+		options.SetString(dhcp4.OPTION_DOMAIN_NAME, r.ctx.Pool.Domain)
+	}
+
+	// Broadcast address, only if the client asked for it
+	if r.ctx.Pool.Broadcast != nil && r.requested(dhcp4.OPTION_BROADCAST) {
+		options.SetIPs(dhcp4.OPTION_BROADCAST, r.ctx.Pool.Broadcast)
+	}
+
+	// Interface MTU
+	if r.ctx.Pool.Mtu != 0 {
+		options.SetUint16(dhcp4.OPTION_MTU, r.ctx.Pool.Mtu)
+	}
+
+	// NTP servers, only if the client asked for them
+	if len(r.ctx.Pool.Ntp) > 0 && r.requested(dhcp4.OPTION_NTP_SERVER) {
+		options.SetIPs(dhcp4.OPTION_NTP_SERVER, r.ctx.Pool.Ntp...)
+	}
+
+	// SIP servers, only if the client asked for them
+	if !r.ctx.Pool.Sip.Empty() && r.requested(dhcp4.OPTION_SIP_SERVERS) {
+		options.Set(dhcp4.OPTION_SIP_SERVERS, r.ctx.Pool.Sip.Bytes())
+	}
+
+	// Proxy auto-discovery
+	if r.requested(dhcp4.OPTION_WPAD) {
+		options.SetString(dhcp4.OPTION_WPAD, r.ctx.Pool.Wpad)
+	}
+
+	// Classless static routes. Mirrored to the pre-RFC Microsoft option
+	// for older windows clients
+	if len(r.ctx.Pool.Routes) > 0 {
+		routes := dhcp4.EncodeStaticRoutes(r.ctx.Pool.Routes)
+		options.Set(dhcp4.OPTION_CLASSLESS_RT, routes)
+		options.Set(dhcp4.OPTION_MS_CLASSLESS, routes)
+	}
+
+	// Vendor-identifying options, for the enterprises the client identified
+	// itself as
+	if data := r.vendorOptions(); len(data) > 0 {
+		options.Set(dhcp4.OPTION_VI_INFO, data)
+	}
+
+	// Lease time
+	options.SetUint32(dhcp4.OPTION_LEASE_TIME, uint32(r.leaseTime().Seconds()))
+
+	// DHCP server
+	options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, r.ctx.Pool.MyIp)
+
+	// Custom options from configuration. Global ones only fill in for what
+	// the pool doesn't set, then pool, class and host ones override in turn
+	for _, option := range r.ctx.Pool.GlobalOptions {
+		if _, ok := options.Get(option.Code); !ok {
+			options.Set(option.Code, option.Data)
+		}
+	}
+	for _, option := range r.ctx.Pool.Options {
+		options.Override(option.Code, option.Data)
+	}
+	for _, option := range r.ctx.Pool.ClassOptions[r.ctx.Class] {
+		options.Override(option.Code, option.Data)
+	}
+	if host, ok := r.ctx.Pool.GetReservedHost(r.hw); ok {
+		for _, option := range host.Options {
+			options.Override(option.Code, option.Data)
+		}
+	}
+
+	// Fit within what the client can receive, overloading the header fields
+	// and then dropping whatever we have to, least important first
+	message.MaxSize = r.maxMessageSize()
+	var priority [256]byte
+	options.Prioritize(append(append(priority[:0], essentialOptions...), r.requestedOptions()...)...)
+	if dropped := message.Trim(len(essentialOptions)); len(dropped) > 0 {
+		log.Printf("Dropped options %v to fit within %v bytes for %v", dropped, message.MaxSize, r.hw.String())
+	}
+
+	return message
+}
+
+// Pool vendor options for each enterprise in the client's vendor-identifying
+// vendor class, as many as fit in the option
+func (r *RequestHandler) vendorOptions() []byte {
+	if len(r.ctx.Pool.VendorOptions) == 0 {
+		return nil
+	}
+	option, ok := r.options.Get(dhcp4.OPTION_VI_CLASS)
+	if !ok {
+		return nil
+	}
+	classes, err := dhcp4.ParseVendorClasses(option.Data)
+	if err != nil {
+		log.Printf("Ignoring malformed vendor-identifying vendor class from %v: %v", r.hw.String(), err)
+		return nil
+	}
+
+	var data []byte
+	for _, vendor := range r.ctx.Pool.VendorOptions {
+		for _, class := range classes {
+			if class.Enterprise != vendor.Enterprise {
+				continue
+			}
+			encoded := dhcp4.EncodeVendorOptions([]dhcp4.VendorOptions{vendor})
+			if len(data)+len(encoded) > 255 {
+				log.Printf("No room for enterprise %v options for %v", vendor.Enterprise, r.hw.String())
+				break
+			}
+			data = append(data, encoded...)
+			break
+		}
+	}
+	return data
+}
+
+// Options we always keep in a reply, in the order we want them
+var essentialOptions = []byte{
+	dhcp4.OPTION_MESSAGE_TYPE,
+	dhcp4.OPTION_SERVER_ID,
+	dhcp4.OPTION_LEASE_TIME,
+	dhcp4.OPTION_SUBNET,
+}
+
+// Largest reply the client will accept, going by the maximum message size
+// option, which includes the IP and UDP headers
+func (r *RequestHandler) maxMessageSize() int {
+	size, ok := r.options.GetUint16(dhcp4.OPTION_MAX_SIZE)
+	if !ok || int(size)-28 < dhcp4.MIN_MESSAGE_SIZE {
+		return dhcp4.MIN_MESSAGE_SIZE
+	}
+	return int(size) - 28
+}
+
+// Options in the client's parameter request list, in its order of preference
+func (r *RequestHandler) requestedOptions() []byte {
+	option, ok := r.options.Get(dhcp4.OPTION_PARAM_REQ)
+	if !ok {
+		return nil
+	}
+	return option.Data
+}
+
+// Whether the client prefers IPv6-only, and the pool's network is IPv6-mostly.
+// Unlike other options, only clients which list it have asked for it
+func (r *RequestHandler) v6OnlyPreferred() bool {
+	return r.ctx.Pool.V6OnlyWait != 0 && bytes.IndexByte(r.requestedOptions(), dhcp4.OPTION_V6_ONLY) != -1
+}
+
+// Tell a client preferring IPv6-only to go without IPv4 for a while, with no
+// IP (RFC 8925). It stops asking for one as soon as it sees this
+func (r *RequestHandler) SendV6OnlyPreferred(op byte) *dhcp4.DHCPMessage {
+	message := dhcp4.GetDhcpMessage()
+	*message.Header = dhcp4.MessageHeader{
+		Op:         dhcp4.BOOT_REPLY,
+		Hops:       0,
+		Identifier: r.header.Identifier,
+		ServerAddr: r.ctx.Pool.MyIp,
+	}
+	message.Header.CopyHardwareAddr(r.header)
+
+	log.Printf("Sending %s to %v, which prefers IPv6-only, to wait %v", dhcp4.OpNames[op], r.hw.String(), r.ctx.Pool.V6OnlyWait)
+
+	message.Options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, op)
+	message.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, r.ctx.Pool.MyIp)
+	message.Options.SetUint32(dhcp4.OPTION_V6_ONLY, uint32(r.ctx.Pool.V6OnlyWait/time.Second))
+	return message
+}
+
+func (r *RequestHandler) SendNAK() *dhcp4.DHCPMessage {
+	message := dhcp4.GetDhcpMessage()
+	*message.Header = dhcp4.MessageHeader{
+		Op:         dhcp4.BOOT_REPLY,
+		Hops:       0,
+		Identifier: r.header.Identifier,
+		ServerAddr: r.ctx.Pool.MyIp,
+	}
+	message.Header.CopyHardwareAddr(r.header)
+
+	log.Printf("Sending %s to %v", dhcp4.OpNames[dhcp4.DHCPNAK], r.hw.String())
+
+	message.Options.SetByte(dhcp4.OPTION_MESSAGE_TYPE, dhcp4.DHCPNAK)
+
+	// FIXME: we likely need more options
+
+	return message
+}
+
+//
+// Send a dhcp response message to broadcast address
+//
+
+func (r *RequestHandler) sendMessageBroadcast(message *dhcp4.DHCPMessage, localSocket PacketConn) {
+	buf := dhcp4.GetEncodeBuffer()
+	defer dhcp4.PutEncodeBuffer(buf)
+
+	r.ctx.Auth.Prepare(message)
+	err := message.Encode(buf)
+	if err != nil {
+		log.Printf("Failed encoding payload: %v", err)
+		return
+	}
+	if err := r.ctx.Auth.Sign(buf.Bytes()); err != nil {
+		log.Printf("Failed signing payload: %v", err)
+		return
+	}
+	r.ctx.Mark("encoded")
+
+	err = r.sendBroadcast(buf.Bytes(), localSocket)
+	if err != nil {
+		log.Printf("Failed sending %s payload: %v", dhcp4.OpNames[message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)], err)
+	}
+}
+
+func (r *RequestHandler) sendBroadcast(data []byte, localSocket PacketConn) error {
+	// Quickly ripped from https://github.com/aler9/howto-udp-broadcast-golang
+	addr, err := net.ResolveUDPAddr("udp4", r.ctx.Pool.Broadcast.String()+":68")
+	if err != nil {
+		return fmt.Errorf("Failed resolving remote: %v", err)
+	}
+
+	// Need to use our original listening socket to maintain source port 67,
+	// otherwise windows dhcp will not see our responses
+	_, err = r.writeLocal(data, addr, localSocket)
+	if err != nil {
+		return fmt.Errorf("Failed writing: %v", err)
+	}
+	r.ctx.Capture.Sent(r.ctx.Pool.MyIp, addr, data)
+	return nil
+}
+
+// Send out of the interface the request arrived on, from our address on it,
+// rather than wherever the routing table says, when the socket can. With
+// several VLANs that's the only way to be sure of reaching the client
+func (r *RequestHandler) writeLocal(data []byte, addr net.Addr, localSocket PacketConn) (int, error) {
+	writer, ok := localSocket.(PacketInfoWriter)
+	if !ok || r.ctx.ReplyIfIndex == 0 {
+		return localSocket.WriteTo(data, addr)
+	}
+	info := &ipv4.ControlMessage{IfIndex: r.ctx.ReplyIfIndex}
+	if !r.ctx.ReplySrc.Empty() {
+		info.Src = r.ctx.ReplySrc.NetIp()
+	}
+	return writer.WriteToWithInfo(data, info, addr)
+}
+
+//
+// Send a dhcp response message to a unicast address
+//
+
+func (r *RequestHandler) sendMessageRelayed(message *dhcp4.DHCPMessage, dest dhcp4.FixedV4, localSocket PacketConn) {
+	// FIXME: maybe more/fixed header mangling?
+	message.Header.GatewayAddr = r.header.GatewayAddr
+	message.Header.Flags = r.header.Flags
+	r.sendMessageUnicast(message, dest, 67, localSocket)
+}
+
+// Clients which already have an IP are answered at it, as renewing ones
+// needn't be on our segment, except for NAKs, which have to reach them
+// however confused they are about their IP (RFC 2131 section 4.1)
+func (r *RequestHandler) replyUnicast(response *dhcp4.DHCPMessage) bool {
+	if r.header.ClientAddr.Empty() || r.options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) == dhcp4.DHCPLEASEQUERY {
+		return false
+	}
+	return response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) != dhcp4.DHCPNAK
+}
+
+func (r *RequestHandler) sendMessageUnicast(message *dhcp4.DHCPMessage, dest dhcp4.FixedV4, port int, localSocket PacketConn) {
+	buf := dhcp4.GetEncodeBuffer()
+	defer dhcp4.PutEncodeBuffer(buf)
+
+	r.ctx.Auth.Prepare(message)
+	err := message.Encode(buf)
+	if err != nil {
+		log.Printf("Failed encoding payload: %v", err)
+		return
+	}
+	if err := r.ctx.Auth.Sign(buf.Bytes()); err != nil {
+		log.Printf("Failed signing payload: %v", err)
+		return
+	}
+	r.ctx.Mark("encoded")
+
+	err = r.sendUnicast(buf.Bytes(), dest, port, localSocket)
+	if err != nil {
+		log.Printf("Failed sending %s unicast payload: %v", dhcp4.OpNames[message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)], err)
+	}
+}
+
+func (r *RequestHandler) sendUnicast(data []byte, dest dhcp4.FixedV4, port int, localSocket PacketConn) error {
+	// Quickly ripped from https://github.com/aler9/howto-udp-broadcast-golang
+	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%v:%v", dest.String(), port))
+	if err != nil {
+		return fmt.Errorf("Failed resolving remote: %v", err)
+	}
+
+	// Need to use our original listening socket to maintain source port 67,
+	// otherwise windows dhcp will not see our responses
+	_, err = localSocket.WriteTo(data, addr)
+	if err != nil {
+		return fmt.Errorf("Failed writing: %v", err)
+	}
+	r.ctx.Capture.Sent(r.ctx.Pool.MyIp, addr, data)
+	return nil
+}
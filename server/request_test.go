@@ -0,0 +1,773 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestDhcpDiscover(t *testing.T) {
+	pool := pool.NewPool()
+	pool.Start = net.ParseIP("10.0.0.10")
+	pool.End = net.ParseIP("10.0.0.20")
+	pool.Netmask = net.ParseIP("255.255.255.0")
+	pool.Router = []net.IP{net.ParseIP("10.0.0.1")}
+	pool.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.254"))
+	pool.Dns = []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("1.0.0.1")}
+
+	//
+	// DHCPREQUEST targeting an unknown lease. Should get a NAK back
+	//
+
+	// FIXME: consider creating DhcpMessage{} directly instead of these giant blobs
+	b := []byte{
+		1, 1, 6, 0, 110, 255, 201, 48, 0, 3, 0, 0, 172, 17, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 3, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err := dhcp4.ParseDhcpMessage(b)
+	require.Nil(t, err)
+
+	handler := NewRequestHandler(message, &RequestContext{Pool: pool})
+	response := handler.Handle()
+
+	require.Equal(t, dhcp4.BOOT_REPLY, response.Header.Op)
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	//
+	// DISCOVER. Should get back a lease.
+	//
+	b = []byte{
+		1, 1, 6, 0, 237, 92, 70, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 1, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err = dhcp4.ParseDhcpMessage(b)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.DHCPDISCOVER, message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	handler = NewRequestHandler(message, &RequestContext{Pool: pool})
+	response = handler.Handle()
+
+	require.Equal(t, dhcp4.BOOT_REPLY, response.Header.Op)
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), response.Header.YourAddr)
+
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("255.255.255.0"))}, response.Options.GetFixedV4s(dhcp4.OPTION_SUBNET))
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))}, response.Options.GetFixedV4s(dhcp4.OPTION_ROUTER))
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("1.1.1.1")), dhcp4.IpToFixedV4(net.ParseIP("1.0.0.1"))}, response.Options.GetFixedV4s(dhcp4.OPTION_DNS_SERVER))
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.254"))}, response.Options.GetFixedV4s(dhcp4.OPTION_SERVER_ID))
+
+	// Pool should have a lease for this mac
+	lease, ok := pool.TouchLeaseByMac(message.Header.Hardware())
+	require.True(t, ok)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), lease.IP)
+
+	//
+	// Request targeting the wrong IP should get back a NAK
+	//
+	b = []byte{
+		1, 1, 6, 0, 110, 255, 201, 48, 0, 3, 0, 0, 10, 0, 0, 11, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 3, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err = dhcp4.ParseDhcpMessage(b)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.BOOT_REPLY, response.Header.Op)
+	require.Equal(t, dhcp4.DHCPREQUEST, message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	handler = NewRequestHandler(message, &RequestContext{Pool: pool})
+	response = handler.Handle()
+
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	//
+	// A request should now get back an ACK
+	//
+	b = []byte{
+		1, 1, 6, 0, 110, 255, 201, 48, 0, 3, 0, 0, 10, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 3, 12, 7, 117, 98, 117, 110, 116, 117, 50, 55, 13, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err = dhcp4.ParseDhcpMessage(b)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.DHCPREQUEST, message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	handler = NewRequestHandler(message, &RequestContext{Pool: pool})
+	response = handler.Handle()
+
+	require.Equal(t, dhcp4.BOOT_REPLY, response.Header.Op)
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	//
+	// Do a DHCPRELEASE
+	//
+	b = []byte{
+		1, 1, 6, 0, 245, 234, 140, 40, 0, 0, 0, 0, 10, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 28, 66, 180, 110, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 99, 130, 83, 99, 53, 1, 7, 54, 4, 172, 17, 0, 1, 12, 7, 117, 98, 117, 110, 116, 117, 50, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	message, err = dhcp4.ParseDhcpMessage(b)
+	require.Nil(t, err)
+	require.Equal(t, dhcp4.DHCPRELEASE, message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	handler = NewRequestHandler(message, &RequestContext{Pool: pool})
+	response = handler.Handle()
+
+	require.Nil(t, response)
+
+	// Pool should no longer have a lease for this mac
+	lease, ok = pool.TouchLeaseByMac(message.Header.Hardware())
+	require.False(t, ok)
+	require.Nil(t, lease)
+}
+
+func newTestPool() *pool.Pool {
+	pool := pool.NewPool()
+	pool.Start = net.ParseIP("10.0.0.10")
+	pool.End = net.ParseIP("10.0.0.20")
+	pool.Netmask = net.ParseIP("255.255.255.0")
+	pool.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.254"))
+	return pool
+}
+
+func newTestMessage(op byte, mac dhcp4.HardwareAddr) *dhcp4.DHCPMessage {
+	message := dhcp4.NewDhcpMessage()
+	message.Header.Op = dhcp4.BOOT_REQUEST
+	message.Header.Identifier = 0x1234
+	message.Header.SetHardware(mac)
+	message.Options.Set(dhcp4.OPTION_MESSAGE_TYPE, []byte{op})
+	return message
+}
+
+func TestNtpOption(t *testing.T) {
+	pool := newTestPool()
+	pool.Ntp = []net.IP{net.ParseIP("10.0.0.123")}
+
+	// Without a parameter request list we send everything
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.123"))}, response.Options.GetFixedV4s(dhcp4.OPTION_NTP_SERVER))
+
+	// Client asking for NTP gets it
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET, dhcp4.OPTION_NTP_SERVER})
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.123"))}, response.Options.GetFixedV4s(dhcp4.OPTION_NTP_SERVER))
+
+	// Client not asking for it doesn't
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET, dhcp4.OPTION_ROUTER})
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	_, ok := response.Options.Get(dhcp4.OPTION_NTP_SERVER)
+	require.False(t, ok)
+}
+
+func TestSipOption(t *testing.T) {
+	pool := newTestPool()
+	sip, err := dhcp4.ParseSipServers([]string{"sip.example.com"})
+	require.Nil(t, err)
+	pool.Sip = sip
+
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET, dhcp4.OPTION_SIP_SERVERS})
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	option, ok := response.Options.Get(dhcp4.OPTION_SIP_SERVERS)
+	require.True(t, ok)
+	require.Equal(t, []byte{dhcp4.SIP_ENC_DOMAINS, 3, 's', 'i', 'p', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}, option.Data)
+
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET})
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	_, ok = response.Options.Get(dhcp4.OPTION_SIP_SERVERS)
+	require.False(t, ok)
+
+	// Either encoding from configuration, but not both at once
+	pc := &PoolConf{Name: "voip", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", MyIp: "10.0.0.254", Sip: []string{"10.0.0.5", "10.0.0.6"}}
+	p, err := pc.ToPool()
+	require.Nil(t, err)
+	require.Equal(t, []byte{dhcp4.SIP_ENC_IPS, 10, 0, 0, 5, 10, 0, 0, 6}, p.Sip.Bytes())
+	pc.Sip = []string{"10.0.0.5", "sip.example.com"}
+	_, err = pc.ToPool()
+	require.NotNil(t, err)
+}
+
+func TestWpadOption(t *testing.T) {
+	pool := newTestPool()
+	pool.Wpad = "http://wpad.example.com/wpad.dat"
+
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET, dhcp4.OPTION_WPAD})
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	option, ok := response.Options.Get(dhcp4.OPTION_WPAD)
+	require.True(t, ok)
+	require.Equal(t, []byte("http://wpad.example.com/wpad.dat"), option.Data)
+
+	// Only absolute http(s) URLs are accepted
+	pc := PoolConf{Name: "office", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", MyIp: "10.0.0.254"}
+	for _, wpad := range []string{"wpad.dat", "/wpad.dat", "ftp://wpad.example.com/wpad.dat", "http:///wpad.dat"} {
+		pc.Wpad = wpad
+		_, err := pc.ToPool()
+		require.NotNil(t, err, wpad)
+	}
+	pc.Wpad = "https://wpad.example.com/wpad.dat"
+	_, err := pc.ToPool()
+	require.Nil(t, err)
+}
+
+func TestDomainAndBroadcastOptions(t *testing.T) {
+	pc := &PoolConf{Name: "office", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", MyIp: "10.0.0.254", Domain: "office.example.com"}
+	pool, err := pc.ToPool()
+	require.Nil(t, err)
+
+	// Broadcast address derived from the network, and only sent if asked for
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET, dhcp4.OPTION_BROADCAST, dhcp4.OPTION_DOMAIN_NAME})
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	domain, _ := response.Options.GetString(dhcp4.OPTION_DOMAIN_NAME)
+	require.Equal(t, "office.example.com", domain)
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.255"))}, response.Options.GetFixedV4s(dhcp4.OPTION_BROADCAST))
+
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_SUBNET})
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	_, ok := response.Options.Get(dhcp4.OPTION_BROADCAST)
+	require.False(t, ok)
+
+	// Or given explicitly
+	pc.Broadcast = "10.0.0.127"
+	pool, err = pc.ToPool()
+	require.Nil(t, err)
+	require.Equal(t, net.ParseIP("10.0.0.127").To4(), pool.Broadcast)
+
+	pc.Broadcast = "bogus"
+	_, err = pc.ToPool()
+	require.NotNil(t, err)
+}
+
+func TestCustomOptions(t *testing.T) {
+	p := newTestPool()
+	p.Dns = []net.IP{net.ParseIP("1.1.1.1")}
+
+	tftp, err := dhcp4.NewCustomOption(66, "string", "tftp.example.com")
+	require.Nil(t, err)
+	dns, err := dhcp4.NewCustomOption(dhcp4.OPTION_DNS_SERVER, "ip", "9.9.9.9")
+	require.Nil(t, err)
+	p.Options = []dhcp4.CustomOption{tftp, dns}
+
+	hostTftp, err := dhcp4.NewCustomOption(66, "string", "other.example.com")
+	require.Nil(t, err)
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	err = p.AddReservedHost(&pool.ReservedHost{
+		Mac:     mac,
+		IP:      dhcp4.IpToFixedV4(net.ParseIP("10.0.0.50")),
+		Options: []dhcp4.CustomOption{hostTftp},
+	})
+	require.Nil(t, err)
+
+	// Pool options apply, and override builtin ones
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	response := NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+	option, ok := response.Options.Get(66)
+	require.True(t, ok)
+	require.Equal(t, []byte("tftp.example.com"), option.Data)
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("9.9.9.9"))}, response.Options.GetFixedV4s(dhcp4.OPTION_DNS_SERVER))
+
+	// Host options override pool ones
+	message = newTestMessage(dhcp4.DHCPDISCOVER, mac)
+	response = NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+	option, ok = response.Options.Get(66)
+	require.True(t, ok)
+	require.Equal(t, []byte("other.example.com"), option.Data)
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	conf := &Conf{Options: []OptionConf{
+		{Code: dhcp4.OPTION_DNS_SERVER, Type: "ip", Value: "9.9.9.9"},
+		{Code: dhcp4.OPTION_BOOT_FILE, Type: "string", Value: "global.efi"},
+		{Code: dhcp4.OPTION_DOMAIN_NAME, Type: "string", Value: "example.com"},
+	}}
+	globalOptions, err := conf.globalOptions()
+	require.Nil(t, err)
+
+	p := newTestPool()
+	p.GlobalOptions = globalOptions
+	p.Dns = []net.IP{net.ParseIP("1.1.1.1")}
+	poolBootfile, err := dhcp4.NewCustomOption(dhcp4.OPTION_BOOT_FILE, "string", "pool.efi")
+	require.Nil(t, err)
+	p.Options = []dhcp4.CustomOption{poolBootfile}
+	classBootfile, err := dhcp4.NewCustomOption(dhcp4.OPTION_BOOT_FILE, "string", "class.efi")
+	require.Nil(t, err)
+	p.ClassOptions = map[string][]dhcp4.CustomOption{"pxe": {classBootfile}}
+
+	hc := &HostConf{
+		IP: "10.0.0.50", Mac: "0:0:0:0:0:2",
+		Dns:      []string{"10.0.0.53"},
+		Routes:   []RouteConf{{Destination: "192.168.0.0/16", Router: "10.0.0.1"}},
+		Bootfile: "host.efi",
+		Options:  []OptionConf{{Code: dhcp4.OPTION_DNS_SERVER, Type: "ip-list", Value: "10.0.0.53, 10.0.0.54"}},
+	}
+	host, err := hc.ToHost()
+	require.Nil(t, err)
+	require.Nil(t, p.AddReservedHost(host))
+
+	options := func(mac dhcp4.HardwareAddr, class string) *dhcp4.Options {
+		response := NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: p, Class: class}).Handle()
+		require.NotNil(t, response)
+		return response.Options
+	}
+	ips := func(s ...string) []dhcp4.FixedV4 {
+		var result []dhcp4.FixedV4
+		for _, ip := range s {
+			result = append(result, dhcp4.IpToFixedV4(net.ParseIP(ip)))
+		}
+		return result
+	}
+
+	// Global options fill in what nothing else sets
+	other := options(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	domain, _ := other.GetString(dhcp4.OPTION_DOMAIN_NAME)
+	require.Equal(t, "example.com", domain)
+	require.Equal(t, ips("1.1.1.1"), other.GetFixedV4s(dhcp4.OPTION_DNS_SERVER))
+	bootfile, _ := other.GetString(dhcp4.OPTION_BOOT_FILE)
+	require.Equal(t, "pool.efi", bootfile)
+
+	bootfile, _ = options(dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware(), "pxe").GetString(dhcp4.OPTION_BOOT_FILE)
+	require.Equal(t, "class.efi", bootfile)
+
+	// Hosts override everything, with their options overriding their fields
+	reserved := options(host.Mac, "pxe")
+	bootfile, _ = reserved.GetString(dhcp4.OPTION_BOOT_FILE)
+	require.Equal(t, "host.efi", bootfile)
+	require.Equal(t, ips("10.0.0.53", "10.0.0.54"), reserved.GetFixedV4s(dhcp4.OPTION_DNS_SERVER))
+	routes, ok := reserved.Get(dhcp4.OPTION_CLASSLESS_RT)
+	require.True(t, ok)
+	require.Equal(t, []byte{16, 192, 168, 10, 0, 0, 1}, routes.Data)
+	domain, _ = reserved.GetString(dhcp4.OPTION_DOMAIN_NAME)
+	require.Equal(t, "example.com", domain)
+
+	_, err = (&HostConf{IP: "10.0.0.51", Mac: "0:0:0:0:0:4", Routes: []RouteConf{{Destination: "192.168.0.0/16", Router: "bogus"}}}).ToHost()
+	require.NotNil(t, err)
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	pool := newTestPool()
+	pool.Router = []net.IP{net.ParseIP("10.0.0.1")}
+	for _, code := range []int{224, 225, 226} {
+		option, err := dhcp4.NewCustomOption(code, "hex", string(bytes.Repeat([]byte("ab"), 250)))
+		require.Nil(t, err)
+		pool.Options = append(pool.Options, option)
+	}
+
+	// Default size can't fit everything, so the least important go
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_ROUTER, 226})
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.MIN_MESSAGE_SIZE, response.MaxSize)
+
+	_, ok := response.Options.Get(226)
+	require.True(t, ok)
+	_, ok = response.Options.Get(225)
+	require.False(t, ok)
+	_, ok = response.Options.Get(224)
+	require.False(t, ok)
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))}, response.Options.GetFixedV4s(dhcp4.OPTION_ROUTER))
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, response.Encode(buf))
+	require.LessOrEqual(t, buf.Len(), dhcp4.MIN_MESSAGE_SIZE)
+
+	// Client accepting bigger messages gets everything
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	message.Options.SetUint16(dhcp4.OPTION_MAX_SIZE, 1500)
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, 1472, response.MaxSize)
+	for _, code := range []byte{224, 225, 226} {
+		_, ok = response.Options.Get(code)
+		require.True(t, ok)
+	}
+}
+
+func TestBootp(t *testing.T) {
+	pool := newTestPool()
+	pool.Router = []net.IP{net.ParseIP("10.0.0.1")}
+	pool.BootpStart = net.ParseIP("10.0.0.100")
+	pool.BootpEnd = net.ParseIP("10.0.0.100")
+
+	// BOOTREQUEST with no vendor area at all
+	message := dhcp4.NewDhcpMessage()
+	message.Header.Op = dhcp4.BOOT_REQUEST
+	message.Header.Identifier = 0x1234
+	message.Header.HType = 1
+	message.Header.HLen = 6
+	message.Header.Mac = dhcp4.MacAddress{0, 0, 0, 0, 0, 1}
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, binary.Write(buf, binary.BigEndian, message.Header))
+	message, err := dhcp4.ParseDhcpMessage(buf.Bytes())
+	require.Nil(t, err)
+
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.BOOT_REPLY, response.Header.Op)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.100")), response.Header.YourAddr)
+	require.Equal(t, []dhcp4.FixedV4{dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))}, response.Options.GetFixedV4s(dhcp4.OPTION_ROUTER))
+	_, ok := response.Options.Get(dhcp4.OPTION_MESSAGE_TYPE)
+	require.False(t, ok)
+	_, ok = response.Options.Get(dhcp4.OPTION_LEASE_TIME)
+	require.False(t, ok)
+
+	buf = new(bytes.Buffer)
+	require.Nil(t, response.Encode(buf))
+	require.Equal(t, dhcp4.BOOTP_MESSAGE_SIZE, buf.Len())
+
+	// The lease is permanent, and repeat requests get the same IP
+	lease, ok := pool.TouchLeaseByMac(message.Header.Hardware())
+	require.True(t, ok)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.100")), lease.IP)
+
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.100")), response.Header.YourAddr)
+
+	// BOOTP range is exhausted for anyone else
+	message.Header.Mac = dhcp4.MacAddress{0, 0, 0, 0, 0, 2}
+	require.Nil(t, NewRequestHandler(message, &RequestContext{Pool: pool}).Handle())
+
+	// Including clients with a DHCP lease, which they keep
+	dhcp, err := pool.GetNextLease(message.Header.Hardware(), "")
+	require.Nil(t, err)
+	require.Nil(t, NewRequestHandler(message, &RequestContext{Pool: pool}).Handle())
+	held, ok := pool.GetLeaseByMac(message.Header.Hardware())
+	require.True(t, ok)
+	require.Equal(t, dhcp.IP, held.IP)
+	require.False(t, held.Permanent())
+
+	// Those with a free BOOTP IP give up their DHCP lease for it
+	pool.BootpEnd = net.ParseIP("10.0.0.101")
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.101")), response.Header.YourAddr)
+	held, ok = pool.GetLeaseByMac(message.Header.Hardware())
+	require.True(t, ok)
+	require.True(t, held.Permanent())
+	_, ok = pool.GetLeaseByIp(dhcp.IP)
+	require.False(t, ok)
+}
+
+func TestHardwareTypes(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}
+
+	// Clients get leases whatever their hardware, with the reply for the
+	// same type and address
+	offers := map[dhcp4.FixedV4]bool{}
+	for _, hw := range []dhcp4.HardwareAddr{
+		mac.Hardware(),
+		dhcp4.NewHardwareAddr(dhcp4.HTYPE_IEEE802, mac[:]),
+		dhcp4.NewHardwareAddr(dhcp4.HTYPE_IEEE1394, []byte{0, 0, 0, 0, 0, 1, 0, 0}),
+	} {
+		offer := NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, hw), &RequestContext{Pool: p}).Handle()
+		require.NotNil(t, offer)
+		require.Equal(t, dhcp4.DHCPOFFER, offer.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+		require.Equal(t, hw, offer.Header.Hardware())
+		offers[offer.Header.YourAddr] = true
+
+		lease, ok := p.GetLeaseByMac(hw)
+		require.True(t, ok)
+		require.Equal(t, offer.Header.YourAddr, lease.IP)
+	}
+
+	// Each being a client of its own
+	require.Len(t, offers, 3)
+
+	// Whereas those without an address can't be leased to
+	discover := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{}.Hardware())
+	discover.Header.HType = dhcp4.HTYPE_INFINIBAND
+	discover.Header.HLen = 0
+	require.Nil(t, NewRequestHandler(discover, &RequestContext{Pool: p}).Handle())
+}
+
+func TestRapidCommit(t *testing.T) {
+	pool := newTestPool()
+
+	// Not enabled on the pool, so we fall back to a regular offer
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Options.Set(dhcp4.OPTION_RAPID_COMMIT, nil)
+	response := NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	_, ok := response.Options.Get(dhcp4.OPTION_RAPID_COMMIT)
+	require.False(t, ok)
+
+	pool.RapidCommit = true
+
+	// Enabled, but the client didn't ask for it
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// Both agree, so we ACK straight away
+	message = newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware())
+	message.Options.Set(dhcp4.OPTION_RAPID_COMMIT, nil)
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	option, ok := response.Options.Get(dhcp4.OPTION_RAPID_COMMIT)
+	require.True(t, ok)
+	require.Empty(t, option.Data)
+}
+
+func TestV6OnlyPreferred(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	v6Only := func(op byte, mac dhcp4.HardwareAddr) *dhcp4.DHCPMessage {
+		message := newTestMessage(op, mac)
+		message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_ROUTER, dhcp4.OPTION_V6_ONLY})
+		if op == dhcp4.DHCPREQUEST {
+			message.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")))
+		}
+		return NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	}
+
+	// Not an IPv6-mostly network, so it gets an IP as usual
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	response := v6Only(dhcp4.DHCPDISCOVER, mac)
+	require.False(t, response.Header.YourAddr.Empty())
+	_, ok := response.Options.Get(dhcp4.OPTION_V6_ONLY)
+	require.False(t, ok)
+
+	pool.V6OnlyWait = 30 * time.Minute
+	mac = dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	response = v6Only(dhcp4.DHCPDISCOVER, mac)
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.True(t, response.Header.YourAddr.Empty())
+	wait, ok := response.Options.GetUint32(dhcp4.OPTION_V6_ONLY)
+	require.True(t, ok)
+	require.Equal(t, uint32(1800), wait)
+	require.Equal(t, pool.MyIp, response.Options.GetFixedV4s(dhcp4.OPTION_SERVER_ID)[0])
+	_, ok = pool.GetLeaseByMac(mac)
+	require.False(t, ok)
+
+	// Rebooting, it's told the same rather than given its IP
+	response = v6Only(dhcp4.DHCPREQUEST, mac)
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.True(t, response.Header.YourAddr.Empty())
+
+	// Clients which don't list it get an IP
+	response = NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: pool}).Handle()
+	require.False(t, response.Header.YourAddr.Empty())
+}
+
+func TestServerId(t *testing.T) {
+	pool := newTestPool()
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	response := NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPOFFER, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	offered := response.Header.YourAddr
+
+	// The client took another server's offer, so we keep quiet and free ours
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offered)
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.253")))
+	require.Nil(t, NewRequestHandler(request, &RequestContext{Pool: pool}).Handle())
+	_, ok := pool.GetLeaseByMac(mac)
+	require.False(t, ok)
+
+	// Nor do we NAK a client we have nothing for
+	request = newTestMessage(dhcp4.DHCPREQUEST, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.99")))
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.253")))
+	require.Nil(t, NewRequestHandler(request, &RequestContext{Pool: pool}).Handle())
+
+	// Taking our offer gets it ACKed
+	response = NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: pool}).Handle()
+	request = newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Header.ClientAddr = response.Header.YourAddr
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, pool.MyIp)
+	response = NewRequestHandler(request, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// A lease already taken up isn't given up by a stray request
+	request.Options.Override(dhcp4.OPTION_SERVER_ID, []byte{10, 0, 0, 253})
+	require.Nil(t, NewRequestHandler(request, &RequestContext{Pool: pool}).Handle())
+	_, ok = pool.GetLeaseByMac(mac)
+	require.True(t, ok)
+}
+
+func TestRequestStates(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease, err := pool.GetNextLease(mac, "")
+	require.Nil(t, err)
+
+	handle := func(request *dhcp4.DHCPMessage, ctx *RequestContext) (string, *dhcp4.DHCPMessage) {
+		ctx.Pool = pool
+		handler := NewRequestHandler(request, ctx)
+		return handler.requestState(), handler.Handle()
+	}
+	op := func(response *dhcp4.DHCPMessage) byte {
+		return response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+	}
+	rebooting := func(mac dhcp4.HardwareAddr, ip string) *dhcp4.DHCPMessage {
+		request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+		request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP(ip)))
+		return request
+	}
+
+	// Rebooting with the IP we gave it
+	state, response := handle(rebooting(mac, "10.0.0.10"), &RequestContext{})
+	require.Equal(t, STATE_INIT_REBOOT, state)
+	require.Equal(t, dhcp4.DHCPACK, op(response))
+	require.Equal(t, lease.IP, response.Header.YourAddr)
+
+	// With another IP on our network, or one from somewhere else entirely
+	_, response = handle(rebooting(mac, "10.0.0.11"), &RequestContext{})
+	require.Equal(t, dhcp4.DHCPNAK, op(response))
+	_, response = handle(rebooting(mac, "192.168.1.10"), &RequestContext{})
+	require.Equal(t, dhcp4.DHCPNAK, op(response))
+
+	// A client we know nothing about may be known to another server, unless
+	// it's on the wrong network altogether
+	stranger := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	_, response = handle(rebooting(stranger, "10.0.0.15"), &RequestContext{})
+	require.Nil(t, response)
+	_, response = handle(rebooting(stranger, "192.168.1.10"), &RequestContext{})
+	require.Equal(t, dhcp4.DHCPNAK, op(response))
+
+	// Renewing straight with us, or rebinding with anyone
+	renewing := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	renewing.Header.ClientAddr = lease.IP
+	state, response = handle(renewing, &RequestContext{Dst: net.ParseIP("10.0.0.254")})
+	require.Equal(t, STATE_RENEWING, state)
+	require.Equal(t, dhcp4.DHCPACK, op(response))
+	state, response = handle(renewing, &RequestContext{Dst: net.IPv4bcast})
+	require.Equal(t, STATE_REBINDING, state)
+	require.Equal(t, dhcp4.DHCPACK, op(response))
+
+	renewing.Header.ClientAddr = lease.IP + 1
+	_, response = handle(renewing, &RequestContext{})
+	require.Equal(t, dhcp4.DHCPNAK, op(response))
+
+	// Neither a requested IP nor ciaddr
+	state, response = handle(newTestMessage(dhcp4.DHCPREQUEST, mac), &RequestContext{})
+	require.Equal(t, "", state)
+	require.Nil(t, response)
+}
+
+func TestAuthoritative(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	rebooting := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	rebooting.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP("192.168.1.10")))
+	renewing := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	renewing.Header.ClientAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))
+
+	response := NewRequestHandler(rebooting, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	response = NewRequestHandler(renewing, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// Leaving clients we don't know to whoever does
+	pool.NotAuthoritative = true
+	require.Nil(t, NewRequestHandler(rebooting, &RequestContext{Pool: pool}).Handle())
+	require.Nil(t, NewRequestHandler(renewing, &RequestContext{Pool: pool}).Handle())
+
+	// But still NAKing ones we know to have another IP
+	_, err := pool.GetNextLease(mac, "")
+	require.Nil(t, err)
+	response = NewRequestHandler(renewing, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPNAK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	// From configuration
+	no := false
+	pc := &PoolConf{Name: "test", Network: "10.0.0.0", Netmask: "255.255.255.0", Start: "10.0.0.10", End: "10.0.0.20", MyIp: "10.0.0.254"}
+	p, err := pc.ToPool()
+	require.Nil(t, err)
+	require.False(t, p.NotAuthoritative)
+	pc.Authoritative = &no
+	p, err = pc.ToPool()
+	require.Nil(t, err)
+	require.True(t, p.NotAuthoritative)
+}
+
+func BenchmarkEncodeDhcpMessage(b *testing.B) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	p.Router = []net.IP{net.ParseIP("10.0.0.1")}
+	p.Dns = []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8")}
+	request := newTestMessage(dhcp4.DHCPREQUEST, dhcp4.MacAddress{0, 0x1c, 0x42, 0xb4, 0x6e, 0x1d}.Hardware())
+	request.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42})
+	lease := &pool.Lease{IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.10")), Mac: request.Header.Hardware()}
+	handler := NewRequestHandler(request, &RequestContext{Pool: p})
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		response := handler.SendLeaseInfo(lease, dhcp4.DHCPACK)
+		buf := dhcp4.GetEncodeBuffer()
+		if err := response.Encode(buf); err != nil {
+			b.Fatal(err)
+		}
+		dhcp4.PutEncodeBuffer(buf)
+		response.Release()
+	}
+}
+
+func TestVendorOptions(t *testing.T) {
+	p := newTestPool()
+	for _, vc := range []VendorOptionsConf{
+		{Enterprise: 3561, Options: []OptionConf{{Code: 1, Type: "string", Value: "http://acs.example.com"}}},
+		{Enterprise: 4491, Options: []OptionConf{{Code: 2, Type: "ip", Value: "10.0.0.5"}}},
+	} {
+		vendor, err := vc.ToVendorOptions()
+		require.Nil(t, err)
+		p.VendorOptions = append(p.VendorOptions, vendor)
+	}
+
+	vendorInfo := func(mac dhcp4.HardwareAddr, enterprises ...uint32) ([]dhcp4.VendorOptions, bool) {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, mac)
+		if len(enterprises) > 0 {
+			var classes []dhcp4.VendorClass
+			for _, enterprise := range enterprises {
+				classes = append(classes, dhcp4.VendorClass{Enterprise: enterprise, Data: [][]byte{[]byte("cpe")}})
+			}
+			message.Options.Set(dhcp4.OPTION_VI_CLASS, dhcp4.EncodeVendorClasses(classes))
+		}
+		response := NewRequestHandler(message, &RequestContext{Pool: p}).Handle()
+		require.NotNil(t, response)
+		option, ok := response.Options.Get(dhcp4.OPTION_VI_INFO)
+		if !ok {
+			return nil, false
+		}
+		vendors, err := dhcp4.ParseVendorOptions(option.Data)
+		require.Nil(t, err)
+		return vendors, true
+	}
+
+	// Only the enterprises the client identified as
+	vendors, ok := vendorInfo(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), 3561, 9)
+	require.True(t, ok)
+	require.Equal(t, []dhcp4.VendorOptions{{Enterprise: 3561, Options: []dhcp4.CustomOption{{Code: 1, Data: []byte("http://acs.example.com")}}}}, vendors)
+
+	vendors, ok = vendorInfo(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), 4491, 3561)
+	require.True(t, ok)
+	require.Len(t, vendors, 2)
+
+	_, ok = vendorInfo(dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware())
+	require.False(t, ok)
+	_, ok = vendorInfo(dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware(), 9)
+	require.False(t, ok)
+
+	_, err := (VendorOptionsConf{Enterprise: 3561}).ToVendorOptions()
+	require.NotNil(t, err)
+	_, err = (VendorOptionsConf{Enterprise: 3561, Options: []OptionConf{{Code: 1, Type: "string", Value: strings.Repeat("x", 254)}}}).ToVendorOptions()
+	require.NotNil(t, err)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Changing reservations at runtime through the admin API. Once a pool's
+// reservations have been changed, they're all written to <pool>.hosts.yaml
+// in leasedir, which is used rather than the configuration's from then on
+// so changes survive restarts. Delete the file to go back to the
+// configuration's. Only the client whose reservation changed has its lease
+// touched, releasing it if it's for another IP so the client picks up the
+// new one.
+//
+
+type Reservations struct {
+	m sync.Mutex
+
+	// Where changed reservations are written, nowhere if empty
+	dir string
+
+	// Current reservations, by pool
+	hosts map[string][]HostConf
+}
+
+func NewReservations(dir string) *Reservations {
+	return &Reservations{dir: dir, hosts: map[string][]HostConf{}}
+}
+
+func (r *Reservations) path(poolName string) string {
+	return filepath.Join(r.dir, poolName+".hosts.yaml")
+}
+
+// Take a pool's reservations from its file if they've been changed at
+// runtime, or otherwise keep track of the configuration's
+func (r *Reservations) Load(p *pool.Pool, configured []HostConf) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.hosts[p.Name] = configured
+	if r.dir == "" {
+		return nil
+	}
+	payload, err := ioutil.ReadFile(r.path(p.Name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var hostConfs []HostConf
+	if err := yaml.Unmarshal(payload, &hostConfs); err != nil {
+		return fmt.Errorf("Failed parsing %v: %v", r.path(p.Name), err)
+	}
+	hosts := make([]*pool.ReservedHost, 0, len(hostConfs))
+	for _, hc := range hostConfs {
+		host, err := hc.ToHost()
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+	if err := p.SetReservedHosts(hosts); err != nil {
+		return fmt.Errorf("%v: %v", r.path(p.Name), err)
+	}
+	r.hosts[p.Name] = hostConfs
+	log.Printf("Using pool %v's reservations from %v rather than the configuration", p.Name, r.path(p.Name))
+	return nil
+}
+
+// Replace a pool's reservations with ones from the configuration which have
+// changed, unless they've been changed at runtime. Clients whose reserved IP
+// changed have their lease released so they pick up the new one
+func (r *Reservations) Reload(p *pool.Pool, configured []HostConf) (bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.dir != "" {
+		if _, err := os.Stat(r.path(p.Name)); err == nil {
+			return false, nil
+		}
+	}
+
+	hosts := make([]*pool.ReservedHost, 0, len(configured))
+	for _, hc := range configured {
+		host, err := hc.ToHost()
+		if err != nil {
+			return false, err
+		}
+		hosts = append(hosts, host)
+	}
+	if err := p.SetReservedHosts(hosts); err != nil {
+		return false, err
+	}
+	r.hosts[p.Name] = configured
+
+	for _, host := range hosts {
+		if lease, ok := p.GetLeaseByMac(host.Mac); ok && lease.IP != host.IP {
+			p.ReleaseLeaseByMac(host.Mac)
+			log.Printf("Released %v's lease for %v, which is no longer its reserved IP", host.Mac.String(), lease.IP.String())
+		}
+	}
+	return true, nil
+}
+
+// Reservations of a pool
+func (r *Reservations) List(poolName string) []HostConf {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]HostConf(nil), r.hosts[poolName]...)
+}
+
+// Must be called with r.m held
+func (r *Reservations) save(poolName string, hosts []HostConf) error {
+	r.hosts[poolName] = hosts
+	if r.dir == "" {
+		return nil
+	}
+	payload, err := yaml.Marshal(hosts)
+	if err != nil {
+		return err
+	}
+	tmp := r.path(poolName) + ".tmp"
+	if err := ioutil.WriteFile(tmp, payload, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path(poolName))
+}
+
+// Index of the reservation for mac, or -1
+func findHostConf(hosts []HostConf, mac dhcp4.HardwareAddr) int {
+	for i := range hosts {
+		if key, err := hosts[i].Key(); err == nil && key == mac {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add a reservation to a pool, or change the client's existing one
+func (a *App) SetReservation(poolName string, hc HostConf) error {
+	p, err := a.findPoolByName(poolName)
+	if err != nil {
+		return err
+	}
+	host, err := hc.ToHost()
+	if err != nil {
+		return err
+	}
+	if !p.OnNetwork(host.IP) {
+		return fmt.Errorf("%v isn't on pool %v's network", host.IP.String(), p.Name)
+	}
+	for _, other := range a.pools() {
+		if other != p && other.Contains(host.IP) {
+			return fmt.Errorf("%v could be handed out by pool %v", host.IP.String(), other.Name)
+		}
+	}
+
+	a.reservations.m.Lock()
+	defer a.reservations.m.Unlock()
+
+	if err := p.SetReservedHost(host); err != nil {
+		return err
+	}
+	hosts := append([]HostConf(nil), a.reservations.hosts[p.Name]...)
+	if i := findHostConf(hosts, host.Mac); i >= 0 {
+		hosts[i] = hc
+	} else {
+		hosts = append(hosts, hc)
+	}
+	if err := a.reservations.save(p.Name, hosts); err != nil {
+		return fmt.Errorf("Reserved, but failed saving: %v", err)
+	}
+	log.Printf("Reserved %v for %v in pool %v", host.IP.String(), host.Mac.String(), p.Name)
+
+	if lease, ok := p.GetLeaseByMac(host.Mac); ok && lease.IP != host.IP {
+		p.ReleaseLeaseByMac(host.Mac)
+		log.Printf("Released %v's lease for %v, which is no longer its reserved IP", host.Mac.String(), lease.IP.String())
+	}
+	return nil
+}
+
+// Take a client's reservation out of a pool, leaving its lease be
+func (a *App) RemoveReservation(poolName string, mac dhcp4.HardwareAddr) error {
+	p, err := a.findPoolByName(poolName)
+	if err != nil {
+		return err
+	}
+
+	a.reservations.m.Lock()
+	defer a.reservations.m.Unlock()
+
+	if _, ok := p.RemoveReservedHost(mac); !ok {
+		return fmt.Errorf("No reservation for %v", mac.String())
+	}
+	hosts := append([]HostConf(nil), a.reservations.hosts[p.Name]...)
+	if i := findHostConf(hosts, mac); i >= 0 {
+		hosts = append(hosts[:i], hosts[i+1:]...)
+	}
+	if err := a.reservations.save(p.Name, hosts); err != nil {
+		return fmt.Errorf("Removed, but failed saving: %v", err)
+	}
+	log.Printf("Removed reservation for %v from pool %v", mac.String(), p.Name)
+	return nil
+}
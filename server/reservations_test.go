@@ -0,0 +1,79 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestRuntimeReservations(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	_, err := p.GetNextLease(mac, "")
+	require.Nil(t, err)
+	otherLease, err := p.GetNextLease(other, "")
+	require.Nil(t, err)
+
+	app := NewApp()
+	require.Nil(t, app.insertPool(p))
+	dir := t.TempDir()
+	app.reservations = NewReservations(dir)
+	require.Nil(t, app.reservations.Load(p, nil))
+	admin := app.AdminHandler()
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	// Reserving a client another IP releases its lease, and only its
+	rec := call(http.MethodPut, "/reservations?pool=test", `{"hw": "0:0:0:0:0:1", "ip": "10.0.0.15", "hostname": "printer"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	host, ok := p.GetReservedHost(mac)
+	require.True(t, ok)
+	require.Equal(t, "10.0.0.15", host.IP.String())
+	_, ok = p.GetLeaseByMac(mac)
+	require.False(t, ok)
+	lease, ok := p.GetLeaseByMac(other)
+	require.True(t, ok)
+	require.Equal(t, otherLease.IP, lease.IP)
+
+	// IPs leased to someone else, or off the network, can't be reserved
+	require.Equal(t, http.StatusBadRequest, call(http.MethodPut, "/reservations?pool=test", "hw: 0:0:0:0:0:3\nip: "+otherLease.IP.String()).Code)
+	require.Equal(t, http.StatusBadRequest, call(http.MethodPut, "/reservations?pool=test", "hw: 0:0:0:0:0:3\nip: 10.1.0.5").Code)
+	require.Equal(t, http.StatusNotFound, call(http.MethodGet, "/reservations?pool=bogus", "").Code)
+
+	// Changing one replaces it
+	require.Equal(t, http.StatusOK, call(http.MethodPut, "/reservations?pool=test", "hw: 0:0:0:0:0:1\nip: 10.0.0.16").Code)
+	rec = call(http.MethodGet, "/reservations?pool=test", "")
+	var listed []adminReservation
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Equal(t, []adminReservation{{Mac: "0:0:0:0:0:1", IP: "10.0.0.16"}}, listed)
+
+	// Changes survive a restart
+	restarted := newTestPool()
+	restarted.Name = "test"
+	require.Nil(t, NewReservations(dir).Load(restarted, []HostConf{{Mac: "0:0:0:0:0:9", IP: "10.0.0.19"}}))
+	host, ok = restarted.GetReservedHost(mac)
+	require.True(t, ok)
+	require.Equal(t, "10.0.0.16", host.IP.String())
+	_, ok = restarted.GetReservedHost(dhcp4.MacAddress{0, 0, 0, 0, 0, 9}.Hardware())
+	require.False(t, ok)
+
+	require.Equal(t, http.StatusOK, call(http.MethodDelete, "/reservations?pool=test&mac=0:0:0:0:0:1", "").Code)
+	require.Equal(t, http.StatusNotFound, call(http.MethodDelete, "/reservations?pool=test&mac=0:0:0:0:0:1", "").Code)
+	_, ok = p.GetReservedHost(mac)
+	require.False(t, ok)
+}
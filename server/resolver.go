@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Router-style local DNS for DHCP clients: a small authoritative DNS server
+// answering A queries for active leases' hostnames, qualified with their
+// pool's domain or not, and PTR queries for their IPs. Point a resolver at
+// it for the lease domain, such as an unbound stub-zone or a CoreDNS
+// forward block. Names are looked up the same way as for the hosts file,
+// and also through the admin API.
+//
+
+// TTL of answers, if not configured
+const defaultResolverTtl = 60
+
+// How long the names of active leases are cached between lookups
+const resolverCacheTime = time.Second
+
+const (
+	dnsTypePTR = 12
+
+	dnsFormErr  = 1
+	dnsNXDomain = 3
+	dnsNotImp   = 4
+)
+
+type LeaseResolver struct {
+	conn  net.PacketConn
+	ttl   uint32
+	pools func() []*pool.Pool
+
+	m      sync.Mutex
+	built  time.Time
+	byName map[string]dhcp4.FixedV4
+	byIp   map[dhcp4.FixedV4]string
+}
+
+// Listens straight away, so that it can be done before dropping privileges
+func NewLeaseResolver(conf *ResolverConf, pools func() []*pool.Pool) (*LeaseResolver, error) {
+	if conf.Listen == "" {
+		return nil, errors.New("Resolver needs an address to listen on")
+	}
+	r := newLeaseResolver(pools, conf.Ttl)
+	var err error
+	if r.conn, err = net.ListenPacket("udp", conf.Listen); err != nil {
+		return nil, fmt.Errorf("Failed listening for DNS on %v: %v", conf.Listen, err)
+	}
+	return r, nil
+}
+
+func newLeaseResolver(pools func() []*pool.Pool, ttl uint32) *LeaseResolver {
+	if ttl == 0 {
+		ttl = defaultResolverTtl
+	}
+	return &LeaseResolver{ttl: ttl, pools: pools}
+}
+
+// Must be called with r.m held
+func (r *LeaseResolver) refresh() {
+	if time.Since(r.built) < resolverCacheTime {
+		return
+	}
+	r.byName = map[string]dhcp4.FixedV4{}
+	r.byIp = map[dhcp4.FixedV4]string{}
+	for _, entry := range hostsEntries(r.pools()) {
+		r.byName[entry.name] = entry.ip
+		r.byIp[entry.ip] = entry.name
+		if entry.fqdn != "" {
+			r.byName[entry.fqdn] = entry.ip
+			r.byIp[entry.ip] = entry.fqdn
+		}
+	}
+	r.built = time.Now()
+}
+
+// The IP of an active lease's hostname, with or without its domain
+func (r *LeaseResolver) Lookup(name string) (dhcp4.FixedV4, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.refresh()
+	ip, ok := r.byName[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return ip, ok
+}
+
+// The name of the active lease with this IP
+func (r *LeaseResolver) Reverse(ip dhcp4.FixedV4) (string, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.refresh()
+	name, ok := r.byIp[ip]
+	return name, ok
+}
+
+// The IP in a name under in-addr.arpa
+func reverseName(name string) (dhcp4.FixedV4, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if len(labels) != 6 || labels[4] != "in-addr" || labels[5] != "arpa" {
+		return 0, false
+	}
+	ip := net.ParseIP(strings.Join([]string{labels[3], labels[2], labels[1], labels[0]}, "."))
+	if ip == nil {
+		return 0, false
+	}
+	return dhcp4.IpToFixedV4(ip), true
+}
+
+// The name asked about in a query, and where its question ends
+func parseQuestion(query []byte) (string, uint16, int, error) {
+	var labels []string
+	i := 12
+	for {
+		if i >= len(query) {
+			return "", 0, 0, errors.New("Truncated question")
+		}
+		n := int(query[i])
+		if n == 0 {
+			break
+		}
+		if n > 63 || i+1+n > len(query) {
+			return "", 0, 0, errors.New("Invalid question name")
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	if i+5 > len(query) {
+		return "", 0, 0, errors.New("Truncated question")
+	}
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	return strings.Join(labels, "."), qtype, i + 5, nil
+}
+
+// The response to a DNS query, if it deserves one
+func (r *LeaseResolver) Answer(query []byte) ([]byte, bool) {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return nil, false
+	}
+	flags := binary.BigEndian.Uint16(query[2:])
+	// Response, authoritative, keeping the opcode and whether recursion
+	// was desired
+	header := func(rcode byte, questions, answers int) []byte {
+		b := append([]byte(nil), query[:2]...)
+		b = binary.BigEndian.AppendUint16(b, 0x8400|flags&0x7900|uint16(rcode))
+		for _, count := range []int{questions, answers, 0, 0} {
+			b = binary.BigEndian.AppendUint16(b, uint16(count))
+		}
+		return b
+	}
+	if opcode := flags >> 11 & 0xf; opcode != 0 {
+		return header(dnsNotImp, 0, 0), true
+	}
+	if binary.BigEndian.Uint16(query[4:]) != 1 {
+		return header(dnsFormErr, 0, 0), true
+	}
+	name, qtype, end, err := parseQuestion(query)
+	if err != nil {
+		return header(dnsFormErr, 0, 0), true
+	}
+	question := query[12:end]
+
+	var answer *dnsRR
+	found := false
+	if ip, ok := reverseName(name); ok {
+		if host, ok := r.Reverse(ip); ok {
+			found = true
+			if qtype == dnsTypePTR || qtype == dnsTypeANY {
+				answer = &dnsRR{name: name, rtype: dnsTypePTR, class: dnsClassIN, ttl: r.ttl,
+					data: dhcp4.EncodeDomainNames([]string{host})}
+			}
+		}
+	} else if ip, ok := r.Lookup(name); ok {
+		found = true
+		if qtype == dnsTypeA || qtype == dnsTypeANY {
+			answer = &dnsRR{name: name, rtype: dnsTypeA, class: dnsClassIN, ttl: r.ttl, data: ip.NetIp().To4()}
+		}
+	}
+
+	if !found {
+		return append(header(dnsNXDomain, 1, 0), question...), true
+	}
+	if answer == nil {
+		// The name exists, with no records of this type
+		return append(header(dnsNoError, 1, 0), question...), true
+	}
+	return answer.appendTo(append(header(dnsNoError, 1, 1), question...)), true
+}
+
+func (r *LeaseResolver) Close() error {
+	return r.conn.Close()
+}
+
+func (r *LeaseResolver) Run() error {
+	log.Printf("Resolver listening on %v", r.conn.LocalAddr())
+	return r.Serve(r.conn)
+}
+
+func (r *LeaseResolver) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if response, ok := r.Answer(buf[:n]); ok {
+			if _, err := conn.WriteTo(response, addr); err != nil {
+				log.Printf("Failed answering DNS query from %v: %v", addr.String(), err)
+			}
+		}
+	}
+}
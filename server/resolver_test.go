@@ -0,0 +1,107 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func dnsQuery(name string, qtype uint16) []byte {
+	query := []byte{0xab, 0xcd, 0x01, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	query = append(query, dhcp4.EncodeDomainNames([]string{name})...)
+	query = binary.BigEndian.AppendUint16(query, qtype)
+	return binary.BigEndian.AppendUint16(query, dnsClassIN)
+}
+
+func TestResolver(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("10.0.0.0")
+	p.LeaseTime = time.Hour
+	p.Domain = "example.com"
+	lease, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "laptop")
+	require.Nil(t, err)
+
+	app := newTestApp(t, p)
+	r, err := NewLeaseResolver(&ResolverConf{Listen: "127.0.0.1:0", Ttl: 30}, app.pools)
+	require.Nil(t, err)
+	defer r.Close()
+	go r.Run()
+
+	client, err := net.Dial("udp", r.conn.LocalAddr().String())
+	require.Nil(t, err)
+	defer client.Close()
+
+	ask := func(query []byte) []byte {
+		_, err := client.Write(query)
+		require.Nil(t, err)
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 512)
+		n, err := client.Read(buf)
+		require.Nil(t, err)
+		require.Equal(t, query[:2], buf[:2])
+		return buf[:n]
+	}
+	rcode := func(response []byte) byte { return response[3] & 0xf }
+	answers := func(response []byte) int { return int(binary.BigEndian.Uint16(response[6:])) }
+
+	// With and without the domain, in any case
+	for _, name := range []string{"laptop.example.com", "LAPTOP", "laptop.example.com."} {
+		query := dnsQuery(name, dnsTypeA)
+		response := ask(query)
+		require.Equal(t, byte(dnsNoError), rcode(response))
+		require.NotZero(t, response[2]&0x04, "authoritative")
+		require.Equal(t, 1, answers(response))
+		require.Equal(t, lease.IP.NetIp().To4(), net.IP(response[len(response)-4:]))
+		ttl := binary.BigEndian.Uint32(response[len(response)-10:])
+		require.Equal(t, uint32(30), ttl)
+	}
+
+	// The name exists, but has no AAAA
+	response := ask(dnsQuery("laptop.example.com", dnsTypeAAAA))
+	require.Equal(t, byte(dnsNoError), rcode(response))
+	require.Equal(t, 0, answers(response))
+
+	response = ask(dnsQuery("printer.example.com", dnsTypeA))
+	require.Equal(t, byte(dnsNXDomain), rcode(response))
+
+	// Reverse
+	ip := lease.IP.NetIp().To4()
+	response = ask(dnsQuery(net.IPv4(ip[3], ip[2], ip[1], ip[0]).String()+".in-addr.arpa", dnsTypePTR))
+	require.Equal(t, byte(dnsNoError), rcode(response))
+	require.Equal(t, 1, answers(response))
+	require.Equal(t, dhcp4.EncodeDomainNames([]string{"laptop.example.com"}), response[len(response)-20:])
+
+	// Other opcodes, and malformed queries
+	query := dnsQuery("laptop", dnsTypeA)
+	query[2] |= 2 << 3
+	require.Equal(t, byte(dnsNotImp), rcode(ask(query)))
+	require.Equal(t, byte(dnsFormErr), rcode(ask(dnsQuery("laptop", dnsTypeA)[:15])))
+
+	// Responses aren't answered
+	_, ok := r.Answer(response)
+	require.False(t, ok)
+
+	handler := app.AdminHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resolve?name=laptop", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"name":"laptop","ip":"`+lease.IP.String()+`"}`, rec.Body.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resolve?ip="+lease.IP.String(), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"name":"laptop.example.com","ip":"`+lease.IP.String()+`"}`, rec.Body.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resolve?name=printer", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	_, err = NewLeaseResolver(&ResolverConf{}, app.pools)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Passive detection of rogue DHCP servers. We listen on the client port for
+// the OFFERs and ACKs other servers broadcast, and alert about any whose
+// server identifier is neither one of ours nor on the allowlist, once when
+// it's first seen and again every so often while it keeps answering. Only
+// replies which reach us are seen, so broadcast ones on our segments, and
+// nothing relayed elsewhere. The socket shares port 68 with any local DHCP
+// client.
+//
+
+const defaultRogueRealert = time.Hour
+
+// A server we've seen answering clients without being authorized to
+type RogueServer struct {
+	ServerId  string    `json:"server_id"`
+	Source    string    `json:"source"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Offers    int       `json:"offers"`
+	Acks      int       `json:"acks"`
+
+	// The last client it answered, and the IP it gave
+	Client string `json:"client"`
+	IP     string `json:"ip,omitempty"`
+
+	alerted time.Time
+}
+
+type RogueDetector struct {
+	conn    net.PacketConn
+	allowed []*net.IPNet
+	realert time.Duration
+	pools   func() []*pool.Pool
+	alerts  []AlertHook
+
+	m       sync.Mutex
+	servers map[string]*RogueServer
+}
+
+// Listens straight away, so that it can be done before dropping privileges
+func NewRogueDetector(conf *RogueConf, pools func() []*pool.Pool) (*RogueDetector, error) {
+	d := &RogueDetector{
+		realert: defaultRogueRealert,
+		pools:   pools,
+		servers: map[string]*RogueServer{},
+	}
+	if conf.Realert != 0 {
+		d.realert = time.Duration(conf.Realert) * time.Second
+	}
+	for _, entry := range conf.Allow {
+		cidr := entry
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || network.IP.To4() == nil {
+			return nil, fmt.Errorf("Invalid DHCP server address '%v'", entry)
+		}
+		d.allowed = append(d.allowed, network)
+	}
+
+	listen := conf.Listen
+	if listen == "" {
+		listen = "0.0.0.0:68"
+	}
+	config := net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			err := conn.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	var err error
+	if d.conn, err = config.ListenPacket(context.Background(), "udp4", listen); err != nil {
+		return nil, fmt.Errorf("Failed listening for rogue DHCP servers on %v: %v", listen, err)
+	}
+	return d, nil
+}
+
+func (d *RogueDetector) AddAlertHook(hook AlertHook) {
+	d.alerts = append(d.alerts, hook)
+}
+
+// Whether a server identifier is ours or allowed
+func (d *RogueDetector) authorized(serverId dhcp4.FixedV4) bool {
+	for _, p := range d.pools() {
+		if p.MyIp == serverId {
+			return true
+		}
+	}
+	for _, network := range d.allowed {
+		if network.Contains(serverId.NetIp()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Look at a packet sent to the client port from src, alerting if it's a
+// reply from a server we don't know
+func (d *RogueDetector) Observe(data []byte, src net.IP) {
+	message, err := dhcp4.ParseDhcpMessage(data)
+	if err != nil {
+		return
+	}
+	defer message.Release()
+
+	if message.Header.Op != dhcp4.BOOT_REPLY {
+		return
+	}
+	op := message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+	if op != dhcp4.DHCPOFFER && op != dhcp4.DHCPACK {
+		return
+	}
+	serverId := dhcp4.IpToFixedV4(src)
+	if id, ok := message.Options.GetUint32(dhcp4.OPTION_SERVER_ID); ok {
+		serverId = dhcp4.FixedV4(id)
+	}
+	if d.authorized(serverId) {
+		return
+	}
+
+	now := time.Now()
+	d.m.Lock()
+	server, ok := d.servers[serverId.String()]
+	if !ok {
+		server = &RogueServer{ServerId: serverId.String(), FirstSeen: now}
+		d.servers[server.ServerId] = server
+	}
+	server.Source = src.String()
+	server.LastSeen = now
+	if op == dhcp4.DHCPOFFER {
+		server.Offers++
+	} else {
+		server.Acks++
+	}
+	server.Client = message.Header.Hardware().String()
+	server.IP = ""
+	if !message.Header.YourAddr.Empty() {
+		server.IP = message.Header.YourAddr.String()
+	}
+	alert := now.Sub(server.alerted) >= d.realert
+	if alert {
+		server.alerted = now
+	}
+	record := EventRecord{
+		Event:  EVENT_ROGUE_SERVER,
+		Time:   now,
+		Mac:    server.Client,
+		IP:     server.IP,
+		Detail: fmt.Sprintf("Unauthorized DHCP server %v (from %v) sent %v", server.ServerId, server.Source, dhcp4.OpNames[op]),
+	}
+	d.m.Unlock()
+
+	if alert {
+		log.Printf("Rogue DHCP server: %v", record.Detail)
+		for _, hook := range d.alerts {
+			hook(record)
+		}
+	}
+}
+
+// Servers seen so far, by server identifier
+func (d *RogueDetector) Servers() []RogueServer {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	servers := make([]RogueServer, 0, len(d.servers))
+	for _, server := range d.servers {
+		servers = append(servers, *server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return dhcp4.IpToFixedV4(net.ParseIP(servers[i].ServerId)) < dhcp4.IpToFixedV4(net.ParseIP(servers[j].ServerId))
+	})
+	return servers
+}
+
+// Counts in the Prometheus text format
+func (d *RogueDetector) WriteMetrics(w io.Writer) {
+	servers := d.Servers()
+	fmt.Fprintf(w, "# HELP dhcp_rogue_servers Unauthorized DHCP servers seen\n# TYPE dhcp_rogue_servers gauge\n")
+	fmt.Fprintf(w, "dhcp_rogue_servers %v\n", len(servers))
+	fmt.Fprintf(w, "# HELP dhcp_rogue_server_replies_total Replies seen from unauthorized DHCP servers\n# TYPE dhcp_rogue_server_replies_total counter\n")
+	for _, server := range servers {
+		fmt.Fprintf(w, "dhcp_rogue_server_replies_total{server=%q,type=\"DHCPOFFER\"} %v\n", server.ServerId, server.Offers)
+		fmt.Fprintf(w, "dhcp_rogue_server_replies_total{server=%q,type=\"DHCPACK\"} %v\n", server.ServerId, server.Acks)
+	}
+}
+
+func (d *RogueDetector) Close() error {
+	return d.conn.Close()
+}
+
+// Read from the client port until closed or it fails
+func (d *RogueDetector) Run() error {
+	log.Printf("Watching for rogue DHCP servers on %v", d.conn.LocalAddr())
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Rogue detection failed reading: %v", err)
+			continue
+		}
+		if udpAddr, ok := addr.(*net.UDPAddr); ok {
+			d.Observe(buf[:n], udpAddr.IP)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func newTestReply(t *testing.T, op byte, serverId string, yiaddr string) []byte {
+	message := newTestMessage(op, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Header.Op = dhcp4.BOOT_REPLY
+	message.Header.YourAddr = dhcp4.IpToFixedV4(net.ParseIP(yiaddr))
+	message.Options.SetUint32(dhcp4.OPTION_SERVER_ID, uint32(dhcp4.IpToFixedV4(net.ParseIP(serverId))))
+	buf := new(bytes.Buffer)
+	require.Nil(t, message.Encode(buf))
+	return buf.Bytes()
+}
+
+func TestRogueDetector(t *testing.T) {
+	_, err := NewRogueDetector(&RogueConf{Allow: []string{"bogus"}}, nil)
+	require.NotNil(t, err)
+
+	p := newTestPool()
+	detector, err := NewRogueDetector(&RogueConf{Listen: "127.0.0.1:0", Allow: []string{"192.168.1.0/24"}}, func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+	defer detector.Close()
+	var alerts []EventRecord
+	detector.AddAlertHook(func(record EventRecord) {
+		alerts = append(alerts, record)
+	})
+	src := net.ParseIP("10.0.0.99")
+
+	// Ours, allowed servers, and requests from clients are fine
+	detector.Observe(newTestReply(t, dhcp4.DHCPOFFER, "10.0.0.254", "10.0.0.10"), src)
+	detector.Observe(newTestReply(t, dhcp4.DHCPACK, "192.168.1.1", "10.0.0.10"), src)
+	detector.Observe(newTestReply(t, dhcp4.DHCPNAK, "10.0.0.1", "0.0.0.0"), src)
+	request := new(bytes.Buffer)
+	require.Nil(t, newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()).Encode(request))
+	detector.Observe(request.Bytes(), src)
+	require.Empty(t, alerts)
+	require.Empty(t, detector.Servers())
+
+	// Others are alerted about once until it's time to again
+	detector.Observe(newTestReply(t, dhcp4.DHCPOFFER, "10.0.0.1", "10.0.0.50"), src)
+	detector.Observe(newTestReply(t, dhcp4.DHCPACK, "10.0.0.1", "10.0.0.50"), src)
+	require.Len(t, alerts, 1)
+	require.Equal(t, EVENT_ROGUE_SERVER, alerts[0].Event)
+	require.Equal(t, "10.0.0.50", alerts[0].IP)
+	require.Equal(t, "Unauthorized DHCP server 10.0.0.1 (from 10.0.0.99) sent DHCPOFFER", alerts[0].Detail)
+
+	servers := detector.Servers()
+	require.Len(t, servers, 1)
+	require.Equal(t, "10.0.0.1", servers[0].ServerId)
+	require.Equal(t, 1, servers[0].Offers)
+	require.Equal(t, 1, servers[0].Acks)
+	require.Equal(t, "0:0:0:0:0:1", servers[0].Client)
+
+	detector.realert = 0
+	detector.Observe(newTestReply(t, dhcp4.DHCPACK, "10.0.0.1", "10.0.0.50"), src)
+	require.Len(t, alerts, 2)
+	require.WithinDuration(t, time.Now(), alerts[1].Time, time.Second)
+
+	buf := new(bytes.Buffer)
+	detector.WriteMetrics(buf)
+	require.Contains(t, buf.String(), "dhcp_rogue_servers 1\n")
+	require.Contains(t, buf.String(), "dhcp_rogue_server_replies_total{server=\"10.0.0.1\",type=\"DHCPACK\"} 2\n")
+
+	// Replies reaching the socket it listens on are looked at
+	go detector.Run()
+	conn, err := net.Dial("udp4", detector.conn.LocalAddr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(newTestReply(t, dhcp4.DHCPOFFER, "10.0.0.2", "10.0.0.51"))
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		return len(detector.Servers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
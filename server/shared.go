@@ -0,0 +1,56 @@
+package server
+
+import (
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Shared networks, as ISC dhcpd has: several subnets on the one link, or
+// behind the one relay, each with its own pool. Whichever of them a request
+// comes in for, a client is served from the pool it has a lease or
+// reservation in, or the one the IP it asks for is on, and new clients get
+// an IP from the first pool with one free.
+//
+
+// The pool in p's shared network to serve the sender of a message from
+func (a *App) sharedPool(p *pool.Pool, message *dhcp4.DHCPMessage) *pool.Pool {
+	members := a.shared[p.SharedNetwork]
+	if p.SharedNetwork == "" || len(members) < 2 {
+		return p
+	}
+
+	for _, member := range members {
+		key := leaseKey(member, message)
+		if _, ok := member.GetLeaseByMac(key); ok {
+			return member
+		}
+		if _, ok := member.GetReservedHost(key); ok {
+			return member
+		}
+	}
+
+	ip, ok := message.Options.GetIP(dhcp4.OPTION_REQUESTED_IP)
+	if !ok {
+		ip = message.Header.ClientAddr
+	}
+	if !ip.Empty() {
+		for _, member := range members {
+			if member.OnNetwork(ip) {
+				return member
+			}
+		}
+	}
+	return p
+}
+
+// Pools to try allocating from in turn, the request's own first
+func (r *RequestHandler) sharedPools() []*pool.Pool {
+	pools := []*pool.Pool{r.ctx.Pool}
+	for _, p := range r.ctx.Shared {
+		if p != r.ctx.Pool {
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
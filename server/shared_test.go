@@ -0,0 +1,130 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestSharedNetwork(t *testing.T) {
+	first := newTestPool()
+	first.Name = "first"
+	first.Network = net.ParseIP("10.0.0.0")
+	first.End = net.ParseIP("10.0.0.11")
+	first.LeaseTime = time.Hour
+	first.SharedNetwork = "link"
+
+	second := newTestPool()
+	second.Name = "second"
+	second.Network = net.ParseIP("10.0.1.0")
+	second.Start = net.ParseIP("10.0.1.10")
+	second.End = net.ParseIP("10.0.1.20")
+	second.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.1.254"))
+	second.LeaseTime = time.Hour
+	second.SharedNetwork = "link"
+
+	app := NewApp()
+	require.Nil(t, app.insertPool(first))
+	require.Nil(t, app.insertPool(second))
+
+	// Requests all come in for the first pool, as they would on its link
+	handle := func(message *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		ctx := &RequestContext{Pool: app.sharedPool(first, message)}
+		ctx.Shared = app.shared[ctx.Pool.SharedNetwork]
+		return NewRequestHandler(message, ctx).Handle()
+	}
+	mac := func(i byte) dhcp4.HardwareAddr {
+		return dhcp4.MacAddress{0, 0, 0, 0, 0, i}.Hardware()
+	}
+
+	// Once the first pool is full, IPs come from the second
+	for i := byte(1); i <= 2; i++ {
+		offer := handle(newTestMessage(dhcp4.DHCPDISCOVER, mac(i)))
+		require.True(t, first.Contains(offer.Header.YourAddr))
+	}
+	offer := handle(newTestMessage(dhcp4.DHCPDISCOVER, mac(3)))
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.1.10")), offer.Header.YourAddr)
+	require.Equal(t, []dhcp4.FixedV4{second.MyIp}, offer.Options.GetFixedV4s(dhcp4.OPTION_SERVER_ID))
+
+	// And the client is served from there from then on
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac(3))
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, offer.Header.YourAddr)
+	request.Options.SetFixedV4s(dhcp4.OPTION_SERVER_ID, second.MyIp)
+	ack := handle(request)
+	require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	_, ok := second.GetLeaseByMac(mac(3))
+	require.True(t, ok)
+
+	// A rebooting client on either subnet is on the right network
+	rebooting := newTestMessage(dhcp4.DHCPREQUEST, mac(4))
+	rebooting.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP("10.0.1.15")))
+	require.Nil(t, handle(rebooting))
+
+	// Members have to be bound to the same interface
+	third := newTestPool()
+	third.Name = "third"
+	third.Network = net.ParseIP("10.0.2.0")
+	third.Interface = "eth1"
+	third.SharedNetwork = "link"
+	require.NotNil(t, app.insertPool(third))
+}
+
+func TestDrainingSharedNetwork(t *testing.T) {
+	first := newTestPool()
+	first.Name = "first"
+	first.Network = net.ParseIP("10.0.0.0")
+	first.LeaseTime = time.Hour
+	first.SharedNetwork = "link"
+
+	second := newTestPool()
+	second.Name = "second"
+	second.Network = net.ParseIP("10.0.1.0")
+	second.Start = net.ParseIP("10.0.1.10")
+	second.End = net.ParseIP("10.0.1.20")
+	second.MyIp = dhcp4.IpToFixedV4(net.ParseIP("10.0.1.254"))
+	second.LeaseTime = time.Hour
+	second.SharedNetwork = "link"
+
+	app := newTestApp(t, first, second)
+	handle := func(message *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		ctx := &RequestContext{Pool: app.sharedPool(first, message)}
+		ctx.Shared = app.shared[ctx.Pool.SharedNetwork]
+		return NewRequestHandler(message, ctx).Handle()
+	}
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease, err := first.GetNextLease(mac, "")
+	require.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain?pool=first", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drain", nil))
+	require.JSONEq(t, `["first"]`, rec.Body.String())
+
+	// The client with a lease keeps renewing it, while new ones move over
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Header.ClientAddr = lease.IP
+	ack := handle(request)
+	require.Equal(t, dhcp4.DHCPACK, ack.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+	require.Equal(t, lease.IP, ack.Header.YourAddr)
+	offer := handle(newTestMessage(dhcp4.DHCPDISCOVER, mac))
+	require.Equal(t, lease.IP, offer.Header.YourAddr)
+
+	offer = handle(newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()))
+	require.True(t, second.Contains(offer.Header.YourAddr))
+
+	rec = httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/drain?pool=first", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.False(t, first.Draining())
+	rec = httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/drain?pool=first", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,560 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Minimal read-only SNMP agent, for network management systems which can't
+// scrape /metrics. It answers v1 and v2c GET, GETNEXT and GETBULK requests
+// with the right community for a small tree of our own:
+//
+//   base.1.0            uptime (TimeTicks)
+//   base.2.1.1.type     message type name, by DHCP message type (0 is BOOTP)
+//   base.2.1.2.type     messages received (Counter32)
+//   base.2.1.3.type     messages sent (Counter32)
+//   base.3.1.1.index    pool name, by pool in name order from 1
+//   base.3.1.2.index    IPs in the pool's range (Gauge32)
+//   base.3.1.3.index    leased
+//   base.3.1.4.index    offered
+//   base.3.1.5.index    reserved
+//   base.3.1.6.index    abandoned
+//   base.3.1.7.index    free
+//
+// The base defaults to net-snmp's playpen, 1.3.6.1.4.1.8072.9999.9999, and
+// can be moved under an enterprise number of your own.
+//
+
+const defaultSnmpBase = "1.3.6.1.4.1.8072.9999.9999"
+
+// BER and SNMP tags we use
+const (
+	snmpInteger     = 0x02
+	snmpOctetString = 0x04
+	snmpNull        = 0x05
+	snmpOid         = 0x06
+	snmpSequence    = 0x30
+	snmpCounter32   = 0x41
+	snmpGauge32     = 0x42
+	snmpTimeTicks   = 0x43
+
+	snmpGetRequest     = 0xa0
+	snmpGetNextRequest = 0xa1
+	snmpResponse       = 0xa2
+	snmpSetRequest     = 0xa3
+	snmpGetBulkRequest = 0xa5
+
+	// v2c exceptions in place of a value
+	snmpNoSuchObject = 0x80
+	snmpEndOfMibView = 0x82
+)
+
+const (
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+)
+
+// Error statuses
+const (
+	snmpNoError     = 0
+	snmpNoSuchName  = 2
+	snmpReadOnly    = 4
+	snmpNotWritable = 17
+)
+
+type snmpOidValue []uint32
+
+func parseOid(s string) (snmpOidValue, error) {
+	var oid snmpOidValue
+	for _, part := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Bad OID '%v'", s)
+		}
+		oid = append(oid, uint32(n))
+	}
+	if len(oid) < 2 || oid[0] > 2 || oid[1] >= 40 {
+		return nil, fmt.Errorf("Bad OID '%v'", s)
+	}
+	return oid, nil
+}
+
+func (o snmpOidValue) append(sub ...uint32) snmpOidValue {
+	return append(append(snmpOidValue(nil), o...), sub...)
+}
+
+func compareOids(a, b snmpOidValue) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+//
+// BER encoding
+//
+
+func berAppendLength(buf []byte, n int) []byte {
+	if n < 0x80 {
+		return append(buf, byte(n))
+	}
+	var length []byte
+	for ; n > 0; n >>= 8 {
+		length = append([]byte{byte(n)}, length...)
+	}
+	return append(append(buf, 0x80|byte(len(length))), length...)
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	buf := berAppendLength([]byte{tag}, len(value))
+	return append(buf, value...)
+}
+
+func berInt(tag byte, n int64) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		if (n >= -0x80 && n < 0x80) || len(value) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return berTLV(tag, value)
+}
+
+// Unsigned types mustn't look negative
+func berUint(tag byte, n uint32) []byte {
+	value := []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	for len(value) > 1 && value[0] == 0 && value[1] < 0x80 {
+		value = value[1:]
+	}
+	if value[0] >= 0x80 {
+		value = append([]byte{0}, value...)
+	}
+	return berTLV(tag, value)
+}
+
+func berOid(oid snmpOidValue) []byte {
+	value := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var sub []byte
+		sub = append(sub, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			sub = append([]byte{0x80 | byte(n&0x7f)}, sub...)
+		}
+		value = append(value, sub...)
+	}
+	return berTLV(snmpOid, value)
+}
+
+func berSequence(tag byte, items ...[]byte) []byte {
+	return berTLV(tag, bytes.Join(items, nil))
+}
+
+//
+// BER decoding
+//
+
+// Split off the first TLV in buf
+func berNext(buf []byte) (tag byte, value, rest []byte, err error) {
+	if len(buf) < 2 {
+		return 0, nil, nil, errors.New("Truncated BER")
+	}
+	tag = buf[0]
+	n := int(buf[1])
+	buf = buf[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(buf) < size {
+			return 0, nil, nil, errors.New("Bad BER length")
+		}
+		n = 0
+		for _, b := range buf[:size] {
+			n = n<<8 | int(b)
+		}
+		buf = buf[size:]
+	}
+	if n > len(buf) {
+		return 0, nil, nil, errors.New("Truncated BER")
+	}
+	return tag, buf[:n], buf[n:], nil
+}
+
+// Split off the first TLV in buf, which must have this tag
+func berExpect(buf []byte, tag byte) ([]byte, []byte, error) {
+	got, value, rest, err := berNext(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if got != tag {
+		return nil, nil, fmt.Errorf("Expected BER tag %#x, got %#x", tag, got)
+	}
+	return value, rest, nil
+}
+
+func berParseInt(value []byte) (int64, error) {
+	if len(value) == 0 || len(value) > 8 {
+		return 0, errors.New("Bad BER integer")
+	}
+	n := int64(int8(value[0]))
+	for _, b := range value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+func berExpectInt(buf []byte) (int64, []byte, error) {
+	value, rest, err := berExpect(buf, snmpInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := berParseInt(value)
+	return n, rest, err
+}
+
+func berParseOid(value []byte) (snmpOidValue, error) {
+	if len(value) == 0 {
+		return nil, errors.New("Empty OID")
+	}
+	oid := snmpOidValue{uint32(value[0]) / 40, uint32(value[0]) % 40}
+	if oid[0] > 2 {
+		oid[0], oid[1] = 2, uint32(value[0])-80
+	}
+	var n uint32
+	for i, b := range value[1:] {
+		if n > 0x1ffffff {
+			return nil, errors.New("OID component too large")
+		}
+		n = n<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		} else if i == len(value)-2 {
+			return nil, errors.New("Truncated OID")
+		}
+	}
+	return oid, nil
+}
+
+//
+// The agent
+//
+
+type snmpRequest struct {
+	version    int64
+	community  []byte
+	pdu        byte
+	id         int64
+	nonRepeat  int64
+	maxRepeats int64
+	oids       []snmpOidValue
+}
+
+// One value in our tree, already encoded
+type snmpVar struct {
+	oid   snmpOidValue
+	value []byte
+}
+
+type SnmpAgent struct {
+	community []byte
+	base      snmpOidValue
+	conn      net.PacketConn
+	start     time.Time
+
+	counters *MessageCounters
+	pools    func() []*pool.Pool
+}
+
+// Listens straight away, so that it can be done before dropping privileges
+func NewSnmpAgent(conf *SnmpConf, counters *MessageCounters, pools func() []*pool.Pool) (*SnmpAgent, error) {
+	if conf.Listen == "" {
+		return nil, errors.New("SNMP agent needs an address to listen on")
+	}
+	a := &SnmpAgent{
+		community: []byte(conf.Community),
+		start:     time.Now(),
+		counters:  counters,
+		pools:     pools,
+	}
+	if conf.Community == "" {
+		a.community = []byte("public")
+	}
+	base := conf.Oid
+	if base == "" {
+		base = defaultSnmpBase
+	}
+	var err error
+	if a.base, err = parseOid(base); err != nil {
+		return nil, err
+	}
+	if a.conn, err = net.ListenPacket("udp", conf.Listen); err != nil {
+		return nil, fmt.Errorf("Failed listening for SNMP on %v: %v", conf.Listen, err)
+	}
+	return a, nil
+}
+
+func (a *SnmpAgent) Addr() net.Addr {
+	return a.conn.LocalAddr()
+}
+
+func (a *SnmpAgent) Close() error {
+	return a.conn.Close()
+}
+
+// Answer requests until closed
+func (a *SnmpAgent) Run() error {
+	log.Printf("SNMP agent listening on %v", a.conn.LocalAddr())
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := a.Handle(buf[:n])
+		if err != nil {
+			log.Printf("Ignoring SNMP request from %v: %v", addr, err)
+			continue
+		}
+		if response == nil {
+			continue
+		}
+		if _, err := a.conn.WriteTo(response, addr); err != nil {
+			log.Printf("Failed answering SNMP request from %v: %v", addr, err)
+		}
+	}
+}
+
+func parseSnmpRequest(data []byte) (*snmpRequest, error) {
+	message, _, err := berExpect(data, snmpSequence)
+	if err != nil {
+		return nil, err
+	}
+	req := &snmpRequest{}
+	if req.version, message, err = berExpectInt(message); err != nil {
+		return nil, err
+	}
+	if req.version != snmpVersion1 && req.version != snmpVersion2c {
+		return nil, fmt.Errorf("Unsupported SNMP version %v", req.version)
+	}
+	if req.community, message, err = berExpect(message, snmpOctetString); err != nil {
+		return nil, err
+	}
+
+	var pdu []byte
+	if req.pdu, pdu, _, err = berNext(message); err != nil {
+		return nil, err
+	}
+	if req.id, pdu, err = berExpectInt(pdu); err != nil {
+		return nil, err
+	}
+	if req.nonRepeat, pdu, err = berExpectInt(pdu); err != nil {
+		return nil, err
+	}
+	if req.maxRepeats, pdu, err = berExpectInt(pdu); err != nil {
+		return nil, err
+	}
+	varbinds, _, err := berExpect(pdu, snmpSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(varbinds) > 0 {
+		var varbind []byte
+		if varbind, varbinds, err = berExpect(varbinds, snmpSequence); err != nil {
+			return nil, err
+		}
+		value, _, err := berExpect(varbind, snmpOid)
+		if err != nil {
+			return nil, err
+		}
+		oid, err := berParseOid(value)
+		if err != nil {
+			return nil, err
+		}
+		req.oids = append(req.oids, oid)
+	}
+	return req, nil
+}
+
+// Response to a request, or nil if it's not one to answer
+func (a *SnmpAgent) Handle(data []byte) ([]byte, error) {
+	req, err := parseSnmpRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(req.community, a.community) {
+		return nil, errors.New("Wrong community")
+	}
+
+	vars := a.vars()
+	errorStatus, errorIndex := int64(snmpNoError), int64(0)
+	var varbinds [][]byte
+	switch req.pdu {
+	case snmpGetRequest, snmpGetNextRequest:
+		for i, oid := range req.oids {
+			v, ok := lookupSnmpVar(vars, oid, req.pdu == snmpGetNextRequest)
+			switch {
+			case ok:
+				varbinds = append(varbinds, berSequence(snmpSequence, berOid(v.oid), v.value))
+			case req.version == snmpVersion1:
+				errorStatus, errorIndex = snmpNoSuchName, int64(i+1)
+			case req.pdu == snmpGetRequest:
+				varbinds = append(varbinds, berSequence(snmpSequence, berOid(oid), berTLV(snmpNoSuchObject, nil)))
+			default:
+				varbinds = append(varbinds, berSequence(snmpSequence, berOid(oid), berTLV(snmpEndOfMibView, nil)))
+			}
+		}
+
+	case snmpGetBulkRequest:
+		if req.version == snmpVersion1 {
+			return nil, errors.New("GETBULK in an SNMPv1 request")
+		}
+		varbinds = bulkSnmpVars(vars, req)
+
+	case snmpSetRequest:
+		errorStatus, errorIndex = snmpNotWritable, 1
+		if req.version == snmpVersion1 {
+			errorStatus = snmpReadOnly
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported PDU type %#x", req.pdu)
+	}
+
+	// v1 errors, and sets, echo the request's varbinds
+	if errorStatus != snmpNoError {
+		varbinds = nil
+		for _, oid := range req.oids {
+			varbinds = append(varbinds, berSequence(snmpSequence, berOid(oid), berTLV(snmpNull, nil)))
+		}
+	}
+
+	pdu := berSequence(snmpResponse,
+		berInt(snmpInteger, req.id),
+		berInt(snmpInteger, errorStatus),
+		berInt(snmpInteger, errorIndex),
+		berSequence(snmpSequence, varbinds...))
+	return berSequence(snmpSequence, berInt(snmpInteger, req.version), berTLV(snmpOctetString, a.community), pdu), nil
+}
+
+// The value at oid, or with next the first after it
+func lookupSnmpVar(vars []snmpVar, oid snmpOidValue, next bool) (snmpVar, bool) {
+	i := sort.Search(len(vars), func(i int) bool { return compareOids(vars[i].oid, oid) >= 0 })
+	if next && i < len(vars) && compareOids(vars[i].oid, oid) == 0 {
+		i++
+	}
+	if i == len(vars) || (!next && compareOids(vars[i].oid, oid) != 0) {
+		return snmpVar{}, false
+	}
+	return vars[i], true
+}
+
+// A GETNEXT of each of the first non-repeaters OIDs, then up to
+// max-repetitions successive GETNEXTs of the rest
+func bulkSnmpVars(vars []snmpVar, req *snmpRequest) [][]byte {
+	nonRepeat := int(req.nonRepeat)
+	if nonRepeat < 0 {
+		nonRepeat = 0
+	}
+	if nonRepeat > len(req.oids) {
+		nonRepeat = len(req.oids)
+	}
+	maxRepeats := int(req.maxRepeats)
+	if maxRepeats < 0 {
+		maxRepeats = 0
+	}
+
+	var varbinds [][]byte
+	next := func(oid snmpOidValue) snmpOidValue {
+		v, ok := lookupSnmpVar(vars, oid, true)
+		if !ok {
+			varbinds = append(varbinds, berSequence(snmpSequence, berOid(oid), berTLV(snmpEndOfMibView, nil)))
+			return oid
+		}
+		varbinds = append(varbinds, berSequence(snmpSequence, berOid(v.oid), v.value))
+		return v.oid
+	}
+
+	for _, oid := range req.oids[:nonRepeat] {
+		next(oid)
+	}
+	repeating := append([]snmpOidValue(nil), req.oids[nonRepeat:]...)
+	for r := 0; r < maxRepeats && len(repeating) > 0 && len(varbinds) < snmpMaxVarbinds; r++ {
+		for i, oid := range repeating {
+			repeating[i] = next(oid)
+		}
+	}
+	return varbinds
+}
+
+// Most varbinds in a GETBULK response, to keep it to one datagram
+const snmpMaxVarbinds = 512
+
+// Our whole tree as it is now, in order
+func (a *SnmpAgent) vars() []snmpVar {
+	var vars []snmpVar
+	add := func(value []byte, sub ...uint32) {
+		vars = append(vars, snmpVar{a.base.append(sub...), value})
+	}
+
+	add(berUint(snmpTimeTicks, uint32(time.Since(a.start)/(10*time.Millisecond))), 1, 0)
+
+	received, sent := a.counters.Snapshot()
+	types := []byte{0}
+	for op := range dhcp4.OpNames {
+		types = append(types, op)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, op := range types {
+		add(berTLV(snmpOctetString, []byte(messageTypeName(op))), 2, 1, 1, uint32(op))
+	}
+	for _, op := range types {
+		add(berUint(snmpCounter32, uint32(received[op])), 2, 1, 2, uint32(op))
+	}
+	for _, op := range types {
+		add(berUint(snmpCounter32, uint32(sent[op])), 2, 1, 3, uint32(op))
+	}
+
+	pools := a.pools()
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	counts := make([]pool.Utilization, len(pools))
+	for i, p := range pools {
+		counts[i] = p.Utilization()
+	}
+	for i, p := range pools {
+		add(berTLV(snmpOctetString, []byte(p.Name)), 3, 1, 1, uint32(i+1))
+	}
+	columns := []func(pool.Utilization) int{
+		func(c pool.Utilization) int { return c.Total },
+		func(c pool.Utilization) int { return c.Leased },
+		func(c pool.Utilization) int { return c.Offered },
+		func(c pool.Utilization) int { return c.Reserved },
+		func(c pool.Utilization) int { return c.Abandoned },
+		func(c pool.Utilization) int { return c.Free },
+	}
+	for col, value := range columns {
+		for i := range pools {
+			add(berUint(snmpGauge32, uint32(value(counts[i]))), 3, 1, uint32(col+2), uint32(i+1))
+		}
+	}
+	return vars
+}
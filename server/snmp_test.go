@@ -0,0 +1,181 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func snmpTestRequest(version int64, community string, pdu byte, a, b int64, oids ...string) []byte {
+	var varbinds [][]byte
+	for _, s := range oids {
+		oid, _ := parseOid(s)
+		varbinds = append(varbinds, berSequence(snmpSequence, berOid(oid), berTLV(snmpNull, nil)))
+	}
+	return berSequence(snmpSequence,
+		berInt(snmpInteger, version),
+		berTLV(snmpOctetString, []byte(community)),
+		berSequence(pdu, berInt(snmpInteger, 42), berInt(snmpInteger, a), berInt(snmpInteger, b), berSequence(snmpSequence, varbinds...)))
+}
+
+type snmpTestVarbind struct {
+	oid   snmpOidValue
+	tag   byte
+	value []byte
+}
+
+// Error status and index, and varbinds, of a response
+func parseSnmpTestResponse(t *testing.T, data []byte) (int64, int64, []snmpTestVarbind) {
+	message, _, err := berExpect(data, snmpSequence)
+	require.Nil(t, err)
+	_, message, err = berExpectInt(message)
+	require.Nil(t, err)
+	_, message, err = berExpect(message, snmpOctetString)
+	require.Nil(t, err)
+	pdu, _, err := berExpect(message, snmpResponse)
+	require.Nil(t, err)
+	id, pdu, err := berExpectInt(pdu)
+	require.Nil(t, err)
+	require.Equal(t, int64(42), id)
+	status, pdu, err := berExpectInt(pdu)
+	require.Nil(t, err)
+	index, pdu, err := berExpectInt(pdu)
+	require.Nil(t, err)
+
+	var varbinds []snmpTestVarbind
+	list, _, err := berExpect(pdu, snmpSequence)
+	require.Nil(t, err)
+	for len(list) > 0 {
+		var varbind []byte
+		varbind, list, err = berExpect(list, snmpSequence)
+		require.Nil(t, err)
+		value, rest, err := berExpect(varbind, snmpOid)
+		require.Nil(t, err)
+		oid, err := berParseOid(value)
+		require.Nil(t, err)
+		tag, value, _, err := berNext(rest)
+		require.Nil(t, err)
+		varbinds = append(varbinds, snmpTestVarbind{oid, tag, value})
+	}
+	return status, index, varbinds
+}
+
+func TestBer(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		value, _, err := berExpect(berInt(snmpInteger, n), snmpInteger)
+		require.Nil(t, err)
+		parsed, err := berParseInt(value)
+		require.Nil(t, err)
+		require.Equal(t, n, parsed)
+	}
+	require.Equal(t, []byte{snmpCounter32, 1, 0}, berUint(snmpCounter32, 0))
+	require.Equal(t, []byte{snmpCounter32, 2, 0, 0x80}, berUint(snmpCounter32, 0x80))
+	require.Equal(t, []byte{snmpCounter32, 5, 0, 0xff, 0xff, 0xff, 0xff}, berUint(snmpCounter32, 0xffffffff))
+
+	oid, err := parseOid("1.3.6.1.4.1.8072.9999.9999.300")
+	require.Nil(t, err)
+	value, _, err := berExpect(berOid(oid), snmpOid)
+	require.Nil(t, err)
+	parsed, err := berParseOid(value)
+	require.Nil(t, err)
+	require.Equal(t, oid, parsed)
+	_, err = berParseOid([]byte{0x2b, 0x86})
+	require.NotNil(t, err)
+
+	// Long form lengths
+	long := berTLV(snmpOctetString, make([]byte, 300))
+	require.Equal(t, []byte{snmpOctetString, 0x82, 1, 44}, long[:4])
+	value, rest, err := berExpect(append(long, 5), snmpOctetString)
+	require.Nil(t, err)
+	require.Len(t, value, 300)
+	require.Equal(t, []byte{5}, rest)
+	_, _, err = berExpect(long[:100], snmpOctetString)
+	require.NotNil(t, err)
+
+	_, err = parseOid("1.3.x")
+	require.NotNil(t, err)
+}
+
+func TestSnmpAgent(t *testing.T) {
+	_, err := NewSnmpAgent(&SnmpConf{}, nil, nil)
+	require.NotNil(t, err)
+
+	p := newTestPool()
+	p.Name = "test"
+	p.LeaseTime = time.Hour
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	require.Nil(t, err)
+
+	counters := &MessageCounters{}
+	counters.Received(newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()))
+	counters.Received(newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()))
+
+	agent, err := NewSnmpAgent(&SnmpConf{Listen: "127.0.0.1:0", Community: "secret", Oid: "1.3.6.1.4.1.99"}, counters, func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+	defer agent.Close()
+
+	// Wrong community
+	_, err = agent.Handle(snmpTestRequest(snmpVersion2c, "public", snmpGetRequest, 0, 0, "1.3.6.1.4.1.99.1.0"))
+	require.NotNil(t, err)
+
+	// GET of DISCOVERs received and free IPs in the pool, and something
+	// that isn't there
+	response, err := agent.Handle(snmpTestRequest(snmpVersion2c, "secret", snmpGetRequest, 0, 0,
+		"1.3.6.1.4.1.99.2.1.2.1", "1.3.6.1.4.1.99.3.1.7.1", "1.3.6.1.4.1.99.3.1.7.2"))
+	require.Nil(t, err)
+	status, _, varbinds := parseSnmpTestResponse(t, response)
+	require.Equal(t, int64(snmpNoError), status)
+	require.Len(t, varbinds, 3)
+	require.Equal(t, snmpTestVarbind{snmpOidValue{1, 3, 6, 1, 4, 1, 99, 2, 1, 2, 1}, snmpCounter32, []byte{2}}, varbinds[0])
+	require.Equal(t, snmpTestVarbind{snmpOidValue{1, 3, 6, 1, 4, 1, 99, 3, 1, 7, 1}, snmpGauge32, []byte{10}}, varbinds[1])
+	require.Equal(t, byte(snmpNoSuchObject), varbinds[2].tag)
+
+	// v1 errors instead
+	response, err = agent.Handle(snmpTestRequest(snmpVersion1, "secret", snmpGetRequest, 0, 0, "1.3.6.1.4.1.99.1.0", "1.3.6.1.4.1.99.9"))
+	require.Nil(t, err)
+	status, index, _ := parseSnmpTestResponse(t, response)
+	require.Equal(t, int64(snmpNoSuchName), status)
+	require.Equal(t, int64(2), index)
+
+	// GETNEXT walks into the pool table, and off the end
+	response, err = agent.Handle(snmpTestRequest(snmpVersion2c, "secret", snmpGetNextRequest, 0, 0, "1.3.6.1.4.1.99.3", "1.3.6.1.4.1.99.3.1.7.1"))
+	require.Nil(t, err)
+	_, _, varbinds = parseSnmpTestResponse(t, response)
+	require.Equal(t, snmpTestVarbind{snmpOidValue{1, 3, 6, 1, 4, 1, 99, 3, 1, 1, 1}, snmpOctetString, []byte("test")}, varbinds[0])
+	require.Equal(t, byte(snmpEndOfMibView), varbinds[1].tag)
+
+	// GETBULK of the uptime, then the first three pool columns
+	response, err = agent.Handle(snmpTestRequest(snmpVersion2c, "secret", snmpGetBulkRequest, 1, 3, "1.3.6.1.4.1.99", "1.3.6.1.4.1.99.3.1"))
+	require.Nil(t, err)
+	_, _, varbinds = parseSnmpTestResponse(t, response)
+	require.Len(t, varbinds, 4)
+	require.Equal(t, snmpOidValue{1, 3, 6, 1, 4, 1, 99, 1, 0}, varbinds[0].oid)
+	require.Equal(t, byte(snmpTimeTicks), varbinds[0].tag)
+	require.Equal(t, snmpTestVarbind{snmpOidValue{1, 3, 6, 1, 4, 1, 99, 3, 1, 2, 1}, snmpGauge32, []byte{11}}, varbinds[2])
+	require.Equal(t, snmpTestVarbind{snmpOidValue{1, 3, 6, 1, 4, 1, 99, 3, 1, 3, 1}, snmpGauge32, []byte{1}}, varbinds[3])
+
+	// Read only
+	response, err = agent.Handle(snmpTestRequest(snmpVersion2c, "secret", snmpSetRequest, 0, 0, "1.3.6.1.4.1.99.1.0"))
+	require.Nil(t, err)
+	status, _, _ = parseSnmpTestResponse(t, response)
+	require.Equal(t, int64(snmpNotWritable), status)
+
+	// Over the network
+	go agent.Run()
+	conn, err := net.Dial("udp", agent.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(snmpTestRequest(snmpVersion2c, "secret", snmpGetRequest, 0, 0, "1.3.6.1.4.1.99.3.1.1.1"))
+	require.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	require.Nil(t, err)
+	_, _, varbinds = parseSnmpTestResponse(t, buf[:n])
+	require.Equal(t, []byte("test"), varbinds[0].value)
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Passive snooping. `mygodhcpd snoop` never sends anything, but listens on
+// both DHCP ports for whatever traffic reaches it and builds a table of
+// bindings, which client has which IP until when, from the ACKs servers
+// send. RELEASEs, DECLINEs and NAKs take a client's binding out, and
+// bindings drop out once they expire. The table is served as json over
+// HTTP and written to a file every interval if it changed, for switches'
+// DHCP snooping, IP source guard or inventory tooling to pick up. The file
+// is read back at startup so a restart doesn't forget anyone. Packets are
+// read through ordinary UDP sockets, so only what the kernel delivers to
+// this host is seen: broadcasts on its segments, and traffic addressed to
+// it. Unicast between other hosts isn't, even on a mirror port, as the
+// kernel drops packets for other addresses before they reach a socket.
+//
+
+type SnoopBinding struct {
+	Mac      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+	ServerId string `json:"server_id"`
+
+	// Relay agent the client is behind, if any, and where we saw the ACK
+	Relay     string `json:"relay,omitempty"`
+	Interface string `json:"interface,omitempty"`
+
+	// Zero for infinite leases
+	Expiration time.Time `json:"expiration"`
+}
+
+func (b *SnoopBinding) expired(now time.Time) bool {
+	return !b.Expiration.IsZero() && now.After(b.Expiration)
+}
+
+type Snooper struct {
+	m        sync.Mutex
+	bindings map[dhcp4.HardwareAddr]SnoopBinding
+
+	// Hostnames clients sent, for ACKs which don't echo them
+	hostnames map[dhcp4.HardwareAddr]string
+
+	path string
+	last []byte
+}
+
+func NewSnooper(path string) *Snooper {
+	return &Snooper{
+		bindings:  map[dhcp4.HardwareAddr]SnoopBinding{},
+		hostnames: map[dhcp4.HardwareAddr]string{},
+		path:      path,
+	}
+}
+
+// Look at a packet seen on iface
+func (s *Snooper) Observe(data []byte, iface string) {
+	message, err := dhcp4.ParseDhcpMessage(data)
+	if err != nil {
+		return
+	}
+	defer message.Release()
+
+	mac := message.Header.Hardware()
+	op := message.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE)
+	hostname, _ := message.Options.GetString(dhcp4.OPTION_HOST_NAME)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch {
+	case message.Header.Op == dhcp4.BOOT_REQUEST && op == dhcp4.DHCPREQUEST:
+		if hostname != "" {
+			s.hostnames[mac] = hostname
+		} else {
+			delete(s.hostnames, mac)
+		}
+	case message.Header.Op == dhcp4.BOOT_REQUEST && (op == dhcp4.DHCPRELEASE || op == dhcp4.DHCPDECLINE):
+		s.remove(mac, op)
+	case message.Header.Op == dhcp4.BOOT_REPLY && op == dhcp4.DHCPNAK:
+		s.remove(mac, op)
+	case message.Header.Op == dhcp4.BOOT_REPLY && op == dhcp4.DHCPACK:
+		// ACKs to INFORMs have no IP or lease time, so aren't bindings
+		leaseTime, ok := message.Options.GetUint32(dhcp4.OPTION_LEASE_TIME)
+		if !ok || message.Header.YourAddr.Empty() {
+			return
+		}
+		binding := SnoopBinding{
+			Mac:       mac.String(),
+			IP:        message.Header.YourAddr.String(),
+			Hostname:  hostname,
+			Interface: iface,
+		}
+		if binding.Hostname == "" {
+			binding.Hostname = s.hostnames[mac]
+		}
+		if id, ok := message.Options.GetUint32(dhcp4.OPTION_SERVER_ID); ok {
+			binding.ServerId = dhcp4.FixedV4(id).String()
+		}
+		if !message.Header.GatewayAddr.Empty() {
+			binding.Relay = message.Header.GatewayAddr.String()
+		}
+		if leaseTime != 0xffffffff {
+			binding.Expiration = time.Now().Add(time.Duration(leaseTime) * time.Second)
+		}
+
+		// An IP is only ever bound to one client
+		for other, b := range s.bindings {
+			if other != mac && b.IP == binding.IP {
+				delete(s.bindings, other)
+			}
+		}
+		s.bindings[mac] = binding
+		delete(s.hostnames, mac)
+	}
+}
+
+// Must be called with s.m held
+func (s *Snooper) remove(mac dhcp4.HardwareAddr, op byte) {
+	if _, ok := s.bindings[mac]; ok {
+		delete(s.bindings, mac)
+		log.Printf("Removed binding for %v after %v", mac.String(), dhcp4.OpNames[op])
+	}
+	delete(s.hostnames, mac)
+}
+
+// Unexpired bindings, by IP
+func (s *Snooper) Bindings() []SnoopBinding {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	bindings := make([]SnoopBinding, 0, len(s.bindings))
+	for mac, b := range s.bindings {
+		if b.expired(now) {
+			delete(s.bindings, mac)
+			continue
+		}
+		bindings = append(bindings, b)
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return dhcp4.IpToFixedV4(net.ParseIP(bindings[i].IP)) < dhcp4.IpToFixedV4(net.ParseIP(bindings[j].IP))
+	})
+	return bindings
+}
+
+// Pick up where we left off
+func (s *Snooper) Load() error {
+	payload, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var bindings []SnoopBinding
+	if err := json.Unmarshal(payload, &bindings); err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, b := range bindings {
+		mac, err := dhcp4.ParseHardwareAddr(b.Mac)
+		if err != nil {
+			return err
+		}
+		s.bindings[mac] = b
+	}
+	return nil
+}
+
+// Write the bindings out if they changed since last time
+func (s *Snooper) Write() (bool, error) {
+	payload, err := json.MarshalIndent(s.Bindings(), "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if s.last != nil && bytes.Equal(payload, s.last) {
+		return false, nil
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, payload, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return false, err
+	}
+	s.last = payload
+	return true, nil
+}
+
+// GET / with the bindings as json
+func (s *Snooper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, s.Bindings())
+}
+
+// Read packets from a socket on a DHCP port until it's closed
+func (s *Snooper) Listen(address string) error {
+	config := net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			err := conn.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := config.ListenPacket(context.Background(), "udp4", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	pconn := ipv4.NewPacketConn(conn)
+	if err := pconn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return err
+	}
+	log.Printf("Snooping on %v", address)
+
+	buf := make([]byte, 1500)
+	for {
+		n, cm, _, err := pconn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Failed reading: %v", err)
+			continue
+		}
+		iface := ""
+		if cm != nil {
+			if i, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
+				iface = i.Name
+			}
+		}
+		s.Observe(buf[:n], iface)
+	}
+}
+
+func RunSnoop(args []string) int {
+	var path, admin string
+	var interval time.Duration
+	flags := flag.NewFlagSet("snoop", flag.ExitOnError)
+	flags.StringVar(&path, "bindings", "bindings.json", "File to write the binding table to")
+	flags.DurationVar(&interval, "interval", 10*time.Second, "How often to write the binding table if it changed")
+	flags.StringVar(&admin, "admin", "", "Optional address to serve the binding table on over HTTP")
+	flags.Parse(args)
+
+	if interval <= 0 {
+		log.Printf("Usage: mygodhcpd snoop [-bindings file] [-interval 10s] [-admin addr]")
+		return 1
+	}
+
+	s := NewSnooper(path)
+	if err := s.Load(); err != nil {
+		log.Printf("Failed loading %v: %v", path, err)
+		return 1
+	}
+
+	errs := make(chan error, 3)
+	for _, address := range []string{"0.0.0.0:67", "0.0.0.0:68"} {
+		go func(address string) {
+			errs <- s.Listen(address)
+		}(address)
+	}
+	if admin != "" {
+		go func() {
+			log.Printf("Serving bindings on %v", admin)
+			errs <- http.ListenAndServe(admin, s)
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			log.Printf("Snooping failed: %v", err)
+			return 1
+		case <-ticker.C:
+			if _, err := s.Write(); err != nil {
+				log.Printf("Failed writing %v: %v", path, err)
+			}
+		}
+	}
+}
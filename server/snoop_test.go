@@ -0,0 +1,97 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+func encodeTestMessage(t *testing.T, message *dhcp4.DHCPMessage) []byte {
+	buf := new(bytes.Buffer)
+	require.Nil(t, message.Encode(buf))
+	return buf.Bytes()
+}
+
+func newTestAck(t *testing.T, mac dhcp4.HardwareAddr, ip string, leaseTime uint32) []byte {
+	ack := newTestMessage(dhcp4.DHCPACK, mac)
+	ack.Header.Op = dhcp4.BOOT_REPLY
+	ack.Header.YourAddr = dhcp4.IpToFixedV4(net.ParseIP(ip))
+	ack.Header.GatewayAddr = dhcp4.IpToFixedV4(net.ParseIP("10.0.0.1"))
+	ack.Options.SetUint32(dhcp4.OPTION_SERVER_ID, uint32(dhcp4.IpToFixedV4(net.ParseIP("10.0.0.254"))))
+	ack.Options.SetUint32(dhcp4.OPTION_LEASE_TIME, leaseTime)
+	return encodeTestMessage(t, ack)
+}
+
+func TestSnooper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bindings.json")
+	s := NewSnooper(path)
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	other := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+
+	// Hostnames come from the client's request
+	request := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	request.Options.Set(dhcp4.OPTION_HOST_NAME, []byte("laptop"))
+	s.Observe(encodeTestMessage(t, request), "eth0")
+	s.Observe(newTestAck(t, mac, "10.0.0.10", 3600), "eth0")
+	s.Observe(newTestAck(t, other, "10.0.0.11", 0xffffffff), "eth0")
+
+	// ACKs to INFORMs aren't bindings
+	inform := newTestMessage(dhcp4.DHCPACK, dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware())
+	inform.Header.Op = dhcp4.BOOT_REPLY
+	s.Observe(encodeTestMessage(t, inform), "eth0")
+
+	bindings := s.Bindings()
+	require.Len(t, bindings, 2)
+	require.Equal(t, "0:0:0:0:0:1", bindings[0].Mac)
+	require.Equal(t, "10.0.0.10", bindings[0].IP)
+	require.Equal(t, "laptop", bindings[0].Hostname)
+	require.Equal(t, "10.0.0.254", bindings[0].ServerId)
+	require.Equal(t, "10.0.0.1", bindings[0].Relay)
+	require.Equal(t, "eth0", bindings[0].Interface)
+	require.WithinDuration(t, time.Now().Add(time.Hour), bindings[0].Expiration, time.Second)
+	require.True(t, bindings[1].Expiration.IsZero())
+
+	// Another client being given the IP takes it over
+	s.Observe(newTestAck(t, dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware(), "10.0.0.11", 60), "eth0")
+	bindings = s.Bindings()
+	require.Len(t, bindings, 2)
+	require.Equal(t, "0:0:0:0:0:4", bindings[1].Mac)
+
+	// Written out, served, and read back
+	written, err := s.Write()
+	require.Nil(t, err)
+	require.True(t, written)
+	written, err = s.Write()
+	require.Nil(t, err)
+	require.False(t, written)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var served []SnoopBinding
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	require.Len(t, served, 2)
+
+	loaded := NewSnooper(path)
+	require.Nil(t, loaded.Load())
+	require.Len(t, loaded.Bindings(), 2)
+
+	// Releases and NAKs take bindings out, as does expiring
+	s.Observe(encodeTestMessage(t, newTestMessage(dhcp4.DHCPRELEASE, mac)), "eth0")
+	nak := newTestMessage(dhcp4.DHCPNAK, dhcp4.MacAddress{0, 0, 0, 0, 0, 4}.Hardware())
+	nak.Header.Op = dhcp4.BOOT_REPLY
+	s.Observe(encodeTestMessage(t, nak), "eth0")
+	require.Empty(t, s.Bindings())
+
+	s.Observe(newTestAck(t, mac, "10.0.0.10", 0), "eth0")
+	time.Sleep(time.Millisecond)
+	require.Empty(t, s.Bindings())
+}
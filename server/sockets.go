@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+//
+// Sockets we receive DHCP requests on. On large networks several can share
+// port 67 with SO_REUSEPORT, each with its own reader, so the kernel spreads
+// the load across cores. That only goes for unicast and relayed requests:
+// each socket gets its own copy of a broadcast, so broadcasts are only
+// handled as read from the first.
+//
+
+// Bind count sockets to port 67
+func ListenDhcp(count int) ([]*net.UDPConn, error) {
+	return listenShared("0.0.0.0:67", count)
+}
+
+func listenShared(address string, count int) ([]*net.UDPConn, error) {
+	if count < 1 {
+		count = 1
+	}
+
+	config := net.ListenConfig{}
+	if count > 1 {
+		config.Control = func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			err := conn.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+
+	sockets := make([]*net.UDPConn, 0, count)
+	for i := 0; i < count; i++ {
+		conn, err := config.ListenPacket(context.Background(), "udp4", address)
+		if err != nil {
+			for _, socket := range sockets {
+				socket.Close()
+			}
+			return nil, err
+		}
+		sockets = append(sockets, conn.(*net.UDPConn))
+	}
+	return sockets, nil
+}
+
+// Options every socket we receive on needs
+func SetupDhcpSocket(ln *net.UDPConn) error {
+	conn, err := ln.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// Boilerplate to get additional OOB data with each incoming packet, which
+	// includes the ID of the incoming interface
+	var sockErr error
+	err = conn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed enabling IP_PKTINFO: %v", err)
+	}
+
+	return ln.SetReadBuffer(1048576)
+}
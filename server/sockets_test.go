@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenShared(t *testing.T) {
+	// Find a free port
+	free, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	address := free.LocalAddr().String()
+	free.Close()
+
+	sockets, err := listenShared(address, 3)
+	require.Nil(t, err)
+	require.Len(t, sockets, 3)
+	for _, socket := range sockets {
+		require.Equal(t, address, socket.LocalAddr().String())
+		require.Nil(t, SetupDhcpSocket(socket))
+		socket.Close()
+	}
+}
+
+func TestSharedSocketsBroadcast(t *testing.T) {
+	app, _, _ := newMemoryApp(t)
+
+	// Find a free port, listening on any address so broadcasts reach us
+	free, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	require.Nil(t, err)
+	port := free.LocalAddr().(*net.UDPAddr).Port
+	free.Close()
+
+	sockets, err := listenShared(fmt.Sprintf("0.0.0.0:%v", port), 2)
+	require.Nil(t, err)
+	for _, socket := range sockets {
+		require.Nil(t, SetupDhcpSocket(socket))
+	}
+	app.SetSockets(sockets...)
+
+	handled := make(chan PacketConn, 4)
+	app.workers.Start(func(p packet) {
+		handled <- p.socket
+	})
+	for i, socket := range app.sockets {
+		go app.receive(socket, i == 0)
+	}
+	defer app.Stop()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	defer client.Close()
+	raw, err := client.SyscallConn()
+	require.Nil(t, err)
+	require.Nil(t, raw.Control(func(fd uintptr) {
+		require.Nil(t, syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1))
+	}))
+
+	// A broadcast reaches both sockets but is handled once, by the first
+	_, err = client.WriteToUDP([]byte("broadcast"), &net.UDPAddr{IP: net.ParseIP("127.255.255.255"), Port: port})
+	require.Nil(t, err)
+	select {
+	case socket := <-handled:
+		require.Equal(t, app.sockets[0], socket)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Broadcast wasn't handled")
+	}
+
+	// Unicasts are handled by whichever socket they reach
+	_, err = client.WriteToUDP([]byte("unicast"), &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	require.Nil(t, err)
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unicast wasn't handled")
+	}
+	select {
+	case <-handled:
+		t.Fatal("Handled a packet twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// Defence against DHCP starvation, where an attacker sends DISCOVERs from
+// endless made up macs to use up every IP in a pool. Clients we already
+// have a lease or reservation for are always answered, but only so many
+// new ones get an offer in each time window. Going over the limit fires an
+// alert, once per window.
+//
+
+// Observed counts for a pool, for the admin API
+type StarvationStats struct {
+	// Offers made to clients we hadn't seen, and DISCOVERs dropped
+	// for going over the limit
+	Offered int `json:"offered"`
+	Dropped int `json:"dropped"`
+	Alerts  int `json:"alerts"`
+
+	// In the current window
+	WindowOffered int `json:"window_offered"`
+	WindowDropped int `json:"window_dropped"`
+}
+
+type starvationWindow struct {
+	start   time.Time
+	alerted bool
+	stats   StarvationStats
+}
+
+// Called when a pool goes over its limit of new clients
+type AlertHook func(record EventRecord)
+
+type StarvationGuard struct {
+	window time.Duration
+	maxNew int
+	alerts []AlertHook
+
+	m     sync.Mutex
+	pools map[string]*starvationWindow
+}
+
+func NewStarvationGuard(conf *StarvationConf) (*StarvationGuard, error) {
+	if conf.MaxNew <= 0 {
+		return nil, errors.New("Starvation protection needs maxnew")
+	}
+
+	g := &StarvationGuard{
+		window: 10 * time.Second,
+		maxNew: conf.MaxNew,
+		pools:  map[string]*starvationWindow{},
+	}
+	if conf.Window != 0 {
+		g.window = time.Duration(conf.Window) * time.Second
+	}
+	return g, nil
+}
+
+func (g *StarvationGuard) AddAlertHook(hook AlertHook) {
+	g.alerts = append(g.alerts, hook)
+}
+
+// Whether to answer a DISCOVER from mac in pool
+func (g *StarvationGuard) Allow(pool *pool.Pool, mac dhcp4.HardwareAddr) bool {
+	if _, ok := pool.GetLeaseByMac(mac); ok {
+		return true
+	}
+	if _, ok := pool.GetReservedHost(mac); ok {
+		return true
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	now := time.Now()
+	w, ok := g.pools[pool.Name]
+	if !ok {
+		w = &starvationWindow{start: now}
+		g.pools[pool.Name] = w
+	}
+	if now.Sub(w.start) >= g.window {
+		w.start = now
+		w.alerted = false
+		w.stats.WindowOffered = 0
+		w.stats.WindowDropped = 0
+	}
+
+	if w.stats.WindowOffered < g.maxNew {
+		w.stats.Offered++
+		w.stats.WindowOffered++
+		return true
+	}
+
+	w.stats.Dropped++
+	w.stats.WindowDropped++
+	if !w.alerted {
+		w.alerted = true
+		w.stats.Alerts++
+		g.alert(pool, mac)
+	}
+	return false
+}
+
+func (g *StarvationGuard) alert(pool *pool.Pool, mac dhcp4.HardwareAddr) {
+	record := EventRecord{
+		Event:  EVENT_STARVATION,
+		Time:   time.Now(),
+		Pool:   pool.Name,
+		Mac:    mac.String(),
+		Detail: fmt.Sprintf("More than %v new clients within %v; ignoring DISCOVERs from new clients", g.maxNew, g.window),
+	}
+	log.Printf("Possible starvation attack on pool %v: %v", pool.Name, record.Detail)
+	for _, hook := range g.alerts {
+		hook(record)
+	}
+}
+
+// Counts for each pool we've seen new clients in
+func (g *StarvationGuard) Stats() map[string]StarvationStats {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	stats := map[string]StarvationStats{}
+	for name, w := range g.pools {
+		s := w.stats
+		if time.Since(w.start) >= g.window {
+			s.WindowOffered = 0
+			s.WindowDropped = 0
+		}
+		stats[name] = s
+	}
+	return stats
+}
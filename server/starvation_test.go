@@ -0,0 +1,91 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestStarvationGuard(t *testing.T) {
+	_, err := NewStarvationGuard(&StarvationConf{})
+	require.NotNil(t, err)
+
+	guard, err := NewStarvationGuard(&StarvationConf{MaxNew: 2})
+	require.Nil(t, err)
+
+	var alerts []EventRecord
+	guard.AddAlertHook(func(record EventRecord) {
+		alerts = append(alerts, record)
+	})
+
+	p := newTestPool()
+	p.Name = "test"
+	p.LeaseTime = time.Hour
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	require.Nil(t, err)
+	require.Nil(t, p.AddReservedHost(&pool.ReservedHost{Mac: dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.100"))}))
+
+	require.True(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 1}.Hardware()))
+	require.True(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 2}.Hardware()))
+	require.False(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 3}.Hardware()))
+	require.False(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 4}.Hardware()))
+
+	// Known clients are still answered
+	require.True(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()))
+	require.True(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()))
+
+	// Alerted only once per window
+	require.Len(t, alerts, 1)
+	require.Equal(t, EVENT_STARVATION, alerts[0].Event)
+	require.Equal(t, "test", alerts[0].Pool)
+	require.Equal(t, "0:0:0:0:1:3", alerts[0].Mac)
+
+	require.Equal(t, StarvationStats{Offered: 2, Dropped: 2, Alerts: 1, WindowOffered: 2, WindowDropped: 2}, guard.Stats()["test"])
+
+	// Next window
+	guard.pools["test"].start = time.Now().Add(-guard.window)
+	require.Equal(t, StarvationStats{Offered: 2, Dropped: 2, Alerts: 1}, guard.Stats()["test"])
+	require.True(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 5}.Hardware()))
+	require.True(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 6}.Hardware()))
+	require.False(t, guard.Allow(p, dhcp4.MacAddress{0, 0, 0, 0, 1, 7}.Hardware()))
+	require.Len(t, alerts, 2)
+}
+
+func TestOfferTime(t *testing.T) {
+	pool := newTestPool()
+	pool.LeaseTime = time.Hour
+	pool.OfferTime = 30 * time.Second
+
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	response := NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: pool}).Handle()
+	leaseTime, _ := response.Options.GetUint32(dhcp4.OPTION_LEASE_TIME)
+	require.Equal(t, uint32(3600), leaseTime)
+
+	// Held briefly until requested
+	lease, ok := pool.GetLeaseByMac(mac)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(30*time.Second), lease.Expiration, time.Second)
+	require.True(t, lease.Offered)
+
+	// Discovering again is offered the same IP, still only held
+	response = NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, lease.IP, response.Header.YourAddr)
+	lease, ok = pool.GetLeaseByMac(mac)
+	require.True(t, ok)
+	require.True(t, lease.Offered)
+
+	message := newTestMessage(dhcp4.DHCPREQUEST, mac)
+	message.Header.ClientAddr = lease.IP
+	response = NewRequestHandler(message, &RequestContext{Pool: pool}).Handle()
+	require.Equal(t, dhcp4.DHCPACK, response.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE))
+
+	lease, ok = pool.GetLeaseByMac(mac)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Hour), lease.Expiration, time.Second)
+	require.False(t, lease.Offered)
+}
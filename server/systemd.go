@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// Running under systemd: taking our socket from a socket unit, so port 67
+// is bound by systemd rather than us needing root, and telling it when
+// we're ready or stopping so units can use Type=notify. The notify socket
+// is connected to before chrooting, as its path is outside the new root.
+//
+
+// First descriptor passed by socket activation
+const sdListenFdsStart = 3
+
+// Socket passed to us by systemd, or nil if we weren't socket activated
+func SystemdListener() (*net.UDPConn, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds == 0 {
+		return nil, nil
+	}
+
+	// Not for any children we run
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds != 1 {
+		return nil, fmt.Errorf("Expected one socket from systemd, got %v", fds)
+	}
+
+	file := os.NewFile(sdListenFdsStart, "systemd")
+	defer file.Close()
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed using socket from systemd: %v", err)
+	}
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("Socket from systemd is %T, not UDP", conn)
+	}
+	return udp, nil
+}
+
+// Connection to systemd's notify socket, once opened
+var sdNotify struct {
+	m    sync.Mutex
+	conn *net.UnixConn
+}
+
+func dialSdNotify() (*net.UnixConn, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil, nil
+	}
+
+	// Abstract namespace
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	return net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+}
+
+// Connect to systemd's notify socket while we can still reach it, for
+// SdNotify to use from then on
+func OpenSdNotify() error {
+	conn, err := dialSdNotify()
+	if err != nil {
+		return err
+	}
+	sdNotify.m.Lock()
+	defer sdNotify.m.Unlock()
+	if sdNotify.conn != nil {
+		sdNotify.conn.Close()
+	}
+	sdNotify.conn = conn
+	return nil
+}
+
+// Tell systemd about our state, e.g. READY=1, if it's listening
+func SdNotify(state string) error {
+	sdNotify.m.Lock()
+	defer sdNotify.m.Unlock()
+	if sdNotify.conn != nil {
+		_, err := sdNotify.conn.Write([]byte(state))
+		return err
+	}
+
+	conn, err := dialSdNotify()
+	if err != nil || conn == nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
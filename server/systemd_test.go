@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSdNotify(t *testing.T) {
+	// Not running under systemd
+	t.Setenv("NOTIFY_SOCKET", "")
+	require.Nil(t, SdNotify("READY=1"))
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	require.Nil(t, SdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.Nil(t, err)
+	require.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestOpenSdNotify(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	require.Nil(t, OpenSdNotify())
+	defer func() {
+		sdNotify.conn.Close()
+		sdNotify.conn = nil
+	}()
+
+	// Still reaches systemd once the path is gone, as after chrooting
+	require.Nil(t, os.Rename(path, filepath.Join(dir, "moved")))
+	require.Nil(t, SdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.Nil(t, err)
+	require.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	// Meant for another process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	ln, err := SystemdListener()
+	require.Nil(t, err)
+	require.Nil(t, ln)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Verbose logging of everything we do for chosen clients, switched on at
+// runtime through the admin API, for debugging one client without flooding
+// the logs for the whole network. Clients are picked by mac address or
+// client identifier.
+//
+
+type Tracer struct {
+	m sync.RWMutex
+
+	// Keyed by normalized mac or client id, to when tracing stops. Zero
+	// means never
+	traced map[string]time.Time
+}
+
+func NewTracer() *Tracer {
+	return &Tracer{traced: map[string]time.Time{}}
+}
+
+func macTraceKey(mac dhcp4.HardwareAddr) string {
+	return "mac " + mac.String()
+}
+
+func clientIdTraceKey(id []byte) string {
+	return "client-id " + hex.EncodeToString(id)
+}
+
+// Parse a client id given as hex, with or without colons
+func ParseClientIdTraceKey(s string) (string, error) {
+	id, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(id) == 0 {
+		return "", fmt.Errorf("Invalid client id '%v'", s)
+	}
+	return clientIdTraceKey(id), nil
+}
+
+// Trace the client for the given time, or forever if zero
+func (t *Tracer) Enable(key string, duration time.Duration) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	var until time.Time
+	if duration != 0 {
+		until = time.Now().Add(duration)
+	}
+	t.traced[key] = until
+}
+
+func (t *Tracer) Disable(key string) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	_, ok := t.traced[key]
+	delete(t.traced, key)
+	return ok
+}
+
+// Clients being traced, and until when
+func (t *Tracer) List() map[string]time.Time {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	list := map[string]time.Time{}
+	for key, until := range t.traced {
+		if !until.IsZero() && time.Now().After(until) {
+			delete(t.traced, key)
+			continue
+		}
+		list[key] = until
+	}
+	return list
+}
+
+// Key the message's client is being traced under, or empty if it isn't
+func (t *Tracer) Traced(message *dhcp4.DHCPMessage) string {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	if len(t.traced) == 0 {
+		return ""
+	}
+
+	keys := []string{macTraceKey(message.Header.Hardware())}
+	if option, ok := message.Options.Get(dhcp4.OPTION_CLIENT_ID); ok {
+		keys = append(keys, clientIdTraceKey(option.Data))
+	}
+	for _, key := range keys {
+		if until, ok := t.traced[key]; ok && (until.IsZero() || time.Now().Before(until)) {
+			return key
+		}
+	}
+	return ""
+}
+
+// Log only if this request's client is being traced
+func (c *RequestContext) Tracef(format string, args ...interface{}) {
+	if c == nil || c.Trace == "" {
+		return
+	}
+	log.Printf("[trace %v] %v", c.Trace, fmt.Sprintf(format, args...))
+}
+
+// Log a whole message, header and options, if this client is being traced
+func (c *RequestContext) TraceMessage(label string, message *dhcp4.DHCPMessage) {
+	if c == nil || c.Trace == "" {
+		return
+	}
+
+	h := message.Header
+	c.Tracef("%v: op=%v xid=%08x secs=%v flags=%04x ciaddr=%v yiaddr=%v siaddr=%v giaddr=%v chaddr=%v",
+		label, h.Op, h.Identifier, h.Secs, h.Flags, h.ClientAddr.String(), h.YourAddr.String(),
+		h.ServerAddr.String(), h.GatewayAddr.String(), h.Mac.String())
+	for _, code := range message.Options.Codes() {
+		option, _ := message.Options.Get(code)
+		c.Tracef("%v: option %v = %x", label, code, option.Data)
+	}
+}
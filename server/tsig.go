@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// TSIG (RFC 8945), signing dynamic DNS updates with a key shared with the
+// DNS server, as most servers require. The signature is an HMAC of the
+// message and the time it was signed, in a record appended to it. Responses
+// are signed by the server in turn, covering our request's signature, and
+// checked before we trust what they say.
+//
+
+const dnsTypeTSIG = 250
+
+// Seconds our clock and the DNS server's may differ by
+const tsigFudge = 300
+
+// TSIG errors, in place of an rcode the server can't give
+var tsigErrorNames = map[uint16]string{
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+	22: "BADTRUNC",
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha384": sha512.New384,
+	"hmac-sha512": sha512.New,
+}
+
+type TsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+	hash      func() hash.Hash
+}
+
+func NewTsigKey(conf *TsigKeyConf) (*TsigKey, error) {
+	k := &TsigKey{
+		name:      strings.ToLower(strings.TrimSuffix(conf.Name, ".")),
+		algorithm: strings.ToLower(conf.Algorithm),
+	}
+	if k.name == "" {
+		return nil, errors.New("TSIG key needs a name")
+	}
+	if k.algorithm == "" {
+		k.algorithm = "hmac-sha256"
+	}
+	var ok bool
+	if k.hash, ok = tsigAlgorithms[k.algorithm]; !ok {
+		return nil, fmt.Errorf("Unknown TSIG algorithm '%v'. Supported are hmac-sha256, hmac-sha384 and hmac-sha512", conf.Algorithm)
+	}
+	var err error
+	if k.secret, err = base64.StdEncoding.DecodeString(conf.Secret); err != nil || len(k.secret) == 0 {
+		return nil, fmt.Errorf("Invalid secret for TSIG key %v", k.name)
+	}
+	return k, nil
+}
+
+// The TSIG record's fields which aren't the MAC
+type tsigVariables struct {
+	timeSigned uint64
+	fudge      uint16
+	originalId uint16
+	err        uint16
+	other      []byte
+}
+
+// The MAC over a message without its TSIG record, preceded by the request's
+// MAC if it's a response
+func (k *TsigKey) mac(priorMac, message []byte, v *tsigVariables) []byte {
+	mac := hmac.New(k.hash, k.secret)
+	if priorMac != nil {
+		binary.Write(mac, binary.BigEndian, uint16(len(priorMac)))
+		mac.Write(priorMac)
+	}
+	mac.Write(message)
+	mac.Write(dhcp4.EncodeDomainNames([]string{k.name}))
+	binary.Write(mac, binary.BigEndian, uint16(dnsClassANY))
+	binary.Write(mac, binary.BigEndian, uint32(0))
+	mac.Write(dhcp4.EncodeDomainNames([]string{k.algorithm}))
+	mac.Write(timeSigned48(v.timeSigned))
+	binary.Write(mac, binary.BigEndian, v.fudge)
+	binary.Write(mac, binary.BigEndian, v.err)
+	binary.Write(mac, binary.BigEndian, uint16(len(v.other)))
+	mac.Write(v.other)
+	return mac.Sum(nil)
+}
+
+func timeSigned48(t uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, t)[2:]
+}
+
+// Append a TSIG record to a message, returning it and the MAC, which the
+// response's covers
+func (k *TsigKey) sign(message, priorMac []byte, now time.Time) ([]byte, []byte) {
+	v := &tsigVariables{
+		timeSigned: uint64(now.Unix()),
+		fudge:      tsigFudge,
+		originalId: binary.BigEndian.Uint16(message),
+	}
+	mac := k.mac(priorMac, message, v)
+
+	rdata := dhcp4.EncodeDomainNames([]string{k.algorithm})
+	rdata = append(rdata, timeSigned48(v.timeSigned)...)
+	rdata = binary.BigEndian.AppendUint16(rdata, v.fudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = binary.BigEndian.AppendUint16(rdata, v.originalId)
+	rdata = binary.BigEndian.AppendUint16(rdata, v.err)
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+
+	rr := dnsRR{name: k.name, rtype: dnsTypeTSIG, class: dnsClassANY, data: rdata}
+	signed := rr.appendTo(append([]byte(nil), message...))
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, mac
+}
+
+// Where the name starting at i ends
+func skipDnsName(message []byte, i int) (int, error) {
+	for i < len(message) {
+		n := int(message[i])
+		switch {
+		case n == 0:
+			return i + 1, nil
+		case n&0xc0 == 0xc0:
+			return i + 2, nil
+		}
+		i += 1 + n
+	}
+	return 0, errors.New("Truncated name in DNS message")
+}
+
+// Check the TSIG record ending a message was made with our key, over the
+// message and the request's MAC if it's a response
+func (k *TsigKey) verify(message, priorMac []byte, now time.Time) error {
+	if len(message) < 12 {
+		return errors.New("DNS message too short")
+	}
+	counts := make([]int, 4)
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(message[4+2*i:]))
+	}
+	if counts[3] == 0 {
+		return errors.New("DNS response isn't signed")
+	}
+
+	// Skip to the last additional record, which must be the TSIG
+	i, tsigStart := 12, 0
+	var rdata []byte
+	var err error
+	for n := 0; n < counts[0]; n++ {
+		if i, err = skipDnsName(message, i); err != nil {
+			return err
+		}
+		i += 4
+	}
+	for n := 0; n < counts[1]+counts[2]+counts[3]; n++ {
+		tsigStart = i
+		if i, err = skipDnsName(message, i); err != nil {
+			return err
+		}
+		if i+10 > len(message) {
+			return errors.New("Truncated record in DNS message")
+		}
+		rtype := binary.BigEndian.Uint16(message[i:])
+		length := int(binary.BigEndian.Uint16(message[i+8:]))
+		if i+10+length > len(message) {
+			return errors.New("Truncated record in DNS message")
+		}
+		rdata = message[i+10 : i+10+length]
+		i += 10 + length
+		if n == counts[1]+counts[2]+counts[3]-1 && rtype != dnsTypeTSIG {
+			return errors.New("DNS response isn't signed")
+		}
+	}
+
+	// Algorithm, time signed, fudge, MAC, original id, error and other data
+	algorithm := dhcp4.EncodeDomainNames([]string{k.algorithm})
+	if len(rdata) < len(algorithm)+10 || !bytes.EqualFold(rdata[:len(algorithm)], algorithm) {
+		return errors.New("DNS response signed with another algorithm")
+	}
+	rdata = rdata[len(algorithm):]
+	v := &tsigVariables{
+		timeSigned: binary.BigEndian.Uint64(append([]byte{0, 0}, rdata[:6]...)),
+		fudge:      binary.BigEndian.Uint16(rdata[6:]),
+	}
+	macLen := int(binary.BigEndian.Uint16(rdata[8:]))
+	if len(rdata) < 10+macLen+6 {
+		return errors.New("Truncated TSIG record")
+	}
+	mac := rdata[10 : 10+macLen]
+	rdata = rdata[10+macLen:]
+	v.originalId = binary.BigEndian.Uint16(rdata)
+	v.err = binary.BigEndian.Uint16(rdata[2:])
+	otherLen := int(binary.BigEndian.Uint16(rdata[4:]))
+	if len(rdata) < 6+otherLen {
+		return errors.New("Truncated TSIG record")
+	}
+	v.other = rdata[6 : 6+otherLen]
+
+	if v.err != 0 {
+		if name, ok := tsigErrorNames[v.err]; ok {
+			return fmt.Errorf("DNS server rejected our signature: %v", name)
+		}
+		return fmt.Errorf("DNS server rejected our signature: error %v", v.err)
+	}
+
+	// The MAC is over the message as it was before the TSIG was added
+	unsigned := append([]byte(nil), message[:tsigStart]...)
+	binary.BigEndian.PutUint16(unsigned, v.originalId)
+	binary.BigEndian.PutUint16(unsigned[10:], uint16(counts[3]-1))
+	if !hmac.Equal(mac, k.mac(priorMac, unsigned, v)) {
+		return errors.New("DNS response signature doesn't match")
+	}
+	signed := time.Unix(int64(v.timeSigned), 0)
+	if now.Sub(signed).Abs() > time.Duration(v.fudge)*time.Second {
+		return errors.New("DNS response signed too long ago")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTsig(t *testing.T) {
+	key, err := NewTsigKey(&TsigKeyConf{Name: "ddns-key.", Secret: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="})
+	require.Nil(t, err)
+	update := &dnsUpdate{
+		zone:    "example.com",
+		updates: []dnsRR{addRR("a.example.com", dnsTypeA, 300, []byte{10, 0, 0, 1})},
+	}
+	message := update.Bytes(0x1234)
+	signedAt := time.Unix(1700000000, 0)
+	signed, mac := key.sign(message, nil, signedAt)
+	require.Equal(t, "ff2102f11cd78c6c5ffb1b3db28e9dd41713a33625258a723ee5c2dc42d208e7", hex.EncodeToString(mac))
+	require.Equal(t, uint16(1), binary.BigEndian.Uint16(signed[10:]))
+	require.Equal(t, message[12:], signed[12:len(message)])
+
+	// As the DNS server checks it
+	require.Nil(t, key.verify(signed, nil, signedAt.Add(time.Minute)))
+	require.NotNil(t, key.verify(signed, nil, signedAt.Add(time.Hour)))
+	tampered := append([]byte(nil), signed...)
+	tampered[len(message)-1] ^= 1
+	require.NotNil(t, key.verify(tampered, nil, signedAt))
+	other, _ := NewTsigKey(&TsigKeyConf{Name: "ddns-key", Secret: "b3RoZXI="})
+	require.NotNil(t, other.verify(signed, nil, signedAt))
+	require.NotNil(t, key.verify(message, nil, signedAt))
+
+	// Responses cover the request's MAC
+	response := append([]byte{0x12, 0x34, 0xa8, 0}, make([]byte, 8)...)
+	signedResponse, _ := key.sign(response, mac, signedAt)
+	require.Nil(t, key.verify(signedResponse, mac, signedAt))
+	require.NotNil(t, key.verify(signedResponse, nil, signedAt))
+
+	for _, bad := range []*TsigKeyConf{
+		{Secret: "MDEy"},
+		{Name: "k", Secret: "not base64"},
+		{Name: "k", Secret: "MDEy", Algorithm: "hmac-md5"},
+	} {
+		_, err = NewTsigKey(bad)
+		require.NotNil(t, err)
+	}
+}
+
+func TestDdnsTsig(t *testing.T) {
+	conf := &DdnsConf{Keys: []TsigKeyConf{{Zone: "Example.com.", Name: "ddns-key", Secret: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}}
+	key, _ := NewTsigKey(&conf.Keys[0])
+
+	// A DNS server which only accepts signed updates, and signs its answer
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.Nil(t, err)
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			response := append([]byte(nil), buf[:12]...)
+			response[2] |= 0x80
+			binary.BigEndian.PutUint16(response[4:], 0)
+			binary.BigEndian.PutUint16(response[8:], 0)
+			binary.BigEndian.PutUint16(response[10:], 0)
+			if err := key.verify(buf[:n], nil, time.Now()); err != nil {
+				response[3] = 9 // NOTAUTH
+				server.WriteToUDP(response, addr)
+				continue
+			}
+			// The request's MAC, at the end of its TSIG before the
+			// original id, error and other length
+			mac := buf[n-6-sha256Len : n-6]
+			signed, _ := key.sign(response, mac, time.Now())
+			server.WriteToUDP(signed, addr)
+		}
+	}()
+	conf.Server = server.LocalAddr().String()
+
+	u, err := NewDdnsUpdater(conf)
+	require.Nil(t, err)
+	rcode, err := u.send(&dnsUpdate{zone: "example.com"})
+	require.Nil(t, err)
+	require.Equal(t, byte(dnsNoError), rcode)
+
+	// Zones without a key go unsigned, and are refused
+	_, err = u.send(&dnsUpdate{zone: "example.org"})
+	require.Nil(t, err)
+	delete(u.keys, "example.com")
+	rcode, err = u.send(&dnsUpdate{zone: "example.com"})
+	require.Nil(t, err)
+	require.Equal(t, byte(9), rcode)
+
+	conf.Keys = append(conf.Keys, conf.Keys[0])
+	_, err = NewDdnsUpdater(conf)
+	require.NotNil(t, err)
+}
+
+const sha256Len = 32
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Locked-down networks, where only clients we know of get an IP: those
+// with a reservation, or put in a class. Pools set to ignore unknown
+// clients never offer or lease to anyone else; their requests are logged
+// and counted by pool, but go unanswered.
+//
+
+type UnknownClients struct {
+	m       sync.Mutex
+	ignored map[string]uint64
+}
+
+func NewUnknownClients() *UnknownClients {
+	return &UnknownClients{ignored: map[string]uint64{}}
+}
+
+// Set up counting and middleware if any pool ignores unknown clients
+func (a *App) initUnknownClients() {
+	for _, p := range a.pools() {
+		if p.IgnoreUnknown {
+			a.unknown = NewUnknownClients()
+			a.Use(a.unknown.Middleware)
+			return
+		}
+	}
+}
+
+// Whether a request is one we'd allocate an IP for
+func allocates(request *dhcp4.DHCPMessage) bool {
+	switch request.Options.GetByte(dhcp4.OPTION_MESSAGE_TYPE) {
+	case dhcp4.DHCPDISCOVER, dhcp4.DHCPREQUEST:
+		return true
+	case 0:
+		return request.Header.Op == dhcp4.BOOT_REQUEST
+	}
+	return false
+}
+
+// Drop requests for IPs from unknown clients of pools ignoring them. Goes
+// after classifying middleware, so the class is known
+func (u *UnknownClients) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx *RequestContext, request *dhcp4.DHCPMessage) *dhcp4.DHCPMessage {
+		if !ctx.Pool.IgnoreUnknown || ctx.Class != "" || !allocates(request) {
+			return next.ServeDHCP(ctx, request)
+		}
+		mac := request.Header.Hardware()
+		if _, ok := ctx.Pool.GetReservedHost(leaseKey(ctx.Pool, request)); ok {
+			return next.ServeDHCP(ctx, request)
+		}
+		log.Printf("Ignoring unknown client %v of pool %v", mac.String(), ctx.Pool.Name)
+		u.m.Lock()
+		u.ignored[ctx.Pool.Name]++
+		u.m.Unlock()
+		return nil
+	})
+}
+
+// Requests ignored so far, by pool
+func (u *UnknownClients) Counts() map[string]uint64 {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	counts := make(map[string]uint64, len(u.ignored))
+	for name, n := range u.ignored {
+		counts[name] = n
+	}
+	return counts
+}
+
+// Counts in the Prometheus text format
+func (u *UnknownClients) WriteMetrics(w io.Writer) {
+	counts := u.Counts()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# HELP dhcp_unknown_clients_ignored_total Requests from clients with no reservation or class ignored\n# TYPE dhcp_unknown_clients_ignored_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "dhcp_unknown_clients_ignored_total{pool=%q} %v\n", name, counts[name])
+	}
+}
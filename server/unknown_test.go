@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestIgnoreUnknownClients(t *testing.T) {
+	p := newTestPool()
+	p.Name = "locked"
+	p.LeaseTime = time.Hour
+	p.IgnoreUnknown = true
+
+	reserved := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	classified := dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware()
+	unknown := dhcp4.MacAddress{0, 0, 0, 0, 0, 3}.Hardware()
+	require.Nil(t, p.AddReservedHost(&pool.ReservedHost{Mac: reserved, IP: dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15"))}))
+
+	unknowns := NewUnknownClients()
+	handler := Chain(DefaultHandler, ClassifyMiddleware(func(ctx *RequestContext, request *dhcp4.DHCPMessage) string {
+		if request.Header.Hardware().String() == classified.String() {
+			return "trusted"
+		}
+		return ""
+	}), unknowns.Middleware)
+	serve := func(op byte, mac dhcp4.HardwareAddr) *dhcp4.DHCPMessage {
+		return handler.ServeDHCP(&RequestContext{Pool: p}, newTestMessage(op, mac))
+	}
+
+	offer := serve(dhcp4.DHCPDISCOVER, reserved)
+	require.NotNil(t, offer)
+	require.Equal(t, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.15")), offer.Header.YourAddr)
+	require.NotNil(t, serve(dhcp4.DHCPDISCOVER, classified))
+
+	// Anyone else is never offered or leased anything
+	require.Nil(t, serve(dhcp4.DHCPDISCOVER, unknown))
+	request := newTestMessage(dhcp4.DHCPREQUEST, unknown)
+	request.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, dhcp4.IpToFixedV4(net.ParseIP("10.0.0.12")))
+	require.Nil(t, handler.ServeDHCP(&RequestContext{Pool: p}, request))
+	_, ok := p.GetLeaseByMac(unknown)
+	require.False(t, ok)
+	require.Equal(t, map[string]uint64{"locked": 2}, unknowns.Counts())
+
+	var metrics bytes.Buffer
+	unknowns.WriteMetrics(&metrics)
+	require.Contains(t, metrics.String(), "dhcp_unknown_clients_ignored_total{pool=\"locked\"} 2\n")
+
+	// Pools not set to ignore them answer as usual
+	p.IgnoreUnknown = false
+	require.NotNil(t, serve(dhcp4.DHCPDISCOVER, unknown))
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"mygodhcpd/pool"
+)
+
+//
+// Tracking how full each pool is. Every interval we count the IPs in each
+// pool's range which are leased, offered, reserved, abandoned after being
+// declined, or free, and keep the last so many samples for the admin API.
+// A pool going over the high-water mark is warned about once, until it's
+// back under it. Running short of free IPs is alerted about the same way,
+// to webhooks and the event bus as well as the log, so operators can act
+// before clients go without.
+//
+
+type UtilizationSample struct {
+	Time time.Time `json:"time"`
+	pool.Utilization
+}
+
+type utilizationHistory struct {
+	// Ring of samples, next being where the next goes
+	samples []UtilizationSample
+	next    int
+	full    bool
+
+	// Over the high-water mark, and short of free IPs, as of the last
+	// sample
+	high      bool
+	exhausted bool
+	alerts    int
+}
+
+// Oldest first
+func (h *utilizationHistory) list() []UtilizationSample {
+	if !h.full {
+		return append([]UtilizationSample(nil), h.samples[:h.next]...)
+	}
+	return append(append([]UtilizationSample(nil), h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+type UtilizationMonitor struct {
+	interval  time.Duration
+	size      int
+	highWater float64
+	pools     func() []*pool.Pool
+
+	minFree        int
+	minFreePercent float64
+	alerts         []AlertHook
+
+	m       sync.Mutex
+	history map[string]*utilizationHistory
+}
+
+const (
+	defaultUtilizationInterval = time.Minute
+	defaultUtilizationHistory  = 60
+)
+
+func NewUtilizationMonitor(conf *UtilizationConf, pools func() []*pool.Pool) (*UtilizationMonitor, error) {
+	u := &UtilizationMonitor{
+		interval:  defaultUtilizationInterval,
+		size:      defaultUtilizationHistory,
+		highWater: conf.HighWater,
+		pools:     pools,
+		history:   map[string]*utilizationHistory{},
+
+		minFree:        conf.MinFree,
+		minFreePercent: conf.MinFreePercent,
+	}
+	if conf.Interval != 0 {
+		u.interval = time.Duration(conf.Interval) * time.Second
+	}
+	if conf.History < 0 {
+		return nil, fmt.Errorf("Invalid utilization history size %v", conf.History)
+	}
+	if conf.History != 0 {
+		u.size = conf.History
+	}
+	if conf.HighWater < 0 || conf.HighWater > 100 {
+		return nil, fmt.Errorf("Utilization high-water mark %v isn't a percentage", conf.HighWater)
+	}
+	if conf.MinFree < 0 {
+		return nil, fmt.Errorf("Invalid minimum free IPs %v", conf.MinFree)
+	}
+	if conf.MinFreePercent < 0 || conf.MinFreePercent > 100 {
+		return nil, fmt.Errorf("Minimum free IPs %v%% isn't a percentage", conf.MinFreePercent)
+	}
+	return u, nil
+}
+
+func (u *UtilizationMonitor) AddAlertHook(hook AlertHook) {
+	u.alerts = append(u.alerts, hook)
+}
+
+func (u *UtilizationMonitor) Run() {
+	u.Sample()
+	for range time.Tick(u.interval) {
+		u.Sample()
+	}
+}
+
+// Count every pool now, adding to its history
+func (u *UtilizationMonitor) Sample() {
+	now := time.Now()
+	for _, p := range u.pools() {
+		u.add(p.Name, UtilizationSample{now, p.Utilization()})
+	}
+}
+
+func (u *UtilizationMonitor) add(name string, sample UtilizationSample) {
+	if record, ok := u.record(name, sample); ok {
+		u.alert(record)
+	}
+}
+
+// Add a sample, returning the alert to send if it's the first short of
+// free IPs
+func (u *UtilizationMonitor) record(name string, sample UtilizationSample) (EventRecord, bool) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	h, ok := u.history[name]
+	if !ok {
+		h = &utilizationHistory{samples: make([]UtilizationSample, u.size)}
+		u.history[name] = h
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % u.size
+	if h.next == 0 {
+		h.full = true
+	}
+
+	used := sample.Used()
+	switch {
+	case u.highWater == 0:
+	case used >= u.highWater && !h.high:
+		h.high = true
+		log.Printf("Pool %v is %.1f%% used, over the high-water mark of %v%%; %v of %v IPs free", name, used, u.highWater, sample.Free, sample.Total)
+	case used < u.highWater && h.high:
+		h.high = false
+		log.Printf("Pool %v is back under the high-water mark, at %.1f%% used", name, used)
+	}
+
+	exhausted := u.short(sample.Utilization)
+	switch {
+	case exhausted && !h.exhausted:
+		h.exhausted = true
+		h.alerts++
+		return EventRecord{
+			Event:  EVENT_EXHAUSTION,
+			Time:   sample.Time,
+			Pool:   name,
+			Detail: fmt.Sprintf("Only %v of %v IPs free", sample.Free, sample.Total),
+		}, true
+	case !exhausted && h.exhausted:
+		h.exhausted = false
+		log.Printf("Pool %v has enough free IPs again; %v of %v free", name, sample.Free, sample.Total)
+	}
+	return EventRecord{}, false
+}
+
+// Whether a pool is short enough of free IPs to alert about
+func (u *UtilizationMonitor) short(c pool.Utilization) bool {
+	if u.minFree != 0 && c.Free < u.minFree {
+		return true
+	}
+	if u.minFreePercent != 0 && c.Total != 0 && 100*float64(c.Free)/float64(c.Total) < u.minFreePercent {
+		return true
+	}
+	return false
+}
+
+func (u *UtilizationMonitor) alert(record EventRecord) {
+	log.Printf("Pool %v is running out of IPs: %v", record.Pool, record.Detail)
+	for _, hook := range u.alerts {
+		hook(record)
+	}
+}
+
+// Samples for each pool, oldest first
+func (u *UtilizationMonitor) History() map[string][]UtilizationSample {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	history := map[string][]UtilizationSample{}
+	for name, h := range u.history {
+		history[name] = h.list()
+	}
+	return history
+}
+
+// Current counts for every pool in the Prometheus text format
+func (u *UtilizationMonitor) WriteMetrics(w io.Writer) {
+	pools := u.pools()
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	counts := make([]pool.Utilization, len(pools))
+	for i, p := range pools {
+		counts[i] = p.Utilization()
+	}
+
+	metrics := []struct {
+		name  string
+		help  string
+		value func(pool.Utilization) int
+	}{
+		{"dhcp_pool_addresses_total", "IPs in the pool's range", func(c pool.Utilization) int { return c.Total }},
+		{"dhcp_pool_addresses_leased", "IPs leased to clients", func(c pool.Utilization) int { return c.Leased }},
+		{"dhcp_pool_addresses_offered", "IPs offered but not yet requested", func(c pool.Utilization) int { return c.Offered }},
+		{"dhcp_pool_addresses_reserved", "IPs reserved for hosts", func(c pool.Utilization) int { return c.Reserved }},
+		{"dhcp_pool_addresses_abandoned", "IPs declined by clients as in use", func(c pool.Utilization) int { return c.Abandoned }},
+		{"dhcp_pool_addresses_free", "IPs free to hand out", func(c pool.Utilization) int { return c.Free }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v gauge\n", metric.name, metric.help, metric.name)
+		for i, p := range pools {
+			fmt.Fprintf(w, "%v{pool=%q} %v\n", metric.name, p.Name, metric.value(counts[i]))
+		}
+	}
+
+	// As of the last sample, rather than now, to match the alerts sent
+	u.m.Lock()
+	defer u.m.Unlock()
+	fmt.Fprintf(w, "# HELP dhcp_pool_exhausted Whether the pool was short of free IPs when last sampled\n# TYPE dhcp_pool_exhausted gauge\n")
+	for _, p := range pools {
+		exhausted := 0
+		if h, ok := u.history[p.Name]; ok && h.exhausted {
+			exhausted = 1
+		}
+		fmt.Fprintf(w, "dhcp_pool_exhausted{pool=%q} %v\n", p.Name, exhausted)
+	}
+	fmt.Fprintf(w, "# HELP dhcp_pool_exhaustion_alerts_total Alerts sent about the pool running short of free IPs\n# TYPE dhcp_pool_exhaustion_alerts_total counter\n")
+	for _, p := range pools {
+		alerts := 0
+		if h, ok := u.history[p.Name]; ok {
+			alerts = h.alerts
+		}
+		fmt.Fprintf(w, "dhcp_pool_exhaustion_alerts_total{pool=%q} %v\n", p.Name, alerts)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestUtilizationMonitor(t *testing.T) {
+	_, err := NewUtilizationMonitor(&UtilizationConf{HighWater: 101}, nil)
+	require.NotNil(t, err)
+
+	p := newTestPool()
+	p.Name = "test"
+	p.LeaseTime = time.Hour
+	monitor, err := NewUtilizationMonitor(&UtilizationConf{History: 2, HighWater: 10}, func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+
+	monitor.Sample()
+	require.False(t, monitor.history["test"].high)
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	require.Nil(t, err)
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "")
+	require.Nil(t, err)
+	monitor.Sample()
+	require.True(t, monitor.history["test"].high)
+	monitor.Sample()
+
+	// Only the last two are kept, oldest first
+	history := monitor.History()["test"]
+	require.Len(t, history, 2)
+	require.Equal(t, 2, history[0].Leased)
+	require.Equal(t, 9, history[1].Free)
+	require.False(t, history[1].Time.Before(history[0].Time))
+
+	_, ok := p.ReleaseLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	require.True(t, ok)
+	_, ok = p.ReleaseLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware())
+	require.True(t, ok)
+	monitor.Sample()
+	require.False(t, monitor.history["test"].high)
+
+	buf := new(bytes.Buffer)
+	monitor.WriteMetrics(buf)
+	require.Contains(t, buf.String(), "# TYPE dhcp_pool_addresses_free gauge\n")
+	require.Contains(t, buf.String(), "dhcp_pool_addresses_free{pool=\"test\"} 11\n")
+}
+
+func TestAdminUtilization(t *testing.T) {
+	p := newTestPool()
+	p.Name = "test"
+	p.Network = net.ParseIP("127.0.0.0")
+	p.LeaseTime = time.Hour
+	_, err := p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware(), "")
+	require.Nil(t, err)
+
+	app := newTestApp(t, p)
+	app.utilization.Sample()
+	app.counters.Received(newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()))
+	handler := app.AdminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/utilization", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var utilization map[string]adminUtilization
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &utilization))
+	require.Equal(t, pool.Utilization{Total: 11, Leased: 1, Free: 10}, utilization["test"].Utilization)
+	require.Len(t, utilization["test"].History, 1)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+	require.Contains(t, rec.Body.String(), "dhcp_pool_addresses_leased{pool=\"test\"} 1\n")
+	require.Contains(t, rec.Body.String(), "dhcp_messages_received_total{type=\"DHCPDISCOVER\"} 1\n")
+}
+
+func TestDecline(t *testing.T) {
+	p := newTestPool()
+	p.LeaseTime = time.Hour
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	lease, err := p.GetNextLease(mac, "")
+	require.Nil(t, err)
+
+	message := newTestMessage(dhcp4.DHCPDECLINE, mac)
+	message.Options.SetFixedV4s(dhcp4.OPTION_REQUESTED_IP, lease.IP)
+	require.Nil(t, NewRequestHandler(message, &RequestContext{Pool: p}).Handle())
+
+	_, ok := p.GetLeaseByMac(mac)
+	require.False(t, ok)
+	require.Equal(t, 1, p.Utilization().Abandoned)
+
+	offer := NewRequestHandler(newTestMessage(dhcp4.DHCPDISCOVER, mac), &RequestContext{Pool: p}).Handle()
+	require.NotEqual(t, lease.IP, offer.Header.YourAddr)
+}
+
+func TestExhaustionAlerts(t *testing.T) {
+	_, err := NewUtilizationMonitor(&UtilizationConf{MinFree: -1}, nil)
+	require.NotNil(t, err)
+
+	p := newTestPool()
+	p.Name = "test"
+	p.LeaseTime = time.Hour
+	monitor, err := NewUtilizationMonitor(&UtilizationConf{MinFree: 10}, func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+	var alerts []EventRecord
+	monitor.AddAlertHook(func(record EventRecord) {
+		alerts = append(alerts, record)
+	})
+
+	monitor.Sample()
+	require.Empty(t, alerts)
+
+	// Alerted once on going under, until there are enough free again
+	mac := dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware()
+	_, err = p.GetNextLease(mac, "")
+	require.Nil(t, err)
+	_, err = p.GetNextLease(dhcp4.MacAddress{0, 0, 0, 0, 0, 2}.Hardware(), "")
+	require.Nil(t, err)
+	monitor.Sample()
+	monitor.Sample()
+	require.Len(t, alerts, 1)
+	require.Equal(t, EVENT_EXHAUSTION, alerts[0].Event)
+	require.Equal(t, "test", alerts[0].Pool)
+	require.Equal(t, "Only 9 of 11 IPs free", alerts[0].Detail)
+
+	buf := new(bytes.Buffer)
+	monitor.WriteMetrics(buf)
+	require.Contains(t, buf.String(), "dhcp_pool_exhausted{pool=\"test\"} 1\n")
+	require.Contains(t, buf.String(), "dhcp_pool_exhaustion_alerts_total{pool=\"test\"} 1\n")
+
+	_, ok := p.ReleaseLeaseByMac(mac)
+	require.True(t, ok)
+	monitor.Sample()
+	_, err = p.GetNextLease(mac, "")
+	require.Nil(t, err)
+	monitor.Sample()
+	require.Len(t, alerts, 2)
+
+	// Or by percentage
+	monitor, err = NewUtilizationMonitor(&UtilizationConf{MinFreePercent: 90}, func() []*pool.Pool { return []*pool.Pool{p} })
+	require.Nil(t, err)
+	monitor.AddAlertHook(func(record EventRecord) {
+		alerts = append(alerts, record)
+	})
+	monitor.Sample()
+	require.Len(t, alerts, 3)
+}
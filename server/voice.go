@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"mygodhcpd/dhcp4"
+)
+
+//
+// Voice classes, presets for provisioning IP phones of common vendors. A
+// class with a voice preset matches the vendor's phones by their vendor
+// class, unless given its own to match, and sends them the options the
+// vendor's firmware looks for: the TFTP server name and list (66, 150),
+// Avaya's call server string (242) and Mitel's option 43 string. Anything
+// else set on the class overrides the preset's options.
+//
+
+type VoiceConf struct {
+	// One of avaya, cisco, mitel, polycom or yealink
+	Vendor string `yaml:"vendor"`
+
+	// TFTP servers to provision from, as IPs
+	Tftp []string `yaml:"tftp,omitempty"`
+
+	// Provisioning server, given to the phone as is: a URL for polycom and
+	// yealink, or the HTTP server for avaya
+	Provisioning string `yaml:"provisioning,omitempty"`
+
+	// Call server, for avaya and mitel
+	CallServer string `yaml:"callserver,omitempty"`
+
+	// Voice VLAN the phone should tag its traffic with, for avaya and mitel
+	Vlan uint16 `yaml:"vlan,omitempty"`
+}
+
+type voicePreset struct {
+	// Prefixes of the vendor class the vendor's phones send
+	vendorClasses []string
+
+	options func(vc *VoiceConf) ([]dhcp4.CustomOption, error)
+}
+
+var voicePresets = map[string]voicePreset{
+	"avaya":   {[]string{"ccp.avaya.com"}, avayaOptions},
+	"cisco":   {[]string{"Cisco Systems, Inc. IP Phone"}, tftpVoiceOptions},
+	"mitel":   {[]string{"ipphone.mitel.com"}, mitelOptions},
+	"polycom": {[]string{"Polycom-"}, urlVoiceOptions},
+	"yealink": {[]string{"yealink"}, urlVoiceOptions},
+}
+
+func voiceVendors() string {
+	vendors := make([]string, 0, len(voicePresets))
+	for vendor := range voicePresets {
+		vendors = append(vendors, vendor)
+	}
+	sort.Strings(vendors)
+	return strings.Join(vendors, ", ")
+}
+
+// The preset's vendor classes and options
+func (vc *VoiceConf) toPreset() ([]string, []dhcp4.CustomOption, error) {
+	preset, ok := voicePresets[vc.Vendor]
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown voice vendor '%v', expected one of %v", vc.Vendor, voiceVendors())
+	}
+	if vc.CallServer != "" && net.ParseIP(vc.CallServer).To4() == nil {
+		return nil, nil, fmt.Errorf("Invalid call server '%v'", vc.CallServer)
+	}
+	if vc.Vlan > 4094 {
+		return nil, nil, fmt.Errorf("Invalid VLAN %v", vc.Vlan)
+	}
+	options, err := preset.options(vc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Voice vendor %v: %v", vc.Vendor, err)
+	}
+	return preset.vendorClasses, options, nil
+}
+
+// Cisco phones take the TFTP server list, falling back to its name
+func tftpVoiceOptions(vc *VoiceConf) ([]dhcp4.CustomOption, error) {
+	if len(vc.Tftp) == 0 {
+		return nil, errors.New("Needs tftp servers")
+	}
+	return tftpOptions(vc.Tftp)
+}
+
+// Polycom and Yealink phones take a provisioning URL as the TFTP server
+// name, which overrides the first TFTP server
+func urlVoiceOptions(vc *VoiceConf) ([]dhcp4.CustomOption, error) {
+	if vc.Provisioning == "" {
+		return tftpVoiceOptions(vc)
+	}
+	var options []dhcp4.CustomOption
+	if len(vc.Tftp) != 0 {
+		tftp, err := tftpOptions(vc.Tftp)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tftp...)
+	}
+	name, err := dhcp4.NewCustomOption(dhcp4.OPTION_TFTP_NAME, "string", vc.Provisioning)
+	if err != nil {
+		return nil, err
+	}
+	return append(options, name), nil
+}
+
+// Avaya phones take comma separated settings in option 242
+func avayaOptions(vc *VoiceConf) ([]dhcp4.CustomOption, error) {
+	if vc.CallServer == "" {
+		return nil, errors.New("Needs a call server")
+	}
+	settings := []string{"MCIPADD=" + vc.CallServer, "MCPORT=1719"}
+	if vc.Provisioning != "" {
+		settings = append(settings, "HTTPSRVR="+vc.Provisioning)
+	}
+	if len(vc.Tftp) != 0 {
+		settings = append(settings, "TFTPSRVR="+strings.Join(vc.Tftp, ","))
+	}
+	if vc.Vlan != 0 {
+		settings = append(settings, "L2Q=1", fmt.Sprintf("L2QVLAN=%v", vc.Vlan))
+	}
+	option, err := dhcp4.NewCustomOption(dhcp4.OPTION_AVAYA, "string", strings.Join(settings, ","))
+	if err != nil {
+		return nil, err
+	}
+	return []dhcp4.CustomOption{option}, nil
+}
+
+// Mitel phones take semicolon separated settings in option 43, starting
+// with their id
+func mitelOptions(vc *VoiceConf) ([]dhcp4.CustomOption, error) {
+	if vc.CallServer == "" && len(vc.Tftp) == 0 {
+		return nil, errors.New("Needs a call server or tftp servers")
+	}
+	settings := []string{"id:ipphone.mitel.com"}
+	if len(vc.Tftp) != 0 {
+		settings = append(settings, "sw_tftp="+vc.Tftp[0])
+	}
+	if vc.CallServer != "" {
+		settings = append(settings, "call_srv="+vc.CallServer)
+	}
+	if vc.Vlan != 0 {
+		settings = append(settings, fmt.Sprintf("vlan=%v", vc.Vlan), "l2p=6", "dscp=46")
+	}
+	option, err := dhcp4.NewCustomOption(dhcp4.OPTION_VENDOR_INFO, "string", strings.Join(settings, ";")+";")
+	if err != nil {
+		return nil, err
+	}
+	options := []dhcp4.CustomOption{option}
+	if len(vc.Tftp) != 0 {
+		tftp, err := tftpOptions(vc.Tftp)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tftp...)
+	}
+	return options, nil
+}
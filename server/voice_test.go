@@ -0,0 +1,69 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+
+	"mygodhcpd/dhcp4"
+)
+
+func TestVoicePresets(t *testing.T) {
+	p := newTestPool()
+	p.Network = []byte{10, 0, 0, 0}
+	app := NewApp()
+	require.Nil(t, app.insertPool(p))
+	require.Nil(t, app.initClasses([]ClassConf{
+		{Name: "avaya", Voice: &VoiceConf{Vendor: "avaya", CallServer: "10.0.0.5", Provisioning: "10.0.0.6", Vlan: 100}},
+		{Name: "mitel", Voice: &VoiceConf{Vendor: "mitel", CallServer: "10.0.0.5", Tftp: []string{"10.0.0.2"}}},
+		{Name: "polycom", Voice: &VoiceConf{Vendor: "polycom", Provisioning: "http://prov.example/polycom"}},
+		{Name: "lab", VendorClasses: []string{"lab"}, Voice: &VoiceConf{Vendor: "cisco", Tftp: []string{"10.0.0.2", "10.0.0.3"}},
+			Options: []OptionConf{{Code: dhcp4.OPTION_TFTP_NAME, Type: "string", Value: "tftp.example.com"}}},
+	}))
+
+	serve := func(vendorClass string) *dhcp4.DHCPMessage {
+		message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+		message.Options.SetString(dhcp4.OPTION_VENDOR, vendorClass)
+		message.Options.Set(dhcp4.OPTION_PARAM_REQ, []byte{dhcp4.OPTION_VENDOR_INFO, dhcp4.OPTION_TFTP_NAME, dhcp4.OPTION_TFTP_SERVERS, dhcp4.OPTION_AVAYA})
+		ctx := NewRequestContext("eth0", nil)
+		ctx.Populate(message)
+		ctx.Pool = p
+		response := app.serve.ServeDHCP(ctx, message)
+		require.NotNil(t, response)
+		return response
+	}
+
+	response := serve("ccp.avaya.com")
+	avaya, _ := response.Options.GetString(dhcp4.OPTION_AVAYA)
+	require.Equal(t, "MCIPADD=10.0.0.5,MCPORT=1719,HTTPSRVR=10.0.0.6,L2Q=1,L2QVLAN=100", avaya)
+
+	response = serve("ipphone.mitel.com")
+	mitel, _ := response.Options.GetString(dhcp4.OPTION_VENDOR_INFO)
+	require.Equal(t, "id:ipphone.mitel.com;sw_tftp=10.0.0.2;call_srv=10.0.0.5;", mitel)
+	require.Len(t, response.Options.GetFixedV4s(dhcp4.OPTION_TFTP_SERVERS), 1)
+
+	response = serve("Polycom-VVX411")
+	name, _ := response.Options.GetString(dhcp4.OPTION_TFTP_NAME)
+	require.Equal(t, "http://prov.example/polycom", name)
+
+	// Its own vendor classes replace the preset's, and its own options
+	// override the preset's
+	response = serve("lab phone")
+	name, _ = response.Options.GetString(dhcp4.OPTION_TFTP_NAME)
+	require.Equal(t, "tftp.example.com", name)
+	require.Len(t, response.Options.GetFixedV4s(dhcp4.OPTION_TFTP_SERVERS), 2)
+	response = serve("Cisco Systems, Inc. IP Phone CP-7841")
+	_, ok := response.Options.Get(dhcp4.OPTION_TFTP_SERVERS)
+	require.False(t, ok)
+
+	for _, bad := range []*VoiceConf{
+		{Vendor: "acme", Tftp: []string{"10.0.0.2"}},
+		{Vendor: "cisco"},
+		{Vendor: "avaya"},
+		{Vendor: "avaya", CallServer: "pbx.example"},
+		{Vendor: "mitel", CallServer: "10.0.0.5", Vlan: 4095},
+	} {
+		_, err := (&ClassConf{Name: "bad", Voice: bad}).ToClass()
+		require.NotNil(t, err)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+//
+// POST lease events as json to a URL, for integrating with NAC, inventory
+// or chat systems. Failed deliveries are retried with exponential backoff,
+// one event at a time so they arrive in order.
+//
+
+type Webhook struct {
+	url     string
+	events  map[string]bool
+	headers map[string]string
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	queue   chan EventRecord
+}
+
+func NewWebhook(conf *WebhookConf) (*Webhook, error) {
+	if conf.Url == "" {
+		return nil, errors.New("Webhook needs a URL")
+	}
+
+	w := &Webhook{
+		url:     conf.Url,
+		headers: conf.Headers,
+		retries: 5,
+		backoff: time.Second,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan EventRecord, 1024),
+	}
+	if conf.Retries != nil {
+		w.retries = *conf.Retries
+	}
+
+	if len(conf.Events) != 0 {
+		w.events = map[string]bool{}
+		for _, event := range conf.Events {
+			if !validEventName(event) {
+				return nil, fmt.Errorf("Unknown event '%v' for webhook", event)
+			}
+			w.events[event] = true
+		}
+	}
+
+	return w, nil
+}
+
+func (w *Webhook) enqueue(event EventRecord) {
+	if w.events != nil && !w.events[event.Event] {
+		return
+	}
+	select {
+	case w.queue <- event:
+	default:
+		log.Printf("Webhook queue for %v full; dropping %v event for %v", w.url, event.Event, event.IP)
+	}
+}
+
+// Lease observer
+func (w *Webhook) Observe(event pool.LeaseEvent) {
+	w.enqueue(NewLeaseEventRecord(event))
+}
+
+// Request hook, for offers and acks
+func (w *Webhook) Hook(ctx *RequestContext, request, response *dhcp4.DHCPMessage) {
+	if record, ok := NewResponseEventRecord(ctx, request, response); ok {
+		w.enqueue(record)
+	}
+}
+
+func (w *Webhook) Run() {
+	for event := range w.queue {
+		w.deliver(event)
+	}
+}
+
+func (w *Webhook) deliver(event EventRecord) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed encoding webhook event: %v", err)
+		return
+	}
+
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(payload)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= w.retries {
+			log.Printf("Giving up delivering %v event for %v to %v: %v", event.Event, event.IP, w.url, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Returns whether a failure is worth retrying
+func (w *Webhook) post(payload []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("Got %v", resp.Status)
+	}
+	return false, fmt.Errorf("Got %v", resp.Status)
+}
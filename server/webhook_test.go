@@ -0,0 +1,78 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"mygodhcpd/dhcp4"
+	"mygodhcpd/pool"
+)
+
+func TestWebhook(t *testing.T) {
+	var m sync.Mutex
+	var received []EventRecord
+	failures := 1
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+
+		require.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+
+		// Fail the first delivery, which should be retried
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event := EventRecord{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&event))
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	_, err := NewWebhook(&WebhookConf{Url: server.URL, Events: []string{"committed"}})
+	require.NotNil(t, err)
+
+	webhook, err := NewWebhook(&WebhookConf{
+		Url:     server.URL,
+		Events:  []string{EVENT_OFFERED, pool.LEASE_RELEASED},
+		Headers: map[string]string{"Authorization": "Bearer abc"},
+	})
+	require.Nil(t, err)
+	webhook.backoff = time.Millisecond
+	go webhook.Run()
+
+	p := newTestPool()
+	p.Name = "test"
+	p.LeaseTime = time.Hour
+	p.AddObserver(webhook.Observe)
+
+	ctx := &RequestContext{Pool: p}
+	message := newTestMessage(dhcp4.DHCPDISCOVER, dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	message.Options.SetString(dhcp4.OPTION_HOST_NAME, "host1")
+	response := NewRequestHandler(message, ctx).Handle()
+	webhook.Hook(ctx, message, response)
+
+	_, ok := p.ReleaseLeaseByMac(dhcp4.MacAddress{0, 0, 0, 0, 0, 1}.Hardware())
+	require.True(t, ok)
+
+	require.Eventually(t, func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return len(received) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, EVENT_OFFERED, received[0].Event)
+	require.Equal(t, "10.0.0.10", received[0].IP)
+	require.Equal(t, "0:0:0:0:0:1", received[0].Mac)
+	require.Equal(t, "host1", received[0].Hostname)
+	require.Equal(t, "test", received[0].Pool)
+	require.Equal(t, pool.LEASE_RELEASED, received[1].Event)
+}
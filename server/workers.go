@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// Requests are handled by a fixed number of workers, taking packets from a
+// bounded queue. Under a flood the queue fills up and packets are dropped
+// rather than piling up in ever more goroutines, so memory use stays flat
+// and the requests we do answer are still answered quickly. Clients resend
+// anything we drop.
+//
+
+const (
+	DROP_NEWEST = "newest"
+	DROP_OLDEST = "oldest"
+
+	defaultWorkerQueue = 1024
+
+	// Log every this many drops, rather than flooding the log too
+	dropLogInterval = 1000
+)
+
+// A received packet waiting to be handled
+type packet struct {
+	buffers *packetBuffers
+	n, nn   int
+	remote  *net.UDPAddr
+	socket  PacketConn
+}
+
+type WorkerStats struct {
+	Workers  int    `json:"workers"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+type WorkerPool struct {
+	count      int
+	dropOldest bool
+	queue      chan packet
+	dropped    atomic.Uint64
+	workers    sync.WaitGroup
+}
+
+func NewWorkerPool(conf *WorkerConf) (*WorkerPool, error) {
+	w := &WorkerPool{count: conf.Count}
+
+	// Handling mostly waits on lease persistence, so a few per core
+	if w.count == 0 {
+		w.count = 4 * runtime.GOMAXPROCS(0)
+	}
+	if w.count < 0 {
+		return nil, fmt.Errorf("Invalid worker count %v", conf.Count)
+	}
+
+	size := conf.Queue
+	if size == 0 {
+		size = defaultWorkerQueue
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("Invalid worker queue size %v", conf.Queue)
+	}
+	w.queue = make(chan packet, size)
+
+	switch conf.Drop {
+	case "", DROP_NEWEST:
+	case DROP_OLDEST:
+		w.dropOldest = true
+	default:
+		return nil, fmt.Errorf("Unknown drop policy '%v'; must be %v or %v", conf.Drop, DROP_NEWEST, DROP_OLDEST)
+	}
+	return w, nil
+}
+
+// Start the workers, each handling packets until Close is called
+func (w *WorkerPool) Start(handle func(p packet)) {
+	for i := 0; i < w.count; i++ {
+		w.workers.Add(1)
+		go func() {
+			defer w.workers.Done()
+			for p := range w.queue {
+				handle(p)
+				packetPool.Put(p.buffers)
+			}
+		}()
+	}
+}
+
+// Queue a packet to be handled, dropping one if the queue is full. Returns
+// whether anything was dropped
+func (w *WorkerPool) Submit(p packet) bool {
+	select {
+	case w.queue <- p:
+		return false
+	default:
+	}
+
+	if !w.dropOldest {
+		w.drop(p)
+		return true
+	}
+
+	// Make room by dropping whatever has been waiting longest, which its
+	// client may well have already given up on
+	for {
+		select {
+		case old := <-w.queue:
+			w.drop(old)
+		default:
+		}
+		select {
+		case w.queue <- p:
+			return true
+		default:
+		}
+	}
+}
+
+func (w *WorkerPool) drop(p packet) {
+	packetPool.Put(p.buffers)
+	if dropped := w.dropped.Add(1); dropped%dropLogInterval == 1 {
+		log.Printf("Request queue full; dropped %v packets so far", dropped)
+	}
+}
+
+// Handle everything already queued and stop the workers, giving up after
+// timeout. Submit must not be called again
+func (w *WorkerPool) Close(timeout time.Duration) error {
+	close(w.queue)
+
+	done := make(chan struct{})
+	go func() {
+		w.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("Timed out waiting for requests being handled")
+	}
+}
+
+func (w *WorkerPool) Stats() WorkerStats {
+	return WorkerStats{
+		Workers:  w.count,
+		Queued:   len(w.queue),
+		Capacity: cap(w.queue),
+		Dropped:  w.dropped.Load(),
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+	"time"
+)
+
+func queuedPacket(n int) packet {
+	return packet{buffers: packetPool.Get().(*packetBuffers), n: n}
+}
+
+func TestWorkerPool(t *testing.T) {
+	_, err := NewWorkerPool(&WorkerConf{Drop: "random"})
+	require.NotNil(t, err)
+	_, err = NewWorkerPool(&WorkerConf{Queue: -1})
+	require.NotNil(t, err)
+
+	// Not yet started, so the queue just fills up
+	workers, err := NewWorkerPool(&WorkerConf{Count: 1, Queue: 2})
+	require.Nil(t, err)
+	require.False(t, workers.Submit(queuedPacket(1)))
+	require.False(t, workers.Submit(queuedPacket(2)))
+	require.True(t, workers.Submit(queuedPacket(3)))
+	require.Equal(t, WorkerStats{Workers: 1, Queued: 2, Capacity: 2, Dropped: 1}, workers.Stats())
+
+	// Everything queued is handled before closing
+	var handled []int
+	workers.Start(func(p packet) {
+		handled = append(handled, p.n)
+	})
+	require.Nil(t, workers.Close(time.Second))
+	require.Equal(t, []int{1, 2}, handled)
+}
+
+func TestWorkerPoolDropOldest(t *testing.T) {
+	workers, err := NewWorkerPool(&WorkerConf{Count: 1, Queue: 2, Drop: DROP_OLDEST})
+	require.Nil(t, err)
+	require.False(t, workers.Submit(queuedPacket(1)))
+	require.False(t, workers.Submit(queuedPacket(2)))
+	require.True(t, workers.Submit(queuedPacket(3)))
+	require.Equal(t, uint64(1), workers.Stats().Dropped)
+
+	var handled []int
+	workers.Start(func(p packet) {
+		handled = append(handled, p.n)
+	})
+	require.Nil(t, workers.Close(time.Second))
+	require.Equal(t, []int{2, 3}, handled)
+}